@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestAccess(t *testing.T) {
+	useTestQueue(t)
+	other := fuse.NewContext(context.Background(), &fuse.Caller{Owner: fuse.Owner{Uid: 54321, Gid: 54321}})
+
+	tests := []struct {
+		mode uint32
+		mask uint32
+		want syscall.Errno
+	}{
+		{0644, lib.R_OK, 0},
+		{0640, lib.R_OK, syscall.EACCES},
+		{0646, lib.W_OK, 0},
+		{0644, lib.W_OK, syscall.EACCES},
+		{0755, lib.X_OK, 0},
+		{0754, lib.X_OK, syscall.EACCES},
+		{0757, lib.R_OK | lib.W_OK | lib.X_OK, 0},
+		{0755, lib.R_OK | lib.W_OK, syscall.EACCES},
+	}
+	for i, test := range tests {
+		path := filepath.Join(realpath, "file"+strconv.Itoa(i))
+		err := os.WriteFile(path, nil, 0600)
+		if err == nil {
+			err = os.Chmod(path, os.FileMode(test.mode))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (&Node{path: path}).Access(other, test.mask); got != test.want {
+			t.Errorf("access %o to mode %o = %v; want %v", test.mask, test.mode, got, test.want)
+		}
+	}
+
+	missing := &Node{path: filepath.Join(realpath, "missing")}
+	if got := missing.Access(other, lib.R_OK); got != syscall.ENOENT {
+		t.Errorf("access to a missing file = %v; want ENOENT", got)
+	}
+
+	// Pull-only mounts never take writes
+	old := syncDirection
+	syncDirection = SYNC_PULL
+	t.Cleanup(func() { syncDirection = old })
+	writable := &Node{path: filepath.Join(realpath, "file2")}
+	if got := writable.Access(other, lib.W_OK); got != syscall.EROFS {
+		t.Errorf("write access on a pull-only mount = %v; want EROFS", got)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+var (
+	archivePath    string
+	archiveOut     string
+	archiveGzip    bool
+	archiveExtract bool
+)
+
+// Downloads a remote directory in one go as a tar. It is saved as is
+// or, with -extract, unpacked into -out as it arrives
+func runArchive() {
+	err := preflight(remote)
+	if err != nil {
+		log.Fatalf("Pre-flight check failed; %v\n", err)
+	}
+	err = authenticate()
+	if err != nil {
+		log.Fatalf("Error authenticating with remote; %v\n", err)
+	}
+	if !remoteSupports(lib.FEATURE_ARCHIVE) {
+		log.Fatalln("Remote does not support downloading directories as archives")
+	}
+
+	ctx, cancel := context.WithCancel(NewAuthenticatedCtx(context.Background()))
+	defer cancel()
+
+	stream, err := grpcClient.DownloadArchive(ctx, &proto.ArchiveRequest{
+		Path: archivePath,
+		Gzip: archiveGzip,
+	})
+	if err != nil {
+		log.Fatalf("Error downloading archive; %v\n", err)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		for {
+			chunk, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				writer.CloseWithError(err)
+				return
+			}
+			_, err = writer.Write(chunk.Data)
+			if err != nil {
+				// The reader gave up
+				cancel()
+				return
+			}
+		}
+	}()
+
+	if archiveExtract {
+		err = extractArchive(reader, archiveOut, archiveGzip)
+		reader.CloseWithError(err)
+		if err != nil {
+			log.Fatalf("Error extracting archive; %v\n", err)
+		}
+		log.Printf("Extracted %v into %v\n", archivePath, archiveOut)
+		return
+	}
+
+	out := archiveOut
+	if dirExists(out) {
+		name := filepath.Base(filepath.Clean("/" + archivePath))
+		if name == "/" {
+			name = "fusion"
+		}
+		out = filepath.Join(out, name+".tar")
+		if archiveGzip {
+			out += ".gz"
+		}
+	}
+	err = saveArchive(reader, out)
+	reader.CloseWithError(err)
+	if err != nil {
+		log.Fatalf("Error saving archive; %v\n", err)
+	}
+	log.Printf("Saved %v to %v\n", archivePath, out)
+}
+
+func saveArchive(r io.Reader, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// Unpacks a tar read from r into dir. Entries that would land outside
+// dir, including through symlinks pointing out of it, are refused
+func extractArchive(r io.Reader, dir string, compressed bool) error {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive entry %q leaves %v", header.Name, dir)
+		}
+		if throughSymlink(dir, name) {
+			return fmt.Errorf("archive entry %q is below a symlink", header.Name)
+		}
+		target := filepath.Join(dir, name)
+		mode := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+			if err == nil {
+				err = os.Chmod(target, mode|0700)
+			}
+
+		case tar.TypeReg:
+			err = extractFile(tr, target, mode)
+
+		case tar.TypeSymlink:
+			// Writing through a symlink pointing out of dir would
+			// escape it
+			resolved := filepath.Join(filepath.Dir(name), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || !filepath.IsLocal(resolved) {
+				log.Printf("Skipping symlink %v -> %v; points outside %v\n", name, header.Linkname, dir)
+				continue
+			}
+			os.Remove(target)
+			err = os.Symlink(header.Linkname, target)
+
+		default:
+			log.Printf("Skipping %v; unsupported entry type %q\n", name, header.Typeflag)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%v; %v", strings.TrimPrefix(target, dir), err)
+		}
+	}
+}
+
+// Reports whether a directory on the way from dir to name, relative
+// to dir, is a symlink
+func throughSymlink(dir, name string) bool {
+	path := dir
+	parts := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Chmod(mode)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type archiveEntry struct {
+	name, link string
+	mode       int64
+	typeflag   byte
+}
+
+// Builds a tar, gzipped if compressed, of entries. Files hold their
+// own name
+func buildArchive(t *testing.T, entries []archiveEntry, compressed bool) *bytes.Buffer {
+	t.Helper()
+	data := &bytes.Buffer{}
+	var gz *gzip.Writer
+	tw := tar.NewWriter(data)
+	if compressed {
+		gz = gzip.NewWriter(data)
+		tw = tar.NewWriter(gz)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Linkname: entry.link, Mode: entry.mode, Typeflag: entry.typeflag}
+		if entry.typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.name))
+		}
+		err := tw.WriteHeader(header)
+		if err == nil && entry.typeflag == tar.TypeReg {
+			_, err = tw.Write([]byte(entry.name))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	if gz != nil {
+		gz.Close()
+	}
+	return data
+}
+
+// Extracting gives back every file with its mode
+func TestExtractArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "tree/", mode: 0755, typeflag: tar.TypeDir},
+		{name: "tree/notes", mode: 0640, typeflag: tar.TypeReg},
+		{name: "tree/run.sh", mode: 0755, typeflag: tar.TypeReg},
+		{name: "tree/private/", mode: 0700, typeflag: tar.TypeDir},
+		{name: "tree/private/key", mode: 0600, typeflag: tar.TypeReg},
+		{name: "tree/link", link: "notes", mode: 0777, typeflag: tar.TypeSymlink},
+	}
+	for _, compressed := range []bool{false, true} {
+		dir := t.TempDir()
+		err := extractArchive(buildArchive(t, entries, compressed), dir, compressed)
+		if err != nil {
+			t.Fatalf("extractArchive (gzip %v) = %v", compressed, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.name)
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Errorf("%v not extracted; %v", entry.name, err)
+				continue
+			}
+			switch entry.typeflag {
+			case tar.TypeReg:
+				data, _ := os.ReadFile(path)
+				if info.Mode().Perm() != os.FileMode(entry.mode) || string(data) != entry.name {
+					t.Errorf("%v = %v %q; want %v %q", entry.name, info.Mode(), data, os.FileMode(entry.mode), entry.name)
+				}
+			case tar.TypeDir:
+				if !info.IsDir() || info.Mode().Perm() != os.FileMode(entry.mode) {
+					t.Errorf("%v = %v; want a %v directory", entry.name, info.Mode(), os.FileMode(entry.mode))
+				}
+			case tar.TypeSymlink:
+				if target, _ := os.Readlink(path); target != entry.link {
+					t.Errorf("%v -> %q; want %q", entry.name, target, entry.link)
+				}
+			}
+		}
+	}
+}
+
+// Entries can't land outside the directory, whether by name or
+// through a symlink
+func TestExtractArchiveStaysInDir(t *testing.T) {
+	tests := map[string][]archiveEntry{
+		"parent":   {{name: "../escaped", mode: 0644, typeflag: tar.TypeReg}},
+		"absolute": {{name: "/tmp/escaped", mode: 0644, typeflag: tar.TypeReg}},
+		"symlink": {
+			{name: "out", link: "..", mode: 0777, typeflag: tar.TypeSymlink},
+			{name: "out/escaped", mode: 0644, typeflag: tar.TypeReg},
+		},
+	}
+	for name, entries := range tests {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "dir")
+		err := extractArchive(buildArchive(t, entries, false), dir, false)
+		if name != "symlink" && err == nil {
+			t.Errorf("%v: extracted %v", name, entries[0].name)
+		}
+		if _, err := os.Lstat(filepath.Join(parent, "escaped")); err == nil {
+			t.Errorf("%v: file written outside the directory", name)
+		}
+		if info, err := os.Lstat(filepath.Join(dir, "out")); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("%v: symlink out of the directory extracted", name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var (
+	// Version and features remote reported after we last logged in
+	remoteVersion  string
+	remoteFeatures = map[string]bool{}
+	remoteInfoMu   = sync.RWMutex{}
+
+	// Files remote doesn't broadcast changes to; see remoteTempFile
+	remoteTempRules *lib.Ignore
+)
+
+func init() {
+	registerStatus("remote_version", func() any {
+		remoteInfoMu.RLock()
+		defer remoteInfoMu.RUnlock()
+		return remoteVersion
+	})
+}
+
+// Asks remote which optional features it supports. Servers from
+// before Hello existed are taken to support none of them
+func fetchCapabilities() error {
+	ctx := NewAuthenticatedCtx(context.Background())
+	info, err := grpcClient.Hello(ctx, &emptypb.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		log.Printf("[SYNC] Remote predates version %v; falling back to basic sync\n", lib.VERSION)
+
+		remoteInfoMu.Lock()
+		remoteVersion = "unknown"
+		remoteFeatures = map[string]bool{}
+		remoteInfoMu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	features := map[string]bool{}
+	for _, feature := range info.Features {
+		features[feature] = true
+	}
+	for _, feature := range lib.FEATURES {
+		if !features[feature] {
+			log.Printf("[SYNC] Remote version %v does not support %v\n", info.Version, feature)
+		}
+	}
+
+	remoteInfoMu.Lock()
+	remoteVersion = info.Version
+	remoteFeatures = features
+	remoteTempRules = lib.ParseIgnore([]byte(info.TempPatterns))
+	remoteInfoMu.Unlock()
+	return nil
+}
+
+// Reports whether remote treats path as a temp file. Remote doesn't
+// send events for those; events for them that reach us anyway, eg.
+// from an older server, are dropped the same way
+func remoteTempFile(path string, mode uint32) bool {
+	if path == "" {
+		return false
+	}
+	remoteInfoMu.RLock()
+	defer remoteInfoMu.RUnlock()
+	return remoteTempRules.Match(path, os.FileMode(mode).IsDir())
+}
+
+// Reports whether remote supports feature
+func remoteSupports(feature string) bool {
+	remoteInfoMu.RLock()
+	defer remoteInfoMu.RUnlock()
+	return remoteFeatures[feature]
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// A remote reporting the features it was given. With none it predates
+// Hello and has no manifests either
+type helloServer struct {
+	proto.UnimplementedFuseServer
+	features     []string
+	tempPatterns string
+}
+
+func (s helloServer) Hello(ctx context.Context, _ *emptypb.Empty) (*proto.ServerInfo, error) {
+	if s.features == nil {
+		return nil, status.Error(codes.Unimplemented, "unknown method Hello")
+	}
+	return &proto.ServerInfo{Version: lib.VERSION, Features: s.features, TempPatterns: s.tempPatterns}, nil
+}
+
+func (helloServer) ReadDirAll(ctx context.Context, req *proto.DirEntry) (*proto.ReadDirAllResponse, error) {
+	return &proto.ReadDirAllResponse{Entries: []*proto.DirEntry{
+		{Path: "/file", Mode: 0644, Attr: &proto.FileAttr{Size: 4}},
+	}}, nil
+}
+
+func (helloServer) GetManifest(req *proto.ManifestRequest, stream proto.Fuse_GetManifestServer) error {
+	return stream.Send(&proto.ManifestEntry{Path: "/file", Mode: 0644, Size: 4, Hash: hashOf("file")})
+}
+
+// Asks srv for its capabilities, restoring ours after the test
+func useTestHello(t *testing.T, srv helloServer) {
+	t.Helper()
+	useRemoteFeatures(t)
+	remoteInfoMu.Lock()
+	oldVersion, oldRules := remoteVersion, remoteTempRules
+	remoteInfoMu.Unlock()
+	t.Cleanup(func() {
+		remoteInfoMu.Lock()
+		remoteVersion, remoteTempRules = oldVersion, oldRules
+		remoteInfoMu.Unlock()
+	})
+	useTestRemote(t, srv)
+
+	err := fetchCapabilities()
+	if err != nil {
+		t.Fatalf("fetchCapabilities = %v", err)
+	}
+}
+
+// Returns the entries remoteEntries lists for the root
+func listedEntries(t *testing.T) []*proto.ManifestEntry {
+	t.Helper()
+	next, err := remoteEntries(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []*proto.ManifestEntry{}
+	for {
+		entry, err := next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Features remote lists are used; the rest are left alone
+func TestCapabilitiesReported(t *testing.T) {
+	useTestHello(t, helloServer{features: []string{lib.FEATURE_MANIFEST, lib.FEATURE_COPY}})
+
+	for feature, want := range map[string]bool{
+		lib.FEATURE_MANIFEST: true,
+		lib.FEATURE_COPY:     true,
+		lib.FEATURE_STATFS:   false,
+		lib.FEATURE_UPLOAD:   false,
+	} {
+		if got := remoteSupports(feature); got != want {
+			t.Errorf("remoteSupports(%v) = %v; want %v", feature, got, want)
+		}
+	}
+	entries := listedEntries(t)
+	if len(entries) != 1 || entries[0].Hash != hashOf("file") {
+		t.Errorf("entries = %v; want the manifest's hashed entry", entries)
+	}
+}
+
+// A remote from before Hello supports nothing optional. Syncs list it
+// with ReadDirAll, and a resync, which needs hashes, is refused
+func TestCapabilitiesOfOldRemote(t *testing.T) {
+	useTestHello(t, helloServer{})
+
+	for _, feature := range lib.FEATURES {
+		if remoteSupports(feature) {
+			t.Errorf("remote from before Hello supports %v", feature)
+		}
+	}
+	if remoteVersion != "unknown" {
+		t.Errorf("version = %q; want unknown", remoteVersion)
+	}
+	entries := listedEntries(t)
+	if len(entries) != 1 || entries[0].Path != "/file" || entries[0].Size != 4 || entries[0].Hash != "" {
+		t.Errorf("entries = %v; want the unhashed listing", entries)
+	}
+	if _, err := remoteManifest(context.Background()); err == nil {
+		t.Error("resync manifest of a remote without manifests succeeded")
+	}
+}
+
+// Events for files remote calls temp files are dropped, even from a
+// remote that sends them anyway
+func TestRemoteTempFileEventsDropped(t *testing.T) {
+	useTestQueue(t)
+	useRemoteDelete(t, REMOTE_DELETE_REMOVE, false)
+	useTestHello(t, helloServer{features: []string{}, tempPatterns: "*.bak\n"})
+
+	for path, want := range map[string]bool{"/report.bak": true, "/report.txt": false} {
+		deletedOnRemote(t, path, "contents")
+		_, err := os.Lstat(localPath(path))
+		if got := err == nil; got != want {
+			t.Errorf("%v kept after delete event = %v; want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Unix socket the running client listens on so that commands like
+// status can talk to it
+var controlSocket = filepath.Join(lib.ProjectDir, "client.sock")
+
+var (
+	// Functions reporting the state of each part of the client.
+	// Keyed by the name they show up as in the status report
+	statusFields   = make(map[string]func() any)
+	statusFieldsMu = sync.Mutex{}
+)
+
+// Adds a field to the report returned by the status command
+func registerStatus(name string, fn func() any) {
+	statusFieldsMu.Lock()
+	defer statusFieldsMu.Unlock()
+
+	statusFields[name] = fn
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	statusFieldsMu.Lock()
+	report := make(map[string]any, len(statusFields))
+	for name, fn := range statusFields {
+		report[name] = fn()
+	}
+	statusFieldsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Serves the control API on controlSocket.
+// Should be run as a goroutine
+func startControlServer() {
+	// Remove socket left behind by a previous run
+	os.Remove(controlSocket)
+
+	listener, err := net.Listen("unix", controlSocket)
+	if err != nil {
+		log.Printf("Error starting control server; %v\n", err)
+		return
+	}
+	defer listener.Close()
+
+	// Only the user running the client may talk to it
+	os.Chmod(controlSocket, 0600)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", statusHandler)
+	mux.HandleFunc("POST /cancel", cancelHandler)
+
+	err = http.Serve(listener, mux)
+	if err != nil {
+		log.Printf("Error running control server; %v\n", err)
+	}
+}
+
+// Returns a HTTP client that talks to the running client over
+// controlSocket
+func controlClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", controlSocket)
+			},
+		},
+	}
+}
+
+// Prints the status report of the running client
+func printStatus() {
+	resp, err := controlClient().Get("http://fusion/status")
+	if err != nil {
+		pid, running := runningPid()
+		if running {
+			log.Fatalf("Client with PID %v is running but not responding; %v\n", pid, err)
+		}
+		log.Fatalf("Error contacting running client; is it running? %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Error reading status; %v\n", err)
+	}
+
+	var report map[string]any
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		log.Fatalf("Error reading status; %v\n", err)
+	}
+
+	pretty, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(pretty))
+}
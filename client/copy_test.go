@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Records Copy calls and counts the bytes written to remote
+type copyServer struct {
+	proto.UnimplementedFuseServer
+
+	mu      sync.Mutex
+	copies  []*proto.CopyRequest
+	written int
+}
+
+func (s *copyServer) Copy(ctx context.Context, req *proto.CopyRequest) (*proto.DirEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.copies = append(s.copies, req)
+	return &proto.DirEntry{Path: req.DstPath}, nil
+}
+
+func (s *copyServer) Write(ctx context.Context, req *proto.WriteRequest) (*proto.WriteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written += len(req.Data)
+	return &proto.WriteResponse{BytesWritten: uint64(len(req.Data)), Offset: req.Offset}, nil
+}
+
+// Pretends remote supports features for the rest of the test
+func useRemoteFeatures(t testing.TB, features ...string) {
+	remoteInfoMu.Lock()
+	old := remoteFeatures
+	remoteFeatures = make(map[string]bool)
+	for _, feature := range features {
+		remoteFeatures[feature] = true
+	}
+	remoteInfoMu.Unlock()
+	t.Cleanup(func() {
+		remoteInfoMu.Lock()
+		remoteFeatures = old
+		remoteInfoMu.Unlock()
+	})
+}
+
+// Opens name under realpath and returns its handle
+func openHandle(t *testing.T, name string, flags int) *FileHandle {
+	t.Helper()
+	path := filepath.Join(realpath, name)
+	fd, err := syscall.Open(path, flags, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	return NewLoopbackFile(fd, path, uint32(flags)).(*FileHandle)
+}
+
+// Copying a whole file within the mount has remote make the copy; none
+// of the data goes over the network
+func TestCopyWithinMountCopiesOnRemote(t *testing.T) {
+	useTestQueue(t)
+	srv := &copyServer{}
+	useTestRemote(t, srv)
+	useRemoteFeatures(t, lib.FEATURE_COPY)
+	online.Store(true)
+
+	contents := bytes.Repeat([]byte("0123456789abcdef"), 512*1024) // 8MiB
+	err := os.WriteFile(filepath.Join(realpath, "src"), contents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := openHandle(t, "src", os.O_RDONLY)
+	dst := openHandle(t, "dst", os.O_CREATE|os.O_RDWR)
+
+	node := &Node{path: realpath}
+	var copied uint64
+	for copied < uint64(len(contents)) {
+		n, errno := node.CopyFileRange(context.Background(), src, copied, nil, dst, copied, uint64(len(contents))-copied, 0)
+		if errno != 0 {
+			t.Fatalf("CopyFileRange = %v", errno)
+		}
+		if n == 0 {
+			break
+		}
+		if copied == 0 && n != uint32(len(contents)) {
+			t.Skip("copy_file_range copied the file in parts")
+		}
+		copied += uint64(n)
+	}
+
+	got, _ := os.ReadFile(filepath.Join(realpath, "dst"))
+	if !bytes.Equal(got, contents) {
+		t.Errorf("local copy has %v bytes; want the %v of src", len(got), len(contents))
+	}
+	if len(srv.copies) != 1 || srv.copies[0].SrcPath != "/src" || srv.copies[0].DstPath != "/dst" {
+		t.Errorf("remote copies = %v; want one of /src to /dst", srv.copies)
+	}
+	if srv.written != 0 {
+		t.Errorf("%v bytes sent to remote; want 0", srv.written)
+	}
+}
+
+// Without Copy on remote the copied bytes are sent as a write
+func TestCopyWithoutRemoteCopySendsData(t *testing.T) {
+	useTestQueue(t)
+	srv := &copyServer{}
+	useTestRemote(t, srv)
+	useRemoteFeatures(t)
+	online.Store(true)
+
+	err := os.WriteFile(filepath.Join(realpath, "src"), []byte("contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := openHandle(t, "src", os.O_RDONLY)
+	dst := openHandle(t, "dst", os.O_CREATE|os.O_RDWR)
+
+	n, errno := (&Node{path: realpath}).CopyFileRange(context.Background(), src, 0, nil, dst, 0, 8, 0)
+	if errno != 0 || n != 8 {
+		t.Fatalf("CopyFileRange = %v, %v; want 8 bytes", n, errno)
+	}
+	if len(srv.copies) != 0 || srv.written != 8 {
+		t.Errorf("remote got %v copies and %v bytes; want 0 and 8", len(srv.copies), srv.written)
+	}
+}
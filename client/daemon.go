@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"golang.org/x/sys/unix"
+)
+
+// With -daemon, run starts a copy of itself in a new session, detached
+// from the terminal, and returns. Go can't fork so the copy is a fresh
+// exec of the same binary; DAEMON_ENV tells it that it is the
+// background one. The daemon records its PID in pidFile so that
+// status and unmount can find it, and logs to logFile
+const (
+	DAEMON_ENV = "FUSION_DAEMONIZED"
+
+	// Size at which the log is rotated and how many old logs are kept
+	MAX_LOG_SIZE    = 10 * 1024 * 1024 // 10Mb
+	MAX_LOG_BACKUPS = 3
+)
+
+var (
+	pidFile = filepath.Join(lib.ProjectDir, "client.pid")
+	logFile = filepath.Join(lib.ProjectDir, "client.log")
+)
+
+func isDaemon() bool {
+	return os.Getenv(DAEMON_ENV) == "1"
+}
+
+// Starts the client in the background and exits
+func daemonize() {
+	pid, running := runningPid()
+	if running {
+		log.Fatalf("Client already running with PID %v\n", pid)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Error starting daemon; %v\n", err)
+	}
+
+	logs, err := openLog()
+	if err != nil {
+		log.Fatalf("Error opening log file; %v\n", err)
+	}
+	defer logs.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), DAEMON_ENV+"=1")
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+
+	// New session; no controlling terminal to send us SIGHUP
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		log.Fatalf("Error starting daemon; %v\n", err)
+	}
+
+	fmt.Printf("Client running in background with PID %v; logs in %v\n", cmd.Process.Pid, logFile)
+	os.Exit(0)
+}
+
+// Sets up the background copy started by daemonize
+func setupDaemon() {
+	writer := &rotatingLog{}
+	err := writer.open()
+	if err != nil {
+		log.Fatalf("Error opening log file; %v\n", err)
+	}
+	log.SetOutput(writer)
+
+	err = writePidFile()
+	if err != nil {
+		log.Fatalf("Error writing PID file; %v\n", err)
+	}
+}
+
+func openLog() (*os.File, error) {
+	return os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+}
+
+// Log file that is moved aside once it grows past MAX_LOG_SIZE.
+// stdout and stderr follow it so that panics end up in the log too
+type rotatingLog struct {
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (l *rotatingLog) open() error {
+	file, err := openLog()
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	unix.Dup2(int(file.Fd()), int(os.Stdout.Fd()))
+	unix.Dup2(int(file.Fd()), int(os.Stderr.Fd()))
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *rotatingLog) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size+int64(len(data)) > MAX_LOG_SIZE {
+		l.rotate()
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return n, err
+}
+
+// Shifts client.log to client.log.1, client.log.1 to client.log.2
+// and so on, dropping the oldest. Caller must hold l.mu
+func (l *rotatingLog) rotate() {
+	for i := MAX_LOG_BACKUPS - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%v.%v", logFile, i), fmt.Sprintf("%v.%v", logFile, i+1))
+	}
+	os.Rename(logFile, logFile+".1")
+
+	err := l.open()
+	if err != nil {
+		// Keep writing to the old file rather than lose logs
+		fmt.Fprintf(l.file, "Error rotating log file; %v\n", err)
+	}
+}
+
+func writePidFile() error {
+	pid, running := runningPid()
+	if running && pid != os.Getpid() {
+		return fmt.Errorf("client already running with PID %v", pid)
+	}
+	return os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+}
+
+func removePidFile() {
+	pid, _ := readPidFile()
+	if pid == os.Getpid() {
+		os.Remove(pidFile)
+	}
+}
+
+func readPidFile() (int, error) {
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Returns the PID of the running daemon. A PID file left behind by a
+// daemon that crashed is not running
+func runningPid() (int, bool) {
+	pid, err := readPidFile()
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	err = syscall.Kill(pid, 0)
+	return pid, err == nil || err == syscall.EPERM
+}
+
+// Asks the daemon to unmount and exit, then waits for it to go away
+func stopDaemon() {
+	pid, running := runningPid()
+	if !running {
+		log.Fatalln("Client is not running in the background")
+	}
+
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		log.Fatalf("Error stopping client with PID %v; %v\n", pid, err)
+	}
+
+	const STOP_TIMEOUT = 10 * time.Second
+	deadline := time.Now().Add(STOP_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pid, 0) == syscall.ESRCH {
+			fmt.Printf("Client with PID %v unmounted and stopped\n", pid)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Fatalf("Client with PID %v did not stop within %v\n", pid, STOP_TIMEOUT)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Set for the copies of the test binary TestDaemonStartStop starts;
+// where they keep their PID file and log
+const TEST_DAEMON_DIR_ENV = "FUSION_TEST_DAEMON_DIR"
+
+// Stands in for run -daemon in a copy of the test binary: daemonizes,
+// then waits to be stopped the way runFileSystem does
+func TestDaemonProcess(t *testing.T) {
+	dir := os.Getenv(TEST_DAEMON_DIR_ENV)
+	if dir == "" {
+		t.Skip("only run by TestDaemonStartStop")
+	}
+	pidFile = filepath.Join(dir, "client.pid")
+	logFile = filepath.Join(dir, "client.log")
+
+	if !isDaemon() {
+		daemonize()
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM)
+	setupDaemon()
+	log.Println("daemon running")
+
+	<-sigChan
+	removePidFile()
+	os.Exit(0)
+}
+
+func TestDaemonStartStop(t *testing.T) {
+	dir := t.TempDir()
+	oldPidFile, oldLogFile := pidFile, logFile
+	pidFile = filepath.Join(dir, "client.pid")
+	logFile = filepath.Join(dir, "client.log")
+	t.Cleanup(func() {
+		if pid, running := runningPid(); running {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		pidFile, logFile = oldPidFile, oldLogFile
+	})
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDaemonProcess$")
+	cmd.Env = append(os.Environ(), TEST_DAEMON_DIR_ENV+"="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("starting daemon failed; %v: %s", err, out)
+	}
+
+	var pid int
+	var running bool
+	deadline := time.Now().Add(5 * time.Second)
+	for !running && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		pid, running = runningPid()
+	}
+	if !running {
+		t.Fatal("daemon never wrote its PID file")
+	}
+	if pid == cmd.Process.Pid {
+		t.Error("the process started from the terminal kept running")
+	}
+	if sid, err := unix.Getsid(pid); err != nil || sid != pid {
+		t.Errorf("daemon in session %v, %v; want a session of its own", sid, err)
+	}
+	for time.Now().Before(deadline) {
+		logs, _ := os.ReadFile(logFile)
+		if strings.Contains(string(logs), "daemon running") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if logs, _ := os.ReadFile(logFile); !strings.Contains(string(logs), "daemon running") {
+		t.Errorf("daemon's log = %q; want its output", logs)
+	}
+
+	stopDaemon()
+	if _, running := runningPid(); running {
+		t.Error("daemon still running after stop")
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("PID file left behind; %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Which way changes flow between this mount and remote; see
+// -sync-direction
+const (
+	// Local changes go to remote and remote's come here
+	SYNC_BIDIRECTIONAL = "bidirectional"
+	// A read-only mirror of remote. Local changes fail with EROFS
+	SYNC_PULL = "pull"
+	// Local changes go to remote but remote's are never applied
+	// here, eg. for a source feeding a central archive
+	SYNC_PUSH = "push"
+)
+
+var syncDirection string
+
+func init() {
+	registerStatus("sync_direction", func() any {
+		return syncDirection
+	})
+}
+
+func validSyncDirection(direction string) bool {
+	return direction == SYNC_BIDIRECTIONAL || direction == SYNC_PULL || direction == SYNC_PUSH
+}
+
+// Fails local changes with EROFS on pull-only mounts. Changes applied
+// from remote write to realpath directly and are unaffected
+func refuseLocalChange(op, path string) syscall.Errno {
+	if syncDirection != SYNC_PULL {
+		return fs.OK
+	}
+	log.Printf("[FUSE] %v %v refused; mount is pull-only\n", op, path)
+	return syscall.EROFS
+}
+
+// Reports whether remote's changes are applied locally
+func pullsRemote() bool {
+	return syncDirection != SYNC_PUSH
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func useSyncDirection(t *testing.T, direction string) {
+	old := syncDirection
+	syncDirection = direction
+	t.Cleanup(func() { syncDirection = old })
+}
+
+// Serves a file and a directory through the raw bridge. Returns the
+// bridge and a header from the calling user for the root
+func directionFixture(t *testing.T) (fuse.RawFileSystem, fuse.InHeader) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(realpath, "file"), []byte("data"), 0644)
+	if err == nil {
+		err = os.Mkdir(filepath.Join(realpath, "dir"), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	return raw, header
+}
+
+func lookupId(t *testing.T, raw fuse.RawFileSystem, header fuse.InHeader, name string) uint64 {
+	t.Helper()
+	entry := fuse.EntryOut{}
+	if status := raw.Lookup(nil, &header, name, &entry); !status.Ok() {
+		t.Fatalf("Lookup %v = %v", name, status)
+	}
+	return entry.NodeId
+}
+
+// Pull-only mounts refuse every local change with EROFS but still
+// read, and apply remote's changes
+func TestPullOnlyRefusesLocalChanges(t *testing.T) {
+	useTestListings(t)
+	useTestQueue(t)
+	useSyncDirection(t, SYNC_PULL)
+	raw, header := directionFixture(t)
+	fileHeader := header
+	fileHeader.NodeId = lookupId(t, raw, header, "file")
+	dirId := lookupId(t, raw, header, "dir")
+
+	refused := map[string]fuse.Status{
+		"Create":  raw.Create(nil, &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_WRONLY | os.O_CREATE), Mode: 0644}, "new", &fuse.CreateOut{}),
+		"Mkdir":   raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 0755}, "newdir", &fuse.EntryOut{}),
+		"Unlink":  raw.Unlink(nil, &header, "file"),
+		"Rmdir":   raw.Rmdir(nil, &header, "dir"),
+		"Rename":  raw.Rename(nil, &fuse.RenameIn{InHeader: header, Newdir: dirId}, "file", "moved"),
+		"Symlink": raw.Symlink(nil, &header, "file", "link", &fuse.EntryOut{}),
+		"Setattr": raw.SetAttr(nil, &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+			InHeader: fileHeader,
+			Valid:    fuse.FATTR_SIZE,
+		}}, &fuse.AttrOut{}),
+		"Open for writing": raw.Open(nil, &fuse.OpenIn{InHeader: fileHeader, Flags: uint32(os.O_WRONLY)}, &fuse.OpenOut{}),
+		"Open truncating":  raw.Open(nil, &fuse.OpenIn{InHeader: fileHeader, Flags: uint32(os.O_RDONLY | os.O_TRUNC)}, &fuse.OpenOut{}),
+	}
+	for op, status := range refused {
+		if status != fuse.Status(syscall.EROFS) {
+			t.Errorf("%v on a pull-only mount = %v; want EROFS", op, status)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(realpath, "file")); string(data) != "data" {
+		t.Errorf("file holds %q after refused changes; want data", data)
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("refused changes queued %v", ops)
+	}
+
+	opened := fuse.OpenOut{}
+	if status := raw.Open(nil, &fuse.OpenIn{InHeader: fileHeader, Flags: uint32(os.O_RDONLY)}, &opened); !status.Ok() {
+		t.Errorf("Open for reading on a pull-only mount = %v", status)
+	} else {
+		raw.Release(nil, &fuse.ReleaseIn{InHeader: fileHeader, Fh: opened.Fh})
+	}
+	if status := raw.GetAttr(nil, &fuse.GetAttrIn{InHeader: fileHeader}, &fuse.AttrOut{}); !status.Ok() {
+		t.Errorf("Getattr on a pull-only mount = %v", status)
+	}
+
+	deletedOnRemote(t, "/gone", "contents")
+	if _, err := os.Lstat(localPath("/gone")); !os.IsNotExist(err) {
+		t.Errorf("remote delete not applied on a pull-only mount; %v", err)
+	}
+}
+
+// Push-only mounts make local changes as usual but never listen to or
+// list remote
+func TestPushOnlyIgnoresRemote(t *testing.T) {
+	useTestListings(t)
+	useTestQueue(t)
+	useSyncDirection(t, SYNC_PUSH)
+	srv := &putServer{}
+	usePutRemote(t, srv)
+	online.Store(false)
+	raw, header := directionFixture(t)
+
+	if status := raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 0755}, "newdir", &fuse.EntryOut{}); !status.Ok() {
+		t.Errorf("Mkdir on a push-only mount = %v", status)
+	}
+	if status := raw.Unlink(nil, &header, "file"); !status.Ok() {
+		t.Errorf("Unlink on a push-only mount = %v", status)
+	}
+	waitQueued(t, OP_MKDIR, "/newdir")
+	waitQueued(t, OP_UNLINK, "/file")
+
+	online.Store(true)
+	srv.reset()
+	startRemoteObserver(t.Context())
+	err := fetchRemoteEntries(t.Context(), "/")
+	if err != nil {
+		t.Errorf("fetchRemoteEntries on a push-only mount = %v", err)
+	}
+	if n := srv.total(); n != 0 {
+		t.Errorf("push-only mount called remote %v", srv.calls)
+	}
+}
+
+func TestSyncDirectionInStatus(t *testing.T) {
+	useSyncDirection(t, SYNC_PULL)
+	w := httptest.NewRecorder()
+	statusHandler(w, httptest.NewRequest("GET", "/status", nil))
+
+	report := struct {
+		SyncDirection string `json:"sync_direction"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SyncDirection != SYNC_PULL {
+		t.Errorf("status sync_direction = %q; want %q", report.SyncDirection, SYNC_PULL)
+	}
+}
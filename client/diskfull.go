@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"google.golang.org/grpc/status"
+)
+
+// Writes are sent to remote in the background, so by the time remote
+// answers that it is out of space the write has already succeeded
+// locally. Files remote refused with ENOSPC or EDQUOT are remembered
+// here; further writes to them fail with ENOSPC, and so does closing
+// them, instead of the local copy silently drifting away from remote.
+//
+// After REMOTE_FULL_RETRY the next write is let through and the whole
+// file is uploaded again. If remote takes it the file is writable
+// again, otherwise it stays refused for another round
+const REMOTE_FULL_RETRY = 30 * time.Second
+
+type remoteFullFile struct {
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error"`
+	retryAt   time.Time
+}
+
+var (
+	// Keyed by path relative to realpath
+	remoteFull   = make(map[string]*remoteFullFile)
+	remoteFullMu = sync.Mutex{}
+)
+
+func init() {
+	registerStatus("remote_full", func() any {
+		remoteFullMu.Lock()
+		defer remoteFullMu.Unlock()
+
+		files := make(map[string]remoteFullFile, len(remoteFull))
+		for path, file := range remoteFull {
+			files[path] = *file
+		}
+		return files
+	})
+}
+
+// Reports whether err is remote refusing a change for lack of space.
+// Other ResourceExhausted errors, eg. the inode quota or too many open
+// files, don't go away by waiting for space and aren't counted
+func remoteOutOfSpace(err error) bool {
+	errno, ok := lib.DetailErrno(err)
+	return ok && (errno == syscall.ENOSPC || errno == syscall.EDQUOT)
+}
+
+// Records that remote refused a change to path for lack of space.
+// Reports whether err was such a refusal
+func markRemoteFull(path string, err error) bool {
+	if !remoteOutOfSpace(err) {
+		return false
+	}
+
+	remoteFullMu.Lock()
+	defer remoteFullMu.Unlock()
+
+	file, ok := remoteFull[path]
+	if !ok {
+		log.Printf("[SYNC] Remote is out of space; refusing writes to %v until it has room\n", path)
+		file = &remoteFullFile{Since: time.Now()}
+		remoteFull[path] = file
+	}
+	file.LastError = status.Convert(err).Message()
+	file.retryAt = time.Now().Add(REMOTE_FULL_RETRY)
+	return true
+}
+
+func clearRemoteFull(path string) {
+	remoteFullMu.Lock()
+	defer remoteFullMu.Unlock()
+
+	if _, ok := remoteFull[path]; ok {
+		log.Printf("[SYNC] Remote accepted %v again\n", path)
+		delete(remoteFull, path)
+	}
+}
+
+// Returns ENOSPC while writes to path are refused. retry is set once
+// REMOTE_FULL_RETRY has passed; the caller should let the write through
+// and upload the whole file, since remote missed the earlier writes
+func remoteFullErrno(path string) (errno syscall.Errno, retry bool) {
+	remoteFullMu.Lock()
+	defer remoteFullMu.Unlock()
+
+	file, ok := remoteFull[path]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().Before(file.retryAt) {
+		return syscall.ENOSPC, false
+	}
+	// Hold off other writers while this one finds out
+	file.retryAt = time.Now().Add(REMOTE_FULL_RETRY)
+	return 0, true
+}
+
+// Reports whether writes to path are currently refused
+func remoteFullRefused(path string) bool {
+	remoteFullMu.Lock()
+	defer remoteFullMu.Unlock()
+
+	_, ok := remoteFull[path]
+	return ok
+}
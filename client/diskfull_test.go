@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fullServer struct {
+	proto.UnimplementedFuseServer
+	err error
+}
+
+func (s fullServer) Write(ctx context.Context, req *proto.WriteRequest) (*proto.WriteResponse, error) {
+	return nil, s.err
+}
+
+func TestRemoteFullRefusesWrites(t *testing.T) {
+	// As remote reports a failed write to a full disk
+	err := lib.StatusError(&os.PathError{Op: "write", Path: "/file", Err: syscall.ENOSPC})
+	client := newTestClient(t, fullServer{err: err})
+	_, err = client.Write(context.Background(), &proto.WriteRequest{Path: "/full", Data: []byte("data")})
+
+	path := "full"
+	if !markRemoteFull(path, err) {
+		t.Fatalf("markRemoteFull(%v) = false; want true", err)
+	}
+	defer clearRemoteFull(path)
+
+	errno, retry := remoteFullErrno(path)
+	if errno != syscall.ENOSPC || retry {
+		t.Errorf("remoteFullErrno = %v, %v; want ENOSPC, false", errno, retry)
+	}
+
+	// Let through once REMOTE_FULL_RETRY has passed
+	remoteFullMu.Lock()
+	remoteFull[path].retryAt = time.Now().Add(-time.Second)
+	remoteFullMu.Unlock()
+	errno, retry = remoteFullErrno(path)
+	if errno != 0 || !retry {
+		t.Errorf("remoteFullErrno after the retry delay = %v, %v; want 0, true", errno, retry)
+	}
+
+	clearRemoteFull(path)
+	if remoteFullRefused(path) {
+		t.Error("path still refused after clearRemoteFull")
+	}
+}
+
+func TestRemoteFullIgnoresOtherLimits(t *testing.T) {
+	tests := []error{
+		status.Error(codes.ResourceExhausted, "Inode quota exceeded"),
+		status.Error(codes.ResourceExhausted, "Too many open files"),
+		status.Errorf(codes.ResourceExhausted, "write of %v bytes is over the %vMB limit", 1<<30, 8),
+		status.Error(codes.Unavailable, "connection refused"),
+		nil,
+	}
+	for _, err := range tests {
+		if markRemoteFull("other", err) {
+			clearRemoteFull("other")
+			t.Errorf("markRemoteFull(%v) = true; want false", err)
+		}
+	}
+
+	if !remoteOutOfSpace(lib.StatusError(syscall.EDQUOT)) {
+		t.Error("remoteOutOfSpace(EDQUOT) = false; want true")
+	}
+}
+
+// A write remote runs out of space for fails the writes after it and
+// the close, rather than the local file drifting away from remote
+func TestRemoteFullReportedToWriter(t *testing.T) {
+	useTestQueue(t)
+	err := lib.StatusError(&os.PathError{Op: "write", Path: "/file", Err: syscall.ENOSPC})
+	useTestRemote(t, fullServer{err: err})
+	online.Store(true)
+	oldLocal := localStatfs
+	localStatfs = lib.NewStatfsCache(0)
+	t.Cleanup(func() { localStatfs = oldLocal })
+
+	fh := openHandle(t, "file", os.O_CREATE|os.O_RDWR)
+	path := relativePath(fh.path)
+	t.Cleanup(func() { clearRemoteFull(path) })
+	if _, errno := fh.Write(context.Background(), []byte("first"), 0); errno != 0 {
+		t.Fatalf("write before remote refused = %v; want it written locally", errno)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !remoteFullRefused(path) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, errno := fh.Write(context.Background(), []byte("second"), 5); errno != syscall.ENOSPC {
+		t.Errorf("write after remote ran out of space = %v; want ENOSPC", errno)
+	}
+	if errno := fh.Flush(context.Background()); errno != syscall.ENOSPC {
+		t.Errorf("close after remote ran out of space = %v; want ENOSPC", errno)
+	}
+	if got, _ := os.ReadFile(localPath("/file")); string(got) != "first" {
+		t.Errorf("local file holds %q; want the refused write left out", got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Pre-flight check run before talking to remote. Turns the cryptic
+// errors we would otherwise get deep inside an RPC into a message
+// telling the user what to fix
+func preflight(remote string) error {
+	err := lib.ValidateAddress(remote)
+	if err != nil {
+		return fmt.Errorf("invalid -remote address %q; %v. Expected host:port eg. 192.168.0.10:1054", remote, err)
+	}
+
+	conn, err := net.DialTimeout("tcp", remote, 5*time.Second)
+	if err != nil {
+		return explainDialError(remote, err)
+	}
+	conn.Close()
+
+	return nil
+}
+
+func explainDialError(remote string, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return fmt.Errorf("cannot resolve host of %v; check the -remote hostname for typos", remote)
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("connection refused by %v; is the fusion server running and listening on that port?", remote)
+	}
+
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return fmt.Errorf("no route to %v; check your network connection", remote)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("timed out connecting to %v; the host may be down or a firewall is blocking the port", remote)
+	}
+
+	return fmt.Errorf("cannot connect to %v; %v", remote, err)
+}
+
+// Warn when remote's certificate is this close to expiring
+const CERT_EXPIRY_WARNING = 14 * 24 * time.Hour
+
+// Authorities remote's certificate must be signed by; nil means the
+// system roots
+var remoteRoots *x509.CertPool
+
+// Does a TLS handshake with remote and returns when the certificate
+// it served expires. The server reloads renewed certificates on its
+// own so a stale expiry here means the renewal itself failed
+func checkCertificate(remote string) (time.Time, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", remote, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    remoteRoots,
+	})
+	if err != nil {
+		return time.Time{}, explainCertError(remote, err)
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("%v did not present a certificate", remote)
+	}
+	return certs[0].NotAfter, nil
+}
+
+func explainCertError(remote string, err error) error {
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		return fmt.Errorf("certificate of %v has expired or is not valid yet; renew it on the server and check the clocks of both machines", remote)
+	}
+
+	var hostErr x509.HostnameError
+	if errors.As(err, &hostErr) {
+		return fmt.Errorf("certificate of %v is not issued for %v; connect using a name the certificate covers or reissue it", remote, hostErr.Host)
+	}
+
+	var authorityErr x509.UnknownAuthorityError
+	if errors.As(err, &authorityErr) {
+		return fmt.Errorf("certificate of %v is not signed by a trusted authority", remote)
+	}
+
+	return fmt.Errorf("TLS handshake with %v failed; %v", remote, err)
+}
+
+// Runs every pre-flight check and reports the result of each
+func runDoctor() {
+	ok := true
+
+	fmt.Printf("Checking remote %v\n", remote)
+	err := preflight(remote)
+	if err != nil {
+		ok = false
+		fmt.Printf("  [FAIL] %v\n", err)
+	} else {
+		fmt.Printf("  [OK] remote is reachable\n")
+	}
+
+	if !useTLS {
+		fmt.Printf("  [WARN] TLS is not enabled; certificate checks skipped\n")
+	} else {
+		expiry, err := checkCertificate(remote)
+		if err != nil {
+			ok = false
+			fmt.Printf("  [FAIL] %v\n", err)
+		} else if time.Until(expiry) < CERT_EXPIRY_WARNING {
+			fmt.Printf("  [WARN] certificate expires on %v\n", expiry.Format(time.DateOnly))
+		} else {
+			fmt.Printf("  [OK] certificate is valid until %v\n", expiry.Format(time.DateOnly))
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+	log.Println("All checks passed")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a new CA and its key
+func testCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, key
+}
+
+// Serves TLS on a local port with a certificate from ca for names,
+// valid until notAfter. Returns the address
+func serveTLS(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, names []string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     names,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return "localhost:" + port
+}
+
+func TestCheckCertificate(t *testing.T) {
+	ca, caKey := testCA(t)
+	old := remoteRoots
+	remoteRoots = x509.NewCertPool()
+	remoteRoots.AddCert(ca)
+	t.Cleanup(func() { remoteRoots = old })
+	valid := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+
+	remote := serveTLS(t, ca, caKey, []string{"localhost"}, valid)
+	expiry, err := checkCertificate(remote)
+	if err != nil {
+		t.Fatalf("valid certificate refused; %v", err)
+	}
+	if !expiry.Equal(valid) {
+		t.Errorf("expiry = %v; want %v", expiry, valid)
+	}
+
+	tests := map[string]struct {
+		names    []string
+		notAfter time.Time
+		want     string
+	}{
+		"expired":  {[]string{"localhost"}, time.Now().Add(-time.Hour), "has expired"},
+		"mismatch": {[]string{"fusion.example.com"}, valid, "not issued for localhost"},
+	}
+	for name, test := range tests {
+		remote := serveTLS(t, ca, caKey, test.names, test.notAfter)
+		_, err := checkCertificate(remote)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v certificate = %v; want an error saying %q", name, err, test.want)
+		}
+	}
+
+	remoteRoots = x509.NewCertPool()
+	_, err = checkCertificate(remote)
+	if err == nil || !strings.Contains(err.Error(), "trusted authority") {
+		t.Errorf("untrusted certificate = %v; want an error about its authority", err)
+	}
+}
+
+func TestPreflightConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := listener.Addr().String()
+	listener.Close()
+
+	err = preflight(remote)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("preflight of closed port = %v; want connection refused", err)
+	}
+	err = preflight("no-port")
+	if err == nil || !strings.Contains(err.Error(), "invalid -remote") {
+		t.Errorf("preflight of bad address = %v; want invalid -remote", err)
+	}
+}
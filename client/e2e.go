@@ -0,0 +1,666 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// With -e2e file contents are encrypted before they leave this machine
+// so remote only ever stores ciphertext. Local files stay plaintext.
+//
+// Every remote file starts with an E2E_HEADER_SIZE byte header holding
+// a random file id. The contents follow in chunks of E2E_CHUNK_SIZE
+// bytes, each sealed on its own with AES-256-GCM under a fresh random
+// nonce and stored as nonce, ciphertext and tag. Each chunk also
+// authenticates the file id, its index and whether it is the last
+// chunk, so chunks can't be moved between files or positions and a
+// file can't be cut short unnoticed. Writes only re-seal the chunks
+// they touch. The file id lives in the file, so renames and server side
+// copies need no re-upload.
+//
+// The key is derived from the passphrase with PBKDF2-SHA256 and a
+// random salt kept in E2E_KEY_FILE at the root of remote, so everyone
+// sharing a passphrase and a volume shares a key. The key file also
+// holds a sealed check value that tells a wrong passphrase apart.
+// Clients keep a copy of it to start offline.
+//
+// Sealing the same contents twice gives different ciphertext, so the
+// hash remote has for a file can't be worked out from the local copy.
+// The local copy remembers in E2E_XATTR its file id and the hash remote
+// had when the two were last in sync instead.
+//
+// File names are not encrypted.
+const (
+	E2E_MAGIC             = "FE2\x01"
+	E2E_FILE_ID_SIZE      = 16
+	E2E_HEADER_SIZE       = len(E2E_MAGIC) + E2E_FILE_ID_SIZE
+	E2E_CHUNK_SIZE        = 64 * 1024
+	E2E_NONCE_SIZE        = 12
+	E2E_TAG_SIZE          = 16
+	E2E_OVERHEAD          = E2E_NONCE_SIZE + E2E_TAG_SIZE
+	E2E_SEALED_CHUNK_SIZE = E2E_CHUNK_SIZE + E2E_OVERHEAD
+
+	E2E_XATTR          = "user.fusion.e2e"
+	E2E_KEY_FILE       = ".fusion-e2e"
+	E2E_PASSPHRASE_ENV = "FUSION_E2E_PASSPHRASE"
+	E2E_SALT_SIZE      = 16
+	E2E_KDF_ITERATIONS = 600_000
+	E2E_KEY_SIZE       = 32
+
+	// Authenticated with the check value in E2E_KEY_FILE
+	E2E_KEY_CHECK = "fusion-e2e key check"
+)
+
+var (
+	// Seals and opens chunks with the key derived from the -e2e
+	// passphrase. nil when -e2e is off
+	e2eAEAD cipher.AEAD
+
+	// Stops concurrent writes to a new file from each picking an id
+	fileIdMu = sync.Mutex{}
+
+	errBadPassphrase = errors.New("passphrase doesn't match the key of this volume")
+)
+
+// Derives the encryption key from the passphrase in
+// FUSION_E2E_PASSPHRASE and the salt of this volume. The key file is
+// fetched from remote, or created there, unless a copy was kept from
+// an earlier run; connected tells whether remote can be asked
+func setupE2E(connected bool) error {
+	passphrase := os.Getenv(E2E_PASSPHRASE_ENV)
+	if passphrase == "" {
+		return fmt.Errorf("-e2e requires a passphrase in $%v", E2E_PASSPHRASE_ENV)
+	}
+
+	keyFile, err := os.ReadFile(keyFilePath())
+	if os.IsNotExist(err) {
+		if !connected {
+			return fmt.Errorf("-e2e needs remote the first time it is used with %v", realpath)
+		}
+		keyFile, err = remoteKeyFile(passphrase)
+		if err == nil {
+			err = saveKeyFile(keyFile)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	aead, err := openKeyFile(keyFile, passphrase)
+	if err != nil {
+		return err
+	}
+	e2eAEAD = aead
+	return nil
+}
+
+func e2eEnabled() bool {
+	return e2eAEAD != nil
+}
+
+// Local copy of the key file of realpath
+func keyFilePath() string {
+	digest := md5.Sum([]byte(realpath))
+	return filepath.Join(lib.ProjectDir, "e2e", hex.EncodeToString(digest[:]))
+}
+
+func saveKeyFile(keyFile []byte) error {
+	path := keyFilePath()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, keyFile, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Reports whether path, relative to realpath, is the key file. It is
+// never synced like other files
+func isKeyFile(path string) bool {
+	return filepath.Clean("/"+path) == "/"+E2E_KEY_FILE
+}
+
+func newAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		// Only fails on bad key sizes which we never pass
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, E2E_KDF_ITERATIONS, E2E_KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key), nil
+}
+
+// Makes the key file of a new volume: a random salt followed by the
+// sealed check value
+func newKeyFile(passphrase string) ([]byte, error) {
+	salt := make([]byte, E2E_SALT_SIZE)
+	rand.Read(salt)
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, E2E_NONCE_SIZE)
+	rand.Read(nonce)
+	keyFile := append(salt, nonce...)
+	return aead.Seal(keyFile, nonce, nil, []byte(E2E_KEY_CHECK)), nil
+}
+
+// Derives the key from keyFile and passphrase and checks it is the
+// one the key file was made with
+func openKeyFile(keyFile []byte, passphrase string) (cipher.AEAD, error) {
+	if len(keyFile) != E2E_SALT_SIZE+E2E_OVERHEAD {
+		return nil, fmt.Errorf("%v is %v bytes long; want %v", E2E_KEY_FILE, len(keyFile), E2E_SALT_SIZE+E2E_OVERHEAD)
+	}
+	salt := keyFile[:E2E_SALT_SIZE]
+	nonce := keyFile[E2E_SALT_SIZE : E2E_SALT_SIZE+E2E_NONCE_SIZE]
+	check := keyFile[E2E_SALT_SIZE+E2E_NONCE_SIZE:]
+
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = aead.Open(nil, nonce, check, []byte(E2E_KEY_CHECK))
+	if err != nil {
+		return nil, errBadPassphrase
+	}
+	return aead, nil
+}
+
+// Returns the key file on remote, creating it if this is the first
+// client to use -e2e with the volume
+func remoteKeyFile(passphrase string) ([]byte, error) {
+	ctx, cancel := newSyncCtx()
+	defer cancel()
+
+	path := "/" + E2E_KEY_FILE
+	res, err := grpcClient.ReadAll(ctx, &proto.DirEntry{Path: path})
+	if err == nil {
+		return res.Data, nil
+	}
+	if lib.StatusErrno(err) != syscall.ENOENT {
+		return nil, err
+	}
+
+	keyFile, err := newKeyFile(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	_, err = grpcClient.Create(ctx, &proto.CreateRequest{
+		Path:  path,
+		Flags: syscall.O_CREAT | syscall.O_EXCL | syscall.O_WRONLY,
+		Mode:  0600,
+	})
+	if lib.StatusErrno(err) == syscall.EEXIST {
+		// Another client got there first
+		res, err := grpcClient.ReadAll(ctx, &proto.DirEntry{Path: path})
+		if err != nil {
+			return nil, err
+		}
+		return res.Data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = grpcClient.Write(ctx, &proto.WriteRequest{Path: path, Data: keyFile})
+	if err != nil {
+		return nil, err
+	}
+	return keyFile, nil
+}
+
+// What a local file knows about its remote copy
+type e2eRecord struct {
+	// Id in the header of the remote file
+	Id []byte `json:"id"`
+
+	// Hash of the local contents and the hash remote had for them
+	// when they were last in sync. Empty once either side changes
+	Hash       string `json:"hash,omitempty"`
+	RemoteHash string `json:"remote_hash,omitempty"`
+}
+
+// Returns the record of local file path or nil if it has none
+func loadRecord(path string) *e2eRecord {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, E2E_XATTR, buf)
+	if err != nil {
+		return nil
+	}
+	var record e2eRecord
+	err = json.Unmarshal(buf[:n], &record)
+	if err != nil || len(record.Id) != E2E_FILE_ID_SIZE {
+		return nil
+	}
+	return &record
+}
+
+func saveRecord(path string, record *e2eRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return syscall.Setxattr(path, E2E_XATTR, data, 0)
+}
+
+// Gives local file dst the record of src, whose remote copy remote
+// duplicated
+func copyRecord(src, dst string) {
+	record := loadRecord(src)
+	if record != nil {
+		saveRecord(dst, record)
+	}
+}
+
+func newFileId() []byte {
+	id := make([]byte, E2E_FILE_ID_SIZE)
+	rand.Read(id)
+	return id
+}
+
+func fileHeader(id []byte) []byte {
+	return append([]byte(E2E_MAGIC), id...)
+}
+
+// Returns the file id in header, which must be a whole header
+func parseHeader(header []byte) ([]byte, error) {
+	if len(header) != E2E_HEADER_SIZE || string(header[:len(E2E_MAGIC)]) != E2E_MAGIC {
+		return nil, errors.New("not an encrypted file")
+	}
+	return header[len(E2E_MAGIC):], nil
+}
+
+// Data authenticated with chunk index of file id
+func chunkAAD(id []byte, index int64, last bool) []byte {
+	aad := make([]byte, 0, E2E_FILE_ID_SIZE+9)
+	aad = append(aad, id...)
+	aad = binary.BigEndian.AppendUint64(aad, uint64(index))
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// Seals plain, the contents of a file of size bytes starting at chunk
+// first, appending the sealed chunks to out
+func sealChunks(out []byte, id []byte, plain []byte, first int64, size int64) []byte {
+	lastIndex := (size - 1) / E2E_CHUNK_SIZE
+	for index := first; len(plain) > 0; index++ {
+		take := min(len(plain), E2E_CHUNK_SIZE)
+		nonce := make([]byte, E2E_NONCE_SIZE)
+		rand.Read(nonce)
+		out = append(out, nonce...)
+		out = e2eAEAD.Seal(out, nonce, plain[:take], chunkAAD(id, index, index == lastIndex))
+		plain = plain[take:]
+	}
+	return out
+}
+
+// Opens sealed chunk index of file id
+func openChunk(out []byte, id []byte, sealed []byte, index int64, last bool) ([]byte, error) {
+	if len(sealed) < E2E_OVERHEAD {
+		return nil, fmt.Errorf("chunk %v is cut short", index)
+	}
+	plain, err := e2eAEAD.Open(out, sealed[:E2E_NONCE_SIZE], sealed[E2E_NONCE_SIZE:], chunkAAD(id, index, last))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %v failed authentication", index)
+	}
+	return plain, nil
+}
+
+// Returns the file id of local file path, writing a header with a new
+// one to the start of the remote file if it has none yet so that the
+// chunks we write after it can be opened
+func ensureFileId(path string) ([]byte, error) {
+	fileIdMu.Lock()
+	defer fileIdMu.Unlock()
+
+	record := loadRecord(path)
+	if record != nil {
+		return record.Id, nil
+	}
+
+	id := newFileId()
+	ctx, cancel := newSyncCtx()
+	defer cancel()
+	_, err := grpcClient.Write(ctx, &proto.WriteRequest{
+		Path: relativePath(path),
+		Data: fileHeader(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return id, saveRecord(path, &e2eRecord{Id: id})
+}
+
+// Seals the chunks of local file path that a write to the plaintext
+// range [off, end) touched, reading them from fd after the write.
+// sizeBefore is the size of the file before the write; when it grows
+// the chunk that used to be last and any gap before off are sealed
+// too. Returns the sealed chunks and the offset they belong at on
+// remote
+func encryptWrite(fd int, path string, off, end, sizeBefore int64) ([]byte, int64, error) {
+	id, err := ensureFileId(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	forgetRemoteHash(path)
+
+	st := syscall.Stat_t{}
+	err = syscall.Fstat(fd, &st)
+	if err != nil {
+		return nil, 0, err
+	}
+	if end > sizeBefore {
+		off = min(off, max(sizeBefore-1, 0))
+	}
+	end = min(end, st.Size)
+	if end <= off {
+		return nil, 0, errors.New("nothing to encrypt")
+	}
+
+	first := off / E2E_CHUNK_SIZE
+	last := (end - 1) / E2E_CHUNK_SIZE
+	start := first * E2E_CHUNK_SIZE
+	plain := make([]byte, min((last+1)*E2E_CHUNK_SIZE, st.Size)-start)
+	n, err := syscall.Pread(fd, plain, start)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sealed := make([]byte, 0, sealedSize(int64(n)))
+	sealed = sealChunks(sealed, id, plain[:n], first, st.Size)
+	return sealed, int64(E2E_HEADER_SIZE) + first*E2E_SEALED_CHUNK_SIZE, nil
+}
+
+// Encrypts the whole contents of a file under a new file id. Returns
+// the id and the remote file contents
+func sealFile(data []byte) ([]byte, []byte) {
+	id := newFileId()
+	sealed := make([]byte, 0, sealedSize(int64(len(data))))
+	sealed = append(sealed, fileHeader(id)...)
+	return id, sealChunks(sealed, id, data, 0, int64(len(data)))
+}
+
+// Streams the contents of a file of size bytes as remote will store
+// them, sealing them as they are written, and hashes the result
+type sealingWriter struct {
+	id   []byte
+	size int64
+	next int64
+	buf  []byte
+	hash hash.Hash
+	out  func([]byte) error
+}
+
+// Returns a writer passing sealed data to out, which gets the header
+// first and whole chunks after that
+func newSealingWriter(size int64, out func([]byte) error) (*sealingWriter, error) {
+	w := &sealingWriter{
+		id:   newFileId(),
+		size: size,
+		hash: md5.New(),
+		out:  out,
+	}
+	header := fileHeader(w.id)
+	w.hash.Write(header)
+	return w, out(header)
+}
+
+func (w *sealingWriter) Write(data []byte) (int, error) {
+	n := len(data)
+	for len(data) > 0 {
+		take := min(len(data), E2E_CHUNK_SIZE-len(w.buf))
+		w.buf = append(w.buf, data[:take]...)
+		data = data[take:]
+		if len(w.buf) == E2E_CHUNK_SIZE {
+			err := w.flush()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *sealingWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	sealed := sealChunks(nil, w.id, w.buf, w.next, w.size)
+	w.next++
+	w.buf = w.buf[:0]
+	w.hash.Write(sealed)
+	return w.out(sealed)
+}
+
+// Sends the last chunk and returns the hash of everything sent
+func (w *sealingWriter) Close() (string, error) {
+	err := w.flush()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(w.hash.Sum(nil)), nil
+}
+
+// Records that remote now holds the contents of local file path with
+// the given hash, sealed under file id, as a file hashing to remoteHash
+func recordUpload(path string, id []byte, hash, remoteHash string) error {
+	return saveRecord(path, &e2eRecord{Id: id, Hash: hash, RemoteHash: remoteHash})
+}
+
+// Records that local file path, read from file, now holds the
+// contents of its remote copy, which hashes to remoteHash
+func recordDownload(path string, file io.Reader, remoteHash string) error {
+	record := loadRecord(path)
+	if record == nil {
+		return fmt.Errorf("file %v has no encryption header", path)
+	}
+	hash, err := lib.HashFile(file)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	record.RemoteHash = remoteHash
+	return saveRecord(path, record)
+}
+
+// Forgets the remote hash of path once its remote copy has changed in
+// a way we can't hash
+func forgetRemoteHash(path string) {
+	record := loadRecord(path)
+	if record == nil || record.RemoteHash == "" {
+		return
+	}
+	record.Hash = ""
+	record.RemoteHash = ""
+	saveRecord(path, record)
+}
+
+// Hash of the remote copy of local file path, if the local contents
+// read from file are the ones it was last in sync with. Otherwise
+// returns a hash no remote file has
+func encryptedFileHash(path string, file io.Reader) (string, error) {
+	hash, err := lib.HashFile(file)
+	if err != nil {
+		return "", err
+	}
+	record := loadRecord(path)
+	if record == nil || record.RemoteHash == "" || record.Hash != hash {
+		return "local:" + hash, nil
+	}
+	return record.RemoteHash, nil
+}
+
+// Reports whether an interrupted download of path that got up to
+// remote offset off can pick up from there. Only whole chunks are
+// written out, and the file id from the header must be known
+func e2eResumable(path string, off int64) bool {
+	if off < int64(E2E_HEADER_SIZE) || loadRecord(path) == nil {
+		return false
+	}
+	return (off-int64(E2E_HEADER_SIZE))%E2E_SEALED_CHUNK_SIZE == 0
+}
+
+// Writes a downloaded encrypted file to its plaintext local copy.
+// Offsets passed to WriteAt are offsets within the remote file, header
+// included, and must come in order. Chunks are opened once all of them
+// has arrived
+type decryptingWriter struct {
+	file   *os.File
+	path   string
+	header []byte
+	id     []byte
+
+	// Size of the remote file, which tells the last chunk
+	size int64
+
+	// Remote offset up to which everything has been written out
+	done int64
+	buf  []byte
+}
+
+// Returns a writer for path. When resuming a download the header was
+// received on an earlier attempt so the stored file id is used
+func newDecryptingWriter(file *os.File, path string, size int64) *decryptingWriter {
+	w := &decryptingWriter{
+		file: file,
+		path: path,
+		size: size,
+	}
+	if record := loadRecord(path); record != nil {
+		w.id = record.Id
+	}
+	return w
+}
+
+func (w *decryptingWriter) WriteAt(data []byte, off int64) (int, error) {
+	n := len(data)
+
+	if off < int64(E2E_HEADER_SIZE) {
+		if off != int64(len(w.header)) {
+			return 0, fmt.Errorf("encryption header of %v received out of order", w.path)
+		}
+		take := min(len(data), E2E_HEADER_SIZE-len(w.header))
+		w.header = append(w.header, data[:take]...)
+		data = data[take:]
+		off += int64(take)
+
+		if len(w.header) == E2E_HEADER_SIZE {
+			id, err := parseHeader(w.header)
+			if err != nil {
+				return 0, fmt.Errorf("file %v; %v", w.path, err)
+			}
+			w.id = id
+			w.done = int64(E2E_HEADER_SIZE)
+			err = saveRecord(w.path, &e2eRecord{Id: id})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(data) == 0 {
+		return n, nil
+	}
+	if w.id == nil {
+		return 0, fmt.Errorf("file %v has no encryption header", w.path)
+	}
+	if w.done == 0 {
+		// Resumed download
+		w.done = off
+	}
+	if off != w.done+int64(len(w.buf)) {
+		return 0, fmt.Errorf("chunks of %v received out of order", w.path)
+	}
+
+	for len(data) > 0 {
+		take := min(len(data), E2E_SEALED_CHUNK_SIZE-len(w.buf))
+		w.buf = append(w.buf, data[:take]...)
+		data = data[take:]
+		if len(w.buf) == E2E_SEALED_CHUNK_SIZE || w.done+int64(len(w.buf)) == w.size {
+			err := w.flush()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Opens the buffered chunk and writes it out
+func (w *decryptingWriter) flush() error {
+	index := (w.done - int64(E2E_HEADER_SIZE)) / E2E_SEALED_CHUNK_SIZE
+	last := w.done+int64(len(w.buf)) == w.size
+
+	buf := lib.GetChunk()
+	defer lib.PutChunk(buf)
+	plain, err := openChunk((*buf)[:0], w.id, w.buf, index, last)
+	if err != nil {
+		return fmt.Errorf("file %v; %v", w.path, err)
+	}
+	_, err = writeSparse(w.file, plain, index*E2E_CHUNK_SIZE)
+	if err != nil {
+		return err
+	}
+	w.done += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Size of the local plaintext copy of a remote file of size size
+func plaintextSize(size int64) int64 {
+	if !e2eEnabled() {
+		return size
+	}
+	body := size - int64(E2E_HEADER_SIZE)
+	if body <= 0 {
+		return 0
+	}
+	full := body / E2E_SEALED_CHUNK_SIZE
+	rest := body % E2E_SEALED_CHUNK_SIZE
+	return full*E2E_CHUNK_SIZE + max(0, rest-E2E_OVERHEAD)
+}
+
+// Size of the remote copy of a file of size plaintext bytes
+func sealedSize(size int64) int64 {
+	full := size / E2E_CHUNK_SIZE
+	rest := size % E2E_CHUNK_SIZE
+	sealed := int64(E2E_HEADER_SIZE) + full*E2E_SEALED_CHUNK_SIZE
+	if rest > 0 {
+		sealed += rest + E2E_OVERHEAD
+	}
+	return sealed
+}
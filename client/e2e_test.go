@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Turns -e2e on with a random key for the length of the test
+func withE2E(t *testing.T) {
+	t.Helper()
+	key := make([]byte, E2E_KEY_SIZE)
+	rand.Read(key)
+	e2eAEAD = newAEAD(key)
+	t.Cleanup(func() { e2eAEAD = nil })
+}
+
+// Returns a file the test can keep e2e records on
+func recordFile(t *testing.T) *os.File {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	err = syscall.Setxattr(file.Name(), E2E_XATTR, []byte("{}"), 0)
+	if err != nil {
+		t.Skipf("Temporary directory has no user xattrs; %v", err)
+	}
+	return file
+}
+
+// Decrypts sealed into file, passing it to the writer step bytes at a
+// time
+func decryptInto(file *os.File, sealed []byte, step int) error {
+	w := newDecryptingWriter(file, file.Name(), int64(len(sealed)))
+	for off := 0; off < len(sealed); off += step {
+		end := min(off+step, len(sealed))
+		_, err := w.WriteAt(sealed[off:end], int64(off))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	withE2E(t)
+
+	sizes := []int{0, 1, E2E_CHUNK_SIZE - 1, E2E_CHUNK_SIZE, E2E_CHUNK_SIZE + 1, 3*E2E_CHUNK_SIZE + 5}
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		id, sealed := sealFile(plain)
+
+		if got := int64(len(sealed)); got != sealedSize(int64(size)) {
+			t.Errorf("size %v: sealed %v bytes; sealedSize says %v", size, got, sealedSize(int64(size)))
+		}
+		if got := plaintextSize(int64(len(sealed))); got != int64(size) {
+			t.Errorf("size %v: plaintextSize = %v", size, got)
+		}
+
+		// Chunk boundaries must not matter to the writer
+		for _, step := range []int{7, 4096, len(sealed)} {
+			file := recordFile(t)
+			err := decryptInto(file, sealed, step)
+			if err != nil {
+				t.Fatalf("size %v step %v: %v", size, step, err)
+			}
+			got, _ := os.ReadFile(file.Name())
+			if !bytes.Equal(got, plain) {
+				t.Errorf("size %v step %v: decrypted contents differ", size, step)
+			}
+			record := loadRecord(file.Name())
+			if record == nil || !bytes.Equal(record.Id, id) {
+				t.Errorf("size %v step %v: record = %+v; want id %x", size, step, record, id)
+			}
+		}
+	}
+}
+
+func TestSealedFilesDiffer(t *testing.T) {
+	withE2E(t)
+
+	plain := []byte("same contents")
+	_, a := sealFile(plain)
+	_, b := sealFile(plain)
+	if bytes.Equal(a, b) {
+		t.Error("sealing the same contents twice gave the same ciphertext")
+	}
+}
+
+func TestSealDetectsTampering(t *testing.T) {
+	withE2E(t)
+
+	plain := make([]byte, 2*E2E_CHUNK_SIZE+100)
+	rand.Read(plain)
+	_, sealed := sealFile(plain)
+	_, other := sealFile(plain)
+
+	flipped := bytes.Clone(sealed)
+	flipped[E2E_HEADER_SIZE+E2E_NONCE_SIZE+10] ^= 1
+
+	// Dropping the last chunk leaves a file whose new last chunk
+	// wasn't sealed as the last one
+	truncated := sealed[:E2E_HEADER_SIZE+2*E2E_SEALED_CHUNK_SIZE]
+
+	swapped := bytes.Clone(sealed)
+	first := E2E_HEADER_SIZE
+	second := E2E_HEADER_SIZE + E2E_SEALED_CHUNK_SIZE
+	copy(swapped[first:second], sealed[second:second+E2E_SEALED_CHUNK_SIZE])
+	copy(swapped[second:second+E2E_SEALED_CHUNK_SIZE], sealed[first:second])
+
+	// A chunk from another file under the same key
+	spliced := bytes.Clone(sealed)
+	copy(spliced[first:second], other[first:second])
+
+	tests := map[string][]byte{
+		"flipped bit":   flipped,
+		"truncated":     truncated,
+		"swapped":       swapped,
+		"spliced":       spliced,
+		"missing magic": append([]byte("XXXX"), sealed[4:]...),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := recordFile(t)
+			err := decryptInto(file, data, 4096)
+			if err == nil {
+				t.Error("tampered file decrypted without error")
+			}
+		})
+	}
+}
+
+func TestSealingWriterMatchesSealFile(t *testing.T) {
+	withE2E(t)
+
+	plain := make([]byte, 2*E2E_CHUNK_SIZE+3)
+	rand.Read(plain)
+
+	var sealed []byte
+	w, err := newSealingWriter(int64(len(plain)), func(data []byte) error {
+		sealed = append(sealed, data...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(plain); off += 1000 {
+		w.Write(plain[off:min(off+1000, len(plain))])
+	}
+	_, err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(sealed)) != sealedSize(int64(len(plain))) {
+		t.Fatalf("sealed %v bytes; want %v", len(sealed), sealedSize(int64(len(plain))))
+	}
+	file := recordFile(t)
+	err = decryptInto(file, sealed, len(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(file.Name())
+	if !bytes.Equal(got, plain) {
+		t.Error("decrypted contents differ")
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	keyFile, err := newKeyFile("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newKeyFile("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(keyFile[:E2E_SALT_SIZE], other[:E2E_SALT_SIZE]) {
+		t.Error("two volumes got the same salt")
+	}
+
+	_, err = openKeyFile(keyFile, "correct horse")
+	if err != nil {
+		t.Errorf("right passphrase refused; %v", err)
+	}
+	_, err = openKeyFile(keyFile, "battery staple")
+	if err != errBadPassphrase {
+		t.Errorf("wrong passphrase gave %v; want %v", err, errBadPassphrase)
+	}
+	_, err = openKeyFile(keyFile[:10], "correct horse")
+	if err == nil {
+		t.Error("short key file accepted")
+	}
+}
+
+// Keeps the last whole file written to it
+type storeServer struct {
+	proto.UnimplementedFuseServer
+
+	mu     sync.Mutex
+	stored []byte
+}
+
+func (s *storeServer) Write(ctx context.Context, req *proto.WriteRequest) (*proto.WriteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored = append([]byte(nil), req.Data...)
+	return &proto.WriteResponse{BytesWritten: uint64(len(req.Data))}, nil
+}
+
+// Content uploaded with -e2e reaches remote only as ciphertext and
+// comes back as the plaintext once downloaded
+func TestE2ERoundTripThroughRemote(t *testing.T) {
+	useTestQueue(t)
+	withE2E(t)
+	srv := &storeServer{}
+	useTestRemote(t, srv)
+
+	plain := bytes.Repeat([]byte("the server must never read this. "), 1000)
+	err := os.WriteFile(localPath("/secret"), plain, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syscall.Setxattr(localPath("/secret"), "user.test", nil, 0) != nil {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+	err = uploadLocal(context.Background(), "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.stored) == 0 {
+		t.Fatal("nothing uploaded")
+	}
+	if bytes.Contains(srv.stored, plain[:32]) {
+		t.Error("remote stores the plaintext")
+	}
+
+	// Another machine with the same key downloads it
+	os.Remove(localPath("/secret"))
+	useTestRemote(t, &resumeServer{contents: srv.stored, dropAfter: len(srv.stored) + 1})
+	err = downloadFile(&proto.DirEntry{Path: "/secret", Mode: 0644})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(localPath("/secret"))
+	if !bytes.Equal(got, plain) {
+		t.Errorf("downloaded %v bytes that differ from the %v uploaded", len(got), len(plain))
+	}
+}
@@ -6,11 +6,18 @@ package main
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -18,22 +25,112 @@ import (
 )
 
 type FileHandle struct {
-	mu   sync.Mutex
-	fd   int
-	path string
+	mu    sync.Mutex
+	fd    int
+	path  string
+	flags uint32
+
+	// Result of creating the file on remote. Only set on handles
+	// returned by Create
+	remoteCreate <-chan error
+
+	// Set once the file passed verifyIntegrity; see -verify-reads
+	verified bool
+
+	// Files opened with O_TRUNC are being rewritten from scratch.
+	// Their writes are held back and the whole file is uploaded in
+	// one piece on close; see uploadRewrite
+	rewrite bool
+	dirty   bool
+
+	// Stream sequential writes are sent over and where the last
+	// write ended; see uploadStream
+	upload   *uploadStream
+	writeEnd int64
+
+	// Set once the times of a rewritten file were set explicitly, eg.
+	// by cp -p. The upload on close replaces the remote copy, times
+	// and all, so they are sent again after it
+	timesSet bool
+
+	// Closed once the last remote op the handle started is done; see
+	// sendInOrder
+	lastSent chan struct{}
+}
+
+// Runs send in the background once the remote ops the handle started
+// before it are done, so a truncate can't overtake the writes made
+// before it, nor a write the truncate before it. A nil handle runs
+// send right away. Called with fh.mu held
+func (fh *FileHandle) sendInOrder(send func()) {
+	if fh == nil {
+		go send()
+		return
+	}
+	prev := fh.lastSent
+	done := make(chan struct{})
+	fh.lastSent = done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		send()
+	}()
+}
+
+// Waits for the remote ops the handle started to be done. Called with
+// fh.mu held
+func (fh *FileHandle) waitSent() {
+	if fh.lastSent != nil {
+		<-fh.lastSent
+	}
 }
 
 // NewLoopbackFile creates a FileHandle out of a file descriptor. All
 // operations are implemented. When using the Fd from a *os.File, call
 // syscall.Dup() on the fd, to avoid os.File's finalizer from closing
 // the file descriptor.
-func NewLoopbackFile(fd int, path string) fs.FileHandle {
+func NewLoopbackFile(fd int, path string, flags uint32) fs.FileHandle {
 	return &FileHandle{
-		fd:   fd,
-		path: path,
+		fd:      fd,
+		path:    path,
+		flags:   flags,
+		rewrite: isRewrite(flags),
 	}
 }
 
+// Returns the handle of a file created in this session. remoteCreate
+// receives the result of creating the file on remote
+func newCreatedFile(fd int, path string, flags uint32, remoteCreate <-chan error) fs.FileHandle {
+	return &FileHandle{
+		fd:           fd,
+		path:         path,
+		flags:        flags,
+		remoteCreate: remoteCreate,
+		rewrite:      isRewrite(flags),
+	}
+}
+
+func isRewrite(flags uint32) bool {
+	return flags&syscall.O_TRUNC != 0 && int(flags)&syscall.O_ACCMODE != syscall.O_RDONLY
+}
+
+// Returns a descriptor the contents of fh can be read through, and a
+// function to release it. Handles opened write-only, the way most
+// programs write a file out, can't be read from; the file they have
+// open is opened again for reading
+func (fh *FileHandle) readFd() (int, func(), error) {
+	if int(fh.flags)&syscall.O_ACCMODE != syscall.O_WRONLY {
+		return fh.fd, func() {}, nil
+	}
+	fd, err := syscall.Open(fmt.Sprintf("/proc/self/fd/%v", fh.fd), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	return fd, func() { syscall.Close(fd) }, nil
+}
+
 var _ = (fs.FileHandle)((*FileHandle)(nil))
 var _ = (fs.FileReleaser)((*FileHandle)(nil))
 var _ = (fs.FileGetattrer)((*FileHandle)(nil))
@@ -54,13 +151,32 @@ func (fh *FileHandle) Read(ctx context.Context, buf []byte, off int64) (res fuse
 	defer fh.mu.Unlock()
 	log.Printf("[FUSE] Read file %v\n", fh.path)
 
-	// Before reading a file, we are going to download remote updates
 	remote := proto.DirEntry{
 		Path: relativePath(fh.path),
 	}
-	err := downloadFile(&remote)
-	if err != nil {
-		log.Printf("[SYNC] Error syncing file %v with remote; %v\n", fh.path, err)
+
+	if verifyReads && !fh.verified {
+		if !verifyIntegrity(fh.path) {
+			// Local copy is corrupt; never serve it. Fetch a fresh
+			// copy for the next read
+			log.Printf("[FUSE] File %v failed integrity check; re-downloading\n", fh.path)
+			go func() {
+				err := downloadFile(&remote)
+				if err != nil {
+					log.Printf("[SYNC] Error repairing file %v; %v\n", fh.path, err)
+				}
+			}()
+			return nil, syscall.EIO
+		}
+		fh.verified = true
+	}
+
+	// Before reading a file, we are going to download remote updates
+	if online.Load() {
+		err := downloadFile(&remote)
+		if err != nil {
+			log.Printf("[SYNC] Error syncing file %v with remote; %v\n", fh.path, err)
+		}
 	}
 
 	r := fuse.ReadResultFd(uintptr(fh.fd), off, len(buf))
@@ -72,43 +188,174 @@ func (fh *FileHandle) Write(ctx context.Context, data []byte, off int64) (uint32
 	defer fh.mu.Unlock()
 	log.Printf("[FUSE] Write file %v\n", fh.path)
 
+	errno, retryFull := remoteFullErrno(relativePath(fh.path))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	// Encrypted writes past the end re-seal the chunk that used to be
+	// last
+	sizeBefore := int64(0)
+	if e2eEnabled() {
+		st := syscall.Stat_t{}
+		if syscall.Fstat(fh.fd, &st) == nil {
+			sizeBefore = st.Size
+		}
+	}
+
 	n, err := syscall.Pwrite(fh.fd, data, off)
 	if err != nil {
 		log.Printf("[FUSE] Error writing to file; %v\n", err)
 		return 0, fs.ToErrno(err)
 	}
+	localStatfs.AddUsage(int64(n))
+	if fh.flags&syscall.O_APPEND != 0 {
+		// pwrite(2) ignores off on O_APPEND descriptors and writes
+		// at the end of the file
+		st := syscall.Stat_t{}
+		if syscall.Fstat(fh.fd, &st) == nil {
+			off = st.Size - int64(n)
+		}
+	}
+	fh.verified = false
+	clearHash(fh.path)
+
+	if fh.rewrite {
+		fh.dirty = true
+		return uint32(n), fs.OK
+	}
 
 	// Write remote file
 	relativePath := relativePath(fh.path)
+	if syncIgnored(relativePath) {
+		return uint32(n), fs.OK
+	}
+	upload := queuedOp{Op: OP_UPLOAD, Path: relativePath}
+	if !online.Load() {
+		enqueue(upload)
+		return uint32(n), fs.OK
+	}
+	if retryFull {
+		// Remote missed the writes it refused; send all of it
+		fh.sendInOrder(func() {
+			err := sendOrQueue(upload, func(ctx context.Context) error {
+				return uploadLocal(ctx, relativePath)
+			})
+			if err != nil {
+				markRemoteFull(relativePath, err)
+				log.Printf("[FUSE] Error uploading file %v; %v\n", relativePath, err)
+				return
+			}
+			clearRemoteFull(relativePath)
+		})
+		return uint32(n), fs.OK
+	}
 
-	go func(path string) {
-		ctx = NewAuthenticatedCtx(ctx)
-		_, err := grpcClient.Write(ctx, &proto.WriteRequest{
-			Path:   path,
-			Offset: off,
-			Data:   data,
+	streamed := fh.streamsWrite(off, n)
+	fh.writeEnd = off + int64(n)
+
+	request := &proto.WriteRequest{
+		Path:   relativePath,
+		Offset: off,
+		Data:   data,
+		Append: fh.flags&syscall.O_APPEND != 0,
+	}
+	if e2eEnabled() {
+		// Chunks are sealed at fixed offsets so remote can't be left
+		// to pick them for appends
+		fd, release, err := fh.readFd()
+		if err == nil {
+			request.Data, request.Offset, err = encryptWrite(fd, fh.path, off, off+int64(n), sizeBefore)
+			release()
+		}
+		if err != nil {
+			log.Printf("[FUSE] Error encrypting write; %v\n", err)
+			enqueue(upload)
+			return uint32(n), fs.OK
+		}
+		request.Append = false
+	}
+
+	if streamed {
+		fh.streamWrite(relativePath, request.Data, request.Offset, request.Append)
+		return uint32(n), fs.OK
+	}
+
+	fh.sendInOrder(func() {
+		err := sendOrQueue(upload, func(ctx context.Context) error {
+			_, err := grpcClient.Write(ctx, request)
+			return err
 		})
 		if err != nil {
+			markRemoteFull(relativePath, err)
 			log.Printf("[FUSE] Error writing to remote file; %v\n", err)
 		}
-	}(relativePath)
+	})
 
 	return uint32(n), fs.ToErrno(err)
 }
 
 func (fh *FileHandle) Release(ctx context.Context) syscall.Errno {
 	fh.mu.Lock()
-	defer fh.mu.Unlock()
+	fh.finishUpload()
+	fh.uploadRewrite()
+	created := fh.remoteCreate
+	empty := fh.isEmpty()
 
 	// Attempt to close the file descriptor.
 	if fh.fd != -1 {
 		syscall.Close(fh.fd)
 		fh.fd = -1
+		releaseHandle()
 	}
+	fh.mu.Unlock()
+
+	if created != nil && empty {
+		// Waiting on remote must not hold up close(2)
+		go removeIfOrphan(fh.path, created)
+	}
+
 	// Always return OK.
 	return fs.OK
 }
 
+// Reports whether nothing was written to the handle's file. Called
+// with fh.mu held
+func (fh *FileHandle) isEmpty() bool {
+	if fh.fd == -1 {
+		return false
+	}
+	st := syscall.Stat_t{}
+	err := syscall.Fstat(fh.fd, &st)
+	return err == nil && st.Size == 0
+}
+
+// Removes path, a file created in this session that was released
+// empty, if remote failed to create it too. Empty files that made it
+// to remote were meant to be empty (think touch) and are left alone
+func removeIfOrphan(path string, created <-chan error) {
+	select {
+	case err := <-created:
+		if err == nil {
+			return
+		}
+	case <-time.After(5 * time.Second):
+		// Remote has not answered yet; we cannot tell
+		return
+	}
+
+	// Written to through another handle in the meantime
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+		return
+	}
+	log.Printf("[FUSE] Removing orphaned file %v\n", path)
+	err = os.Remove(path)
+	if err != nil {
+		log.Printf("[FUSE] Error removing orphaned file; %v\n", err)
+	}
+}
+
 func (fh *FileHandle) Flush(ctx context.Context) syscall.Errno {
 	fh.mu.Lock()
 	defer fh.mu.Unlock()
@@ -116,13 +363,352 @@ func (fh *FileHandle) Flush(ctx context.Context) syscall.Errno {
 	// Written files are never flushed.
 	// This is bad. But so long as it saves me from debugging file
 	// not found errors, I will keep it this way.
+	// Rewritten files are the exception; remote only gets them here.
+	// Streamed writes are waited for too
+	fh.finishUpload()
+	fh.uploadRewrite()
+
+	// Let close(2) report that remote never got the file
+	if remoteFullRefused(relativePath(fh.path)) {
+		return syscall.ENOSPC
+	}
 	return fs.OK
 }
 
+// Truncates remote copy of local file path to size bytes. Sent after
+// the remote ops fh started before it; fh may be nil
+func truncateRemote(fh *FileHandle, path string, size int64) {
+	clearHash(path)
+	relativePath := relativePath(path)
+
+	op := queuedOp{Op: OP_TRUNCATE, Path: relativePath, Size: size}
+	if e2eEnabled() {
+		// Bytes added by extending a file must be encrypted too; send
+		// the whole file instead
+		op = queuedOp{Op: OP_UPLOAD, Path: relativePath}
+	}
+
+	fh.sendInOrder(func() {
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			if op.Op == OP_UPLOAD {
+				return uploadLocal(ctx, op.Path)
+			}
+			remoteSize := uint64(size)
+			_, err := grpcClient.Setattr(ctx, &proto.SetattrRequest{
+				Path: op.Path,
+				Size: &remoteSize,
+			})
+			return err
+		})
+		if err != nil {
+			log.Printf("[FUSE] Error truncating remote file; %v\n", err)
+		}
+	})
+}
+
+// Sends the attributes the kernel marked valid in `in` to remote as a
+// single Setattr. The size is left out unless withSize is set, eg.
+// for files that are uploaded whole on close. Sent after the remote ops
+// fh started before it; fh may be nil
+func setattrRemote(fh *FileHandle, path string, in *fuse.SetAttrIn, withSize bool) {
+	attrs := queuedAttrs{}
+	if mode, ok := in.GetMode(); ok {
+		attrs.Mode = &mode
+	}
+	if atime, ok := in.GetATime(); ok {
+		attrs.ATime = &atime
+	}
+	if mtime, ok := in.GetMTime(); ok {
+		attrs.MTime = &mtime
+	}
+	if size, ok := in.GetSize(); ok && withSize {
+		if e2eEnabled() {
+			// Needs the whole file re-encrypted; see truncateRemote
+			truncateRemote(fh, path, int64(size))
+		} else {
+			clearHash(path)
+			attrs.Size = &size
+		}
+	}
+	if attrs == (queuedAttrs{}) {
+		return
+	}
+
+	op := queuedOp{Op: OP_SETATTR, Path: relativePath(path), Attrs: &attrs}
+
+	// Pending from now, not from when the send gets to run, so a stat
+	// right after a truncate doesn't get remote's old size
+	sent := beginSend(op)
+	fh.sendInOrder(func() {
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Setattr(ctx, attrs.request(op.Path))
+			return err
+		})
+		sent()
+		if err != nil {
+			log.Printf("[FUSE] Error setting attributes of remote file; %v\n", err)
+		}
+	})
+}
+
+// Sends the chattr flags of path to remote after the remote ops fh
+// started before it
+func setFlagsRemote(fh *FileHandle, path string, flags uint32) {
+	attrs := queuedAttrs{Flags: &flags}
+	op := queuedOp{Op: OP_SETATTR, Path: relativePath(path), Attrs: &attrs}
+	fh.sendInOrder(func() {
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Setattr(ctx, attrs.request(op.Path))
+			return err
+		})
+		if err != nil {
+			log.Printf("[FUSE] Error setting flags of remote file; %v\n", err)
+		}
+	})
+}
+
+// Applies the access and modification times the kernel marked valid
+// in `in` to local file path
+func setTimes(path string, in *fuse.SetAttrIn) syscall.Errno {
+	atime, atimeOK := in.GetATime()
+	mtime, mtimeOK := in.GetMTime()
+	if !atimeOK && !mtimeOK {
+		return fs.OK
+	}
+
+	var atimeP, mtimeP *time.Time
+	if atimeOK {
+		atimeP = &atime
+	}
+	if mtimeOK {
+		mtimeP = &mtime
+	}
+	return fs.ToErrno(lib.SetTimes(path, atimeP, mtimeP))
+}
+
+// Files bigger than this do not fit in a single gRPC message and are
+// uploaded chunk by chunk instead, losing the atomic replace
+const MAX_REPLACE_SIZE = 3 * 1024 * 1024 // 3Mb
+
+// Sends the whole contents of a rewritten file to remote, which swaps
+// it in atomically. Called with fh.mu held
+func (fh *FileHandle) uploadRewrite() {
+	if fh.fd == -1 {
+		return
+	}
+	if dc := claimDeferredCreate(relativePath(fh.path)); dc != nil {
+		if fh.putNew(dc) {
+			return
+		}
+		dc.create()
+	}
+	if !fh.dirty {
+		return
+	}
+	fh.dirty = false
+
+	st := syscall.Stat_t{}
+	err := syscall.Fstat(fh.fd, &st)
+	if err != nil {
+		log.Printf("[FUSE] Error uploading file %v; %v\n", fh.path, err)
+		return
+	}
+
+	// Remote has to know the file before we can replace it
+	if fh.remoteCreate != nil {
+		select {
+		case err := <-fh.remoteCreate:
+			if err != nil {
+				log.Printf("[FUSE] Not uploading file %v; remote create failed\n", fh.path)
+				return
+			}
+		case <-time.After(5 * time.Second):
+		}
+		fh.remoteCreate = nil
+	}
+
+	fh.waitSent()
+	op := queuedOp{Op: OP_UPLOAD, Path: relativePath(fh.path)}
+	err = sendOrQueue(op, func(ctx context.Context) error {
+		fd, release, err := fh.readFd()
+		if err != nil {
+			return err
+		}
+		defer release()
+		return uploadFile(ctx, fh.path, fd, st.Size)
+	})
+	if err != nil {
+		markRemoteFull(op.Path, err)
+		log.Printf("[FUSE] Error uploading file %v; %v\n", fh.path, err)
+		return
+	}
+	clearRemoteFull(op.Path)
+	fh.resendTimes()
+}
+
+// Sends the local times of a rewritten file whose times were set
+// explicitly once its upload replaced the remote copy. Called with
+// fh.mu held
+func (fh *FileHandle) resendTimes() {
+	if !fh.timesSet {
+		return
+	}
+	st := syscall.Stat_t{}
+	err := syscall.Fstat(fh.fd, &st)
+	if err != nil {
+		return
+	}
+	atime := time.Unix(st.Atim.Unix())
+	mtime := time.Unix(st.Mtim.Unix())
+	attrs := queuedAttrs{ATime: &atime, MTime: &mtime}
+
+	op := queuedOp{Op: OP_SETATTR, Path: relativePath(fh.path), Attrs: &attrs}
+	fh.sendInOrder(func() {
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Setattr(ctx, attrs.request(op.Path))
+			return err
+		})
+		if err != nil {
+			log.Printf("[FUSE] Error setting times of remote file; %v\n", err)
+		}
+	})
+}
+
+// Sends size bytes read from fd to remote as the new contents of
+// local file fullpath
+func uploadFile(ctx context.Context, fullpath string, fd int, size int64) error {
+	path := relativePath(fullpath)
+
+	ctx, tr, done := startTransfer(ctx, path, TRANSFER_UPLOAD)
+	defer done()
+
+	sent := size
+	if e2eEnabled() {
+		sent = sealedSize(size)
+	}
+	if sent > MAX_REPLACE_SIZE {
+		log.Printf("[FUSE] File %v too large to replace atomically; uploading in chunks\n", fullpath)
+
+		if e2eEnabled() {
+			return uploadSealed(ctx, tr, fullpath, fd, size)
+		}
+
+		buf := make([]byte, 1024*1024)
+		for off := int64(0); off < size; {
+			n, err := syscall.Pread(fd, buf, off)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return io.ErrUnexpectedEOF
+			}
+			_, err = grpcClient.Write(ctx, &proto.WriteRequest{
+				Path:   path,
+				Offset: off,
+				Data:   buf[:n],
+			})
+			if err != nil {
+				return err
+			}
+			off += int64(n)
+			err = tr.moved(ctx, n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	data := make([]byte, size)
+	n, err := syscall.Pread(fd, data, 0)
+	if err != nil {
+		return err
+	}
+	data = data[:n]
+
+	// Replacing the whole file is a good time to move to a new file id
+	var id []byte
+	plain := data
+	if e2eEnabled() {
+		id, data = sealFile(data)
+	}
+
+	_, err = grpcClient.Write(ctx, &proto.WriteRequest{
+		Path:    path,
+		Data:    data,
+		Replace: true,
+	})
+	if err != nil {
+		return err
+	}
+	// Sent already; a cancel only cuts the wait short
+	tr.moved(ctx, len(data))
+	if id != nil {
+		hash := md5.Sum(plain)
+		remoteHash := md5.Sum(data)
+		return recordUpload(fullpath, id, hex.EncodeToString(hash[:]), hex.EncodeToString(remoteHash[:]))
+	}
+	return nil
+}
+
+// Encrypts size bytes read from fd and writes them to remote chunk by
+// chunk as the new contents of local file fullpath
+func uploadSealed(ctx context.Context, tr *transfer, fullpath string, fd int, size int64) error {
+	path := relativePath(fullpath)
+	off := int64(0)
+	sealer, err := newSealingWriter(size, func(sealed []byte) error {
+		_, err := grpcClient.Write(ctx, &proto.WriteRequest{
+			Path:   path,
+			Offset: off,
+			Data:   sealed,
+		})
+		off += int64(len(sealed))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	buf := make([]byte, 1024*1024)
+	for read := int64(0); read < size; {
+		n, err := syscall.Pread(fd, buf, read)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		hash.Write(buf[:n])
+		_, err = sealer.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+		read += int64(n)
+		err = tr.moved(ctx, n)
+		if err != nil {
+			return err
+		}
+	}
+	remoteHash, err := sealer.Close()
+	if err != nil {
+		return err
+	}
+
+	// Chunks left over from a longer file would no longer open
+	remoteSize := uint64(off)
+	_, err = grpcClient.Setattr(ctx, &proto.SetattrRequest{Path: path, Size: &remoteSize})
+	if err != nil {
+		return err
+	}
+	return recordUpload(fullpath, sealer.id, hex.EncodeToString(hash.Sum(nil)), remoteHash)
+}
+
 func (fh *FileHandle) Fsync(ctx context.Context, flags uint32) (errno syscall.Errno) {
 	fh.mu.Lock()
 	defer fh.mu.Unlock()
 	log.Printf("[FUSE] Fsync file %v\n", fh.path)
+	fh.finishUpload()
 
 	// Check if the file still exists, and if so, perform the sync.
 	if _, err := os.Stat(fh.path); err == nil {
@@ -199,41 +785,39 @@ func (fh *FileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse
 		}
 	}
 
-	uid32, uOk := in.GetUID()
-	gid32, gOk := in.GetGID()
-	if uOk || gOk {
-		uid := -1
-		gid := -1
+	// Owners are left alone; Node.Setattr refuses a chown changing
+	// them before it gets here
 
-		if uOk {
-			uid = int(uid32)
-		}
-		if gOk {
-			gid = int(gid32)
-		}
-
-		// Change owner
-		err := syscall.Fchown(fh.fd, uid, gid)
-		if err != nil {
-			return fs.ToErrno(err)
-		}
+	errno := setTimes(fmt.Sprintf("/proc/self/fd/%v", fh.fd), in)
+	if errno != fs.OK {
+		return errno
 	}
 
-	// mtime, mok := in.GetMTime()
-	// atime, aok := in.GetATime()
-
-	// if mok || aok {
-	// 	// Change modified and access times
-	// }
-
-	size, ok := in.GetSize()
-	if ok {
+	size, sizeOK := in.GetSize()
+	if sizeOK {
 		err := syscall.Ftruncate(fh.fd, int64(size))
 		if err != nil {
 			return fs.ToErrno(err)
 		}
 	}
 
+	fh.mu.Lock()
+	// Writes already made come before the new attributes
+	fh.finishUpload()
+	// A rewritten file is sent whole on close, size and all
+	withSize := !fh.rewrite
+	_, atimeOK := in.GetATime()
+	_, mtimeOK := in.GetMTime()
+	if fh.rewrite && (atimeOK || mtimeOK) {
+		fh.timesSet = true
+	}
+	if sizeOK {
+		fh.dirty = fh.dirty || fh.rewrite
+		fh.verified = false
+	}
+	setattrRemote(fh, fh.path, in, withSize)
+	fh.mu.Unlock()
+
 	return fh.Getattr(ctx, out)
 }
 
@@ -246,6 +830,7 @@ func (fh *FileHandle) Getattr(ctx context.Context, a *fuse.AttrOut) syscall.Errn
 		return fs.ToErrno(err)
 	}
 	a.FromStat(&st)
+	showOwner(fh.path, &a.Attr)
 
 	return fs.OK
 }
@@ -253,6 +838,23 @@ func (fh *FileHandle) Getattr(ctx context.Context, a *fuse.AttrOut) syscall.Errn
 func (fh *FileHandle) Lseek(ctx context.Context, off uint64, whence uint32) (uint64, syscall.Errno) {
 	fh.mu.Lock()
 	defer fh.mu.Unlock()
+
+	switch whence {
+	case unix.SEEK_SET, unix.SEEK_CUR, unix.SEEK_END:
+	case unix.SEEK_DATA, unix.SEEK_HOLE:
+		// POSIX; there is neither data nor a hole at or past EOF
+		st := syscall.Stat_t{}
+		err := syscall.Fstat(fh.fd, &st)
+		if err != nil {
+			return 0, fs.ToErrno(err)
+		}
+		if int64(off) >= st.Size {
+			return 0, syscall.ENXIO
+		}
+	default:
+		return 0, syscall.EINVAL
+	}
+
 	n, err := unix.Seek(fh.fd, int64(off), int(whence))
 	return uint64(n), fs.ToErrno(err)
 }
@@ -267,6 +869,50 @@ func (fh *FileHandle) Lseek(ctx context.Context, off uint64, whence uint32) (uin
 // 	return fs.OK
 // }
 
+var _ = (fs.FileIoctler)((*FileHandle)(nil))
+
+// Gets and sets chattr flags on the backing file. Only the immutable
+// and append-only flags may be changed; they are sent on to remote,
+// which enforces them on its copy too
+func (fh *FileHandle) Ioctl(ctx context.Context, cmd uint32, arg uint64, input []byte, output []byte) (result int32, errno syscall.Errno) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	switch cmd {
+	case unix.FS_IOC_GETFLAGS:
+		if len(output) < 4 {
+			return 0, syscall.EINVAL
+		}
+		flags, err := lib.GetFileFlags(fh.fd)
+		if err != nil {
+			return 0, fs.ToErrno(err)
+		}
+		binary.NativeEndian.PutUint32(output, flags)
+		return 0, fs.OK
+
+	case unix.FS_IOC_SETFLAGS:
+		if len(input) < 4 {
+			return 0, syscall.EINVAL
+		}
+		flags := binary.NativeEndian.Uint32(input)
+		log.Printf("[FUSE] Set flags of %v to %#x\n", fh.path, flags)
+		if errno := refuseLocalChange("Set flags of", fh.path); errno != 0 {
+			return 0, errno
+		}
+
+		err := lib.SetFileFlags(fh.fd, flags)
+		if err != nil {
+			log.Printf("[FUSE] Error setting flags of %v; %v\n", fh.path, err)
+			return 0, fs.ToErrno(err)
+		}
+		setFlagsRemote(fh, fh.path, flags&lib.SYNCED_FILE_FLAGS)
+		return 0, fs.OK
+
+	default:
+		return 0, syscall.ENOTTY
+	}
+}
+
 // func (fh *FileHandle) Ioctl(ctx context.Context, cmd uint32, arg uint64, input []byte, output []byte) (result int32, errno syscall.Errno) {
 // 	fh.mu.Lock()
 // 	defer fh.mu.Unlock()
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Opens a new empty file and returns its handle as Create would, with
+// created standing in for remote's answer
+func createdHandle(t *testing.T, created chan error) (*FileHandle, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	fd, err := syscall.Open(path, syscall.O_CREAT|syscall.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := newCreatedFile(fd, path, syscall.O_RDWR, created).(*FileHandle)
+	return fh, path
+}
+
+// Release doesn't wait for remote to answer the create, and removes
+// the file once remote refuses it
+func TestReleaseRemovesOrphanInBackground(t *testing.T) {
+	created := make(chan error, 1)
+	fh, path := createdHandle(t, created)
+
+	start := time.Now()
+	fh.Release(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Release took %v waiting on remote", elapsed)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file removed before remote answered; %v", err)
+	}
+
+	created <- errors.New("create refused")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("orphaned file left behind")
+}
+
+func TestReleaseKeepsCreatedFile(t *testing.T) {
+	created := make(chan error, 1)
+	created <- nil
+	fh, path := createdHandle(t, created)
+
+	fh.Release(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("empty file remote created was removed; %v", err)
+	}
+}
+
+// Records the sizes files are truncated to
+type truncateServer struct {
+	proto.UnimplementedFuseServer
+	sizes chan *proto.SetattrRequest
+}
+
+func (s truncateServer) Setattr(ctx context.Context, req *proto.SetattrRequest) (*proto.FileAttr, error) {
+	s.sizes <- req
+	return &proto.FileAttr{}, nil
+}
+
+// Waits for remote to be sent a truncate of path to size
+func expectTruncate(t *testing.T, srv truncateServer, path string, size uint64) {
+	t.Helper()
+	select {
+	case req := <-srv.sizes:
+		if req.Path != path || req.Size == nil || *req.Size != size {
+			t.Errorf("remote Setattr = %v; want %v truncated to %v", req, path, size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("truncate of %v never reached remote", path)
+	}
+}
+
+// ftruncate(2) on an open file and truncate(2) by path both leave
+// remote at the new size
+func TestTruncateReachesRemote(t *testing.T) {
+	useTestQueue(t)
+	srv := truncateServer{sizes: make(chan *proto.SetattrRequest, 1)}
+	useTestRemote(t, srv)
+	online.Store(true)
+
+	err := os.WriteFile(filepath.Join(realpath, "file"), []byte("contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: 3}}
+	out := &fuse.AttrOut{}
+
+	fh := openHandle(t, "file", os.O_RDWR)
+	if errno := fh.Setattr(context.Background(), in, out); errno != 0 {
+		t.Fatalf("ftruncate = %v", errno)
+	}
+	if out.Size != 3 {
+		t.Errorf("size after ftruncate = %v; want 3", out.Size)
+	}
+	expectTruncate(t, srv, "/file", 3)
+
+	in.Size = 1
+	node := &Node{path: filepath.Join(realpath, "file")}
+	if errno := node.Setattr(context.Background(), nil, in, out); errno != 0 {
+		t.Fatalf("truncate = %v", errno)
+	}
+	expectTruncate(t, srv, "/file", 1)
+
+	got, _ := os.ReadFile(filepath.Join(realpath, "file"))
+	if string(got) != "c" {
+		t.Errorf("local file = %q; want %q", got, "c")
+	}
+}
+
+// Refuses every create
+type refuseCreateServer struct {
+	proto.UnimplementedFuseServer
+}
+
+func (refuseCreateServer) Create(ctx context.Context, req *proto.CreateRequest) (*proto.CreateResponse, error) {
+	return nil, status.Error(codes.PermissionDenied, "refused")
+}
+
+// With -sync-create a create remote refuses fails and leaves no local
+// file, inode or queued op behind
+func TestSyncCreateRefusedByRemote(t *testing.T) {
+	useTestInodes(t)
+	useTestQueue(t)
+	useTestRemote(t, refuseCreateServer{})
+	online.Store(true)
+	oldSync := syncCreate
+	syncCreate = true
+	t.Cleanup(func() { syncCreate = oldSync })
+
+	root := &Node{path: realpath}
+	raw := fs.NewNodeFS(root, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	code := raw.Create(nil, &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}, "file", &fuse.CreateOut{})
+	if code != fuse.Status(syscall.EPERM) && code != fuse.Status(syscall.EACCES) {
+		t.Errorf("Create refused by remote = %v; want a permission error", code)
+	}
+	if _, err := os.Lstat(localPath("/file")); !os.IsNotExist(err) {
+		t.Errorf("refused file left behind; %v", err)
+	}
+	if root.GetChild("file") != nil {
+		t.Error("refused file left in the tree")
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("refused create queued; %v", ops)
+	}
+	inodes.mu.Lock()
+	_, kept := inodes.Inos["/file"]
+	inodes.mu.Unlock()
+	if kept {
+		t.Error("refused file kept its inode number")
+	}
+}
+
+// fsync(2) on a directory flushes the local directory instead of
+// failing
+func TestFsyncDirectory(t *testing.T) {
+	useTestQueue(t)
+	node := &Node{path: realpath}
+	if errno := node.Fsync(t.Context(), nil, 0); errno != 0 {
+		t.Errorf("Fsync of a directory = %v", errno)
+	}
+	node = &Node{path: localPath("/missing")}
+	if errno := node.Fsync(t.Context(), nil, 0); errno != syscall.ENOENT {
+		t.Errorf("Fsync of a missing directory = %v; want ENOENT", errno)
+	}
+}
+
+// Each attribute the kernel marks valid, alone or together, reaches
+// remote in a single Setattr carrying exactly those fields
+func TestSetattrSendsValidFields(t *testing.T) {
+	useTestQueue(t)
+	srv := truncateServer{sizes: make(chan *proto.SetattrRequest, 2)}
+	useTestRemote(t, srv)
+	online.Store(true)
+	err := os.WriteFile(filepath.Join(realpath, "file"), []byte("contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{path: filepath.Join(realpath, "file")}
+	atime, mtime := time.Unix(1000, 0), time.Unix(2000, 0)
+
+	tests := map[string]uint32{
+		"mode":           fuse.FATTR_MODE,
+		"atime":          fuse.FATTR_ATIME,
+		"mtime":          fuse.FATTR_MTIME,
+		"size":           fuse.FATTR_SIZE,
+		"atime mtime":    fuse.FATTR_ATIME | fuse.FATTR_MTIME,
+		"mode size":      fuse.FATTR_MODE | fuse.FATTR_SIZE,
+		"mode mtime":     fuse.FATTR_MODE | fuse.FATTR_MTIME,
+		"everything":     fuse.FATTR_MODE | fuse.FATTR_ATIME | fuse.FATTR_MTIME | fuse.FATTR_SIZE,
+		"owner only":     fuse.FATTR_UID | fuse.FATTR_GID,
+		"owner and mode": fuse.FATTR_UID | fuse.FATTR_GID | fuse.FATTR_MODE,
+	}
+	for name, valid := range tests {
+		in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+			Valid: valid,
+			Mode:  0600,
+			Size:  3,
+			Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())},
+		}}
+		in.Atime, in.Atimensec = uint64(atime.Unix()), 0
+		in.Mtime, in.Mtimensec = uint64(mtime.Unix()), 0
+		if errno := node.Setattr(context.Background(), nil, in, &fuse.AttrOut{}); errno != 0 {
+			t.Fatalf("Setattr of %v = %v", name, errno)
+		}
+
+		if valid&^(fuse.FATTR_UID|fuse.FATTR_GID) == 0 {
+			// Owners are emails on remote; nothing to send
+			select {
+			case req := <-srv.sizes:
+				t.Errorf("Setattr of %v sent %v", name, req)
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+		var req *proto.SetattrRequest
+		select {
+		case req = <-srv.sizes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Setattr of %v never reached remote", name)
+		}
+		if got := req.Mode != nil; got != (valid&fuse.FATTR_MODE != 0) || got && *req.Mode != 0600 {
+			t.Errorf("Setattr of %v sent mode %v", name, req.Mode)
+		}
+		if got := req.Size != nil; got != (valid&fuse.FATTR_SIZE != 0) || got && *req.Size != 3 {
+			t.Errorf("Setattr of %v sent size %v", name, req.Size)
+		}
+		if got := req.ATime != nil; got != (valid&fuse.FATTR_ATIME != 0) || got && !req.ATime.AsTime().Equal(atime) {
+			t.Errorf("Setattr of %v sent atime %v", name, req.ATime)
+		}
+		if got := req.MTime != nil; got != (valid&fuse.FATTR_MTIME != 0) || got && !req.MTime.AsTime().Equal(mtime) {
+			t.Errorf("Setattr of %v sent mtime %v", name, req.MTime)
+		}
+		select {
+		case req := <-srv.sizes:
+			t.Errorf("Setattr of %v sent a second request %v", name, req)
+		default:
+		}
+	}
+}
+
+// Creates every file with mode, as a server with a stricter umask does
+type umaskServer struct {
+	proto.UnimplementedFuseServer
+	mode uint32
+}
+
+func (s umaskServer) Create(ctx context.Context, req *proto.CreateRequest) (*proto.CreateResponse, error) {
+	return &proto.CreateResponse{Attr: &proto.FileAttr{Mode: syscall.S_IFREG | s.mode}}, nil
+}
+
+// With -sync-create the kernel is told the mode remote gave the new
+// file, and the local copy takes it on
+func TestSyncCreateAdoptsRemoteMode(t *testing.T) {
+	useTestInodes(t)
+	useTestQueue(t)
+	useTestRemote(t, umaskServer{mode: 0600})
+	online.Store(true)
+	oldSync := syncCreate
+	syncCreate = true
+	t.Cleanup(func() { syncCreate = oldSync })
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	out := fuse.CreateOut{}
+	code := raw.Create(nil, &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}, "file", &out)
+	if !code.Ok() {
+		t.Fatalf("Create = %v", code)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: out.NodeId}, Fh: out.Fh})
+
+	if perm := out.Attr.Mode & 07777; perm != 0600 {
+		t.Errorf("mode reported to the kernel = %o; want remote's 600", perm)
+	}
+	info, err := os.Stat(localPath("/file"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("local file = %v, %v; want mode 0600", info, err)
+	}
+	if adoptRemoteMode(localPath("/file"), nil) {
+		t.Error("mode changed for a create remote never answered")
+	}
+}
+
+// Without -sync-create the mode is taken on once remote answers, and
+// the kernel stops reporting the old one
+func TestCreateConvergesOnRemoteMode(t *testing.T) {
+	useTestMount(t)
+	useTestRemote(t, umaskServer{mode: 0600})
+	online.Store(true)
+
+	path := filepath.Join(mountpoint, "new")
+	err := os.WriteFile(path, []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().Perm() == 0600 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mount reports %v, %v; want mode 0600", info, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info, err := os.Stat(localPath("/new")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("local file = %v, %v; want mode 0600", info, err)
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
 )
 
 // Node is a filesystem node in a loopback file system.
@@ -18,8 +23,18 @@ type Node struct {
 	fs.Inode
 
 	path string
+
+	// Stat taken by the Lookup/Open/Create that produced this node.
+	// Used once by the Getattr that normally follows it
+	attrMu      sync.Mutex
+	attr        syscall.Stat_t
+	attrExpires time.Time
 }
 
+// How long a stat handed over to Getattr stays valid. Matches the
+// kernel's default attribute timeout
+const attrCacheTTL = time.Second
+
 var _ = (fs.NodeLookuper)((*Node)(nil))
 var _ = (fs.NodeMkdirer)((*Node)(nil))
 var _ = (fs.NodeRmdirer)((*Node)(nil))
@@ -30,12 +45,16 @@ var _ = (fs.NodeSymlinker)((*Node)(nil))
 var _ = (fs.NodeLinker)((*Node)(nil))
 var _ = (fs.NodeReadlinker)((*Node)(nil))
 var _ = (fs.NodeOpener)((*Node)(nil))
+var _ = (fs.NodeCopyFileRanger)((*Node)(nil))
+var _ = (fs.NodeAccesser)((*Node)(nil))
 
 // var _ = (fs.NodeOpendirHandler)((*Node)(nil))
 var _ = (fs.NodeReaddirer)((*Node)(nil))
 var _ = (fs.NodeGetattrer)((*Node)(nil))
 var _ = (fs.NodeSetattrer)((*Node)(nil))
 var _ = (fs.NodeOnForgetter)((*Node)(nil))
+var _ = (fs.NodeGetxattrer)((*Node)(nil))
+var _ = (fs.NodeFsyncer)((*Node)(nil))
 
 // NewFileSystem returns a root node for a loopback file system.
 // This node implements all NodeXxxxer operations available.
@@ -47,22 +66,95 @@ func NewFileSystem(ctx context.Context, realpath string) (fs.InodeEmbedder, erro
 		return nil, err
 	}
 
-	go startRemoteObserver(ctx)
+	loadInodes()
+	loadListings()
+	if online.Load() {
+		go startRemoteObserver(ctx)
+	}
+
+	rootNode = &Node{path: realpath}
+	return rootNode, nil
+}
+
+// Hands st over to the Getattr following the current operation
+func cacheStat(inode *fs.Inode, st *syscall.Stat_t) {
+	node, ok := inode.Operations().(*Node)
+	if !ok {
+		return
+	}
+
+	node.attrMu.Lock()
+	defer node.attrMu.Unlock()
+
+	node.attr = *st
+	node.attrExpires = time.Now().Add(attrCacheTTL)
+}
+
+// Returns the stat cached by cacheStat if it has not expired yet.
+// Each cached stat is only used once
+func (n *Node) takeStat(st *syscall.Stat_t) bool {
+	n.attrMu.Lock()
+	defer n.attrMu.Unlock()
+
+	if n.attrExpires.IsZero() || time.Now().After(n.attrExpires) {
+		return false
+	}
+	*st = n.attr
+	n.attrExpires = time.Time{}
+	return true
+}
+
+// Like takeStat but leaves the cached stat in place for Getattr
+func (n *Node) peekStat(st *syscall.Stat_t) bool {
+	n.attrMu.Lock()
+	defer n.attrMu.Unlock()
+
+	if n.attrExpires.IsZero() || time.Now().After(n.attrExpires) {
+		return false
+	}
+	*st = n.attr
+	return true
+}
 
-	return &Node{path: realpath}, nil
+// Drops the stat cached by cacheStat once the file has changed
+func (n *Node) forgetStat() {
+	n.attrMu.Lock()
+	defer n.attrMu.Unlock()
+	n.attrExpires = time.Time{}
 }
 
+// Turns a path under realpath into a wire path; see lib.ToWire.
+// realpath itself is "". Paths outside it are returned as they are
 func relativePath(path string) string {
-	return strings.TrimPrefix(path, realpath)
+	rel, err := filepath.Rel(realpath, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return path
+	}
+	if rel == "." {
+		return ""
+	}
+	return lib.ToWire(rel)
+}
+
+// Turns wire path p into a path under realpath
+func localPath(p string) string {
+	return filepath.Join(realpath, lib.FromWire(p))
 }
 
 func (n *Node) OnAdd(ctx context.Context) {
+	lib.LiveInodes.Add(1)
+
 	if !n.IsDir() {
 		return
 	}
 
 	// log.Printf("[FUSE] OnAdd %v\n", n.path)
 
+	if !online.Load() {
+		// Caught up with once we reconnect
+		return
+	}
+
 	relativePath := relativePath(n.path)
 	err := fetchRemoteEntries(ctx, relativePath)
 	if err != nil {
@@ -74,12 +166,22 @@ func (n *Node) OnAdd(ctx context.Context) {
 func (n *Node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
 	log.Printf("[FUSE] Statfs %v\n", n.path)
 	stat := syscall.Statfs_t{}
-	err := syscall.Statfs(n.path, &stat)
+	err := localStatfs.Statfs(n.path, &stat)
 	if err != nil {
 		log.Printf("[FUSE] Stafs %v failed; %v\n", n.path, err)
 		return fs.ToErrno(err)
 	}
 	out.FromStatfsT(&stat)
+
+	// Inode usage is that of the org on remote, not the local disk
+	remoteStat, ok := remoteStatfs(ctx)
+	if ok {
+		out.Files = remoteStat.Files
+		if remoteStat.Quota > 0 {
+			out.Files = remoteStat.Quota
+			out.Ffree = remoteStat.Ffree
+		}
+	}
 	return fs.OK
 }
 
@@ -89,30 +191,64 @@ func (n *Node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs
 
 	stat := syscall.Stat_t{}
 	err := syscall.Lstat(fullpath, &stat)
+	if err == syscall.ENOENT {
+		// Listed by remote but not downloaded yet
+		entry, ok := lookupListing(relativePath(fullpath))
+		if ok && materialize(relativePath(fullpath), entry) == nil {
+			err = syscall.Lstat(fullpath, &stat)
+		} else if !ok && lookupRemote(ctx, relativePath(fullpath)) {
+			// Added to remote since we last listed the directory
+			err = syscall.Lstat(fullpath, &stat)
+		}
+	}
 	if err != nil {
-		log.Printf("[FUSE] Lookup %v failed; %v\n", relativePath(fullpath), err)
+		if err != syscall.ENOENT {
+			log.Printf("[FUSE] Lookup %v failed; %v\n", relativePath(fullpath), err)
+		}
 		return nil, fs.ToErrno(err)
 	}
+	remotePlaceholderSize(relativePath(fullpath), &stat)
 	out.Attr.FromStat(&stat)
-
-	child := n.NewPersistentInode(
-		ctx,
-		&Node{path: fullpath},
-		fs.StableAttr{
-			Ino:  stat.Ino,
-			Mode: stat.Mode,
-		},
-	)
-	n.AddChild(name, child, false)
+	ino := stableIno(relativePath(fullpath))
+	out.Attr.Ino = ino
+	showOwner(fullpath, &out.Attr)
+
+	// Repeated lookups (every stat of the path) hand back the inode
+	// go-fuse already tracks. A new one is only made for a new file
+	// or one that was replaced by a different kind of file
+	child := n.GetChild(name)
+	if child == nil || child.StableAttr().Ino != ino || child.Mode() != stat.Mode&syscall.S_IFMT {
+		child = n.NewInode(
+			ctx,
+			&Node{path: fullpath},
+			fs.StableAttr{
+				Ino:  ino,
+				Mode: stat.Mode,
+			},
+		)
+	}
+	cacheStat(child, &stat)
 	return child, 0
 }
 
 func (n *Node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Mkdir; %v\n", fullpath)
+	if errno := refuseLocalChange("Mkdir", fullpath); errno != 0 {
+		return nil, errno
+	}
+
+	if !inodesAvailable(ctx) {
+		return nil, syscall.ENOSPC
+	}
 
 	// Create local directory
-	err := os.MkdirAll(fullpath, os.FileMode(mode))
+	err := os.MkdirAll(fullpath, os.FileMode(mode&0777))
+	if err == nil {
+		// Keeps the setgid and sticky bits that os.MkdirAll drops so
+		// the remote directory gets them too
+		err = syscall.Chmod(fullpath, mode&07777)
+	}
 	if err != nil {
 		log.Printf("[FUSE] Mkdir %v failed; %v\n", fullpath, err)
 		return nil, fs.ToErrno(err)
@@ -125,26 +261,33 @@ func (n *Node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.En
 		log.Printf("[FUSE] Mkdir %v failed; %v\n", fullpath, err)
 		return nil, fs.ToErrno(err)
 	}
+	remotePlaceholderSize(relativePath(fullpath), &stat)
 	out.Attr.FromStat(&stat)
+	ino := stableIno(relativePath(fullpath))
+	out.Attr.Ino = ino
+	lib.SetOwner(fullpath, email)
+	showOwner(fullpath, &out.Attr)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: fullpath},
 		fs.StableAttr{
-			Ino:  stat.Ino,
+			Ino:  ino,
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
 
 	// Create remote directory
 	relativePath := relativePath(fullpath)
 
 	go func(path string, mode uint32) {
-		ctx = NewAuthenticatedCtx(ctx)
-		_, err := grpcClient.Mkdir(ctx, &proto.MkdirRequest{
-			Path: path,
-			Mode: mode,
+		op := queuedOp{Op: OP_MKDIR, Path: path, Mode: mode}
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Mkdir(ctx, &proto.MkdirRequest{
+				Path: path,
+				Mode: mode,
+			})
+			return err
 		})
 		if err != nil {
 			log.Printf("[FUSE] Error creating remote directory; %v\n", err)
@@ -157,19 +300,28 @@ func (n *Node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.En
 func (n *Node) Rmdir(ctx context.Context, name string) syscall.Errno {
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Rmdir %v\n", fullpath)
+	if errno := refuseLocalChange("Rmdir", fullpath); errno != 0 {
+		return errno
+	}
 
 	err := syscall.Rmdir(fullpath)
 	if err != nil {
 		return fs.ToErrno(err)
 	}
+	forgetIno(relativePath(fullpath))
+	forgetListing(relativePath(fullpath))
+	n.dropChild(name)
 
 	// Remove remote directory
 	relativePath := relativePath(fullpath)
 
 	go func(path string) {
-		ctx = NewAuthenticatedCtx(ctx)
-		_, err := grpcClient.Rmdir(ctx, &proto.DirEntry{
-			Path: path,
+		op := queuedOp{Op: OP_RMDIR, Path: path}
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Rmdir(ctx, &proto.DirEntry{
+				Path: path,
+			})
+			return err
 		})
 		if err != nil {
 			log.Printf("[FUSE] Error deleting remote directory; %v\n", err)
@@ -182,30 +334,57 @@ func (n *Node) Rmdir(ctx context.Context, name string) syscall.Errno {
 func (n *Node) Unlink(ctx context.Context, name string) syscall.Errno {
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Unlink %v\n", fullpath)
+	if errno := refuseLocalChange("Unlink", fullpath); errno != 0 {
+		return errno
+	}
 
 	// Remove local file
 	err := os.Remove(fullpath)
 	if err != nil {
 		return fs.ToErrno(err)
 	}
+	forgetIno(relativePath(fullpath))
+	forgetListing(relativePath(fullpath))
+	n.dropChild(name)
 
 	// Remove remote file
 	relativePath := relativePath(fullpath)
 
 	go func(path string) {
-		ctx = NewAuthenticatedCtx(ctx)
-		_, err := grpcClient.Rmdir(ctx, &proto.DirEntry{
-			Path: path,
+		op := queuedOp{Op: OP_UNLINK, Path: path}
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Unlink(ctx, &proto.DirEntry{
+				Path: path,
+			})
+			return err
 		})
 		if err != nil {
 			log.Printf("[FUSE] Error deleting remote file; %v\n", err)
 		}
 	}(relativePath)
 
-	return fs.ToErrno(err)
+	return fs.OK
+}
+
+// Takes a removed entry out of the inode tree straight away so that
+// nothing walking the tree, eg. findInode, finds it again before the
+// kernel forgets it. A hard link to the same file keeps the inode
+// alive; the stat cached for it is dropped as its link count changed
+func (n *Node) dropChild(name string) {
+	child := n.GetChild(name)
+	if child == nil {
+		return
+	}
+	if node, ok := child.Operations().(*Node); ok {
+		node.forgetStat()
+	}
+	n.RmChild(name)
 }
 
 func (n *Node) Rename(ctx context.Context, oldName string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if errno := refuseLocalChange("Rename", filepath.Join(n.path, oldName)); errno != 0 {
+		return errno
+	}
 	newNode, ok := newParent.(*Node)
 	if !ok {
 		// log.Println("Rename failed; newNode is NOT of type *Node")
@@ -226,63 +405,187 @@ func (n *Node) Rename(ctx context.Context, oldName string, newParent fs.InodeEmb
 		}
 	}
 
-	err := os.Rename(oldpath, newpath)
+	// Like rename(2), an existing destination is replaced. Keep it
+	// until remote agrees so a failed rename can put it back as it
+	// was, changes remote hasn't seen yet included
+	var replaced os.FileInfo
+	kept := ""
+	if info, err := os.Lstat(newpath); err == nil {
+		replaced = info
+		kept, err = keepReplaced(newpath, info)
+		if err != nil {
+			log.Printf("[FUSE] Error keeping %v while renaming over it; %v\n", newpath, err)
+			return fs.ToErrno(err)
+		}
+	}
+
+	// os.Rename refuses to replace a directory; rename(2) replaces
+	// empty ones
+	err := syscall.Rename(oldpath, newpath)
 	if err != nil {
 		log.Printf("[FUSE] Rename %v -> %v failed; %v\n", oldpath, newpath, err)
+		if replaced != nil {
+			restoreReplaced(newpath, kept, replaced)
+		}
 		return fs.ToErrno(err)
 	}
 
-	// Remove old entry from parent
+	// Rename remote file. Local and remote must not diverge so
+	// undo the local rename if remote refuses
+	op := queuedOp{
+		Op:      OP_RENAME,
+		Path:    relativePath(oldpath),
+		NewPath: relativePath(newpath),
+	}
+	err = sendOrQueue(op, func(ctx context.Context) error {
+		_, err := grpcClient.Rename(ctx, &proto.RenameRequest{
+			OldPath: op.Path,
+			NewPath: op.NewPath,
+		})
+		return err
+	})
+	if err != nil {
+		log.Printf("[FUSE] Error renaming remote file; %v\n", err)
+
+		undoErr := syscall.Rename(newpath, oldpath)
+		if undoErr != nil {
+			log.Printf("[FUSE] Error rolling back rename %v -> %v; %v\n", newpath, oldpath, undoErr)
+			return syscall.EIO
+		}
+		if replaced != nil {
+			restoreReplaced(newpath, kept, replaced)
+		}
+		return lib.StatusErrno(err)
+	}
+
+	if replaced != nil {
+		if kept != "" {
+			os.Remove(kept)
+		}
+		forgetIno(relativePath(newpath))
+		forgetListing(relativePath(newpath))
+	}
+	renameIno(relativePath(oldpath), relativePath(newpath))
+	forgetListing(relativePath(oldpath))
+
+	// Move old entry over to its new parent
 	oldChild := n.GetChild(oldName)
 	if oldChild != nil {
-		n.MvChild(oldName, &newNode.Inode, newName, false)
+		moved := n.MvChild(oldName, &newNode.Inode, newName, true)
+		if moved {
+			updatePaths(oldChild, newpath)
+		} else {
+			// Forget the stale entry and let the kernel look the
+			// file up again under its new name
+			log.Printf("[FUSE] Error moving inode %v -> %v\n", oldpath, newpath)
+			n.RmChild(oldName)
+			newNode.RmChild(newName)
+		}
+
 		go func() {
 			n.NotifyDelete(oldName, oldChild)
 
 			// Notify kernel of new node
 			newNode.NotifyEntry(newName)
 		}()
+	} else if replaced != nil {
+		// The replaced file's inode must not outlive its name
+		newNode.RmChild(newName)
 	}
 
-	var stat syscall.Stat_t
-	err = syscall.Lstat(newpath, &stat)
-	if err != nil {
-		log.Printf("[FUSE] Rename %v -> %v failed; %v\n", oldpath, newpath, err)
-		return fs.ToErrno(err)
+	return 0
+}
+
+// Names files replaced by a rename are kept under until remote has
+// renamed too
+const REPLACED_PREFIX = ".fusion-replaced-"
+
+// Keeps the file a rename is about to replace at path so that a
+// failed rename can bring it back. Returns where it was kept, or ""
+// for directories, which can only be replaced when empty and are
+// recreated instead
+func keepReplaced(path string, info os.FileInfo) (string, error) {
+	if info.IsDir() {
+		return "", nil
 	}
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	kept := filepath.Join(filepath.Dir(path), REPLACED_PREFIX+hex.EncodeToString(suffix))
 
-	child := newNode.NewPersistentInode(
-		ctx,
-		&Node{path: newpath},
-		fs.StableAttr{
-			Ino:  stat.Ino,
-			Mode: stat.Mode,
-		},
-	)
-	newNode.AddChild(newName, child, false)
+	// A hard link keeps path in place until the rename replaces it
+	err := os.Link(path, kept)
+	if err != nil {
+		// Not every filesystem has hard links
+		err = os.Rename(path, kept)
+	}
+	if err != nil {
+		return "", err
+	}
+	return kept, nil
+}
 
-	// Rename remote file
-	oldpath = relativePath(oldpath)
-	newpath = relativePath(newpath)
+// Brings back the file a failed rename replaced from where
+// keepReplaced kept it
+func restoreReplaced(path, kept string, info os.FileInfo) {
+	var err error
+	if kept == "" {
+		err = os.Mkdir(path, info.Mode().Perm())
+	} else {
+		err = os.Rename(kept, path)
+	}
+	if err != nil && !os.IsExist(err) {
+		log.Printf("[FUSE] Error restoring %v after failed rename; %v\n", path, err)
+	}
+}
 
-	go func(oldpath, newpath string) {
-		ctx = NewAuthenticatedCtx(ctx)
-		_, err := grpcClient.Rename(ctx, &proto.RenameRequest{
-			OldPath: oldpath,
-			NewPath: newpath,
-		})
-		if err != nil {
-			log.Printf("[FUSE] Error renaming remote file; %v\n", err)
-		}
-	}(oldpath, newpath)
+// Points node and everything below it at its new location after a
+// rename
+func updatePaths(inode *fs.Inode, path string) {
+	node, ok := inode.Operations().(*Node)
+	if !ok {
+		return
+	}
+	node.path = path
 
-	return 0
+	for name, child := range inode.Children() {
+		updatePaths(child, filepath.Join(path, name))
+	}
 }
 
 func (n *Node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (inode *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if flags&unix.O_TMPFILE == unix.O_TMPFILE {
+		// Unnamed files aren't supported; programs fall back to a
+		// named temporary file on EOPNOTSUPP
+		log.Printf("[FUSE] Create %v with O_TMPFILE refused\n", filepath.Join(n.path, name))
+		return nil, nil, 0, syscall.EOPNOTSUPP
+	}
+	if errno := refuseLocalChange("Create", filepath.Join(n.path, name)); errno != 0 {
+		return nil, nil, 0, errno
+	}
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Create %v\n", fullpath)
 
+	if !inodesAvailable(ctx) {
+		return nil, nil, 0, syscall.ENOSPC
+	}
+
+	// Creating a file needs write and search permission on its parent
+	errno = lib.CheckPermissions(ctx, n.path, lib.W_OK|lib.X_OK)
+	if errno != 0 {
+		log.Printf("[FUSE] Create %v failed; %v\n", fullpath, errno)
+		return nil, nil, 0, errno
+	}
+
+	errno = reserveHandle()
+	if errno != 0 {
+		return nil, nil, 0, errno
+	}
+	defer func() {
+		if errno != 0 {
+			releaseHandle()
+		}
+	}()
+
 	file, err := os.OpenFile(fullpath, int(flags), os.FileMode(mode))
 	if err != nil {
 		log.Printf("[FUSE] Create %v failed; %v\n", fullpath, err)
@@ -296,43 +599,133 @@ func (n *Node) Create(ctx context.Context, name string, flags uint32, mode uint3
 		return nil, nil, 0, fs.ToErrno(err)
 	}
 	out.FromStat(&stat)
-
-	child := n.NewPersistentInode(
-		ctx,
-		&Node{path: fullpath},
-		fs.StableAttr{
-			Ino:  stat.Ino,
-			Mode: stat.Mode,
-		},
-	)
-	n.AddChild(name, child, false)
+	ino := stableIno(relativePath(fullpath))
+	out.Attr.Ino = ino
+	lib.SetOwner(fullpath, email)
+	showOwner(fullpath, &out.Attr)
 
 	// Create remote file
 	relativePath := relativePath(fullpath)
-
-	go func(path string, flags uint32, mode uint32) {
-		ctx = NewAuthenticatedCtx(ctx)
-		_, err := grpcClient.Create(ctx, &proto.CreateRequest{
-			Path:  path,
-			Flags: flags,
-			Mode:  mode,
+	var remoteAttr *proto.FileAttr
+	createRemote := func() error {
+		op := queuedOp{Op: OP_CREATE, Path: relativePath, Flags: flags, Mode: mode}
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			res, err := grpcClient.Create(ctx, &proto.CreateRequest{
+				Path:  relativePath,
+				Flags: flags,
+				Mode:  mode,
+			})
+			if err == nil {
+				remoteAttr = res.Attr
+			}
+			return err
 		})
 		if err != nil {
+			markRemoteFull(relativePath, err)
 			log.Printf("[FUSE] Error creating remote file; %v\n", err)
 		}
-	}(relativePath, flags, mode)
+		return err
+	}
+
+	remoteCreate := make(chan error, 1)
+	if syncCreate {
+		// Don't report a file remote will never have as created.
+		// Offline creates are still queued and succeed
+		err = createRemote()
+		if err != nil {
+			file.Close()
+			os.Remove(fullpath)
+			forgetIno(relativePath)
+			return nil, nil, 0, lib.StatusErrno(err)
+		}
+		if adoptRemoteMode(fullpath, remoteAttr) {
+			err = syscall.Fstat(int(file.Fd()), &stat)
+			if err != nil {
+				return nil, nil, 0, fs.ToErrno(err)
+			}
+			out.FromStat(&stat)
+			out.Attr.Ino = ino
+			showOwner(fullpath, &out.Attr)
+		}
+		remoteCreate <- nil
+	} else {
+		finishCreate := func() {
+			err := createRemote()
+			if err == nil && adoptRemoteMode(fullpath, remoteAttr) {
+				// The kernel may already have the attributes we
+				// reported
+				if inode := findInode(relativePath); inode != nil {
+					if node, ok := inode.Operations().(*Node); ok {
+						node.forgetStat()
+					}
+					inode.NotifyContent(0, 0)
+				}
+			}
+			remoteCreate <- err
+		}
+		if isRewrite(flags) {
+			// Sent along with the contents on first flush; see
+			// deferredCreate
+			deferCreate(relativePath, &deferredCreate{
+				create: finishCreate,
+				done:   remoteCreate,
+			})
+		} else {
+			go finishCreate()
+		}
+	}
+
+	child := n.NewInode(
+		ctx,
+		&Node{path: fullpath},
+		fs.StableAttr{
+			Ino:  ino,
+			Mode: stat.Mode,
+		},
+	)
+	cacheStat(child, &stat)
 
 	fd, err := syscall.Dup(int(file.Fd()))
 	if err != nil {
 		return nil, nil, 0, fs.ToErrno(err)
 	}
 
-	return child, NewLoopbackFile(fd, fullpath), 0, 0
+	return child, newCreatedFile(fd, fullpath, flags, remoteCreate), 0, 0
+}
+
+// The server creates files with its own umask applied, so the mode it
+// settles on can differ from ours. Takes on the server's mode so both
+// sides agree and reports whether it changed. attr is nil for creates
+// queued while offline
+func adoptRemoteMode(fullpath string, attr *proto.FileAttr) bool {
+	if attr == nil {
+		return false
+	}
+	stat := syscall.Stat_t{}
+	err := syscall.Lstat(fullpath, &stat)
+	if err != nil {
+		return false
+	}
+	local := stat.Mode & 07777
+	remote := attr.Mode & 07777
+	if local == remote {
+		return false
+	}
+
+	err = syscall.Chmod(fullpath, remote)
+	if err != nil {
+		log.Printf("[FUSE] Error applying remote mode to %v; %v\n", relativePath(fullpath), err)
+		return false
+	}
+	return true
 }
 
 func (n *Node) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Symlink; %v\n", fullpath)
+	if errno := refuseLocalChange("Symlink", fullpath); errno != 0 {
+		return nil, errno
+	}
 
 	err := syscall.Symlink(target, fullpath)
 	if err != nil {
@@ -347,28 +740,52 @@ func (n *Node) Symlink(ctx context.Context, target, name string, out *fuse.Entry
 		log.Printf("[FUSE] Symlink %v failed; %v\n", fullpath, err)
 		return nil, fs.ToErrno(err)
 	}
+	remotePlaceholderSize(relativePath(fullpath), &stat)
 	out.Attr.FromStat(&stat)
+	ino := stableIno(relativePath(fullpath))
+	out.Attr.Ino = ino
+	// A symlink can't carry the owner xattr; reading it would follow
+	// the link to its target. Whoever created it owns it
+	out.Attr.Uid, out.Attr.Gid = localOwner(email)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: fullpath},
 		fs.StableAttr{
-			Ino:  stat.Ino,
+			Ino:  ino,
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
+
+	// Remote stores the target exactly as given
+	go func(path string) {
+		op := queuedOp{Op: OP_SYMLINK, Path: path, Target: target}
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			_, err := grpcClient.Symlink(ctx, &proto.LinkRequest{
+				OldPath: target,
+				NewPath: path,
+			})
+			return err
+		})
+		if err != nil {
+			log.Printf("[FUSE] Error creating remote symlink; %v\n", err)
+		}
+	}(relativePath(fullpath))
+
 	return child, 0
 }
 
 func (n *Node) Link(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := refuseLocalChange("Link", filepath.Join(n.path, name)); errno != 0 {
+		return nil, errno
+	}
 	targetNode, ok := target.(*Node)
 	if !ok {
 		// log.Println("Link failed; targetNode is NOT of type *Node")
 		return nil, syscall.EIO
 	}
 
-	oldpath := filepath.Join(n.path, targetNode.path)
+	oldpath := targetNode.path
 	newpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Link %v -> %v\n", oldpath, newpath)
 
@@ -379,49 +796,62 @@ func (n *Node) Link(ctx context.Context, target fs.InodeEmbedder, name string, o
 	}
 
 	stat := syscall.Stat_t{}
-	err = syscall.Lstat(oldpath, &stat)
+	err = syscall.Lstat(newpath, &stat)
 	if err != nil {
-		syscall.Unlink(oldpath)
+		syscall.Unlink(newpath)
 		log.Printf("[FUSE] Link %v -> %v failed; %v\n", oldpath, newpath, err)
 		return nil, fs.ToErrno(err)
 	}
 	out.Attr.FromStat(&stat)
+	ino := linkIno(relativePath(targetNode.path), relativePath(newpath))
+	out.Attr.Ino = ino
+	showOwner(newpath, &out.Attr)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: newpath},
 		fs.StableAttr{
-			Ino:  stat.Ino,
+			Ino:  ino,
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
 	return child, 0
 }
 
 func (n *Node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
-	path := n.path
-
-	// No idea whats going on here
-	for l := 256; ; l *= 2 {
-		buf := make([]byte, l)
-		size, err := syscall.Readlink(path, buf)
-		if err != nil {
-			return nil, fs.ToErrno(err)
-		}
-
-		if size < len(buf) {
-			return buf[:size], 0
-		}
-	}
+	return lib.Readlink(n.path)
 }
 
 func (n *Node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
 	fullpath := n.path
 	log.Printf("[FUSE] Open %v\n", fullpath)
+	if int(flags)&syscall.O_ACCMODE != syscall.O_RDONLY || flags&syscall.O_TRUNC != 0 {
+		if errno := refuseLocalChange("Open", fullpath); errno != 0 {
+			return nil, 0, errno
+		}
+	}
+
+	errno := lib.CheckPermissions(ctx, fullpath, lib.AccessMask(flags))
+	if errno != 0 {
+		log.Printf("[FUSE] Open %v failed; %v\n", fullpath, errno)
+		return nil, 0, errno
+	}
+
+	// Large files are only downloaded once someone needs them
+	err := fetchOnDemand(relativePath(fullpath))
+	if err != nil {
+		log.Printf("[FUSE] Open %v failed; %v\n", fullpath, err)
+		return nil, 0, syscall.EIO
+	}
+
+	errno = reserveHandle()
+	if errno != 0 {
+		return nil, 0, errno
+	}
 
 	file, err := os.OpenFile(fullpath, int(flags), 0755)
 	if err != nil {
+		releaseHandle()
 		log.Printf("[FUSE] Open %v failed; %v\n", fullpath, err)
 		return nil, 0, fs.ToErrno(err)
 	}
@@ -429,65 +859,152 @@ func (n *Node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, s
 	stat := syscall.Stat_t{}
 	err = syscall.Lstat(fullpath, &stat)
 	if err != nil {
+		releaseHandle()
 		log.Printf("[FUSE] Open %v failed; %v\n", fullpath, err)
 		return nil, 0, fs.ToErrno(err)
 	}
 
-	name := filepath.Base(fullpath)
-	child := n.NewPersistentInode(
-		ctx,
-		&Node{path: fullpath},
-		fs.StableAttr{
-			Ino:  stat.Ino,
-			Mode: stat.Mode,
-		},
-	)
-	n.AddChild(name, child, false)
+	cacheStat(n.EmbeddedInode(), &stat)
 
 	fd, err := syscall.Dup(int(file.Fd()))
 	if err != nil {
+		releaseHandle()
 		return nil, 0, fs.ToErrno(err)
 	}
 
-	return NewLoopbackFile(fd, fullpath), 0, 0
+	return NewLoopbackFile(fd, fullpath, flags), 0, 0
 }
 
-func (n *Node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	// log.Printf("[FUSE] Readdir %v\n", n.path)
+func (n *Node) CopyFileRange(ctx context.Context, fhIn fs.FileHandle, offIn uint64, out *fs.Inode, fhOut fs.FileHandle, offOut uint64, length uint64, flags uint64) (uint32, syscall.Errno) {
+	in, ok := fhIn.(*FileHandle)
+	if !ok {
+		return 0, syscall.ENOTSUP
+	}
+	dst, ok := fhOut.(*FileHandle)
+	if !ok {
+		return 0, syscall.ENOTSUP
+	}
+	log.Printf("[FUSE] CopyFileRange %v -> %v\n", in.path, dst.path)
+
+	// Encrypted copies past the end re-seal the chunk that used to be
+	// last
+	var before syscall.Stat_t
+	if e2eEnabled() {
+		syscall.Fstat(dst.fd, &before)
+	}
 
-	entries := []fuse.DirEntry{}
-	files, err := os.ReadDir(n.path)
+	srcOff := int64(offIn)
+	dstOff := int64(offOut)
+	written, err := unix.CopyFileRange(in.fd, &srcOff, dst.fd, &dstOff, int(length), int(flags))
 	if err != nil {
-		return nil, fs.ToErrno(err)
+		return 0, fs.ToErrno(err)
+	}
+
+	var st syscall.Stat_t
+	err = syscall.Fstat(in.fd, &st)
+	if err != nil {
+		return uint32(written), fs.OK
+	}
+
+	if syncIgnored(relativePath(dst.path)) {
+		return uint32(written), fs.OK
+	}
+	if !online.Load() {
+		enqueue(queuedOp{Op: OP_UPLOAD, Path: relativePath(dst.path)})
+		return uint32(written), fs.OK
+	}
+	// Remote calls must not be cut short if the kernel interrupts
+	// the request; the local copy has already happened
+	syncCtx, cancel := newSyncCtx()
+	defer cancel()
+
+	// Copying a whole file; remote already has the data so let it
+	// make the copy itself
+	if offIn == 0 && offOut == 0 && int64(written) == st.Size && remoteSupports(lib.FEATURE_COPY) {
+		_, err := grpcClient.Copy(syncCtx, &proto.CopyRequest{
+			SrcPath: relativePath(in.path),
+			DstPath: relativePath(dst.path),
+		})
+		if err == nil {
+			// The copy on remote has the same file id and hash
+			copyRecord(in.path, dst.path)
+			return uint32(written), fs.OK
+		}
+		log.Printf("[FUSE] Error copying remote file; %v\n", err)
+	}
+
+	// Partial copy; send the copied bytes as a normal write
+	data := make([]byte, written)
+	read, err := syscall.Pread(dst.fd, data, int64(offOut))
+	if err != nil {
+		log.Printf("[FUSE] Error reading copied range; %v\n", err)
+		return uint32(written), fs.OK
 	}
-	for _, f := range files {
-		info, err := f.Info()
+	request := &proto.WriteRequest{
+		Path:   relativePath(dst.path),
+		Offset: int64(offOut),
+		Data:   data[:read],
+	}
+	if e2eEnabled() {
+		end := int64(offOut) + int64(read)
+		request.Data, request.Offset, err = encryptWrite(dst.fd, dst.path, int64(offOut), end, before.Size)
 		if err != nil {
-			continue
+			log.Printf("[FUSE] Error encrypting write; %v\n", err)
+			enqueue(queuedOp{Op: OP_UPLOAD, Path: relativePath(dst.path)})
+			return uint32(written), fs.OK
 		}
+	}
+	_, err = grpcClient.Write(syncCtx, request)
+	if err != nil {
+		log.Printf("[FUSE] Error writing to remote file; %v\n", err)
+	}
 
-		var mode uint32
-		if info.IsDir() {
-			mode = fuse.S_IFDIR | uint32(info.Mode().Perm())
-		} else {
-			mode = fuse.S_IFREG | uint32(info.Mode().Perm())
+	return uint32(written), fs.OK
+}
+
+func (n *Node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	// log.Printf("[FUSE] Readdir %v\n", n.path)
+
+	ds, errno := lib.NewSnapshotDirStream(func() ([]fuse.DirEntry, error) {
+		entries, err := lib.ReadDir(n.path)
+		if err != nil {
+			return nil, err
 		}
+		// Include what remote has that we haven't downloaded
+		entries = append(entries, remoteOnlyEntries(relativePath(n.path), entries)...)
 
-		entries = append(entries, fuse.DirEntry{
-			Name: f.Name(),
-			Mode: mode,
-			Ino:  uint64(info.Sys().(*syscall.Stat_t).Ino),
-		})
+		for i := range entries {
+			entries[i].Ino = stableIno(relativePath(filepath.Join(n.path, entries[i].Name)))
+		}
+		return entries, nil
+	})
+	if errno != 0 {
+		return nil, errno
 	}
-	return fs.NewListDirStream(entries), fs.OK
+	return ds, fs.OK
 }
 
 func (n *Node) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	// log.Printf("[FUSE] Getattr %v\n", n.path)
 
+	// An open handle sees its own writes and truncates; the path may
+	// not have caught up with them yet
+	if fga, ok := fh.(fs.FileGetattrer); ok && fga != nil {
+		n.forgetStat()
+		errno := fga.Getattr(ctx, out)
+		if errno != fs.OK {
+			return errno
+		}
+		out.Ino = n.StableAttr().Ino
+		showOwner(n.path, &out.Attr)
+		return fs.OK
+	}
+
 	var err error
 	st := syscall.Stat_t{}
-	if &n.Inode == n.Root() {
+	if n.takeStat(&st) {
+		// Just statted by Lookup/Open/Create
+	} else if &n.Inode == n.Root() {
 		err = syscall.Stat(n.path, &st)
 	} else {
 		err = syscall.Lstat(n.path, &st)
@@ -496,13 +1013,55 @@ func (n *Node) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut)
 	if err != nil {
 		return fs.ToErrno(err)
 	}
+	remotePlaceholderSize(relativePath(n.path), &st)
 	out.FromStat(&st)
+	out.Ino = n.StableAttr().Ino
+	showOwner(n.path, &out.Attr)
 	return fs.OK
 }
 
+// Answers access(2) probes against the same mode and owner Getattr
+// reports
+func (n *Node) Access(ctx context.Context, mask uint32) syscall.Errno {
+	if mask&lib.W_OK != 0 && syncDirection == SYNC_PULL {
+		return syscall.EROFS
+	}
+	st := syscall.Stat_t{}
+	if !n.peekStat(&st) {
+		err := syscall.Lstat(n.path, &st)
+		if err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+
+	owner := lib.GetOwner(n.path)
+	if owner != "" {
+		st.Uid, st.Gid = localOwner(owner)
+	}
+	return lib.CheckStatPermissions(ctx, &st, mask)
+}
+
 func (n *Node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
 	fullpath := n.path
 	log.Printf("[FUSE] Setattr %v\n", fullpath)
+	if errno := refuseLocalChange("Setattr", fullpath); errno != 0 {
+		return errno
+	}
+	n.forgetStat()
+
+	// Owners aren't synced. A chown that gets past this names the
+	// owner the file already shows and changes nothing
+	if errno := checkChown(fullpath, in); errno != 0 {
+		return errno
+	}
+
+	// ftruncate and friends; let the open handle apply them
+	if fsa, ok := fh.(fs.FileSetattrer); ok && fsa != nil {
+		errno := fsa.Setattr(ctx, in, out)
+		out.Ino = n.StableAttr().Ino
+		return errno
+	}
+
 	mode, ok := in.GetMode()
 	if ok {
 		err := syscall.Chmod(fullpath, mode)
@@ -512,35 +1071,12 @@ func (n *Node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn
 		}
 	}
 
-	userId, uidOK := in.GetUID()
-	groupId, gidOK := in.GetGID()
-	if uidOK || gidOK {
-		suid := -1
-		sgid := -1
-
-		if uidOK {
-			suid = int(userId)
-		}
-		if gidOK {
-			sgid = int(groupId)
-		}
-
-		err := syscall.Chown(fullpath, suid, sgid)
-		if err != nil {
-			log.Printf("[FUSE] Setattr %v failed; %v\n", n.path, err)
-			return fs.ToErrno(err)
-		}
+	errno := setTimes(fullpath, in)
+	if errno != fs.OK {
+		log.Printf("[FUSE] Setattr %v failed; %v\n", n.path, errno)
+		return errno
 	}
 
-	// TODO: Implement setting modified and access times
-
-	// modifiedTime, modifiedOK := in.GetMTime()
-	// accessTime, accessOK := in.GetATime()
-
-	// if modifiedOK || accessOK {
-
-	// }
-
 	size, ok := in.GetSize()
 	if ok {
 		err := syscall.Truncate(fullpath, int64(size))
@@ -549,6 +1085,7 @@ func (n *Node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn
 			return fs.ToErrno(err)
 		}
 	}
+	setattrRemote(nil, fullpath, in, true)
 
 	stat := syscall.Stat_t{}
 	err := syscall.Lstat(fullpath, &stat)
@@ -557,9 +1094,21 @@ func (n *Node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn
 		return fs.ToErrno(err)
 	}
 	out.FromStat(&stat)
+	out.Ino = n.StableAttr().Ino
+	showOwner(n.path, &out.Attr)
 	return fs.OK
 }
 
+// Files are synced through their handle. This is reached for
+// fsync(2) on a directory, which makes its entries durable
+func (n *Node) Fsync(ctx context.Context, f fs.FileHandle, flags uint32) syscall.Errno {
+	if fsyncer, ok := f.(fs.FileFsyncer); ok {
+		return fsyncer.Fsync(ctx, flags)
+	}
+	log.Printf("[FUSE] Fsync %v\n", n.path)
+	return fs.ToErrno(lib.FsyncPath(n.path))
+}
+
 func (n *Node) OnForget() {
-	n.ForgetPersistent()
+	lib.LiveInodes.Add(-1)
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"golang.org/x/sys/unix"
+)
+
+// chattr's A; see linux/fs.h
+const FS_NOATIME_FL = 0x00000080
+
+// chattr +a through the mount sets the flag on the backing file, reads
+// back and is sent on to remote
+func TestIoctlAppendOnly(t *testing.T) {
+	useTestQueue(t)
+	path := filepath.Join(realpath, "log")
+	err := os.WriteFile(path, []byte("first\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := openHandle(t, "log", os.O_RDONLY)
+
+	output := make([]byte, 4)
+	_, errno := fh.Ioctl(context.Background(), unix.FS_IOC_GETFLAGS, 0, nil, output)
+	if errno != 0 {
+		t.Skipf("Filesystem doesn't support file flags; %v", errno)
+	}
+	before := binary.NativeEndian.Uint32(output)
+
+	input := binary.NativeEndian.AppendUint32(nil, before|lib.FS_APPEND_FL)
+	_, errno = fh.Ioctl(context.Background(), unix.FS_IOC_SETFLAGS, 0, input, nil)
+	if errno == syscall.EPERM || errno == syscall.EOPNOTSUPP {
+		t.Skipf("Can't set the append-only flag here; %v", errno)
+	}
+	if errno != 0 {
+		t.Fatalf("FS_IOC_SETFLAGS = %v", errno)
+	}
+	t.Cleanup(func() { lib.SetPathFlags(path, 0) })
+
+	_, errno = fh.Ioctl(context.Background(), unix.FS_IOC_GETFLAGS, 0, nil, output)
+	if got := binary.NativeEndian.Uint32(output); errno != 0 || got != before|lib.FS_APPEND_FL {
+		t.Errorf("FS_IOC_GETFLAGS = %#x, %v; want %#x", got, errno, before|lib.FS_APPEND_FL)
+	}
+	_, err = os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err == nil {
+		t.Errorf("truncating open of the append-only backing file succeeded")
+	}
+
+	// Offline, so the flag is queued for remote
+	waitQueued(t, OP_SETATTR, "/log")
+	for _, op := range loadQueue() {
+		if op.Op != OP_SETATTR || op.Path != "/log" {
+			continue
+		}
+		if op.Attrs == nil || op.Attrs.Flags == nil || *op.Attrs.Flags != lib.FS_APPEND_FL {
+			t.Errorf("queued attributes %+v; want flags %#x", op.Attrs, lib.FS_APPEND_FL)
+		}
+	}
+
+	// Only the synced flags may be changed
+	input = binary.NativeEndian.AppendUint32(nil, before|FS_NOATIME_FL)
+	_, errno = fh.Ioctl(context.Background(), unix.FS_IOC_SETFLAGS, 0, input, nil)
+	if before&FS_NOATIME_FL == 0 && errno != syscall.EOPNOTSUPP {
+		t.Errorf("FS_IOC_SETFLAGS of noatime = %v; want EOPNOTSUPP", errno)
+	}
+}
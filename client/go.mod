@@ -1,6 +1,6 @@
 module github.com/caleb-mwasikira/fusion/client
 
-go 1.23.6
+go 1.24
 
 require (
 	github.com/hanwen/go-fuse/v2 v2.8.0
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"syscall"
+)
+
+// Most files applications may hold open on the mount at once, set by
+// -max-open-files. Every FileHandle keeps a descriptor open on the
+// backing store until it is released, so a program that never closes
+// what it opens would otherwise run the client itself out of
+// descriptors. Past the limit Open and Create fail with EMFILE. 0
+// means unlimited
+var maxOpenFiles int
+
+var openFiles atomic.Int64
+
+func init() {
+	registerStatus("open_handles", func() any {
+		return map[string]any{
+			"open": openFiles.Load(),
+			"max":  maxOpenFiles,
+		}
+	})
+}
+
+// Reserves a handle for a file about to be opened. A reserved handle
+// is given back with releaseHandle when the FileHandle is released, or
+// right away if opening fails
+func reserveHandle() syscall.Errno {
+	for {
+		current := openFiles.Load()
+		if maxOpenFiles > 0 && current >= int64(maxOpenFiles) {
+			log.Printf("[FUSE] Refusing to open more than %v files\n", maxOpenFiles)
+			return syscall.EMFILE
+		}
+		if openFiles.CompareAndSwap(current, current+1) {
+			return 0
+		}
+	}
+}
+
+func releaseHandle() {
+	openFiles.Add(-1)
+}
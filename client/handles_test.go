@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Opening past -max-open-files fails with EMFILE and leaves the count
+// where it was; a released handle makes room again
+func TestOpenFilesCapped(t *testing.T) {
+	useTestQueue(t)
+	// Helpers making FileHandles directly never reserved theirs
+	oldMax, oldOpen := maxOpenFiles, openFiles.Swap(0)
+	maxOpenFiles = 3
+	t.Cleanup(func() {
+		maxOpenFiles = oldMax
+		openFiles.Store(oldOpen)
+	})
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	type handle struct{ node, fh uint64 }
+	open := func(name string) (handle, fuse.Status) {
+		entry := fuse.EntryOut{}
+		if code := raw.Lookup(nil, &header, name, &entry); !code.Ok() {
+			t.Fatalf("Lookup %v = %v", name, code)
+		}
+		out := fuse.OpenOut{}
+		in := &fuse.OpenIn{InHeader: fuse.InHeader{NodeId: entry.NodeId, Caller: header.Caller}, Flags: uint32(os.O_RDONLY)}
+		code := raw.Open(nil, in, &out)
+		return handle{entry.NodeId, out.Fh}, code
+	}
+	release := func(h handle) {
+		raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: h.node}, Fh: h.fh})
+	}
+
+	handles := []handle{}
+	for i := range maxOpenFiles + 1 {
+		name := "file" + strconv.Itoa(i)
+		err := os.WriteFile(filepath.Join(realpath, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, code := open(name)
+		if i < maxOpenFiles {
+			if !code.Ok() {
+				t.Fatalf("Open %v = %v", name, code)
+			}
+			handles = append(handles, h)
+			continue
+		}
+		if code != fuse.Status(syscall.EMFILE) {
+			t.Errorf("Open past the cap = %v; want EMFILE", code)
+		}
+	}
+	if got := openFiles.Load(); got != int64(maxOpenFiles) {
+		t.Errorf("open files = %v; want %v", got, maxOpenFiles)
+	}
+
+	release(handles[0])
+	h, code := open("file" + strconv.Itoa(maxOpenFiles))
+	if !code.Ok() {
+		t.Errorf("Open after a release = %v; want it let through", code)
+	}
+	release(h)
+	for _, h := range handles[1:] {
+		release(h)
+	}
+	if got := openFiles.Load(); got != 0 {
+		t.Errorf("open files after releasing all = %v; want 0", got)
+	}
+}
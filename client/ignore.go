@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+var (
+	ignoreFile     *lib.IgnoreFile
+	ignoreFileOnce sync.Once
+)
+
+// Rules of the ignore file at the root of realpath
+func ignoreRules() *lib.Ignore {
+	ignoreFileOnce.Do(func() {
+		ignoreFile = lib.NewIgnoreFile(filepath.Join(realpath, lib.IGNORE_FILE))
+	})
+	return ignoreFile.Rules()
+}
+
+// Reports whether local path, relative to realpath, is kept out of
+// sync by the ignore file. The -e2e key file never syncs either
+func syncIgnored(path string) bool {
+	if e2eEnabled() && isKeyFile(path) {
+		return true
+	}
+	rules := ignoreRules()
+	if rules == nil {
+		return false
+	}
+	info, err := os.Lstat(localPath(path))
+	return rules.Match(path, err == nil && info.IsDir())
+}
+
+// Same as syncIgnored for a remote entry we know the mode of
+func remoteIgnored(path string, mode uint32) bool {
+	if e2eEnabled() && isKeyFile(path) {
+		return true
+	}
+	return ignoreRules().Match(path, os.FileMode(mode).IsDir())
+}
+
+// Reports whether none of the paths op touches are synced. A rename
+// between an ignored and a synced name still goes to remote
+func opIgnored(op queuedOp) bool {
+	if !syncIgnored(op.Path) {
+		return false
+	}
+	return op.NewPath == "" || syncIgnored(op.NewPath)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Writes rules to the ignore file at the root of realpath
+func useTestIgnore(t *testing.T, rules string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(realpath, lib.IGNORE_FILE), []byte(rules), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset := func() {
+		ignoreFile, ignoreFileOnce = nil, sync.Once{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// Remote creates of ignored paths are not applied locally
+func TestIgnoredCreatesSkipped(t *testing.T) {
+	useTestQueue(t)
+	useTestIgnore(t, "build/\n*.log\n")
+
+	for _, path := range []string{"/build", "/src", "/src/build"} {
+		handleFileEvent(&proto.FileEvent{Event: uint32(events.ADD_FILE), Path: path, Mode: uint32(os.ModeDir | 0755)})
+	}
+	for path, want := range map[string]bool{"/build": false, "/src": true, "/src/build": false} {
+		_, err := os.Stat(localPath(path))
+		if got := err == nil; got != want {
+			t.Errorf("%v created = %v; want %v", path, got, want)
+		}
+	}
+}
+
+// Local changes to ignored paths never reach remote, though a rename
+// out of an ignored name does
+func TestIgnoredChangesNotSent(t *testing.T) {
+	useTestQueue(t)
+	useTestIgnore(t, "*.log\n")
+
+	enqueue(queuedOp{Op: OP_UPLOAD, Path: "/debug.log"})
+	enqueue(queuedOp{Op: OP_RENAME, Path: "/debug.log", NewPath: "/old.log"})
+	enqueue(queuedOp{Op: OP_UPLOAD, Path: "/notes.txt"})
+	enqueue(queuedOp{Op: OP_RENAME, Path: "/debug.log", NewPath: "/debug.txt"})
+	ops := loadQueue()
+	if len(ops) != 2 || ops[0].Path != "/notes.txt" || ops[1].NewPath != "/debug.txt" {
+		t.Errorf("queue = %+v; want the upload of /notes.txt and the rename to /debug.txt", ops)
+	}
+
+	online.Store(true)
+	err := sendOrQueue(queuedOp{Op: OP_UPLOAD, Path: "/debug.log"}, func(ctx context.Context) error {
+		t.Error("upload of an ignored file sent to remote")
+		return nil
+	})
+	if err != nil {
+		t.Errorf("sendOrQueue of an ignored file = %v", err)
+	}
+}
+
+// Ignored remote files are neither downloaded nor listed
+func TestIgnoredDownloadsSkipped(t *testing.T) {
+	useTestQueue(t)
+	useTestIgnore(t, "*.log\n")
+
+	err := downloadFile(&proto.DirEntry{Path: "/debug.log", Mode: 0644})
+	if err != nil {
+		t.Errorf("download of an ignored file = %v", err)
+	}
+	if _, err := os.Stat(localPath("/debug.log")); !os.IsNotExist(err) {
+		t.Errorf("ignored file downloaded; %v", err)
+	}
+	if !remoteIgnored("/sub/debug.log", 0644) || remoteIgnored("/"+lib.IGNORE_FILE, 0644) {
+		t.Error("remote entries not matched against the ignore file")
+	}
+}
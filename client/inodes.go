@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Inode numbers shown to applications are handed out per remote path
+// instead of being taken from the backing file. Re-downloads and
+// remote renames replace the backing file, and its inode number with
+// it, which confuses programs holding the file open.
+type inodeTable struct {
+	Next  uint64            `json:"next"`
+	Inos  map[string]uint64 `json:"inos"`
+	mu    sync.Mutex
+	dirty bool
+
+	// Pending write of the table; see saveInodesLater
+	saveTimer *time.Timer
+}
+
+// How long changes to the inode table wait before it is written, so a
+// burst of creates or a rename of a big directory costs one write
+const INODE_SAVE_DELAY = 2 * time.Second
+
+var inodes = inodeTable{
+	Next: 2, // 1 belongs to the root
+	Inos: make(map[string]uint64),
+}
+
+func inodeTablePath() string {
+	digest := md5.Sum([]byte(realpath))
+	return filepath.Join(lib.ProjectDir, "inodes", hex.EncodeToString(digest[:])+".json")
+}
+
+// Loads inode numbers handed out by previous runs
+func loadInodes() {
+	data, err := os.ReadFile(inodeTablePath())
+	if err != nil {
+		return
+	}
+
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	err = json.Unmarshal(data, &inodes)
+	if err != nil {
+		log.Printf("[FUSE] Error loading inode table; %v\n", err)
+	}
+	if inodes.Inos == nil {
+		inodes.Inos = make(map[string]uint64)
+	}
+	if inodes.Next < 2 {
+		inodes.Next = 2
+	}
+}
+
+// Writes the inode table to disk. Caller must hold inodes.mu
+func saveInodes() {
+	if !inodes.dirty {
+		return
+	}
+
+	path := inodeTablePath()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		log.Printf("[FUSE] Error saving inode table; %v\n", err)
+		return
+	}
+
+	data, err := json.Marshal(&inodes)
+	if err != nil {
+		log.Printf("[FUSE] Error saving inode table; %v\n", err)
+		return
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("[FUSE] Error saving inode table; %v\n", err)
+		return
+	}
+	inodes.dirty = false
+}
+
+// Writes the inode table to disk after INODE_SAVE_DELAY unless a write
+// is already pending. Caller must hold inodes.mu
+func saveInodesLater() {
+	if !inodes.dirty || inodes.saveTimer != nil {
+		return
+	}
+	inodes.saveTimer = time.AfterFunc(INODE_SAVE_DELAY, func() {
+		inodes.mu.Lock()
+		defer inodes.mu.Unlock()
+		inodes.saveTimer = nil
+		saveInodes()
+	})
+}
+
+// Writes pending changes to the inode table right away. Called before
+// the client exits
+func flushInodes() {
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	if inodes.saveTimer != nil {
+		inodes.saveTimer.Stop()
+		inodes.saveTimer = nil
+	}
+	saveInodes()
+}
+
+// Returns the stable inode number of relative path, assigning one if
+// the path has none yet
+func stableIno(path string) uint64 {
+	path = filepath.Clean("/" + path)
+	if path == "/" {
+		return 1
+	}
+
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	ino, ok := inodes.Inos[path]
+	if ok {
+		return ino
+	}
+
+	ino = inodes.Next
+	inodes.Next++
+	inodes.Inos[path] = ino
+	inodes.dirty = true
+	saveInodesLater()
+	return ino
+}
+
+// Gives newpath the same inode number as path. Used for hard links
+func linkIno(path, newpath string) uint64 {
+	ino := stableIno(path)
+
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	inodes.Inos[filepath.Clean("/"+newpath)] = ino
+	inodes.dirty = true
+	saveInodesLater()
+	return ino
+}
+
+// Moves the inode numbers of oldpath and everything below it over to
+// newpath
+func renameIno(oldpath, newpath string) {
+	oldpath = filepath.Clean("/" + oldpath)
+	newpath = filepath.Clean("/" + newpath)
+
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	for path, ino := range inodes.Inos {
+		if path != oldpath && !strings.HasPrefix(path, oldpath+"/") {
+			continue
+		}
+		delete(inodes.Inos, path)
+		inodes.Inos[newpath+strings.TrimPrefix(path, oldpath)] = ino
+		inodes.dirty = true
+	}
+	saveInodesLater()
+}
+
+// Drops the inode numbers of a deleted path and everything below it
+func forgetIno(path string) {
+	path = filepath.Clean("/" + path)
+
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	for p := range inodes.Inos {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(inodes.Inos, p)
+			inodes.dirty = true
+		}
+	}
+	saveInodesLater()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Starts the test with an empty inode table kept in a temporary
+// directory
+func useTestInodes(t *testing.T) {
+	oldDir, oldRealpath := lib.ProjectDir, realpath
+	lib.ProjectDir, realpath = t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		flushInodes()
+		lib.ProjectDir, realpath = oldDir, oldRealpath
+		inodes.mu.Lock()
+		inodes.Next, inodes.Inos = 2, make(map[string]uint64)
+		inodes.mu.Unlock()
+	})
+}
+
+// A burst of new inode numbers is written once, not once per number
+func TestSaveInodesBatched(t *testing.T) {
+	useTestInodes(t)
+
+	for i := range 100 {
+		stableIno("/file" + strconv.Itoa(i))
+	}
+	if _, err := os.Stat(inodeTablePath()); err == nil {
+		t.Fatal("inode table written before INODE_SAVE_DELAY")
+	}
+
+	flushInodes()
+	data, err := os.ReadFile(inodeTablePath())
+	if err != nil {
+		t.Fatalf("inode table not written on flush; %v", err)
+	}
+	saved := struct {
+		Inos map[string]uint64 `json:"inos"`
+	}{}
+	err = json.Unmarshal(data, &saved)
+	if err != nil || len(saved.Inos) != 100 {
+		t.Errorf("saved %v inode numbers, %v; want 100", len(saved.Inos), err)
+	}
+}
+
+// Returns the inode number the mount shows for name and the one of
+// the file backing it
+func lookupIno(t *testing.T, raw fuse.RawFileSystem, name string) (uint64, uint64) {
+	t.Helper()
+	entry := fuse.EntryOut{}
+	status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, name, &entry)
+	if !status.Ok() {
+		t.Fatalf("Lookup %v = %v", name, status)
+	}
+	st := syscall.Stat_t{}
+	err := syscall.Lstat(localPath("/"+name), &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry.Attr.Ino, st.Ino
+}
+
+// A file held open keeps the inode number it was opened with while
+// remote modifies and renames it
+func TestInodeStableAcrossRemoteChanges(t *testing.T) {
+	useTestQueue(t)
+	useTestInodes(t)
+	useTestRemote(t, &resumeServer{contents: []byte("modified on remote"), dropAfter: 1 << 20})
+	err := os.WriteFile(localPath("/file"), []byte("original"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := &Node{path: realpath}
+	raw := fs.NewNodeFS(root, &fs.Options{})
+	ino, backing := lookupIno(t, raw, "file")
+	fd, err := syscall.Open(localPath("/file"), syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	fh := NewLoopbackFile(fd, localPath("/file"), syscall.O_RDONLY).(*FileHandle)
+
+	handleFileEvent(&proto.FileEvent{Event: uint32(events.MODIFY_FILE), Path: "/file", Mode: 0644})
+	got, _ := os.ReadFile(localPath("/file"))
+	if string(got) != "modified on remote" {
+		t.Fatalf("file = %q after remote modify; want the new contents", got)
+	}
+	if gotIno, _ := lookupIno(t, raw, "file"); gotIno != ino {
+		t.Errorf("inode after remote modify = %v; want %v", gotIno, ino)
+	}
+
+	// Replaced by a new file on disk, as a resync or a download that
+	// renames into place does
+	err = os.WriteFile(localPath("/file.new"), []byte("replaced"), 0644)
+	if err == nil {
+		err = os.Rename(localPath("/file.new"), localPath("/file"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotIno, gotBacking := lookupIno(t, raw, "file")
+	if gotBacking == backing {
+		t.Fatal("backing file not replaced")
+	}
+	if gotIno != ino {
+		t.Errorf("inode after the backing file was replaced = %v; want %v", gotIno, ino)
+	}
+	node := root.GetChild("file").Operations().(*Node)
+	attr := fuse.AttrOut{}
+	if errno := node.Getattr(t.Context(), fh, &attr); errno != 0 || attr.Ino != ino {
+		t.Errorf("inode through open handle = %v, %v; want %v", attr.Ino, errno, ino)
+	}
+
+	handleFileEvent(&proto.FileEvent{Event: uint32(events.RENAME_FILE), Path: "/file", NewPath: "/renamed", Mode: 0644})
+	if gotIno, _ := lookupIno(t, raw, "renamed"); gotIno != ino {
+		t.Errorf("inode after remote rename = %v; want %v", gotIno, ino)
+	}
+}
+
+// Looking the same name up over and over hands back the one inode
+// go-fuse tracks rather than a new one each time
+func TestRepeatedLookupReusesChild(t *testing.T) {
+	useTestQueue(t)
+	useTestInodes(t)
+	err := os.WriteFile(localPath("/file"), []byte("file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &Node{path: realpath}
+	raw := fs.NewNodeFS(root, &fs.Options{})
+
+	first := fuse.EntryOut{}
+	if status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &first); !status.Ok() {
+		t.Fatalf("Lookup = %v", status)
+	}
+	child := root.GetChild("file")
+	for range 50 {
+		entry := fuse.EntryOut{}
+		raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &entry)
+		if entry.NodeId != first.NodeId || entry.Ino != first.Ino {
+			t.Fatalf("lookup = node %v ino %v; want node %v ino %v", entry.NodeId, entry.Ino, first.NodeId, first.Ino)
+		}
+		got, errno := root.Lookup(t.Context(), "file", &fuse.EntryOut{})
+		if errno != 0 || got != child {
+			t.Fatalf("Lookup = %p, %v; want the tracked child %p", got, errno, child)
+		}
+	}
+	if children := root.Children(); len(children) != 1 {
+		t.Errorf("root has %v children; want 1", len(children))
+	}
+
+	// Replaced by a directory, the name gets a new inode
+	err = os.Remove(localPath("/file"))
+	if err == nil {
+		err = os.Mkdir(localPath("/file"), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, errno := root.Lookup(t.Context(), "file", &fuse.EntryOut{})
+	if errno != 0 || got == child || !got.IsDir() {
+		t.Errorf("Lookup of replaced file = %p, %v; want a new directory inode", got, errno)
+	}
+}
+
+// Files created and deleted over and over leave no inodes behind once
+// the kernel forgets them
+func TestLiveInodesReturnToBaseline(t *testing.T) {
+	useTestQueue(t)
+	useTestInodes(t)
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	baseline := lib.LiveInodes.Load()
+
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	for i := range 200 {
+		name := "file" + strconv.Itoa(i%10)
+		out := fuse.CreateOut{}
+		status := raw.Create(nil, &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}, name, &out)
+		if !status.Ok() {
+			t.Fatalf("Create %v = %v", name, status)
+		}
+		if live := lib.LiveInodes.Load(); live != baseline+1 {
+			t.Fatalf("%v live inodes with one file; want %v", live, baseline+1)
+		}
+		raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: out.NodeId}, Fh: out.Fh})
+		if status := raw.Unlink(nil, &header, name); !status.Ok() {
+			t.Fatalf("Unlink %v = %v", name, status)
+		}
+		raw.Forget(out.NodeId, 1)
+	}
+
+	if live := lib.LiveInodes.Load(); live != baseline {
+		t.Errorf("%v live inodes after the files were forgotten; want %v", live, baseline)
+	}
+
+	// The creates and unlinks are queued in the background; they must
+	// not land in the next test's queue
+	deadline := time.Now().Add(5 * time.Second)
+	for len(loadQueue()) < 400 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v ops queued; want a create and an unlink of each file", len(loadQueue()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Holds every Unlink until released and counts Lookups
+type slowUnlinkServer struct {
+	proto.UnimplementedFuseServer
+	unlinking chan struct{}
+	release   chan struct{}
+	lookups   *atomic.Int32
+}
+
+func (s slowUnlinkServer) Unlink(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
+	s.unlinking <- struct{}{}
+	<-s.release
+	return &emptypb.Empty{}, nil
+}
+
+func (s slowUnlinkServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
+	s.lookups.Add(1)
+	return nil, status.Error(codes.NotFound, "not found")
+}
+
+// An unlinked file leaves the tree at once, and looking it up again
+// fails without asking remote, which still has it
+func TestUnlinkDropsChild(t *testing.T) {
+	useTestQueue(t)
+	useTestInodes(t)
+	srv := slowUnlinkServer{
+		unlinking: make(chan struct{}),
+		release:   make(chan struct{}),
+		lookups:   &atomic.Int32{},
+	}
+	useTestRemote(t, srv)
+	online.Store(true)
+	err := os.WriteFile(localPath("/file"), []byte("file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &Node{path: realpath}
+	raw := fs.NewNodeFS(root, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	if status := raw.Lookup(nil, &header, "file", &fuse.EntryOut{}); !status.Ok() {
+		t.Fatalf("Lookup = %v", status)
+	}
+
+	if status := raw.Unlink(nil, &header, "file"); !status.Ok() {
+		t.Fatalf("Unlink = %v", status)
+	}
+	if root.GetChild("file") != nil || findInode("/file") != nil {
+		t.Error("unlinked file still in the tree")
+	}
+	<-srv.unlinking
+	if status := raw.Lookup(nil, &header, "file", &fuse.EntryOut{}); status != fuse.ENOENT {
+		t.Errorf("Lookup after unlink = %v; want ENOENT", status)
+	}
+	if n := srv.lookups.Load(); n != 0 {
+		t.Errorf("Lookup after unlink asked remote %v times; want none", n)
+	}
+
+	close(srv.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for hasPendingChanges("/file") {
+		if time.Now().After(deadline) {
+			t.Fatal("remote unlink never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
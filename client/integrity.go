@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// Extended attribute holding the hash of a local file as of the last
+// time it was known to match remote
+const HASH_XATTR = "user.fusion.hash"
+
+// Synced hashes back -verify-reads and let reconcile tell files that
+// still match remote from ones edited here
+func tracksHashes() bool {
+	return verifyReads || reconcileRemote
+}
+
+// Records hash as the last synced hash of path
+func storeHash(path, hash string) {
+	if !tracksHashes() {
+		return
+	}
+	err := syscall.Setxattr(path, HASH_XATTR, []byte(hash), 0)
+	if err != nil {
+		log.Printf("[SYNC] Error storing hash of %v; %v\n", path, err)
+	}
+}
+
+// Returns the last synced hash of path or an empty string if there
+// is none
+func storedHash(path string) string {
+	buf := make([]byte, 64)
+	n, err := syscall.Getxattr(path, HASH_XATTR, buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+// Forgets the synced hash of path. Called when the file is changed
+// locally since the stored hash no longer describes it
+func clearHash(path string) {
+	if !tracksHashes() {
+		return
+	}
+	syscall.Removexattr(path, HASH_XATTR)
+}
+
+// Checks the contents of a local file against its last synced hash.
+// Files without a stored hash pass
+func verifyIntegrity(path string) bool {
+	expected := storedHash(path)
+	if expected == "" {
+		return true
+	}
+
+	actual, err := localFileHash(path)
+	if err != nil {
+		return false
+	}
+	return actual == expected
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Reads path through a new handle
+func readHandle(t *testing.T, path string) ([]byte, syscall.Errno) {
+	t.Helper()
+	fd, err := syscall.Open(path, syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+
+	fh := NewLoopbackFile(fd, path, syscall.O_RDONLY).(*FileHandle)
+	buf := make([]byte, 64)
+	res, errno := fh.Read(context.Background(), buf, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	data, status := res.Bytes(buf)
+	if !status.Ok() {
+		t.Fatalf("reading result = %v", status)
+	}
+	return data, 0
+}
+
+// A local copy that no longer matches its synced hash is refused and
+// downloaded again
+func TestVerifyReadsRepairsCorruption(t *testing.T) {
+	useTestQueue(t)
+	contents := []byte("synced contents")
+	useTestRemote(t, &resumeServer{contents: contents, dropAfter: len(contents) + 1})
+	old := verifyReads
+	verifyReads = true
+	t.Cleanup(func() { verifyReads = old })
+
+	err := downloadFile(&proto.DirEntry{Path: "/file", Mode: 0644})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := localPath("/file")
+	if storedHash(path) == "" {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+	if data, errno := readHandle(t, path); errno != 0 || !bytes.Equal(data, contents) {
+		t.Fatalf("read of synced file = %q, %v; want %q", data, errno, contents)
+	}
+
+	// Flip a byte behind our back, keeping the hash
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("S"), 0)
+	file.Close()
+
+	if _, errno := readHandle(t, path); errno != syscall.EIO {
+		t.Fatalf("read of corrupt file = %v; want EIO", errno)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := os.ReadFile(path)
+		if bytes.Equal(got, contents) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file still holds %q; want it repaired to %q", got, contents)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if data, errno := readHandle(t, path); errno != 0 || !bytes.Equal(data, contents) {
+		t.Errorf("read after repair = %q, %v; want %q", data, errno, contents)
+	}
+}
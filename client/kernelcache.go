@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// The kernel caches what we tell it so that it doesn't have to ask
+// again:
+//
+//	-entry-timeout     name -> inode lookups
+//	-attr-timeout      file attributes (size, mtime, mode...)
+//	-negative-timeout  lookups of names that don't exist
+//
+// Longer timeouts mean fewer calls into the client, but changes that
+// bypass the mount go unseen until they expire. Changes made through
+// the mount are handled by the kernel itself. Changes from remote
+// are written straight to realpath, so whatever the kernel cached
+// for them is dropped by hand; see invalidateEntry and
+// invalidateContent. That leaves only edits made directly in
+// realpath showing late, by at most the timeout
+
+// Root of the mounted filesystem
+var rootNode *Node
+
+// Returns the inode of relative path if the kernel knows of it. If
+// a directory on the way was never looked up nothing below it is
+// cached either
+func findInode(path string) *fs.Inode {
+	if rootNode == nil {
+		return nil
+	}
+
+	inode := rootNode.EmbeddedInode()
+	for _, name := range strings.Split(strings.Trim(filepath.Clean("/"+path), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		inode = inode.GetChild(name)
+		if inode == nil {
+			return nil
+		}
+	}
+	return inode
+}
+
+// Drops whatever the kernel has cached for relative path, including
+// a cached "no such file"
+func invalidateEntry(path string) {
+	path = filepath.Clean("/" + path)
+	if path == "/" {
+		return
+	}
+
+	parent := findInode(filepath.Dir(path))
+	if parent == nil {
+		return
+	}
+	// ENOENT only means the kernel had nothing cached
+	parent.NotifyEntry(filepath.Base(path))
+}
+
+// Drops the cached attributes and contents of relative path
+func invalidateContent(path string) {
+	inode := findInode(path)
+	if inode == nil {
+		return
+	}
+	inode.NotifyContent(0, 0)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Has nothing; counts the lookups it is sent
+type missServer struct {
+	proto.UnimplementedFuseServer
+	lookups atomic.Int32
+}
+
+func (s *missServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
+	s.lookups.Add(1)
+	return nil, status.Error(codes.NotFound, "no such file")
+}
+
+// Probing a missing name over and over asks remote about it once,
+// until remote reports a change to it
+func TestMissingNameAskedOnce(t *testing.T) {
+	useTestQueue(t)
+	srv := &missServer{}
+	useTestRemote(t, srv)
+	online.Store(true)
+	oldMisses := remoteMisses
+	remoteMisses = make(map[string]time.Time)
+	t.Cleanup(func() { remoteMisses = oldMisses })
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	probe := func() {
+		t.Helper()
+		for range 100 {
+			status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, ".config", &fuse.EntryOut{})
+			if status != fuse.ENOENT {
+				t.Fatalf("Lookup of missing name = %v; want ENOENT", status)
+			}
+		}
+	}
+
+	probe()
+	if n := srv.lookups.Load(); n != 1 {
+		t.Errorf("100 probes sent %v lookups to remote; want 1", n)
+	}
+
+	forgetRemoteMiss("/.config")
+	probe()
+	if n := srv.lookups.Load(); n != 2 {
+		t.Errorf("probes after a remote change sent %v lookups in all; want 2", n)
+	}
+}
+
+// The kernel answers repeated lookups of a missing name itself until
+// the name is created through the mount or invalidated
+func TestNegativeEntriesCachedByKernel(t *testing.T) {
+	oldTimeout := negativeTimeout
+	negativeTimeout = time.Minute
+	t.Cleanup(func() { negativeTimeout = oldTimeout })
+	useTestMount(t)
+
+	missing := func(name string) bool {
+		_, err := os.Stat(filepath.Join(mountpoint, name))
+		return errors.Is(err, syscall.ENOENT)
+	}
+
+	if !missing("remote") {
+		t.Fatal("missing name found")
+	}
+	// Arrives the way files from remote do, behind the kernel's back
+	err := os.WriteFile(filepath.Join(realpath, "remote"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !missing("remote") {
+		t.Fatal("negative entry not cached; the lookup reached the client")
+	}
+	invalidateEntry("/remote")
+	if missing("remote") {
+		t.Error("name still missing after invalidateEntry")
+	}
+
+	if !missing("created") {
+		t.Fatal("missing name found")
+	}
+	err = os.WriteFile(filepath.Join(mountpoint, "created"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing("created") {
+		t.Error("name created through the mount still missing")
+	}
+}
+
+// -entry-timeout and -attr-timeout decide how long changes made behind
+// the kernel's back go unseen; invalidation shows them at once
+func TestKernelCacheTimeouts(t *testing.T) {
+	for _, timeout := range []time.Duration{time.Minute, 0} {
+		t.Run(timeout.String(), func(t *testing.T) {
+			oldEntry, oldAttr := entryTimeout, attrTimeout
+			entryTimeout, attrTimeout = timeout, timeout
+			t.Cleanup(func() { entryTimeout, attrTimeout = oldEntry, oldAttr })
+			useTestMount(t)
+			cached := timeout > 0
+
+			info, err := os.Stat(filepath.Join(mountpoint, "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(realpath, "file"), []byte("longer contents"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err = os.Stat(filepath.Join(mountpoint, "file"))
+			if err != nil || (info.Size() == 4) != cached {
+				t.Errorf("size after change = %v, %v; want the cached size only while attributes are cached", info.Size(), err)
+			}
+			invalidateContent("/file")
+			info, err = os.Stat(filepath.Join(mountpoint, "file"))
+			if err != nil || info.Size() != int64(len("longer contents")) {
+				t.Errorf("size after invalidateContent = %v, %v; want the new size", info.Size(), err)
+			}
+
+			err = os.Rename(filepath.Join(realpath, "file"), filepath.Join(realpath, "moved"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = os.Lstat(filepath.Join(mountpoint, "file"))
+			if (err == nil) != cached {
+				t.Errorf("lookup of moved name = %v; want it found only while entries are cached", err)
+			}
+			invalidateEntry("/file")
+			if _, err := os.Lstat(filepath.Join(mountpoint, "file")); !errors.Is(err, syscall.ENOENT) {
+				t.Errorf("lookup after invalidateEntry = %v; want ENOENT", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Number of recent remote events sync lag is computed over
+const LAG_SAMPLES = 1024
+
+var (
+	// How long each recent remote event took to be applied locally,
+	// counted from when remote sent it. Used as a ring buffer
+	syncLags    = make([]time.Duration, 0, LAG_SAMPLES)
+	nextSyncLag int
+	syncLagsMu  = sync.Mutex{}
+)
+
+func init() {
+	registerStatus("sync_lag", func() any {
+		p50, p95, samples := syncLag()
+		return map[string]any{
+			"p50":     p50.String(),
+			"p95":     p95.String(),
+			"samples": samples,
+		}
+	})
+}
+
+// Records how far behind remote we were when an event sent at
+// sentAt finished applying
+func recordSyncLag(sentAt *timestamppb.Timestamp) {
+	if sentAt == nil {
+		return
+	}
+	// Clocks of client and remote can disagree a little
+	lag := max(time.Since(sentAt.AsTime()), 0)
+
+	syncLagsMu.Lock()
+	defer syncLagsMu.Unlock()
+
+	if len(syncLags) < LAG_SAMPLES {
+		syncLags = append(syncLags, lag)
+		return
+	}
+	syncLags[nextSyncLag] = lag
+	nextSyncLag = (nextSyncLag + 1) % LAG_SAMPLES
+}
+
+// Returns the median and 95th percentile lag of recent remote events
+// along with how many events they were computed from
+func syncLag() (p50, p95 time.Duration, samples int) {
+	syncLagsMu.Lock()
+	sorted := slices.Clone(syncLags)
+	syncLagsMu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0, 0, 0
+	}
+	slices.Sort(sorted)
+	return percentile(sorted, 50), percentile(sorted, 95), len(sorted)
+}
+
+// Nearest rank percentile of sorted lags
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank-1, 0)]
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Starts the test with no sync lag recorded
+func useTestSyncLags(t *testing.T) {
+	reset := func() {
+		syncLagsMu.Lock()
+		syncLags, nextSyncLag = make([]time.Duration, 0, LAG_SAMPLES), 0
+		syncLagsMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func checkLag(t *testing.T, what string, got, want time.Duration) {
+	t.Helper()
+	// Applying the events takes a little while too
+	if got < want || got > want+time.Second {
+		t.Errorf("%v = %v; want about %v", what, got, want)
+	}
+}
+
+// Events sent 1s to 20s ago put the median at 10s and the 95th
+// percentile at 19s, in status as well
+func TestSyncLagOfAppliedEvents(t *testing.T) {
+	useTestQueue(t)
+	useTestSyncLags(t)
+
+	now := time.Now()
+	for i := 1; i <= 20; i++ {
+		handleFileEvent(&proto.FileEvent{
+			Event:     uint32(events.ADD_FILE),
+			Path:      "/dir" + strconv.Itoa(i),
+			Mode:      uint32(os.ModeDir | 0755),
+			Timestamp: timestamppb.New(now.Add(-time.Duration(i) * time.Second)),
+		})
+	}
+	// Without a timestamp there's nothing to measure; from a clock
+	// running ahead the lag is no less than none
+	handleFileEvent(&proto.FileEvent{Event: uint32(events.ADD_FILE), Path: "/untimed", Mode: uint32(os.ModeDir | 0755)})
+
+	p50, p95, samples := syncLag()
+	if samples != 20 {
+		t.Fatalf("lag computed over %v events; want 20", samples)
+	}
+	checkLag(t, "p50", p50, 10*time.Second)
+	checkLag(t, "p95", p95, 19*time.Second)
+
+	recordSyncLag(timestamppb.New(time.Now().Add(time.Hour)))
+	if _, _, samples := syncLag(); samples != 21 {
+		t.Errorf("lag computed over %v events; want 21", samples)
+	}
+
+	w := httptest.NewRecorder()
+	statusHandler(w, httptest.NewRequest("GET", "/status", nil))
+	report := struct {
+		SyncLag struct {
+			P50     string `json:"p50"`
+			P95     string `json:"p95"`
+			Samples int    `json:"samples"`
+		} `json:"sync_lag"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SyncLag.Samples != 21 || report.SyncLag.P50 == "" || report.SyncLag.P95 == "" {
+		t.Errorf("status sync_lag = %+v; want p50, p95 over 21 events", report.SyncLag)
+	}
+}
+
+// Only the most recent LAG_SAMPLES events count
+func TestSyncLagKeepsRecentEvents(t *testing.T) {
+	useTestSyncLags(t)
+
+	old := timestamppb.New(time.Now().Add(-time.Hour))
+	for range LAG_SAMPLES {
+		recordSyncLag(old)
+	}
+	recent := timestamppb.New(time.Now().Add(-time.Second))
+	for range LAG_SAMPLES {
+		recordSyncLag(recent)
+	}
+
+	p50, p95, samples := syncLag()
+	if samples != LAG_SAMPLES {
+		t.Errorf("lag computed over %v events; want %v", samples, LAG_SAMPLES)
+	}
+	checkLag(t, "p50", p50, time.Second)
+	checkLag(t, "p95", p95, time.Second)
+}
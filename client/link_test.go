@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// A hard link made in another directory links the target itself and
+// shares its inode number
+func TestLinkIntoSubdirectory(t *testing.T) {
+	useTestListings(t)
+	useTestQueue(t)
+	raw, header := directionFixture(t)
+	fileEntry := fuse.EntryOut{}
+	if status := raw.Lookup(nil, &header, "file", &fileEntry); !status.Ok() {
+		t.Fatalf("Lookup file = %v", status)
+	}
+	dirHeader := header
+	dirHeader.NodeId = lookupId(t, raw, header, "dir")
+
+	linked := fuse.EntryOut{}
+	status := raw.Link(nil, &fuse.LinkIn{InHeader: dirHeader, Oldnodeid: fileEntry.NodeId}, "hard", &linked)
+	if !status.Ok() {
+		t.Fatalf("Link into dir = %v", status)
+	}
+	if linked.Ino != fileEntry.Ino {
+		t.Errorf("link has inode %v; want the target's %v", linked.Ino, fileEntry.Ino)
+	}
+	if linked.Nlink != 2 {
+		t.Errorf("link has %v links; want 2", linked.Nlink)
+	}
+
+	target, err := os.Stat(filepath.Join(realpath, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := os.Stat(filepath.Join(realpath, "dir", "hard"))
+	if err != nil {
+		t.Fatalf("no link in dir; %v", err)
+	}
+	if !os.SameFile(target, link) {
+		t.Error("dir/hard is not a link to file")
+	}
+
+	// A name that's taken fails and leaves both files alone
+	status = raw.Link(nil, &fuse.LinkIn{InHeader: header, Oldnodeid: fileEntry.NodeId}, "dir", &fuse.EntryOut{})
+	if status != fuse.Status(syscall.EEXIST) {
+		t.Errorf("Link onto an existing name = %v; want EEXIST", status)
+	}
+	if _, err := os.Stat(filepath.Join(realpath, "file")); err != nil {
+		t.Errorf("failed link removed its target; %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Remote directory listings as of the last manifest fetch. Readdir
+// merges them with the local directory so files not downloaded yet,
+// whether because we are offline or still syncing, show up too.
+// Looking one of them up creates an on-demand placeholder for it.
+//
+// The listings are kept on disk so a client starting offline still
+// knows what remote has
+
+// Extended attribute reporting whether a file is available locally.
+// Read only; see syncStatus
+const SYNC_STATUS_XATTR = "user.fusion.status"
+
+const (
+	STATUS_SYNCED = "synced" // local copy matches remote
+	STATUS_REMOTE = "remote" // only on remote; downloaded on open
+)
+
+type listedEntry struct {
+	Mode   uint32 `json:"mode"` // os.FileMode
+	Size   uint64 `json:"size"`
+	Target string `json:"target,omitempty"` // of a symlink, if known
+}
+
+// How long changes to the listings wait before they are written, so a
+// manifest fetch setting every directory's listing costs one write
+const LISTINGS_SAVE_DELAY = 2 * time.Second
+
+var (
+	// Relative directory path -> entry name -> entry
+	listings   = make(map[string]map[string]listedEntry)
+	listingsMu = sync.Mutex{}
+
+	// Pending write of the listings; see saveListingsLater
+	listingsTimer *time.Timer
+)
+
+func listingsPath() string {
+	digest := md5.Sum([]byte(realpath))
+	return filepath.Join(lib.ProjectDir, "listings", hex.EncodeToString(digest[:])+".json")
+}
+
+// Loads the listings saved by a previous run
+func loadListings() {
+	data, err := os.ReadFile(listingsPath())
+	if err != nil {
+		return
+	}
+
+	listingsMu.Lock()
+	defer listingsMu.Unlock()
+
+	err = json.Unmarshal(data, &listings)
+	if err != nil {
+		log.Printf("[SYNC] Error loading remote listings; %v\n", err)
+	}
+	if listings == nil {
+		listings = make(map[string]map[string]listedEntry)
+	}
+}
+
+// Caller must hold listingsMu
+func saveListings() {
+	path := listingsPath()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		log.Printf("[SYNC] Error saving remote listings; %v\n", err)
+		return
+	}
+
+	data, err := json.Marshal(listings)
+	if err != nil {
+		log.Printf("[SYNC] Error saving remote listings; %v\n", err)
+		return
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("[SYNC] Error saving remote listings; %v\n", err)
+	}
+}
+
+// Writes the listings to disk after LISTINGS_SAVE_DELAY unless a write
+// is already pending. Caller must hold listingsMu
+func saveListingsLater() {
+	if listingsTimer != nil {
+		return
+	}
+	listingsTimer = time.AfterFunc(LISTINGS_SAVE_DELAY, func() {
+		listingsMu.Lock()
+		defer listingsMu.Unlock()
+		listingsTimer = nil
+		saveListings()
+	})
+}
+
+// Writes pending changes to the listings right away. Called before the
+// client exits
+func flushListings() {
+	listingsMu.Lock()
+	defer listingsMu.Unlock()
+
+	if listingsTimer == nil {
+		return
+	}
+	listingsTimer.Stop()
+	listingsTimer = nil
+	saveListings()
+}
+
+// Replaces the listing of directory dir with entries fetched from
+// remote
+func setListing(dir string, entries map[string]listedEntry) {
+	listingsMu.Lock()
+	defer listingsMu.Unlock()
+
+	listings[filepath.Clean("/"+dir)] = entries
+	saveListingsLater()
+}
+
+// Returns the remote entry of relative path if remote had one
+func lookupListing(path string) (listedEntry, bool) {
+	path = filepath.Clean("/" + path)
+
+	listingsMu.Lock()
+	defer listingsMu.Unlock()
+
+	entry, ok := listings[filepath.Dir(path)][filepath.Base(path)]
+	return entry, ok
+}
+
+// Drops path and everything below it from the listings. Called when
+// path is removed or renamed so that it doesn't come back
+func forgetListing(path string) {
+	path = filepath.Clean("/" + path)
+
+	listingsMu.Lock()
+	defer listingsMu.Unlock()
+
+	entries := listings[filepath.Dir(path)]
+	_, ok := entries[filepath.Base(path)]
+	if !ok {
+		return
+	}
+	delete(entries, filepath.Base(path))
+	for dir := range listings {
+		if dir == path || len(dir) > len(path) && dir[:len(path)+1] == path+"/" {
+			delete(listings, dir)
+		}
+	}
+	saveListingsLater()
+}
+
+// Returns entries of remote directory dir missing from the local
+// directory listing local
+func remoteOnlyEntries(dir string, local []fuse.DirEntry) []fuse.DirEntry {
+	dir = filepath.Clean("/" + dir)
+
+	listingsMu.Lock()
+	defer listingsMu.Unlock()
+
+	remote := listings[dir]
+	if len(remote) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(local))
+	for _, entry := range local {
+		seen[entry.Name] = true
+	}
+
+	missing := []fuse.DirEntry{}
+	for name, entry := range remote {
+		if seen[name] {
+			continue
+		}
+		missing = append(missing, fuse.DirEntry{
+			Name: name,
+			Mode: fuseMode(os.FileMode(entry.Mode)),
+		})
+	}
+	return missing
+}
+
+func fuseMode(mode os.FileMode) uint32 {
+	switch {
+	case mode.IsDir():
+		return fuse.S_IFDIR | uint32(mode.Perm())
+	case mode&os.ModeSymlink != 0:
+		return syscall.S_IFLNK | uint32(mode.Perm())
+	default:
+		return fuse.S_IFREG | uint32(mode.Perm())
+	}
+}
+
+// Creates the local stand in of a remote entry that was listed but
+// never downloaded. Directories and symlinks are created as is; files
+// become placeholders downloaded on open
+func materialize(path string, entry listedEntry) error {
+	mode := os.FileMode(entry.Mode)
+	fullpath := localPath(path)
+
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(fullpath, mode.Perm())
+	case mode&os.ModeSymlink != 0:
+		target := entry.Target
+		if target == "" {
+			// Listings don't carry link targets
+			var err error
+			target, err = remoteLinkTarget(path)
+			if err != nil {
+				return err
+			}
+		}
+		return os.Symlink(target, fullpath)
+	case mode.IsRegular():
+		deferDownload(path, entry.Size, entry.Mode)
+		return nil
+	default:
+		// Devices and the like are left out
+		return syscall.ENOENT
+	}
+}
+
+// Sync status of relative path as reported by SYNC_STATUS_XATTR
+func syncStatus(path string) string {
+	onDemandMu.Lock()
+	defer onDemandMu.Unlock()
+
+	_, ok := onDemand[path]
+	if ok {
+		return STATUS_REMOTE
+	}
+	return STATUS_SYNCED
+}
+
+// Only SYNC_STATUS_XATTR is exposed; the other attributes on the
+// backing files are our own bookkeeping
+func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	if attr != SYNC_STATUS_XATTR {
+		return 0, syscall.ENODATA
+	}
+
+	value := syncStatus(relativePath(n.path))
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), fs.OK
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Points the listings at temporary directories and empties them
+// after the test
+func useTestListings(t *testing.T) {
+	oldDir, oldRealpath := lib.ProjectDir, realpath
+	lib.ProjectDir, realpath = t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		flushListings()
+		lib.ProjectDir, realpath = oldDir, oldRealpath
+		listingsMu.Lock()
+		listings = make(map[string]map[string]listedEntry)
+		listingsMu.Unlock()
+	})
+}
+
+// Setting many listings at once writes them once, not once per
+// directory
+func TestSaveListingsBatched(t *testing.T) {
+	useTestListings(t)
+
+	for i := range 100 {
+		setListing("/dir"+strconv.Itoa(i), map[string]listedEntry{"file": {Size: 1}})
+	}
+	if _, err := os.Stat(listingsPath()); err == nil {
+		t.Fatal("listings written before LISTINGS_SAVE_DELAY")
+	}
+
+	flushListings()
+	data, err := os.ReadFile(listingsPath())
+	if err != nil {
+		t.Fatalf("listings not written on flush; %v", err)
+	}
+	saved := map[string]map[string]listedEntry{}
+	err = json.Unmarshal(data, &saved)
+	if err != nil || len(saved) != 100 {
+		t.Errorf("saved %v listings, %v; want 100", len(saved), err)
+	}
+}
+
+// Files remote listed but we never downloaded are listed alongside the
+// local ones and report that they are only on remote
+func TestReaddirListsRemoteOnlyEntries(t *testing.T) {
+	useTestListings(t)
+	useTestInodes(t)
+	t.Cleanup(func() {
+		onDemandMu.Lock()
+		delete(onDemand, "/remote.txt")
+		onDemandMu.Unlock()
+	})
+
+	err := os.WriteFile(filepath.Join(realpath, "local.txt"), []byte("local"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setListing("/", map[string]listedEntry{
+		"local.txt":  {Mode: 0644, Size: 5},
+		"remote.txt": {Mode: 0600, Size: 1 << 20},
+		"remote-dir": {Mode: uint32(os.ModeDir | 0755)},
+	})
+
+	stream, errno := (&Node{path: realpath}).Readdir(context.Background())
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	listed := map[string]uint32{}
+	for stream.HasNext() {
+		entry, errno := stream.Next()
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		listed[entry.Name] = entry.Mode
+	}
+	stream.Close()
+	names := []string{}
+	for name := range listed {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"local.txt", "remote-dir", "remote.txt"}) {
+		t.Fatalf("listed %v; want local.txt, remote-dir and remote.txt", names)
+	}
+	if listed["local.txt"]&syscall.S_IFMT != syscall.S_IFREG {
+		t.Errorf("local.txt listed with mode %o; want a file", listed["local.txt"])
+	}
+	for name, want := range map[string]uint32{"remote.txt": syscall.S_IFREG | 0600, "remote-dir": syscall.S_IFDIR | 0755} {
+		if listed[name] != want {
+			t.Errorf("%v listed with mode %o; want %o", name, listed[name], want)
+		}
+	}
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	for name, want := range map[string]string{"local.txt": STATUS_SYNCED, "remote.txt": STATUS_REMOTE} {
+		entry := fuse.EntryOut{}
+		status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, name, &entry)
+		if !status.Ok() {
+			t.Fatalf("Lookup %v = %v", name, status)
+		}
+		dest := make([]byte, 64)
+		n, status := raw.GetXAttr(nil, &fuse.InHeader{NodeId: entry.NodeId}, SYNC_STATUS_XATTR, dest)
+		if !status.Ok() || string(dest[:n]) != want {
+			t.Errorf("%v of %v = %q, %v; want %q", SYNC_STATUS_XATTR, name, dest[:n], status, want)
+		}
+	}
+}
@@ -9,12 +9,16 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -24,13 +28,35 @@ var (
 	realpath, mountpoint string
 	email, password      string
 	orgName, deptName    string
+	connections          int
+	createMountpoint     bool
+	largeFileThreshold   int64
+	verifyReads          bool
+	endToEnd             bool
+	entryTimeout         time.Duration
+	attrTimeout          time.Duration
+	negativeTimeout      time.Duration
+	daemon               bool
+	syncTimeout          time.Duration
+	syncCreate           bool
+	defaultPermissions   bool
+	useTLS               bool
+	scope                string
+	remoteDelete         string
+	trashRetention       time.Duration
+	confirmDeletes       bool
+	reconcileRemote      bool
+	syncWindowFlag       string
+	statfsTTL            time.Duration
+	cancelPath           string
+	autoRemount          bool
 
 	fuseServer *fuse.Server
 	grpcClient proto.FuseClient
 	authToken  string
 )
 
-func init() {
+func parseArgs() {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatalf("Error getting user's home dir; %v\n", err)
@@ -40,6 +66,7 @@ func init() {
 	authFlag.StringVar(&email, "email", "", "Name of the user connecting to remote")
 	authFlag.StringVar(&password, "password", "", "Password of the user connecting to remote")
 	authFlag.StringVar(&remote, "remote", "", "Remote GRPC FUSE server.")
+	authFlag.BoolVar(&useTLS, "tls", false, "Connect to remote over TLS, verifying its certificate against the system roots.")
 
 	runFlag := flag.NewFlagSet("run", flag.ExitOnError)
 	runFlag.BoolVar(&debug, "debug", false, "Display FUSE debug logs to stdout.")
@@ -48,6 +75,62 @@ func init() {
 	runFlag.StringVar(&email, "email", "", "Name of the user connecting to remote")
 	runFlag.StringVar(&password, "password", "", "Password of the user connecting to remote")
 	runFlag.StringVar(&remote, "remote", "", "Remote GRPC FUSE server.")
+	runFlag.BoolVar(&useTLS, "tls", false, "Connect to remote over TLS, verifying its certificate against the system roots.")
+	runFlag.StringVar(&scope, "scope", SCOPE_SHARED, "shared mounts your department's files, personal only your own directory in it that other users can't see.")
+	runFlag.BoolVar(&createMountpoint, "create-mountpoint", true, "Create -mountpoint if it does not exist.")
+	runFlag.Int64Var(&largeFileThreshold, "large-file-threshold", 1024, "Files larger than this many MB are only downloaded when opened. 0 disables.")
+	runFlag.BoolVar(&verifyReads, "verify-reads", false, "Verify files against their last synced hash before reading them.")
+	runFlag.IntVar(&connections, "connections", 4, "Number of GRPC connections used for parallel downloads.")
+	runFlag.DurationVar(&entryTimeout, "entry-timeout", time.Second, "How long the kernel caches file name lookups. Longer means fewer lookups but slower to notice files changed directly in -realpath. 0 disables.")
+	runFlag.DurationVar(&attrTimeout, "attr-timeout", time.Second, "How long the kernel caches file attributes such as size and mtime. Same tradeoff as -entry-timeout.")
+	runFlag.DurationVar(&negativeTimeout, "negative-timeout", time.Second, "How long the kernel remembers that a file does not exist. 0 disables.")
+	runFlag.DurationVar(&statfsTTL, "statfs-ttl", 2*time.Second, "How long disk usage of -realpath is reused between Statfs calls, eg. from df. 0 disables.")
+	runFlag.DurationVar(&syncTimeout, "sync-timeout", 10*time.Minute, "Longest a single background call to remote may take before it is queued for retry. 0 disables.")
+	runFlag.BoolVar(&syncCreate, "sync-create", false, "Wait for remote to create a file before reporting it created; a file remote refuses is removed again. Slower but never leaves files only you can see.")
+	runFlag.BoolVar(&defaultPermissions, "default-permissions", false, "Let the kernel check file modes and owners before any request reaches the client or remote.")
+	runFlag.StringVar(&ownerMapFlag, "owner-map", "", "Local accounts shown as the owners of other users' files; eg. bob@example.com=bob. Files of anyone not listed show as yours.")
+	runFlag.StringVar(&remoteDelete, "remote-delete", REMOTE_DELETE_TRASH, "What to do with local files deleted on remote; trash keeps them for -trash-retention, remove deletes them right away.")
+	runFlag.DurationVar(&trashRetention, "trash-retention", 7*24*time.Hour, "How long files deleted on remote are kept in the trash.")
+	runFlag.BoolVar(&confirmDeletes, "confirm-deletes", false, "Check with remote that a file is really gone before acting on its delete event.")
+	runFlag.BoolVar(&reconcileRemote, "reconcile", true, "On startup and reconnect, remove local files deleted on remote while the client was away and fetch files it missed. Removals follow -remote-delete.")
+	runFlag.IntVar(&maxOpenFiles, "max-open-files", 1024, "Most files applications may hold open on the mount at once; opening more fails with EMFILE. 0 means unlimited.")
+	runFlag.Int64Var(&bandwidthLimit, "bandwidth", 0, "Most KiB per second uploads and downloads may use together. 0 means unlimited.")
+	runFlag.StringVar(&syncDirection, "sync-direction", SYNC_BIDIRECTIONAL, "Which way changes sync; bidirectional, pull keeps a read-only mirror of remote, push sends local changes but never applies remote's.")
+	runFlag.DurationVar(&rpcTimeout, "rpc-timeout", 2*time.Minute, "Deadline of calls to remote that don't set their own, covering all retries. 0 disables.")
+	runFlag.StringVar(&rpcMethodTimeoutFlag, "rpc-method-timeouts", "ReadAll=10m,Write=10m", "Per method deadlines overriding -rpc-timeout; eg. Write=10m,Lookup=5s.")
+	runFlag.IntVar(&rpcAttempts, "rpc-attempts", 3, "Most times a call to remote that is safe to repeat is tried when remote is unavailable. 1 disables retries.")
+	runFlag.DurationVar(&rpcBackoff, "rpc-backoff", 200*time.Millisecond, "Wait before the first retry of a call to remote; doubled for each later one.")
+	runFlag.BoolVar(&autoRemount, "remount", true, "Mount the filesystem again when something other than the client unmounts it, eg. fusermount -u or an aborted connection.")
+	runFlag.StringVar(&syncWindowFlag, "sync-window", SYNC_WINDOW_ALWAYS, "Local hours background downloads and reconciliation may run in; eg. 22:00-06:00,12:00-13:00. Files you open are always downloaded.")
+	runFlag.BoolVar(&daemon, "daemon", false, "Run in the background. Logs go to "+logFile+"; stop it with the unmount command.")
+	runFlag.BoolVar(&endToEnd, "e2e", false, "Encrypt file contents before sending them to remote. The passphrase is read from $"+E2E_PASSPHRASE_ENV+".")
+
+	resyncFlag := flag.NewFlagSet("resync", flag.ExitOnError)
+	resyncFlag.StringVar(&realpath, "realpath", "", "Physical directory where files are stored")
+	resyncFlag.StringVar(&email, "email", "", "Name of the user connecting to remote")
+	resyncFlag.StringVar(&password, "password", "", "Password of the user connecting to remote")
+	resyncFlag.StringVar(&remote, "remote", "", "Remote GRPC FUSE server.")
+	resyncFlag.BoolVar(&useTLS, "tls", false, "Connect to remote over TLS, verifying its certificate against the system roots.")
+	resyncFlag.StringVar(&scope, "scope", SCOPE_SHARED, "Which remote directory to resync with; see run -scope.")
+	resyncFlag.StringVar(&resyncMode, "mode", RESYNC_MIRROR, "pull makes local match remote, push makes remote match local, mirror copies both ways keeping conflict copies.")
+	resyncFlag.BoolVar(&assumeYes, "yes", false, "Don't ask before overwriting or deleting files.")
+	resyncFlag.Int64Var(&bandwidthLimit, "bandwidth", 0, "Most KiB per second the resync may use; see run -bandwidth.")
+	resyncFlag.BoolVar(&endToEnd, "e2e", false, "Files on remote are encrypted; see run -e2e.")
+
+	archiveFlag := flag.NewFlagSet("archive", flag.ExitOnError)
+	archiveFlag.StringVar(&email, "email", "", "Name of the user connecting to remote")
+	archiveFlag.StringVar(&password, "password", "", "Password of the user connecting to remote")
+	archiveFlag.StringVar(&remote, "remote", "", "Remote GRPC FUSE server.")
+	archiveFlag.BoolVar(&useTLS, "tls", false, "Connect to remote over TLS, verifying its certificate against the system roots.")
+	archiveFlag.StringVar(&scope, "scope", SCOPE_SHARED, "Which remote directory -path is in; see run -scope.")
+	archiveFlag.StringVar(&archivePath, "path", "/", "Remote directory to download.")
+	archiveFlag.StringVar(&archiveOut, "out", ".", "Where to save the archive. A directory gets a file named after -path; with -extract, the directory to unpack into.")
+	archiveFlag.BoolVar(&archiveGzip, "gzip", false, "Compress the archive while it is sent.")
+	archiveFlag.BoolVar(&archiveExtract, "extract", false, "Unpack the archive into -out instead of saving it.")
+
+	doctorFlag := flag.NewFlagSet("doctor", flag.ExitOnError)
+	doctorFlag.StringVar(&remote, "remote", "", "Remote GRPC FUSE server.")
+	doctorFlag.BoolVar(&useTLS, "tls", false, "Connect to remote over TLS, verifying its certificate against the system roots.")
 
 	var help bool
 	flag.BoolVar(&help, "help", false, "Display help message")
@@ -61,6 +144,27 @@ func init() {
 		runFlag.PrintDefaults()
 		fmt.Printf("\r\n")
 
+		fmt.Printf("Usage of %v:\n", resyncFlag.Name())
+		resyncFlag.PrintDefaults()
+		fmt.Printf("\r\n")
+
+		fmt.Printf("Usage of %v:\n", archiveFlag.Name())
+		archiveFlag.PrintDefaults()
+		fmt.Printf("\r\n")
+
+		fmt.Printf("Usage of %v:\n", doctorFlag.Name())
+		doctorFlag.PrintDefaults()
+		fmt.Printf("\r\n")
+
+		fmt.Printf("Usage of status:\n  Prints the state of the running client\n")
+		fmt.Printf("\r\n")
+
+		fmt.Printf("Usage of cancel:\n  cancel <path>\n  Cancels the upload or download of path in the running client. Path is\n  relative to the mount or a full path under -mountpoint\n")
+		fmt.Printf("\r\n")
+
+		fmt.Printf("Usage of unmount:\n  Unmounts and stops the client started with run -daemon\n")
+		fmt.Printf("\r\n")
+
 		fmt.Printf("Common arguments:\n")
 		flag.PrintDefaults()
 	}
@@ -82,12 +186,57 @@ func init() {
 		parseFlag(authFlag)
 	case "run":
 		parseFlag(runFlag)
+	case "resync":
+		parseFlag(resyncFlag)
+	case "archive":
+		parseFlag(archiveFlag)
+	case "doctor":
+		parseFlag(doctorFlag)
+	case "status", "unmount":
+		// Talks to the running client; no flags needed
+	case "cancel":
+		if len(os.Args) < 3 || strings.TrimSpace(os.Args[2]) == "" {
+			flag.Usage()
+			log.Fatalln("Expected the path of the transfer to cancel")
+		}
+		cancelPath = os.Args[2]
 	default:
 		flag.Usage()
 		log.Fatalln("Invalid command")
 	}
 
-	grpcClient = new_gRPC_client()
+	if remoteDelete != "" && remoteDelete != REMOTE_DELETE_REMOVE && remoteDelete != REMOTE_DELETE_TRASH {
+		log.Fatalf("Invalid -remote-delete %q; expected %v or %v\n", remoteDelete, REMOTE_DELETE_TRASH, REMOTE_DELETE_REMOVE)
+	}
+	if syncDirection != "" && !validSyncDirection(syncDirection) {
+		log.Fatalf("Invalid -sync-direction %q; expected %v, %v or %v\n", syncDirection, SYNC_BIDIRECTIONAL, SYNC_PULL, SYNC_PUSH)
+	}
+	if scope != "" && scope != SCOPE_SHARED && scope != SCOPE_PERSONAL {
+		log.Fatalf("Invalid -scope %q; expected %v or %v\n", scope, SCOPE_SHARED, SCOPE_PERSONAL)
+	}
+
+	rpcMethodTimeouts, err = parseMethodTimeouts(rpcMethodTimeoutFlag)
+	if err != nil {
+		log.Fatalf("Invalid -rpc-method-timeouts; %v\n", err)
+	}
+	ownerMap, err = parseOwnerMap(ownerMapFlag)
+	if err != nil {
+		log.Fatalf("Invalid -owner-map; %v\n", err)
+	}
+
+	localStatfs = lib.NewStatfsCache(statfsTTL)
+
+	if syncWindowFlag != "" {
+		syncWindows, err = parseSyncWindows(syncWindowFlag)
+		if err != nil {
+			log.Fatalf("Invalid -sync-window; %v\n", err)
+		}
+	}
+
+	// status, cancel and unmount only talk to the running client
+	if command != "status" && command != "cancel" && command != "unmount" {
+		grpcClient = new_gRPC_client()
+	}
 }
 
 func parseFlag(flagSet *flag.FlagSet) {
@@ -103,15 +252,29 @@ func parseFlag(flagSet *flag.FlagSet) {
 func mountFileSystem(errorChan chan<- error) {
 	log.Printf("Mounting directory %v -> %v\n", realpath, mountpoint)
 
+	// Ensure mountpoint directory exists
+	created, err := lib.PrepareMountpoint(mountpoint, createMountpoint)
+	if err != nil {
+		log.Fatalf("Error preparing mount directory; %v\n", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	fileSystem, err := NewFileSystem(ctx, realpath)
 	if err != nil {
+		if created {
+			lib.RemoveMountpoint(mountpoint)
+		}
 		errorChan <- fmt.Errorf("error creating loopback Root directory; %v", err)
 		return
 	}
 
+	var mountOptions []string
+	if defaultPermissions {
+		mountOptions = append(mountOptions, "default_permissions")
+	}
+
 	fuseServer, err = fs.Mount(
 		mountpoint,
 		fileSystem,
@@ -119,20 +282,55 @@ func mountFileSystem(errorChan chan<- error) {
 			MountOptions: fuse.MountOptions{
 				AllowOther: true,
 				Debug:      debug,
+				Options:    mountOptions,
 			},
-			UID: uint32(os.Geteuid()),
-			GID: uint32(os.Getegid()),
+			UID:             uint32(os.Geteuid()),
+			GID:             uint32(os.Getegid()),
+			EntryTimeout:    &entryTimeout,
+			AttrTimeout:     &attrTimeout,
+			NegativeTimeout: &negativeTimeout,
 		},
 	)
 	if err != nil {
+		// Don't leave stale directories behind between restarts
+		if created {
+			lib.RemoveMountpoint(mountpoint)
+		}
 		errorChan <- fmt.Errorf("mount fail: %v", err)
 		return
 	}
 	fuseServer.Wait()
 
-	// If we reach here the filesystem has been unmounted by user
-	// exit program
-	log.Fatalln("Filesystem unmounted by user")
+	if unmounting.Load() {
+		// Unmounted by us on SIGINT or SIGTERM; the signal handler
+		// exits
+		return
+	}
+	if !autoRemount {
+		log.Fatalln("Filesystem unmounted by user")
+	}
+
+	// Something else unmounted us. Make sure the kernel has let go of
+	// an aborted mount before mounting again
+	fuseServer.Unmount()
+	errorChan <- fmt.Errorf("filesystem unmounted unexpectedly")
+}
+
+// Set once the client unmounts the filesystem itself. Any other
+// unmount is unexpected and, with -remount, mounted again
+var unmounting atomic.Bool
+
+// A mount that stayed up this long was working; failures before it
+// no longer count towards giving up
+const MOUNT_FAILS_RESET = 10 * time.Minute
+
+// Returns the number of mount failures in a row counting one more of
+// a mount started at mountedAt
+func mountFailed(fails int, mountedAt time.Time) int {
+	if time.Since(mountedAt) >= MOUNT_FAILS_RESET {
+		fails = 0
+	}
+	return fails + 1
 }
 
 func dirExists(path string) bool {
@@ -143,44 +341,89 @@ func dirExists(path string) bool {
 	return info.IsDir()
 }
 
+// Logs into remote and keeps the token for later requests
+func authenticate() error {
+	response, err := grpcClient.Auth(context.Background(), &proto.AuthRequest{
+		Email:    email,
+		Password: password,
+	})
+	if err != nil {
+		return err
+	}
+	authToken = response.Token
+	return fetchCapabilities()
+}
+
 func runFileSystem() {
+	if daemon {
+		if !isDaemon() {
+			daemonize()
+		}
+		setupDaemon()
+	}
+
 	// Ensure realpath directory exists
 	if !dirExists(realpath) {
 		log.Fatalln("-realpath directory does not exist")
 	}
 
-	// Ensure mountpoint directory exists
-	if !dirExists(mountpoint) {
-		log.Println("-mountpoint directory does not exist")
-		err := os.Mkdir(mountpoint, 0755)
-		if err != nil {
-			log.Fatalf("Error creating mount directory; %v\n", err)
-		}
+	err := lib.ValidateAddress(remote)
+	if err != nil {
+		log.Fatalf("Invalid -remote address; %v\n", err)
 	}
 
 	// Before we mount the FUSE file system first lets
-	// make sure we are authenticated with the remote server
-	response, err := grpcClient.Auth(context.Background(), &proto.AuthRequest{
-		Email:    email,
-		Password: password,
-	})
+	// make sure we are authenticated with the remote server.
+	// If remote can't be reached we still mount the local copy
+	err = preflight(remote)
+	if err == nil {
+		err = authenticate()
+		if err != nil && status.Code(err) != codes.Unavailable {
+			log.Fatalf("Error authenticating with remote; %v\n", err)
+		}
+	}
+
+	// The key is on remote; earlier runs keep a copy for offline starts
+	if endToEnd {
+		e2eErr := setupE2E(err == nil)
+		if e2eErr != nil {
+			log.Fatalf("Error setting up end-to-end encryption; %v\n", e2eErr)
+		}
+	}
+
 	if err != nil {
-		log.Fatalf("Error authenticating with remote; %v\n", err)
+		log.Printf("Error connecting to remote; %v\n", err)
+		startOffline()
+	} else if !drainQueue() {
+		// Changes left over from an earlier offline session could
+		// not be sent
+		startOffline()
+	} else {
+		// Downloads get their own pool of connections; the observer
+		// stream and other RPCs keep using grpcClient
+		setupDownloadClients()
+
+		if reconcileRemote {
+			go reconcile(context.Background())
+		}
+	}
+
+	go startControlServer()
+	go runSyncWindow(context.Background())
+	if remoteDelete == REMOTE_DELETE_TRASH {
+		go cleanupTrash(context.Background(), trashRetention)
 	}
-	authToken = response.Token
 
 	errorChan := make(chan error)
 	go mountFileSystem(errorChan)
 
-	const MAX_FAILS = 3
-	numberFails := 0
-
 	// Close servers when SIGINT and SIGTERM signals are received
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		<-sigChan
+		unmounting.Store(true)
 		if fuseServer != nil {
 			log.Println("Unmounting filesystem now")
 			err := fuseServer.Unmount()
@@ -188,29 +431,51 @@ func runFileSystem() {
 				log.Printf("Error unmounting filesystem; %v\n", err)
 			}
 		}
+		flushInodes()
+		flushListings()
+		removePidFile()
 
 		os.Exit(1)
 	}()
 
+	keepMounted(context.Background(), errorChan)
+	log.Fatalln("Mounting FUSE filesystem failed too many times")
+}
+
+// Number of mount failures in a row after which the client gives up
+const MAX_MOUNT_FAILS = 3
+
+// Mounts the filesystem again each time mountFileSystem reports on
+// errorChan that it failed or was unmounted. Returns once
+// MAX_MOUNT_FAILS mounts have failed in a row or ctx ends
+func keepMounted(ctx context.Context, errorChan chan error) {
+	mountedAt := time.Now()
+	numberFails := 0
+
 	for {
-		// Restart FUSE filesystem whenever it fails
+		var err error
 		select {
-		case err := <-errorChan:
-			log.Printf("Error mounting FUSE filesystem; %v\n", err)
-
-			numberFails += 1
-			if numberFails >= MAX_FAILS {
-				log.Fatalln("Mounting FUSE filesystem failed too many times")
-			}
-			go mountFileSystem(errorChan)
+		case <-ctx.Done():
+			return
+		case err = <-errorChan:
+		}
+		log.Printf("Error mounting FUSE filesystem; %v\n", err)
+		if unmounting.Load() {
+			continue
+		}
 
-		default:
-			time.Sleep(30 * time.Second)
+		numberFails = mountFailed(numberFails, mountedAt)
+		if numberFails >= MAX_MOUNT_FAILS {
+			return
 		}
+		go mountFileSystem(errorChan)
+		mountedAt = time.Now()
 	}
 }
 
 func main() {
+	parseArgs()
+
 	defer func() {
 		// recover() will return a non-nil value if a panic occurred.
 		if r := recover(); r != nil {
@@ -221,6 +486,14 @@ func main() {
 		}
 	}()
 
+	// run falls back to offline mode instead
+	if command == "auth" {
+		err := preflight(remote)
+		if err != nil {
+			log.Fatalf("Pre-flight check failed; %v\n", err)
+		}
+	}
+
 	switch command {
 	case "auth":
 		response, err := grpcClient.Auth(context.Background(), &proto.AuthRequest{
@@ -235,6 +508,24 @@ func main() {
 	case "run":
 		runFileSystem()
 
+	case "resync":
+		runResync()
+
+	case "archive":
+		runArchive()
+
+	case "doctor":
+		runDoctor()
+
+	case "status":
+		printStatus()
+
+	case "cancel":
+		runCancel(cancelPath)
+
+	case "unmount":
+		stopDaemon()
+
 	default:
 		//
 	}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMain(m *testing.M) {
+	rpcTimeout = 10 * time.Second
+	rpcAttempts = 3
+	rpcBackoff = time.Millisecond
+	m.Run()
+}
+
+// Serves srv over an in-memory connection and returns a client for it
+// that goes through the client's interceptors
+func newTestClient(t testing.TB, srv proto.FuseServer, opts ...grpc.ServerOption) proto.FuseClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(opts...)
+	proto.RegisterFuseServer(server, srv)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dialOpts := append(dialOptions(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufconn", dialOpts...)
+	if err != nil {
+		t.Fatalf("Error connecting to test server; %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewFuseClient(conn)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// A setgid directory keeps the bit locally, whatever the umask, and
+// asks remote for it too
+func TestMkdirKeepsSetgid(t *testing.T) {
+	useTestQueue(t)
+	oldUmask := syscall.Umask(027)
+	t.Cleanup(func() { syscall.Umask(oldUmask) })
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	status := raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 02770}, "shared", &fuse.EntryOut{})
+	if !status.Ok() {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	stat := syscall.Stat_t{}
+	err := syscall.Lstat(localPath("/shared"), &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stat.Mode & 07777; got != 02770 {
+		t.Errorf("local directory has mode %o; want 2770", got)
+	}
+
+	// Offline, so the remote Mkdir is queued
+	deadline := time.Now().Add(5 * time.Second)
+	for len(loadQueue()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ops := loadQueue()
+	if len(ops) != 1 || ops[0].Op != OP_MKDIR || ops[0].Mode&07777 != 02770 {
+		t.Errorf("queued %v; want a Mkdir of /shared with mode 2770", ops)
+	}
+}
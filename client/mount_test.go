@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Only failures in a row count; one after a mount that stayed up
+// starts the count again
+func TestMountFailedResets(t *testing.T) {
+	fails := mountFailed(0, time.Now())
+	fails = mountFailed(fails, time.Now())
+	if fails != 2 {
+		t.Errorf("fails = %v after two quick failures; want 2", fails)
+	}
+	fails = mountFailed(fails, time.Now().Add(-MOUNT_FAILS_RESET))
+	if fails != 1 {
+		t.Errorf("fails = %v after a mount that stayed up; want 1", fails)
+	}
+}
+
+// A mountpoint created for a mount that then fails is removed again
+func TestFailedMountRemovesMountpoint(t *testing.T) {
+	oldMountpoint, oldRealpath, oldCreate := mountpoint, realpath, createMountpoint
+	mountpoint = filepath.Join(t.TempDir(), "mnt")
+	realpath = filepath.Join(t.TempDir(), "missing")
+	createMountpoint = true
+	t.Cleanup(func() {
+		mountpoint, realpath, createMountpoint = oldMountpoint, oldRealpath, oldCreate
+	})
+
+	errorChan := make(chan error, 1)
+	mountFileSystem(errorChan)
+	select {
+	case err := <-errorChan:
+		t.Logf("mount failed; %v", err)
+	default:
+		t.Fatal("mount without -realpath succeeded")
+	}
+	if _, err := os.Stat(mountpoint); !os.IsNotExist(err) {
+		t.Errorf("mountpoint left behind; %v", err)
+	}
+}
+
+// Waits until name in realpath shows through the mount
+func waitMounted(t *testing.T, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(filepath.Join(mountpoint, name)); err == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%v not visible through the mount", name)
+}
+
+// Mounts realpath at a temporary mountpoint, skipping the test where
+// FUSE can't be used. Returns the fusermount to unmount with and the
+// channel mountFileSystem reports on
+func useTestMount(t *testing.T) (string, chan error) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("mounting is only tested on Linux")
+	}
+	fusermount, err := exec.LookPath("fusermount3")
+	if err != nil {
+		fusermount, err = exec.LookPath("fusermount")
+	}
+	if err != nil {
+		t.Skip("fusermount not installed")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("FUSE not available; ", err)
+	}
+
+	useTestQueue(t)
+	oldMountpoint, oldRemount := mountpoint, autoRemount
+	mountpoint, autoRemount = t.TempDir(), true
+	t.Cleanup(func() {
+		unmounting.Store(true)
+		if fuseServer != nil {
+			fuseServer.Unmount()
+		}
+		unmounting.Store(false)
+		mountpoint, autoRemount = oldMountpoint, oldRemount
+	})
+	err = os.WriteFile(filepath.Join(realpath, "file"), []byte("file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorChan := make(chan error, 1)
+	go mountFileSystem(errorChan)
+	select {
+	case err := <-errorChan:
+		t.Skip("can't mount here; ", err)
+	case <-time.After(time.Second):
+	}
+	waitMounted(t, "file")
+	return fusermount, errorChan
+}
+
+// Unmounting the filesystem from outside, as fusermount -u does, has
+// the client mount it again by itself
+func TestRemountAfterExternalUnmount(t *testing.T) {
+	fusermount, errorChan := useTestMount(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go keepMounted(ctx, errorChan)
+
+	out, err := exec.Command(fusermount, "-u", mountpoint).CombinedOutput()
+	if err != nil {
+		t.Fatalf("fusermount -u failed; %v: %s", err, out)
+	}
+	// The mountpoint is an empty directory until the remount
+	waitMounted(t, "file")
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// While remote is unreachable the mount keeps working on the local
+// copy. Changes made in the meantime are written to a queue on disk
+// and replayed against remote once we reconnect
+
+// How often we try to reach remote while offline
+const RECONNECT_INTERVAL = 15 * time.Second
+
+// Calls in a row that may run past -sync-timeout before remote is
+// treated as gone. A single slow call, eg. a big upload over a slow
+// link, says little about remote
+const SYNC_TIMEOUTS_OFFLINE = 3
+
+// Operations recorded in the offline queue
+const (
+	OP_MKDIR    = "mkdir"
+	OP_RMDIR    = "rmdir"
+	OP_UNLINK   = "unlink"
+	OP_RENAME   = "rename"
+	OP_CREATE   = "create"
+	OP_UPLOAD   = "upload" // send the whole local file
+	OP_PUT      = "put"    // create or replace a small file whole
+	OP_TRUNCATE = "truncate"
+	OP_SYMLINK  = "symlink"
+	OP_SETATTR  = "setattr"
+)
+
+type queuedOp struct {
+	Op      string       `json:"op"`
+	Path    string       `json:"path"`
+	NewPath string       `json:"new_path,omitempty"`
+	Mode    uint32       `json:"mode,omitempty"`
+	Flags   uint32       `json:"flags,omitempty"`
+	Size    int64        `json:"size,omitempty"`
+	Target  string       `json:"target,omitempty"`
+	Attrs   *queuedAttrs `json:"attrs,omitempty"`
+
+	// Sent with every attempt so remote applies the op only once
+	Key string `json:"key"`
+}
+
+// Attributes changed by an OP_SETATTR; nil ones were left alone
+type queuedAttrs struct {
+	Mode  *uint32    `json:"mode,omitempty"`
+	Size  *uint64    `json:"size,omitempty"`
+	ATime *time.Time `json:"atime,omitempty"`
+	MTime *time.Time `json:"mtime,omitempty"`
+	Flags *uint32    `json:"flags,omitempty"`
+}
+
+func (a *queuedAttrs) request(path string) *proto.SetattrRequest {
+	req := &proto.SetattrRequest{
+		Path:  path,
+		Mode:  a.Mode,
+		Size:  a.Size,
+		Flags: a.Flags,
+	}
+	if a.ATime != nil {
+		req.ATime = timestamppb.New(*a.ATime)
+	}
+	if a.MTime != nil {
+		req.MTime = timestamppb.New(*a.MTime)
+	}
+	return req
+}
+
+var (
+	online atomic.Bool
+
+	// Calls that timed out since remote last answered
+	syncTimeouts atomic.Int32
+
+	queueMu  = sync.Mutex{}
+	queueLen int
+
+	// Paths whose last queued op is an upload. Uploads send the file
+	// as it is when replayed, so another one adds nothing
+	queuedUploads = map[string]bool{}
+
+	// Held while the queue is replayed so replays don't overlap
+	drainMu = sync.Mutex{}
+)
+
+func init() {
+	registerStatus("mode", func() any {
+		if online.Load() {
+			return "online"
+		}
+		return "offline"
+	})
+	registerStatus("queued_operations", func() any {
+		queueMu.Lock()
+		defer queueMu.Unlock()
+		return queueLen
+	})
+	registerStatus("live_inodes", func() any {
+		return lib.LiveInodes.Load()
+	})
+}
+
+func queuePath() string {
+	digest := md5.Sum([]byte(realpath))
+	name := hex.EncodeToString(digest[:]) + ".jsonl"
+	return filepath.Join(lib.ProjectDir, "queue", name)
+}
+
+// Appends op to the offline queue
+func enqueue(op queuedOp) {
+	if opIgnored(op) {
+		return
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	// Writes to a file only need one upload until something else
+	// happens to it
+	if op.Op == OP_UPLOAD && queuedUploads[op.Path] {
+		return
+	}
+
+	path := queuePath()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		log.Printf("[SYNC] Error queueing %v %v; %v\n", op.Op, op.Path, err)
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[SYNC] Error queueing %v %v; %v\n", op.Op, op.Path, err)
+		return
+	}
+	defer file.Close()
+
+	data, _ := json.Marshal(op)
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		log.Printf("[SYNC] Error queueing %v %v; %v\n", op.Op, op.Path, err)
+		return
+	}
+
+	queueLen++
+	trackUpload(op)
+	addQueuedPaths(op)
+
+	if online.Load() {
+		// Went to the queue just as it was drained; send it on
+		go func() {
+			if !drainQueue() {
+				goOffline()
+			}
+		}()
+	}
+}
+
+// Updates queuedUploads for op joining the queue. Called with queueMu
+// held
+func trackUpload(op queuedOp) {
+	switch op.Op {
+	case OP_UPLOAD:
+		queuedUploads[op.Path] = true
+	case OP_RENAME, OP_RMDIR:
+		// Moves or removes everything below the path too
+		clear(queuedUploads)
+	default:
+		delete(queuedUploads, op.Path)
+	}
+}
+
+// Replaces the queue bookkeeping with that of ops, the new contents of
+// the offline queue. Called with queueMu held
+func resetQueued(ops []queuedOp) {
+	queueLen = len(ops)
+	clear(queuedUploads)
+	for _, op := range ops {
+		trackUpload(op)
+	}
+	setQueuedPaths(ops)
+}
+
+// Returns the operations waiting in the offline queue
+func loadQueue() []queuedOp {
+	file, err := os.Open(queuePath())
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	ops := []queuedOp{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var op queuedOp
+		err := json.Unmarshal(scanner.Bytes(), &op)
+		if err != nil {
+			// Torn last line from a crash mid append
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// Sends a change to remote, or queues it if remote can't be reached.
+// send gets an authenticated context tagged with the op's
+// idempotency key
+func sendOrQueue(op queuedOp, send func(ctx context.Context) error) error {
+	if opIgnored(op) {
+		return nil
+	}
+	flushDeferredCreates(op.Path, op.NewPath)
+	if op.Key == "" {
+		op.Key = newIdempotencyKey()
+	}
+	if !online.Load() {
+		enqueue(op)
+		return nil
+	}
+
+	for {
+		ctx, cancel := newSyncCtx()
+		done := beginSend(op)
+		err := send(withIdempotencyKey(ctx, op.Key))
+		done()
+		cancel()
+
+		switch syncErrCode(err) {
+		case codes.Unavailable:
+			if lib.IsMaintenance(err) {
+				log.Println("[SYNC] Remote is in maintenance; queueing changes until it is over")
+			} else {
+				log.Printf("[SYNC] Lost connection to remote; %v\n", err)
+			}
+		case codes.DeadlineExceeded:
+			// Remote may or may not have applied it; the idempotency
+			// key makes sending it again safe either way
+			if retryTimedOut() {
+				log.Printf("[SYNC] Timed out waiting for remote; retrying %v %v; %v\n", op.Op, op.Path, err)
+				continue
+			}
+			log.Printf("[SYNC] Timed out waiting for remote %v times in a row; %v\n", SYNC_TIMEOUTS_OFFLINE, err)
+		default:
+			syncTimeouts.Store(0)
+			return err
+		}
+		goOffline()
+		enqueue(op)
+		return nil
+	}
+}
+
+// Returns the gRPC code of err, the result of a call to remote. Calls
+// cut short by their sync context count as DeadlineExceeded whether or
+// not gRPC reported them that way
+func syncErrCode(err error) codes.Code {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+	return status.Code(err)
+}
+
+// Counts a call that ran past -sync-timeout. Reports whether to send
+// it again rather than treat remote as gone
+func retryTimedOut() bool {
+	return syncTimeouts.Add(1) < SYNC_TIMEOUTS_OFFLINE
+}
+
+// Switches to offline mode and starts trying to reconnect
+func goOffline() {
+	if !online.CompareAndSwap(true, false) {
+		return
+	}
+	syncTimeouts.Store(0)
+	log.Println("[SYNC] Remote unreachable; running in offline mode")
+	go reconnectLoop()
+}
+
+// Starts the client offline. Called when remote can't be reached at
+// startup
+func startOffline() {
+	log.Println("[SYNC] Remote unreachable; running in offline mode")
+	online.Store(false)
+
+	queueMu.Lock()
+	resetQueued(loadQueue())
+	queueMu.Unlock()
+
+	go reconnectLoop()
+}
+
+func reconnectLoop() {
+	for {
+		time.Sleep(RECONNECT_INTERVAL)
+
+		err := preflight(remote)
+		if err != nil {
+			continue
+		}
+		err = authenticate()
+		if err != nil {
+			log.Printf("[SYNC] Error reconnecting to remote; %v\n", err)
+			continue
+		}
+		syncTimeouts.Store(0)
+
+		if !drainQueue() {
+			continue
+		}
+		log.Println("[SYNC] Reconnected to remote; back online")
+
+		// Started offline; the download pool was never set up
+		setupDownloadClients()
+
+		ctx := context.Background()
+		go startRemoteObserver(ctx)
+
+		err = fetchRemoteEntries(ctx, "")
+		if err != nil {
+			log.Printf("[SYNC] Error fetching remote entries; %v\n", err)
+		}
+		if reconcileRemote {
+			// Delete events sent while we were away were missed
+			go reconcile(ctx)
+		}
+		return
+	}
+}
+
+// Replays the offline queue against remote and goes online once it is
+// empty. Ops queued while it runs are replayed after it. Returns false
+// if remote went away again before the queue was empty
+func drainQueue() bool {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+
+	for {
+		queueMu.Lock()
+		ops := loadQueue()
+		if len(ops) == 0 {
+			// Online before the lock is let go, so changes from here
+			// on are sent rather than queued behind us
+			online.Store(true)
+			os.Remove(queuePath())
+			resetQueued(nil)
+			queueMu.Unlock()
+			return true
+		}
+		queueMu.Unlock()
+
+		log.Printf("[SYNC] Replaying %v queued operations\n", len(ops))
+		for i := 0; i < len(ops); i++ {
+			op := ops[i]
+			err := replay(op)
+			code := syncErrCode(err)
+			if code == codes.DeadlineExceeded && retryTimedOut() {
+				log.Printf("[SYNC] Timed out replaying %v %v; retrying; %v\n", op.Op, op.Path, err)
+				i--
+				continue
+			}
+			if code == codes.Unavailable || code == codes.DeadlineExceeded {
+				// Keep what's left, this op included, for the next
+				// attempt
+				dropReplayed(i)
+				return false
+			}
+			syncTimeouts.Store(0)
+			if err != nil {
+				log.Printf("[SYNC] Error replaying %v %v; %v\n", op.Op, op.Path, err)
+			}
+		}
+		dropReplayed(len(ops))
+	}
+}
+
+// Removes the first n ops, which were replayed, from the offline queue
+// while keeping those queued in the meantime
+func dropReplayed(n int) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	rewriteQueue(loadQueue()[n:])
+}
+
+func rewriteQueue(ops []queuedOp) {
+	path := queuePath()
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("[SYNC] Error saving offline queue; %v\n", err)
+		return
+	}
+	for _, op := range ops {
+		data, _ := json.Marshal(op)
+		file.Write(append(data, '\n'))
+	}
+	file.Sync()
+	file.Close()
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		log.Printf("[SYNC] Error saving offline queue; %v\n", err)
+		return
+	}
+	resetQueued(ops)
+}
+
+func replay(op queuedOp) error {
+	ctx, cancel := newSyncCtx()
+	defer cancel()
+	if op.Key != "" {
+		ctx = withIdempotencyKey(ctx, op.Key)
+	}
+
+	switch op.Op {
+	case OP_MKDIR:
+		_, err := grpcClient.Mkdir(ctx, &proto.MkdirRequest{
+			Path: op.Path,
+			Mode: op.Mode,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			return nil
+		}
+		return err
+
+	case OP_RMDIR:
+		_, err := grpcClient.Rmdir(ctx, &proto.DirEntry{
+			Path: op.Path,
+		})
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+
+	case OP_UNLINK:
+		_, err := grpcClient.Unlink(ctx, &proto.DirEntry{
+			Path: op.Path,
+		})
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+
+	case OP_CREATE:
+		_, err := grpcClient.Create(ctx, &proto.CreateRequest{
+			Path:  op.Path,
+			Flags: op.Flags,
+			Mode:  op.Mode,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			return nil
+		}
+		return err
+
+	case OP_RENAME:
+		_, err := grpcClient.Rename(ctx, &proto.RenameRequest{
+			OldPath: op.Path,
+			NewPath: op.NewPath,
+		})
+		if status.Code(err) == codes.NotFound {
+			// Created while offline too; remote never had the old
+			// name. Send the file under its new one
+			return uploadLocal(ctx, op.NewPath)
+		}
+		return err
+
+	case OP_SYMLINK:
+		_, err := grpcClient.Symlink(ctx, &proto.LinkRequest{
+			OldPath: op.Target,
+			NewPath: op.Path,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			return nil
+		}
+		return err
+
+	case OP_UPLOAD:
+		return uploadLocal(ctx, op.Path)
+
+	case OP_PUT:
+		return putLocal(ctx, op.Path)
+
+	case OP_SETATTR:
+		if op.Attrs == nil {
+			return nil
+		}
+		_, err := grpcClient.Setattr(ctx, op.Attrs.request(op.Path))
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+
+	case OP_TRUNCATE:
+		size := uint64(op.Size)
+		_, err := grpcClient.Setattr(ctx, &proto.SetattrRequest{
+			Path: op.Path,
+			Size: &size,
+		})
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+
+	default:
+		log.Printf("[SYNC] Dropping unknown queued operation %v\n", op.Op)
+		return nil
+	}
+}
+
+// Replaces remote file path with the local copy. Files that are gone
+// locally were removed or renamed later in the queue and are skipped
+func uploadLocal(ctx context.Context, path string) error {
+	fullpath := localPath(path)
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return uploadFile(ctx, fullpath, int(file.Fd()), info.Size())
+}
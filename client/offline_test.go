@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Keeps the offline queue in a temporary directory and starts offline
+// with it empty
+func useTestQueue(t testing.TB) {
+	oldDir, oldRealpath := lib.ProjectDir, realpath
+	lib.ProjectDir, realpath = t.TempDir(), t.TempDir()
+	online.Store(false)
+	syncTimeouts.Store(0)
+	queueMu.Lock()
+	resetQueued(nil)
+	queueMu.Unlock()
+	t.Cleanup(func() {
+		lib.ProjectDir, realpath = oldDir, oldRealpath
+		online.Store(false)
+	})
+}
+
+// Records the paths of Unlink calls. The first call runs during, if
+// set, as if the user changed something while the queue is replayed
+type unlinkServer struct {
+	proto.UnimplementedFuseServer
+	during func()
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func (s *unlinkServer) Unlink(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	during := s.during
+	s.during = nil
+	s.paths = append(s.paths, req.Path)
+	s.mu.Unlock()
+
+	if during != nil {
+		during()
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func TestEnqueueDedupsUploads(t *testing.T) {
+	useTestQueue(t)
+
+	enqueue(queuedOp{Op: OP_UPLOAD, Path: "/a"})
+	enqueue(queuedOp{Op: OP_UPLOAD, Path: "/b"})
+	enqueue(queuedOp{Op: OP_UPLOAD, Path: "/a"})
+	if n := len(loadQueue()); n != 2 {
+		t.Fatalf("queued %v ops; want the second upload of /a dropped", n)
+	}
+
+	// A truncate after the upload would cut off what a dropped upload
+	// sent
+	enqueue(queuedOp{Op: OP_TRUNCATE, Path: "/a"})
+	enqueue(queuedOp{Op: OP_UPLOAD, Path: "/a"})
+	if n := len(loadQueue()); n != 4 {
+		t.Errorf("queued %v ops; want the upload after the truncate kept", n)
+	}
+}
+
+func TestDrainQueueReplaysOpsQueuedMeanwhile(t *testing.T) {
+	useTestQueue(t)
+	srv := &unlinkServer{}
+	srv.during = func() {
+		// Waits on queueMu if the drain holds it while sending
+		enqueue(queuedOp{Op: OP_UNLINK, Path: "/late"})
+	}
+	useTestRemote(t, srv)
+
+	enqueue(queuedOp{Op: OP_UNLINK, Path: "/early"})
+	if !drainQueue() {
+		t.Fatal("drainQueue failed")
+	}
+
+	want := []string{"/early", "/late"}
+	if !slices.Equal(srv.paths, want) {
+		t.Errorf("remote unlinked %v; want %v", srv.paths, want)
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("left %v in the queue", ops)
+	}
+	if !online.Load() {
+		t.Error("still offline after draining the queue")
+	}
+}
+
+func timedOut() error {
+	return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+}
+
+// A single slow call is sent again instead of taking the client
+// offline
+func TestSendOrQueueRetriesTimeout(t *testing.T) {
+	useTestQueue(t)
+	srv := &flakyServer{errs: []error{timedOut()}}
+	useTestRemote(t, srv)
+	online.Store(true)
+
+	op := queuedOp{Op: OP_UNLINK, Path: "/file"}
+	err := sendOrQueue(op, func(ctx context.Context) error {
+		_, err := grpcClient.Unlink(ctx, &proto.DirEntry{Path: op.Path})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("sendOrQueue = %v", err)
+	}
+	if !online.Load() {
+		t.Error("went offline after one timeout")
+	}
+	if calls := srv.calls.Load(); calls != 2 {
+		t.Errorf("server got %v calls; want 2", calls)
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("queued %v", ops)
+	}
+}
+
+// Ops that keep timing out while the queue is replayed stay queued
+func TestDrainQueueKeepsTimedOutOps(t *testing.T) {
+	useTestQueue(t)
+	errs := make([]error, SYNC_TIMEOUTS_OFFLINE)
+	for i := range errs {
+		errs[i] = timedOut()
+	}
+	srv := &flakyServer{errs: errs}
+	useTestRemote(t, srv)
+
+	enqueue(queuedOp{Op: OP_UNLINK, Path: "/file"})
+	if drainQueue() {
+		t.Fatal("drainQueue succeeded with remote timing out")
+	}
+	if ops := loadQueue(); len(ops) != 1 || ops[0].Path != "/file" {
+		t.Fatalf("queue has %v; want the timed out unlink kept", ops)
+	}
+
+	if !drainQueue() {
+		t.Fatal("drainQueue failed once remote answered")
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("left %v in the queue", ops)
+	}
+}
+
+// Changes remote refuses during maintenance are queued and sent once
+// it is over
+func TestMaintenanceQueuesChanges(t *testing.T) {
+	useTestQueue(t)
+	srv := &flakyServer{errs: []error{lib.MaintenanceError()}}
+	useTestRemote(t, srv)
+	online.Store(true)
+
+	op := queuedOp{Op: OP_UNLINK, Path: "/file"}
+	err := sendOrQueue(op, func(ctx context.Context) error {
+		_, err := grpcClient.Unlink(ctx, &proto.DirEntry{Path: op.Path})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("sendOrQueue during maintenance = %v; want the change queued", err)
+	}
+	if online.Load() {
+		t.Error("still online with remote in maintenance")
+	}
+	if ops := loadQueue(); len(ops) != 1 || ops[0].Path != "/file" {
+		t.Fatalf("queue has %v; want the refused unlink", ops)
+	}
+
+	if !drainQueue() {
+		t.Fatal("drainQueue failed once maintenance was over")
+	}
+	if calls := srv.calls.Load(); calls != 2 {
+		t.Errorf("server got %v calls; want 2", calls)
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("left %v in the queue", ops)
+	}
+}
+
+// Takes a while over each Mkdir and counts the ones whose context was
+// cancelled or had no deadline by the time it answered
+type slowMkdirServer struct {
+	proto.UnimplementedFuseServer
+	calls, cancelled, unbounded atomic.Int32
+}
+
+func (s *slowMkdirServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.DirEntry, error) {
+	time.Sleep(50 * time.Millisecond)
+	if ctx.Err() != nil {
+		s.cancelled.Add(1)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		s.unbounded.Add(1)
+	}
+	s.calls.Add(1)
+	return &proto.DirEntry{Path: req.Path}, nil
+}
+
+// Background syncs of concurrent mutations each run on a context of
+// their own, bounded by -sync-timeout, that outlives the request that
+// started them. Run with -race
+func TestBackgroundSyncsOutliveRequest(t *testing.T) {
+	useTestInodes(t)
+	useTestQueue(t)
+	srv := &slowMkdirServer{}
+	useTestRemote(t, srv)
+	online.Store(true)
+	oldTimeout := syncTimeout
+	syncTimeout = time.Minute
+	t.Cleanup(func() { syncTimeout = oldTimeout })
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	const DIRS = 20
+	wg := sync.WaitGroup{}
+	for i := range DIRS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Closed once the request returns, as the kernel's would be
+			cancel := make(chan struct{})
+			defer close(cancel)
+			status := raw.Mkdir(cancel, &fuse.MkdirIn{InHeader: header, Mode: 0755}, "dir"+strconv.Itoa(i), &fuse.EntryOut{})
+			if !status.Ok() {
+				t.Errorf("Mkdir = %v", status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.calls.Load() < DIRS && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := srv.calls.Load(); calls != DIRS {
+		t.Fatalf("remote got %v of %v Mkdirs", calls, DIRS)
+	}
+	if n := srv.cancelled.Load(); n != 0 {
+		t.Errorf("%v Mkdirs cancelled along with their request", n)
+	}
+	if n := srv.unbounded.Load(); n != 0 {
+		t.Errorf("%v Mkdirs sent without a deadline", n)
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("queued %v", ops)
+	}
+}
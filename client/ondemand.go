@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+var (
+	// Remote files too large to download eagerly. They are fetched
+	// the first time they are opened. Maps relative path to size
+	onDemand   = make(map[string]uint64)
+	onDemandMu = sync.Mutex{}
+)
+
+func init() {
+	registerStatus("pending_downloads", func() any {
+		onDemandMu.Lock()
+		defer onDemandMu.Unlock()
+
+		pending := make(map[string]uint64, len(onDemand))
+		for path, size := range onDemand {
+			pending[path] = size
+		}
+		return pending
+	})
+}
+
+func isLargeFile(size uint64) bool {
+	return largeFileThreshold > 0 && size > uint64(largeFileThreshold)*1024*1024
+}
+
+// Defers downloading remote file path until it is opened. An empty
+// placeholder is created so the file shows up in the mount
+func deferDownload(path string, size uint64, mode uint32) {
+	log.Printf("[SYNC] Deferring download of large file \"%v\" (%v bytes)\n", path, size)
+
+	fullpath := localPath(path)
+	file, err := os.OpenFile(fullpath, os.O_CREATE|os.O_RDWR, os.FileMode(mode).Perm())
+	if err != nil {
+		log.Printf("[SYNC] Error creating placeholder file; %v\n", err)
+	} else {
+		file.Close()
+	}
+
+	onDemandMu.Lock()
+	onDemand[path] = size
+	onDemandMu.Unlock()
+}
+
+// Downloads path if its download was deferred
+func fetchOnDemand(path string) error {
+	onDemandMu.Lock()
+	_, ok := onDemand[path]
+	onDemandMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	log.Printf("[SYNC] Downloading \"%v\" on demand\n", path)
+	err := downloadFile(&proto.DirEntry{Path: path})
+	if err != nil {
+		return err
+	}
+
+	onDemandMu.Lock()
+	delete(onDemand, path)
+	onDemandMu.Unlock()
+	return nil
+}
+
+// Downloads a modified remote file unless it is too large or the
+// sync window is closed, in which case its download is deferred until
+// it is opened
+func downloadModified(remote *proto.DirEntry) error {
+	if largeFileThreshold > 0 || len(syncWindows) > 0 {
+		ctx, cancel := newSyncCtx()
+		defer cancel()
+		attr, err := grpcClient.Getattr(ctx, remote)
+		if err == nil && isLargeFile(attr.Size) {
+			deferDownload(remote.Path, attr.Size, remote.Mode)
+			return nil
+		}
+		if err == nil && deferOutsideWindow(remote.Path, attr.Size, remote.Mode) {
+			return nil
+		}
+	}
+	return downloadFile(remote)
+}
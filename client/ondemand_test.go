@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc"
+)
+
+// Lists one file of the given size and counts its downloads. Sends
+// contents whatever the size listed
+type largeFileServer struct {
+	proto.UnimplementedFuseServer
+	size      uint64
+	contents  []byte
+	downloads atomic.Int32
+}
+
+func (s *largeFileServer) ReadDirAll(ctx context.Context, req *proto.DirEntry) (*proto.ReadDirAllResponse, error) {
+	return &proto.ReadDirAllResponse{
+		Entries: []*proto.DirEntry{{
+			Path: "/large",
+			Mode: 0644,
+			Attr: &proto.FileAttr{Size: s.size},
+		}},
+	}, nil
+}
+
+func (s *largeFileServer) DownloadFile(req *proto.DownloadRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
+	s.downloads.Add(1)
+	return stream.Send(&proto.FileChunk{Data: s.contents, TotalSize: int64(len(s.contents))})
+}
+
+// A file over -large-file-threshold is listed without being
+// downloaded and is fetched the first time it is opened
+func TestLargeFileDownloadedOnOpen(t *testing.T) {
+	useTestQueue(t)
+	srv := &largeFileServer{size: 2 << 30, contents: []byte("contents")}
+	useTestRemote(t, srv)
+	oldThreshold := largeFileThreshold
+	largeFileThreshold = 1024
+	t.Cleanup(func() {
+		largeFileThreshold = oldThreshold
+		onDemandMu.Lock()
+		delete(onDemand, "/large")
+		onDemandMu.Unlock()
+	})
+
+	err := fetchRemoteEntries(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.downloads.Load(); n != 0 {
+		t.Fatalf("2GiB file downloaded %v times while listing; want 0", n)
+	}
+	onDemandMu.Lock()
+	size, pending := onDemand["/large"]
+	onDemandMu.Unlock()
+	if !pending || size != srv.size {
+		t.Errorf("pending downloads hold %v, %v; want %v", size, pending, srv.size)
+	}
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	entry := fuse.EntryOut{}
+	status := raw.Lookup(nil, &header, "large", &entry)
+	if !status.Ok() {
+		t.Fatalf("Lookup = %v", status)
+	}
+	header.NodeId = entry.NodeId
+	opened := fuse.OpenOut{}
+	status = raw.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_RDONLY)}, &opened)
+	if !status.Ok() {
+		t.Fatalf("Open = %v", status)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: opened.Fh})
+
+	if n := srv.downloads.Load(); n != 1 {
+		t.Errorf("downloaded %v times on open; want 1", n)
+	}
+	if got, _ := os.ReadFile(localPath("/large")); string(got) != "contents" {
+		t.Errorf("local copy = %q; want %q", got, "contents")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var (
+	ownerMapFlag string
+
+	// Email -> local account name; see -owner-map
+	ownerMap = map[string]string{}
+
+	// Local uid/gid of owners we have already looked up
+	ownerCache   = make(map[string][2]uint32)
+	ownerCacheMu = sync.Mutex{}
+)
+
+// Parses -owner-map, a comma separated list of email=account pairs,
+// eg. bob@example.com=bob
+func parseOwnerMap(value string) (map[string]string, error) {
+	owners := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		owner, account, ok := strings.Cut(pair, "=")
+		if !ok || owner == "" || account == "" {
+			return nil, fmt.Errorf("expected email=account, got %q", pair)
+		}
+		owners[owner] = account
+	}
+	return owners, nil
+}
+
+// Maps the logical owner of a file (an email) to a uid/gid on this
+// machine. Owners listed in -owner-map map to their local account;
+// anyone else, us included, maps to the mounting user. Emails are
+// never matched to accounts by name, which would hand eg. root@ the
+// files of uid 0
+func localOwner(owner string) (uint32, uint32) {
+	account, ok := ownerMap[owner]
+	if !ok || owner == email {
+		return uint32(os.Geteuid()), uint32(os.Getegid())
+	}
+
+	ownerCacheMu.Lock()
+	defer ownerCacheMu.Unlock()
+
+	ids, ok := ownerCache[owner]
+	if ok {
+		return ids[0], ids[1]
+	}
+
+	ids = [2]uint32{uint32(os.Geteuid()), uint32(os.Getegid())}
+	u, err := user.Lookup(account)
+	if err == nil {
+		uid, uidErr := strconv.ParseUint(u.Uid, 10, 32)
+		gid, gidErr := strconv.ParseUint(u.Gid, 10, 32)
+		if uidErr == nil && gidErr == nil {
+			ids = [2]uint32{uint32(uid), uint32(gid)}
+		}
+	} else {
+		log.Printf("[WARN] -owner-map account %v of %v not found; %v\n", account, owner, err)
+	}
+
+	ownerCache[owner] = ids
+	return ids[0], ids[1]
+}
+
+// Shows the logical owner of path in attr instead of whoever wrote
+// the file to disk. With -default-permissions the kernel checks
+// access against these, so every reply carrying attributes must
+// go through here
+func showOwner(path string, attr *fuse.Attr) {
+	owner := lib.GetOwner(path)
+	if owner != "" {
+		attr.Uid, attr.Gid = localOwner(owner)
+	}
+}
+
+// Refuses a chown that would change the owner path shows. Remote
+// records owners as emails, not uids, so a new owner could only ever
+// change the local copy. One naming the owner it already has is fine
+func checkChown(path string, in *fuse.SetAttrIn) syscall.Errno {
+	uid, uidOK := in.GetUID()
+	gid, gidOK := in.GetGID()
+	if !uidOK && !gidOK {
+		return fs.OK
+	}
+	st := syscall.Stat_t{}
+	err := syscall.Lstat(path, &st)
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	attr := fuse.Attr{}
+	attr.FromStat(&st)
+	showOwner(path, &attr)
+	if (uidOK && uid != attr.Uid) || (gidOK && gid != attr.Gid) {
+		log.Printf("[FUSE] Chown %v refused; owners aren't synced\n", path)
+		return syscall.EPERM
+	}
+	return fs.OK
+}
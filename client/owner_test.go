@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestParseOwnerMap(t *testing.T) {
+	owners, err := parseOwnerMap("bob@example.com=bob, carol@example.com=carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owners["bob@example.com"] != "bob" || owners["carol@example.com"] != "carol" {
+		t.Errorf("owners = %v", owners)
+	}
+	for _, value := range []string{"bob@example.com", "=bob", "bob@example.com="} {
+		_, err := parseOwnerMap(value)
+		if err == nil {
+			t.Errorf("parseOwnerMap(%q) succeeded", value)
+		}
+	}
+}
+
+// Owners only map to an account -owner-map gives them
+func TestLocalOwnerNeedsMapping(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	old := ownerMap
+	ownerMap = map[string]string{"mapped@example.com": current.Username}
+	t.Cleanup(func() {
+		ownerMap = old
+		ownerCacheMu.Lock()
+		clear(ownerCache)
+		ownerCacheMu.Unlock()
+	})
+
+	uid, _ := localOwner("root@example.com")
+	if uid != uint32(os.Geteuid()) {
+		t.Errorf("root@example.com maps to uid %v; want the mounting user's %v", uid, os.Geteuid())
+	}
+	uid, _ = localOwner("mapped@example.com")
+	if strconv.Itoa(int(uid)) != current.Uid {
+		t.Errorf("mapped@example.com maps to uid %v; want %v", uid, current.Uid)
+	}
+}
+
+// Files created through the mount report their mapped owner straight
+// away; with -default-permissions the kernel checks access against it.
+// A setgid parent gives them a group on disk that isn't ours
+func TestCreateRepliesShowOwner(t *testing.T) {
+	useTestQueue(t)
+	oldEmail := email
+	email = "alice@example.com"
+	t.Cleanup(func() { email = oldEmail })
+	if lib.SetOwner(realpath, email) != nil {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+	otherGid := os.Getegid() + 1
+	err := os.Chown(realpath, -1, otherGid)
+	if err == nil {
+		err = os.Chmod(realpath, 0755|os.ModeSetgid)
+	}
+	if err != nil {
+		t.Skip("can't hand the directory to another group; ", err)
+	}
+	wantGid := uint32(os.Getegid())
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+
+	entry := fuse.EntryOut{}
+	status := raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 0755}, "dir", &entry)
+	if !status.Ok() {
+		t.Fatalf("Mkdir = %v", status)
+	}
+	if entry.Attr.Gid != wantGid {
+		t.Errorf("Mkdir reply gid = %v; want %v", entry.Attr.Gid, wantGid)
+	}
+
+	created := fuse.CreateOut{}
+	in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}
+	status = raw.Create(nil, in, "file", &created)
+	if !status.Ok() {
+		t.Fatalf("Create = %v", status)
+	}
+	if created.Attr.Gid != wantGid {
+		t.Errorf("Create reply gid = %v; want %v", created.Attr.Gid, wantGid)
+	}
+	attr := fuse.AttrOut{}
+	getattr := &fuse.GetAttrIn{
+		InHeader: fuse.InHeader{NodeId: created.NodeId},
+		Flags_:   fuse.FUSE_GETATTR_FH,
+		Fh_:      created.Fh,
+	}
+	status = raw.GetAttr(nil, getattr, &attr)
+	if !status.Ok() {
+		t.Fatalf("GetAttr = %v", status)
+	}
+	if attr.Gid != wantGid {
+		t.Errorf("GetAttr through handle gid = %v; want %v", attr.Gid, wantGid)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: created.NodeId}, Fh: created.Fh})
+
+	entry = fuse.EntryOut{}
+	status = raw.Symlink(nil, &header, "file", "link", &entry)
+	if !status.Ok() {
+		t.Fatalf("Symlink = %v", status)
+	}
+	if entry.Attr.Gid != wantGid {
+		t.Errorf("Symlink reply gid = %v; want %v", entry.Attr.Gid, wantGid)
+	}
+
+	// The creates go to remote in the background; let them land in
+	// this test's queue before it goes away
+	waitQueued(t, OP_MKDIR, "/dir")
+	waitQueued(t, OP_CREATE, "/file")
+	waitQueued(t, OP_SYMLINK, "/link")
+}
+
+// A file another user created on remote shows their mapped account on
+// this machine once synced, not the mounting user
+func TestSyncedFileShowsCreator(t *testing.T) {
+	useTestQueue(t)
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	oldEmail, oldMap := email, ownerMap
+	email = "bob@example.com"
+	ownerMap = map[string]string{"alice@example.com": "nobody"}
+	t.Cleanup(func() {
+		email, ownerMap = oldEmail, oldMap
+		ownerCacheMu.Lock()
+		clear(ownerCache)
+		ownerCacheMu.Unlock()
+	})
+
+	path := filepath.Join(realpath, "file")
+	err = os.WriteFile(path, []byte("alice's"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncOwner(path, "alice@example.com")
+	if lib.GetOwner(path) != "alice@example.com" {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	entry := fuse.EntryOut{}
+	status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &entry)
+	if !status.Ok() {
+		t.Fatalf("Lookup = %v", status)
+	}
+	if strconv.Itoa(int(entry.Attr.Uid)) != nobody.Uid || strconv.Itoa(int(entry.Attr.Gid)) != nobody.Gid {
+		t.Errorf("owner = %v:%v; want %v:%v", entry.Attr.Uid, entry.Attr.Gid, nobody.Uid, nobody.Gid)
+	}
+}
+
+// Counts every call that would change a file on remote
+type countingServer struct {
+	proto.UnimplementedFuseServer
+	calls atomic.Int32
+}
+
+func (s *countingServer) Create(ctx context.Context, req *proto.CreateRequest) (*proto.CreateResponse, error) {
+	s.calls.Add(1)
+	return &proto.CreateResponse{}, nil
+}
+
+func (s *countingServer) Setattr(ctx context.Context, req *proto.SetattrRequest) (*proto.FileAttr, error) {
+	s.calls.Add(1)
+	return &proto.FileAttr{}, nil
+}
+
+func (s *countingServer) Write(ctx context.Context, req *proto.WriteRequest) (*proto.WriteResponse, error) {
+	s.calls.Add(1)
+	return &proto.WriteResponse{}, nil
+}
+
+// With -default-permissions the kernel refuses a write to a file whose
+// mapped owner is someone else, though on disk it is ours and the
+// client would have let it through. Nothing reaches remote
+func TestDefaultPermissionsDeniedByKernel(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("the kernel lets root past default_permissions")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody account; ", err)
+	}
+	oldPerms, oldMap := defaultPermissions, ownerMap
+	defaultPermissions = true
+	ownerMap = map[string]string{"other@example.com": nobody.Username}
+	t.Cleanup(func() {
+		defaultPermissions, ownerMap = oldPerms, oldMap
+		ownerCacheMu.Lock()
+		clear(ownerCache)
+		ownerCacheMu.Unlock()
+	})
+
+	useTestMount(t)
+	srv := &countingServer{}
+	useTestRemote(t, srv)
+	online.Store(true)
+
+	shared := filepath.Join(realpath, "shared")
+	err = os.WriteFile(shared, []byte("shared"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.SetOwner(shared, "other@example.com") != nil {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+
+	info, err := os.Stat(filepath.Join(mountpoint, "shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; strconv.Itoa(int(uid)) != nobody.Uid {
+		t.Fatalf("shared shows uid %v; want %v", uid, nobody.Uid)
+	}
+	_, err = os.OpenFile(filepath.Join(mountpoint, "shared"), os.O_WRONLY, 0)
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("write open of a file someone else owns = %v; want EACCES", err)
+	}
+	err = os.Truncate(filepath.Join(mountpoint, "shared"), 0)
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("truncate of a file someone else owns = %v; want EACCES", err)
+	}
+	if calls := srv.calls.Load(); calls != 0 {
+		t.Errorf("remote got %v calls for refused accesses; want none", calls)
+	}
+	if got, _ := os.ReadFile(shared); string(got) != "shared" {
+		t.Errorf("shared = %q after refused writes; want it unchanged", got)
+	}
+}
+
+// A chown changing the owner a file shows is refused, since remote
+// would never hear of it; one naming the owner it shows changes nothing
+func TestChownRefused(t *testing.T) {
+	useTestQueue(t)
+	srv := &countingServer{}
+	useTestRemote(t, srv)
+	online.Store(true)
+	path := filepath.Join(realpath, "file")
+	err := os.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := syscall.Stat_t{}
+	if err := syscall.Lstat(path, &before); err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{path: path}
+
+	chown := func(valid uint32, uid, gid uint32) syscall.Errno {
+		in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+			Valid: valid,
+			Mode:  0600,
+			Owner: fuse.Owner{Uid: uid, Gid: gid},
+		}}
+		return node.Setattr(context.Background(), nil, in, &fuse.AttrOut{})
+	}
+	if errno := chown(fuse.FATTR_UID, before.Uid+1, 0); errno != syscall.EPERM {
+		t.Errorf("chown to another uid = %v; want EPERM", errno)
+	}
+	if errno := chown(fuse.FATTR_GID, 0, before.Gid+1); errno != syscall.EPERM {
+		t.Errorf("chgrp to another gid = %v; want EPERM", errno)
+	}
+	if errno := chown(fuse.FATTR_UID|fuse.FATTR_MODE, before.Uid+1, 0); errno != syscall.EPERM {
+		t.Errorf("chown with a chmod = %v; want EPERM", errno)
+	}
+	if errno := chown(fuse.FATTR_UID|fuse.FATTR_GID, before.Uid, before.Gid); errno != 0 {
+		t.Errorf("chown to the owner it has = %v; want it to succeed", errno)
+	}
+
+	after := syscall.Stat_t{}
+	if err := syscall.Lstat(path, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Uid != before.Uid || after.Gid != before.Gid || after.Mode != before.Mode {
+		t.Errorf("file is %v:%v mode %o after refused chowns; want %v:%v mode %o", after.Uid, after.Gid, after.Mode, before.Uid, before.Gid, before.Mode)
+	}
+	if calls := srv.calls.Load(); calls != 0 {
+		t.Errorf("remote got %v calls; want none", calls)
+	}
+
+	// A file someone else created shows their mapped account, which a
+	// chown may name but not change
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	oldMap := ownerMap
+	ownerMap = map[string]string{"other@example.com": nobody.Username}
+	t.Cleanup(func() {
+		ownerMap = oldMap
+		ownerCacheMu.Lock()
+		clear(ownerCache)
+		ownerCacheMu.Unlock()
+	})
+	if lib.SetOwner(path, "other@example.com") != nil {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+	uid, _ := strconv.Atoi(nobody.Uid)
+	if errno := chown(fuse.FATTR_UID, uint32(uid), 0); errno != 0 {
+		t.Errorf("chown to the mapped owner = %v; want it to succeed", errno)
+	}
+	if errno := chown(fuse.FATTR_UID, before.Uid, 0); errno != syscall.EPERM {
+		t.Errorf("chown of someone else's file to the owner on disk = %v; want EPERM", errno)
+	}
+	if err := syscall.Lstat(path, &after); err != nil || after.Uid != before.Uid {
+		t.Errorf("file owned by %v on disk; want %v left alone", after.Uid, before.Uid)
+	}
+}
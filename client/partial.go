@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Record of an in-flight download. Written to disk so that a download
+// interrupted by a network drop or crash can resume where it stopped
+type partialDownload struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"` // highest contiguous offset received
+	Hash   string `json:"hash"`   // remote hash the bytes belong to
+}
+
+func partialRecordPath(path string) string {
+	digest := md5.Sum([]byte(localPath(path)))
+	name := hex.EncodeToString(digest[:]) + ".fusion-partial"
+	return filepath.Join(lib.ProjectDir, "partial", name)
+}
+
+// Returns the partial download record of path, if any
+func loadPartial(path string) (*partialDownload, bool) {
+	data, err := os.ReadFile(partialRecordPath(path))
+	if err != nil {
+		return nil, false
+	}
+
+	var partial partialDownload
+	err = json.Unmarshal(data, &partial)
+	if err != nil || partial.Path != path {
+		return nil, false
+	}
+	return &partial, true
+}
+
+func savePartial(partial *partialDownload) error {
+	recordPath := partialRecordPath(partial.Path)
+	err := os.MkdirAll(filepath.Dir(recordPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(partial)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a torn record
+	tmp := recordPath + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, recordPath)
+}
+
+func removePartial(path string) {
+	os.Remove(partialRecordPath(path))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Serves contents, resuming where asked. The first download breaks
+// off after dropAfter bytes, or with stall set hangs there until the
+// client gives up on it
+type resumeServer struct {
+	proto.UnimplementedFuseServer
+	contents  []byte
+	dropAfter int
+	stall     bool
+
+	mu       sync.Mutex
+	requests []*proto.DownloadRequest
+	sent     []int
+}
+
+func (s *resumeServer) DownloadFile(req *proto.DownloadRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
+	s.mu.Lock()
+	attempt := len(s.requests)
+	s.requests = append(s.requests, req)
+	s.sent = append(s.sent, 0)
+	s.mu.Unlock()
+
+	digest := md5.Sum(s.contents)
+	hash := hex.EncodeToString(digest[:])
+	off := 0
+	if req.ResumeHash == hash {
+		off = int(req.ResumeOffset)
+	}
+	for off < len(s.contents) {
+		if attempt == 0 && off >= s.dropAfter {
+			if s.stall {
+				<-stream.Context().Done()
+				return stream.Context().Err()
+			}
+			return status.Error(codes.Unavailable, "connection dropped")
+		}
+		end := min(off+64*1024, len(s.contents))
+		err := stream.Send(&proto.FileChunk{
+			Data:      s.contents[off:end],
+			Offset:    int64(off),
+			TotalSize: int64(len(s.contents)),
+			Hash:      hash,
+		})
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.sent[attempt] += end - off
+		s.mu.Unlock()
+		off = end
+	}
+	return nil
+}
+
+// A download that broke off resumes from the bytes it got rather than
+// starting over
+func TestDownloadResumes(t *testing.T) {
+	useTestQueue(t)
+	contents := bytes.Repeat([]byte("0123456789abcdef"), 256*1024) // 4MiB
+	srv := &resumeServer{contents: contents, dropAfter: 3 * 1024 * 1024}
+	useTestRemote(t, srv)
+	entry := &proto.DirEntry{Path: "/large", Mode: 0644}
+
+	err := downloadFile(entry)
+	if err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	partial, ok := loadPartial(entry.Path)
+	if !ok || partial.Offset != int64(srv.dropAfter) {
+		t.Fatalf("partial record = %+v, %v; want offset %v", partial, ok, srv.dropAfter)
+	}
+
+	err = downloadFile(entry)
+	if err != nil {
+		t.Fatalf("resumed download failed; %v", err)
+	}
+	if got := srv.requests[1].ResumeOffset; got != int64(srv.dropAfter) {
+		t.Errorf("resumed from %v; want %v", got, srv.dropAfter)
+	}
+	if want := len(contents) - srv.dropAfter; srv.sent[1] != want {
+		t.Errorf("resume transferred %v bytes; want the remaining %v", srv.sent[1], want)
+	}
+	got, _ := os.ReadFile(localPath(entry.Path))
+	if !bytes.Equal(got, contents) {
+		t.Error("resumed file differs from remote")
+	}
+	if _, ok := loadPartial(entry.Path); ok {
+		t.Error("partial record left behind after the download completed")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRelativePath(t *testing.T) {
+	useTestQueue(t)
+	tests := []struct {
+		path, want string
+	}{
+		{realpath, ""},
+		{realpath + "/", ""},
+		{filepath.Join(realpath, "a"), "/a"},
+		{filepath.Join(realpath, "a", "b"), "/a/b"},
+		{realpath + "//a/./b", "/a/b"},
+		{filepath.Join(realpath, `back\slash`), `/back\slash`},
+		// A sibling sharing realpath's prefix is outside it
+		{realpath + "-other/a", realpath + "-other/a"},
+		{filepath.Dir(realpath), filepath.Dir(realpath)},
+	}
+	for _, test := range tests {
+		if got := relativePath(test.path); got != test.want {
+			t.Errorf("relativePath(%q) = %q; want %q", test.path, got, test.want)
+		}
+	}
+}
+
+// Wire paths from remote stay under realpath, whatever separators and
+// dots they hold, and turn back into their cleaned selves
+func TestLocalPath(t *testing.T) {
+	useTestQueue(t)
+	tests := []struct {
+		wire, want, back string
+	}{
+		{"", realpath, ""},
+		{"/", realpath, ""},
+		{"/a/b", filepath.Join(realpath, "a", "b"), "/a/b"},
+		{"a//b/", filepath.Join(realpath, "a", "b"), "/a/b"},
+		{"/../../etc/passwd", filepath.Join(realpath, "etc", "passwd"), "/etc/passwd"},
+		{`/a\b`, filepath.Join(realpath, `a\b`), `/a\b`},
+	}
+	for _, test := range tests {
+		got := localPath(test.wire)
+		if got != test.want {
+			t.Errorf("localPath(%q) = %q; want %q", test.wire, got, test.want)
+		}
+		if back := relativePath(got); back != test.back {
+			t.Errorf("relativePath(localPath(%q)) = %q; want %q", test.wire, back, test.back)
+		}
+	}
+}
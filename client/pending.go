@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+)
+
+// Getattr answers from the backing file, which always has our latest
+// changes. On-demand placeholders are the exception: they stay empty
+// until first opened, so the size reported for them is the one remote
+// listed. Remote's word is only taken while no local change to the
+// path is on its way there; a change being sent or waiting in the
+// offline queue means the backing file is newer than anything remote
+// told us
+
+var (
+	// Changes being sent to remote, per relative path
+	sending = make(map[string]int)
+
+	// Paths with changes in the offline queue
+	queuedPaths = make(map[string]bool)
+
+	pendingMu = sync.Mutex{}
+)
+
+// Records that op is being sent to remote. The returned function
+// must be called once remote answered
+func beginSend(op queuedOp) func() {
+	paths := []string{op.Path}
+	if op.NewPath != "" {
+		paths = append(paths, op.NewPath)
+	}
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for _, path := range paths {
+		sending[path]++
+	}
+
+	return func() {
+		pendingMu.Lock()
+		defer pendingMu.Unlock()
+		for _, path := range paths {
+			sending[path]--
+			if sending[path] <= 0 {
+				delete(sending, path)
+			}
+		}
+	}
+}
+
+// Records the paths op changes as queued
+func addQueuedPaths(op queuedOp) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	queuedPaths[op.Path] = true
+	if op.NewPath != "" {
+		queuedPaths[op.NewPath] = true
+	}
+}
+
+// Replaces the queued paths with those of ops, the new contents of the
+// offline queue
+func setQueuedPaths(ops []queuedOp) {
+	paths := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		paths[op.Path] = true
+		if op.NewPath != "" {
+			paths[op.NewPath] = true
+		}
+	}
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	queuedPaths = paths
+}
+
+// Reports whether local changes to relative path have yet to reach
+// remote
+func hasPendingChanges(path string) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	return sending[path] > 0 || queuedPaths[path]
+}
+
+// Puts the size remote listed for relative path into st if path is an
+// on-demand placeholder and remote's size can be trusted
+func remotePlaceholderSize(path string, st *syscall.Stat_t) {
+	onDemandMu.Lock()
+	size, ok := onDemand[path]
+	onDemandMu.Unlock()
+
+	if !ok || hasPendingChanges(path) {
+		return
+	}
+	st.Size = int64(size)
+	st.Blocks = 0
+}
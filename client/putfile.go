@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// New files opened for writing with O_TRUNC, which is how most
+// programs write a file out, are not created on remote straight away.
+// Remote gets them when they are first flushed: small ones with a
+// single PutFile carrying contents and mode, larger ones with the
+// usual Create and upload. Any other change to such a file sent
+// before then creates it first, so remote never hears of changes to
+// a file it doesn't have
+
+type deferredCreate struct {
+	create func()       // sends Create and reports its result to done
+	done   chan<- error // remoteCreate of the file's handle
+}
+
+var (
+	// Creates held back until first flush, per relative path
+	deferredCreates   = make(map[string]*deferredCreate)
+	deferredCreatesMu = sync.Mutex{}
+)
+
+func deferCreate(path string, dc *deferredCreate) {
+	deferredCreatesMu.Lock()
+	defer deferredCreatesMu.Unlock()
+	deferredCreates[path] = dc
+}
+
+// Takes the deferred create of relative path, if there is one. Only
+// one caller gets it
+func claimDeferredCreate(path string) *deferredCreate {
+	deferredCreatesMu.Lock()
+	defer deferredCreatesMu.Unlock()
+
+	dc, ok := deferredCreates[path]
+	if !ok {
+		return nil
+	}
+	delete(deferredCreates, path)
+	return dc
+}
+
+// Sends the deferred creates of relative paths now. Called before
+// any other change to them goes to remote
+func flushDeferredCreates(paths ...string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if dc := claimDeferredCreate(path); dc != nil {
+			dc.create()
+		}
+	}
+}
+
+// Reports whether a file of size bytes can be sent with PutFile
+func putFits(size int64) bool {
+	if e2eEnabled() {
+		size = sealedSize(size)
+	}
+	return size <= lib.MAX_PUT_SIZE && remoteSupports(lib.FEATURE_PUT)
+}
+
+// Sends a new file whose create was deferred to remote in one PutFile.
+// Reports false, having sent nothing, if the file is too large for
+// it. Called with fh.mu held
+func (fh *FileHandle) putNew(dc *deferredCreate) bool {
+	st := syscall.Stat_t{}
+	err := syscall.Fstat(fh.fd, &st)
+	if err != nil || !putFits(st.Size) {
+		return false
+	}
+	fh.dirty = false
+
+	op := queuedOp{Op: OP_PUT, Path: relativePath(fh.path)}
+	err = sendOrQueue(op, func(ctx context.Context) error {
+		fd, release, err := fh.readFd()
+		if err != nil {
+			return err
+		}
+		defer release()
+		return putFile(ctx, fh.path, fd, st.Size, st.Mode&07777)
+	})
+	if err != nil {
+		markRemoteFull(op.Path, err)
+		log.Printf("[FUSE] Error putting file %v; %v\n", fh.path, err)
+	} else {
+		clearRemoteFull(op.Path)
+		fh.resendTimes()
+	}
+
+	// Lets isOrphan tell whether remote ever got the file
+	dc.done <- err
+	if err == nil {
+		fh.remoteCreate = nil
+	}
+	return true
+}
+
+// Sends size bytes read from fd to remote as the whole of local file
+// fullpath, creating it with mode if it doesn't exist
+func putFile(ctx context.Context, fullpath string, fd int, size int64, mode uint32) error {
+	path := relativePath(fullpath)
+
+	ctx, tr, done := startTransfer(ctx, path, TRANSFER_UPLOAD)
+	defer done()
+
+	data := make([]byte, size)
+	n, err := syscall.Pread(fd, data, 0)
+	if err != nil {
+		return err
+	}
+	data = data[:n]
+
+	var id []byte
+	plain := data
+	if e2eEnabled() {
+		id, data = sealFile(data)
+	}
+
+	res, err := grpcClient.PutFile(ctx, &proto.PutFileRequest{
+		Path: path,
+		Data: data,
+		Mode: mode,
+	})
+	if err != nil {
+		return err
+	}
+	// Sent already; a cancel only cuts the wait short
+	tr.moved(ctx, n)
+	if id != nil {
+		hash := md5.Sum(plain)
+		err = recordUpload(fullpath, id, hex.EncodeToString(hash[:]), res.Hash)
+		if err != nil {
+			log.Printf("[SYNC] Error recording hash of %v; %v\n", fullpath, err)
+		}
+	}
+	storeHash(fullpath, res.Hash)
+	return nil
+}
+
+// Replays a queued OP_PUT. Files that have grown too large for
+// PutFile since are uploaded instead
+func putLocal(ctx context.Context, path string) error {
+	fullpath := localPath(path)
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	st := syscall.Stat_t{}
+	err = syscall.Fstat(int(file.Fd()), &st)
+	if err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		return nil
+	}
+	if !putFits(st.Size) {
+		return uploadLocal(ctx, path)
+	}
+	return putFile(ctx, fullpath, int(file.Fd()), st.Size, st.Mode&07777)
+}
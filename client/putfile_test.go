@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc"
+)
+
+// Answers what creating and writing a file takes, and counts every
+// call it gets per method, implemented or not
+type putServer struct {
+	proto.UnimplementedFuseServer
+
+	callsMu  sync.Mutex
+	calls    map[string]int
+	order    []string // methods called, in order
+	puts     []*proto.PutFileRequest
+	writes   []*proto.WriteRequest
+	setattrs []*proto.SetattrRequest
+}
+
+func (s *putServer) Create(ctx context.Context, req *proto.CreateRequest) (*proto.CreateResponse, error) {
+	return &proto.CreateResponse{Attr: &proto.FileAttr{Mode: req.Mode}}, nil
+}
+
+func (s *putServer) PutFile(ctx context.Context, req *proto.PutFileRequest) (*proto.PutFileResponse, error) {
+	s.callsMu.Lock()
+	s.puts = append(s.puts, req)
+	s.callsMu.Unlock()
+	return &proto.PutFileResponse{Attr: &proto.FileAttr{Size: uint64(len(req.Data))}, Hash: hashOf(string(req.Data))}, nil
+}
+
+func (s *putServer) Write(ctx context.Context, req *proto.WriteRequest) (*proto.WriteResponse, error) {
+	s.callsMu.Lock()
+	s.writes = append(s.writes, req)
+	s.callsMu.Unlock()
+	return &proto.WriteResponse{BytesWritten: uint64(len(req.Data))}, nil
+}
+
+func (s *putServer) Setattr(ctx context.Context, req *proto.SetattrRequest) (*proto.FileAttr, error) {
+	s.callsMu.Lock()
+	s.setattrs = append(s.setattrs, req)
+	s.callsMu.Unlock()
+	return &proto.FileAttr{}, nil
+}
+
+func (s *putServer) count(method string) {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	s.calls[method]++
+	s.order = append(s.order, path.Base(method))
+}
+
+// Forgets the calls so far, such as the listing of the root
+func (s *putServer) reset() {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	clear(s.calls)
+	s.order = nil
+}
+
+// Calls remote got, all methods together
+func (s *putServer) total() int {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	n := 0
+	for _, calls := range s.calls {
+		n += calls
+	}
+	return n
+}
+
+// Serves srv as remote, online, with the given features
+func usePutRemote(t testing.TB, srv *putServer, features ...string) {
+	srv.calls = make(map[string]int)
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		srv.count(info.FullMethod)
+		return handler(ctx, req)
+	}
+	stream := func(s any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		srv.count(info.FullMethod)
+		return handler(s, ss)
+	}
+	old := grpcClient
+	grpcClient = newTestClient(t, srv, grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+	t.Cleanup(func() { grpcClient = old })
+	useRemoteFeatures(t, features...)
+	online.Store(true)
+
+	oldLocal := localStatfs
+	localStatfs = lib.NewStatfsCache(0)
+	t.Cleanup(func() { localStatfs = oldLocal })
+}
+
+// Creates name under the root of raw and writes data to it the way
+// most programs write a file out, then closes it
+func writeNewFile(t testing.TB, raw fuse.RawFileSystem, name string, data []byte) {
+	t.Helper()
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	created := fuse.CreateOut{}
+	in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_WRONLY | os.O_CREATE | os.O_TRUNC), Mode: 0640}
+	if status := raw.Create(nil, in, name, &created); !status.Ok() {
+		t.Fatalf("Create %v = %v", name, status)
+	}
+	header.NodeId = created.NodeId
+	written, status := raw.Write(nil, &fuse.WriteIn{InHeader: header, Fh: created.Fh}, data)
+	if !status.Ok() || int(written) != len(data) {
+		t.Fatalf("Write %v = %v, %v; want %v bytes written", name, written, status, len(data))
+	}
+	if status := raw.Flush(nil, &fuse.FlushIn{InHeader: header, Fh: created.Fh}); !status.Ok() {
+		t.Fatalf("Flush %v = %v", name, status)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: created.Fh})
+}
+
+// A small new file reaches remote in one PutFile carrying its contents
+// and mode; without PutFile it takes a Create and an upload
+func TestSmallNewFilePut(t *testing.T) {
+	useTestQueue(t)
+	srv := &putServer{}
+	usePutRemote(t, srv, lib.FEATURE_PUT)
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	srv.reset()
+
+	data := bytes.Repeat([]byte("x"), 100)
+	writeNewFile(t, raw, "small", data)
+	if n := srv.total(); n != 1 || len(srv.puts) != 1 {
+		t.Fatalf("remote got %v; want a single PutFile", srv.calls)
+	}
+	put := srv.puts[0]
+	if put.Path != "/small" || !bytes.Equal(put.Data, data) || put.Mode&0777 != 0640 {
+		t.Errorf("PutFile of %v with %v bytes, mode %o; want /small, 100 bytes, mode 640", put.Path, len(put.Data), put.Mode)
+	}
+
+	srv.reset()
+	large := bytes.Repeat([]byte("x"), lib.MAX_PUT_SIZE+1)
+	writeNewFile(t, raw, "large", large)
+	if len(srv.puts) != 1 {
+		t.Errorf("file over MAX_PUT_SIZE sent with PutFile")
+	}
+	if len(srv.writes) != 1 || !srv.writes[0].Replace || !bytes.Equal(srv.writes[0].Data, large) {
+		t.Fatalf("remote got %v writes; want the %v bytes in one replacing write", len(srv.writes), len(large))
+	}
+	if n := srv.total(); n != 2 {
+		t.Errorf("remote got %v for a large file; want a Create and a Write", srv.calls)
+	}
+}
+
+// Times set on a file being written out, as cp -p does before close,
+// are sent again once the contents replaced remote's copy
+func TestRewriteKeepsSetTimes(t *testing.T) {
+	for _, put := range []bool{true, false} {
+		name := "put"
+		features := []string{lib.FEATURE_PUT}
+		if !put {
+			name = "upload"
+			features = nil
+		}
+		t.Run(name, func(t *testing.T) {
+			useTestQueue(t)
+			srv := &putServer{}
+			usePutRemote(t, srv, features...)
+			raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+			srv.reset()
+
+			header := fuse.InHeader{
+				NodeId: fuse.FUSE_ROOT_ID,
+				Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+			}
+			created := fuse.CreateOut{}
+			in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_WRONLY | os.O_CREATE | os.O_TRUNC), Mode: 0644}
+			if status := raw.Create(nil, in, "copy", &created); !status.Ok() {
+				t.Fatalf("Create = %v", status)
+			}
+			header.NodeId = created.NodeId
+			if _, status := raw.Write(nil, &fuse.WriteIn{InHeader: header, Fh: created.Fh}, []byte("copied")); !status.Ok() {
+				t.Fatalf("Write = %v", status)
+			}
+			mtime := time.Unix(1_000_000, 0)
+			setattr := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+				InHeader: header,
+				Valid:    fuse.FATTR_FH | fuse.FATTR_ATIME | fuse.FATTR_MTIME,
+				Fh:       created.Fh,
+			}}
+			setattr.Atime, setattr.Mtime = uint64(mtime.Unix()), uint64(mtime.Unix())
+			if status := raw.SetAttr(nil, setattr, &fuse.AttrOut{}); !status.Ok() {
+				t.Fatalf("SetAttr = %v", status)
+			}
+			raw.Flush(nil, &fuse.FlushIn{InHeader: header, Fh: created.Fh})
+			raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: created.Fh})
+
+			// The last call carrying the contents is followed by a
+			// Setattr with the times. The handle's own Setattr may
+			// land after the contents too, so wait for both
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				srv.callsMu.Lock()
+				order := append([]string(nil), srv.order...)
+				setattrs := append([]*proto.SetattrRequest(nil), srv.setattrs...)
+				srv.callsMu.Unlock()
+
+				contents := -1
+				for i, method := range order {
+					if method == "PutFile" || method == "Write" {
+						contents = i
+					}
+				}
+				resent := contents >= 0 && slices.Contains(order[contents:], "Setattr")
+				if resent && len(setattrs) == 2 {
+					last := setattrs[len(setattrs)-1]
+					if last.MTime == nil || !last.MTime.AsTime().Equal(mtime) {
+						t.Errorf("last Setattr sent mtime %v; want %v", last.MTime, mtime)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("remote got %v; want the times sent after the contents", order)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// Calls remote gets per small file created, with and without PutFile
+func BenchmarkSmallFileCreates(b *testing.B) {
+	data := bytes.Repeat([]byte("x"), 100)
+	for _, put := range []bool{true, false} {
+		name := "put"
+		features := []string{lib.FEATURE_PUT}
+		if !put {
+			name = "create-upload"
+			features = nil
+		}
+		b.Run(name, func(b *testing.B) {
+			useTestQueue(b)
+			srv := &putServer{}
+			usePutRemote(b, srv, features...)
+			raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+			srv.reset()
+
+			i := 0
+			for b.Loop() {
+				writeNewFile(b, raw, "file"+strconv.Itoa(i), data)
+				i++
+			}
+			b.ReportMetric(float64(srv.total())/float64(i), "rpcs/op")
+		})
+	}
+}
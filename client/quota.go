@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// How long inode usage fetched from remote is reused
+const INODE_COUNT_TTL = 10 * time.Second
+
+// Disk usage of realpath; see -statfs-ttl
+var localStatfs *lib.StatfsCache
+
+var (
+	lastStatfs   *proto.StatfsResponse
+	lastStatfsAt time.Time
+	statfsMu     = sync.Mutex{}
+)
+
+// Returns the inode usage of our org on remote
+func remoteStatfs(ctx context.Context) (*proto.StatfsResponse, bool) {
+	statfsMu.Lock()
+	defer statfsMu.Unlock()
+
+	if !online.Load() {
+		return lastStatfs, lastStatfs != nil
+	}
+	if !remoteSupports(lib.FEATURE_STATFS) {
+		return nil, false
+	}
+	if lastStatfs != nil && time.Since(lastStatfsAt) < INODE_COUNT_TTL {
+		return lastStatfs, true
+	}
+
+	ctx = NewAuthenticatedCtx(ctx)
+	response, err := grpcClient.Statfs(ctx, &emptypb.Empty{})
+	if err != nil {
+		return lastStatfs, lastStatfs != nil
+	}
+
+	lastStatfs = response
+	lastStatfsAt = time.Now()
+	return response, true
+}
+
+// Reports whether the org still has room for another file or
+// directory. Always true when remote has no inode quota
+func inodesAvailable(ctx context.Context) bool {
+	stat, ok := remoteStatfs(ctx)
+	if !ok || stat.Quota == 0 {
+		return true
+	}
+	return stat.Ffree > 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Reports an org with quota inodes, free of them left
+type statfsServer struct {
+	proto.UnimplementedFuseServer
+	files, free, quota uint64
+}
+
+func (s statfsServer) Statfs(ctx context.Context, _ *emptypb.Empty) (*proto.StatfsResponse, error) {
+	return &proto.StatfsResponse{Files: s.files, Ffree: s.free, Quota: s.quota}, nil
+}
+
+// Serves remote's inode usage from srv for the rest of the test
+func useTestStatfs(t *testing.T, srv statfsServer) {
+	useTestQueue(t)
+	useTestRemote(t, srv)
+	useRemoteFeatures(t, lib.FEATURE_STATFS)
+	online.Store(true)
+	oldLocal := localStatfs
+	localStatfs = lib.NewStatfsCache(0)
+	t.Cleanup(func() {
+		localStatfs = oldLocal
+		statfsMu.Lock()
+		lastStatfs, lastStatfsAt = nil, time.Time{}
+		statfsMu.Unlock()
+	})
+	statfsMu.Lock()
+	lastStatfs = nil
+	statfsMu.Unlock()
+}
+
+// df -i in the mount shows the org's inode quota and usage on remote
+func TestStatfsReportsRemoteInodes(t *testing.T) {
+	useTestStatfs(t, statfsServer{files: 7, free: 3, quota: 10})
+
+	out := fuse.StatfsOut{}
+	errno := (&Node{path: realpath}).Statfs(context.Background(), &out)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if out.Files != 10 || out.Ffree != 3 {
+		t.Errorf("Files, Ffree = %v, %v; want 10, 3", out.Files, out.Ffree)
+	}
+}
+
+// With the quota used up, Create and Mkdir fail with ENOSPC and leave
+// nothing behind
+func TestInodeQuotaRefusesCreates(t *testing.T) {
+	useTestStatfs(t, statfsServer{files: 10, free: 0, quota: 10})
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	status := raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 0755}, "dir", &fuse.EntryOut{})
+	if status != fuse.Status(syscall.ENOSPC) {
+		t.Errorf("Mkdir = %v; want ENOSPC", status)
+	}
+	in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}
+	status = raw.Create(nil, in, "file", &fuse.CreateOut{})
+	if status != fuse.Status(syscall.ENOSPC) {
+		t.Errorf("Create = %v; want ENOSPC", status)
+	}
+	entries, _ := os.ReadDir(realpath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %v", entries)
+	}
+}
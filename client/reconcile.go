@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Brings the local copy up to date with changes made on remote while
+// we were not listening for them, eg. while offline. Files remote no
+// longer has are removed like a DELETE event would, which honours
+// -remote-delete, and directories with files remote has that we don't
+// are fetched again.
+//
+// Only files that were last known to match remote are removed. A file
+// edited here since, or never synced, is left alone, as is anything
+// changed while reconciling. Waits for the sync window to open.
+// Push-only mounts never reconcile
+func reconcile(ctx context.Context) {
+	if !pullsRemote() {
+		return
+	}
+	if !waitForSyncWindow(ctx) {
+		return
+	}
+	since := time.Now()
+	ctx = NewAuthenticatedCtx(ctx)
+	remote, err := remoteManifest(ctx)
+	if err != nil {
+		log.Printf("[SYNC] Skipping reconciliation with remote; %v\n", err)
+		return
+	}
+	local, err := localManifest()
+	if err != nil {
+		log.Printf("[SYNC] Skipping reconciliation with remote; %v\n", err)
+		return
+	}
+
+	gone := []string{}
+	for path := range local {
+		if _, ok := remote[path]; !ok && !syncIgnored(path) {
+			gone = append(gone, path)
+		}
+	}
+	// Children before their parents so emptied directories can go too
+	sort.Sort(sort.Reverse(sort.StringSlice(gone)))
+
+	removed := 0
+	for _, path := range gone {
+		fullpath := localPath(path)
+		info, err := os.Lstat(fullpath)
+		if err != nil || info.ModTime().After(since) {
+			continue
+		}
+		if local[path].isDir {
+			if !isEmptyDir(fullpath) || !remoteConfirmsDelete(path) {
+				continue
+			}
+			err = os.Remove(fullpath)
+		} else {
+			if storedHash(fullpath) == "" || !remoteConfirmsDelete(path) {
+				continue
+			}
+			err = removeDeleted(path)
+		}
+		if err != nil {
+			log.Printf("[SYNC] Error removing %v deleted on remote; %v\n", path, err)
+			continue
+		}
+		forgetIno(path)
+		forgetListing(path)
+		invalidateEntry(path)
+		removed++
+	}
+
+	// Directories we already have that are missing some of remote's
+	// files. Those we never listed are fetched when first opened
+	missing := make(map[string]bool)
+	for path := range remote {
+		if _, ok := local[path]; ok {
+			continue
+		}
+		parent := filepath.Dir(path)
+		if _, ok := local[parent]; ok || parent == "/" {
+			missing[parent] = true
+		}
+	}
+	for dir := range missing {
+		err := fetchRemoteEntries(ctx, strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			log.Printf("[SYNC] Error fetching %v from remote; %v\n", dir, err)
+		}
+	}
+
+	if removed > 0 || len(missing) > 0 {
+		log.Printf("[SYNC] Reconciled with remote; removed %v entries, refreshed %v directories\n", removed, len(missing))
+	}
+}
+
+func isEmptyDir(path string) bool {
+	entries, err := os.ReadDir(path)
+	return err == nil && len(entries) == 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Serves a fixed remote tree of file contents; directories end in "/"
+type reconcileServer struct {
+	proto.UnimplementedFuseServer
+	files map[string]string
+}
+
+func (s reconcileServer) entry(path string) *proto.ManifestEntry {
+	if _, ok := s.files[path+"/"]; ok {
+		return &proto.ManifestEntry{Path: path, Mode: uint32(os.ModeDir | 0755)}
+	}
+	contents := s.files[path]
+	return &proto.ManifestEntry{Path: path, Mode: 0644, Size: uint64(len(contents)), Hash: hashOf(contents)}
+}
+
+func (s reconcileServer) GetManifest(req *proto.ManifestRequest, stream proto.Fuse_GetManifestServer) error {
+	dir := filepath.Clean("/" + req.Path)
+	for name := range s.files {
+		path := filepath.Clean(name)
+		if !req.Recursive && filepath.Dir(path) != dir {
+			continue
+		}
+		err := stream.Send(s.entry(path))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s reconcileServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
+	entry := s.entry(req.Path)
+	_, isFile := s.files[req.Path]
+	_, isDir := s.files[req.Path+"/"]
+	if !isFile && !isDir {
+		return nil, status.Error(codes.NotFound, "no such file")
+	}
+	return &proto.DirEntry{Path: entry.Path, Mode: entry.Mode}, nil
+}
+
+func (s reconcileServer) DownloadFile(req *proto.DownloadRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
+	contents := s.files[req.Path]
+	return stream.Send(&proto.FileChunk{Data: []byte(contents), TotalSize: int64(len(contents))})
+}
+
+// A file deleted on remote while the client was away is trashed on
+// reconnect once remote confirms it is gone. Files edited here since
+// are kept and files added on remote meanwhile are fetched
+func TestReconcileAfterDowntime(t *testing.T) {
+	useTestListings(t)
+	useTestQueue(t)
+	useRemoteFeatures(t, lib.FEATURE_MANIFEST)
+	useRemoteDelete(t, REMOTE_DELETE_TRASH, false)
+	useTestRemote(t, reconcileServer{files: map[string]string{
+		"/kept":    "kept",
+		"/dir/":    "",
+		"/dir/old": "old",
+		"/dir/new": "new",
+	}})
+	old := reconcileRemote
+	reconcileRemote = true
+	t.Cleanup(func() { reconcileRemote = old })
+
+	probe := localPath("/probe")
+	err := os.WriteFile(probe, nil, 0644)
+	if err == nil {
+		err = syscall.Setxattr(probe, HASH_XATTR, []byte("probe"), 0)
+	}
+	if err != nil {
+		t.Skip("no user extended attributes in ", realpath)
+	}
+	os.Remove(probe)
+
+	synced := map[string]string{"/kept": "kept", "/dir/old": "old", "/gone": "gone"}
+	for path, contents := range synced {
+		err := os.MkdirAll(filepath.Dir(localPath(path)), 0755)
+		if err == nil {
+			err = os.WriteFile(localPath(path), []byte(contents), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		storeHash(localPath(path), hashOf(contents))
+	}
+	err = os.WriteFile(localPath("/edited"), []byte("never synced"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(localPath("/emptied"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconcile(context.Background())
+
+	for path, want := range map[string]bool{
+		"/kept":    true,
+		"/dir/old": true,
+		"/edited":  true,
+		"/gone":    false,
+		"/emptied": false,
+		"/dir/new": true,
+	} {
+		_, err := os.Lstat(localPath(path))
+		if got := err == nil; got != want {
+			t.Errorf("%v present after reconciling = %v; want %v", path, got, want)
+		}
+	}
+	if got := trashed(t, "/gone"); len(got) != 1 || got[0] != "gone" {
+		t.Errorf("trash holds %q; want the file deleted on remote", got)
+	}
+	if got, _ := os.ReadFile(localPath("/dir/new")); string(got) != "new" {
+		t.Errorf("file added on remote = %q; want %q", got, "new")
+	}
+}
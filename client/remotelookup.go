@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Files remote got after we last listed their directory have no local
+// copy or listing entry yet. Lookup asks remote about names it can't
+// find locally so they can still be opened by name; remote's answer
+// becomes an on-demand placeholder like a listed entry would
+const (
+	// Longest a lookup waits for remote
+	REMOTE_LOOKUP_TIMEOUT = 5 * time.Second
+
+	// How long a name remote doesn't have is not asked about again.
+	// Programs probe for missing files, eg. config files in several
+	// places, far more often than the kernel's negative cache lasts
+	REMOTE_MISS_TTL = 30 * time.Second
+
+	// Most misses remembered at once
+	MAX_REMOTE_MISSES = 10_000
+)
+
+var (
+	// Relative path -> when remote said it had no such entry
+	remoteMisses   = make(map[string]time.Time)
+	remoteMissesMu = sync.Mutex{}
+)
+
+// Asks remote for relative path, which is missing locally, and
+// creates its stand in if remote has it. Reports whether it did.
+// Skipped offline, on push-only mounts and for paths with local
+// changes on their way to remote, eg. an unlink not sent yet
+func lookupRemote(ctx context.Context, path string) bool {
+	if !online.Load() || !pullsRemote() || syncIgnored(path) || hasPendingChanges(path) {
+		return false
+	}
+	if recentRemoteMiss(path) {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, REMOTE_LOOKUP_TIMEOUT)
+	defer cancel()
+	res, err := grpcClient.Lookup(NewAuthenticatedCtx(ctx), &proto.LookupRequest{Path: path})
+	if status.Code(err) == codes.NotFound {
+		rememberRemoteMiss(path)
+		return false
+	}
+	if err != nil || res.Attr == nil {
+		if err != nil {
+			log.Printf("[FUSE] Error looking up %v on remote; %v\n", path, err)
+		}
+		return false
+	}
+
+	mode := statMode(res.Attr.Mode)
+	if remoteIgnored(path, uint32(mode)) || remoteTempFile(path, uint32(mode)) {
+		return false
+	}
+	err = materialize(path, listedEntry{
+		Mode:   uint32(mode),
+		Size:   res.Attr.Size,
+		Target: res.Target,
+	})
+	if err != nil {
+		return false
+	}
+	log.Printf("[SYNC] Found %v on remote; added it as a placeholder\n", path)
+	return true
+}
+
+// Asks remote where symlink path points. Fails with ENOENT offline or
+// if remote has no such symlink
+func remoteLinkTarget(path string) (string, error) {
+	if !online.Load() {
+		return "", syscall.ENOENT
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), REMOTE_LOOKUP_TIMEOUT)
+	defer cancel()
+	res, err := grpcClient.Lookup(NewAuthenticatedCtx(ctx), &proto.LookupRequest{Path: path})
+	if err != nil {
+		return "", err
+	}
+	if res.Target == "" {
+		// A server that follows symlinks on Lookup
+		return "", syscall.ENOENT
+	}
+	return res.Target, nil
+}
+
+func recentRemoteMiss(path string) bool {
+	remoteMissesMu.Lock()
+	defer remoteMissesMu.Unlock()
+
+	missed, ok := remoteMisses[path]
+	if !ok {
+		return false
+	}
+	if time.Since(missed) > REMOTE_MISS_TTL {
+		delete(remoteMisses, path)
+		return false
+	}
+	return true
+}
+
+func rememberRemoteMiss(path string) {
+	remoteMissesMu.Lock()
+	defer remoteMissesMu.Unlock()
+
+	if len(remoteMisses) >= MAX_REMOTE_MISSES {
+		remoteMisses = make(map[string]time.Time)
+	}
+	remoteMisses[path] = time.Now()
+}
+
+// Forgets that remote lacked path. Called when remote tells us it has
+// it after all
+func forgetRemoteMiss(path string) {
+	remoteMissesMu.Lock()
+	defer remoteMissesMu.Unlock()
+	delete(remoteMisses, path)
+}
+
+// Converts st_mode as sent in FileAttr into an os.FileMode
+func statMode(mode uint32) os.FileMode {
+	perm := os.FileMode(mode & 0777)
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		return perm | os.ModeDir
+	case syscall.S_IFLNK:
+		return perm | os.ModeSymlink
+	case syscall.S_IFREG:
+		return perm
+	default:
+		return perm | os.ModeIrregular
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Has a single symlink, /link, pointing at target
+type symlinkServer struct {
+	proto.UnimplementedFuseServer
+}
+
+func (symlinkServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
+	if req.Path != "/link" {
+		return nil, status.Error(codes.NotFound, "no such file")
+	}
+	return &proto.DirEntry{
+		Path:   req.Path,
+		Attr:   &proto.FileAttr{Mode: syscall.S_IFLNK | 0777},
+		Target: "target",
+	}, nil
+}
+
+func checkLink(t *testing.T, path string) {
+	t.Helper()
+	target, err := os.Readlink(localPath(path))
+	if err != nil {
+		t.Fatalf("no symlink at %v; %v", path, err)
+	}
+	if target != "target" {
+		t.Errorf("%v points at %q; want %q", path, target, "target")
+	}
+}
+
+// Symlinks only remote has come down as symlinks, whether remote was
+// asked about them or they were listed
+func TestRemoteSymlinkMaterialized(t *testing.T) {
+	useTestQueue(t)
+	useTestRemote(t, symlinkServer{})
+	online.Store(true)
+
+	if !lookupRemote(context.Background(), "/link") {
+		t.Fatal("lookupRemote of a remote symlink failed")
+	}
+	checkLink(t, "/link")
+
+	os.Remove(localPath("/link"))
+	err := materialize("/link", listedEntry{Mode: uint32(os.ModeSymlink | 0777)})
+	if err != nil {
+		t.Fatalf("materialize of listed symlink = %v", err)
+	}
+	checkLink(t, "/link")
+
+	// Offline there's no asking for the target
+	os.Remove(localPath("/link"))
+	online.Store(false)
+	err = materialize("/link", listedEntry{Mode: uint32(os.ModeSymlink | 0777)})
+	if err != syscall.ENOENT {
+		t.Errorf("offline materialize of listed symlink = %v; want ENOENT", err)
+	}
+}
+
+// Has files that were never listed to us, with contents. Counts
+// lookups and downloads
+type remoteOnlyServer struct {
+	proto.UnimplementedFuseServer
+	files map[string]string
+
+	lookups, downloads atomic.Int32
+}
+
+func (s *remoteOnlyServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
+	s.lookups.Add(1)
+	contents, ok := s.files[req.Path]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such file")
+	}
+	return &proto.DirEntry{
+		Path: req.Path,
+		Attr: &proto.FileAttr{Mode: syscall.S_IFREG | 0644, Size: uint64(len(contents))},
+	}, nil
+}
+
+func (s *remoteOnlyServer) DownloadFile(req *proto.DownloadRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
+	s.downloads.Add(1)
+	contents, ok := s.files[req.Path]
+	if !ok {
+		return status.Error(codes.NotFound, "no such file")
+	}
+	return stream.Send(&proto.FileChunk{Data: []byte(contents), TotalSize: int64(len(contents))})
+}
+
+// A file only remote knows of is found by name and downloaded once
+// opened. Names remote lacks aren't asked about again for a while,
+// and nothing is asked offline
+func TestLookupRemoteOnlyFile(t *testing.T) {
+	useTestListings(t)
+	useTestQueue(t)
+	srv := &remoteOnlyServer{files: map[string]string{
+		"/new.txt":     "contents",
+		"/offline.txt": "unseen",
+	}}
+	useTestRemote(t, srv)
+	online.Store(true)
+	t.Cleanup(func() {
+		onDemandMu.Lock()
+		delete(onDemand, "/new.txt")
+		onDemandMu.Unlock()
+		forgetRemoteMiss("/missing")
+	})
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	entry := fuse.EntryOut{}
+	if status := raw.Lookup(nil, &header, "new.txt", &entry); !status.Ok() {
+		t.Fatalf("Lookup of a remote-only file = %v", status)
+	}
+	if entry.Size != 8 {
+		t.Errorf("remote-only file has size %v; want remote's 8", entry.Size)
+	}
+	if n := srv.downloads.Load(); n != 0 {
+		t.Errorf("lookup downloaded the file %v times; want 0", n)
+	}
+
+	header.NodeId = entry.NodeId
+	opened := fuse.OpenOut{}
+	if status := raw.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_RDONLY)}, &opened); !status.Ok() {
+		t.Fatalf("Open of a remote-only file = %v", status)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: opened.Fh})
+	if n := srv.downloads.Load(); n != 1 {
+		t.Errorf("open downloaded the file %v times; want 1", n)
+	}
+	if data, _ := os.ReadFile(localPath("/new.txt")); string(data) != "contents" {
+		t.Errorf("opened file holds %q; want contents", data)
+	}
+
+	header.NodeId = fuse.FUSE_ROOT_ID
+	lookups := srv.lookups.Load()
+	for range 2 {
+		if status := raw.Lookup(nil, &header, "missing", &fuse.EntryOut{}); status != fuse.ENOENT {
+			t.Errorf("Lookup of a name remote lacks = %v; want ENOENT", status)
+		}
+	}
+	if n := srv.lookups.Load() - lookups; n != 1 {
+		t.Errorf("remote asked %v times about a missing name; want 1", n)
+	}
+
+	online.Store(false)
+	lookups = srv.lookups.Load()
+	if status := raw.Lookup(nil, &header, "offline.txt", &fuse.EntryOut{}); status != fuse.ENOENT {
+		t.Errorf("offline Lookup of a remote-only file = %v; want ENOENT", status)
+	}
+	if n := srv.lookups.Load() - lookups; n != 0 {
+		t.Errorf("remote asked %v times while offline; want 0", n)
+	}
+}
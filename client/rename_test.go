@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Runs the local half of a rename of src over dst that remote then
+// refused, the way Rename rolls it back
+func renameRolledBack(t *testing.T, src, dst string) {
+	t.Helper()
+
+	info, err := os.Lstat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := keepReplaced(dst, info)
+	if err != nil {
+		t.Fatalf("keepReplaced = %v", err)
+	}
+	err = syscall.Rename(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = syscall.Rename(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreReplaced(dst, kept, info)
+}
+
+// Returns the names in dir left behind by keepReplaced
+func keptFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), REPLACED_PREFIX) {
+			kept = append(kept, entry.Name())
+		}
+	}
+	return kept
+}
+
+// The replaced file comes back as it was locally, including changes
+// remote never got, rather than as remote has it
+func TestRenameRollbackRestoresReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	err := os.WriteFile(src, []byte("source"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dst, []byte("unsynced changes"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renameRolledBack(t, src, dst)
+
+	got, _ := os.ReadFile(src)
+	if string(got) != "source" {
+		t.Errorf("src = %q; want %q", got, "source")
+	}
+	got, _ = os.ReadFile(dst)
+	if string(got) != "unsynced changes" {
+		t.Errorf("dst = %q; want %q", got, "unsynced changes")
+	}
+	info, err := os.Stat(dst)
+	if err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("dst mode = %v; want 0600", info.Mode().Perm())
+	}
+	if kept := keptFiles(t, dir); len(kept) != 0 {
+		t.Errorf("left behind %v", kept)
+	}
+}
+
+func TestRenameRollbackRestoresReplacedDir(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	err := os.Mkdir(src, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(dst, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renameRolledBack(t, src, dst)
+
+	info, err := os.Stat(dst)
+	if err != nil || !info.IsDir() {
+		t.Fatalf("dst = %v, %v; want a directory", info, err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("dst mode = %v; want 0700", info.Mode().Perm())
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("src missing after rollback; %v", err)
+	}
+}
+
+// Refuses every rename
+type refuseRenameServer struct {
+	proto.UnimplementedFuseServer
+}
+
+func (refuseRenameServer) Rename(ctx context.Context, req *proto.RenameRequest) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.PermissionDenied, "refused")
+}
+
+// Writes src and dst under realpath and renames src over dst through
+// the node tree
+func renameThroughMount(t *testing.T) fuse.Status {
+	t.Helper()
+	for name, contents := range map[string]string{"src": "source", "dst": "replaced"} {
+		err := os.WriteFile(filepath.Join(realpath, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	return raw.Rename(nil, &fuse.RenameIn{InHeader: header, Newdir: fuse.FUSE_ROOT_ID}, "src", "dst")
+}
+
+// A rename remote refuses fails and leaves both files as they were
+func TestRenameRefusedByRemote(t *testing.T) {
+	useTestQueue(t)
+	useTestRemote(t, refuseRenameServer{})
+	online.Store(true)
+
+	if status := renameThroughMount(t); status.Ok() {
+		t.Fatal("rename refused by remote succeeded")
+	}
+	for name, want := range map[string]string{"src": "source", "dst": "replaced"} {
+		got, err := os.ReadFile(filepath.Join(realpath, name))
+		if string(got) != want {
+			t.Errorf("%v = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if kept := keptFiles(t, realpath); len(kept) != 0 {
+		t.Errorf("left behind %v", kept)
+	}
+	if ops := loadQueue(); len(ops) != 0 {
+		t.Errorf("refused rename queued; %v", ops)
+	}
+}
+
+// Offline, the rename goes through locally and waits in the queue to
+// be replayed on remote
+func TestRenameQueuedOffline(t *testing.T) {
+	useTestQueue(t)
+
+	if status := renameThroughMount(t); !status.Ok() {
+		t.Fatalf("offline rename = %v", status)
+	}
+	got, _ := os.ReadFile(filepath.Join(realpath, "dst"))
+	if string(got) != "source" {
+		t.Errorf("dst = %q; want %q", got, "source")
+	}
+	ops := loadQueue()
+	if len(ops) != 1 || ops[0].Op != OP_RENAME || ops[0].Path != "/src" || ops[0].NewPath != "/dst" {
+		t.Errorf("queue = %+v; want the rename of /src to /dst", ops)
+	}
+}
+
+// Renaming a onto an existing b leaves b with a's contents and none
+// of the old b's inode behind
+func TestRenameOntoExistingFile(t *testing.T) {
+	useTestQueue(t)
+	useTestInodes(t)
+	root := &Node{path: realpath}
+	raw := fs.NewNodeFS(root, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	for name, contents := range map[string]string{"a": "from a", "b": "from b"} {
+		err := os.WriteFile(filepath.Join(realpath, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	replaced := fuse.EntryOut{}
+	if status := raw.Lookup(nil, &header, "b", &replaced); !status.Ok() {
+		t.Fatalf("Lookup b = %v", status)
+	}
+
+	if status := raw.Rename(nil, &fuse.RenameIn{InHeader: header, Newdir: fuse.FUSE_ROOT_ID}, "a", "b"); !status.Ok() {
+		t.Fatalf("rename = %v", status)
+	}
+	if got, _ := os.ReadFile(filepath.Join(realpath, "b")); string(got) != "from a" {
+		t.Errorf("b = %q; want %q", got, "from a")
+	}
+	if children := root.Children(); len(children) != 0 {
+		t.Errorf("tree keeps %v after rename; want the replaced b gone", children)
+	}
+
+	after := fuse.EntryOut{}
+	if status := raw.Lookup(nil, &header, "b", &after); !status.Ok() {
+		t.Fatalf("Lookup b = %v", status)
+	}
+	if after.NodeId == replaced.NodeId || after.Ino == replaced.Ino {
+		t.Errorf("b is node %v ino %v; want a new one in place of the replaced b", after.NodeId, after.Ino)
+	}
+	if children := root.Children(); len(children) != 1 {
+		t.Errorf("root has %v children; want 1", len(children))
+	}
+}
+
+// Through the kernel, b takes over the inode a had
+func TestRenameOntoExistingFileMounted(t *testing.T) {
+	useTestMount(t)
+	for name, contents := range map[string]string{"a": "from a", "b": "from b"} {
+		err := os.WriteFile(filepath.Join(mountpoint, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := os.Stat(filepath.Join(mountpoint, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(filepath.Join(mountpoint, "a"), filepath.Join(mountpoint, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(mountpoint, "b")); string(got) != "from a" {
+		t.Errorf("b = %q; want %q", got, "from a")
+	}
+	dst, err := os.Stat(filepath.Join(mountpoint, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(src, dst) {
+		t.Errorf("b is inode %v; want a's %v", dst.Sys().(*syscall.Stat_t).Ino, src.Sys().(*syscall.Stat_t).Ino)
+	}
+	entries, err := os.ReadDir(mountpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"b", "file"}; !slices.Equal(names, want) {
+		t.Errorf("mount lists %q after rename; want %q", names, want)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Tags every call to remote with a request id. Remote logs it, so a
+// failure logged here with its id can be found in the server's logs.
+// Remote leaves the id out of error messages to keep their details
+// intact
+
+// Keeps an id the caller already put on ctx
+func outgoingRequestId(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	ids := md.Get(lib.REQUEST_ID_KEY)
+	if len(ids) > 0 {
+		return ctx, ids[0]
+	}
+	id := lib.NewRequestId()
+	return metadata.AppendToOutgoingContext(ctx, lib.REQUEST_ID_KEY, id), id
+}
+
+func logFailedCall(method, id string, err error) {
+	if status.Code(err) == codes.NotFound {
+		// Lookups of missing names; expected and frequent
+		return
+	}
+	log.Printf("[GRPC] Request %v %v failed; %v\n", id, method, err)
+}
+
+func requestIdInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	ctx, id := outgoingRequestId(ctx)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		logFailedCall(method, id, err)
+	}
+	return err
+}
+
+func requestIdStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	ctx, id := outgoingRequestId(ctx)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		logFailedCall(method, id, err)
+	}
+	return stream, err
+}
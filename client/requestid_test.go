@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Records the request id each call arrives with. Rmdir fails
+type requestIdServer struct {
+	proto.UnimplementedFuseServer
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func (s *requestIdServer) record(ctx context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.ids = append(s.ids, strings.Join(md.Get(lib.REQUEST_ID_KEY), ","))
+	s.mu.Unlock()
+}
+
+func (s *requestIdServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.FileAttr, error) {
+	s.record(ctx)
+	return &proto.FileAttr{}, nil
+}
+
+func (s *requestIdServer) Rmdir(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
+	s.record(ctx)
+	return nil, status.Error(codes.FailedPrecondition, "directory not empty")
+}
+
+func (s *requestIdServer) ObserveFileChanges(req *emptypb.Empty, stream grpc.ServerStreamingServer[proto.FileEvent]) error {
+	s.record(stream.Context())
+	return nil
+}
+
+// Every call and stream reaches remote with an id of its own, or the
+// one the caller chose, and a failed call is logged with its id
+func TestRequestIdSentToRemote(t *testing.T) {
+	srv := &requestIdServer{}
+	useTestRemote(t, srv)
+	output := bytes.Buffer{}
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ctx := context.Background()
+	for range 2 {
+		_, err := grpcClient.Getattr(ctx, &proto.DirEntry{Path: "/file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream, err := grpcClient.ObserveFileChanges(ctx, &emptypb.Empty{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err == nil {
+		t.Fatal("stream sent an event")
+	}
+	chosen := metadata.AppendToOutgoingContext(ctx, lib.REQUEST_ID_KEY, "chosen-id")
+	_, err = grpcClient.Rmdir(chosen, &proto.DirEntry{Path: "/dir"})
+	if err == nil {
+		t.Fatal("Rmdir succeeded")
+	}
+
+	srv.mu.Lock()
+	ids := srv.ids
+	srv.mu.Unlock()
+	if len(ids) != 4 {
+		t.Fatalf("remote got %v calls; want 4", len(ids))
+	}
+	for _, id := range ids[:3] {
+		if len(id) != 16 || strings.Contains(id, ",") {
+			t.Errorf("request id %q; want one generated id", id)
+		}
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("two calls share request id %v", ids[0])
+	}
+	if ids[3] != "chosen-id" {
+		t.Errorf("request id %q; want the caller's chosen-id", ids[3])
+	}
+	if !strings.Contains(output.String(), "chosen-id") {
+		t.Errorf("failed call logged as %q; want its request id", output.String())
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The resync command rebuilds the local copy or remote after the two
+// drifted apart. It compares the whole tree against the remote
+// manifest and then, depending on -mode:
+//
+//	pull    makes local match remote; local changes are lost
+//	push    makes remote match local; remote changes are lost
+//	mirror  carries changes made on either side since the last
+//	        resync over to the other, deletes included. Files
+//	        changed on both sides keep the remote version; the local
+//	        one is kept next to it as a conflict copy
+//
+// What both sides looked like after the last successful resync is
+// kept on disk; mirror compares each side against it to tell which
+// one changed. Without it, any difference counts as a conflict
+const (
+	RESYNC_PULL   = "pull"
+	RESYNC_PUSH   = "push"
+	RESYNC_MIRROR = "mirror"
+)
+
+var (
+	resyncMode string
+	assumeYes  bool
+)
+
+type resyncPlan struct {
+	mkdirLocal   []string
+	mkdirRemote  []string
+	download     []*proto.ManifestEntry
+	upload       []string
+	conflicts    []*proto.ManifestEntry
+	deleteLocal  []string
+	deleteRemote []string
+}
+
+// Reports whether carrying out the plan throws away changes
+func (p *resyncPlan) destructive() bool {
+	return len(p.deleteLocal) > 0 || len(p.deleteRemote) > 0 ||
+		(resyncMode != RESYNC_MIRROR && (len(p.download) > 0 || len(p.upload) > 0))
+}
+
+type localEntry struct {
+	isDir bool
+}
+
+// An entry as both sides had it after the last resync
+type syncedEntry struct {
+	IsDir bool   `json:"is_dir,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+func runResync() {
+	if resyncMode != RESYNC_PULL && resyncMode != RESYNC_PUSH && resyncMode != RESYNC_MIRROR {
+		log.Fatalf("Invalid -mode %q; expected %v, %v or %v\n", resyncMode, RESYNC_PULL, RESYNC_PUSH, RESYNC_MIRROR)
+	}
+	if !dirExists(realpath) {
+		log.Fatalln("-realpath directory does not exist")
+	}
+
+	// The mount would see files change under it
+	_, err := controlClient().Get("http://fusion/status")
+	if err == nil {
+		log.Fatalln("Client is running; stop it before running resync")
+	}
+
+	err = preflight(remote)
+	if err != nil {
+		log.Fatalf("Pre-flight check failed; %v\n", err)
+	}
+	err = authenticate()
+	if err != nil {
+		log.Fatalf("Error authenticating with remote; %v\n", err)
+	}
+	if endToEnd {
+		err := setupE2E(true)
+		if err != nil {
+			log.Fatalf("Error setting up end-to-end encryption; %v\n", err)
+		}
+	}
+
+	ctx := NewAuthenticatedCtx(context.Background())
+	remoteEntries, err := remoteManifest(ctx)
+	if err != nil {
+		log.Fatalf("Error fetching remote manifest; %v\n", err)
+	}
+	localEntries, err := localManifest()
+	if err != nil {
+		log.Fatalf("Error listing %v; %v\n", realpath, err)
+	}
+
+	plan := planResync(localEntries, remoteEntries, loadResyncBase())
+	printPlan(plan)
+
+	if plan.destructive() && !assumeYes && !confirm("Continue?") {
+		fmt.Println("Aborted")
+		os.Exit(1)
+	}
+
+	failed := applyResync(ctx, plan)
+	if failed > 0 {
+		// The previous base still tells apart what changed since
+		log.Fatalf("Resync finished with %v errors\n", failed)
+	}
+
+	remoteEntries, err = remoteManifest(ctx)
+	if err == nil {
+		err = saveResyncBase(remoteEntries)
+	}
+	if err != nil {
+		log.Printf("[SYNC] Error saving resync state; the next mirror may report conflicts; %v\n", err)
+	}
+	fmt.Println("Resync complete")
+}
+
+func resyncBasePath() string {
+	digest := md5.Sum([]byte(realpath))
+	return filepath.Join(lib.ProjectDir, "resync", hex.EncodeToString(digest[:])+".json")
+}
+
+// Returns what both sides looked like after the last successful
+// resync, keyed by relative path. Empty if there was none
+func loadResyncBase() map[string]syncedEntry {
+	base := make(map[string]syncedEntry)
+	data, err := os.ReadFile(resyncBasePath())
+	if err != nil {
+		return base
+	}
+	err = json.Unmarshal(data, &base)
+	if err != nil {
+		log.Printf("[SYNC] Error loading resync state; %v\n", err)
+		return make(map[string]syncedEntry)
+	}
+	return base
+}
+
+// Records remote's entries as what both sides have now that they match
+func saveResyncBase(remote map[string]*proto.ManifestEntry) error {
+	base := make(map[string]syncedEntry, len(remote))
+	for path, entry := range remote {
+		mode := os.FileMode(entry.Mode)
+		if mode.IsDir() {
+			base[path] = syncedEntry{IsDir: true}
+		} else if mode.IsRegular() {
+			base[path] = syncedEntry{Hash: entry.Hash}
+		}
+	}
+
+	data, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+	path := resyncBasePath()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Returns every entry on remote keyed by its cleaned relative path
+func remoteManifest(ctx context.Context) (map[string]*proto.ManifestEntry, error) {
+	if !remoteSupports(lib.FEATURE_MANIFEST) {
+		// Without hashes there's no telling which side changed
+		return nil, fmt.Errorf("remote does not support manifests; resync needs a newer server")
+	}
+
+	stream, err := grpcClient.GetManifest(ctx, &proto.ManifestRequest{
+		Path:      "",
+		Recursive: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*proto.ManifestEntry)
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[filepath.Clean("/"+entry.Path)] = entry
+	}
+}
+
+// Returns the directories and regular files under realpath keyed by
+// relative path
+func localManifest() (map[string]localEntry, error) {
+	entries := make(map[string]localEntry)
+	err := filepath.WalkDir(realpath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == realpath {
+			return nil
+		}
+
+		rel := filepath.Clean("/" + relativePath(path))
+		switch {
+		case d.IsDir():
+			entries[rel] = localEntry{isDir: true}
+		case d.Type().IsRegular():
+			entries[rel] = localEntry{}
+		default:
+			log.Printf("[SYNC] Skipping %v; only files and directories are resynced\n", rel)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Works out what resyncMode has to do to bring local and remote
+// together. base is what both had after the last resync; only mirror
+// looks at it
+func planResync(local map[string]localEntry, remote map[string]*proto.ManifestEntry, base map[string]syncedEntry) *resyncPlan {
+	plan := &resyncPlan{}
+
+	// Reports whether a side still has path as it was at the last
+	// resync
+	localUnchanged := func(path string, entry localEntry) bool {
+		synced, ok := base[path]
+		if !ok || synced.IsDir != entry.isDir {
+			return false
+		}
+		if entry.isDir {
+			return true
+		}
+		hash, err := localFileHash(localPath(path))
+		return err == nil && hash == synced.Hash
+	}
+	remoteUnchanged := func(path string, entry *proto.ManifestEntry) bool {
+		synced, ok := base[path]
+		if !ok || synced.IsDir != os.FileMode(entry.Mode).IsDir() {
+			return false
+		}
+		return synced.IsDir || synced.Hash == entry.Hash
+	}
+
+	pullEntry := func(path string, entry *proto.ManifestEntry) {
+		if os.FileMode(entry.Mode).IsDir() {
+			plan.mkdirLocal = append(plan.mkdirLocal, path)
+		} else if os.FileMode(entry.Mode).IsRegular() {
+			plan.download = append(plan.download, entry)
+		}
+	}
+	pushEntry := func(path string, entry localEntry) {
+		if entry.isDir {
+			plan.mkdirRemote = append(plan.mkdirRemote, path)
+		} else {
+			plan.upload = append(plan.upload, path)
+		}
+	}
+
+	for path, entry := range remote {
+		localEntry, ok := local[path]
+		if !ok {
+			// Deleted locally since the last resync
+			deleted := resyncMode == RESYNC_MIRROR && remoteUnchanged(path, entry)
+			if resyncMode == RESYNC_PUSH || deleted {
+				plan.deleteRemote = append(plan.deleteRemote, path)
+			} else {
+				pullEntry(path, entry)
+			}
+			continue
+		}
+
+		remoteIsDir := os.FileMode(entry.Mode).IsDir()
+		if localEntry.isDir && remoteIsDir {
+			continue
+		}
+		if !localEntry.isDir && !remoteIsDir {
+			hash, err := localFileHash(localPath(path))
+			if err == nil && hash == entry.Hash {
+				continue
+			}
+		}
+
+		switch resyncMode {
+		case RESYNC_PULL:
+			if localEntry.isDir != remoteIsDir {
+				plan.deleteLocal = append(plan.deleteLocal, path)
+			}
+			pullEntry(path, entry)
+		case RESYNC_PUSH:
+			if localEntry.isDir != remoteIsDir {
+				plan.deleteRemote = append(plan.deleteRemote, path)
+			}
+			pushEntry(path, localEntry)
+		case RESYNC_MIRROR:
+			switch {
+			case localEntry.isDir != remoteIsDir:
+				log.Printf("[SYNC] Skipping %v; it is a file on one side and a directory on the other\n", path)
+			case localUnchanged(path, localEntry):
+				pullEntry(path, entry)
+			case remoteUnchanged(path, entry):
+				pushEntry(path, localEntry)
+			default:
+				plan.conflicts = append(plan.conflicts, entry)
+			}
+		}
+	}
+
+	for path, entry := range local {
+		if _, ok := remote[path]; ok {
+			continue
+		}
+		// Deleted on remote since the last resync
+		deleted := resyncMode == RESYNC_MIRROR && localUnchanged(path, entry)
+		if resyncMode == RESYNC_PULL || deleted {
+			plan.deleteLocal = append(plan.deleteLocal, path)
+		} else {
+			pushEntry(path, entry)
+		}
+	}
+
+	if resyncMode == RESYNC_MIRROR {
+		keepParents(plan)
+	}
+
+	// Parents are created before their children and deleted after
+	sort.Strings(plan.mkdirLocal)
+	sort.Strings(plan.mkdirRemote)
+	sort.Strings(plan.upload)
+	sort.Sort(sort.Reverse(sort.StringSlice(plan.deleteLocal)))
+	sort.Sort(sort.Reverse(sort.StringSlice(plan.deleteRemote)))
+	return plan
+}
+
+// A directory one side deleted but the other added to since is kept;
+// it is created again where it was deleted instead
+func keepParents(plan *resyncPlan) {
+	keep := func(deletes []string, mkdirs *[]string, paths []string) []string {
+		for _, path := range paths {
+			for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+				i := slices.Index(deletes, dir)
+				if i == -1 {
+					continue
+				}
+				deletes = slices.Delete(deletes, i, i+1)
+				*mkdirs = append(*mkdirs, dir)
+			}
+		}
+		return deletes
+	}
+
+	pulled := slices.Clone(plan.mkdirLocal)
+	for _, entry := range append(plan.download, plan.conflicts...) {
+		pulled = append(pulled, filepath.Clean("/"+entry.Path))
+	}
+	plan.deleteRemote = keep(plan.deleteRemote, &plan.mkdirLocal, pulled)
+	pushed := append(slices.Clone(plan.mkdirRemote), plan.upload...)
+	plan.deleteLocal = keep(plan.deleteLocal, &plan.mkdirRemote, pushed)
+}
+
+func printPlan(plan *resyncPlan) {
+	fmt.Printf("Resync (%v):\n", resyncMode)
+	fmt.Printf("  directories to create locally:   %v\n", len(plan.mkdirLocal))
+	fmt.Printf("  directories to create on remote: %v\n", len(plan.mkdirRemote))
+	fmt.Printf("  files to download:               %v\n", len(plan.download))
+	fmt.Printf("  files to upload:                 %v\n", len(plan.upload))
+	fmt.Printf("  conflicts to keep copies of:     %v\n", len(plan.conflicts))
+	fmt.Printf("  local entries to delete:         %v\n", len(plan.deleteLocal))
+	fmt.Printf("  remote entries to delete:        %v\n", len(plan.deleteRemote))
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%v [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Carries out plan. Returns the number of actions that failed
+func applyResync(ctx context.Context, plan *resyncPlan) int {
+	failed := 0
+	report := func(action, path string, err error) {
+		if err != nil {
+			failed++
+			log.Printf("[SYNC] Error trying to %v %v; %v\n", action, path, err)
+		}
+	}
+
+	for _, path := range plan.deleteLocal {
+		report("delete local", path, os.RemoveAll(localPath(path)))
+	}
+	for _, path := range plan.deleteRemote {
+		report("delete remote", path, deleteRemote(ctx, path))
+	}
+
+	for _, path := range plan.mkdirLocal {
+		report("create local directory", path, os.MkdirAll(localPath(path), 0755))
+	}
+	for _, path := range plan.mkdirRemote {
+		_, err := grpcClient.Mkdir(ctx, &proto.MkdirRequest{Path: path, Mode: 0755})
+		if isAlreadyExists(err) {
+			err = nil
+		}
+		report("create remote directory", path, err)
+	}
+
+	for _, path := range plan.upload {
+		report("upload", path, pushFile(ctx, path))
+	}
+	for _, entry := range plan.conflicts {
+		report("keep conflict copy of", entry.Path, keepConflictCopy(ctx, entry))
+	}
+	for _, entry := range append(plan.download, plan.conflicts...) {
+		err := downloadFile(&proto.DirEntry{Path: entry.Path, Mode: entry.Mode})
+		report("download", entry.Path, err)
+	}
+	return failed
+}
+
+func deleteRemote(ctx context.Context, path string) error {
+	_, err := grpcClient.Unlink(ctx, &proto.DirEntry{Path: path})
+	if status.Code(err) == codes.FailedPrecondition {
+		// A directory; its children were deleted before it
+		_, err = grpcClient.Rmdir(ctx, &proto.DirEntry{Path: path})
+	}
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+// Creates remote file path if needed and sends it the local contents
+func pushFile(ctx context.Context, path string) error {
+	_, err := grpcClient.Create(ctx, &proto.CreateRequest{
+		Path:  path,
+		Flags: uint32(os.O_CREATE | os.O_WRONLY),
+		Mode:  0644,
+	})
+	if err != nil && !isAlreadyExists(err) {
+		return err
+	}
+	return uploadLocal(ctx, path)
+}
+
+// Moves the local version of a file changed on both sides aside and
+// uploads it under its new name. The remote version is downloaded
+// in its place afterwards
+func keepConflictCopy(ctx context.Context, entry *proto.ManifestEntry) error {
+	ext := filepath.Ext(entry.Path)
+	stamp := time.Now().Format("2006-01-02 150405")
+	copyPath := fmt.Sprintf("%v (conflict %v)%v", strings.TrimSuffix(entry.Path, ext), stamp, ext)
+
+	err := os.Rename(localPath(entry.Path), localPath(copyPath))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  kept local version of %v as %v\n", entry.Path, copyPath)
+	return pushFile(ctx, copyPath)
+}
+
+func isAlreadyExists(err error) bool {
+	return lib.StatusErrno(err) == syscall.EEXIST
+}
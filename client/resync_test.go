@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+func hashOf(contents string) string {
+	digest := md5.Sum([]byte(contents))
+	return hex.EncodeToString(digest[:])
+}
+
+// Lays out local and remote trees for a mirror resync. Local files
+// are written under realpath; remote files and the base are given by
+// their contents. Directories end in "/"
+func mirrorFixture(t *testing.T, local, remote, base map[string]string) *resyncPlan {
+	t.Helper()
+	return resyncFixture(t, RESYNC_MIRROR, local, remote, base)
+}
+
+// Like mirrorFixture for a resync in mode
+func resyncFixture(t *testing.T, mode string, local, remote, base map[string]string) *resyncPlan {
+	t.Helper()
+	oldDir, oldRealpath, oldMode := lib.ProjectDir, realpath, resyncMode
+	lib.ProjectDir, realpath, resyncMode = t.TempDir(), t.TempDir(), mode
+	t.Cleanup(func() {
+		lib.ProjectDir, realpath, resyncMode = oldDir, oldRealpath, oldMode
+	})
+
+	for path, contents := range local {
+		var err error
+		if path[len(path)-1] == '/' {
+			err = os.MkdirAll(localPath(path), 0755)
+		} else {
+			err = os.MkdirAll(filepath.Dir(localPath(path)), 0755)
+			if err == nil {
+				err = os.WriteFile(localPath(path), []byte(contents), 0644)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	localEntries, err := localManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteEntries := make(map[string]*proto.ManifestEntry)
+	for path, contents := range remote {
+		entry := &proto.ManifestEntry{Path: filepath.Clean(path), Mode: 0644, Hash: hashOf(contents)}
+		if path[len(path)-1] == '/' {
+			entry = &proto.ManifestEntry{Path: filepath.Clean(path), Mode: uint32(os.ModeDir | 0755)}
+		}
+		remoteEntries[entry.Path] = entry
+	}
+	baseEntries := make(map[string]syncedEntry)
+	for path, contents := range base {
+		if path[len(path)-1] == '/' {
+			baseEntries[filepath.Clean(path)] = syncedEntry{IsDir: true}
+		} else {
+			baseEntries[path] = syncedEntry{Hash: hashOf(contents)}
+		}
+	}
+	return planResync(localEntries, remoteEntries, baseEntries)
+}
+
+func entryPaths(entries []*proto.ManifestEntry) []string {
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func checkPaths(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if got == nil {
+		got = []string{}
+	}
+	if want == nil {
+		want = []string{}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("%v = %q; want %q", what, got, want)
+	}
+}
+
+// Each side's changes since the last resync go to the other side;
+// only files changed on both are conflicts
+func TestMirrorComparesAgainstBase(t *testing.T) {
+	base := map[string]string{
+		"/same":        "same",
+		"/local-edit":  "old",
+		"/remote-edit": "old",
+		"/both-edit":   "old",
+		"/local-gone":  "old",
+		"/remote-gone": "old",
+	}
+	local := map[string]string{
+		"/same":        "same",
+		"/local-edit":  "new",
+		"/remote-edit": "old",
+		"/both-edit":   "local",
+		"/remote-gone": "old",
+		"/local-new":   "new",
+	}
+	remote := map[string]string{
+		"/same":        "same",
+		"/local-edit":  "old",
+		"/remote-edit": "new",
+		"/both-edit":   "remote",
+		"/local-gone":  "old",
+		"/remote-new":  "new",
+	}
+	plan := mirrorFixture(t, local, remote, base)
+
+	checkPaths(t, "upload", plan.upload, "/local-edit", "/local-new")
+	checkPaths(t, "download", entryPaths(plan.download), "/remote-edit", "/remote-new")
+	checkPaths(t, "conflicts", entryPaths(plan.conflicts), "/both-edit")
+	checkPaths(t, "deleteRemote", plan.deleteRemote, "/local-gone")
+	checkPaths(t, "deleteLocal", plan.deleteLocal, "/remote-gone")
+}
+
+// Without a base there's no telling which side changed
+func TestMirrorWithoutBase(t *testing.T) {
+	local := map[string]string{"/edited": "local", "/local-only": "new"}
+	remote := map[string]string{"/edited": "remote", "/remote-only": "new"}
+	plan := mirrorFixture(t, local, remote, nil)
+
+	checkPaths(t, "upload", plan.upload, "/local-only")
+	checkPaths(t, "download", entryPaths(plan.download), "/remote-only")
+	checkPaths(t, "conflicts", entryPaths(plan.conflicts), "/edited")
+	checkPaths(t, "deleteRemote", plan.deleteRemote)
+	checkPaths(t, "deleteLocal", plan.deleteLocal)
+}
+
+// A directory deleted locally that gained a file on remote is created
+// again rather than deleted along with the new file
+func TestMirrorKeepsDirWithNewFiles(t *testing.T) {
+	base := map[string]string{"/dir/": "", "/dir/old": "old"}
+	remote := map[string]string{"/dir/": "", "/dir/old": "old", "/dir/new": "new"}
+	plan := mirrorFixture(t, nil, remote, base)
+
+	checkPaths(t, "deleteRemote", plan.deleteRemote, "/dir/old")
+	checkPaths(t, "mkdirLocal", plan.mkdirLocal, "/dir")
+	checkPaths(t, "download", entryPaths(plan.download), "/dir/new")
+}
+
+// Local and remote trees that differ every way they can
+var (
+	divergentLocal = map[string]string{
+		"/same":         "same",
+		"/edited":       "local",
+		"/local-only":   "new",
+		"/local-dir/":   "",
+		"/file-or-dir/": "",
+	}
+	divergentRemote = map[string]string{
+		"/same":        "same",
+		"/edited":      "remote",
+		"/remote-only": "new",
+		"/remote-dir/": "",
+		"/file-or-dir": "file",
+	}
+)
+
+// Pull makes local match remote, throwing away what only local has
+func TestPullMakesLocalMatchRemote(t *testing.T) {
+	plan := resyncFixture(t, RESYNC_PULL, divergentLocal, divergentRemote, nil)
+
+	checkPaths(t, "download", entryPaths(plan.download), "/edited", "/file-or-dir", "/remote-only")
+	checkPaths(t, "mkdirLocal", plan.mkdirLocal, "/remote-dir")
+	checkPaths(t, "deleteLocal", plan.deleteLocal, "/file-or-dir", "/local-dir", "/local-only")
+	checkPaths(t, "upload", plan.upload)
+	checkPaths(t, "mkdirRemote", plan.mkdirRemote)
+	checkPaths(t, "deleteRemote", plan.deleteRemote)
+	checkPaths(t, "conflicts", entryPaths(plan.conflicts))
+	if !plan.destructive() {
+		t.Error("pull overwriting local files not destructive")
+	}
+}
+
+// Push makes remote match local, throwing away what only remote has
+func TestPushMakesRemoteMatchLocal(t *testing.T) {
+	plan := resyncFixture(t, RESYNC_PUSH, divergentLocal, divergentRemote, nil)
+
+	checkPaths(t, "upload", plan.upload, "/edited", "/local-only")
+	checkPaths(t, "mkdirRemote", plan.mkdirRemote, "/file-or-dir", "/local-dir")
+	checkPaths(t, "deleteRemote", plan.deleteRemote, "/file-or-dir", "/remote-dir", "/remote-only")
+	checkPaths(t, "download", entryPaths(plan.download))
+	checkPaths(t, "mkdirLocal", plan.mkdirLocal)
+	checkPaths(t, "deleteLocal", plan.deleteLocal)
+	checkPaths(t, "conflicts", entryPaths(plan.conflicts))
+	if !plan.destructive() {
+		t.Error("push overwriting remote files not destructive")
+	}
+}
+
+// Mirror keeps both sides' files; without a base it deletes nothing
+func TestMirrorOfDivergentTrees(t *testing.T) {
+	plan := mirrorFixture(t, divergentLocal, divergentRemote, nil)
+
+	checkPaths(t, "upload", plan.upload, "/local-only")
+	checkPaths(t, "mkdirRemote", plan.mkdirRemote, "/local-dir")
+	checkPaths(t, "download", entryPaths(plan.download), "/remote-only")
+	checkPaths(t, "mkdirLocal", plan.mkdirLocal, "/remote-dir")
+	checkPaths(t, "conflicts", entryPaths(plan.conflicts), "/edited")
+	checkPaths(t, "deleteLocal", plan.deleteLocal)
+	checkPaths(t, "deleteRemote", plan.deleteRemote)
+	if plan.destructive() {
+		t.Error("mirror that deletes nothing is destructive")
+	}
+}
+
+func TestResyncBaseRoundTrip(t *testing.T) {
+	mirrorFixture(t, nil, nil, nil)
+	err := saveResyncBase(map[string]*proto.ManifestEntry{
+		"/dir":      {Path: "dir", Mode: uint32(os.ModeDir | 0755)},
+		"/dir/file": {Path: "dir/file", Mode: 0644, Hash: hashOf("contents")},
+		"/dir/link": {Path: "dir/link", Mode: uint32(os.ModeSymlink | 0777)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := loadResyncBase()
+	want := map[string]syncedEntry{
+		"/dir":      {IsDir: true},
+		"/dir/file": {Hash: hashOf("contents")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("base = %v; want %v", got, want)
+	}
+	for path, entry := range want {
+		if got[path] != entry {
+			t.Errorf("base[%v] = %v; want %v", path, got[path], entry)
+		}
+	}
+}
+
+// Transfers together keep to the limit, and a cancelled one stops
+// waiting
+func TestLimiterKeepsToRate(t *testing.T) {
+	l := &limiter{}
+	ctx := context.Background()
+	start := time.Now()
+	for range 4 {
+		err := l.wait(ctx, 256, 10) // 10 KiB/s
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("moved 1 KiB at 10 KiB/s in %v; want about 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err := l.wait(ctx, 1024*1024, 10)
+	if err != context.Canceled {
+		t.Errorf("wait after cancel = %v; want %v", err, context.Canceled)
+	}
+
+	if err := l.wait(context.Background(), 1024*1024, 0); err != nil {
+		t.Errorf("unlimited wait = %v", err)
+	}
+}
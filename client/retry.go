@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Unary calls to remote get a deadline and are retried after
+// transient failures. Streams are left alone; the observer reconnects
+// on its own and downloads resume where they stopped.
+//
+// Only calls that are safe to repeat are retried: those that change
+// nothing and those carrying an idempotency key, which remote applies
+// once however many times they arrive. Refusals during maintenance
+// aren't retried either; sendOrQueue queues those changes instead
+var (
+	rpcTimeout           time.Duration
+	rpcMethodTimeoutFlag string
+	rpcAttempts          int
+	rpcBackoff           time.Duration
+
+	// Method name, eg. Write -> deadline; see -rpc-method-timeouts
+	rpcMethodTimeouts = map[string]time.Duration{}
+)
+
+// Longest wait between two attempts
+const MAX_RPC_BACKOFF = 5 * time.Second
+
+// Calls that change nothing on remote
+var readOnlyMethods = map[string]bool{
+	proto.Fuse_Auth_FullMethodName:         true,
+	proto.Fuse_Hello_FullMethodName:        true,
+	proto.Fuse_Lookup_FullMethodName:       true,
+	proto.Fuse_ReadDirAll_FullMethodName:   true,
+	proto.Fuse_Getattr_FullMethodName:      true,
+	proto.Fuse_ReadAll_FullMethodName:      true,
+	proto.Fuse_Statfs_FullMethodName:       true,
+	proto.Fuse_ListVersions_FullMethodName: true,
+	proto.Fuse_GetProfile_FullMethodName:   true,
+}
+
+// Parses -rpc-method-timeouts, a comma separated list of method=duration
+// pairs, eg. Write=2m,ReadAll=5m
+func parseMethodTimeouts(value string) (map[string]time.Duration, error) {
+	known := make(map[string]bool)
+	for _, method := range proto.Fuse_ServiceDesc.Methods {
+		known[method.MethodName] = true
+	}
+
+	timeouts := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, duration, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected method=duration, got %q", pair)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown method %q", name)
+		}
+		timeout, err := time.ParseDuration(duration)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid duration %q for %v", duration, name)
+		}
+		timeouts[name] = timeout
+	}
+	return timeouts, nil
+}
+
+// Deadline of a single call to method, the full method name. 0 means
+// none
+func methodTimeout(method string) time.Duration {
+	if timeout, ok := rpcMethodTimeouts[path.Base(method)]; ok {
+		return timeout
+	}
+	return rpcTimeout
+}
+
+// Reports whether method can be sent again without it taking effect
+// twice
+func retrySafe(ctx context.Context, method string) bool {
+	if readOnlyMethods[method] {
+		return true
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	keys := md.Get(lib.IDEMPOTENCY_KEY)
+	return len(keys) > 0 && keys[0] != ""
+}
+
+func retryableError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return !lib.IsMaintenance(err)
+	case codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits before attempt, counted from 1 for the first retry. Backoff
+// doubles each time, with jitter so clients cut off together don't
+// come back together. Reports false if ctx ended first
+func waitBackoff(ctx context.Context, attempt int) bool {
+	delay := rpcBackoff << (attempt - 1)
+	if delay > MAX_RPC_BACKOFF || delay <= 0 {
+		delay = MAX_RPC_BACKOFF
+	}
+	delay = delay/2 + rand.N(delay/2+1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Applies the method's deadline to calls that don't have one and
+// retries transient failures of calls that are safe to repeat. The
+// deadline covers all attempts
+func retryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout := methodTimeout(method); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+
+	attempts := rpcAttempts
+	if attempts < 1 || !retrySafe(ctx, method) {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil || attempt >= attempts || !retryableError(err) {
+			return err
+		}
+		log.Printf("[GRPC] %v failed; retrying (attempt %v of %v); %v\n", path.Base(method), attempt+1, attempts, err)
+		if !waitBackoff(ctx, attempt) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Fails calls with errs in turn, then succeeds
+type flakyServer struct {
+	proto.UnimplementedFuseServer
+	calls atomic.Int32
+	errs  []error
+}
+
+func (s *flakyServer) next() error {
+	n := int(s.calls.Add(1))
+	if n <= len(s.errs) {
+		return s.errs[n-1]
+	}
+	return nil
+}
+
+func (s *flakyServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.FileAttr, error) {
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	return &proto.FileAttr{}, nil
+}
+
+func (s *flakyServer) Unlink(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func unavailable() error {
+	return status.Error(codes.Unavailable, "connection refused")
+}
+
+func TestRetryReadOnlyCall(t *testing.T) {
+	srv := &flakyServer{errs: []error{unavailable(), unavailable()}}
+	client := newTestClient(t, srv)
+
+	_, err := client.Getattr(context.Background(), &proto.DirEntry{Path: "/file"})
+	if err != nil {
+		t.Fatalf("Getattr = %v; want it to succeed on the third attempt", err)
+	}
+	if calls := srv.calls.Load(); calls != 3 {
+		t.Errorf("server got %v calls; want 3", calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv := &flakyServer{errs: []error{unavailable(), unavailable(), unavailable(), unavailable()}}
+	client := newTestClient(t, srv)
+
+	_, err := client.Getattr(context.Background(), &proto.DirEntry{Path: "/file"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Getattr = %v; want Unavailable", err)
+	}
+	if calls := srv.calls.Load(); calls != int32(rpcAttempts) {
+		t.Errorf("server got %v calls; want %v", calls, rpcAttempts)
+	}
+}
+
+func TestRetryNotRetried(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"maintenance", lib.MaintenanceError()},
+		{"not found", status.Error(codes.NotFound, "no such file")},
+		{"internal", status.Error(codes.Internal, "broken")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &flakyServer{errs: []error{test.err}}
+			client := newTestClient(t, srv)
+
+			_, err := client.Getattr(context.Background(), &proto.DirEntry{Path: "/file"})
+			if status.Code(err) != status.Code(test.err) {
+				t.Fatalf("Getattr = %v; want %v", err, test.err)
+			}
+			if calls := srv.calls.Load(); calls != 1 {
+				t.Errorf("server got %v calls; want 1", calls)
+			}
+		})
+	}
+}
+
+func TestRetryNeedsIdempotencyKey(t *testing.T) {
+	srv := &flakyServer{errs: []error{unavailable()}}
+	client := newTestClient(t, srv)
+
+	_, err := client.Unlink(context.Background(), &proto.DirEntry{Path: "/file"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Unlink without a key = %v; want Unavailable", err)
+	}
+
+	srv = &flakyServer{errs: []error{unavailable()}}
+	client = newTestClient(t, srv)
+	ctx := withIdempotencyKey(context.Background(), newIdempotencyKey())
+	_, err = client.Unlink(ctx, &proto.DirEntry{Path: "/file"})
+	if err != nil {
+		t.Fatalf("Unlink with a key = %v; want it to be retried", err)
+	}
+	if calls := srv.calls.Load(); calls != 2 {
+		t.Errorf("server got %v calls; want 2", calls)
+	}
+}
+
+// Never answers Getattr
+type hungServer struct {
+	proto.UnimplementedFuseServer
+	calls atomic.Int32
+}
+
+func (s *hungServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.FileAttr, error) {
+	s.calls.Add(1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// A call remote never answers ends at its method's deadline and isn't
+// retried
+func TestHungCallTimesOut(t *testing.T) {
+	old := rpcMethodTimeouts
+	rpcMethodTimeouts = map[string]time.Duration{"Getattr": 50 * time.Millisecond}
+	t.Cleanup(func() { rpcMethodTimeouts = old })
+	srv := &hungServer{}
+	client := newTestClient(t, srv)
+
+	start := time.Now()
+	_, err := client.Getattr(context.Background(), &proto.DirEntry{Path: "/file"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Getattr of a hung server = %v; want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > rpcTimeout/2 {
+		t.Errorf("Getattr took %v; want about the 50ms Getattr deadline", elapsed)
+	}
+	if calls := srv.calls.Load(); calls != 1 {
+		t.Errorf("server got %v calls; want 1", calls)
+	}
+}
+
+func TestParseMethodTimeouts(t *testing.T) {
+	timeouts, err := parseMethodTimeouts("Write=2m, ReadAll=5m,")
+	if err != nil {
+		t.Fatalf("parseMethodTimeouts = %v", err)
+	}
+	if timeouts["Write"].Minutes() != 2 || timeouts["ReadAll"].Minutes() != 5 {
+		t.Errorf("timeouts = %v", timeouts)
+	}
+
+	for _, bad := range []string{"Write", "Nope=1s", "Write=soon", "Write=-1s"} {
+		if _, err := parseMethodTimeouts(bad); err == nil {
+			t.Errorf("parseMethodTimeouts(%q) succeeded; want an error", bad)
+		}
+	}
+}
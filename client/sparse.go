@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Downloads write runs of zeros as holes instead of as data so sparse
+// remote files stay sparse locally, and SEEK_DATA/SEEK_HOLE report
+// real boundaries
+
+// Zero runs shorter than this are written out; punching tiny holes
+// costs more than it saves
+const SPARSE_BLOCK_SIZE = 4096
+
+// Writes data at off of file, punching holes where whole blocks of
+// data are zero. Holes are punched rather than skipped since the
+// range may still hold an older version of the file
+func writeSparse(file *os.File, data []byte, off int64) (int, error) {
+	n := len(data)
+
+	for len(data) > 0 {
+		// Work in blocks aligned to the file, not to the chunk
+		size := SPARSE_BLOCK_SIZE - int(off%SPARSE_BLOCK_SIZE)
+		size = min(size, len(data))
+		block := data[:size]
+
+		if size == SPARSE_BLOCK_SIZE && isZero(block) {
+			err := unix.Fallocate(
+				int(file.Fd()),
+				unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE,
+				off,
+				int64(size),
+			)
+			if err != nil {
+				// Filesystem can't punch holes; write the zeros
+				_, err = file.WriteAt(block, off)
+			}
+			if err != nil {
+				return n - len(data), err
+			}
+		} else {
+			_, err := file.WriteAt(block, off)
+			if err != nil {
+				return n - len(data), err
+			}
+		}
+
+		data = data[size:]
+		off += int64(size)
+	}
+	return n, nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes downloaded chunks to the local copy of a file
+type sparseWriter struct {
+	file *os.File
+}
+
+func (w sparseWriter) WriteAt(data []byte, off int64) (int, error) {
+	return writeSparse(w.file, data, off)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"golang.org/x/sys/unix"
+)
+
+// A download of data, three zero blocks and more data leaves a hole
+// where the zeros were, and Lseek reports where it starts and ends
+func TestSeekDataAndHoles(t *testing.T) {
+	useTestQueue(t)
+	data := bytes.Repeat([]byte("a"), SPARSE_BLOCK_SIZE)
+	contents := append(append(bytes.Clone(data), make([]byte, 3*SPARSE_BLOCK_SIZE)...), data...)
+	size := uint64(len(contents))
+
+	file, err := os.Create(filepath.Join(realpath, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In chunks, the way downloads arrive
+	w := sparseWriter{file: file}
+	for off := 0; off < len(contents); off += lib.CHUNK_SIZE {
+		end := min(off+lib.CHUNK_SIZE, len(contents))
+		_, err = w.WriteAt(contents[off:end], int64(off))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	got, _ := os.ReadFile(filepath.Join(realpath, "sparse"))
+	if !bytes.Equal(got, contents) {
+		t.Fatal("sparse download doesn't read back as written")
+	}
+
+	probe, err := os.Create(filepath.Join(realpath, "probe"))
+	if err == nil {
+		err = unix.Fallocate(int(probe.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, 0, SPARSE_BLOCK_SIZE)
+		probe.Close()
+	}
+	if err != nil {
+		t.Skip("filesystem under the test directory can't punch holes; ", err)
+	}
+
+	fh := openHandle(t, "sparse", os.O_RDONLY)
+	ctx := context.Background()
+
+	tests := []struct {
+		off    uint64
+		whence uint32
+		want   uint64
+	}{
+		{0, unix.SEEK_DATA, 0},
+		{0, unix.SEEK_HOLE, SPARSE_BLOCK_SIZE},
+		{SPARSE_BLOCK_SIZE, unix.SEEK_DATA, 4 * SPARSE_BLOCK_SIZE},
+		{2 * SPARSE_BLOCK_SIZE, unix.SEEK_HOLE, 2 * SPARSE_BLOCK_SIZE},
+		{4 * SPARSE_BLOCK_SIZE, unix.SEEK_HOLE, size},
+	}
+	for _, test := range tests {
+		got, errno := fh.Lseek(ctx, test.off, test.whence)
+		if errno != 0 || got != test.want {
+			t.Errorf("Lseek(%v, %v) = %v, %v; want %v", test.off, test.whence, got, errno, test.want)
+		}
+	}
+
+	for _, whence := range []uint32{unix.SEEK_DATA, unix.SEEK_HOLE} {
+		for _, off := range []uint64{size, size + 10} {
+			if _, errno := fh.Lseek(ctx, off, whence); errno != syscall.ENXIO {
+				t.Errorf("Lseek(%v, %v) past EOF = %v; want ENXIO", off, whence, errno)
+			}
+		}
+	}
+	if _, errno := fh.Lseek(ctx, 0, 99); errno != syscall.EINVAL {
+		t.Errorf("Lseek with whence 99 = %v; want EINVAL", errno)
+	}
+}
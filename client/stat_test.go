@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Serves a file through the raw bridge. Returns the bridge, the file's
+// path and its node id
+func statFixture(t testing.TB) (fuse.RawFileSystem, *Node, string, uint64) {
+	t.Helper()
+	useTestQueue(t)
+	path := filepath.Join(realpath, "file")
+	err := os.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := &Node{path: realpath}
+	raw := fs.NewNodeFS(root, &fs.Options{})
+	entry := fuse.EntryOut{}
+	status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &entry)
+	if !status.Ok() {
+		t.Fatalf("Lookup = %v", status)
+	}
+	node := root.GetChild("file").Operations().(*Node)
+	return raw, node, path, entry.NodeId
+}
+
+func getattrSize(t testing.TB, raw fuse.RawFileSystem, id uint64) uint64 {
+	t.Helper()
+	out := fuse.AttrOut{}
+	status := raw.GetAttr(nil, &fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: id}}, &out)
+	if !status.Ok() {
+		t.Fatalf("GetAttr = %v", status)
+	}
+	return out.Size
+}
+
+// The Getattr right after Lookup answers from Lookup's stat; the one
+// after that stats the file again
+func TestGetattrReusesLookupStat(t *testing.T) {
+	raw, node, path, id := statFixture(t)
+	err := os.WriteFile(path, []byte("changed since lookup"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := getattrSize(t, raw, id); size != 4 {
+		t.Errorf("size after lookup = %v; want the looked up 4", size)
+	}
+	if size := getattrSize(t, raw, id); size != 20 {
+		t.Errorf("size on second getattr = %v; want 20", size)
+	}
+
+	// A stat older than the attribute timeout isn't trusted
+	raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &fuse.EntryOut{})
+	node.attrMu.Lock()
+	node.attrExpires = node.attrExpires.Add(-2 * attrCacheTTL)
+	node.attrMu.Unlock()
+	err = os.WriteFile(path, []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := getattrSize(t, raw, id); size != 7 {
+		t.Errorf("size after expiry = %v; want 7", size)
+	}
+}
+
+// A stat of a path through the mount: Lookup followed by Getattr.
+// "restat" drops the stat Lookup handed over, as before it was
+// reused, so the difference is the Lstat saved on each stat
+func BenchmarkLookupGetattr(b *testing.B) {
+	for _, reuse := range []bool{true, false} {
+		name := "reuse"
+		if !reuse {
+			name = "restat"
+		}
+		b.Run(name, func(b *testing.B) {
+			raw, node, _, id := statFixture(b)
+			lookup := &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}
+			for b.Loop() {
+				raw.Lookup(nil, lookup, "file", &fuse.EntryOut{})
+				if !reuse {
+					node.attrMu.Lock()
+					node.attrExpires = time.Time{}
+					node.attrMu.Unlock()
+				}
+				getattrSize(b, raw, id)
+			}
+		})
+	}
+}
+
+// A truncate through an open handle shows in the stat that follows,
+// which answers from the handle rather than the path
+func TestGetattrThroughHandle(t *testing.T) {
+	raw, _, path, id := statFixture(t)
+	header := fuse.InHeader{
+		NodeId: id,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	opened := fuse.OpenOut{}
+	if status := raw.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_RDWR)}, &opened); !status.Ok() {
+		t.Fatalf("Open = %v", status)
+	}
+
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+		InHeader: header,
+		Valid:    fuse.FATTR_SIZE | fuse.FATTR_FH,
+		Fh:       opened.Fh,
+		Size:     1,
+	}}
+	if status := raw.SetAttr(nil, in, &fuse.AttrOut{}); !status.Ok() {
+		t.Fatalf("SetAttr = %v", status)
+	}
+	if size := getattrSize(t, raw, id); size != 1 {
+		t.Errorf("size after truncate = %v; want 1", size)
+	}
+	waitQueued(t, OP_SETATTR, "/file")
+
+	// Another file in place of the open one, as a download that
+	// renames into place leaves it
+	err := os.WriteFile(path+".new", []byte("replaced"), 0644)
+	if err == nil {
+		err = os.Rename(path+".new", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := getattrSize(t, raw, id); size != 1 {
+		t.Errorf("size with the handle open = %v; want the handle's 1", size)
+	}
+
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: opened.Fh})
+	if size := getattrSize(t, raw, id); size != 8 {
+		t.Errorf("size after release = %v; want the path's 8", size)
+	}
+}
+
+// Waits for an op of kind op on relative path to reach the offline
+// queue. Other tests' sends may still be landing in it
+func waitQueued(t testing.TB, op string, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, queued := range loadQueue() {
+			if queued.Op == op && queued.Path == path {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("queued %v; want %v of %v", loadQueue(), op, path)
+}
+
+// A write made offline shows in stat straight away
+func TestStatShowsOfflineWrite(t *testing.T) {
+	raw, _, _, id := statFixture(t)
+	oldLocal := localStatfs
+	localStatfs = lib.NewStatfsCache(0)
+	t.Cleanup(func() { localStatfs = oldLocal })
+
+	header := fuse.InHeader{
+		NodeId: id,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	opened := fuse.OpenOut{}
+	status := raw.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_WRONLY)}, &opened)
+	if !status.Ok() {
+		t.Fatalf("Open = %v", status)
+	}
+	written, status := raw.Write(nil, &fuse.WriteIn{InHeader: header, Fh: opened.Fh, Offset: 4}, []byte(" written offline"))
+	if !status.Ok() || written != 16 {
+		t.Fatalf("Write = %v, %v; want 16 bytes written", written, status)
+	}
+	if size := getattrSize(t, raw, id); size != 20 {
+		t.Errorf("size after offline write = %v; want 20", size)
+	}
+	raw.Flush(nil, &fuse.FlushIn{InHeader: header, Fh: opened.Fh})
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: opened.Fh})
+	if size := getattrSize(t, raw, id); size != 20 {
+		t.Errorf("size after release = %v; want 20", size)
+	}
+	waitQueued(t, OP_UPLOAD, "/file")
+}
+
+// An on-demand placeholder shows the size remote listed until it is
+// changed locally; a truncate made offline shows straight away
+func TestStatShowsPlaceholderTruncate(t *testing.T) {
+	useTestQueue(t)
+	err := os.WriteFile(filepath.Join(realpath, "placeholder"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDemandMu.Lock()
+	onDemand["/placeholder"] = 1 << 20
+	onDemandMu.Unlock()
+	t.Cleanup(func() {
+		onDemandMu.Lock()
+		delete(onDemand, "/placeholder")
+		onDemandMu.Unlock()
+	})
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	entry := fuse.EntryOut{}
+	status := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "placeholder", &entry)
+	if !status.Ok() {
+		t.Fatalf("Lookup = %v", status)
+	}
+	if entry.Size != 1<<20 {
+		t.Errorf("looked up placeholder size = %v; want the listed %v", entry.Size, 1<<20)
+	}
+
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+		InHeader: fuse.InHeader{
+			NodeId: entry.NodeId,
+			Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+		},
+		Valid: fuse.FATTR_SIZE,
+		Size:  3,
+	}}
+	if status := raw.SetAttr(nil, in, &fuse.AttrOut{}); !status.Ok() {
+		t.Fatalf("SetAttr = %v", status)
+	}
+	if size := getattrSize(t, raw, entry.NodeId); size != 3 {
+		t.Errorf("placeholder size after offline truncate = %v; want 3", size)
+	}
+	waitQueued(t, OP_SETATTR, "/placeholder")
+	if size := getattrSize(t, raw, entry.NodeId); size != 3 {
+		t.Errorf("placeholder size with the truncate queued = %v; want 3", size)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Passes on the symlinks remote is asked to create
+type symlinkingServer struct {
+	proto.UnimplementedFuseServer
+	links chan *proto.LinkRequest
+}
+
+func (s *symlinkingServer) Symlink(ctx context.Context, req *proto.LinkRequest) (*proto.LinkResponse, error) {
+	s.links <- req
+	return &proto.LinkResponse{}, nil
+}
+
+// Relative and absolute targets read back, and reach remote, exactly
+// as they were given
+func TestSymlinkTargetsKeptVerbatim(t *testing.T) {
+	useTestInodes(t)
+	useTestQueue(t)
+	srv := &symlinkingServer{links: make(chan *proto.LinkRequest, 1)}
+	useTestRemote(t, srv)
+	online.Store(true)
+
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	targets := map[string]string{
+		"relative": "../dir/./file",
+		"absolute": "/etc/hostname",
+	}
+	for name, target := range targets {
+		out := fuse.EntryOut{}
+		status := raw.Symlink(nil, &header, target, name, &out)
+		if !status.Ok() {
+			t.Fatalf("Symlink %v = %v", name, status)
+		}
+
+		got, status := raw.Readlink(nil, &fuse.InHeader{NodeId: out.NodeId})
+		if !status.Ok() || string(got) != target {
+			t.Errorf("Readlink %v = %q, %v; want %q", name, got, status, target)
+		}
+		if got, err := os.Readlink(localPath("/" + name)); err != nil || got != target {
+			t.Errorf("local %v = %q, %v; want %q", name, got, err, target)
+		}
+
+		select {
+		case req := <-srv.links:
+			if req.OldPath != target || req.NewPath != "/"+name {
+				t.Errorf("remote asked for %v -> %q; want /%v -> %q", req.NewPath, req.OldPath, name, target)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("symlink %v never reached remote", name)
+		}
+	}
+}
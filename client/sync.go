@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -11,25 +12,43 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/events"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-// //go:embed certs/ca.crt
-// var CA_CERT_DATA []byte
+// Remote roots us at our department's shared directory or at our
+// personal directory inside it depending on the SCOPE_METADATA we send
+const (
+	SCOPE_METADATA = "fusion-scope"
+	SCOPE_SHARED   = "shared"
+	SCOPE_PERSONAL = "personal"
+)
+
+var (
+	// Pool of gRPC clients used for file downloads. Each client owns
+	// its own connection so that parallel downloads are not all
+	// multiplexed over the connection used by the REMOTE_OBSERVER
+	downloadClients atomic.Pointer[[]proto.FuseClient]
+	nextDownload    atomic.Uint64
+)
 
 // Returns an authenticated gRPC client
 func new_gRPC_client() proto.FuseClient {
-	conn, err := grpc.NewClient(
-		remote,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
+	opts := append(dialOptions(), grpc.WithTransportCredentials(creds))
+	conn, err := grpc.NewClient(remote, opts...)
 	if err != nil {
 		log.Fatalf("[GRPC] Error creating GRPC channel; %v\n", err)
 	}
@@ -37,16 +56,96 @@ func new_gRPC_client() proto.FuseClient {
 	return proto.NewFuseClient(conn)
 }
 
+// Interceptors every call to remote goes through
+func dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(requestIdInterceptor, retryInterceptor),
+		grpc.WithChainStreamInterceptor(requestIdStreamInterceptor),
+	}
+}
+
+// Returns a pool of gRPC clients, each on its own connection
+func new_gRPC_client_pool(size int) []proto.FuseClient {
+	if size < 1 {
+		size = 1
+	}
+
+	clients := make([]proto.FuseClient, size)
+	for i := range clients {
+		clients[i] = new_gRPC_client()
+	}
+	return clients
+}
+
+// Picks the next download client in round-robin order
+func downloadClient() proto.FuseClient {
+	clients := downloadClients.Load()
+	if clients == nil || len(*clients) == 0 {
+		return grpcClient
+	}
+	n := nextDownload.Add(1)
+	return (*clients)[n%uint64(len(*clients))]
+}
+
+// Sets up the download pool unless it already is
+func setupDownloadClients() {
+	if downloadClients.Load() != nil {
+		return
+	}
+	clients := new_gRPC_client_pool(connections)
+	downloadClients.CompareAndSwap(nil, &clients)
+}
+
 // Embeds authorization key in gRPC request metadata
 func NewAuthenticatedCtx(ctx context.Context) context.Context {
 	md := metadata.New(map[string]string{
 		"authorization": authToken,
 	})
+	if scope != "" {
+		md.Set(SCOPE_METADATA, scope)
+	}
 	return metadata.NewOutgoingContext(ctx, md)
 }
 
-// Opens a stream with remote and listens for file events
+// Returns an authenticated context for a single call to remote made
+// in the background. Background syncs outlive the FUSE request that
+// started them so they can't use its context, but they shouldn't hang
+// forever on a stuck remote either
+func newSyncCtx() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if syncTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), syncTimeout)
+	}
+	return NewAuthenticatedCtx(ctx), cancel
+}
+
+// Tags a mutating request with key so that remote applies it at
+// most once however many times it is sent
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, lib.IDEMPOTENCY_KEY, key)
+}
+
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return hex.EncodeToString(key)
+}
+
+// Set while a REMOTE_OBSERVER is running. Going offline doesn't
+// always end the stream, eg. when remote refuses changes during
+// maintenance, so reconnecting must not start a second one
+var observing atomic.Bool
+
+// Opens a stream with remote and listens for file events. Push-only
+// mounts don't listen
 func startRemoteObserver(ctx context.Context) {
+	if !pullsRemote() {
+		return
+	}
+	if !observing.CompareAndSwap(false, true) {
+		return
+	}
+	defer observing.Store(false)
 	log.Println("[SYNC] Launching REMOTE_OBSERVER goroutine")
 
 	ctx = NewAuthenticatedCtx(ctx)
@@ -83,18 +182,35 @@ outer:
 
 func handleFileEvent(fileEvent *proto.FileEvent) {
 	log.Printf("[SYNC] REMOTE_OBSERVER received fileEvent: %s\n", lib.PrintFileEvent(fileEvent))
+	defer recordSyncLag(fileEvent.Timestamp)
 	eventType := events.EventType(fileEvent.Event)
 
+	if remoteIgnored(fileEvent.Path, fileEvent.Mode) &&
+		(fileEvent.NewPath == "" || remoteIgnored(fileEvent.NewPath, fileEvent.Mode)) {
+		return
+	}
+	if remoteTempFile(fileEvent.Path, fileEvent.Mode) || remoteTempFile(fileEvent.NewPath, fileEvent.Mode) {
+		return
+	}
+	if !lib.LocalPathValid(fileEvent.Path) || !lib.LocalPathValid(fileEvent.NewPath) {
+		log.Printf("[SYNC] Skipping event for a name this OS can't store; %v\n", lib.PrintFileEvent(fileEvent))
+		return
+	}
+	forgetRemoteMiss(fileEvent.Path)
+	forgetRemoteMiss(fileEvent.NewPath)
+
 	switch eventType {
 	case events.ADD_FILE:
 		mode := os.FileMode(fileEvent.Mode)
-		fullpath := filepath.Join(realpath, fileEvent.Path)
+		fullpath := localPath(fileEvent.Path)
 
 		if mode.IsDir() {
 			err := os.MkdirAll(fullpath, mode)
 			if err != nil {
 				log.Printf("[SYNC] Error creating directory; %v\n", err)
+				return
 			}
+			invalidateEntry(fileEvent.Path)
 			return
 		}
 
@@ -105,6 +221,7 @@ func handleFileEvent(fileEvent *proto.FileEvent) {
 				return
 			}
 			file.Close()
+			invalidateEntry(fileEvent.Path)
 		}
 
 	case events.MODIFY_FILE:
@@ -112,82 +229,220 @@ func handleFileEvent(fileEvent *proto.FileEvent) {
 			Path: fileEvent.Path,
 			Mode: fileEvent.Mode,
 		}
-		err := downloadFile(&remote)
+		err := downloadModified(&remote)
 		if err != nil {
 			log.Printf("[SYNC] Error downloading file changes; %v\n", err)
 		}
 
 	case events.RENAME_FILE:
-		oldpath := filepath.Join(realpath, fileEvent.Path)
-		newpath := filepath.Join(realpath, fileEvent.NewPath)
+		oldpath := localPath(fileEvent.Path)
+		newpath := localPath(fileEvent.NewPath)
 
 		err := os.Rename(oldpath, newpath)
 		if err != nil {
 			log.Printf("[SYNC] Error handling RENAME file event; %v\n", err)
 			return
 		}
+		renameIno(fileEvent.Path, fileEvent.NewPath)
+		forgetListing(fileEvent.Path)
+		invalidateEntry(fileEvent.Path)
+		invalidateEntry(fileEvent.NewPath)
 
 	case events.DELETE_FILE:
-		path := filepath.Join(realpath, fileEvent.Path)
-		err := os.Remove(path)
+		if confirmDeletes && !remoteConfirmsDelete(fileEvent.Path) {
+			return
+		}
+		err := removeDeleted(fileEvent.Path)
 		if err != nil {
 			log.Printf("[SYNC] Error handling DELETE file event; %v\n", err)
+			return
 		}
+		forgetIno(fileEvent.Path)
+		forgetListing(fileEvent.Path)
+		invalidateEntry(fileEvent.Path)
 
 	default:
 		log.Println("[SYNC] Unregistered file event")
 	}
 }
 
+// Compares the remote manifest of directory path against the local
+// copy and downloads only the files whose hashes differ. Does nothing
+// on push-only mounts
 func fetchRemoteEntries(ctx context.Context, path string) error {
+	if !pullsRemote() {
+		return nil
+	}
 	if strings.Contains(path, "Trash") {
 		return nil
 	}
 
 	// Download directory tree and re-create it
-	ctx = NewAuthenticatedCtx(ctx)
-	response, err := grpcClient.ReadDirAll(ctx, &proto.DirEntry{
-		Path: path,
-	})
+	nextEntry, err := remoteEntries(ctx, path)
 	if err != nil {
 		return err
 	}
 
-	remoteEntries := response.GetEntries()
 	wg := sync.WaitGroup{}
+	listing := make(map[string]listedEntry)
+
+	for {
+		remoteEntry, err := nextEntry()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			wg.Wait()
+			return err
+		}
+		if remoteIgnored(remoteEntry.Path, remoteEntry.Mode) {
+			continue
+		}
+		listing[filepath.Base(remoteEntry.Path)] = listedEntry{
+			Mode: remoteEntry.Mode,
+			Size: remoteEntry.Size,
+		}
 
-	for _, remoteEntry := range remoteEntries {
 		mode := os.FileMode(remoteEntry.Mode)
-		fullpath := filepath.Join(realpath, remoteEntry.Path)
+		fullpath := localPath(remoteEntry.Path)
+
+		// Names new to this machine may be cached as missing
+		_, err = os.Lstat(fullpath)
+		isNew := os.IsNotExist(err)
 
 		if mode.IsDir() {
 			err := os.MkdirAll(fullpath, 0755)
 			if err != nil {
 				log.Printf("[SYNC] Error creating directory; %v\n", err)
 			}
+			syncOwner(fullpath, remoteEntry.Owner)
+			if isNew {
+				invalidateEntry(remoteEntry.Path)
+			}
 		}
 
 		if mode.IsRegular() {
+			localHash, err := localFileHash(fullpath)
+			if err == nil && localHash == remoteEntry.Hash {
+				// Local file already in sync with remote
+				syncOwner(fullpath, remoteEntry.Owner)
+				storeHash(fullpath, localHash)
+				continue
+			}
+
+			if isLargeFile(remoteEntry.Size) {
+				deferDownload(remoteEntry.Path, remoteEntry.Size, remoteEntry.Mode)
+				if isNew {
+					invalidateEntry(remoteEntry.Path)
+				}
+				continue
+			}
+			if deferOutsideWindow(remoteEntry.Path, remoteEntry.Size, remoteEntry.Mode) {
+				if isNew {
+					invalidateEntry(remoteEntry.Path)
+				}
+				continue
+			}
+
 			wg.Add(1)
-			go func(file *proto.DirEntry) {
+			go func(file *proto.DirEntry, owner string, isNew bool) {
 				defer wg.Done()
 				err := downloadFile(file)
 				if err != nil {
 					log.Printf("[SYNC] Error downloading remote file; %v\n", err)
+					return
 				}
-			}(remoteEntry)
+				syncOwner(localPath(file.Path), owner)
+				if isNew {
+					invalidateEntry(file.Path)
+				}
+			}(&proto.DirEntry{
+				Path: remoteEntry.Path,
+				Mode: remoteEntry.Mode,
+			}, remoteEntry.Owner, isNew)
 		}
 	}
 
+	setListing(path, listing)
 	wg.Wait()
 
 	return nil
 }
 
+// Returns a function yielding the entries of remote directory path
+// until io.EOF. Remotes without manifests are listed with ReadDirAll
+// instead; with no hashes to compare every file gets downloaded
+func remoteEntries(ctx context.Context, path string) (func() (*proto.ManifestEntry, error), error) {
+	ctx = NewAuthenticatedCtx(ctx)
+
+	if remoteSupports(lib.FEATURE_MANIFEST) {
+		stream, err := grpcClient.GetManifest(ctx, &proto.ManifestRequest{
+			Path: path,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return stream.Recv, nil
+	}
+
+	response, err := grpcClient.ReadDirAll(ctx, &proto.DirEntry{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	entries := response.Entries
+	return func() (*proto.ManifestEntry, error) {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entry := entries[0]
+		entries = entries[1:]
+		return &proto.ManifestEntry{
+			Path: entry.Path,
+			Size: entry.GetAttr().GetSize(),
+			Mode: entry.Mode,
+		}, nil
+	}, nil
+}
+
+// Copies the owner recorded on remote onto the local file
+func syncOwner(path, owner string) {
+	if owner == "" || lib.GetOwner(path) == owner {
+		return
+	}
+	err := lib.SetOwner(path, owner)
+	if err != nil {
+		log.Printf("[SYNC] Error setting owner of %v; %v\n", path, err)
+	}
+}
+
+// Returns the md5 hash of a local file
+func localFileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return contentHash(path, file)
+}
+
+// Returns the hash remote would have for the contents of local file
+// path read from file
+func contentHash(path string, file io.Reader) (string, error) {
+	if e2eEnabled() {
+		return encryptedFileHash(path, file)
+	}
+	return lib.HashFile(file)
+}
+
 func downloadFile(remote *proto.DirEntry) error {
 	// log.Printf("[SYNC] Downloading remote file \"%v\"\n", remote.Path)
 
-	fullpath := filepath.Join(realpath, remote.Path)
+	if remoteIgnored(remote.Path, remote.Mode) {
+		return nil
+	}
+
+	fullpath := localPath(remote.Path)
 	file, err := os.OpenFile(fullpath, os.O_CREATE|os.O_RDWR, os.FileMode(remote.Mode))
 	if err != nil {
 		return err
@@ -197,29 +452,46 @@ func downloadFile(remote *proto.DirEntry) error {
 	// Remote is a file;
 	// We need to check for any file changes on remote and
 	// download them
-	hash := md5.New()
-	_, err = io.Copy(hash, file)
+	localFileHash, err := contentHash(fullpath, file)
 	if err != nil {
 		return err
 	}
-	digest := hash.Sum(nil)
-	localFileHash := hex.EncodeToString(digest)
+
+	request := &proto.DownloadRequest{
+		Path:         remote.Path,
+		ExpectedHash: localFileHash,
+	}
+
+	// Ask remote to skip the bytes we already got on a previous
+	// interrupted attempt
+	// Encrypted files can only resume at the end of a chunk
+	partial, ok := loadPartial(remote.Path)
+	if ok && (!e2eEnabled() || e2eResumable(fullpath, partial.Offset)) {
+		request.ResumeOffset = partial.Offset
+		request.ResumeHash = partial.Hash
+	}
 
 	// Download file
-	authCtx := NewAuthenticatedCtx(context.Background())
-	stream, err := grpcClient.DownloadFile(
-		authCtx,
-		&proto.DownloadRequest{
-			Path:         remote.Path,
-			ExpectedHash: localFileHash,
-		},
-	)
+	ctx, tr, done := startTransfer(context.Background(), remote.Path, TRANSFER_DOWNLOAD)
+	defer done()
+
+	authCtx := NewAuthenticatedCtx(ctx)
+	stream, err := downloadClient().DownloadFile(authCtx, request)
 	if err != nil {
 		return err
 	}
 
+	// Saving the partial record on every chunk is wasteful; save
+	// every few chunks and whenever the stream breaks
+	const PARTIAL_SAVE_INTERVAL = 1024 * 1024 // 1Mb
+
+	var out io.WriterAt = sparseWriter{file}
+
 	totalExpectedSize := -1
+	startOffset := int64(0)
 	recvBytes := 0
+	progress := partialDownload{Path: remote.Path}
+	lastSaved := int64(0)
 
 	for {
 		chunk, err := stream.Recv()
@@ -227,28 +499,90 @@ func downloadFile(remote *proto.DirEntry) error {
 			if err == io.EOF {
 				break
 			}
+			if progress.Offset > 0 {
+				savePartial(&progress)
+			}
 			return err
 		}
 		if totalExpectedSize == -1 {
 			totalExpectedSize = int(chunk.TotalSize)
+			startOffset = chunk.Offset
+			progress.Hash = chunk.Hash
+			lastSaved = startOffset
+
+			// Encrypted downloads are decrypted on their way to disk
+			if e2eEnabled() {
+				out = newDecryptingWriter(file, fullpath, chunk.TotalSize)
+			}
 		}
 
-		n, err := file.WriteAt(chunk.Data, chunk.Offset)
+		n, err := out.WriteAt(chunk.Data, chunk.Offset)
 		if err != nil {
 			return err
 		}
 		recvBytes += n
+
+		progress.Offset = chunk.Offset + int64(n)
+		if progress.Offset-lastSaved >= PARTIAL_SAVE_INTERVAL {
+			err := savePartial(&progress)
+			if err != nil {
+				log.Printf("[SYNC] Error saving partial download; %v\n", err)
+			}
+			lastSaved = progress.Offset
+		}
+
+		err = tr.moved(ctx, n)
+		if err != nil {
+			savePartial(&progress)
+			return err
+		}
 	}
 
 	if totalExpectedSize == -1 || recvBytes == 0 {
 		// No file received and no error means we have the same
 		// local file as remote
+		removePartial(remote.Path)
+		storeHash(fullpath, localFileHash)
 		return nil
 	}
 
-	if totalExpectedSize != -1 && recvBytes != totalExpectedSize {
-		return fmt.Errorf("expected file of size %v but got %v bytes instead", totalExpectedSize, recvBytes)
+	if int64(recvBytes) != int64(totalExpectedSize)-startOffset {
+		savePartial(&progress)
+		return fmt.Errorf("expected file of size %v but got %v bytes instead", totalExpectedSize, startOffset+int64(recvBytes))
+	}
+
+	// Drop any stale bytes past the end of the remote file then
+	// verify the whole file now that it is complete
+	err = file.Truncate(plaintextSize(int64(totalExpectedSize)))
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	var downloadedHash string
+	if e2eEnabled() {
+		// Every chunk has been authenticated on its way in; remember
+		// which remote contents we now have
+		downloadedHash = progress.Hash
+		err = recordDownload(fullpath, file, progress.Hash)
+	} else {
+		downloadedHash, err = contentHash(fullpath, file)
+	}
+	if err != nil {
+		return err
+	}
+	removePartial(remote.Path)
+
+	if progress.Hash != "" && downloadedHash != progress.Hash {
+		return fmt.Errorf("downloaded file \"%v\" does not match remote hash", remote.Path)
 	}
+	storeHash(fullpath, downloadedHash)
+
+	// Downloads also run inside Read; invalidating the inode from
+	// within an operation on it can deadlock the kernel
+	go invalidateContent(remote.Path)
 
 	log.Printf("[SYNC] File \"%v\" updated successfully\n", remote.Path)
 	return nil
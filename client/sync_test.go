@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+)
+
+// Streams a file of DOWNLOAD_BENCH_SIZE zero bytes to every download
+type zeroServer struct {
+	proto.UnimplementedFuseServer
+}
+
+const DOWNLOAD_BENCH_SIZE = 4 * 1024 * 1024
+
+func (zeroServer) DownloadFile(req *proto.DownloadRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
+	chunk := make([]byte, 64*1024)
+	for off := 0; off < DOWNLOAD_BENCH_SIZE; off += len(chunk) {
+		err := stream.Send(&proto.FileChunk{
+			Data:      chunk,
+			Offset:    int64(off),
+			TotalSize: DOWNLOAD_BENCH_SIZE,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parallel downloads over a single connection against a pool of them
+func BenchmarkParallelDownloads(b *testing.B) {
+	for _, size := range []int{1, 4} {
+		b.Run(fmt.Sprintf("connections=%v", size), func(b *testing.B) {
+			clients := make([]proto.FuseClient, size)
+			for i := range clients {
+				clients[i] = newTestClient(b, zeroServer{})
+			}
+			old := downloadClients.Swap(&clients)
+			b.Cleanup(func() { downloadClients.Store(old) })
+
+			b.SetBytes(DOWNLOAD_BENCH_SIZE)
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					stream, err := downloadClient().DownloadFile(context.Background(), &proto.DownloadRequest{Path: "file"})
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					for {
+						_, err := stream.Recv()
+						if err == io.EOF {
+							break
+						}
+						if err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}
+			})
+		})
+	}
+}
+
+// Downloads take turns on the pool's connections
+func TestDownloadClientRoundRobin(t *testing.T) {
+	clients := []proto.FuseClient{
+		newTestClient(t, zeroServer{}),
+		newTestClient(t, zeroServer{}),
+	}
+	old := downloadClients.Swap(&clients)
+	t.Cleanup(func() { downloadClients.Store(old) })
+
+	first, second, third := downloadClient(), downloadClient(), downloadClient()
+	if first == second || first != third {
+		t.Error("downloads don't alternate between the pool's connections")
+	}
+}
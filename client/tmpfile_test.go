@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// An O_TMPFILE create fails with EOPNOTSUPP, so programs fall back to a
+// named temporary file, and leaves nothing behind locally or queued
+func TestTmpfileCreateRefused(t *testing.T) {
+	useTestQueue(t)
+	raw := fs.NewNodeFS(&Node{path: realpath}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+
+	in := &fuse.CreateIn{InHeader: header, Flags: uint32(unix.O_TMPFILE | os.O_RDWR), Mode: 0644}
+	status := raw.Create(nil, in, "tmp", &fuse.CreateOut{})
+	if status != fuse.Status(syscall.EOPNOTSUPP) {
+		t.Errorf("Create with O_TMPFILE = %v; want EOPNOTSUPP", status)
+	}
+	if entries, _ := os.ReadDir(realpath); len(entries) != 0 {
+		t.Errorf("refused create left %v behind", entries)
+	}
+	if queued := loadQueue(); len(queued) != 0 {
+		t.Errorf("refused create queued %v", queued)
+	}
+}
+
+// Through the mount, opening a directory with O_TMPFILE fails cleanly
+// rather than creating anything
+func TestTmpfileOpenThroughMount(t *testing.T) {
+	useTestMount(t)
+
+	fd, err := unix.Open(mountpoint, unix.O_TMPFILE|unix.O_RDWR, 0644)
+	if err == nil {
+		unix.Close(fd)
+		t.Fatal("O_TMPFILE open of the mount succeeded")
+	}
+	if err != unix.EOPNOTSUPP {
+		t.Errorf("O_TMPFILE open of the mount = %v; want EOPNOTSUPP", err)
+	}
+	entries, err := os.ReadDir(realpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "file" {
+		t.Errorf("local directory holds %v; want only file", entries)
+	}
+}
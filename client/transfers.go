@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Uploads and downloads in flight. Each runs under a context of its
+// own so that the cancel command can stop one without touching the
+// rest.
+//
+// A cancelled download keeps its partial record and resumes from it
+// the next time the file is fetched. A cancelled upload leaves the
+// local file as it is; a whole-file upload is swapped in on remote
+// atomically so remote keeps its old contents, a chunked one leaves
+// remote with the chunks sent so far until the file is uploaded again
+const (
+	TRANSFER_UPLOAD   = "upload"
+	TRANSFER_DOWNLOAD = "download"
+
+	// Number of cancelled transfers listed by the status command
+	MAX_CANCELLED_TRANSFERS = 10
+)
+
+type transfer struct {
+	path      string
+	direction string
+	started   time.Time
+	bytes     atomic.Int64
+	cancel    context.CancelFunc
+}
+
+type cancelledTransfer struct {
+	Path        string    `json:"path"`
+	Direction   string    `json:"direction"`
+	Bytes       int64     `json:"bytes"`
+	CancelledAt time.Time `json:"cancelled_at"`
+}
+
+var (
+	transfers       = make(map[*transfer]struct{})
+	cancelledRecent = []cancelledTransfer{}
+	transfersMu     = sync.Mutex{}
+)
+
+// Most KiB per second all transfers together may move; see -bandwidth.
+// 0 means unlimited
+var bandwidthLimit int64
+
+// Spaces transfers out so they keep to bandwidthLimit
+type limiter struct {
+	mu sync.Mutex
+	// When the bytes moved so far fit the limit
+	next time.Time
+}
+
+var bandwidth = &limiter{}
+
+// Waits until n more bytes fit rate KiB per second. Returns early
+// with ctx's error if ctx is done first
+func (l *limiter) wait(ctx context.Context, n int, rate int64) error {
+	if rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / (rate * 1024)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Counts n more bytes moved by t and holds the transfer back until
+// they fit -bandwidth
+func (t *transfer) moved(ctx context.Context, n int) error {
+	t.bytes.Add(int64(n))
+	return bandwidth.wait(ctx, n, bandwidthLimit)
+}
+
+func init() {
+	registerStatus("transfers", func() any {
+		transfersMu.Lock()
+		defer transfersMu.Unlock()
+
+		active := []map[string]any{}
+		for t := range transfers {
+			active = append(active, map[string]any{
+				"path":      t.path,
+				"direction": t.direction,
+				"bytes":     t.bytes.Load(),
+				"started":   t.started,
+			})
+		}
+		return map[string]any{
+			"active":    active,
+			"cancelled": append([]cancelledTransfer{}, cancelledRecent...),
+		}
+	})
+}
+
+// Registers a transfer of path, relative to realpath. The returned
+// context is cancelled when the transfer is; done must be called once
+// the transfer ends
+func startTransfer(ctx context.Context, path, direction string) (context.Context, *transfer, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	t := &transfer{
+		path:      path,
+		direction: direction,
+		started:   time.Now(),
+		cancel:    cancel,
+	}
+
+	transfersMu.Lock()
+	transfers[t] = struct{}{}
+	transfersMu.Unlock()
+
+	return ctx, t, func() {
+		transfersMu.Lock()
+		delete(transfers, t)
+		transfersMu.Unlock()
+		cancel()
+	}
+}
+
+// Cancels every transfer of path. Path may be relative to the mount
+// or a path under mountpoint. Returns the number of transfers cancelled
+func cancelTransfer(path string) int {
+	path = transferPath(path)
+
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+
+	count := 0
+	for t := range transfers {
+		if t.path != path {
+			continue
+		}
+		t.cancel()
+		delete(transfers, t)
+		count++
+
+		log.Printf("[SYNC] Cancelled %v of %v\n", t.direction, t.path)
+		cancelledRecent = append(cancelledRecent, cancelledTransfer{
+			Path:        t.path,
+			Direction:   t.direction,
+			Bytes:       t.bytes.Load(),
+			CancelledAt: time.Now(),
+		})
+	}
+	if len(cancelledRecent) > MAX_CANCELLED_TRANSFERS {
+		cancelledRecent = cancelledRecent[len(cancelledRecent)-MAX_CANCELLED_TRANSFERS:]
+	}
+	return count
+}
+
+// Turns a path given to the cancel command into the form transfers
+// are registered under
+func transferPath(path string) string {
+	if filepath.IsAbs(path) && mountpoint != "" {
+		rel, err := filepath.Rel(mountpoint, path)
+		if err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+	}
+	path = filepath.Clean("/" + path)
+	if path == "/" {
+		return ""
+	}
+	return path
+}
+
+func cancelHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if strings.TrimSpace(path) == "" {
+		http.Error(w, "path required", http.StatusBadRequest)
+		return
+	}
+
+	count := cancelTransfer(path)
+	if count == 0 {
+		http.Error(w, fmt.Sprintf("no transfer of %v in progress", path), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"path":      transferPath(path),
+		"cancelled": count,
+	})
+}
+
+// Asks the running client to cancel the transfers of path
+func runCancel(path string) {
+	resp, err := controlClient().Post("http://fusion/cancel?path="+url.QueryEscape(path), "", nil)
+	if err != nil {
+		log.Fatalf("Error contacting running client; is it running? %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprint(os.Stderr, string(data))
+		os.Exit(1)
+	}
+	fmt.Print(string(data))
+}
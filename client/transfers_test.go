@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Returns the bytes moved so far by the transfer of path, or -1 if
+// there is none
+func transferBytes(path string) int64 {
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+	for t := range transfers {
+		if t.path == path {
+			return t.bytes.Load()
+		}
+	}
+	return -1
+}
+
+// A download cancelled mid-stream stops at once, keeps what it got as
+// a partial and is listed as cancelled. Fetching the file again picks
+// up where it stopped
+func TestCancelDownloadMidStream(t *testing.T) {
+	useTestQueue(t)
+	t.Cleanup(func() {
+		transfersMu.Lock()
+		cancelledRecent = []cancelledTransfer{}
+		transfersMu.Unlock()
+	})
+	contents := bytes.Repeat([]byte("0123456789abcdef"), 256*1024) // 4MiB
+	srv := &resumeServer{contents: contents, dropAfter: 2 * 1024 * 1024, stall: true}
+	useTestRemote(t, srv)
+	entry := &proto.DirEntry{Path: "/large", Mode: 0644}
+
+	errs := make(chan error)
+	go func() { errs <- downloadFile(entry) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for transferBytes(entry.Path) < int64(srv.dropAfter) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := cancelTransfer("large"); n != 1 {
+		t.Fatalf("cancelled %v transfers; want 1", n)
+	}
+	select {
+	case err := <-errs:
+		if status.Code(err) != codes.Canceled {
+			t.Fatalf("cancelled download = %v; want %v", err, codes.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download still running after it was cancelled")
+	}
+	if got := transferBytes(entry.Path); got != -1 {
+		t.Errorf("cancelled download still listed with %v bytes", got)
+	}
+	transfersMu.Lock()
+	cancelled := append([]cancelledTransfer{}, cancelledRecent...)
+	transfersMu.Unlock()
+	if len(cancelled) != 1 || cancelled[0].Path != entry.Path || cancelled[0].Bytes != int64(srv.dropAfter) {
+		t.Errorf("cancelled transfers = %+v; want the download of %v after %v bytes", cancelled, entry.Path, srv.dropAfter)
+	}
+	partial, ok := loadPartial(entry.Path)
+	if !ok || partial.Offset != int64(srv.dropAfter) {
+		t.Fatalf("partial record = %+v, %v; want offset %v", partial, ok, srv.dropAfter)
+	}
+	got, _ := os.ReadFile(localPath(entry.Path))
+	if !bytes.Equal(got[:srv.dropAfter], contents[:srv.dropAfter]) {
+		t.Error("bytes written before the cancel differ from remote")
+	}
+
+	err := downloadFile(entry)
+	if err != nil {
+		t.Fatalf("download after cancel failed; %v", err)
+	}
+	if got := srv.requests[1].ResumeOffset; got != int64(srv.dropAfter) {
+		t.Errorf("resumed from %v; want %v", got, srv.dropAfter)
+	}
+	got, _ = os.ReadFile(localPath(entry.Path))
+	if !bytes.Equal(got, contents) {
+		t.Error("file differs from remote after resuming")
+	}
+}
+
+// Cancelling a path with nothing in flight is an error
+func TestCancelHandlerWithoutTransfer(t *testing.T) {
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"", http.StatusBadRequest},
+		{"/idle", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		cancelHandler(w, httptest.NewRequest("POST", "/cancel?path="+test.path, nil))
+		if w.Code != test.want {
+			t.Errorf("cancel of %q = %v; want %v", test.path, w.Code, test.want)
+		}
+	}
+}
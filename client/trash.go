@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// What happens to a local file when remote says it was deleted
+const (
+	// Remove it right away
+	REMOTE_DELETE_REMOVE = "remove"
+	// Move it into the trash where it is kept for -trash-retention
+	REMOTE_DELETE_TRASH = "trash"
+)
+
+// How often expired files are removed from the trash
+const TRASH_CLEANUP_INTERVAL = time.Hour
+
+// Layout of the directories files are trashed into
+const TRASH_TIME_FORMAT = "20060102T150405.000000000"
+
+// Files trashed from realpath. Each delete gets a directory named
+// after when it happened holding the file at its relative path, so
+// deleting the same name twice keeps both copies
+func trashDir() string {
+	digest := md5.Sum([]byte(realpath))
+	return filepath.Join(lib.ProjectDir, "trash", hex.EncodeToString(digest[:]))
+}
+
+// Applies a delete made on remote to local file path
+func removeDeleted(path string) error {
+	fullpath := localPath(path)
+	info, err := os.Lstat(fullpath)
+	if err != nil {
+		return err
+	}
+	if remoteDelete != REMOTE_DELETE_TRASH || info.IsDir() {
+		// Remote only deletes empty directories; nothing to keep
+		return os.Remove(fullpath)
+	}
+
+	trashPath := filepath.Join(trashDir(), time.Now().Format(TRASH_TIME_FORMAT), path)
+	err = os.MkdirAll(filepath.Dir(trashPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(fullpath, trashPath)
+	if errors.Is(err, syscall.EXDEV) {
+		// Trash lives on another filesystem than realpath
+		err = moveAcross(fullpath, trashPath, info)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("[SYNC] Moved %v deleted on remote to %v\n", path, trashPath)
+	return nil
+}
+
+// Copies src to dst then removes src
+func moveAcross(src, dst string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(target, dst)
+		if err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Asks remote whether path is really gone before we delete our copy.
+// Anything but a definite not found keeps the local file
+func remoteConfirmsDelete(path string) bool {
+	ctx, cancel := newSyncCtx()
+	defer cancel()
+
+	_, err := grpcClient.Lookup(ctx, &proto.LookupRequest{Path: path})
+	if err == nil {
+		log.Printf("[SYNC] Ignoring DELETE event for %v; remote still has it\n", path)
+		return false
+	}
+	if status.Code(err) != codes.NotFound {
+		log.Printf("[SYNC] Ignoring DELETE event for %v; could not confirm it with remote; %v\n", path, err)
+		return false
+	}
+	return true
+}
+
+// Removes trashed files older than retention. Should be run as a
+// goroutine
+func cleanupTrash(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(TRASH_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		entries, err := os.ReadDir(trashDir())
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[SYNC] Error reading trash; %v\n", err)
+		}
+		for _, entry := range entries {
+			trashedAt, err := time.ParseInLocation(TRASH_TIME_FORMAT, entry.Name(), time.Local)
+			if err != nil || time.Since(trashedAt) < retention {
+				continue
+			}
+			err = os.RemoveAll(filepath.Join(trashDir(), entry.Name()))
+			if err != nil {
+				log.Printf("[SYNC] Error emptying trash; %v\n", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sets -remote-delete and -confirm-deletes for the rest of the test
+func useRemoteDelete(t *testing.T, mode string, confirm bool) {
+	oldMode, oldConfirm := remoteDelete, confirmDeletes
+	remoteDelete, confirmDeletes = mode, confirm
+	t.Cleanup(func() { remoteDelete, confirmDeletes = oldMode, oldConfirm })
+}
+
+// Writes path under realpath and has remote delete it
+func deletedOnRemote(t *testing.T, path, contents string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(localPath(path)), 0755)
+	if err == nil {
+		err = os.WriteFile(localPath(path), []byte(contents), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleFileEvent(&proto.FileEvent{Event: uint32(events.DELETE_FILE), Path: path, Mode: 0644})
+}
+
+// Returns the contents of every copy of path in the trash
+func trashed(t *testing.T, path string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(trashDir())
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	copies := []string{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(trashDir(), entry.Name(), path))
+		if err == nil {
+			copies = append(copies, string(data))
+		}
+	}
+	return copies
+}
+
+// Files deleted on remote go to the trash, each delete keeping its own
+// copy; with -remote-delete remove they are gone for good
+func TestRemoteDeleteTrashes(t *testing.T) {
+	useTestQueue(t)
+	useRemoteDelete(t, REMOTE_DELETE_TRASH, false)
+
+	deletedOnRemote(t, "/dir/file", "first")
+	deletedOnRemote(t, "/dir/file", "second")
+	if _, err := os.Lstat(localPath("/dir/file")); !os.IsNotExist(err) {
+		t.Errorf("file deleted on remote still in place; %v", err)
+	}
+	got := trashed(t, "/dir/file")
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("trash holds %q; want both copies", got)
+	}
+
+	remoteDelete = REMOTE_DELETE_REMOVE
+	deletedOnRemote(t, "/removed", "removed")
+	if _, err := os.Lstat(localPath("/removed")); !os.IsNotExist(err) {
+		t.Errorf("file deleted on remote still in place; %v", err)
+	}
+	if got := trashed(t, "/removed"); len(got) != 0 {
+		t.Errorf("trash holds %q with -remote-delete remove", got)
+	}
+}
+
+// Only trash older than the retention is emptied
+func TestCleanupTrash(t *testing.T) {
+	useTestQueue(t)
+	old := time.Now().Add(-2 * time.Hour).Format(TRASH_TIME_FORMAT)
+	recent := time.Now().Add(-time.Minute).Format(TRASH_TIME_FORMAT)
+	for _, name := range []string{old, recent, "not-trash"} {
+		err := os.MkdirAll(filepath.Join(trashDir(), name), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cleanupTrash(ctx, time.Hour)
+
+	for name, want := range map[string]bool{old: false, recent: true, "not-trash": true} {
+		_, err := os.Stat(filepath.Join(trashDir(), name))
+		if got := err == nil; got != want {
+			t.Errorf("%v kept = %v; want %v", name, got, want)
+		}
+	}
+}
+
+// Answers Lookup of /kept, fails that of /unsure and has nothing else
+type deleteLookupServer struct {
+	proto.UnimplementedFuseServer
+}
+
+func (deleteLookupServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
+	switch req.Path {
+	case "/kept":
+		return &proto.DirEntry{Path: req.Path, Mode: 0644}, nil
+	case "/unsure":
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return nil, status.Error(codes.NotFound, "no such file")
+}
+
+// With -confirm-deletes only a delete remote confirms with a not found
+// removes the local file
+func TestConfirmedRemoteDeletes(t *testing.T) {
+	useTestQueue(t)
+	useTestRemote(t, deleteLookupServer{})
+	useRemoteDelete(t, REMOTE_DELETE_REMOVE, true)
+
+	for path, want := range map[string]bool{"/kept": true, "/unsure": true, "/gone": false} {
+		deletedOnRemote(t, path, "contents")
+		_, err := os.Lstat(localPath(path))
+		if got := err == nil; got != want {
+			t.Errorf("%v kept after delete event = %v; want %v", path, got, want)
+		}
+	}
+}
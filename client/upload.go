@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"syscall"
+
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Sequential writes to an open file, appends included, are sent over
+// an UploadFile stream instead of a Write request each. Remote applies
+// them in order through a single open file instead of opening it
+// again for every write. Writes are handed to the stream as they come;
+// those that queue up behind a slow remote are joined into chunks of
+// up to lib.CHUNK_SIZE. A stream left without writes for UPLOAD_IDLE
+// is ended and a new one started by the next write, so open handles
+// don't hold streams on remote. Other writes keep using a Write request
+// each. A stream that fails is made up for by uploading the whole
+// file, so remote never keeps a gap
+const (
+	// Writes waiting to be sent before writers start waiting on remote
+	UPLOAD_QUEUE = 16
+
+	// How long a stream is kept open without writes
+	UPLOAD_IDLE = 2 * time.Second
+)
+
+type uploadStream struct {
+	path      string // relative path
+	appending bool   // remote writes each chunk at its end
+
+	chunks chan *proto.UploadChunk
+	result chan error
+
+	// Only used by run
+	held   *proto.UploadChunk // received but not sent yet
+	closed bool               // chunks was closed
+}
+
+// Reports whether a write at off continues the handle's writes
+// closely enough to be streamed. Called with fh.mu held
+func (fh *FileHandle) streamsWrite(off int64, size int) bool {
+	if fh.upload != nil {
+		return true
+	}
+	if !remoteSupports(lib.FEATURE_UPLOAD) {
+		return false
+	}
+	return fh.flags&syscall.O_APPEND != 0 || size >= lib.CHUNK_SIZE || fh.writeEnd > 0 && off == fh.writeEnd
+}
+
+// Queues data, written at remote offset off, on the handle's upload
+// stream, starting one if needed. Called with fh.mu held
+func (fh *FileHandle) streamWrite(path string, data []byte, off int64, appending bool) {
+	if fh.upload == nil {
+		// Writes sent one by one go first
+		fh.waitSent()
+		fh.upload = startUpload(path, appending)
+	}
+	fh.upload.chunks <- &proto.UploadChunk{
+		Path:   path,
+		Offset: off,
+		Data:   bytes.Clone(data),
+		Append: fh.upload.appending,
+	}
+}
+
+func startUpload(path string, appending bool) *uploadStream {
+	u := &uploadStream{
+		path:      path,
+		appending: appending,
+		chunks:    make(chan *proto.UploadChunk, UPLOAD_QUEUE),
+		result:    make(chan error, 1),
+	}
+	go u.run()
+	return u
+}
+
+// Ends the stream and waits for remote to apply what was written
+func (u *uploadStream) finish() error {
+	close(u.chunks)
+	return <-u.result
+}
+
+func (u *uploadStream) run() {
+	var err error
+	for {
+		chunk := u.receive(nil)
+		if chunk == nil {
+			break
+		}
+		if err != nil {
+			// Drained so writes don't block; the file is uploaded
+			// whole instead
+			continue
+		}
+		err = u.upload(chunk)
+	}
+	u.result <- err
+}
+
+// Sends chunk and the writes that follow it over one UploadFile call,
+// which ends once no write came for UPLOAD_IDLE
+func (u *uploadStream) upload(chunk *proto.UploadChunk) error {
+	done := beginSend(queuedOp{Op: OP_UPLOAD, Path: u.path})
+	defer done()
+
+	// Each message gets its own deadline instead of the stream, which
+	// lives as long as writes keep coming
+	ctx, cancel := context.WithCancel(NewAuthenticatedCtx(context.Background()))
+	defer cancel()
+	ctx, tr, finished := startTransfer(ctx, u.path, TRANSFER_UPLOAD)
+	defer finished()
+
+	stream, err := grpcClient.UploadFile(ctx)
+	if err != nil {
+		return err
+	}
+	for chunk != nil {
+		chunk = u.join(chunk)
+		err = withSyncTimeout(cancel, func() error {
+			return stream.Send(chunk)
+		})
+		if err != nil {
+			break
+		}
+		err = tr.moved(ctx, len(chunk.Data))
+		if err != nil {
+			break
+		}
+
+		idle := time.NewTimer(UPLOAD_IDLE)
+		chunk = u.receive(idle.C)
+		idle.Stop()
+	}
+	if err == nil || err == io.EOF {
+		// Send only reports that the stream ended; the reason comes
+		// back with the response
+		err = withSyncTimeout(cancel, func() error {
+			_, err := stream.CloseAndRecv()
+			return err
+		})
+	}
+	return err
+}
+
+// Takes the next write, waiting until idle fires at most. nil idle
+// waits until the handle is done with the stream. Returns nil if no
+// write came
+func (u *uploadStream) receive(idle <-chan time.Time) *proto.UploadChunk {
+	if u.held != nil {
+		chunk := u.held
+		u.held = nil
+		return chunk
+	}
+	if u.closed {
+		return nil
+	}
+
+	select {
+	case chunk, ok := <-u.chunks:
+		if !ok {
+			u.closed = true
+			return nil
+		}
+		return chunk
+	case <-idle:
+		return nil
+	}
+}
+
+// Joins the writes already queued behind chunk that continue it, up
+// to lib.CHUNK_SIZE
+func (u *uploadStream) join(chunk *proto.UploadChunk) *proto.UploadChunk {
+	for len(chunk.Data) < lib.CHUNK_SIZE && u.held == nil && !u.closed {
+		select {
+		case next, ok := <-u.chunks:
+			if !ok {
+				u.closed = true
+				break
+			}
+			follows := u.appending || next.Offset == chunk.Offset+int64(len(chunk.Data))
+			if !follows || len(chunk.Data)+len(next.Data) > lib.CHUNK_SIZE {
+				u.held = next
+				break
+			}
+			chunk.Data = append(chunk.Data, next.Data...)
+		default:
+			return chunk
+		}
+	}
+	return chunk
+}
+
+// Runs call on a stream, cancelling the stream if call takes longer
+// than -sync-timeout
+func withSyncTimeout(cancel context.CancelFunc, call func() error) error {
+	if syncTimeout > 0 {
+		timer := time.AfterFunc(syncTimeout, cancel)
+		defer timer.Stop()
+	}
+	return call()
+}
+
+// Ends the handle's upload stream, if any, and waits for remote to
+// apply it. Called with fh.mu held
+func (fh *FileHandle) finishUpload() {
+	if fh.upload == nil {
+		return
+	}
+	u := fh.upload
+	fh.upload = nil
+
+	err := u.finish()
+	if err == nil {
+		return
+	}
+	if markRemoteFull(u.path, err) {
+		// Sent whole once remote has room; see remoteFullErrno
+		log.Printf("[FUSE] Error streaming writes to %v; %v\n", u.path, err)
+		return
+	}
+
+	log.Printf("[FUSE] Error streaming writes to %v; uploading the whole file; %v\n", u.path, err)
+	op := queuedOp{Op: OP_UPLOAD, Path: u.path}
+	go func() {
+		err := sendOrQueue(op, func(ctx context.Context) error {
+			return uploadLocal(ctx, u.path)
+		})
+		if err != nil {
+			markRemoteFull(u.path, err)
+			log.Printf("[FUSE] Error uploading file %v; %v\n", u.path, err)
+			return
+		}
+		clearRemoteFull(u.path)
+	}()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Records the chunks of every UploadFile call
+type uploadServer struct {
+	proto.UnimplementedFuseServer
+	err error
+
+	mu      sync.Mutex
+	streams [][]*proto.UploadChunk
+}
+
+func (s *uploadServer) UploadFile(stream grpc.ClientStreamingServer[proto.UploadChunk, proto.UploadResponse]) error {
+	var chunks []*proto.UploadChunk
+	written := uint64(0)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if s.err != nil {
+			return s.err
+		}
+		chunks = append(chunks, chunk)
+		written += uint64(len(chunk.Data))
+	}
+
+	s.mu.Lock()
+	s.streams = append(s.streams, chunks)
+	s.mu.Unlock()
+	return stream.SendAndClose(&proto.UploadResponse{BytesWritten: written})
+}
+
+// Applies the recorded chunks to an empty file
+func (s *uploadServer) contents() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var file []byte
+	for _, chunks := range s.streams {
+		for _, chunk := range chunks {
+			off := int(chunk.Offset)
+			if chunk.Append {
+				off = len(file)
+			}
+			if end := off + len(chunk.Data); end > len(file) {
+				file = append(file, make([]byte, end-len(file))...)
+			}
+			copy(file[off:], chunk.Data)
+		}
+	}
+	return file
+}
+
+func useTestRemote(t testing.TB, srv proto.FuseServer) {
+	old := grpcClient
+	grpcClient = newTestClient(t, srv)
+	t.Cleanup(func() { grpcClient = old })
+}
+
+func TestUploadStreamWritesInOrder(t *testing.T) {
+	srv := &uploadServer{}
+	useTestRemote(t, srv)
+
+	fh := &FileHandle{}
+	var want []byte
+	for i := range 200 {
+		data := bytes.Repeat([]byte{byte(i)}, 1000)
+		fh.streamWrite("file", data, int64(len(want)), false)
+		want = append(want, data...)
+	}
+	fh.finishUpload()
+
+	if got := srv.contents(); !bytes.Equal(got, want) {
+		t.Fatalf("remote has %v bytes; want the %v written", len(got), len(want))
+	}
+	for _, chunks := range srv.streams {
+		for _, chunk := range chunks {
+			if len(chunk.Data) > lib.CHUNK_SIZE {
+				t.Errorf("chunk of %v bytes; want at most %v", len(chunk.Data), lib.CHUNK_SIZE)
+			}
+			if chunk.Path != "file" {
+				t.Errorf("chunk for %q; want file", chunk.Path)
+			}
+		}
+	}
+}
+
+func TestUploadStreamKeepsGaps(t *testing.T) {
+	srv := &uploadServer{}
+	useTestRemote(t, srv)
+
+	fh := &FileHandle{}
+	fh.streamWrite("file", []byte("abc"), 0, false)
+	fh.streamWrite("file", []byte("xyz"), 10, false)
+	fh.finishUpload()
+
+	want := append([]byte("abc"), make([]byte, 7)...)
+	want = append(want, "xyz"...)
+	if got := srv.contents(); !bytes.Equal(got, want) {
+		t.Errorf("remote has %q; want %q", got, want)
+	}
+}
+
+func TestUploadStreamAppends(t *testing.T) {
+	srv := &uploadServer{}
+	useTestRemote(t, srv)
+
+	fh := &FileHandle{}
+	fh.streamWrite("log", []byte("one\n"), 0, true)
+	fh.streamWrite("log", []byte("two\n"), 0, true)
+	fh.finishUpload()
+
+	for _, chunks := range srv.streams {
+		for _, chunk := range chunks {
+			if !chunk.Append {
+				t.Error("appending stream sent a chunk without Append")
+			}
+		}
+	}
+	if got := string(srv.contents()); got != "one\ntwo\n" {
+		t.Errorf("remote has %q; want both lines", got)
+	}
+}
+
+// Writes reach remote while the handle stays open, and streams end
+// when writes stop
+func TestUploadStreamEndsWhenIdle(t *testing.T) {
+	srv := &uploadServer{}
+	useTestRemote(t, srv)
+
+	fh := &FileHandle{}
+	fh.streamWrite("file", []byte("first"), 0, false)
+	time.Sleep(UPLOAD_IDLE + 500*time.Millisecond)
+
+	srv.mu.Lock()
+	streams := len(srv.streams)
+	srv.mu.Unlock()
+	if streams != 1 {
+		t.Fatalf("%v streams finished before the handle was; want the idle one", streams)
+	}
+
+	fh.streamWrite("file", []byte("second"), 5, false)
+	fh.finishUpload()
+	if len(srv.streams) != 2 {
+		t.Errorf("%v streams; want 2", len(srv.streams))
+	}
+	if got := string(srv.contents()); got != "firstsecond" {
+		t.Errorf("remote has %q; want firstsecond", got)
+	}
+}
+
+func TestUploadStreamFailureDoesNotBlock(t *testing.T) {
+	srv := &uploadServer{err: status.Error(codes.NotFound, "gone")}
+	useTestRemote(t, srv)
+
+	u := startUpload("file", false)
+	done := make(chan struct{})
+	go func() {
+		// More than fit in the queue
+		for i := range 4 * UPLOAD_QUEUE {
+			u.chunks <- &proto.UploadChunk{Path: "file", Offset: int64(i), Data: []byte{1}}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked after the stream failed")
+	}
+	if err := u.finish(); status.Code(err) != codes.NotFound {
+		t.Errorf("finish = %v; want NotFound", err)
+	}
+}
+
+// Remote ops a handle starts run one after another in the order they
+// were started, however long each takes
+func TestSendInOrder(t *testing.T) {
+	fh := &FileHandle{}
+	mu := sync.Mutex{}
+	got := []int{}
+	for i := range 5 {
+		fh.sendInOrder(func() {
+			// Later ops would finish first if run side by side
+			time.Sleep(time.Duration(5-i) * time.Millisecond)
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+		})
+	}
+	fh.waitSent()
+
+	for i, op := range got {
+		if op != i {
+			t.Fatalf("ops ran in order %v", got)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("ran %d of 5 ops", len(got))
+	}
+}
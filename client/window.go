@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Background syncs, ie. downloading files nobody asked for yet and
+// reconciling with remote, can be limited to the -sync-window hours.
+// Outside them remote files are deferred like large files are and
+// downloaded the moment they are opened; once a window opens the
+// deferred files are downloaded in the background
+
+const SYNC_WINDOW_ALWAYS = "always"
+
+// Local time of day a window opens and closes, counted from midnight.
+// Windows with end before start run past midnight
+type syncWindow struct {
+	start, end time.Duration
+}
+
+var (
+	syncWindows []syncWindow
+
+	// Clock the windows are checked against
+	windowNow = time.Now
+
+	// Files deferred only because the window was closed
+	windowDeferred   = make(map[string]bool)
+	windowDeferredMu = sync.Mutex{}
+)
+
+func init() {
+	registerStatus("sync_window", func() any {
+		if inSyncWindow(windowNow()) {
+			return "open"
+		}
+		return "closed"
+	})
+}
+
+// Parses windows in the format 22:00-06:00,12:00-13:00
+func parseSyncWindows(value string) ([]syncWindow, error) {
+	if value == SYNC_WINDOW_ALWAYS {
+		return nil, nil
+	}
+
+	windows := []syncWindow{}
+	for _, entry := range strings.Split(value, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(entry), "-")
+		if !ok {
+			return nil, fmt.Errorf("expected HH:MM-HH:MM but got %q", entry)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("window %q is empty", entry)
+		}
+		windows = append(windows, syncWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q; expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+func (w syncWindow) contains(t time.Time) bool {
+	day := timeOfDay(t)
+	if w.start < w.end {
+		return day >= w.start && day < w.end
+	}
+	return day >= w.start || day < w.end
+}
+
+// Reports whether background syncs may run at t
+func inSyncWindow(t time.Time) bool {
+	if len(syncWindows) == 0 {
+		return true
+	}
+	for _, w := range syncWindows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// How long after t the next window opens
+func untilSyncWindow(t time.Time) time.Duration {
+	day := timeOfDay(t)
+	waits := []time.Duration{}
+	for _, w := range syncWindows {
+		wait := w.start - day
+		if wait < 0 {
+			wait += 24 * time.Hour
+		}
+		waits = append(waits, wait)
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	return waits[0]
+}
+
+// Blocks until background syncs may run. Returns false if ctx ends
+// first
+func waitForSyncWindow(ctx context.Context) bool {
+	for !inSyncWindow(windowNow()) {
+		wait := untilSyncWindow(windowNow())
+		log.Printf("[SYNC] Outside the sync window; background syncs resume in %v\n", wait.Round(time.Minute))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+	return true
+}
+
+// Defers the download of remote file path if the sync window is
+// closed. Reports whether it did
+func deferOutsideWindow(path string, size uint64, mode uint32) bool {
+	if inSyncWindow(windowNow()) {
+		return false
+	}
+	deferDownload(path, size, mode)
+
+	windowDeferredMu.Lock()
+	windowDeferred[path] = true
+	windowDeferredMu.Unlock()
+	return true
+}
+
+// Downloads the files deferred while the window was closed each time
+// it opens. Should be run as a goroutine
+func runSyncWindow(ctx context.Context) {
+	if len(syncWindows) == 0 {
+		return
+	}
+
+	for waitForSyncWindow(ctx) {
+		windowDeferredMu.Lock()
+		paths := make([]string, 0, len(windowDeferred))
+		for path := range windowDeferred {
+			paths = append(paths, path)
+		}
+		windowDeferredMu.Unlock()
+
+		for _, path := range paths {
+			if !inSyncWindow(windowNow()) || !online.Load() {
+				break
+			}
+			err := fetchOnDemand(path)
+			if err != nil {
+				log.Printf("[SYNC] Error downloading deferred file %v; %v\n", path, err)
+				continue
+			}
+			windowDeferredMu.Lock()
+			delete(windowDeferred, path)
+			windowDeferredMu.Unlock()
+		}
+
+		// Check again once this window is over
+		timer := time.NewTimer(time.Minute)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
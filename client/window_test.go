@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Sets -sync-window to value and checks it against a clock the test
+// moves, starting at hour:minute today
+func useTestWindow(t *testing.T, value string, hour, minute int) *atomic.Int64 {
+	t.Helper()
+	windows, err := parseSyncWindows(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &atomic.Int64{}
+	setClock(clock, hour, minute)
+
+	oldWindows, oldNow := syncWindows, windowNow
+	syncWindows = windows
+	windowNow = func() time.Time { return time.Unix(0, clock.Load()) }
+	t.Cleanup(func() {
+		syncWindows, windowNow = oldWindows, oldNow
+		windowDeferredMu.Lock()
+		clear(windowDeferred)
+		windowDeferredMu.Unlock()
+		onDemandMu.Lock()
+		clear(onDemand)
+		onDemandMu.Unlock()
+	})
+	return clock
+}
+
+func setClock(clock *atomic.Int64, hour, minute int) {
+	now := time.Now()
+	clock.Store(time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, time.Local).UnixNano())
+}
+
+func TestParseSyncWindows(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{SYNC_WINDOW_ALWAYS, 0, true},
+		{"22:00-06:00", 1, true},
+		{"22:00-06:00, 12:00-13:00", 2, true},
+		{"22:00", 0, false},
+		{"22:00-24:30", 0, false},
+		{"10:00-10:00", 0, false},
+		{"evening", 0, false},
+	}
+	for _, test := range tests {
+		windows, err := parseSyncWindows(test.value)
+		if (err == nil) != test.ok || len(windows) != test.want {
+			t.Errorf("parseSyncWindows(%q) = %v windows, %v; want %v windows, ok %v", test.value, len(windows), err, test.want, test.ok)
+		}
+	}
+}
+
+// Windows past midnight wrap around and the next window is the
+// nearest start, tomorrow's if today's have all passed
+func TestInSyncWindow(t *testing.T) {
+	clock := useTestWindow(t, "22:00-06:00,12:00-13:00", 0, 0)
+
+	tests := []struct {
+		hour, minute int
+		open         bool
+		until        time.Duration
+	}{
+		{23, 0, true, 0},
+		{5, 59, true, 0},
+		{6, 0, false, 6 * time.Hour},
+		{9, 30, false, 2*time.Hour + 30*time.Minute},
+		{12, 30, true, 0},
+		{13, 0, false, 9 * time.Hour},
+	}
+	for _, test := range tests {
+		setClock(clock, test.hour, test.minute)
+		now := windowNow()
+		if got := inSyncWindow(now); got != test.open {
+			t.Errorf("window open at %v = %v; want %v", now.Format("15:04"), got, test.open)
+		}
+		if test.open {
+			continue
+		}
+		if got := untilSyncWindow(now); got != test.until {
+			t.Errorf("next window at %v opens in %v; want %v", now.Format("15:04"), got, test.until)
+		}
+	}
+}
+
+// Outside the window remote files are deferred rather than downloaded
+// and background syncs wait; the deferred files are downloaded once
+// the window opens. Opening a file fetches it whatever the hour
+func TestBackgroundSyncsWaitForWindow(t *testing.T) {
+	useTestListings(t)
+	useTestQueue(t)
+	srv := &largeFileServer{size: 8, contents: []byte("contents")}
+	useTestRemote(t, srv)
+	online.Store(true)
+	clock := useTestWindow(t, "22:00-06:00", 9, 0)
+
+	err := fetchRemoteEntries(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.downloads.Load(); n != 0 {
+		t.Fatalf("file downloaded %v times outside the window; want 0", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if waitForSyncWindow(ctx) {
+		t.Fatal("background syncs went ahead outside the window")
+	}
+
+	run := func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			runSyncWindow(ctx)
+			close(done)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		<-done
+	}
+	run()
+	if n := srv.downloads.Load(); n != 0 {
+		t.Fatalf("deferred file downloaded %v times outside the window; want 0", n)
+	}
+
+	setClock(clock, 23, 0)
+	run()
+	if n := srv.downloads.Load(); n != 1 {
+		t.Fatalf("deferred file downloaded %v times once the window opened; want 1", n)
+	}
+	windowDeferredMu.Lock()
+	left := len(windowDeferred)
+	windowDeferredMu.Unlock()
+	if left != 0 {
+		t.Errorf("%v files still deferred after the window opened; want 0", left)
+	}
+
+	// Files opened outside the window are not held back
+	setClock(clock, 9, 0)
+	deferOutsideWindow("/large", srv.size, 0644)
+	err = fetchOnDemand("/large")
+	if err != nil || srv.downloads.Load() != 2 {
+		t.Errorf("file opened outside the window = %v, %v downloads; want it downloaded", err, srv.downloads.Load())
+	}
+}
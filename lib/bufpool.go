@@ -0,0 +1,30 @@
+package lib
+
+import "sync"
+
+// Size of the chunks files are downloaded in
+const CHUNK_SIZE = 64 * 1024
+
+// Chunk buffers shared by every transfer so that many concurrent
+// downloads don't each allocate their own. Pointers are pooled to
+// keep Put from allocating
+var chunkPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, CHUNK_SIZE)
+		return &buf
+	},
+}
+
+// Returns a CHUNK_SIZE buffer. Hand it back with PutChunk once
+// nothing refers to it anymore
+func GetChunk() *[]byte {
+	return chunkPool.Get().(*[]byte)
+}
+
+func PutChunk(buf *[]byte) {
+	if cap(*buf) < CHUNK_SIZE {
+		return
+	}
+	*buf = (*buf)[:CHUNK_SIZE]
+	chunkPool.Put(buf)
+}
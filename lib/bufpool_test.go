@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Reads a 1MB file chunk by chunk, as a download does, with a buffer
+// from get
+func transfer(b *testing.B, data []byte, get func() *[]byte, put func(*[]byte)) {
+	r := bytes.NewReader(data)
+	buf := get()
+	defer put(buf)
+	for {
+		_, err := r.Read(*buf)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Concurrent transfers with a buffer allocated for each, as before the
+// pool, and with pooled buffers
+func BenchmarkChunkBuffers(b *testing.B) {
+	data := make([]byte, 1024*1024)
+	fresh := func() *[]byte {
+		buf := make([]byte, CHUNK_SIZE)
+		return &buf
+	}
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				transfer(b, data, fresh, func(*[]byte) {})
+			}
+		})
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				transfer(b, data, GetChunk, PutChunk)
+			}
+		})
+	})
+}
+
+func TestPutChunkKeepsFullSize(t *testing.T) {
+	buf := GetChunk()
+	*buf = (*buf)[:10]
+	PutChunk(buf)
+	for range 10 {
+		buf := GetChunk()
+		if len(*buf) != CHUNK_SIZE {
+			t.Fatalf("pooled buffer of %v bytes; want %v", len(*buf), CHUNK_SIZE)
+		}
+		PutChunk(buf)
+	}
+
+	small := make([]byte, 10)
+	PutChunk(&small) // too small to pool; dropped
+}
@@ -0,0 +1,35 @@
+package lib
+
+// Version of fusion reported by the server in its Hello reply
+const VERSION = "0.2.0"
+
+// Optional features a server can report in Hello. Clients only use
+// them when the server lists them; servers from before Hello existed
+// are assumed to support none
+const (
+	// GetManifest streams hashed directory listings
+	FEATURE_MANIFEST = "manifest"
+	// Copy duplicates a file without uploading it again
+	FEATURE_COPY = "copy"
+	// Statfs reports the org's inode quota
+	FEATURE_STATFS = "statfs"
+	// DownloadArchive streams a directory as a tar
+	FEATURE_ARCHIVE = "archive"
+	// ListVersions and RestoreVersion work. Only reported when the
+	// server keeps versions
+	FEATURE_VERSIONS = "versions"
+	// PutFile creates or replaces small files in one call
+	FEATURE_PUT = "put"
+	// UploadFile streams writes to one file
+	FEATURE_UPLOAD = "upload"
+)
+
+// Features this build of the server supports
+var FEATURES = []string{
+	FEATURE_MANIFEST,
+	FEATURE_COPY,
+	FEATURE_STATFS,
+	FEATURE_ARCHIVE,
+	FEATURE_PUT,
+	FEATURE_UPLOAD,
+}
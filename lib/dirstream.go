@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var _ = (fs.DirStream)((*SnapshotDirStream)(nil))
+var _ = (fs.FileSeekdirer)((*SnapshotDirStream)(nil))
+
+// Directory listing that stays the same for as long as the directory
+// is open. The kernel resumes a listing from the offset of the last
+// entry it was given, so offsets must keep pointing at the same entries
+// while files are created and removed around a long running ls.
+//
+// Entries are sorted by name with duplicates dropped and their offsets
+// are their position in the snapshot counting from 1. Seeking back to
+// 0 (rewinddir) takes a new snapshot
+type SnapshotDirStream struct {
+	list    func() ([]fuse.DirEntry, error)
+	entries []fuse.DirEntry
+	idx     int
+}
+
+// Snapshots the entries returned by list
+func NewSnapshotDirStream(list func() ([]fuse.DirEntry, error)) (*SnapshotDirStream, syscall.Errno) {
+	ds := &SnapshotDirStream{list: list}
+	errno := ds.snapshot()
+	if errno != 0 {
+		return nil, errno
+	}
+	return ds, 0
+}
+
+func (ds *SnapshotDirStream) snapshot() syscall.Errno {
+	entries, err := ds.list()
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+
+	slices.SortStableFunc(entries, func(a, b fuse.DirEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	entries = slices.CompactFunc(entries, func(a, b fuse.DirEntry) bool {
+		return a.Name == b.Name
+	})
+	for i := range entries {
+		entries[i].Off = uint64(i + 1)
+	}
+
+	ds.entries = entries
+	ds.idx = 0
+	return 0
+}
+
+func (ds *SnapshotDirStream) HasNext() bool {
+	return ds.idx < len(ds.entries)
+}
+
+func (ds *SnapshotDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	entry := ds.entries[ds.idx]
+	ds.idx++
+	return entry, 0
+}
+
+func (ds *SnapshotDirStream) Seekdir(ctx context.Context, off uint64) syscall.Errno {
+	if off == 0 {
+		return ds.snapshot()
+	}
+	if off > uint64(len(ds.entries)) {
+		return syscall.EINVAL
+	}
+	ds.idx = int(off)
+	return 0
+}
+
+func (ds *SnapshotDirStream) Close() {}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Reads the rest of ds, checking each offset is one past the last
+func readStream(t *testing.T, ds *SnapshotDirStream, off uint64) []string {
+	t.Helper()
+	names := []string{}
+	for ds.HasNext() {
+		entry, errno := ds.Next()
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		if entry.Off != off+1 {
+			t.Errorf("%v at offset %v; want %v", entry.Name, entry.Off, off+1)
+		}
+		off = entry.Off
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// Files added and removed during a listing, resumed from an offset as
+// the kernel does, neither show up nor shift what is listed. A rewind
+// sees them
+func TestSnapshotDirStreamStable(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{}
+	for i := range 20 {
+		name := "file" + strconv.Itoa(10+i)
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	ds, errno := NewSnapshotDirStream(func() ([]fuse.DirEntry, error) {
+		return ReadDir(dir)
+	})
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+
+	got := []string{}
+	var off uint64
+	for range 10 {
+		entry, _ := ds.Next()
+		got = append(got, entry.Name)
+		off = entry.Off
+	}
+	// Names before and after where the listing got to
+	for _, name := range []string{"file00", "file15x", "file99"} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Remove(filepath.Join(dir, "file12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errno := ds.Seekdir(context.Background(), off); errno != 0 {
+		t.Fatalf("Seekdir(%v) = %v", off, errno)
+	}
+	got = append(got, readStream(t, ds, off)...)
+	if !slices.Equal(got, want) {
+		t.Errorf("listing = %q; want the snapshot %q", got, want)
+	}
+
+	if errno := ds.Seekdir(context.Background(), 0); errno != 0 {
+		t.Fatalf("rewinddir = %v", errno)
+	}
+	got = readStream(t, ds, 0)
+	want = append(slices.DeleteFunc(want, func(name string) bool { return name == "file12" }), "file00", "file15x", "file99")
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("listing after rewinddir = %q; want %q", got, want)
+	}
+
+	if errno := ds.Seekdir(context.Background(), uint64(len(got)+1)); errno == 0 {
+		t.Error("seek past the end of the snapshot succeeded")
+	}
+}
+
+// Names listed twice, as a local file and its remote copy are, come
+// once
+func TestSnapshotDirStreamDropsDuplicates(t *testing.T) {
+	ds, _ := NewSnapshotDirStream(func() ([]fuse.DirEntry, error) {
+		return []fuse.DirEntry{{Name: "b"}, {Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "a"}}, nil
+	})
+	if got := readStream(t, ds, 0); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("listing = %q; want %q", got, []string{"a", "b", "c"})
+	}
+}
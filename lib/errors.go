@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Domain of the ErrorInfo detail StatusError attaches to errors that
+// came from a syscall. Its reason is the errno's name, eg. ENOTEMPTY,
+// so that clients get back the exact errno even where several share a
+// gRPC code
+const ERRNO_DOMAIN = "fusion.errno"
+
+// Domain and reason of the ErrorInfo detail on the Unavailable errors
+// the server returns for changes made while it is in maintenance.
+// Clients match on the detail; the message is for people
+const (
+	FUSION_DOMAIN       = "fusion"
+	MAINTENANCE_REASON  = "MAINTENANCE"
+	MAINTENANCE_MESSAGE = "server in maintenance"
+)
+
+// Error the server returns for changes made during maintenance
+func MaintenanceError() error {
+	st := status.New(codes.Unavailable, MAINTENANCE_MESSAGE)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: MAINTENANCE_REASON,
+		Domain: FUSION_DOMAIN,
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// Reports whether err is the server refusing a change because it is
+// in maintenance
+func IsMaintenance(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return false
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if ok && info.Domain == FUSION_DOMAIN && info.Reason == MAINTENANCE_REASON {
+			return true
+		}
+	}
+	return false
+}
+
+// Maps an error returned by the filesystem onto a gRPC status. Errors
+// that already carry a status are returned as they are
+func StatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return status.Error(errorCode(err), err.Error())
+	}
+
+	var st *status.Status
+	switch errno {
+	case syscall.EIO:
+		// I/O error, often indicates a physical disk failure
+		st = status.Newf(codes.Internal, "I/O error: %v", err)
+	case syscall.ENOSPC, syscall.EDQUOT:
+		st = status.Newf(codes.ResourceExhausted, "no space left on device: %v", err)
+	case syscall.EINVAL:
+		st = status.Newf(codes.InvalidArgument, "invalid system call argument: %v", err)
+	default:
+		st = status.New(errnoCode(errno), err.Error())
+	}
+
+	detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: unix.ErrnoName(errno),
+		Domain: ERRNO_DOMAIN,
+	})
+	if detailErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func errnoCode(errno syscall.Errno) codes.Code {
+	switch errno {
+	case syscall.ENOENT:
+		return codes.NotFound
+	case syscall.EACCES, syscall.EPERM:
+		return codes.PermissionDenied
+	case syscall.EEXIST:
+		return codes.AlreadyExists
+	case syscall.ETIMEDOUT:
+		return codes.DeadlineExceeded
+	case syscall.EINVAL, syscall.ENAMETOOLONG:
+		return codes.InvalidArgument
+	case syscall.ENOSPC, syscall.EDQUOT:
+		return codes.ResourceExhausted
+	case syscall.ENOTEMPTY, syscall.ENOTDIR, syscall.EISDIR, syscall.ELOOP, syscall.EROFS, syscall.EXDEV:
+		// The filesystem isn't in a state that allows the
+		// operation: a directory still has entries, a path crosses
+		// too many symlinks, the disk is read-only and so on
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
+
+// Code of an error that didn't come from a syscall
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return codes.NotFound
+	case errors.Is(err, os.ErrPermission):
+		return codes.PermissionDenied
+	case errors.Is(err, os.ErrExist):
+		return codes.AlreadyExists
+	case os.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	default:
+		return codes.Internal
+	}
+}
+
+// Reverse of unix.ErrnoName
+var errnoByName = sync.OnceValue(func() map[string]syscall.Errno {
+	names := make(map[string]syscall.Errno)
+	for errno := syscall.Errno(1); errno < 256; errno++ {
+		if name := unix.ErrnoName(errno); name != "" {
+			names[name] = errno
+		}
+	}
+	return names
+})
+
+// Maps an error returned by remote onto the errno reported to the
+// kernel. The reverse of StatusError
+func StatusErrno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return syscall.EIO
+	}
+	if errno, ok := DetailErrno(err); ok {
+		return errno
+	}
+
+	switch st.Code() {
+	case codes.OK:
+		return 0
+	case codes.NotFound:
+		return syscall.ENOENT
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return syscall.EACCES
+	case codes.AlreadyExists:
+		return syscall.EEXIST
+	case codes.InvalidArgument:
+		return syscall.EINVAL
+	case codes.ResourceExhausted:
+		return syscall.ENOSPC
+	case codes.DeadlineExceeded:
+		return syscall.ETIMEDOUT
+	case codes.Unavailable:
+		return syscall.ENOTCONN
+	case codes.Canceled:
+		return syscall.EINTR
+	default:
+		return syscall.EIO
+	}
+}
+
+// Returns the errno StatusError attached to err, if it has one. Unlike
+// StatusErrno it doesn't guess one from the code
+func DetailErrno(err error) (syscall.Errno, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != ERRNO_DOMAIN {
+			continue
+		}
+		if errno, ok := errnoByName()[info.Reason]; ok {
+			return errno, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusErrorCodes(t *testing.T) {
+	tests := []struct {
+		errno syscall.Errno
+		code  codes.Code
+	}{
+		{syscall.ENOENT, codes.NotFound},
+		{syscall.EACCES, codes.PermissionDenied},
+		{syscall.EPERM, codes.PermissionDenied},
+		{syscall.EEXIST, codes.AlreadyExists},
+		{syscall.ETIMEDOUT, codes.DeadlineExceeded},
+		{syscall.EINVAL, codes.InvalidArgument},
+		{syscall.ENAMETOOLONG, codes.InvalidArgument},
+		{syscall.ENOSPC, codes.ResourceExhausted},
+		{syscall.EDQUOT, codes.ResourceExhausted},
+		{syscall.ENOTEMPTY, codes.FailedPrecondition},
+		{syscall.ENOTDIR, codes.FailedPrecondition},
+		{syscall.EISDIR, codes.FailedPrecondition},
+		{syscall.ELOOP, codes.FailedPrecondition},
+		{syscall.EROFS, codes.FailedPrecondition},
+		{syscall.EXDEV, codes.FailedPrecondition},
+		{syscall.EIO, codes.Internal},
+		{syscall.EBUSY, codes.Internal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.errno.Error(), func(t *testing.T) {
+			// Wrapped the way os reports syscall failures
+			err := StatusError(&os.PathError{Op: "open", Path: "/file", Err: test.errno})
+			if code := status.Code(err); code != test.code {
+				t.Errorf("code = %v; want %v", code, test.code)
+			}
+			if errno := StatusErrno(err); errno != test.errno {
+				t.Errorf("StatusErrno = %v; want %v", errno, test.errno)
+			}
+		})
+	}
+}
+
+func TestStatusErrorWithoutErrno(t *testing.T) {
+	tests := []struct {
+		err   error
+		code  codes.Code
+		errno syscall.Errno
+	}{
+		{os.ErrNotExist, codes.NotFound, syscall.ENOENT},
+		{os.ErrPermission, codes.PermissionDenied, syscall.EACCES},
+		{fmt.Errorf("wrapped; %w", os.ErrExist), codes.AlreadyExists, syscall.EEXIST},
+		{context.DeadlineExceeded, codes.DeadlineExceeded, syscall.ETIMEDOUT},
+		{context.Canceled, codes.Canceled, syscall.EINTR},
+		{errors.New("anything else"), codes.Internal, syscall.EIO},
+	}
+
+	for _, test := range tests {
+		err := StatusError(test.err)
+		if code := status.Code(err); code != test.code {
+			t.Errorf("StatusError(%v) code = %v; want %v", test.err, code, test.code)
+		}
+		if errno := StatusErrno(err); errno != test.errno {
+			t.Errorf("StatusErrno(StatusError(%v)) = %v; want %v", test.err, errno, test.errno)
+		}
+	}
+}
+
+func TestStatusErrorKeepsStatus(t *testing.T) {
+	err := status.Error(codes.Aborted, "already a status")
+	if got := StatusError(err); got != err {
+		t.Errorf("StatusError changed an error that already had a status; got %v", got)
+	}
+	if StatusError(nil) != nil || StatusErrno(nil) != 0 {
+		t.Error("nil errors should map to nil and 0")
+	}
+}
+
+func TestIsMaintenance(t *testing.T) {
+	if !IsMaintenance(MaintenanceError()) {
+		t.Error("IsMaintenance(MaintenanceError()) = false")
+	}
+	// Only the detail counts, not the wording
+	if IsMaintenance(status.Error(codes.Unavailable, MAINTENANCE_MESSAGE)) {
+		t.Error("IsMaintenance matched an error without the maintenance detail")
+	}
+	if IsMaintenance(status.Error(codes.Unavailable, "connection refused")) {
+		t.Error("IsMaintenance matched a plain Unavailable error")
+	}
+}
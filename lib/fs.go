@@ -0,0 +1,434 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// Permission bits checked by CheckPermissions
+const (
+	R_OK = 4
+	W_OK = 2
+	X_OK = 1
+)
+
+const (
+	PATH_MAX = 4096
+
+	// Longest symlink target Readlink will return. Linux itself caps
+	// targets at PATH_MAX but other backing filesystems may not
+	MAX_LINK_SIZE = 64 * 1024
+
+	// Largest file PutFile takes in one piece. Anything bigger goes
+	// through Create and Write
+	MAX_PUT_SIZE = 256 * 1024
+)
+
+// Number of inodes the kernel currently holds a reference to.
+// Nodes increment it in OnAdd and decrement it in OnForget; a count
+// that keeps growing while the tree stays the same size is a leak
+var LiveInodes atomic.Int64
+
+// Lists directory entries of path in the format expected by
+// FUSE Readdir. Shared by both client and server nodes
+func ReadDir(path string) ([]fuse.DirEntry, error) {
+	entries := []fuse.DirEntry{}
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+
+		var mode uint32
+		switch {
+		case info.IsDir():
+			mode = fuse.S_IFDIR | uint32(info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			mode = syscall.S_IFLNK | uint32(info.Mode().Perm())
+		default:
+			mode = fuse.S_IFREG | uint32(info.Mode().Perm())
+		}
+
+		entries = append(entries, fuse.DirEntry{
+			Name: f.Name(),
+			Mode: mode,
+			Ino:  uint64(info.Sys().(*syscall.Stat_t).Ino),
+		})
+	}
+	return entries, nil
+}
+
+// Returns the target of symlink path. readlink(2) truncates targets
+// that don't fit its buffer without saying so, so the buffer is grown
+// until the target comes back shorter than it
+func Readlink(path string) ([]byte, syscall.Errno) {
+	for size := PATH_MAX; size <= MAX_LINK_SIZE; size *= 2 {
+		buf := make([]byte, size)
+		n, err := syscall.Readlink(path, buf)
+		if err != nil {
+			if errno, ok := err.(syscall.Errno); ok {
+				return nil, errno
+			}
+			return nil, syscall.EIO
+		}
+
+		if n < len(buf) {
+			return buf[:n], 0
+		}
+	}
+	return nil, syscall.ENAMETOOLONG
+}
+
+// Flushes path to disk. On a directory this makes the entries
+// created, renamed or removed in it durable
+func FsyncPath(path string) error {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	return syscall.Fsync(fd)
+}
+
+// Creates directory path with exactly the permission bits of mode.
+// mkdir(2) applies the umask and drops the setgid bit, so the mode is
+// set again once the directory exists
+func Mkdir(path string, mode uint32) error {
+	err := syscall.Mkdir(path, mode&07777)
+	if err != nil {
+		return err
+	}
+	return syscall.Chmod(path, mode&07777)
+}
+
+// File flags as set by chattr(1); see linux/fs.h
+const (
+	FS_IMMUTABLE_FL = 0x00000010
+	FS_APPEND_FL    = 0x00000020
+)
+
+// Flags that are synced. Others such as compression or no-COW only
+// make sense on the disk they were set on
+const SYNCED_FILE_FLAGS = FS_IMMUTABLE_FL | FS_APPEND_FL
+
+// Returns the FS_IOC_GETFLAGS flags of the open file fd
+func GetFileFlags(fd int) (uint32, error) {
+	return unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+}
+
+// Sets the SYNCED_FILE_FLAGS of the open file fd to those in flags.
+// Fails with EOPNOTSUPP if flags changes any other flag
+func SetFileFlags(fd int, flags uint32) error {
+	current, err := GetFileFlags(fd)
+	if err != nil {
+		return err
+	}
+	if (current^flags)&^SYNCED_FILE_FLAGS != 0 {
+		return syscall.EOPNOTSUPP
+	}
+	return unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, int(flags))
+}
+
+// Returns the FS_IOC_GETFLAGS flags of path. Fails with ELOOP on a
+// symlink
+func GetPathFlags(path string) (uint32, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Close(fd)
+	return GetFileFlags(fd)
+}
+
+// Like SetFileFlags but only changes the SYNCED_FILE_FLAGS of path,
+// leaving its other flags as they are
+func SetPathFlags(path string, flags uint32) error {
+	// Immutable and append-only files can't be opened for writing
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	current, err := GetFileFlags(fd)
+	if err != nil {
+		return err
+	}
+	return SetFileFlags(fd, current&^SYNCED_FILE_FLAGS|flags&SYNCED_FILE_FLAGS)
+}
+
+// Tells utimensat(2) to leave a time unchanged
+const UTIME_OMIT = (1 << 30) - 2
+
+func utimes(atime, mtime *time.Time) []unix.Timespec {
+	times := []unix.Timespec{
+		{Nsec: UTIME_OMIT},
+		{Nsec: UTIME_OMIT},
+	}
+	if atime != nil {
+		times[0] = unix.NsecToTimespec(atime.UnixNano())
+	}
+	if mtime != nil {
+		times[1] = unix.NsecToTimespec(mtime.UnixNano())
+	}
+	return times
+}
+
+// Sets the access and modification times of path, leaving the ones
+// that are nil unchanged
+func SetTimes(path string, atime, mtime *time.Time) error {
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, utimes(atime, mtime), 0)
+}
+
+// Like SetTimes but sets the times of a symlink itself rather than of
+// its target
+func LsetTimes(path string, atime, mtime *time.Time) error {
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, utimes(atime, mtime), unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// Like chmod(2) but fails with EOPNOTSUPP on a symlink instead of
+// changing its target. Linux has no lchmod, so the mode is set through
+// an O_PATH descriptor, which pins the file that was checked
+func Lchmod(path string, mode uint32) error {
+	fd, err := syscall.Open(path, unix.O_PATH|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	stat := syscall.Stat_t{}
+	err = syscall.Fstat(fd, &stat)
+	if err != nil {
+		return err
+	}
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+		return syscall.EOPNOTSUPP
+	}
+	return syscall.Chmod(fmt.Sprintf("/proc/self/fd/%v", fd), mode)
+}
+
+// Like truncate(2) but fails with ELOOP on a symlink instead of
+// truncating its target
+func Ltruncate(path string, size int64) error {
+	fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	return syscall.Ftruncate(fd, size)
+}
+
+// Like os.OpenFile on path relative to root, except the kernel
+// refuses to resolve it to anything outside root, whether through ".."
+// or a symlink, and fails with EXDEV instead. The check happens as the
+// file is opened, so a symlink swapped in after path was checked can't
+// lead out either. Kernels before 5.6 lack openat2(2); there path is
+// opened as is
+func OpenBeneath(root, path string, flags int, mode uint32) (*os.File, error) {
+	return openat2(root, path, flags, mode, unix.RESOLVE_BENEATH|unix.RESOLVE_NO_MAGICLINKS)
+}
+
+// Like OpenBeneath but fails with ELOOP on any symlink along path
+func OpenBeneathNoSymlinks(root, path string, flags int, mode uint32) (*os.File, error) {
+	return openat2(root, path, flags, mode, unix.RESOLVE_BENEATH|unix.RESOLVE_NO_SYMLINKS)
+}
+
+var openat2Missing atomic.Bool
+
+func openat2(root, path string, flags int, mode uint32, resolve uint64) (*os.File, error) {
+	rel := strings.TrimLeft(path, "/")
+	if rel == "" {
+		rel = "."
+	}
+	if openat2Missing.Load() {
+		return os.OpenFile(filepath.Join(root, rel), flags, os.FileMode(mode))
+	}
+
+	dirfd, err := syscall.Open(root, unix.O_PATH|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer syscall.Close(dirfd)
+
+	// Unlike open(2), openat2(2) refuses a mode it has no use for
+	if flags&(syscall.O_CREAT|unix.O_TMPFILE) == 0 {
+		mode = 0
+	}
+	fd, err := unix.Openat2(dirfd, rel, &unix.OpenHow{
+		Flags:   uint64(flags) | syscall.O_CLOEXEC,
+		Mode:    uint64(mode),
+		Resolve: resolve,
+	})
+	if err == syscall.ENOSYS {
+		log.Println("[WARN] openat2(2) not supported; paths are no longer kept beneath their root")
+		openat2Missing.Store(true)
+		return os.OpenFile(filepath.Join(root, rel), flags, os.FileMode(mode))
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(root, rel), Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root, rel)), nil
+}
+
+// Path the kernel has for an open file, with every symlink that led
+// to it resolved
+func FilePath(file *os.File) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/self/fd/%v", file.Fd()))
+}
+
+// Converts open(2) flags into the permission bits they require
+func AccessMask(flags uint32) uint32 {
+	switch int(flags) & syscall.O_ACCMODE {
+	case syscall.O_WRONLY:
+		return W_OK
+	case syscall.O_RDWR:
+		return R_OK | W_OK
+	default:
+		return R_OK
+	}
+}
+
+// Checks that the process calling into FUSE has the permission
+// bits in mask on path. Returns EACCES if it does not
+func CheckPermissions(ctx context.Context, path string, mask uint32) syscall.Errno {
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		// Not called from the kernel; nothing to check against
+		return 0
+	}
+
+	stat := syscall.Stat_t{}
+	err := syscall.Stat(path, &stat)
+	if err != nil {
+		if errno, ok := err.(syscall.Errno); ok {
+			return errno
+		}
+		return syscall.EIO
+	}
+	return checkMode(caller, &stat, mask)
+}
+
+// Same as CheckPermissions but checks against an existing stat
+// instead of statting a path
+func CheckStatPermissions(ctx context.Context, stat *syscall.Stat_t, mask uint32) syscall.Errno {
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		return 0
+	}
+	return checkMode(caller, stat, mask)
+}
+
+func checkMode(caller *fuse.Caller, stat *syscall.Stat_t, mask uint32) syscall.Errno {
+	if caller.Uid == 0 {
+		// root may read and write anything but still needs at
+		// least one execute bit to run a file
+		if mask&X_OK != 0 && stat.Mode&0111 == 0 && stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+			return syscall.EACCES
+		}
+		return 0
+	}
+
+	var perm uint32
+	switch {
+	case caller.Uid == stat.Uid:
+		perm = (stat.Mode >> 6) & 7
+	case callerInGroup(caller, stat.Gid):
+		perm = (stat.Mode >> 3) & 7
+	default:
+		perm = stat.Mode & 7
+	}
+
+	if perm&mask != mask {
+		return syscall.EACCES
+	}
+	return 0
+}
+
+// Reports whether caller is a member of group gid. Requests only carry
+// the caller's primary group; supplementary groups are read from /proc
+func callerInGroup(caller *fuse.Caller, gid uint32) bool {
+	if caller.Gid == gid {
+		return true
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", caller.Pid))
+	if err != nil {
+		// Gone already; its primary group is all we know
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		groups, ok := strings.CutPrefix(line, "Groups:")
+		if !ok {
+			continue
+		}
+		for _, group := range strings.Fields(groups) {
+			id, err := strconv.ParseUint(group, 10, 32)
+			if err == nil && uint32(id) == gid {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// Makes sure the mountpoint directory exists. If it does not and
+// create is set, the directory is created with mode 0755 and owned by
+// the user the filesystem is mounted as. Returns true if the
+// directory was created by us so callers can clean it up if mounting
+// fails
+func PrepareMountpoint(path string, create bool) (bool, error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return false, fmt.Errorf("mountpoint %v is not a directory", path)
+		}
+		return false, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+	if !create {
+		return false, fmt.Errorf("mountpoint %v does not exist", path)
+	}
+
+	err = os.Mkdir(path, 0755)
+	if err != nil {
+		return false, err
+	}
+
+	// Mkdir is subject to the umask and the parent's setgid bit; make
+	// sure the result matches what the mount will report
+	err = os.Chmod(path, 0755)
+	if err == nil {
+		err = os.Chown(path, os.Geteuid(), os.Getegid())
+	}
+	if err != nil {
+		os.Remove(path)
+		return false, err
+	}
+	return true, nil
+}
+
+// Removes a mountpoint created by PrepareMountpoint. Only empty
+// directories are removed
+func RemoveMountpoint(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing mountpoint %v; %v\n", path, err)
+	}
+}
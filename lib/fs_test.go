@@ -0,0 +1,348 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestOpenBeneath(t *testing.T) {
+	outside := t.TempDir()
+	err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	err = os.MkdirAll(filepath.Join(root, "dir"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, "dir", "file"), []byte("file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"inside":   "dir/file",
+		"up":       "../file",
+		"absolute": filepath.Join(outside, "secret"),
+		"escape":   "../../" + filepath.Base(outside) + "/secret",
+		"outdir":   outside,
+	}
+	for name, target := range links {
+		err = os.Symlink(target, filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink("../file", filepath.Join(root, "dir", "up"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		want     error
+		noLinks  error
+		contents string
+	}{
+		{"dir/file", nil, nil, "file"},
+		{"/dir/file", nil, nil, "file"},
+		{"inside", nil, syscall.ELOOP, "file"},
+		{"dir/up", syscall.ENOENT, syscall.ELOOP, ""},
+		{"up", syscall.EXDEV, syscall.ELOOP, ""},
+		{"absolute", syscall.EXDEV, syscall.ELOOP, ""},
+		{"escape", syscall.EXDEV, syscall.ELOOP, ""},
+		{"outdir/secret", syscall.EXDEV, syscall.ELOOP, ""},
+		{"../" + filepath.Base(outside) + "/secret", syscall.EXDEV, syscall.EXDEV, ""},
+	}
+	for _, test := range tests {
+		for _, noLinks := range []bool{false, true} {
+			open, want := OpenBeneath, test.want
+			if noLinks {
+				open, want = OpenBeneathNoSymlinks, test.noLinks
+			}
+
+			file, err := open(root, test.path, os.O_RDONLY, 0)
+			if openat2Missing.Load() {
+				t.Skip("openat2(2) not supported")
+			}
+			if !errors.Is(err, want) && !(want == nil && err == nil) {
+				t.Errorf("open %v (no symlinks %v) = %v; want %v", test.path, noLinks, err, want)
+			}
+			if err != nil {
+				continue
+			}
+			data := make([]byte, 16)
+			n, _ := file.Read(data)
+			file.Close()
+			if string(data[:n]) != test.contents {
+				t.Errorf("open %v read %q; want %q", test.path, data[:n], test.contents)
+			}
+		}
+	}
+}
+
+func TestOpenBeneathCreate(t *testing.T) {
+	root := t.TempDir()
+	file, err := OpenBeneath(root, "new", os.O_CREATE|os.O_WRONLY, 0640)
+	if openat2Missing.Load() {
+		t.Skip("openat2(2) not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	info, err := os.Stat(filepath.Join(root, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&^0640 != 0 {
+		t.Errorf("mode = %v; want at most 0640", info.Mode().Perm())
+	}
+
+	// A mode without O_CREAT is dropped rather than refused
+	file, err = OpenBeneath(root, "new", os.O_RDONLY, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+// Group permissions apply to supplementary groups as well as the
+// primary group the request carries
+func TestCheckModeSupplementaryGroups(t *testing.T) {
+	groups, err := os.Getgroups()
+	if err != nil || len(groups) == 0 {
+		t.Skip("no supplementary groups")
+	}
+	caller := &fuse.Caller{
+		Owner: fuse.Owner{Uid: 12345, Gid: 54321},
+		Pid:   uint32(os.Getpid()),
+	}
+	stat := &syscall.Stat_t{Uid: 1, Gid: uint32(groups[0]), Mode: syscall.S_IFREG | 0060}
+
+	if errno := checkMode(caller, stat, R_OK|W_OK); errno != 0 {
+		t.Errorf("checkMode by group member = %v; want access", errno)
+	}
+	stat.Mode = syscall.S_IFREG | 0006
+	if errno := checkMode(caller, stat, R_OK); errno != syscall.EACCES {
+		t.Errorf("checkMode without group bits = %v; want EACCES", errno)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	owner := &fuse.Caller{Owner: fuse.Owner{Uid: 1000, Gid: 1000}}
+	member := &fuse.Caller{Owner: fuse.Owner{Uid: 1001, Gid: 100}}
+	other := &fuse.Caller{Owner: fuse.Owner{Uid: 1002, Gid: 1002}}
+	root := &fuse.Caller{Owner: fuse.Owner{Uid: 0, Gid: 0}}
+
+	tests := []struct {
+		caller *fuse.Caller
+		mode   uint32
+		mask   uint32
+		want   syscall.Errno
+	}{
+		{owner, 0600, R_OK | W_OK, 0},
+		{owner, 0400, W_OK, syscall.EACCES},
+		{owner, 0077, R_OK, syscall.EACCES}, // the owner bits apply, not the group's
+		{member, 0640, R_OK, 0},
+		{member, 0640, W_OK, syscall.EACCES},
+		{other, 0644, R_OK, 0},
+		{other, 0640, R_OK, syscall.EACCES},
+		{other, 0755, X_OK, 0},
+		{other, 0644, X_OK, syscall.EACCES},
+		{root, 0000, R_OK | W_OK, 0},
+		{root, 0644, X_OK, syscall.EACCES},
+		{root, 0744, X_OK, 0},
+	}
+	for _, test := range tests {
+		stat := &syscall.Stat_t{Uid: 1000, Gid: 100, Mode: syscall.S_IFREG | test.mode}
+		ctx := fuse.NewContext(context.Background(), test.caller)
+		got := CheckStatPermissions(ctx, stat, test.mask)
+		if got != test.want {
+			t.Errorf("uid %v on mode %o for mask %o = %v; want %v", test.caller.Uid, test.mode, test.mask, got, test.want)
+		}
+	}
+
+	// Not called from the kernel; nothing to check
+	stat := &syscall.Stat_t{Uid: 1000, Mode: syscall.S_IFREG}
+	if got := CheckStatPermissions(context.Background(), stat, R_OK); got != 0 {
+		t.Errorf("check without a caller = %v; want 0", got)
+	}
+	ctx := fuse.NewContext(context.Background(), owner)
+	missing := filepath.Join(t.TempDir(), "missing")
+	if got := CheckPermissions(ctx, missing, R_OK); got != syscall.ENOENT {
+		t.Errorf("check of a missing file = %v; want ENOENT", got)
+	}
+}
+
+func TestAccessMask(t *testing.T) {
+	tests := map[int]uint32{
+		os.O_RDONLY:              R_OK,
+		os.O_WRONLY:              W_OK,
+		os.O_RDWR:                R_OK | W_OK,
+		os.O_WRONLY | os.O_TRUNC: W_OK,
+	}
+	for flags, want := range tests {
+		if got := AccessMask(uint32(flags)); got != want {
+			t.Errorf("AccessMask(%#o) = %v; want %v", flags, got, want)
+		}
+	}
+}
+
+func TestPrepareMountpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mnt")
+
+	_, err := PrepareMountpoint(path, false)
+	if err == nil {
+		t.Error("missing mountpoint accepted without create")
+	}
+
+	created, err := PrepareMountpoint(path, true)
+	if err != nil || !created {
+		t.Fatalf("PrepareMountpoint = %v, %v; want true, nil", created, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0755 {
+		t.Errorf("mountpoint = %v, %v; want a 0755 directory", info, err)
+	}
+
+	// Only what we created is ours to clean up
+	created, err = PrepareMountpoint(path, true)
+	if err != nil || created {
+		t.Errorf("PrepareMountpoint of existing directory = %v, %v; want false, nil", created, err)
+	}
+	RemoveMountpoint(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("mountpoint left behind; %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	err = os.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PrepareMountpoint(file, true)
+	if err == nil {
+		t.Error("file accepted as mountpoint")
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	dir := t.TempDir()
+	targets := []string{
+		"target",
+		strings.Repeat("d/", 127) + "f",
+		strings.Repeat("x", 255) + "/" + strings.Repeat("y", PATH_MAX-257), // fills the first buffer
+	}
+	for i, target := range targets {
+		link := filepath.Join(dir, "link"+strconv.Itoa(i))
+		err := os.Symlink(target, link)
+		if err != nil {
+			t.Logf("can't make a link of %v bytes; %v", len(target), err)
+			continue
+		}
+		got, errno := Readlink(link)
+		if errno != 0 || string(got) != target {
+			t.Errorf("Readlink of %v byte target = %v bytes, %v; want all of it", len(target), len(got), errno)
+		}
+	}
+
+	file := filepath.Join(dir, "file")
+	err := os.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errno := Readlink(file); errno != syscall.EINVAL {
+		t.Errorf("Readlink of a regular file = %v; want EINVAL", errno)
+	}
+	if _, errno := Readlink(filepath.Join(dir, "missing")); errno != syscall.ENOENT {
+		t.Errorf("Readlink of a missing file = %v; want ENOENT", errno)
+	}
+}
+
+// The setgid and sticky bits survive mkdir(2) and the umask
+func TestMkdirKeepsSpecialBits(t *testing.T) {
+	oldUmask := syscall.Umask(027)
+	t.Cleanup(func() { syscall.Umask(oldUmask) })
+
+	dir := t.TempDir()
+	for _, mode := range []uint32{02770, 01777, 0755} {
+		path := filepath.Join(dir, strconv.FormatUint(uint64(mode), 8))
+		err := Mkdir(path, syscall.S_IFDIR|mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := syscall.Stat_t{}
+		err = syscall.Lstat(path, &stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stat.Mode & 07777; got != mode {
+			t.Errorf("Mkdir(%o) made a directory with mode %o", mode, got)
+		}
+	}
+}
+
+// The append-only flag reads back once set, refuses writes that don't
+// append and leaves flags outside SYNCED_FILE_FLAGS alone
+func TestSetPathFlagsAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	err := os.WriteFile(path, []byte("first\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := GetPathFlags(path)
+	if err != nil {
+		t.Skipf("Filesystem doesn't support file flags; %v", err)
+	}
+	err = SetPathFlags(path, FS_APPEND_FL)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOTTY) {
+		t.Skipf("Can't set the append-only flag here; %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetPathFlags(path, 0) })
+
+	flags, err := GetPathFlags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != before|FS_APPEND_FL {
+		t.Errorf("flags = %#x; want %#x", flags, before|FS_APPEND_FL)
+	}
+
+	_, err = os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if !errors.Is(err, syscall.EPERM) {
+		t.Errorf("truncating open of an append-only file = %v; want EPERM", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("appending open of an append-only file failed; %v", err)
+	}
+	_, err = file.WriteString("second\n")
+	file.Close()
+	if err != nil {
+		t.Errorf("append to an append-only file failed; %v", err)
+	}
+
+	err = SetPathFlags(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err = GetPathFlags(path)
+	if err != nil || flags != before {
+		t.Errorf("flags after clearing = %#x, %v; want %#x", flags, err, before)
+	}
+}
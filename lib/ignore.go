@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File at the root of a synced directory listing paths, in gitignore
+// syntax, that are never synced. The file itself always is
+const IGNORE_FILE = ".fusionignore"
+
+// Transient files editors and office suites keep next to the file
+// being edited, in the same syntax as IGNORE_FILE. Servers don't
+// broadcast changes to them unless configured otherwise
+const DEFAULT_TEMP_PATTERNS = `# Dotfiles; vim swap files, LibreOffice .~lock.*# files and the
+# temp files of atomic saves
+.*
+# Microsoft Office owner files
+~$*
+*.tmp
+*~
+# AutoCAD drawing locks
+*.dwl
+*.dwl2
+`
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Parsed gitignore style rules. Later rules override earlier ones and
+// nothing inside an ignored directory can be re-included, like git
+type Ignore struct {
+	rules []ignoreRule
+}
+
+func ParseIgnore(data []byte) *Ignore {
+	ignore := &Ignore{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		rule, ok := parseIgnoreRule(scanner.Text())
+		if ok {
+			ignore.rules = append(ignore.rules, rule)
+		}
+	}
+	return ignore
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// Patterns with a slash are relative to the root, the rest match
+	// a name at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	pattern, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.pattern = pattern
+	return rule, true
+}
+
+// Translates a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Reports whether path, relative to the root the rules were loaded
+// from, is ignored
+func (ig *Ignore) Match(path string, isDir bool) bool {
+	if ig == nil || len(ig.rules) == 0 {
+		return false
+	}
+	path = strings.Trim(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	if path == "" || path == IGNORE_FILE {
+		return false
+	}
+
+	// Nothing inside an ignored directory can be re-included
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.matchOne(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.matchOne(path, isDir)
+}
+
+func (ig *Ignore) matchOne(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range ig.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Ignore file that is read again whenever it changes on disk
+type IgnoreFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	data    []byte
+	ignore  *Ignore
+}
+
+func NewIgnoreFile(path string) *IgnoreFile {
+	return &IgnoreFile{path: path}
+}
+
+// Current rules of the file. A missing file ignores nothing
+func (f *IgnoreFile) Rules() *Ignore {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reload()
+	return f.ignore
+}
+
+// Current contents of the file. Empty if it is missing
+func (f *IgnoreFile) Data() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reload()
+	return f.data
+}
+
+// Reads the file again if it changed. Called with f.mu held
+func (f *IgnoreFile) reload() {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.ignore = nil
+		f.data = nil
+		f.modTime = time.Time{}
+		return
+	}
+	if f.ignore != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return
+	}
+	f.data = data
+	f.ignore = ParseIgnore(data)
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+}
+
+func (f *IgnoreFile) Match(path string, isDir bool) bool {
+	return f.Rules().Match(path, isDir)
+}
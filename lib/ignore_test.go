@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	ignore := ParseIgnore([]byte(`# build output
+*.log
+!keep.log
+build/
+/top
+docs/**/draft.md
+`))
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"sub/debug.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false}, // only directories match build/
+		{"build/out.o", false, true},
+		{"sub/build/out.o", false, true},
+		{"top", false, true},
+		{"sub/top", false, false},
+		{"docs/a/b/draft.md", false, true},
+		{"docs/draft.md", false, true},
+		{"notes.txt", false, false},
+		{IGNORE_FILE, false, false},
+	}
+	for _, test := range tests {
+		if got := ignore.Match(test.path, test.isDir); got != test.want {
+			t.Errorf("Match(%v, dir %v) = %v; want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+
+	// Nothing inside an ignored directory comes back
+	ignore = ParseIgnore([]byte("build/\n!build/keep.log\n"))
+	if !ignore.Match("build/keep.log", false) {
+		t.Error("file re-included from inside an ignored directory")
+	}
+	if (*Ignore)(nil).Match("debug.log", false) {
+		t.Error("no rules ignore debug.log")
+	}
+}
+
+// Changes to the file apply without a restart
+func TestIgnoreFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), IGNORE_FILE)
+	file := NewIgnoreFile(path)
+	if file.Match("debug.log", false) {
+		t.Error("missing ignore file ignores debug.log")
+	}
+
+	err := os.WriteFile(path, []byte("*.log\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.Match("debug.log", false) {
+		t.Error("debug.log not ignored after *.log was added")
+	}
+
+	err = os.WriteFile(path, []byte("*.tmp\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if file.Match("debug.log", false) || !file.Match("a.tmp", false) {
+		t.Error("rules not reloaded after the file changed")
+	}
+
+	os.Remove(path)
+	if file.Match("a.tmp", false) || file.Data() != nil {
+		t.Error("rules kept after the file was removed")
+	}
+}
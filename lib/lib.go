@@ -1,12 +1,15 @@
 package lib
 
 import (
+	"crypto/md5"
+	"crypto/rand"
 	_ "embed"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"syscall"
 	"time"
 
@@ -20,6 +23,24 @@ var (
 	ProjectDir string
 )
 
+// gRPC metadata key carrying the idempotency key of a mutating
+// request. Remote applies requests with the same key only once
+const IDEMPOTENCY_KEY = "idempotency-key"
+
+// gRPC metadata key and HTTP header carrying the id of a request. The
+// client and server both log it so a failed request can be traced
+// across them; servers generate one for requests without it
+const (
+	REQUEST_ID_KEY    = "x-request-id"
+	REQUEST_ID_HEADER = "X-Request-Id"
+)
+
+func NewRequestId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 func init() {
 	// Ensure project directory folder is created on
 	// users home directory
@@ -35,33 +56,15 @@ func init() {
 	}
 }
 
-func LoadEnv() error {
-	envFile := filepath.Join(ProjectDir, ".env")
-
-	data, err := os.ReadFile(envFile)
+// Returns the hex encoded md5 hash of everything read from r.
+// Both client and server use it to decide if files are in sync
+func HashFile(r io.Reader) (string, error) {
+	hash := md5.New()
+	_, err := io.Copy(hash, r)
 	if err != nil {
-		return err
-	}
-
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		fields := strings.SplitN(line, "=", 2)
-		if len(fields) != 2 {
-			return fmt.Errorf("invalid .env file format near; %v", line)
-		}
-
-		key := strings.Trim(fields[0], "\"")
-		value := strings.Trim(fields[1], "\"")
-		err = os.Setenv(key, value)
-		if err != nil {
-			return err
-		}
+		return "", err
 	}
-	return nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func FileInfoToFileAttr(info os.FileInfo) *proto.FileAttr {
@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"syscall"
+)
+
+// Extended attribute holding the email of the user that owns a file.
+// uid/gid on disk belong to whatever process wrote the file so they
+// mean nothing across machines
+const OWNER_XATTR = "user.fusion.owner"
+
+// Records owner as the logical owner of path
+func SetOwner(path, owner string) error {
+	return syscall.Setxattr(path, OWNER_XATTR, []byte(owner), 0)
+}
+
+// Returns the logical owner of path or an empty string if the file
+// has none
+func GetOwner(path string) string {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, OWNER_XATTR, buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
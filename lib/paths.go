@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Paths sent over the wire, in requests, file events, listings and
+// manifests, always use forward slashes whatever OS either end runs
+// on. They are rooted at the user's directory: "/" is the directory
+// itself and "/a/b" a file in it. Handle them with the path package,
+// not filepath, and convert with ToWire and FromWire where they meet
+// the local filesystem.
+//
+// A backslash in a wire path is part of a name, never a separator.
+// Such names can't be stored where the backslash is the separator;
+// see LocalPathValid
+
+// Converts a path relative to some local root into a wire path. ""
+// stays "" so that unset fields stay unset
+func ToWire(osPath string) string {
+	if osPath == "" {
+		return ""
+	}
+	return path.Clean("/" + filepath.ToSlash(osPath))
+}
+
+// Converts wire path p into a path relative to some local root. Check
+// it with LocalPathValid first if it came from remote
+func FromWire(p string) string {
+	if p == "" {
+		return ""
+	}
+	return filepath.FromSlash(path.Clean("/" + p))
+}
+
+// Reports whether wire path p names the same file once converted with
+// FromWire. On Linux every path does
+func LocalPathValid(p string) bool {
+	if filepath.Separator == '/' {
+		return true
+	}
+	return !strings.ContainsRune(p, filepath.Separator)
+}
+
+// Cleans wire path p, which is taken to be relative to the user's
+// directory whether or not it starts with a slash. "", "." and "/"
+// all come back as "/". ok is false if p leaves the directory through
+// ".."
+func CleanWirePath(p string) (cleaned string, ok bool) {
+	rel := path.Clean(strings.TrimLeft(p, "/"))
+	if rel == "." {
+		return "/", true
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return "/" + rel, true
+}
+
+// Returns wire path p relative to wire path root, itself as a wire
+// path. ok is false if p is not root or inside it
+func WireRel(root, p string) (rel string, ok bool) {
+	root = path.Clean("/" + root)
+	p = path.Clean("/" + p)
+	if p == root {
+		return "/", true
+	}
+	if root == "/" {
+		return p, true
+	}
+	if !strings.HasPrefix(p, root+"/") {
+		return "", false
+	}
+	return p[len(root):], true
+}
@@ -0,0 +1,91 @@
+package lib
+
+import "testing"
+
+func TestWireConversion(t *testing.T) {
+	tests := []struct {
+		local, wire string
+	}{
+		{"", ""},
+		{".", "/"},
+		{"a", "/a"},
+		{"a/b", "/a/b"},
+		{"/a/b/", "/a/b"},
+		{"a//b/./c", "/a/b/c"},
+		{"a/../b", "/b"},
+		{`back\slash`, `/back\slash`}, // a name, not a separator, on Linux
+		{`a\b/c`, `/a\b/c`},
+	}
+	for _, test := range tests {
+		if got := ToWire(test.local); got != test.wire {
+			t.Errorf("ToWire(%q) = %q; want %q", test.local, got, test.wire)
+		}
+	}
+
+	// Back again, relative to some root whatever the leading slash
+	fromWire := []struct {
+		wire, local string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"a/b", "/a/b"},
+		{"/a//b/", "/a/b"},
+		{"/../../etc", "/etc"},
+		{`/a\b`, `/a\b`},
+	}
+	for _, test := range fromWire {
+		if got := FromWire(test.wire); got != test.local {
+			t.Errorf("FromWire(%q) = %q; want %q", test.wire, got, test.local)
+		}
+		if !LocalPathValid(test.wire) {
+			t.Errorf("LocalPathValid(%q) = false on Linux", test.wire)
+		}
+	}
+}
+
+func TestCleanWirePath(t *testing.T) {
+	tests := []struct {
+		path, want string
+		ok         bool
+	}{
+		{"", "/", true},
+		{".", "/", true},
+		{"/", "/", true},
+		{"a", "/a", true},
+		{"//a//b/", "/a/b", true},
+		{"a/./b/../c", "/a/c", true},
+		{`a\..\..\b`, `/a\..\..\b`, true}, // one name, not a way out
+		{"..", "", false},
+		{"/../a", "", false},
+		{"a/../../b", "", false},
+		{"..a", "/..a", true},
+	}
+	for _, test := range tests {
+		got, ok := CleanWirePath(test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("CleanWirePath(%q) = %q, %v; want %q, %v", test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestWireRel(t *testing.T) {
+	tests := []struct {
+		root, path, want string
+		ok               bool
+	}{
+		{"/org/dept", "/org/dept", "/", true},
+		{"/org/dept", "/org/dept/a/b", "/a/b", true},
+		{"/org/dept/", "org/dept//a", "/a", true},
+		{"/", "/a", "/a", true},
+		{"/org/dept", "/org/department/a", "", false},
+		{"/org/dept", "/org", "", false},
+		{"/org/dept", "/org/dept/../other/a", "", false},
+		{`/org/dept`, `/org/dept\a`, "", false},
+	}
+	for _, test := range tests {
+		got, ok := WireRel(test.root, test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("WireRel(%q, %q) = %q, %v; want %q, %v", test.root, test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -88,6 +88,7 @@ type FileAttr struct {
 	Owner         *Owner                 `protobuf:"bytes,9,opt,name=owner,proto3" json:"owner,omitempty"`                            // owner user ID and group ID
 	BlockSize     uint32                 `protobuf:"varint,10,opt,name=block_size,json=blockSize,proto3" json:"block_size,omitempty"` // preferred blocksize for filesystem I/O
 	Flags         uint32                 `protobuf:"varint,11,opt,name=flags,proto3" json:"flags,omitempty"`
+	Mime          string                 `protobuf:"bytes,12,opt,name=mime,proto3" json:"mime,omitempty"` // content type of regular files
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -199,6 +200,13 @@ func (x *FileAttr) GetFlags() uint32 {
 	return 0
 }
 
+func (x *FileAttr) GetMime() string {
+	if x != nil {
+		return x.Mime
+	}
+	return ""
+}
+
 type LookupRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Node          *DirEntry              `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"` // node to perform lookup operation in
@@ -452,6 +460,8 @@ type WriteRequest struct {
 	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`      // file to write to
 	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"` // point to start writing within file
 	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Append        bool                   `protobuf:"varint,4,opt,name=append,proto3" json:"append,omitempty"`   // write at end of file; offset is ignored
+	Replace       bool                   `protobuf:"varint,5,opt,name=replace,proto3" json:"replace,omitempty"` // data is the whole new file; replaces it atomically
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -507,6 +517,106 @@ func (x *WriteRequest) GetData() []byte {
 	return nil
 }
 
+func (x *WriteRequest) GetAppend() bool {
+	if x != nil {
+		return x.Append
+	}
+	return false
+}
+
+func (x *WriteRequest) GetReplace() bool {
+	if x != nil {
+		return x.Replace
+	}
+	return false
+}
+
+// Fields left unset are not changed. Owners are not part of it; they
+// are emails recorded by remote, not uids
+type SetattrRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Size          *uint64                `protobuf:"varint,2,opt,name=size,proto3,oneof" json:"size,omitempty"`         // truncate or extend the file to size bytes
+	Mode          *uint32                `protobuf:"varint,3,opt,name=mode,proto3,oneof" json:"mode,omitempty"`         // permission bits
+	ATime         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=a_time,json=aTime,proto3" json:"a_time,omitempty"` // time of last access
+	MTime         *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=m_time,json=mTime,proto3" json:"m_time,omitempty"` // time of last modification
+	Flags         *uint32                `protobuf:"varint,6,opt,name=flags,proto3,oneof" json:"flags,omitempty"`       // chattr flags; only lib.SYNCED_FILE_FLAGS are applied
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetattrRequest) Reset() {
+	*x = SetattrRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetattrRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetattrRequest) ProtoMessage() {}
+
+func (x *SetattrRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetattrRequest.ProtoReflect.Descriptor instead.
+func (*SetattrRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SetattrRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetattrRequest) GetSize() uint64 {
+	if x != nil && x.Size != nil {
+		return *x.Size
+	}
+	return 0
+}
+
+func (x *SetattrRequest) GetMode() uint32 {
+	if x != nil && x.Mode != nil {
+		return *x.Mode
+	}
+	return 0
+}
+
+func (x *SetattrRequest) GetATime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ATime
+	}
+	return nil
+}
+
+func (x *SetattrRequest) GetMTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MTime
+	}
+	return nil
+}
+
+func (x *SetattrRequest) GetFlags() uint32 {
+	if x != nil && x.Flags != nil {
+		return *x.Flags
+	}
+	return 0
+}
+
 type RenameRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	OldPath       string                 `protobuf:"bytes,1,opt,name=old_path,json=oldPath,proto3" json:"old_path,omitempty"`
@@ -517,7 +627,7 @@ type RenameRequest struct {
 
 func (x *RenameRequest) Reset() {
 	*x = RenameRequest{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[7]
+	mi := &file_lib_proto_fuse_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -529,7 +639,7 @@ func (x *RenameRequest) String() string {
 func (*RenameRequest) ProtoMessage() {}
 
 func (x *RenameRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[7]
+	mi := &file_lib_proto_fuse_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -542,7 +652,7 @@ func (x *RenameRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RenameRequest.ProtoReflect.Descriptor instead.
 func (*RenameRequest) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{7}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *RenameRequest) GetOldPath() string {
@@ -565,13 +675,15 @@ type DirEntry struct {
 	Mode          uint32                 `protobuf:"varint,2,opt,name=mode,proto3" json:"mode,omitempty"`
 	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"` // path of the entry
 	Attr          *FileAttr              `protobuf:"bytes,4,opt,name=attr,proto3" json:"attr,omitempty"`
+	Mime          string                 `protobuf:"bytes,5,opt,name=mime,proto3" json:"mime,omitempty"`     // content type of regular files
+	Target        string                 `protobuf:"bytes,6,opt,name=target,proto3" json:"target,omitempty"` // where a symlink points
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *DirEntry) Reset() {
 	*x = DirEntry{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[8]
+	mi := &file_lib_proto_fuse_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -583,7 +695,7 @@ func (x *DirEntry) String() string {
 func (*DirEntry) ProtoMessage() {}
 
 func (x *DirEntry) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[8]
+	mi := &file_lib_proto_fuse_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -596,7 +708,7 @@ func (x *DirEntry) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DirEntry.ProtoReflect.Descriptor instead.
 func (*DirEntry) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{8}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *DirEntry) GetIno() uint64 {
@@ -627,6 +739,20 @@ func (x *DirEntry) GetAttr() *FileAttr {
 	return nil
 }
 
+func (x *DirEntry) GetMime() string {
+	if x != nil {
+		return x.Mime
+	}
+	return ""
+}
+
+func (x *DirEntry) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
 type ReadDirAllResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Entries       []*DirEntry            `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
@@ -636,7 +762,7 @@ type ReadDirAllResponse struct {
 
 func (x *ReadDirAllResponse) Reset() {
 	*x = ReadDirAllResponse{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[9]
+	mi := &file_lib_proto_fuse_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -648,7 +774,7 @@ func (x *ReadDirAllResponse) String() string {
 func (*ReadDirAllResponse) ProtoMessage() {}
 
 func (x *ReadDirAllResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[9]
+	mi := &file_lib_proto_fuse_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -661,7 +787,7 @@ func (x *ReadDirAllResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ReadDirAllResponse.ProtoReflect.Descriptor instead.
 func (*ReadDirAllResponse) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{9}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *ReadDirAllResponse) GetEntries() []*DirEntry {
@@ -680,7 +806,7 @@ type ReadAllResponse struct {
 
 func (x *ReadAllResponse) Reset() {
 	*x = ReadAllResponse{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[10]
+	mi := &file_lib_proto_fuse_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -692,7 +818,7 @@ func (x *ReadAllResponse) String() string {
 func (*ReadAllResponse) ProtoMessage() {}
 
 func (x *ReadAllResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[10]
+	mi := &file_lib_proto_fuse_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -705,7 +831,7 @@ func (x *ReadAllResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ReadAllResponse.ProtoReflect.Descriptor instead.
 func (*ReadAllResponse) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{10}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *ReadAllResponse) GetData() []byte {
@@ -718,13 +844,14 @@ func (x *ReadAllResponse) GetData() []byte {
 type WriteResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	BytesWritten  uint64                 `protobuf:"varint,1,opt,name=bytes_written,json=bytesWritten,proto3" json:"bytes_written,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"` // offset the data was written at
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *WriteResponse) Reset() {
 	*x = WriteResponse{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[11]
+	mi := &file_lib_proto_fuse_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -736,7 +863,7 @@ func (x *WriteResponse) String() string {
 func (*WriteResponse) ProtoMessage() {}
 
 func (x *WriteResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[11]
+	mi := &file_lib_proto_fuse_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -749,7 +876,7 @@ func (x *WriteResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WriteResponse.ProtoReflect.Descriptor instead.
 func (*WriteResponse) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{11}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *WriteResponse) GetBytesWritten() uint64 {
@@ -759,29 +886,40 @@ func (x *WriteResponse) GetBytesWritten() uint64 {
 	return 0
 }
 
-type LinkRequest struct {
+func (x *WriteResponse) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+// One piece of an UploadFile stream. Every chunk of a stream names
+// the same file
+type UploadChunk struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	OldPath       string                 `protobuf:"bytes,1,opt,name=old_path,json=oldPath,proto3" json:"old_path,omitempty"`
-	NewPath       string                 `protobuf:"bytes,2,opt,name=new_path,json=newPath,proto3" json:"new_path,omitempty"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`      // file to write to
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"` // point to start writing within file
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Append        bool                   `protobuf:"varint,4,opt,name=append,proto3" json:"append,omitempty"` // write at end of file; offset is ignored. Only read from the first chunk
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *LinkRequest) Reset() {
-	*x = LinkRequest{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[12]
+func (x *UploadChunk) Reset() {
+	*x = UploadChunk{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *LinkRequest) String() string {
+func (x *UploadChunk) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LinkRequest) ProtoMessage() {}
+func (*UploadChunk) ProtoMessage() {}
 
-func (x *LinkRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[12]
+func (x *UploadChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -792,91 +930,60 @@ func (x *LinkRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LinkRequest.ProtoReflect.Descriptor instead.
-func (*LinkRequest) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{12}
+// Deprecated: Use UploadChunk.ProtoReflect.Descriptor instead.
+func (*UploadChunk) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{13}
 }
 
-func (x *LinkRequest) GetOldPath() string {
+func (x *UploadChunk) GetPath() string {
 	if x != nil {
-		return x.OldPath
+		return x.Path
 	}
 	return ""
 }
 
-func (x *LinkRequest) GetNewPath() string {
+func (x *UploadChunk) GetOffset() int64 {
 	if x != nil {
-		return x.NewPath
+		return x.Offset
 	}
-	return ""
-}
-
-type LinkResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Node          *DirEntry              `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
-}
-
-func (x *LinkResponse) Reset() {
-	*x = LinkResponse{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[13]
-	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-	ms.StoreMessageInfo(mi)
-}
-
-func (x *LinkResponse) String() string {
-	return protoimpl.X.MessageStringOf(x)
+	return 0
 }
 
-func (*LinkResponse) ProtoMessage() {}
-
-func (x *LinkResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[13]
+func (x *UploadChunk) GetData() []byte {
 	if x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
+		return x.Data
 	}
-	return mi.MessageOf(x)
-}
-
-// Deprecated: Use LinkResponse.ProtoReflect.Descriptor instead.
-func (*LinkResponse) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{13}
+	return nil
 }
 
-func (x *LinkResponse) GetNode() *DirEntry {
+func (x *UploadChunk) GetAppend() bool {
 	if x != nil {
-		return x.Node
+		return x.Append
 	}
-	return nil
+	return false
 }
 
-type DownloadRequest struct {
+type UploadResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
-	ExpectedHash  string                 `protobuf:"bytes,2,opt,name=expected_hash,json=expectedHash,proto3" json:"expected_hash,omitempty"`
+	BytesWritten  uint64                 `protobuf:"varint,1,opt,name=bytes_written,json=bytesWritten,proto3" json:"bytes_written,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *DownloadRequest) Reset() {
-	*x = DownloadRequest{}
+func (x *UploadResponse) Reset() {
+	*x = UploadResponse{}
 	mi := &file_lib_proto_fuse_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *DownloadRequest) String() string {
+func (x *UploadResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DownloadRequest) ProtoMessage() {}
+func (*UploadResponse) ProtoMessage() {}
 
-func (x *DownloadRequest) ProtoReflect() protoreflect.Message {
+func (x *UploadResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_lib_proto_fuse_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -888,48 +995,42 @@ func (x *DownloadRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DownloadRequest.ProtoReflect.Descriptor instead.
-func (*DownloadRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use UploadResponse.ProtoReflect.Descriptor instead.
+func (*UploadResponse) Descriptor() ([]byte, []int) {
 	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{14}
 }
 
-func (x *DownloadRequest) GetPath() string {
-	if x != nil {
-		return x.Path
-	}
-	return ""
-}
-
-func (x *DownloadRequest) GetExpectedHash() string {
+func (x *UploadResponse) GetBytesWritten() uint64 {
 	if x != nil {
-		return x.ExpectedHash
+		return x.BytesWritten
 	}
-	return ""
+	return 0
 }
 
-type FileChunk struct {
+// Creates or replaces a small file whole, contents and mode together
+type PutFileRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
-	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
-	TotalSize     int64                  `protobuf:"varint,3,opt,name=total_size,json=totalSize,proto3" json:"total_size,omitempty"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`  // the whole file; at most MAX_PUT_SIZE bytes
+	Mode          uint32                 `protobuf:"varint,3,opt,name=mode,proto3" json:"mode,omitempty"` // permission bits; applied to new and existing files
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *FileChunk) Reset() {
-	*x = FileChunk{}
+func (x *PutFileRequest) Reset() {
+	*x = PutFileRequest{}
 	mi := &file_lib_proto_fuse_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *FileChunk) String() string {
+func (x *PutFileRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*FileChunk) ProtoMessage() {}
+func (*PutFileRequest) ProtoMessage() {}
 
-func (x *FileChunk) ProtoReflect() protoreflect.Message {
+func (x *PutFileRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_lib_proto_fuse_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -941,54 +1042,54 @@ func (x *FileChunk) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use FileChunk.ProtoReflect.Descriptor instead.
-func (*FileChunk) Descriptor() ([]byte, []int) {
+// Deprecated: Use PutFileRequest.ProtoReflect.Descriptor instead.
+func (*PutFileRequest) Descriptor() ([]byte, []int) {
 	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{15}
 }
 
-func (x *FileChunk) GetData() []byte {
+func (x *PutFileRequest) GetPath() string {
 	if x != nil {
-		return x.Data
+		return x.Path
 	}
-	return nil
+	return ""
 }
 
-func (x *FileChunk) GetOffset() int64 {
+func (x *PutFileRequest) GetData() []byte {
 	if x != nil {
-		return x.Offset
+		return x.Data
 	}
-	return 0
+	return nil
 }
 
-func (x *FileChunk) GetTotalSize() int64 {
+func (x *PutFileRequest) GetMode() uint32 {
 	if x != nil {
-		return x.TotalSize
+		return x.Mode
 	}
 	return 0
 }
 
-type AuthRequest struct {
+type PutFileResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Email         string                 `protobuf:"bytes,1,opt,name=email,proto3" json:"email,omitempty"`
-	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	Attr          *FileAttr              `protobuf:"bytes,1,opt,name=attr,proto3" json:"attr,omitempty"`
+	Hash          string                 `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"` // hash of the stored contents
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AuthRequest) Reset() {
-	*x = AuthRequest{}
+func (x *PutFileResponse) Reset() {
+	*x = PutFileResponse{}
 	mi := &file_lib_proto_fuse_proto_msgTypes[16]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AuthRequest) String() string {
+func (x *PutFileResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AuthRequest) ProtoMessage() {}
+func (*PutFileResponse) ProtoMessage() {}
 
-func (x *AuthRequest) ProtoReflect() protoreflect.Message {
+func (x *PutFileResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_lib_proto_fuse_proto_msgTypes[16]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -1000,35 +1101,835 @@ func (x *AuthRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AuthRequest.ProtoReflect.Descriptor instead.
-func (*AuthRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use PutFileResponse.ProtoReflect.Descriptor instead.
+func (*PutFileResponse) Descriptor() ([]byte, []int) {
 	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{16}
 }
 
-func (x *AuthRequest) GetEmail() string {
+func (x *PutFileResponse) GetAttr() *FileAttr {
 	if x != nil {
-		return x.Email
+		return x.Attr
 	}
-	return ""
+	return nil
 }
 
-func (x *AuthRequest) GetPassword() string {
+func (x *PutFileResponse) GetHash() string {
 	if x != nil {
-		return x.Password
+		return x.Hash
 	}
 	return ""
 }
 
-type AuthResponse struct {
+type LinkRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	OldPath       string                 `protobuf:"bytes,1,opt,name=old_path,json=oldPath,proto3" json:"old_path,omitempty"`
+	NewPath       string                 `protobuf:"bytes,2,opt,name=new_path,json=newPath,proto3" json:"new_path,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AuthResponse) Reset() {
-	*x = AuthResponse{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[17]
+func (x *LinkRequest) Reset() {
+	*x = LinkRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LinkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LinkRequest) ProtoMessage() {}
+
+func (x *LinkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LinkRequest.ProtoReflect.Descriptor instead.
+func (*LinkRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *LinkRequest) GetOldPath() string {
+	if x != nil {
+		return x.OldPath
+	}
+	return ""
+}
+
+func (x *LinkRequest) GetNewPath() string {
+	if x != nil {
+		return x.NewPath
+	}
+	return ""
+}
+
+type CopyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SrcPath       string                 `protobuf:"bytes,1,opt,name=src_path,json=srcPath,proto3" json:"src_path,omitempty"`
+	DstPath       string                 `protobuf:"bytes,2,opt,name=dst_path,json=dstPath,proto3" json:"dst_path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CopyRequest) Reset() {
+	*x = CopyRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CopyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CopyRequest) ProtoMessage() {}
+
+func (x *CopyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CopyRequest.ProtoReflect.Descriptor instead.
+func (*CopyRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *CopyRequest) GetSrcPath() string {
+	if x != nil {
+		return x.SrcPath
+	}
+	return ""
+}
+
+func (x *CopyRequest) GetDstPath() string {
+	if x != nil {
+		return x.DstPath
+	}
+	return ""
+}
+
+type FileVersion struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`           // identifies the version within its file
+	Size          uint64                 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`      // size in bytes
+	Created       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=created,proto3" json:"created,omitempty"` // when the version was replaced
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileVersion) Reset() {
+	*x = FileVersion{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileVersion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileVersion) ProtoMessage() {}
+
+func (x *FileVersion) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileVersion.ProtoReflect.Descriptor instead.
+func (*FileVersion) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *FileVersion) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *FileVersion) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *FileVersion) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+type VersionList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Versions      []*FileVersion         `protobuf:"bytes,1,rep,name=versions,proto3" json:"versions,omitempty"` // newest first
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VersionList) Reset() {
+	*x = VersionList{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VersionList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VersionList) ProtoMessage() {}
+
+func (x *VersionList) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VersionList.ProtoReflect.Descriptor instead.
+func (*VersionList) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *VersionList) GetVersions() []*FileVersion {
+	if x != nil {
+		return x.Versions
+	}
+	return nil
+}
+
+type RestoreVersionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreVersionRequest) Reset() {
+	*x = RestoreVersionRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreVersionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreVersionRequest) ProtoMessage() {}
+
+func (x *RestoreVersionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreVersionRequest.ProtoReflect.Descriptor instead.
+func (*RestoreVersionRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *RestoreVersionRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *RestoreVersionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ServerInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`                               // version of the server
+	Features      []string               `protobuf:"bytes,2,rep,name=features,proto3" json:"features,omitempty"`                             // optional RPCs and behaviours the server supports
+	TempPatterns  string                 `protobuf:"bytes,3,opt,name=temp_patterns,json=tempPatterns,proto3" json:"temp_patterns,omitempty"` // files whose changes aren't broadcast, in .fusionignore syntax
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerInfo) Reset() {
+	*x = ServerInfo{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfo) ProtoMessage() {}
+
+func (x *ServerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfo.ProtoReflect.Descriptor instead.
+func (*ServerInfo) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ServerInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *ServerInfo) GetTempPatterns() string {
+	if x != nil {
+		return x.TempPatterns
+	}
+	return ""
+}
+
+type StatfsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Files         uint64                 `protobuf:"varint,1,opt,name=files,proto3" json:"files,omitempty"` // files and directories in the org
+	Ffree         uint64                 `protobuf:"varint,2,opt,name=ffree,proto3" json:"ffree,omitempty"` // files that can still be created
+	Quota         uint64                 `protobuf:"varint,3,opt,name=quota,proto3" json:"quota,omitempty"` // inode quota of the org; 0 if there is none
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatfsResponse) Reset() {
+	*x = StatfsResponse{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatfsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatfsResponse) ProtoMessage() {}
+
+func (x *StatfsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatfsResponse.ProtoReflect.Descriptor instead.
+func (*StatfsResponse) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *StatfsResponse) GetFiles() uint64 {
+	if x != nil {
+		return x.Files
+	}
+	return 0
+}
+
+func (x *StatfsResponse) GetFfree() uint64 {
+	if x != nil {
+		return x.Ffree
+	}
+	return 0
+}
+
+func (x *StatfsResponse) GetQuota() uint64 {
+	if x != nil {
+		return x.Quota
+	}
+	return 0
+}
+
+type LinkResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Node          *DirEntry              `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LinkResponse) Reset() {
+	*x = LinkResponse{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LinkResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LinkResponse) ProtoMessage() {}
+
+func (x *LinkResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LinkResponse.ProtoReflect.Descriptor instead.
+func (*LinkResponse) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *LinkResponse) GetNode() *DirEntry {
+	if x != nil {
+		return x.Node
+	}
+	return nil
+}
+
+type DownloadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	ExpectedHash  string                 `protobuf:"bytes,2,opt,name=expected_hash,json=expectedHash,proto3" json:"expected_hash,omitempty"`
+	ResumeOffset  int64                  `protobuf:"varint,3,opt,name=resume_offset,json=resumeOffset,proto3" json:"resume_offset,omitempty"` // bytes already received by the client
+	ResumeHash    string                 `protobuf:"bytes,4,opt,name=resume_hash,json=resumeHash,proto3" json:"resume_hash,omitempty"`        // remote hash the partial download belongs to
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DownloadRequest) Reset() {
+	*x = DownloadRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DownloadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadRequest) ProtoMessage() {}
+
+func (x *DownloadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadRequest.ProtoReflect.Descriptor instead.
+func (*DownloadRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *DownloadRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *DownloadRequest) GetExpectedHash() string {
+	if x != nil {
+		return x.ExpectedHash
+	}
+	return ""
+}
+
+func (x *DownloadRequest) GetResumeOffset() int64 {
+	if x != nil {
+		return x.ResumeOffset
+	}
+	return 0
+}
+
+func (x *DownloadRequest) GetResumeHash() string {
+	if x != nil {
+		return x.ResumeHash
+	}
+	return ""
+}
+
+type ArchiveRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`  // directory to archive
+	Gzip          bool                   `protobuf:"varint,2,opt,name=gzip,proto3" json:"gzip,omitempty"` // compress the tar stream
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ArchiveRequest) Reset() {
+	*x = ArchiveRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ArchiveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArchiveRequest) ProtoMessage() {}
+
+func (x *ArchiveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArchiveRequest.ProtoReflect.Descriptor instead.
+func (*ArchiveRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *ArchiveRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ArchiveRequest) GetGzip() bool {
+	if x != nil {
+		return x.Gzip
+	}
+	return false
+}
+
+type FileChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	TotalSize     int64                  `protobuf:"varint,3,opt,name=total_size,json=totalSize,proto3" json:"total_size,omitempty"`
+	Hash          string                 `protobuf:"bytes,4,opt,name=hash,proto3" json:"hash,omitempty"` // hash of the whole remote file
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileChunk) Reset() {
+	*x = FileChunk{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileChunk) ProtoMessage() {}
+
+func (x *FileChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileChunk.ProtoReflect.Descriptor instead.
+func (*FileChunk) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *FileChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *FileChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *FileChunk) GetTotalSize() int64 {
+	if x != nil {
+		return x.TotalSize
+	}
+	return 0
+}
+
+func (x *FileChunk) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+type ManifestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`            // directory to build the manifest for
+	Recursive     bool                   `protobuf:"varint,2,opt,name=recursive,proto3" json:"recursive,omitempty"` // include entries of sub-directories
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ManifestRequest) Reset() {
+	*x = ManifestRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ManifestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ManifestRequest) ProtoMessage() {}
+
+func (x *ManifestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ManifestRequest.ProtoReflect.Descriptor instead.
+func (*ManifestRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *ManifestRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ManifestRequest) GetRecursive() bool {
+	if x != nil {
+		return x.Recursive
+	}
+	return false
+}
+
+type ManifestEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Size          uint64                 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`               // size in bytes
+	MTime         *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=m_time,json=mTime,proto3" json:"m_time,omitempty"` // time of last modification
+	Hash          string                 `protobuf:"bytes,4,opt,name=hash,proto3" json:"hash,omitempty"`                // md5 hash of file contents; empty for directories
+	Mode          uint32                 `protobuf:"varint,5,opt,name=mode,proto3" json:"mode,omitempty"`               // file mode
+	Owner         string                 `protobuf:"bytes,6,opt,name=owner,proto3" json:"owner,omitempty"`              // email of the user that owns the entry
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ManifestEntry) Reset() {
+	*x = ManifestEntry{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ManifestEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ManifestEntry) ProtoMessage() {}
+
+func (x *ManifestEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ManifestEntry.ProtoReflect.Descriptor instead.
+func (*ManifestEntry) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *ManifestEntry) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ManifestEntry) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ManifestEntry) GetMTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MTime
+	}
+	return nil
+}
+
+func (x *ManifestEntry) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *ManifestEntry) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+func (x *ManifestEntry) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+type AuthRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Email         string                 `protobuf:"bytes,1,opt,name=email,proto3" json:"email,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuthRequest) Reset() {
+	*x = AuthRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuthRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuthRequest) ProtoMessage() {}
+
+func (x *AuthRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuthRequest.ProtoReflect.Descriptor instead.
+func (*AuthRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *AuthRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *AuthRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type AuthResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuthResponse) Reset() {
+	*x = AuthResponse{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[31]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1040,7 +1941,7 @@ func (x *AuthResponse) String() string {
 func (*AuthResponse) ProtoMessage() {}
 
 func (x *AuthResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[17]
+	mi := &file_lib_proto_fuse_proto_msgTypes[31]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1053,7 +1954,7 @@ func (x *AuthResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AuthResponse.ProtoReflect.Descriptor instead.
 func (*AuthResponse) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{17}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{31}
 }
 
 func (x *AuthResponse) GetToken() string {
@@ -1063,6 +1964,230 @@ func (x *AuthResponse) GetToken() string {
 	return ""
 }
 
+type Profile struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Email         string                 `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+	OrgName       string                 `protobuf:"bytes,3,opt,name=org_name,json=orgName,proto3" json:"org_name,omitempty"`
+	DeptName      string                 `protobuf:"bytes,4,opt,name=dept_name,json=deptName,proto3" json:"dept_name,omitempty"`
+	PendingEmail  string                 `protobuf:"bytes,5,opt,name=pending_email,json=pendingEmail,proto3" json:"pending_email,omitempty"` // new email waiting to be confirmed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Profile) Reset() {
+	*x = Profile{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Profile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Profile) ProtoMessage() {}
+
+func (x *Profile) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Profile.ProtoReflect.Descriptor instead.
+func (*Profile) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *Profile) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *Profile) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *Profile) GetOrgName() string {
+	if x != nil {
+		return x.OrgName
+	}
+	return ""
+}
+
+func (x *Profile) GetDeptName() string {
+	if x != nil {
+		return x.DeptName
+	}
+	return ""
+}
+
+func (x *Profile) GetPendingEmail() string {
+	if x != nil {
+		return x.PendingEmail
+	}
+	return ""
+}
+
+type UpdateProfileRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"` // new username; empty keeps the current one
+	Email         string                 `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`       // new email; only takes effect once confirmed with ConfirmEmail
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateProfileRequest) Reset() {
+	*x = UpdateProfileRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateProfileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateProfileRequest) ProtoMessage() {}
+
+func (x *UpdateProfileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateProfileRequest.ProtoReflect.Descriptor instead.
+func (*UpdateProfileRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *UpdateProfileRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UpdateProfileRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+type ConfirmEmailRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Otp           string                 `protobuf:"bytes,1,opt,name=otp,proto3" json:"otp,omitempty"` // code sent to the new email
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfirmEmailRequest) Reset() {
+	*x = ConfirmEmailRequest{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfirmEmailRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfirmEmailRequest) ProtoMessage() {}
+
+func (x *ConfirmEmailRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfirmEmailRequest.ProtoReflect.Descriptor instead.
+func (*ConfirmEmailRequest) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *ConfirmEmailRequest) GetOtp() string {
+	if x != nil {
+		return x.Otp
+	}
+	return ""
+}
+
+type ProfileResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       *Profile               `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"` // token carrying the updated profile; older tokens keep the old one until they expire
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProfileResponse) Reset() {
+	*x = ProfileResponse{}
+	mi := &file_lib_proto_fuse_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProfileResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProfileResponse) ProtoMessage() {}
+
+func (x *ProfileResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_lib_proto_fuse_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProfileResponse.ProtoReflect.Descriptor instead.
+func (*ProfileResponse) Descriptor() ([]byte, []int) {
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *ProfileResponse) GetProfile() *Profile {
+	if x != nil {
+		return x.Profile
+	}
+	return nil
+}
+
+func (x *ProfileResponse) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
 type FileEvent struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Event         uint32                 `protobuf:"varint,1,opt,name=event,proto3" json:"event,omitempty"`
@@ -1076,7 +2201,7 @@ type FileEvent struct {
 
 func (x *FileEvent) Reset() {
 	*x = FileEvent{}
-	mi := &file_lib_proto_fuse_proto_msgTypes[18]
+	mi := &file_lib_proto_fuse_proto_msgTypes[36]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1088,7 +2213,7 @@ func (x *FileEvent) String() string {
 func (*FileEvent) ProtoMessage() {}
 
 func (x *FileEvent) ProtoReflect() protoreflect.Message {
-	mi := &file_lib_proto_fuse_proto_msgTypes[18]
+	mi := &file_lib_proto_fuse_proto_msgTypes[36]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1101,7 +2226,7 @@ func (x *FileEvent) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FileEvent.ProtoReflect.Descriptor instead.
 func (*FileEvent) Descriptor() ([]byte, []int) {
-	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{18}
+	return file_lib_proto_fuse_proto_rawDescGZIP(), []int{36}
 }
 
 func (x *FileEvent) GetEvent() uint32 {
@@ -1146,7 +2271,7 @@ const file_lib_proto_fuse_proto_rawDesc = "" +
 	"\x14lib/proto/fuse.proto\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1bgoogle/protobuf/empty.proto\"+\n" +
 	"\x05Owner\x12\x10\n" +
 	"\x03uid\x18\x01 \x01(\rR\x03uid\x12\x10\n" +
-	"\x03gid\x18\x02 \x01(\rR\x03gid\"\xf9\x02\n" +
+	"\x03gid\x18\x02 \x01(\rR\x03gid\"\x8d\x03\n" +
 	"\bFileAttr\x120\n" +
 	"\x05valid\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x05valid\x12\x10\n" +
 	"\x03ino\x18\x02 \x01(\x04R\x03ino\x12\x12\n" +
@@ -1160,7 +2285,8 @@ const file_lib_proto_fuse_proto_rawDesc = "" +
 	"\n" +
 	"block_size\x18\n" +
 	" \x01(\rR\tblockSize\x12\x14\n" +
-	"\x05flags\x18\v \x01(\rR\x05flags\"B\n" +
+	"\x05flags\x18\v \x01(\rR\x05flags\x12\x12\n" +
+	"\x04mime\x18\f \x01(\tR\x04mime\"B\n" +
 	"\rLookupRequest\x12\x1d\n" +
 	"\x04node\x18\x01 \x01(\v2\t.DirEntryR\x04node\x12\x12\n" +
 	"\x04path\x18\x02 \x01(\tR\x04path\"L\n" +
@@ -1180,67 +2306,166 @@ const file_lib_proto_fuse_proto_rawDesc = "" +
 	"generation\x12;\n" +
 	"\ventry_valid\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
 	"entryValid\x12\x1d\n" +
-	"\x04attr\x18\x04 \x01(\v2\t.FileAttrR\x04attr\"N\n" +
+	"\x04attr\x18\x04 \x01(\v2\t.FileAttrR\x04attr\"\x80\x01\n" +
 	"\fWriteRequest\x12\x12\n" +
 	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
 	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
-	"\x04data\x18\x03 \x01(\fR\x04data\"E\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x16\n" +
+	"\x06append\x18\x04 \x01(\bR\x06append\x12\x18\n" +
+	"\areplace\x18\x05 \x01(\bR\areplace\"\xf3\x01\n" +
+	"\x0eSetattrRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x17\n" +
+	"\x04size\x18\x02 \x01(\x04H\x00R\x04size\x88\x01\x01\x12\x17\n" +
+	"\x04mode\x18\x03 \x01(\rH\x01R\x04mode\x88\x01\x01\x121\n" +
+	"\x06a_time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05aTime\x121\n" +
+	"\x06m_time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05mTime\x12\x19\n" +
+	"\x05flags\x18\x06 \x01(\rH\x02R\x05flags\x88\x01\x01B\a\n" +
+	"\x05_sizeB\a\n" +
+	"\x05_modeB\b\n" +
+	"\x06_flags\"E\n" +
 	"\rRenameRequest\x12\x19\n" +
 	"\bold_path\x18\x01 \x01(\tR\aoldPath\x12\x19\n" +
-	"\bnew_path\x18\x02 \x01(\tR\anewPath\"c\n" +
+	"\bnew_path\x18\x02 \x01(\tR\anewPath\"\x8f\x01\n" +
 	"\bDirEntry\x12\x10\n" +
 	"\x03ino\x18\x01 \x01(\x04R\x03ino\x12\x12\n" +
 	"\x04mode\x18\x02 \x01(\rR\x04mode\x12\x12\n" +
 	"\x04path\x18\x03 \x01(\tR\x04path\x12\x1d\n" +
-	"\x04attr\x18\x04 \x01(\v2\t.FileAttrR\x04attr\"9\n" +
+	"\x04attr\x18\x04 \x01(\v2\t.FileAttrR\x04attr\x12\x12\n" +
+	"\x04mime\x18\x05 \x01(\tR\x04mime\x12\x16\n" +
+	"\x06target\x18\x06 \x01(\tR\x06target\"9\n" +
 	"\x12ReadDirAllResponse\x12#\n" +
 	"\aentries\x18\x01 \x03(\v2\t.DirEntryR\aentries\"%\n" +
 	"\x0fReadAllResponse\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\fR\x04data\"4\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"L\n" +
 	"\rWriteResponse\x12#\n" +
-	"\rbytes_written\x18\x01 \x01(\x04R\fbytesWritten\"C\n" +
+	"\rbytes_written\x18\x01 \x01(\x04R\fbytesWritten\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\"e\n" +
+	"\vUploadChunk\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x16\n" +
+	"\x06append\x18\x04 \x01(\bR\x06append\"5\n" +
+	"\x0eUploadResponse\x12#\n" +
+	"\rbytes_written\x18\x01 \x01(\x04R\fbytesWritten\"L\n" +
+	"\x0ePutFileRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x12\n" +
+	"\x04mode\x18\x03 \x01(\rR\x04mode\"D\n" +
+	"\x0fPutFileResponse\x12\x1d\n" +
+	"\x04attr\x18\x01 \x01(\v2\t.FileAttrR\x04attr\x12\x12\n" +
+	"\x04hash\x18\x02 \x01(\tR\x04hash\"C\n" +
 	"\vLinkRequest\x12\x19\n" +
 	"\bold_path\x18\x01 \x01(\tR\aoldPath\x12\x19\n" +
-	"\bnew_path\x18\x02 \x01(\tR\anewPath\"-\n" +
+	"\bnew_path\x18\x02 \x01(\tR\anewPath\"C\n" +
+	"\vCopyRequest\x12\x19\n" +
+	"\bsrc_path\x18\x01 \x01(\tR\asrcPath\x12\x19\n" +
+	"\bdst_path\x18\x02 \x01(\tR\adstPath\"g\n" +
+	"\vFileVersion\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x04R\x04size\x124\n" +
+	"\acreated\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\"7\n" +
+	"\vVersionList\x12(\n" +
+	"\bversions\x18\x01 \x03(\v2\f.FileVersionR\bversions\";\n" +
+	"\x15RestoreVersionRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\"g\n" +
+	"\n" +
+	"ServerInfo\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1a\n" +
+	"\bfeatures\x18\x02 \x03(\tR\bfeatures\x12#\n" +
+	"\rtemp_patterns\x18\x03 \x01(\tR\ftempPatterns\"R\n" +
+	"\x0eStatfsResponse\x12\x14\n" +
+	"\x05files\x18\x01 \x01(\x04R\x05files\x12\x14\n" +
+	"\x05ffree\x18\x02 \x01(\x04R\x05ffree\x12\x14\n" +
+	"\x05quota\x18\x03 \x01(\x04R\x05quota\"-\n" +
 	"\fLinkResponse\x12\x1d\n" +
-	"\x04node\x18\x01 \x01(\v2\t.DirEntryR\x04node\"J\n" +
+	"\x04node\x18\x01 \x01(\v2\t.DirEntryR\x04node\"\x90\x01\n" +
 	"\x0fDownloadRequest\x12\x12\n" +
 	"\x04path\x18\x01 \x01(\tR\x04path\x12#\n" +
-	"\rexpected_hash\x18\x02 \x01(\tR\fexpectedHash\"V\n" +
+	"\rexpected_hash\x18\x02 \x01(\tR\fexpectedHash\x12#\n" +
+	"\rresume_offset\x18\x03 \x01(\x03R\fresumeOffset\x12\x1f\n" +
+	"\vresume_hash\x18\x04 \x01(\tR\n" +
+	"resumeHash\"8\n" +
+	"\x0eArchiveRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04gzip\x18\x02 \x01(\bR\x04gzip\"j\n" +
 	"\tFileChunk\x12\x12\n" +
 	"\x04data\x18\x01 \x01(\fR\x04data\x12\x16\n" +
 	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x1d\n" +
 	"\n" +
-	"total_size\x18\x03 \x01(\x03R\ttotalSize\"?\n" +
+	"total_size\x18\x03 \x01(\x03R\ttotalSize\x12\x12\n" +
+	"\x04hash\x18\x04 \x01(\tR\x04hash\"C\n" +
+	"\x0fManifestRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1c\n" +
+	"\trecursive\x18\x02 \x01(\bR\trecursive\"\xa8\x01\n" +
+	"\rManifestEntry\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x04R\x04size\x121\n" +
+	"\x06m_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x05mTime\x12\x12\n" +
+	"\x04hash\x18\x04 \x01(\tR\x04hash\x12\x12\n" +
+	"\x04mode\x18\x05 \x01(\rR\x04mode\x12\x14\n" +
+	"\x05owner\x18\x06 \x01(\tR\x05owner\"?\n" +
 	"\vAuthRequest\x12\x14\n" +
 	"\x05email\x18\x01 \x01(\tR\x05email\x12\x1a\n" +
 	"\bpassword\x18\x02 \x01(\tR\bpassword\"$\n" +
 	"\fAuthResponse\x12\x14\n" +
-	"\x05token\x18\x01 \x01(\tR\x05token\"\x9e\x01\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\"\x98\x01\n" +
+	"\aProfile\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x14\n" +
+	"\x05email\x18\x02 \x01(\tR\x05email\x12\x19\n" +
+	"\borg_name\x18\x03 \x01(\tR\aorgName\x12\x1b\n" +
+	"\tdept_name\x18\x04 \x01(\tR\bdeptName\x12#\n" +
+	"\rpending_email\x18\x05 \x01(\tR\fpendingEmail\"H\n" +
+	"\x14UpdateProfileRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x14\n" +
+	"\x05email\x18\x02 \x01(\tR\x05email\"'\n" +
+	"\x13ConfirmEmailRequest\x12\x10\n" +
+	"\x03otp\x18\x01 \x01(\tR\x03otp\"K\n" +
+	"\x0fProfileResponse\x12\"\n" +
+	"\aprofile\x18\x01 \x01(\v2\b.ProfileR\aprofile\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\tR\x05token\"\x9e\x01\n" +
 	"\tFileEvent\x12\x14\n" +
 	"\x05event\x18\x01 \x01(\rR\x05event\x12\x12\n" +
 	"\x04path\x18\x02 \x01(\tR\x04path\x12\x19\n" +
 	"\bnew_path\x18\x03 \x01(\tR\anewPath\x12\x12\n" +
 	"\x04mode\x18\x04 \x01(\rR\x04mode\x128\n" +
-	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp2\xf0\x04\n" +
+	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp2\xa4\n" +
+	"\n" +
 	"\x04Fuse\x12%\n" +
-	"\x04Auth\x12\f.AuthRequest\x1a\r.AuthResponse\"\x00\x120\n" +
+	"\x04Auth\x12\f.AuthRequest\x1a\r.AuthResponse\"\x00\x12.\n" +
+	"\x05Hello\x12\x16.google.protobuf.Empty\x1a\v.ServerInfo\"\x00\x120\n" +
 	"\fDownloadFile\x12\x10.DownloadRequest\x1a\n" +
+	".FileChunk\"\x000\x01\x122\n" +
+	"\x0fDownloadArchive\x12\x0f.ArchiveRequest\x1a\n" +
 	".FileChunk\"\x000\x01\x12<\n" +
 	"\x12ObserveFileChanges\x12\x16.google.protobuf.Empty\x1a\n" +
-	".FileEvent\"\x000\x01\x12%\n" +
+	".FileEvent\"\x000\x01\x123\n" +
+	"\vGetManifest\x12\x10.ManifestRequest\x1a\x0e.ManifestEntry\"\x000\x01\x12%\n" +
 	"\x06Lookup\x12\x0e.LookupRequest\x1a\t.DirEntry\"\x00\x12.\n" +
 	"\n" +
 	"ReadDirAll\x12\t.DirEntry\x1a\x13.ReadDirAllResponse\"\x00\x12#\n" +
 	"\x05Mkdir\x12\r.MkdirRequest\x1a\t.DirEntry\"\x00\x12,\n" +
-	"\x05Rmdir\x12\t.DirEntry\x1a\x16.google.protobuf.Empty\"\x00\x12!\n" +
-	"\aGetattr\x12\t.DirEntry\x1a\t.FileAttr\"\x00\x12+\n" +
+	"\x05Rmdir\x12\t.DirEntry\x1a\x16.google.protobuf.Empty\"\x00\x12-\n" +
+	"\x06Unlink\x12\t.DirEntry\x1a\x16.google.protobuf.Empty\"\x00\x12!\n" +
+	"\aGetattr\x12\t.DirEntry\x1a\t.FileAttr\"\x00\x12'\n" +
+	"\aSetattr\x12\x0f.SetattrRequest\x1a\t.FileAttr\"\x00\x12+\n" +
 	"\x06Create\x12\x0e.CreateRequest\x1a\x0f.CreateResponse\"\x00\x12(\n" +
 	"\aSymlink\x12\f.LinkRequest\x1a\r.LinkResponse\"\x00\x12%\n" +
 	"\x04Link\x12\f.LinkRequest\x1a\r.LinkResponse\"\x00\x12(\n" +
 	"\aReadAll\x12\t.DirEntry\x1a\x10.ReadAllResponse\"\x00\x12(\n" +
-	"\x05Write\x12\r.WriteRequest\x1a\x0e.WriteResponse\"\x00\x122\n" +
-	"\x06Rename\x12\x0e.RenameRequest\x1a\x16.google.protobuf.Empty\"\x00B&\n" +
+	"\x05Write\x12\r.WriteRequest\x1a\x0e.WriteResponse\"\x00\x12.\n" +
+	"\aPutFile\x12\x0f.PutFileRequest\x1a\x10.PutFileResponse\"\x00\x12/\n" +
+	"\n" +
+	"UploadFile\x12\f.UploadChunk\x1a\x0f.UploadResponse\"\x00(\x01\x122\n" +
+	"\x06Rename\x12\x0e.RenameRequest\x1a\x16.google.protobuf.Empty\"\x00\x12!\n" +
+	"\x04Copy\x12\f.CopyRequest\x1a\t.DirEntry\"\x00\x123\n" +
+	"\x06Statfs\x12\x16.google.protobuf.Empty\x1a\x0f.StatfsResponse\"\x00\x12)\n" +
+	"\fListVersions\x12\t.DirEntry\x1a\f.VersionList\"\x00\x125\n" +
+	"\x0eRestoreVersion\x12\x16.RestoreVersionRequest\x1a\t.FileAttr\"\x00\x120\n" +
+	"\n" +
+	"GetProfile\x12\x16.google.protobuf.Empty\x1a\b.Profile\"\x00\x12:\n" +
+	"\rUpdateProfile\x12\x15.UpdateProfileRequest\x1a\x10.ProfileResponse\"\x00\x128\n" +
+	"\fConfirmEmail\x12\x14.ConfirmEmailRequest\x1a\x10.ProfileResponse\"\x00B&\n" +
 	"\x19org.example.project.protoP\x01Z\a./protob\x06proto3"
 
 var (
@@ -1255,7 +2480,7 @@ func file_lib_proto_fuse_proto_rawDescGZIP() []byte {
 	return file_lib_proto_fuse_proto_rawDescData
 }
 
-var file_lib_proto_fuse_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
+var file_lib_proto_fuse_proto_msgTypes = make([]protoimpl.MessageInfo, 37)
 var file_lib_proto_fuse_proto_goTypes = []any{
 	(*Owner)(nil),                 // 0: Owner
 	(*FileAttr)(nil),              // 1: FileAttr
@@ -1264,67 +2489,120 @@ var file_lib_proto_fuse_proto_goTypes = []any{
 	(*CreateRequest)(nil),         // 4: CreateRequest
 	(*CreateResponse)(nil),        // 5: CreateResponse
 	(*WriteRequest)(nil),          // 6: WriteRequest
-	(*RenameRequest)(nil),         // 7: RenameRequest
-	(*DirEntry)(nil),              // 8: DirEntry
-	(*ReadDirAllResponse)(nil),    // 9: ReadDirAllResponse
-	(*ReadAllResponse)(nil),       // 10: ReadAllResponse
-	(*WriteResponse)(nil),         // 11: WriteResponse
-	(*LinkRequest)(nil),           // 12: LinkRequest
-	(*LinkResponse)(nil),          // 13: LinkResponse
-	(*DownloadRequest)(nil),       // 14: DownloadRequest
-	(*FileChunk)(nil),             // 15: FileChunk
-	(*AuthRequest)(nil),           // 16: AuthRequest
-	(*AuthResponse)(nil),          // 17: AuthResponse
-	(*FileEvent)(nil),             // 18: FileEvent
-	(*timestamppb.Timestamp)(nil), // 19: google.protobuf.Timestamp
-	(*emptypb.Empty)(nil),         // 20: google.protobuf.Empty
+	(*SetattrRequest)(nil),        // 7: SetattrRequest
+	(*RenameRequest)(nil),         // 8: RenameRequest
+	(*DirEntry)(nil),              // 9: DirEntry
+	(*ReadDirAllResponse)(nil),    // 10: ReadDirAllResponse
+	(*ReadAllResponse)(nil),       // 11: ReadAllResponse
+	(*WriteResponse)(nil),         // 12: WriteResponse
+	(*UploadChunk)(nil),           // 13: UploadChunk
+	(*UploadResponse)(nil),        // 14: UploadResponse
+	(*PutFileRequest)(nil),        // 15: PutFileRequest
+	(*PutFileResponse)(nil),       // 16: PutFileResponse
+	(*LinkRequest)(nil),           // 17: LinkRequest
+	(*CopyRequest)(nil),           // 18: CopyRequest
+	(*FileVersion)(nil),           // 19: FileVersion
+	(*VersionList)(nil),           // 20: VersionList
+	(*RestoreVersionRequest)(nil), // 21: RestoreVersionRequest
+	(*ServerInfo)(nil),            // 22: ServerInfo
+	(*StatfsResponse)(nil),        // 23: StatfsResponse
+	(*LinkResponse)(nil),          // 24: LinkResponse
+	(*DownloadRequest)(nil),       // 25: DownloadRequest
+	(*ArchiveRequest)(nil),        // 26: ArchiveRequest
+	(*FileChunk)(nil),             // 27: FileChunk
+	(*ManifestRequest)(nil),       // 28: ManifestRequest
+	(*ManifestEntry)(nil),         // 29: ManifestEntry
+	(*AuthRequest)(nil),           // 30: AuthRequest
+	(*AuthResponse)(nil),          // 31: AuthResponse
+	(*Profile)(nil),               // 32: Profile
+	(*UpdateProfileRequest)(nil),  // 33: UpdateProfileRequest
+	(*ConfirmEmailRequest)(nil),   // 34: ConfirmEmailRequest
+	(*ProfileResponse)(nil),       // 35: ProfileResponse
+	(*FileEvent)(nil),             // 36: FileEvent
+	(*timestamppb.Timestamp)(nil), // 37: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),         // 38: google.protobuf.Empty
 }
 var file_lib_proto_fuse_proto_depIdxs = []int32{
-	19, // 0: FileAttr.valid:type_name -> google.protobuf.Timestamp
-	19, // 1: FileAttr.a_time:type_name -> google.protobuf.Timestamp
-	19, // 2: FileAttr.m_time:type_name -> google.protobuf.Timestamp
-	19, // 3: FileAttr.c_time:type_name -> google.protobuf.Timestamp
+	37, // 0: FileAttr.valid:type_name -> google.protobuf.Timestamp
+	37, // 1: FileAttr.a_time:type_name -> google.protobuf.Timestamp
+	37, // 2: FileAttr.m_time:type_name -> google.protobuf.Timestamp
+	37, // 3: FileAttr.c_time:type_name -> google.protobuf.Timestamp
 	0,  // 4: FileAttr.owner:type_name -> Owner
-	8,  // 5: LookupRequest.node:type_name -> DirEntry
-	19, // 6: CreateResponse.entry_valid:type_name -> google.protobuf.Timestamp
+	9,  // 5: LookupRequest.node:type_name -> DirEntry
+	37, // 6: CreateResponse.entry_valid:type_name -> google.protobuf.Timestamp
 	1,  // 7: CreateResponse.attr:type_name -> FileAttr
-	1,  // 8: DirEntry.attr:type_name -> FileAttr
-	8,  // 9: ReadDirAllResponse.entries:type_name -> DirEntry
-	8,  // 10: LinkResponse.node:type_name -> DirEntry
-	19, // 11: FileEvent.timestamp:type_name -> google.protobuf.Timestamp
-	16, // 12: Fuse.Auth:input_type -> AuthRequest
-	14, // 13: Fuse.DownloadFile:input_type -> DownloadRequest
-	20, // 14: Fuse.ObserveFileChanges:input_type -> google.protobuf.Empty
-	2,  // 15: Fuse.Lookup:input_type -> LookupRequest
-	8,  // 16: Fuse.ReadDirAll:input_type -> DirEntry
-	3,  // 17: Fuse.Mkdir:input_type -> MkdirRequest
-	8,  // 18: Fuse.Rmdir:input_type -> DirEntry
-	8,  // 19: Fuse.Getattr:input_type -> DirEntry
-	4,  // 20: Fuse.Create:input_type -> CreateRequest
-	12, // 21: Fuse.Symlink:input_type -> LinkRequest
-	12, // 22: Fuse.Link:input_type -> LinkRequest
-	8,  // 23: Fuse.ReadAll:input_type -> DirEntry
-	6,  // 24: Fuse.Write:input_type -> WriteRequest
-	7,  // 25: Fuse.Rename:input_type -> RenameRequest
-	17, // 26: Fuse.Auth:output_type -> AuthResponse
-	15, // 27: Fuse.DownloadFile:output_type -> FileChunk
-	18, // 28: Fuse.ObserveFileChanges:output_type -> FileEvent
-	8,  // 29: Fuse.Lookup:output_type -> DirEntry
-	9,  // 30: Fuse.ReadDirAll:output_type -> ReadDirAllResponse
-	8,  // 31: Fuse.Mkdir:output_type -> DirEntry
-	20, // 32: Fuse.Rmdir:output_type -> google.protobuf.Empty
-	1,  // 33: Fuse.Getattr:output_type -> FileAttr
-	5,  // 34: Fuse.Create:output_type -> CreateResponse
-	13, // 35: Fuse.Symlink:output_type -> LinkResponse
-	13, // 36: Fuse.Link:output_type -> LinkResponse
-	10, // 37: Fuse.ReadAll:output_type -> ReadAllResponse
-	11, // 38: Fuse.Write:output_type -> WriteResponse
-	20, // 39: Fuse.Rename:output_type -> google.protobuf.Empty
-	26, // [26:40] is the sub-list for method output_type
-	12, // [12:26] is the sub-list for method input_type
-	12, // [12:12] is the sub-list for extension type_name
-	12, // [12:12] is the sub-list for extension extendee
-	0,  // [0:12] is the sub-list for field type_name
+	37, // 8: SetattrRequest.a_time:type_name -> google.protobuf.Timestamp
+	37, // 9: SetattrRequest.m_time:type_name -> google.protobuf.Timestamp
+	1,  // 10: DirEntry.attr:type_name -> FileAttr
+	9,  // 11: ReadDirAllResponse.entries:type_name -> DirEntry
+	1,  // 12: PutFileResponse.attr:type_name -> FileAttr
+	37, // 13: FileVersion.created:type_name -> google.protobuf.Timestamp
+	19, // 14: VersionList.versions:type_name -> FileVersion
+	9,  // 15: LinkResponse.node:type_name -> DirEntry
+	37, // 16: ManifestEntry.m_time:type_name -> google.protobuf.Timestamp
+	32, // 17: ProfileResponse.profile:type_name -> Profile
+	37, // 18: FileEvent.timestamp:type_name -> google.protobuf.Timestamp
+	30, // 19: Fuse.Auth:input_type -> AuthRequest
+	38, // 20: Fuse.Hello:input_type -> google.protobuf.Empty
+	25, // 21: Fuse.DownloadFile:input_type -> DownloadRequest
+	26, // 22: Fuse.DownloadArchive:input_type -> ArchiveRequest
+	38, // 23: Fuse.ObserveFileChanges:input_type -> google.protobuf.Empty
+	28, // 24: Fuse.GetManifest:input_type -> ManifestRequest
+	2,  // 25: Fuse.Lookup:input_type -> LookupRequest
+	9,  // 26: Fuse.ReadDirAll:input_type -> DirEntry
+	3,  // 27: Fuse.Mkdir:input_type -> MkdirRequest
+	9,  // 28: Fuse.Rmdir:input_type -> DirEntry
+	9,  // 29: Fuse.Unlink:input_type -> DirEntry
+	9,  // 30: Fuse.Getattr:input_type -> DirEntry
+	7,  // 31: Fuse.Setattr:input_type -> SetattrRequest
+	4,  // 32: Fuse.Create:input_type -> CreateRequest
+	17, // 33: Fuse.Symlink:input_type -> LinkRequest
+	17, // 34: Fuse.Link:input_type -> LinkRequest
+	9,  // 35: Fuse.ReadAll:input_type -> DirEntry
+	6,  // 36: Fuse.Write:input_type -> WriteRequest
+	15, // 37: Fuse.PutFile:input_type -> PutFileRequest
+	13, // 38: Fuse.UploadFile:input_type -> UploadChunk
+	8,  // 39: Fuse.Rename:input_type -> RenameRequest
+	18, // 40: Fuse.Copy:input_type -> CopyRequest
+	38, // 41: Fuse.Statfs:input_type -> google.protobuf.Empty
+	9,  // 42: Fuse.ListVersions:input_type -> DirEntry
+	21, // 43: Fuse.RestoreVersion:input_type -> RestoreVersionRequest
+	38, // 44: Fuse.GetProfile:input_type -> google.protobuf.Empty
+	33, // 45: Fuse.UpdateProfile:input_type -> UpdateProfileRequest
+	34, // 46: Fuse.ConfirmEmail:input_type -> ConfirmEmailRequest
+	31, // 47: Fuse.Auth:output_type -> AuthResponse
+	22, // 48: Fuse.Hello:output_type -> ServerInfo
+	27, // 49: Fuse.DownloadFile:output_type -> FileChunk
+	27, // 50: Fuse.DownloadArchive:output_type -> FileChunk
+	36, // 51: Fuse.ObserveFileChanges:output_type -> FileEvent
+	29, // 52: Fuse.GetManifest:output_type -> ManifestEntry
+	9,  // 53: Fuse.Lookup:output_type -> DirEntry
+	10, // 54: Fuse.ReadDirAll:output_type -> ReadDirAllResponse
+	9,  // 55: Fuse.Mkdir:output_type -> DirEntry
+	38, // 56: Fuse.Rmdir:output_type -> google.protobuf.Empty
+	38, // 57: Fuse.Unlink:output_type -> google.protobuf.Empty
+	1,  // 58: Fuse.Getattr:output_type -> FileAttr
+	1,  // 59: Fuse.Setattr:output_type -> FileAttr
+	5,  // 60: Fuse.Create:output_type -> CreateResponse
+	24, // 61: Fuse.Symlink:output_type -> LinkResponse
+	24, // 62: Fuse.Link:output_type -> LinkResponse
+	11, // 63: Fuse.ReadAll:output_type -> ReadAllResponse
+	12, // 64: Fuse.Write:output_type -> WriteResponse
+	16, // 65: Fuse.PutFile:output_type -> PutFileResponse
+	14, // 66: Fuse.UploadFile:output_type -> UploadResponse
+	38, // 67: Fuse.Rename:output_type -> google.protobuf.Empty
+	9,  // 68: Fuse.Copy:output_type -> DirEntry
+	23, // 69: Fuse.Statfs:output_type -> StatfsResponse
+	20, // 70: Fuse.ListVersions:output_type -> VersionList
+	1,  // 71: Fuse.RestoreVersion:output_type -> FileAttr
+	32, // 72: Fuse.GetProfile:output_type -> Profile
+	35, // 73: Fuse.UpdateProfile:output_type -> ProfileResponse
+	35, // 74: Fuse.ConfirmEmail:output_type -> ProfileResponse
+	47, // [47:75] is the sub-list for method output_type
+	19, // [19:47] is the sub-list for method input_type
+	19, // [19:19] is the sub-list for extension type_name
+	19, // [19:19] is the sub-list for extension extendee
+	0,  // [0:19] is the sub-list for field type_name
 }
 
 func init() { file_lib_proto_fuse_proto_init() }
@@ -1332,13 +2610,14 @@ func file_lib_proto_fuse_proto_init() {
 	if File_lib_proto_fuse_proto != nil {
 		return
 	}
+	file_lib_proto_fuse_proto_msgTypes[7].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_lib_proto_fuse_proto_rawDesc), len(file_lib_proto_fuse_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   19,
+			NumMessages:   37,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -21,19 +21,33 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	Fuse_Auth_FullMethodName               = "/Fuse/Auth"
+	Fuse_Hello_FullMethodName              = "/Fuse/Hello"
 	Fuse_DownloadFile_FullMethodName       = "/Fuse/DownloadFile"
+	Fuse_DownloadArchive_FullMethodName    = "/Fuse/DownloadArchive"
 	Fuse_ObserveFileChanges_FullMethodName = "/Fuse/ObserveFileChanges"
+	Fuse_GetManifest_FullMethodName        = "/Fuse/GetManifest"
 	Fuse_Lookup_FullMethodName             = "/Fuse/Lookup"
 	Fuse_ReadDirAll_FullMethodName         = "/Fuse/ReadDirAll"
 	Fuse_Mkdir_FullMethodName              = "/Fuse/Mkdir"
 	Fuse_Rmdir_FullMethodName              = "/Fuse/Rmdir"
+	Fuse_Unlink_FullMethodName             = "/Fuse/Unlink"
 	Fuse_Getattr_FullMethodName            = "/Fuse/Getattr"
+	Fuse_Setattr_FullMethodName            = "/Fuse/Setattr"
 	Fuse_Create_FullMethodName             = "/Fuse/Create"
 	Fuse_Symlink_FullMethodName            = "/Fuse/Symlink"
 	Fuse_Link_FullMethodName               = "/Fuse/Link"
 	Fuse_ReadAll_FullMethodName            = "/Fuse/ReadAll"
 	Fuse_Write_FullMethodName              = "/Fuse/Write"
+	Fuse_PutFile_FullMethodName            = "/Fuse/PutFile"
+	Fuse_UploadFile_FullMethodName         = "/Fuse/UploadFile"
 	Fuse_Rename_FullMethodName             = "/Fuse/Rename"
+	Fuse_Copy_FullMethodName               = "/Fuse/Copy"
+	Fuse_Statfs_FullMethodName             = "/Fuse/Statfs"
+	Fuse_ListVersions_FullMethodName       = "/Fuse/ListVersions"
+	Fuse_RestoreVersion_FullMethodName     = "/Fuse/RestoreVersion"
+	Fuse_GetProfile_FullMethodName         = "/Fuse/GetProfile"
+	Fuse_UpdateProfile_FullMethodName      = "/Fuse/UpdateProfile"
+	Fuse_ConfirmEmail_FullMethodName       = "/Fuse/ConfirmEmail"
 )
 
 // FuseClient is the client API for Fuse service.
@@ -41,20 +55,34 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type FuseClient interface {
 	Auth(ctx context.Context, in *AuthRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	Hello(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ServerInfo, error)
 	DownloadFile(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileChunk], error)
+	DownloadArchive(ctx context.Context, in *ArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileChunk], error)
 	ObserveFileChanges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileEvent], error)
+	GetManifest(ctx context.Context, in *ManifestRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ManifestEntry], error)
 	// FUSE functions
 	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*DirEntry, error)
 	ReadDirAll(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*ReadDirAllResponse, error)
 	Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*DirEntry, error)
 	Rmdir(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Unlink(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	Getattr(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*FileAttr, error)
+	Setattr(ctx context.Context, in *SetattrRequest, opts ...grpc.CallOption) (*FileAttr, error)
 	Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*CreateResponse, error)
 	Symlink(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*LinkResponse, error)
 	Link(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*LinkResponse, error)
 	ReadAll(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*ReadAllResponse, error)
 	Write(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	PutFile(ctx context.Context, in *PutFileRequest, opts ...grpc.CallOption) (*PutFileResponse, error)
+	UploadFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadChunk, UploadResponse], error)
 	Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*DirEntry, error)
+	Statfs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StatfsResponse, error)
+	ListVersions(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*VersionList, error)
+	RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*FileAttr, error)
+	GetProfile(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Profile, error)
+	UpdateProfile(ctx context.Context, in *UpdateProfileRequest, opts ...grpc.CallOption) (*ProfileResponse, error)
+	ConfirmEmail(ctx context.Context, in *ConfirmEmailRequest, opts ...grpc.CallOption) (*ProfileResponse, error)
 }
 
 type fuseClient struct {
@@ -75,6 +103,16 @@ func (c *fuseClient) Auth(ctx context.Context, in *AuthRequest, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *fuseClient) Hello(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ServerInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServerInfo)
+	err := c.cc.Invoke(ctx, Fuse_Hello_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *fuseClient) DownloadFile(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileChunk], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	stream, err := c.cc.NewStream(ctx, &Fuse_ServiceDesc.Streams[0], Fuse_DownloadFile_FullMethodName, cOpts...)
@@ -94,9 +132,28 @@ func (c *fuseClient) DownloadFile(ctx context.Context, in *DownloadRequest, opts
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Fuse_DownloadFileClient = grpc.ServerStreamingClient[FileChunk]
 
+func (c *fuseClient) DownloadArchive(ctx context.Context, in *ArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Fuse_ServiceDesc.Streams[1], Fuse_DownloadArchive_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ArchiveRequest, FileChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fuse_DownloadArchiveClient = grpc.ServerStreamingClient[FileChunk]
+
 func (c *fuseClient) ObserveFileChanges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileEvent], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Fuse_ServiceDesc.Streams[1], Fuse_ObserveFileChanges_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Fuse_ServiceDesc.Streams[2], Fuse_ObserveFileChanges_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +170,25 @@ func (c *fuseClient) ObserveFileChanges(ctx context.Context, in *emptypb.Empty,
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Fuse_ObserveFileChangesClient = grpc.ServerStreamingClient[FileEvent]
 
+func (c *fuseClient) GetManifest(ctx context.Context, in *ManifestRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ManifestEntry], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Fuse_ServiceDesc.Streams[3], Fuse_GetManifest_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ManifestRequest, ManifestEntry]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fuse_GetManifestClient = grpc.ServerStreamingClient[ManifestEntry]
+
 func (c *fuseClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*DirEntry, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(DirEntry)
@@ -153,6 +229,16 @@ func (c *fuseClient) Rmdir(ctx context.Context, in *DirEntry, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *fuseClient) Unlink(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Fuse_Unlink_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *fuseClient) Getattr(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*FileAttr, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(FileAttr)
@@ -163,6 +249,16 @@ func (c *fuseClient) Getattr(ctx context.Context, in *DirEntry, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *fuseClient) Setattr(ctx context.Context, in *SetattrRequest, opts ...grpc.CallOption) (*FileAttr, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FileAttr)
+	err := c.cc.Invoke(ctx, Fuse_Setattr_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *fuseClient) Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*CreateResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(CreateResponse)
@@ -213,6 +309,29 @@ func (c *fuseClient) Write(ctx context.Context, in *WriteRequest, opts ...grpc.C
 	return out, nil
 }
 
+func (c *fuseClient) PutFile(ctx context.Context, in *PutFileRequest, opts ...grpc.CallOption) (*PutFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutFileResponse)
+	err := c.cc.Invoke(ctx, Fuse_PutFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) UploadFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadChunk, UploadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Fuse_ServiceDesc.Streams[4], Fuse_UploadFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadChunk, UploadResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fuse_UploadFileClient = grpc.ClientStreamingClient[UploadChunk, UploadResponse]
+
 func (c *fuseClient) Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(emptypb.Empty)
@@ -223,25 +342,109 @@ func (c *fuseClient) Rename(ctx context.Context, in *RenameRequest, opts ...grpc
 	return out, nil
 }
 
+func (c *fuseClient) Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*DirEntry, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DirEntry)
+	err := c.cc.Invoke(ctx, Fuse_Copy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) Statfs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*StatfsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatfsResponse)
+	err := c.cc.Invoke(ctx, Fuse_Statfs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) ListVersions(ctx context.Context, in *DirEntry, opts ...grpc.CallOption) (*VersionList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VersionList)
+	err := c.cc.Invoke(ctx, Fuse_ListVersions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*FileAttr, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FileAttr)
+	err := c.cc.Invoke(ctx, Fuse_RestoreVersion_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) GetProfile(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Profile, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Profile)
+	err := c.cc.Invoke(ctx, Fuse_GetProfile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) UpdateProfile(ctx context.Context, in *UpdateProfileRequest, opts ...grpc.CallOption) (*ProfileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProfileResponse)
+	err := c.cc.Invoke(ctx, Fuse_UpdateProfile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fuseClient) ConfirmEmail(ctx context.Context, in *ConfirmEmailRequest, opts ...grpc.CallOption) (*ProfileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProfileResponse)
+	err := c.cc.Invoke(ctx, Fuse_ConfirmEmail_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FuseServer is the server API for Fuse service.
 // All implementations must embed UnimplementedFuseServer
 // for forward compatibility.
 type FuseServer interface {
 	Auth(context.Context, *AuthRequest) (*AuthResponse, error)
+	Hello(context.Context, *emptypb.Empty) (*ServerInfo, error)
 	DownloadFile(*DownloadRequest, grpc.ServerStreamingServer[FileChunk]) error
+	DownloadArchive(*ArchiveRequest, grpc.ServerStreamingServer[FileChunk]) error
 	ObserveFileChanges(*emptypb.Empty, grpc.ServerStreamingServer[FileEvent]) error
+	GetManifest(*ManifestRequest, grpc.ServerStreamingServer[ManifestEntry]) error
 	// FUSE functions
 	Lookup(context.Context, *LookupRequest) (*DirEntry, error)
 	ReadDirAll(context.Context, *DirEntry) (*ReadDirAllResponse, error)
 	Mkdir(context.Context, *MkdirRequest) (*DirEntry, error)
 	Rmdir(context.Context, *DirEntry) (*emptypb.Empty, error)
+	Unlink(context.Context, *DirEntry) (*emptypb.Empty, error)
 	Getattr(context.Context, *DirEntry) (*FileAttr, error)
+	Setattr(context.Context, *SetattrRequest) (*FileAttr, error)
 	Create(context.Context, *CreateRequest) (*CreateResponse, error)
 	Symlink(context.Context, *LinkRequest) (*LinkResponse, error)
 	Link(context.Context, *LinkRequest) (*LinkResponse, error)
 	ReadAll(context.Context, *DirEntry) (*ReadAllResponse, error)
 	Write(context.Context, *WriteRequest) (*WriteResponse, error)
+	PutFile(context.Context, *PutFileRequest) (*PutFileResponse, error)
+	UploadFile(grpc.ClientStreamingServer[UploadChunk, UploadResponse]) error
 	Rename(context.Context, *RenameRequest) (*emptypb.Empty, error)
+	Copy(context.Context, *CopyRequest) (*DirEntry, error)
+	Statfs(context.Context, *emptypb.Empty) (*StatfsResponse, error)
+	ListVersions(context.Context, *DirEntry) (*VersionList, error)
+	RestoreVersion(context.Context, *RestoreVersionRequest) (*FileAttr, error)
+	GetProfile(context.Context, *emptypb.Empty) (*Profile, error)
+	UpdateProfile(context.Context, *UpdateProfileRequest) (*ProfileResponse, error)
+	ConfirmEmail(context.Context, *ConfirmEmailRequest) (*ProfileResponse, error)
 	mustEmbedUnimplementedFuseServer()
 }
 
@@ -255,12 +458,21 @@ type UnimplementedFuseServer struct{}
 func (UnimplementedFuseServer) Auth(context.Context, *AuthRequest) (*AuthResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Auth not implemented")
 }
+func (UnimplementedFuseServer) Hello(context.Context, *emptypb.Empty) (*ServerInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hello not implemented")
+}
 func (UnimplementedFuseServer) DownloadFile(*DownloadRequest, grpc.ServerStreamingServer[FileChunk]) error {
 	return status.Errorf(codes.Unimplemented, "method DownloadFile not implemented")
 }
+func (UnimplementedFuseServer) DownloadArchive(*ArchiveRequest, grpc.ServerStreamingServer[FileChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadArchive not implemented")
+}
 func (UnimplementedFuseServer) ObserveFileChanges(*emptypb.Empty, grpc.ServerStreamingServer[FileEvent]) error {
 	return status.Errorf(codes.Unimplemented, "method ObserveFileChanges not implemented")
 }
+func (UnimplementedFuseServer) GetManifest(*ManifestRequest, grpc.ServerStreamingServer[ManifestEntry]) error {
+	return status.Errorf(codes.Unimplemented, "method GetManifest not implemented")
+}
 func (UnimplementedFuseServer) Lookup(context.Context, *LookupRequest) (*DirEntry, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
 }
@@ -273,9 +485,15 @@ func (UnimplementedFuseServer) Mkdir(context.Context, *MkdirRequest) (*DirEntry,
 func (UnimplementedFuseServer) Rmdir(context.Context, *DirEntry) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Rmdir not implemented")
 }
+func (UnimplementedFuseServer) Unlink(context.Context, *DirEntry) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unlink not implemented")
+}
 func (UnimplementedFuseServer) Getattr(context.Context, *DirEntry) (*FileAttr, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Getattr not implemented")
 }
+func (UnimplementedFuseServer) Setattr(context.Context, *SetattrRequest) (*FileAttr, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Setattr not implemented")
+}
 func (UnimplementedFuseServer) Create(context.Context, *CreateRequest) (*CreateResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Create not implemented")
 }
@@ -291,9 +509,36 @@ func (UnimplementedFuseServer) ReadAll(context.Context, *DirEntry) (*ReadAllResp
 func (UnimplementedFuseServer) Write(context.Context, *WriteRequest) (*WriteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Write not implemented")
 }
+func (UnimplementedFuseServer) PutFile(context.Context, *PutFileRequest) (*PutFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutFile not implemented")
+}
+func (UnimplementedFuseServer) UploadFile(grpc.ClientStreamingServer[UploadChunk, UploadResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
+}
 func (UnimplementedFuseServer) Rename(context.Context, *RenameRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
 }
+func (UnimplementedFuseServer) Copy(context.Context, *CopyRequest) (*DirEntry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Copy not implemented")
+}
+func (UnimplementedFuseServer) Statfs(context.Context, *emptypb.Empty) (*StatfsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Statfs not implemented")
+}
+func (UnimplementedFuseServer) ListVersions(context.Context, *DirEntry) (*VersionList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedFuseServer) RestoreVersion(context.Context, *RestoreVersionRequest) (*FileAttr, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedFuseServer) GetProfile(context.Context, *emptypb.Empty) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProfile not implemented")
+}
+func (UnimplementedFuseServer) UpdateProfile(context.Context, *UpdateProfileRequest) (*ProfileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateProfile not implemented")
+}
+func (UnimplementedFuseServer) ConfirmEmail(context.Context, *ConfirmEmailRequest) (*ProfileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmEmail not implemented")
+}
 func (UnimplementedFuseServer) mustEmbedUnimplementedFuseServer() {}
 func (UnimplementedFuseServer) testEmbeddedByValue()              {}
 
@@ -333,6 +578,24 @@ func _Fuse_Auth_Handler(srv interface{}, ctx context.Context, dec func(interface
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Fuse_Hello_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).Hello(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_Hello_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).Hello(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Fuse_DownloadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(DownloadRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -344,6 +607,17 @@ func _Fuse_DownloadFile_Handler(srv interface{}, stream grpc.ServerStream) error
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Fuse_DownloadFileServer = grpc.ServerStreamingServer[FileChunk]
 
+func _Fuse_DownloadArchive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ArchiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FuseServer).DownloadArchive(m, &grpc.GenericServerStream[ArchiveRequest, FileChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fuse_DownloadArchiveServer = grpc.ServerStreamingServer[FileChunk]
+
 func _Fuse_ObserveFileChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(emptypb.Empty)
 	if err := stream.RecvMsg(m); err != nil {
@@ -355,6 +629,17 @@ func _Fuse_ObserveFileChanges_Handler(srv interface{}, stream grpc.ServerStream)
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Fuse_ObserveFileChangesServer = grpc.ServerStreamingServer[FileEvent]
 
+func _Fuse_GetManifest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ManifestRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FuseServer).GetManifest(m, &grpc.GenericServerStream[ManifestRequest, ManifestEntry]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fuse_GetManifestServer = grpc.ServerStreamingServer[ManifestEntry]
+
 func _Fuse_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(LookupRequest)
 	if err := dec(in); err != nil {
@@ -427,6 +712,24 @@ func _Fuse_Rmdir_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Fuse_Unlink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DirEntry)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).Unlink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_Unlink_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).Unlink(ctx, req.(*DirEntry))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Fuse_Getattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DirEntry)
 	if err := dec(in); err != nil {
@@ -445,6 +748,24 @@ func _Fuse_Getattr_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Fuse_Setattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).Setattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_Setattr_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).Setattr(ctx, req.(*SetattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Fuse_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(CreateRequest)
 	if err := dec(in); err != nil {
@@ -535,6 +856,31 @@ func _Fuse_Write_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Fuse_PutFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).PutFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_PutFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).PutFile(ctx, req.(*PutFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_UploadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FuseServer).UploadFile(&grpc.GenericServerStream[UploadChunk, UploadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fuse_UploadFileServer = grpc.ClientStreamingServer[UploadChunk, UploadResponse]
+
 func _Fuse_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(RenameRequest)
 	if err := dec(in); err != nil {
@@ -553,6 +899,132 @@ func _Fuse_Rename_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Fuse_Copy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).Copy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_Copy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).Copy(ctx, req.(*CopyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_Statfs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).Statfs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_Statfs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).Statfs(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DirEntry)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_ListVersions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).ListVersions(ctx, req.(*DirEntry))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_RestoreVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).RestoreVersion(ctx, req.(*RestoreVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_GetProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).GetProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_GetProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).GetProfile(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_UpdateProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).UpdateProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_UpdateProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).UpdateProfile(ctx, req.(*UpdateProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fuse_ConfirmEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FuseServer).ConfirmEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fuse_ConfirmEmail_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FuseServer).ConfirmEmail(ctx, req.(*ConfirmEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Fuse_ServiceDesc is the grpc.ServiceDesc for Fuse service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -564,6 +1036,10 @@ var Fuse_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Auth",
 			Handler:    _Fuse_Auth_Handler,
 		},
+		{
+			MethodName: "Hello",
+			Handler:    _Fuse_Hello_Handler,
+		},
 		{
 			MethodName: "Lookup",
 			Handler:    _Fuse_Lookup_Handler,
@@ -580,10 +1056,18 @@ var Fuse_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Rmdir",
 			Handler:    _Fuse_Rmdir_Handler,
 		},
+		{
+			MethodName: "Unlink",
+			Handler:    _Fuse_Unlink_Handler,
+		},
 		{
 			MethodName: "Getattr",
 			Handler:    _Fuse_Getattr_Handler,
 		},
+		{
+			MethodName: "Setattr",
+			Handler:    _Fuse_Setattr_Handler,
+		},
 		{
 			MethodName: "Create",
 			Handler:    _Fuse_Create_Handler,
@@ -604,10 +1088,42 @@ var Fuse_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Write",
 			Handler:    _Fuse_Write_Handler,
 		},
+		{
+			MethodName: "PutFile",
+			Handler:    _Fuse_PutFile_Handler,
+		},
 		{
 			MethodName: "Rename",
 			Handler:    _Fuse_Rename_Handler,
 		},
+		{
+			MethodName: "Copy",
+			Handler:    _Fuse_Copy_Handler,
+		},
+		{
+			MethodName: "Statfs",
+			Handler:    _Fuse_Statfs_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _Fuse_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _Fuse_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "GetProfile",
+			Handler:    _Fuse_GetProfile_Handler,
+		},
+		{
+			MethodName: "UpdateProfile",
+			Handler:    _Fuse_UpdateProfile_Handler,
+		},
+		{
+			MethodName: "ConfirmEmail",
+			Handler:    _Fuse_ConfirmEmail_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -615,11 +1131,26 @@ var Fuse_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Fuse_DownloadFile_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "DownloadArchive",
+			Handler:       _Fuse_DownloadArchive_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "ObserveFileChanges",
 			Handler:       _Fuse_ObserveFileChanges_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetManifest",
+			Handler:       _Fuse_GetManifest_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UploadFile",
+			Handler:       _Fuse_UploadFile_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "lib/proto/fuse.proto",
 }
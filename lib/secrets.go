@@ -0,0 +1,274 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secrets and other settings (SECRET_KEY, DB_PASSWORD, SMTP_*...) are
+// read from the source named by $FUSION_SECRETS:
+//
+//	dotenv (default)  ~/.fusion/.env, then the environment
+//	env               the environment only
+//	vault             a HashiCorp Vault KV secret at $VAULT_SECRET_PATH,
+//	                  read from $VAULT_ADDR with $VAULT_TOKEN
+//
+// Whatever the source, NAME_FILE pointing at a file makes the file's
+// contents the value of NAME. This is how container secrets are
+// usually handed over
+const SECRETS_SOURCE_ENV = "FUSION_SECRETS"
+
+const (
+	SECRETS_DOTENV = "dotenv"
+	SECRETS_ENV    = "env"
+	SECRETS_VAULT  = "vault"
+)
+
+var (
+	secretsOnce  sync.Once
+	secretsErr   error
+	vaultSecrets map[string]string
+	secretsMu    sync.RWMutex
+)
+
+// Loads ~/.fusion/.env into the environment. A missing file is not an
+// error; the values may come from the environment instead
+func LoadEnv() error {
+	envFile := filepath.Join(ProjectDir, ".env")
+
+	data, err := os.ReadFile(envFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid .env file format near; %v", line)
+		}
+
+		key := strings.Trim(fields[0], "\"")
+		value := strings.Trim(fields[1], "\"")
+		err = os.Setenv(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func secretsSource() string {
+	source := strings.ToLower(strings.TrimSpace(os.Getenv(SECRETS_SOURCE_ENV)))
+	if source == "" {
+		return SECRETS_DOTENV
+	}
+	return source
+}
+
+// Prepares the configured source. Runs once
+func loadSecrets() error {
+	secretsOnce.Do(func() {
+		vaultSecrets, secretsErr = readSource()
+	})
+	return secretsErr
+}
+
+// Reads the configured source. Only Vault secrets are kept aside;
+// the other sources end up in the environment
+func readSource() (map[string]string, error) {
+	switch source := secretsSource(); source {
+	case SECRETS_DOTENV:
+		return nil, LoadEnv()
+	case SECRETS_ENV:
+		return nil, nil
+	case SECRETS_VAULT:
+		return readVault()
+	default:
+		return nil, fmt.Errorf("unknown $%v %q; expected %v, %v or %v", SECRETS_SOURCE_ENV, source, SECRETS_DOTENV, SECRETS_ENV, SECRETS_VAULT)
+	}
+}
+
+// Reads the configured source again so that rotated secrets are
+// picked up without a restart
+func ReloadSecrets() error {
+	err := loadSecrets()
+	if err != nil {
+		return err
+	}
+
+	secrets, err := readSource()
+	if err != nil {
+		return err
+	}
+	secretsMu.Lock()
+	vaultSecrets = secrets
+	secretsMu.Unlock()
+	return nil
+}
+
+// Returned by SaveSecrets when the secrets live somewhere the server
+// doesn't write to
+var ErrSecretsReadOnly = errors.New("secrets can't be changed by the server")
+
+// Sets secrets in ~/.fusion/.env and reads the source again. Only the
+// dotenv source can be written; secrets from the environment, Vault or
+// a NAME_FILE are changed where they live
+func SaveSecrets(values map[string]string) error {
+	if source := secretsSource(); source != SECRETS_DOTENV {
+		return fmt.Errorf("%w; they come from %v", ErrSecretsReadOnly, source)
+	}
+	for name := range values {
+		if os.Getenv(name+"_FILE") != "" {
+			return fmt.Errorf("%w; %v comes from %v_FILE", ErrSecretsReadOnly, name, name)
+		}
+	}
+
+	envFile := filepath.Join(ProjectDir, ".env")
+	data, err := os.ReadFile(envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := []string{}
+	saved := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(line, "=")
+		key = strings.Trim(key, "\"")
+		if value, ok := values[key]; ok {
+			line = key + "=" + value
+			saved[key] = true
+		}
+		lines = append(lines, line)
+	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if !saved[key] {
+			lines = append(lines, key+"="+values[key])
+		}
+	}
+
+	// Replaced whole so a crash can't leave the keys half written
+	tmp := envFile + ".tmp"
+	err = os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, envFile)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return ReloadSecrets()
+}
+
+// Returns the value of secret name and whether it was found
+func LookupSecret(name string) (string, bool, error) {
+	err := loadSecrets()
+	if err != nil {
+		return "", false, err
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("error reading %v_FILE; %v", name, err)
+		}
+		return strings.TrimSpace(string(data)), true, nil
+	}
+
+	secretsMu.RLock()
+	value, ok := vaultSecrets[name]
+	secretsMu.RUnlock()
+	if ok {
+		return value, true, nil
+	}
+	value, ok = os.LookupEnv(name)
+	return value, ok, nil
+}
+
+// Returns secret name or an empty string if it isn't set. For
+// settings that have defaults or are only needed by some features
+func Secret(name string) string {
+	value, _, _ := LookupSecret(name)
+	return value
+}
+
+// Returns secret name or an error saying where it was looked for
+func RequireSecret(name string) (string, error) {
+	value, ok, err := LookupSecret(name)
+	if err != nil {
+		return "", fmt.Errorf("error loading %v from %v; %v", name, secretsSource(), err)
+	}
+	if !ok || strings.TrimSpace(value) == "" {
+		return "", fmt.Errorf("missing %v; set it in the %v secrets source or point %v_FILE at a file holding it", name, secretsSource(), name)
+	}
+	return value, nil
+}
+
+// Reads every key of the Vault secret at $VAULT_SECRET_PATH. Works
+// with both version 1 and version 2 KV engines
+func readVault() (map[string]string, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	path := strings.Trim(os.Getenv("VAULT_SECRET_PATH"), "/")
+	if addr == "" || path == "" {
+		return nil, fmt.Errorf("vault secrets require $VAULT_ADDR and $VAULT_SECRET_PATH")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting vault; %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %v for %v", resp.Status, path)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vault response; %v", err)
+	}
+
+	// KV version 2 nests the values one level down
+	data := body.Data
+	nested, ok := data["data"].(map[string]any)
+	if ok {
+		data = nested
+	}
+
+	secrets := make(map[string]string, len(data))
+	for key, value := range data {
+		secrets[key] = fmt.Sprint(value)
+	}
+	return secrets, nil
+}
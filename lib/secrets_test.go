@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Reads secrets from source, starting over from an unloaded state,
+// with ~/.fusion in a temporary directory
+func useSecretsSource(t *testing.T, source string) {
+	t.Helper()
+	reset := func() {
+		secretsOnce = sync.Once{}
+		secretsErr = nil
+		vaultSecrets = nil
+	}
+	oldDir := ProjectDir
+	ProjectDir = t.TempDir()
+	t.Setenv(SECRETS_SOURCE_ENV, source)
+	reset()
+	t.Cleanup(func() {
+		ProjectDir = oldDir
+		reset()
+	})
+}
+
+func TestSecretFromEnv(t *testing.T) {
+	useSecretsSource(t, SECRETS_ENV)
+	t.Setenv("FUSION_TEST_SECRET", "from env")
+	err := os.WriteFile(filepath.Join(ProjectDir, ".env"), []byte("FUSION_TEST_SECRET=from file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := RequireSecret("FUSION_TEST_SECRET")
+	if err != nil || value != "from env" {
+		t.Errorf("secret = %q, %v; want %q", value, err, "from env")
+	}
+}
+
+func TestSecretFromDotenv(t *testing.T) {
+	useSecretsSource(t, SECRETS_DOTENV)
+	// Restores the environment LoadEnv writes to
+	t.Setenv("FUSION_TEST_SECRET", "")
+	err := os.WriteFile(filepath.Join(ProjectDir, ".env"), []byte("FUSION_TEST_SECRET=\"from file\"\n\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := RequireSecret("FUSION_TEST_SECRET")
+	if err != nil || value != "from file" {
+		t.Errorf("secret = %q, %v; want %q", value, err, "from file")
+	}
+}
+
+// A missing .env is no error; the environment is still read
+func TestSecretWithoutDotenv(t *testing.T) {
+	useSecretsSource(t, SECRETS_DOTENV)
+	t.Setenv("FUSION_TEST_SECRET", "from env")
+
+	value, err := RequireSecret("FUSION_TEST_SECRET")
+	if err != nil || value != "from env" {
+		t.Errorf("secret = %q, %v; want %q", value, err, "from env")
+	}
+}
+
+// NAME_FILE wins over the source
+func TestSecretFromFile(t *testing.T) {
+	useSecretsSource(t, SECRETS_ENV)
+	file := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(file, []byte("from secret file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FUSION_TEST_SECRET", "from env")
+	t.Setenv("FUSION_TEST_SECRET_FILE", file)
+
+	value, err := RequireSecret("FUSION_TEST_SECRET")
+	if err != nil || value != "from secret file" {
+		t.Errorf("secret = %q, %v; want %q", value, err, "from secret file")
+	}
+
+	t.Setenv("FUSION_TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := RequireSecret("FUSION_TEST_SECRET"); err == nil {
+		t.Error("missing secret file accepted")
+	}
+}
+
+func TestSecretFromVault(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/fusion" || r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"FUSION_TEST_SECRET": "from vault"}}}`))
+	}))
+	t.Cleanup(vault.Close)
+
+	useSecretsSource(t, SECRETS_VAULT)
+	t.Setenv("VAULT_ADDR", vault.URL+"/")
+	t.Setenv("VAULT_SECRET_PATH", "/secret/data/fusion")
+	t.Setenv("VAULT_TOKEN", "token")
+	t.Setenv("FUSION_TEST_SECRET", "from env")
+
+	value, err := RequireSecret("FUSION_TEST_SECRET")
+	if err != nil || value != "from vault" {
+		t.Errorf("secret = %q, %v; want %q", value, err, "from vault")
+	}
+
+	// Rotated to a token vault refuses
+	t.Setenv("VAULT_TOKEN", "revoked")
+	if err := ReloadSecrets(); err == nil {
+		t.Error("reload with a refused token succeeded")
+	}
+}
+
+func TestMissingSecret(t *testing.T) {
+	useSecretsSource(t, SECRETS_ENV)
+	t.Setenv("FUSION_TEST_MISSING", "")
+	os.Unsetenv("FUSION_TEST_MISSING")
+
+	if value := Secret("FUSION_TEST_MISSING"); value != "" {
+		t.Errorf("missing optional secret = %q; want empty", value)
+	}
+	_, err := RequireSecret("FUSION_TEST_MISSING")
+	if err == nil || !strings.Contains(err.Error(), "FUSION_TEST_MISSING") {
+		t.Errorf("missing required secret = %v; want an error naming it", err)
+	}
+
+	useSecretsSource(t, "keyring")
+	_, err = RequireSecret("FUSION_TEST_MISSING")
+	if err == nil || !strings.Contains(err.Error(), SECRETS_SOURCE_ENV) {
+		t.Errorf("unknown source = %v; want an error naming $%v", err, SECRETS_SOURCE_ENV)
+	}
+}
+
+// Saved secrets replace their lines in .env, or are added to it, and
+// are read back straight away
+func TestSaveSecrets(t *testing.T) {
+	useSecretsSource(t, SECRETS_DOTENV)
+	t.Setenv("FUSION_TEST_SECRET", "")
+	t.Setenv("FUSION_TEST_NEW", "")
+	t.Setenv("FUSION_TEST_OTHER", "")
+	envFile := filepath.Join(ProjectDir, ".env")
+	err := os.WriteFile(envFile, []byte("FUSION_TEST_OTHER=kept\n\"FUSION_TEST_SECRET\"=\"old\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveSecrets(map[string]string{"FUSION_TEST_SECRET": "new", "FUSION_TEST_NEW": "added"})
+	if err != nil {
+		t.Fatalf("SaveSecrets = %v", err)
+	}
+	data, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "FUSION_TEST_OTHER=kept\nFUSION_TEST_SECRET=new\nFUSION_TEST_NEW=added\n"
+	if string(data) != want {
+		t.Errorf(".env = %q; want %q", data, want)
+	}
+	for name, want := range map[string]string{"FUSION_TEST_SECRET": "new", "FUSION_TEST_NEW": "added", "FUSION_TEST_OTHER": "kept"} {
+		if value := Secret(name); value != want {
+			t.Errorf("%v = %q; want %q", name, value, want)
+		}
+	}
+	if info, err := os.Stat(envFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf(".env mode = %v, %v; want 0600", info.Mode(), err)
+	}
+}
+
+// Secrets the server can't write to are refused, leaving .env alone
+func TestSaveSecretsReadOnly(t *testing.T) {
+	useSecretsSource(t, SECRETS_ENV)
+	err := SaveSecrets(map[string]string{"FUSION_TEST_SECRET": "new"})
+	if !errors.Is(err, ErrSecretsReadOnly) {
+		t.Errorf("SaveSecrets from the environment = %v; want ErrSecretsReadOnly", err)
+	}
+
+	useSecretsSource(t, SECRETS_DOTENV)
+	t.Setenv("FUSION_TEST_SECRET_FILE", filepath.Join(t.TempDir(), "secret"))
+	err = SaveSecrets(map[string]string{"FUSION_TEST_SECRET": "new"})
+	if !errors.Is(err, ErrSecretsReadOnly) {
+		t.Errorf("SaveSecrets of a NAME_FILE secret = %v; want ErrSecretsReadOnly", err)
+	}
+	if _, err := os.Stat(filepath.Join(ProjectDir, ".env")); !os.IsNotExist(err) {
+		t.Errorf("refused save wrote .env; %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reuses the result of statfs(2) for a short while. df and some file
+// managers call Statfs over and over and every node of a mount sits on
+// the same backing filesystem, so one result serves them all.
+//
+// Writes are counted with AddUsage; once they add up to more than 1%
+// of the filesystem the cached result is dropped early
+type StatfsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	stat    syscall.Statfs_t
+	fetched time.Time
+	written int64
+}
+
+var statfs = syscall.Statfs
+
+// A ttl of 0 disables caching
+func NewStatfsCache(ttl time.Duration) *StatfsCache {
+	return &StatfsCache{ttl: ttl}
+}
+
+func (c *StatfsCache) Statfs(path string, out *syscall.Statfs_t) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl > 0 && !c.fetched.IsZero() && time.Since(c.fetched) < c.ttl {
+		*out = c.stat
+		return nil
+	}
+
+	err := statfs(path, out)
+	if err != nil {
+		return err
+	}
+	c.stat = *out
+	c.fetched = time.Now()
+	c.written = 0
+	return nil
+}
+
+// Records n bytes written to or freed on the filesystem
+func (c *StatfsCache) AddUsage(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fetched.IsZero() {
+		return
+	}
+	if n < 0 {
+		n = -n
+	}
+	c.written += n
+	size := int64(c.stat.Blocks) * int64(c.stat.Bsize)
+	if c.written > size/100 {
+		c.fetched = time.Time{}
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Counts statfs(2) calls while the test runs
+func useCountedStatfs(t *testing.T) *int {
+	calls := 0
+	old := statfs
+	statfs = func(path string, out *syscall.Statfs_t) error {
+		calls++
+		return old(path, out)
+	}
+	t.Cleanup(func() { statfs = old })
+	return &calls
+}
+
+// Calls within the ttl share one statfs(2); a ttl of 0 caches nothing
+func TestStatfsCacheReusesResult(t *testing.T) {
+	calls := useCountedStatfs(t)
+	dir := t.TempDir()
+	cache := NewStatfsCache(time.Hour)
+	stat := syscall.Statfs_t{}
+	for range 10 {
+		err := cache.Statfs(dir, &stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("10 Statfs within the ttl made %v syscalls; want 1", *calls)
+	}
+	if stat.Blocks == 0 {
+		t.Error("cached result is empty")
+	}
+
+	*calls = 0
+	cache = NewStatfsCache(0)
+	for range 3 {
+		cache.Statfs(dir, &stat)
+	}
+	if *calls != 3 {
+		t.Errorf("3 uncached Statfs made %v syscalls; want 3", *calls)
+	}
+
+	*calls = 0
+	cache = NewStatfsCache(time.Millisecond)
+	cache.Statfs(dir, &stat)
+	time.Sleep(5 * time.Millisecond)
+	cache.Statfs(dir, &stat)
+	if *calls != 2 {
+		t.Errorf("Statfs after the ttl made %v syscalls; want 2", *calls)
+	}
+}
+
+// Writes adding up to more than 1% of the filesystem drop the result
+func TestStatfsCacheDroppedAfterWrites(t *testing.T) {
+	calls := useCountedStatfs(t)
+	dir := t.TempDir()
+	cache := NewStatfsCache(time.Hour)
+	stat := syscall.Statfs_t{}
+	err := cache.Statfs(dir, &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	percent := int64(stat.Blocks) * int64(stat.Bsize) / 100
+
+	cache.AddUsage(percent / 2)
+	cache.AddUsage(-percent / 2) // freeing space changes usage too
+	cache.Statfs(dir, &stat)
+	if *calls != 1 {
+		t.Errorf("%v syscalls after writes of 1%%; want 1", *calls)
+	}
+
+	cache.AddUsage(1)
+	cache.Statfs(dir, &stat)
+	if *calls != 2 {
+		t.Errorf("%v syscalls after writes past 1%%; want 2", *calls)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 const (
@@ -20,6 +21,29 @@ func ValidateName(field, name string) error {
 	return nil
 }
 
+// Checks that name can safely be used as a single directory name.
+// Org and department names are joined straight into paths on disk
+func ValidatePathComponent(field, name string) error {
+	if name == "" {
+		return fmt.Errorf("%v required", field)
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("%v cannot be %q", field, name)
+	}
+	if strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("%v cannot contain path separators", field)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%v cannot contain control characters", field)
+		}
+	}
+	if strings.Trim(name, ". ") != name {
+		return fmt.Errorf("%v cannot start or end with a dot or space", field)
+	}
+	return nil
+}
+
 func ValidateEmail(email string) error {
 	if !strings.Contains(email, "@") || !strings.Contains(email, ".com") {
 		return fmt.Errorf("invalid email address")
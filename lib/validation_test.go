@@ -0,0 +1,33 @@
+package lib
+
+import "testing"
+
+func TestValidatePathComponent(t *testing.T) {
+	bad := []string{
+		"",
+		".",
+		"..",
+		"../etc",
+		"org/dept",
+		"org\\dept",
+		"/org",
+		"org\x00",
+		"org\nname",
+		".hidden",
+		"trailing.",
+		" org",
+		"org ",
+	}
+	for _, name := range bad {
+		if err := ValidatePathComponent("orgName", name); err == nil {
+			t.Errorf("ValidatePathComponent(%q) accepted", name)
+		}
+	}
+
+	good := []string{"acme", "Acme Corp", "r&d", "dept.2", "營業部"}
+	for _, name := range good {
+		if err := ValidatePathComponent("orgName", name); err != nil {
+			t.Errorf("ValidatePathComponent(%q) = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Two clients appending to the same file at once both keep every
+// record, and each is told where its record landed
+func TestConcurrentAppendsKeepEveryWrite(t *testing.T) {
+	name := "/" + t.Name()
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	fullpath := filepath.Join(dir, name)
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(fullpath, nil, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(fullpath) })
+
+	const records = 50
+	offsets := make(map[string]int64)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, writer := range []string{"a", "b"} {
+		client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range records {
+				record := fmt.Sprintf("%v%03d\n", writer, i)
+				res, err := client.Write(ctx, &proto.WriteRequest{Path: name, Data: []byte(record), Append: true})
+				if err != nil {
+					t.Errorf("append %q failed; %v", record, err)
+					return
+				}
+				mu.Lock()
+				offsets[record] = res.Offset
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(fullpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2*records {
+		t.Errorf("file holds %v records; want %v", n, 2*records)
+	}
+	for record, offset := range offsets {
+		end := offset + int64(len(record))
+		if offset < 0 || end > int64(len(data)) || string(data[offset:end]) != record {
+			t.Errorf("%q not found at its reported offset %v", record, offset)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Writes a tar of the open directory dir to w as it walks it, so
+// trees of any size are never held in memory. Entries are named under
+// name. Entries for which hidden returns true, given their path
+// relative to dir, are left out along with everything below them.
+// Symlinks are archived as links and never followed; every entry is
+// opened relative to its parent's descriptor so a directory swapped
+// for a symlink mid-walk can't lead out. Sockets, devices and fifos
+// are skipped
+func writeArchive(w io.Writer, dir *os.File, name string, compress bool, hidden func(path string) bool) error {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	info, err := dir.Stat()
+	if err != nil {
+		return err
+	}
+	err = writeArchiveHeader(tw, info, name, "")
+	if err != nil {
+		return err
+	}
+	err = archiveDir(tw, dir, name, ".", hidden)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+func writeArchiveHeader(tw *tar.Writer, info os.FileInfo, name, link string) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	return tw.WriteHeader(header)
+}
+
+// Archives the entries of dir, which is at rel inside the archived
+// directory and named name in the archive
+func archiveDir(tw *tar.Writer, dir *os.File, name, rel string, hidden func(path string) bool) error {
+	entries, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	slices.Sort(entries)
+
+	dirfd := int(dir.Fd())
+	for _, entry := range entries {
+		entryRel := filepath.Join(rel, entry)
+		entryName := filepath.Join(name, entry)
+		if hidden(entryRel) {
+			continue
+		}
+
+		st := unix.Stat_t{}
+		err := unix.Fstatat(dirfd, entry, &st, unix.AT_SYMLINK_NOFOLLOW)
+		if err == unix.ENOENT {
+			// Removed while we were walking
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFLNK:
+			err = archiveSymlink(tw, dirfd, entry, entryName)
+		case unix.S_IFDIR:
+			err = archiveEntry(tw, dirfd, entry, entryName, unix.O_DIRECTORY, func(file *os.File) error {
+				return archiveDir(tw, file, entryName, entryRel, hidden)
+			})
+		case unix.S_IFREG:
+			err = archiveEntry(tw, dirfd, entry, entryName, 0, func(file *os.File) error {
+				return archiveContents(tw, file, entryRel)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opens entry of dirfd without following a symlink, writes its header
+// and hands it to archive. An entry that changed type since it was
+// listed is skipped
+func archiveEntry(tw *tar.Writer, dirfd int, entry, name string, flags int, archive func(*os.File) error) error {
+	fd, err := unix.Openat(dirfd, entry, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC|flags, 0)
+	if err == unix.ENOENT || err == unix.ELOOP || err == unix.ENOTDIR {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file := os.NewFile(uintptr(fd), name)
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if flags&unix.O_DIRECTORY == 0 && !info.Mode().IsRegular() {
+		return nil
+	}
+	err = writeArchiveHeader(tw, info, name, "")
+	if err != nil {
+		return err
+	}
+	return archive(file)
+}
+
+func archiveSymlink(tw *tar.Writer, dirfd int, entry, name string) error {
+	fd, err := unix.Openat(dirfd, entry, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err == unix.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file := os.NewFile(uintptr(fd), name)
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return err
+	}
+	buf := make([]byte, lib.PATH_MAX)
+	n, err := unix.Readlinkat(fd, "", buf)
+	if err != nil {
+		return err
+	}
+	return writeArchiveHeader(tw, info, name, string(buf[:n]))
+}
+
+func archiveContents(tw *tar.Writer, file *os.File, rel string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, io.LimitReader(file, info.Size()))
+	if err != nil {
+		return err
+	}
+	if n < info.Size() {
+		return fmt.Errorf("%v shrank while being archived", rel)
+	}
+	return nil
+}
+
+// Sends everything written to it as FileChunks of a download stream
+type chunkStreamWriter struct {
+	stream   grpc.ServerStreamingServer[proto.FileChunk]
+	deadline time.Time
+	sent     int64
+}
+
+func (w *chunkStreamWriter) Write(data []byte) (int, error) {
+	err := sendChunk(w.stream, &proto.FileChunk{
+		Data:   data,
+		Offset: w.sent,
+	}, w.deadline)
+	if err != nil {
+		return 0, err
+	}
+	w.sent += int64(len(data))
+	return len(data), nil
+}
+
+// Streams a directory as a tar, gzipped if asked to. Like
+// DownloadFile the download is aborted if the client stalls
+func (s FuseServer) DownloadArchive(req *proto.ArchiveRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
+	ctx := stream.Context()
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	user, err := currentUser(ctx)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+
+	root := filepath.Join(realpath, usersDir)
+	dir, err := openInDir(ctx, root, filepath.Join(root, req.Path), os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if errors.Is(err, syscall.ENOTDIR) {
+		return status.Errorf(codes.InvalidArgument, "%v is not a directory", req.Path)
+	}
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	defer dir.Close()
+
+	// Symlinks along req.Path may have led anywhere in the user's
+	// directory; hide by where the walk really is
+	resolved, err := resolvedPath(root, dir)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	log.Printf("[GRPC] DownloadArchive \"%v\"\n", req.Path)
+
+	var deadline time.Time
+	if downloadTimeout > 0 {
+		deadline = time.Now().Add(downloadTimeout)
+	}
+	out := bufio.NewWriterSize(&chunkStreamWriter{stream: stream, deadline: deadline}, lib.CHUNK_SIZE)
+
+	err = writeArchive(out, dir, filepath.Base(filepath.Join(root, req.Path)), req.Gzip, func(path string) bool {
+		return hiddenPath(ctx, user, filepath.Join(resolved, path))
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Printf("[GRPC] Error archiving %v; %v\n", req.Path, err)
+		return lib.StatusError(err)
+	}
+	return nil
+}
+
+// Downloads a directory, relative to the user's department directory,
+// as a tar. Add ?gzip to compress it
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	relDir := strings.Trim(chi.URLParam(r, "*"), "/")
+	root := filepath.Join(realpath, user.OrgName, user.DeptName)
+	name := user.DeptName
+	if relDir != "" {
+		_, ok := userPath(user, relDir)
+		if !ok {
+			errorResponse(w, http.StatusForbidden, ERR_FORBIDDEN, "path is outside your directory")
+			return
+		}
+		name = filepath.Base(relDir)
+	}
+
+	dir, err := openInDir(r.Context(), root, filepath.Join(root, relDir), os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	var resolved string
+	if err == nil {
+		defer dir.Close()
+		resolved, err = resolvedPath(root, dir)
+	}
+	if err != nil {
+		errMessage := fmt.Sprintf("Directory '%v' NOT found", relDir)
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, errMessage)
+		return
+	}
+
+	compress := r.URL.Query().Has("gzip")
+	filename := name + ".tar"
+	contentType := "application/x-tar"
+	if compress {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Headers are sent with the first bytes; a failure after that can
+	// only cut the archive short
+	err = writeArchive(w, dir, name, compress, func(path string) bool {
+		return inOthersPersonalDir(user, filepath.Join(resolved, path))
+	})
+	if err != nil {
+		log.Printf("Error archiving %v; %v\n", relDir, err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/go-chi/chi/v5"
+)
+
+// Downloads path as a tar and returns its entries, with link targets
+// after "->"
+func archiveEntries(t *testing.T, path string) []string {
+	t.Helper()
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+
+	stream, err := client.DownloadArchive(ctx, &proto.ArchiveRequest{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Buffer{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DownloadArchive %v failed; %v", path, err)
+		}
+		data.Write(chunk.Data)
+	}
+
+	entries := []string{}
+	tr := tar.NewReader(&data)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := header.Name
+		if header.Linkname != "" {
+			entry += " -> " + header.Linkname
+		}
+		entries = append(entries, entry)
+	}
+	slices.Sort(entries)
+	return entries
+}
+
+func TestArchiveDoesNotFollowSymlinks(t *testing.T) {
+	dir, link := personalDirFixture(t)
+	outside := t.TempDir()
+	err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := filepath.Join(dir, "tree")
+	err = os.MkdirAll(filepath.Join(tree, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tree) })
+	err = os.WriteFile(filepath.Join(tree, "sub", "file"), []byte("file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(outside, filepath.Join(tree, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("../"+link, filepath.Join(tree, "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := archiveEntries(t, "/tree")
+	want := []string{
+		"tree/",
+		"tree/bob -> ../" + link,
+		"tree/out -> " + outside,
+		"tree/sub/",
+		"tree/sub/file",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries = %q; want %q", got, want)
+	}
+}
+
+// Reaching the users directory through a symlink still hides other
+// users' personal directories
+func TestArchiveHidesPersonalDirsThroughSymlink(t *testing.T) {
+	dir, _ := personalDirFixture(t)
+	err := os.Symlink(USERS_DIR_NAME, filepath.Join(dir, "people"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "people")) })
+
+	got := archiveEntries(t, "/people")
+	want := []string{"people/"}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries = %q; want %q", got, want)
+	}
+}
+
+// Reads a tar, gzipped if compressed, into the mode and contents of
+// each entry
+func readArchive(t *testing.T, r io.Reader, compressed bool) map[string]string {
+	t.Helper()
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	entries := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = fmt.Sprintf("%v %s", header.FileInfo().Mode(), data)
+	}
+}
+
+// Both the RPC and the web route, compressed or not, give exactly the
+// files of the directory with their modes
+func TestArchiveContentsAndModes(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	tree := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, "tree")
+	err := os.MkdirAll(filepath.Join(tree, "private"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tree) })
+	files := map[string]os.FileMode{"notes": 0640, "run.sh": 0755, "private/key": 0600}
+	for name, mode := range files {
+		err := os.WriteFile(filepath.Join(tree, name), []byte(name), mode)
+		if err == nil {
+			err = os.Chmod(filepath.Join(tree, name), mode)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Chmod(filepath.Join(tree, "private"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"tree/":            "drwxr-xr-x ",
+		"tree/notes":       "-rw-r----- notes",
+		"tree/run.sh":      "-rwxr-xr-x run.sh",
+		"tree/private/":    "drwx------ ",
+		"tree/private/key": "-rw------- private/key",
+	}
+
+	for _, compressed := range []bool{false, true} {
+		stream, err := client.DownloadArchive(ctx, &proto.ArchiveRequest{Path: "/tree", Gzip: compressed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := bytes.Buffer{}
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("DownloadArchive = %v", err)
+			}
+			data.Write(chunk.Data)
+		}
+		if got := readArchive(t, &data, compressed); !maps.Equal(got, want) {
+			t.Errorf("archive (gzip %v) = %q; want %q", compressed, got, want)
+		}
+
+		target := "/archive/tree"
+		if compressed {
+			target += "?gzip"
+		}
+		r := httptest.NewRequest("GET", target, nil)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("*", "tree")
+		r = r.WithContext(context.WithValue(
+			context.WithValue(r.Context(), auth.USER_CTX_KEY, &testUser),
+			chi.RouteCtxKey, routeCtx,
+		))
+		w := httptest.NewRecorder()
+		archiveHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %v = %v; %v", target, w.Code, w.Body)
+		}
+		if got := readArchive(t, w.Body, compressed); !maps.Equal(got, want) {
+			t.Errorf("GET %v = %q; want %q", target, got, want)
+		}
+	}
+}
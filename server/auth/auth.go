@@ -1,39 +1,19 @@
 package auth
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/server/db"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var (
-	SECRET_KEY string
-)
-
-func init() {
-	err := lib.LoadEnv()
-	if err != nil {
-		log.Fatalf("Error loading environment variables; %v\n", err)
-	}
-
-	SECRET_KEY = os.Getenv("SECRET_KEY")
-	if strings.TrimSpace(SECRET_KEY) == "" {
-		log.Fatalln("Missing SECRET_KEY environment variable")
-	}
-}
-
+// Tokens carry the user's details but never their password hash
 func GenerateToken(user db.User) (string, error) {
+	user.Password = ""
 	data, err := json.Marshal(user)
 	if err != nil {
 		return "", err
@@ -52,7 +32,7 @@ func GenerateToken(user db.User) (string, error) {
 			"sub": b64EncodedData,
 		},
 	)
-	tokenString, err := token.SignedString([]byte(SECRET_KEY))
+	tokenString, err := token.SignedString([]byte(db.SecretKeys().Current))
 	return tokenString, err
 }
 
@@ -65,7 +45,12 @@ func ValidToken(tokenString string, obj any) bool {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
-			return []byte(SECRET_KEY), nil
+			// Tokens signed before a key rotation stay valid
+			keyset := jwt.VerificationKeySet{}
+			for _, key := range db.SecretKeys().All() {
+				keyset.Keys = append(keyset.Keys, []byte(key))
+			}
+			return keyset, nil
 		},
 	)
 	if err != nil {
@@ -104,12 +89,9 @@ func ValidToken(tokenString string, obj any) bool {
 // 	return fmt.Sprintf("%x", digest)
 // }
 
-func VerifyPassword(dbPassword, password string) bool {
-	hash := hmac.New(sha256.New, []byte(SECRET_KEY))
-	mac2 := hash.Sum([]byte(password))
-	hmacPassword, err := hex.DecodeString(dbPassword)
-	if err != nil {
-		return false
-	}
-	return hmac.Equal([]byte(hmacPassword), mac2)
+// Checks password against the hash stored in the database. outdated
+// is set when the hash was made with fewer iterations than we now use
+// and should be replaced
+func VerifyPassword(dbPassword, password string) (match bool, outdated bool) {
+	return db.CheckPassword(dbPassword, password)
 }
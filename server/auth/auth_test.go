@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/server/db"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv(lib.SECRETS_SOURCE_ENV, lib.SECRETS_ENV)
+	os.Setenv("SECRET_KEY", "test-secret-key")
+	if err := db.ReloadKeys(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestTokenOmitsPassword(t *testing.T) {
+	user := db.User{
+		Id:       1,
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: "pbkdf2-sha256$600000$c2FsdA$a2V5",
+		OrgName:  "org",
+		DeptName: "dept",
+	}
+	token, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Error generating token; %v", err)
+	}
+
+	var got db.User
+	if !ValidToken(token, &got) {
+		t.Fatal("Token didn't validate")
+	}
+	if got.Password != "" {
+		t.Errorf("Token carries password %q", got.Password)
+	}
+	if got.Email != user.Email || got.OrgName != user.OrgName {
+		t.Errorf("Token user = %+v; want %+v", got, user)
+	}
+}
+
+// Tokens signed with a key moved to SECRET_KEY_PREVIOUS stay valid
+// while ones signed with a dropped key don't
+func TestTokenKeyRotation(t *testing.T) {
+	t.Cleanup(func() {
+		os.Setenv("SECRET_KEY", "test-secret-key")
+		os.Unsetenv("SECRET_KEY_PREVIOUS")
+		db.ReloadKeys()
+	})
+
+	token, err := GenerateToken(db.User{Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Error generating token; %v", err)
+	}
+
+	os.Setenv("SECRET_KEY", "rotated-secret-key")
+	os.Setenv("SECRET_KEY_PREVIOUS", "test-secret-key")
+	if err := db.ReloadKeys(); err != nil {
+		t.Fatalf("Error reloading keys; %v", err)
+	}
+	var user db.User
+	if !ValidToken(token, &user) {
+		t.Error("Token signed with the previous key didn't validate")
+	}
+
+	os.Unsetenv("SECRET_KEY_PREVIOUS")
+	if err := db.ReloadKeys(); err != nil {
+		t.Fatalf("Error reloading keys; %v", err)
+	}
+	if ValidToken(token, &user) {
+		t.Error("Token signed with a dropped key validated")
+	}
+}
+
+func TestTokenVersion(t *testing.T) {
+	stored := map[string]db.User{
+		"alice@example.com": {Id: 1, Email: "alice@example.com", Username: "alice"},
+	}
+	SetUserLookup(func(email string) (*db.User, error) {
+		user, ok := stored[email]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		return &user, nil
+	})
+	t.Cleanup(func() {
+		SetUserLookup(nil)
+		ForgetTokenVersion("alice@example.com")
+	})
+
+	token, err := GenerateToken(stored["alice@example.com"])
+	if err != nil {
+		t.Fatalf("Error generating token; %v", err)
+	}
+	var user db.User
+	if !ValidUserToken(token, &user) {
+		t.Fatal("Current token refused")
+	}
+
+	// Renamed
+	alice := stored["alice@example.com"]
+	alice.Username = "alicia"
+	alice.TokenVersion++
+	stored["alice@example.com"] = alice
+	ForgetTokenVersion("alice@example.com")
+	if ValidUserToken(token, &user) {
+		t.Error("Token issued before a rename accepted")
+	}
+	renamed, err := GenerateToken(alice)
+	if err != nil {
+		t.Fatalf("Error generating token; %v", err)
+	}
+	if !ValidUserToken(renamed, &user) {
+		t.Error("Token issued after a rename refused")
+	}
+
+	// Moved to another email and someone else registered the old one
+	delete(stored, "alice@example.com")
+	stored["alice@example.com"] = db.User{Id: 2, Email: "alice@example.com", Username: "alice", TokenVersion: 1}
+	ForgetTokenVersion("alice@example.com")
+	if ValidUserToken(renamed, &user) {
+		t.Error("Token of the email's previous holder accepted")
+	}
+
+	delete(stored, "alice@example.com")
+	ForgetTokenVersion("alice@example.com")
+	if ValidUserToken(renamed, &user) {
+		t.Error("Token of a user that no longer exists accepted")
+	}
+}
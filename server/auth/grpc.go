@@ -56,10 +56,16 @@ func AuthInterceptor(
 	token := tokens[0]
 
 	var user db.User
-	if !ValidToken(token, &user) {
+	if !ValidUserToken(token, &user) {
 		return nil, status.Error(codes.Unauthenticated, "Invalid authorization token")
 	}
 
+	release, err := acquire(&user, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Save user object into context
 	newCtx := context.WithValue(ctx, USER_CTX_KEY, &user)
 	return handler(newCtx, req)
@@ -95,10 +101,16 @@ func AuthStreamInterceptor(
 	token := tokens[0]
 
 	var user db.User
-	if !ValidToken(token, &user) {
+	if !ValidUserToken(token, &user) {
 		return status.Error(codes.Unauthenticated, "Invalid authorization token")
 	}
 
+	release, err := acquire(&user, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Save user object into context
 	newCtx := context.WithValue(ss.Context(), USER_CTX_KEY, &user)
 	newServerStream := myServerStream{
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Maximum number of unary RPCs and streams a single user may have in
+// flight at once. 0 means unlimited
+type Limits struct {
+	MaxRPCs    int
+	MaxStreams int
+}
+
+type inFlight struct {
+	rpcs    int
+	streams int
+}
+
+var (
+	defaultLimits Limits
+	orgLimits     = make(map[string]Limits)
+
+	// RPCs and streams in flight per user, keyed by email
+	usage   = make(map[string]*inFlight)
+	usageMu = sync.Mutex{}
+)
+
+// Sets the limits applied to every user. Users of an org listed in
+// perOrg get that org's limits instead
+func SetLimits(limits Limits, perOrg map[string]Limits) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	defaultLimits = limits
+	orgLimits = perOrg
+	if orgLimits == nil {
+		orgLimits = make(map[string]Limits)
+	}
+}
+
+func limitsFor(user *db.User) Limits {
+	limits, ok := orgLimits[user.OrgName]
+	if ok {
+		return limits
+	}
+	return defaultLimits
+}
+
+// Reserves a slot for a new RPC or stream of user. The returned
+// function releases it
+func acquire(user *db.User, stream bool) (func(), error) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	limits := limitsFor(user)
+	current, ok := usage[user.Email]
+	if !ok {
+		current = &inFlight{}
+		usage[user.Email] = current
+	}
+
+	if stream {
+		if limits.MaxStreams > 0 && current.streams >= limits.MaxStreams {
+			return nil, status.Error(codes.ResourceExhausted, "Too many concurrent streams")
+		}
+		current.streams++
+	} else {
+		if limits.MaxRPCs > 0 && current.rpcs >= limits.MaxRPCs {
+			return nil, status.Error(codes.ResourceExhausted, "Too many concurrent requests")
+		}
+		current.rpcs++
+	}
+
+	release := func() {
+		usageMu.Lock()
+		defer usageMu.Unlock()
+
+		if stream {
+			current.streams--
+		} else {
+			current.rpcs--
+		}
+		if current.rpcs == 0 && current.streams == 0 {
+			delete(usage, user.Email)
+		}
+	}
+	return release, nil
+}
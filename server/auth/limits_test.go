@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Returns an incoming context carrying a token for user
+func tokenCtx(t *testing.T, user db.User) context.Context {
+	t.Helper()
+	token, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Error generating token; %v", err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+}
+
+// Runs a unary call of user through AuthInterceptor that stays in
+// flight until release is closed. Returns once the call either got a
+// slot or was refused
+func unaryCall(t *testing.T, user db.User, release chan struct{}) error {
+	t.Helper()
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		_, err := AuthInterceptor(tokenCtx(t, user), nil, &grpc.UnaryServerInfo{FullMethod: "/proto.Fuse/Write"},
+			func(ctx context.Context, req any) (any, error) {
+				close(started)
+				<-release
+				return nil, nil
+			})
+		result <- err
+	}()
+
+	select {
+	case <-started:
+		return nil
+	case err := <-result:
+		return err
+	}
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss testServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// One user at their limit is refused more calls while another user's
+// go through, and an org can be given limits of its own
+func TestPerUserLimits(t *testing.T) {
+	SetLimits(Limits{MaxRPCs: 2, MaxStreams: 1}, map[string]Limits{"big": {MaxRPCs: 3}})
+	t.Cleanup(func() { SetLimits(Limits{}, nil) })
+	alice := db.User{Id: 1, Email: "alice@example.com", OrgName: "org"}
+	bob := db.User{Id: 2, Email: "bob@example.com", OrgName: "org"}
+	carol := db.User{Id: 3, Email: "carol@example.com", OrgName: "big"}
+
+	release := make(chan struct{})
+	for range 2 {
+		if err := unaryCall(t, alice, release); err != nil {
+			t.Fatalf("call within limit = %v", err)
+		}
+	}
+	if err := unaryCall(t, alice, release); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call past limit = %v; want ResourceExhausted", err)
+	}
+	if err := unaryCall(t, bob, release); err != nil {
+		t.Errorf("other user's call = %v; want it to proceed", err)
+	}
+	for range 3 {
+		if err := unaryCall(t, carol, release); err != nil {
+			t.Errorf("call within org limit = %v", err)
+		}
+	}
+
+	// Streams are counted apart from unary calls
+	streamed := make(chan struct{})
+	go AuthStreamInterceptor(nil, testServerStream{ctx: tokenCtx(t, alice)}, &grpc.StreamServerInfo{},
+		func(srv any, ss grpc.ServerStream) error {
+			close(streamed)
+			<-release
+			return nil
+		})
+	<-streamed
+	err := AuthStreamInterceptor(nil, testServerStream{ctx: tokenCtx(t, alice)}, &grpc.StreamServerInfo{},
+		func(srv any, ss grpc.ServerStream) error { return nil })
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("stream past limit = %v; want ResourceExhausted", err)
+	}
+
+	close(release)
+	for {
+		usageMu.Lock()
+		n := len(usage)
+		usageMu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := unaryCall(t, alice, release); err != nil {
+		t.Errorf("call after others finished = %v", err)
+	}
+}
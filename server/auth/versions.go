@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/server/db"
+)
+
+// Tokens carry the user's id and token version as they were when the
+// token was issued. Renaming a user or moving them to a new email bumps
+// the version stored with them, which refuses every token issued before.
+//
+// What the database holds for each user is remembered for
+// TOKEN_CHECK_TTL so requests don't each need a query; the server
+// forgets it straight away whenever it bumps a version
+
+const TOKEN_CHECK_TTL = time.Minute
+
+type tokenCheck struct {
+	// -1 once the user no longer exists
+	id      int
+	version int
+	checked time.Time
+}
+
+var (
+	lookupUser func(email string) (*db.User, error)
+
+	tokenChecksMu sync.Mutex
+	tokenChecks   = map[string]tokenCheck{}
+
+	// Counts ForgetTokenVersion calls so a lookup that raced one isn't
+	// remembered
+	tokenForgets int
+)
+
+// Sets how users are looked up to check their tokens are current.
+// Until it is set tokens are only checked for their signature and
+// expiry
+func SetUserLookup(lookup func(email string) (*db.User, error)) {
+	lookupUser = lookup
+}
+
+// Makes the next request of the user with email check their token
+// against the database. Call after bumping the user's token version
+func ForgetTokenVersion(email string) {
+	tokenChecksMu.Lock()
+	defer tokenChecksMu.Unlock()
+	delete(tokenChecks, email)
+	tokenForgets++
+}
+
+// Reports whether user, as read from a token, is still the user with
+// that email and the token is of their current version
+func tokenCurrent(user *db.User) bool {
+	if lookupUser == nil {
+		return true
+	}
+
+	tokenChecksMu.Lock()
+	check, ok := tokenChecks[user.Email]
+	forgets := tokenForgets
+	tokenChecksMu.Unlock()
+
+	if !ok || time.Since(check.checked) > TOKEN_CHECK_TTL {
+		current, err := lookupUser(user.Email)
+		switch {
+		case err == sql.ErrNoRows:
+			check = tokenCheck{id: -1, checked: time.Now()}
+		case err != nil:
+			log.Printf("Error checking token version of %v; %v\n", user.Email, err)
+			// Keep going on what we knew, if anything
+			if !ok {
+				return false
+			}
+		default:
+			check = tokenCheck{id: current.Id, version: current.TokenVersion, checked: time.Now()}
+		}
+
+		tokenChecksMu.Lock()
+		if tokenForgets == forgets {
+			tokenChecks[user.Email] = check
+		}
+		tokenChecksMu.Unlock()
+	}
+	return check.id == user.Id && check.version == user.TokenVersion
+}
+
+// Like ValidToken for tokens carrying a user. Also refuses tokens that
+// are out of date
+func ValidUserToken(tokenString string, user *db.User) bool {
+	return ValidToken(tokenString, user) && tokenCurrent(user)
+}
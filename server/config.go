@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+)
+
+// Organizations and users can be dumped to JSON and restored on
+// another server, either by an organization's admin over the web
+// server or for every organization with -export-config and
+// -import-config; see db.Config
+var (
+	exportConfigPath string
+	importConfigPath string
+	importMode       string
+)
+
+// Creates the directories of the organizations and departments in
+// config that don't exist yet, so imported users can log in straight
+// away
+func createConfigDirs(config db.Config) error {
+	for _, org := range config.Organizations {
+		err := os.MkdirAll(filepath.Join(realpath, org.Name), 0751)
+		if err != nil {
+			return err
+		}
+	}
+	for _, user := range config.Users {
+		err := os.MkdirAll(filepath.Join(realpath, user.OrgName, user.DeptName), 0771)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mails a password reset token to each imported user without a
+// password so they can set one
+func sendImportResets(result *db.ImportResult) {
+	for _, email := range result.ResetRequired {
+		token := db.NewPasswordResetToken(email, 72*time.Hour)
+		_, err := passwordResetTokens.Insert(*token)
+		if err != nil {
+			log.Printf("Error saving password_reset_token of %v; %v\n", email, err)
+			continue
+		}
+		err = sendEmail(email, token.OTP)
+		if err != nil {
+			log.Printf("Error sending email; %v\n", err)
+		}
+	}
+}
+
+// Runs -export-config or -import-config. Reports whether either was
+// given, in which case the server shouldn't start
+func runConfigCommand() bool {
+	switch {
+	case exportConfigPath != "":
+		config, err := db.ExportConfig("")
+		if err != nil {
+			log.Fatalf("Error exporting config; %v\n", err)
+		}
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			log.Fatalf("Error exporting config; %v\n", err)
+		}
+		if exportConfigPath == "-" {
+			fmt.Println(string(data))
+			return true
+		}
+		err = os.WriteFile(exportConfigPath, data, 0600)
+		if err != nil {
+			log.Fatalf("Error exporting config; %v\n", err)
+		}
+		log.Printf("Exported %v organizations and %v users to %v\n", len(config.Organizations), len(config.Users), exportConfigPath)
+		return true
+
+	case importConfigPath != "":
+		data, err := os.ReadFile(importConfigPath)
+		if err != nil {
+			log.Fatalf("Error importing config; %v\n", err)
+		}
+		var config db.Config
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			log.Fatalf("Error importing config; %v\n", err)
+		}
+		result, err := db.ImportConfig(config, importMode, "")
+		if err != nil {
+			log.Fatalf("Error importing config; %v\n", err)
+		}
+		if realpath != "" {
+			err = createConfigDirs(config)
+			if err != nil {
+				log.Printf("Error creating organization directories; %v\n", err)
+			}
+		}
+		sendImportResets(result)
+		log.Printf("Imported config; %v created, %v updated, %v skipped\n", result.Created, result.Updated, result.Skipped)
+		return true
+	}
+	return false
+}
+
+func exportConfigHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	config, err := db.ExportConfig(user.OrgName)
+	if err != nil {
+		log.Printf("Error exporting config; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error exporting config")
+		return
+	}
+	jsonResponse(w, http.StatusOK, config)
+}
+
+func importConfigHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = db.IMPORT_SKIP
+	}
+	if mode != db.IMPORT_SKIP && mode != db.IMPORT_OVERWRITE {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "mode must be one of skip, overwrite")
+		return
+	}
+
+	var config db.Config
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "invalid request body")
+		return
+	}
+
+	result, err := db.ImportConfig(config, mode, user.OrgName)
+	if errors.Is(err, db.ErrOutsideOrg) {
+		errorResponse(w, http.StatusForbidden, ERR_FORBIDDEN, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrInvalidConfig) {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error importing config; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error importing config")
+		return
+	}
+
+	err = createConfigDirs(config)
+	if err != nil {
+		log.Printf("Error creating organization directories; %v\n", err)
+	}
+	go sendImportResets(result)
+	jsonResponse(w, http.StatusOK, result)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Copies src to dst without sending the data through userspace when
+// possible. Tries a reflink first, which shares blocks on filesystems
+// that support it (btrfs, xfs), then copy_file_range and finally a
+// plain stream copy
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if err == nil {
+		return nil
+	}
+
+	remaining := info.Size()
+	for remaining > 0 {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, int(remaining), 0)
+		if err != nil || n == 0 {
+			break
+		}
+		remaining -= int64(n)
+	}
+	if remaining == 0 {
+		return nil
+	}
+
+	// copy_file_range not supported; continue from where it stopped
+	offset := info.Size() - remaining
+	_, err = in.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = out.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+func TestCopyRPC(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	contents := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, name), contents, 0640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(dir, name))
+		os.Remove(filepath.Join(dir, name+".copy"))
+	})
+
+	_, err = client.Copy(ctx, &proto.CopyRequest{SrcPath: "/" + name, DstPath: "/" + name + ".copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, name+".copy"))
+	if !bytes.Equal(got, contents) {
+		t.Errorf("copy has %v bytes, %v; want the %v of the original", len(got), err, len(contents))
+	}
+	info, err := os.Stat(filepath.Join(dir, name+".copy"))
+	if err == nil && info.Mode().Perm() != 0640 {
+		t.Errorf("copy mode = %v; want 0640", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Clients create a file and write to it in separate requests; the
+// server holds no handle in between, so the file can be removed,
+// eg. by another client, before the writes arrive. Writes never
+// recreate a missing file since whoever removed it may have meant
+// to. Instead files created over gRPC are remembered for a while per
+// user, so that writes to one that has disappeared fail with an error
+// saying so rather than a bare ENOENT
+const (
+	// How long a create is remembered
+	CREATE_TTL = 10 * time.Minute
+
+	// Most creates remembered at once. Older ones are dropped first
+	// once it fills up
+	MAX_CREATES = 10_000
+)
+
+var (
+	// Expiry of remembered creates, keyed by email and full path
+	recentCreates   = make(map[string]time.Time)
+	recentCreatesMu = sync.Mutex{}
+)
+
+func createKey(email, fullpath string) string {
+	return email + "|" + fullpath
+}
+
+// Remembers that the logged in user created fullpath
+func rememberCreate(ctx context.Context, fullpath string) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return
+	}
+
+	recentCreatesMu.Lock()
+	defer recentCreatesMu.Unlock()
+
+	now := time.Now()
+	if len(recentCreates) >= MAX_CREATES {
+		for key, expires := range recentCreates {
+			if now.After(expires) {
+				delete(recentCreates, key)
+			}
+		}
+	}
+	if len(recentCreates) >= MAX_CREATES {
+		var oldestKey string
+		var oldest time.Time
+		for key, expires := range recentCreates {
+			if oldestKey == "" || expires.Before(oldest) {
+				oldestKey, oldest = key, expires
+			}
+		}
+		delete(recentCreates, oldestKey)
+	}
+	recentCreates[createKey(user.Email, fullpath)] = now.Add(CREATE_TTL)
+}
+
+// Reports whether the logged in user created fullpath within
+// CREATE_TTL
+func createdRecently(ctx context.Context, fullpath string) bool {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return false
+	}
+
+	recentCreatesMu.Lock()
+	defer recentCreatesMu.Unlock()
+
+	key := createKey(user.Email, fullpath)
+	expires, ok := recentCreates[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(recentCreates, key)
+		return false
+	}
+	return true
+}
+
+// Maps the error from opening fullpath, relative path path, for a
+// write. Files the user created that have since been removed get
+// NotFound with a message saying so
+func writeOpenError(ctx context.Context, fullpath, path string, err error) error {
+	if os.IsNotExist(err) && createdRecently(ctx, fullpath) {
+		return status.Errorf(codes.NotFound, "file %v was removed after it was created; write dropped", path)
+	}
+	return lib.StatusError(err)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Writes to a created file that was removed before they arrived fail
+// with an error saying so, and don't bring the file back
+func TestWriteAfterCreatedFileRemoved(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	other := testUser
+	other.Email = "bob@example.com"
+	otherClient, otherCtx := newTestClient(t, FuseServer{path: mountpoint}, other)
+	name := "/" + t.Name()
+	fullpath := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name)
+	t.Cleanup(func() { os.Remove(fullpath) })
+
+	_, err := client.Create(ctx, &proto.CreateRequest{
+		Path:  name,
+		Flags: syscall.O_WRONLY | syscall.O_CREAT,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Fatalf("Create failed; %v", err)
+	}
+	err = os.Remove(fullpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []*proto.WriteRequest{
+		{Path: name, Data: []byte("data")},
+		{Path: name, Data: []byte("data"), Append: true},
+	} {
+		_, err = client.Write(ctx, req)
+		if status.Code(err) != codes.NotFound || !strings.Contains(err.Error(), "removed after it was created") {
+			t.Errorf("Write (append %v) to removed file = %v; want NotFound saying it was removed", req.Append, err)
+		}
+		if _, err := os.Lstat(fullpath); !os.IsNotExist(err) {
+			t.Errorf("Write (append %v) brought the removed file back", req.Append)
+		}
+	}
+
+	// Only its creator is told
+	_, err = otherClient.Write(otherCtx, &proto.WriteRequest{Path: name, Data: []byte("data")})
+	if status.Code(err) != codes.NotFound || strings.Contains(err.Error(), "removed after it was created") {
+		t.Errorf("Write by another user = %v; want a plain NotFound", err)
+	}
+
+	// Nor is it once the create is forgotten
+	recentCreatesMu.Lock()
+	recentCreates[createKey(testUser.Email, fullpath)] = time.Now().Add(-time.Second)
+	recentCreatesMu.Unlock()
+	_, err = client.Write(ctx, &proto.WriteRequest{Path: name, Data: []byte("data")})
+	if status.Code(err) != codes.NotFound || strings.Contains(err.Error(), "removed after it was created") {
+		t.Errorf("Write after CREATE_TTL = %v; want a plain NotFound", err)
+	}
+}
+
+// Once MAX_CREATES are remembered the one expiring first makes room
+func TestRecentCreatesBounded(t *testing.T) {
+	ctx := context.WithValue(context.Background(), auth.USER_CTX_KEY, &testUser)
+	recentCreatesMu.Lock()
+	old := recentCreates
+	recentCreates = make(map[string]time.Time)
+	recentCreatesMu.Unlock()
+	t.Cleanup(func() {
+		recentCreatesMu.Lock()
+		recentCreates = old
+		recentCreatesMu.Unlock()
+	})
+
+	for i := range MAX_CREATES + 1 {
+		rememberCreate(ctx, "/file"+strconv.Itoa(i))
+	}
+	if n := len(recentCreates); n != MAX_CREATES {
+		t.Errorf("%v creates remembered; want %v", n, MAX_CREATES)
+	}
+	if createdRecently(ctx, "/file0") {
+		t.Error("oldest create kept")
+	}
+	if !createdRecently(ctx, "/file"+strconv.Itoa(MAX_CREATES)) {
+		t.Error("newest create dropped")
+	}
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Organizations and their users as moved between servers by
+// ExportConfig and ImportConfig. File contents aren't part of it.
+//
+// Passwords are never exported. A record may carry a plaintext
+// password, which is hashed when the import creates it; records
+// created without one must have their password reset, and existing
+// records keep the password they have
+type Config struct {
+	Organizations []ConfigOrganization `json:"organizations"`
+	Users         []ConfigUser         `json:"users"`
+}
+
+type ConfigOrganization struct {
+	Name       string `json:"name"`
+	AdminName  string `json:"admin_name"`
+	AdminEmail string `json:"admin_email"`
+	Password   string `json:"password,omitempty"`
+}
+
+type ConfigUser struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	OrgName  string `json:"org_name"`
+	DeptName string `json:"dept_name"`
+	Password string `json:"password,omitempty"`
+}
+
+// What ImportConfig does with records that already exist
+const (
+	IMPORT_SKIP      = "skip"
+	IMPORT_OVERWRITE = "overwrite"
+)
+
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+
+	// Emails of users created without a password
+	ResetRequired []string `json:"reset_required"`
+}
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+	ErrOutsideOrg    = errors.New("record belongs to another organization")
+)
+
+// Dumps organization orgName and its users. An empty orgName dumps
+// every organization
+func ExportConfig(orgName string) (*Config, error) {
+	config := &Config{
+		Organizations: []ConfigOrganization{},
+		Users:         []ConfigUser{},
+	}
+
+	query := "SELECT name, admin_name, admin_email FROM organizations WHERE ? = '' OR name = ? ORDER BY name"
+	rows, err := db.Query(query, orgName, orgName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var org ConfigOrganization
+		err = rows.Scan(&org.Name, &org.AdminName, &org.AdminEmail)
+		if err != nil {
+			return nil, err
+		}
+		config.Organizations = append(config.Organizations, org)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = "SELECT username, email, org_name, dept_name FROM users WHERE ? = '' OR org_name = ? ORDER BY org_name, dept_name, email"
+	rows, err = db.Query(query, orgName, orgName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user ConfigUser
+		err = rows.Scan(&user.Username, &user.Email, &user.OrgName, &user.DeptName)
+		if err != nil {
+			return nil, err
+		}
+		config.Users = append(config.Users, user)
+	}
+	return config, rows.Err()
+}
+
+// Restores config in a single transaction; either every record is
+// imported or none is. Existing records, organizations by name and
+// users by email, are left alone or replaced according to mode.
+//
+// A non-empty orgName limits the import to that organization: records
+// of any other organization are refused, and so is replacing a user
+// who belongs to another one
+func ImportConfig(config Config, mode string, orgName string) (*ImportResult, error) {
+	if mode != IMPORT_SKIP && mode != IMPORT_OVERWRITE {
+		return nil, fmt.Errorf("unknown import mode %q", mode)
+	}
+	err := config.validate(orgName)
+	if err != nil {
+		return nil, fmt.Errorf("%w; %w", ErrInvalidConfig, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{ResetRequired: []string{}}
+	for _, org := range config.Organizations {
+		err = importOrganization(tx, org, mode, result)
+		if err != nil {
+			return nil, fmt.Errorf("organization %v; %w", org.Name, err)
+		}
+	}
+	for _, user := range config.Users {
+		err = importUser(tx, user, mode, orgName, result)
+		if err != nil {
+			return nil, fmt.Errorf("user %v; %w", user.Email, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c Config) validate(orgName string) error {
+	for _, org := range c.Organizations {
+		if orgName != "" && org.Name != orgName {
+			return fmt.Errorf("organization %v; %w", org.Name, ErrOutsideOrg)
+		}
+		if err := lib.ValidatePathComponent("name", org.Name); err != nil {
+			return fmt.Errorf("organization %v; %w", org.Name, err)
+		}
+		if err := lib.ValidateEmail(org.AdminEmail); err != nil {
+			return fmt.Errorf("organization %v; %w", org.Name, err)
+		}
+		if err := validateSecret(org.Password); err != nil {
+			return fmt.Errorf("organization %v; %w", org.Name, err)
+		}
+	}
+
+	for _, user := range c.Users {
+		if orgName != "" && user.OrgName != orgName {
+			return fmt.Errorf("user %v; %w", user.Email, ErrOutsideOrg)
+		}
+		if err := lib.ValidateName("username", user.Username); err != nil {
+			return fmt.Errorf("user %v; %w", user.Email, err)
+		}
+		if err := lib.ValidateEmail(user.Email); err != nil {
+			return fmt.Errorf("user %v; %w", user.Email, err)
+		}
+		if err := lib.ValidatePathComponent("org_name", user.OrgName); err != nil {
+			return fmt.Errorf("user %v; %w", user.Email, err)
+		}
+		if err := lib.ValidatePathComponent("dept_name", user.DeptName); err != nil {
+			return fmt.Errorf("user %v; %w", user.Email, err)
+		}
+		if err := validateSecret(user.Password); err != nil {
+			return fmt.Errorf("user %v; %w", user.Email, err)
+		}
+	}
+	return nil
+}
+
+func validateSecret(password string) error {
+	if password != "" {
+		return lib.ValidatePassword(password)
+	}
+	return nil
+}
+
+// Hashes the password of a record the import creates. Records without
+// one get a hash that matches no password
+func storedSecret(password string) (string, error) {
+	if password != "" {
+		return hashPassword(password)
+	}
+	return PASSWORD_RESET_REQUIRED, nil
+}
+
+func importOrganization(tx *sql.Tx, org ConfigOrganization, mode string, result *ImportResult) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM organizations WHERE name = ?)", org.Name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	// Passwords are only set on the records the import creates
+	var secret string
+	if !exists {
+		secret, err = storedSecret(org.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case !exists:
+		_, err = tx.Exec(
+			"INSERT INTO organizations(name, admin_name, admin_email, org_password) VALUES(?, ?, ?, ?)",
+			org.Name, org.AdminName, org.AdminEmail, secret,
+		)
+		result.Created++
+	case mode == IMPORT_OVERWRITE:
+		_, err = tx.Exec(
+			"UPDATE organizations SET admin_name = ?, admin_email = ? WHERE name = ?",
+			org.AdminName, org.AdminEmail, org.Name,
+		)
+		result.Updated++
+	default:
+		result.Skipped++
+	}
+	return err
+}
+
+func importUser(tx *sql.Tx, user ConfigUser, mode string, orgName string, result *ImportResult) error {
+	var existingOrg string
+	err := tx.QueryRow("SELECT org_name FROM users WHERE email = ?", user.Email).Scan(&existingOrg)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	exists := err == nil
+
+	// Passwords are only set on the records the import creates
+	var secret string
+	if !exists {
+		secret, err = storedSecret(user.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case !exists:
+		_, err = tx.Exec(
+			"INSERT INTO users(username, email, password, org_name, dept_name) VALUES(?, ?, ?, ?, ?)",
+			user.Username, user.Email, secret, user.OrgName, user.DeptName,
+		)
+		result.Created++
+		if user.Password == "" {
+			result.ResetRequired = append(result.ResetRequired, user.Email)
+		}
+	case mode == IMPORT_OVERWRITE:
+		if orgName != "" && existingOrg != orgName {
+			return ErrOutsideOrg
+		}
+		_, err = tx.Exec(
+			"UPDATE users SET username = ?, org_name = ?, dept_name = ? WHERE email = ?",
+			user.Username, user.OrgName, user.DeptName, user.Email,
+		)
+		result.Updated++
+	default:
+		result.Skipped++
+	}
+	return err
+}
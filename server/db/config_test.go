@@ -0,0 +1,155 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Exported records never carry secrets, and a hash supplied on import
+// is not a field we read
+func TestConfigCarriesNoHashes(t *testing.T) {
+	data := []byte(`{
+		"organizations": [{"name": "org", "admin_name": "bob", "admin_email": "bob@example.com", "password_hash": "x", "org_password": "y"}],
+		"users": [{"username": "alice", "email": "alice@example.com", "org_name": "org", "dept_name": "dept", "password_hash": "x"}]
+	}`)
+	var config Config
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		t.Fatalf("Error decoding config; %v", err)
+	}
+	if config.Organizations[0].Password != "" || config.Users[0].Password != "" {
+		t.Errorf("Hash read as a password; %+v", config)
+	}
+
+	out, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Error encoding config; %v", err)
+	}
+	if strings.Contains(string(out), "password") {
+		t.Errorf("Config without passwords encoded as %s", out)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	user := ConfigUser{
+		Username: "alice",
+		Email:    "alice@example.com",
+		OrgName:  "org",
+		DeptName: "dept",
+	}
+	weak := user
+	weak.Password = "a"
+	strong := user
+	strong.Password = "Secret-password1"
+	other := user
+	other.OrgName = "other"
+
+	tests := []struct {
+		name    string
+		user    ConfigUser
+		orgName string
+		wantErr bool
+	}{
+		{"without password", user, "", false},
+		{"weak password", weak, "", true},
+		{"strong password", strong, "", false},
+		{"own organization", user, "org", false},
+		{"other organization", other, "org", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Config{Users: []ConfigUser{test.user}}.validate(test.orgName)
+			if (err != nil) != test.wantErr {
+				t.Errorf("validate = %v; want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoredSecret(t *testing.T) {
+	secret, err := storedSecret("")
+	if err != nil || secret != PASSWORD_RESET_REQUIRED {
+		t.Errorf("storedSecret(\"\") = %q, %v; want %q", secret, err, PASSWORD_RESET_REQUIRED)
+	}
+
+	secret, err = storedSecret("Secret-password1")
+	if err != nil {
+		t.Fatalf("Error hashing password; %v", err)
+	}
+	if match, _ := CheckPassword(secret, "Secret-password1"); !match {
+		t.Errorf("storedSecret hash %q doesn't match its password", secret)
+	}
+}
+
+// A config exported from one database and imported into a fresh one
+// exports the same again. Importing it a second time skips or
+// overwrites what is already there, and the new records need their
+// passwords reset
+func TestConfigRoundTrip(t *testing.T) {
+	config := Config{
+		Organizations: []ConfigOrganization{
+			{Name: "acme", AdminName: "bob", AdminEmail: "bob@example.com", Password: "Secret-password1"},
+			{Name: "globex", AdminName: "hank", AdminEmail: "hank@example.com"},
+		},
+		Users: []ConfigUser{
+			{Username: "alice", Email: "alice@example.com", OrgName: "acme", DeptName: "design", Password: "Secret-password1"},
+			{Username: "carol", Email: "carol@example.com", OrgName: "acme", DeptName: "sales"},
+			{Username: "homer", Email: "homer@example.com", OrgName: "globex", DeptName: "plant"},
+		},
+	}
+	openTestDB(t)
+	_, err := ImportConfig(config, IMPORT_SKIP, "")
+	if err != nil {
+		t.Fatalf("Error importing config; %v", err)
+	}
+	exported, err := ExportConfig("")
+	if err != nil {
+		t.Fatalf("Error exporting config; %v", err)
+	}
+
+	// Tables are created afresh
+	openTestDB(t)
+	result, err := ImportConfig(*exported, IMPORT_SKIP, "")
+	if err != nil {
+		t.Fatalf("Error importing into a fresh database; %v", err)
+	}
+	if result.Created != 5 || len(result.ResetRequired) != 3 {
+		t.Errorf("import into a fresh database = %+v; want 5 created, 3 needing a reset", result)
+	}
+	again, err := ExportConfig("")
+	if err != nil {
+		t.Fatalf("Error exporting config; %v", err)
+	}
+	if !reflect.DeepEqual(again, exported) {
+		t.Errorf("round trip exported %+v; want %+v", again, exported)
+	}
+
+	result, err = ImportConfig(*exported, IMPORT_SKIP, "")
+	if err != nil || result.Skipped != 5 || result.Created != 0 {
+		t.Errorf("second import = %+v, %v; want all 5 skipped", result, err)
+	}
+	moved := *exported
+	moved.Users = []ConfigUser{{Username: "alice", Email: "alice@example.com", OrgName: "acme", DeptName: "sales"}}
+	result, err = ImportConfig(moved, IMPORT_OVERWRITE, "globex")
+	if err == nil {
+		t.Errorf("import limited to globex overwrote an acme user; %+v", result)
+	}
+	result, err = ImportConfig(moved, IMPORT_OVERWRITE, "")
+	if err != nil || result.Updated != 3 {
+		t.Errorf("overwriting import = %+v, %v; want 3 updated", result, err)
+	}
+	acme, err := ExportConfig("acme")
+	if err != nil {
+		t.Fatalf("Error exporting acme; %v", err)
+	}
+	if len(acme.Organizations) != 1 || len(acme.Users) != 2 {
+		t.Fatalf("acme exported %+v; want 1 organization, 2 users", acme)
+	}
+	for _, user := range acme.Users {
+		if user.Username == "alice" && user.DeptName != "sales" {
+			t.Errorf("alice dept = %v; want sales", user.DeptName)
+		}
+	}
+}
@@ -15,8 +15,6 @@ import (
 )
 
 var (
-	SECRET_KEY string
-
 	db *sql.DB
 )
 
@@ -27,23 +25,20 @@ const (
 	sqliteDb
 )
 
-func init() {
-	err := lib.LoadEnv()
-	if err != nil {
-		log.Fatalf("Error loading env variables; %v\n", err)
-	}
-
+// Loads the secret keys and connects to the database. Called by the
+// server before anything else touches the database
+func Open() {
 	// Ensure SECRET_KEY is always set
-	SECRET_KEY = os.Getenv("SECRET_KEY")
-
-	if strings.TrimSpace(SECRET_KEY) == "" {
-		log.Fatalln("Missing SECRET_KEY env variable")
+	keyset, err := loadKeys()
+	if err != nil {
+		log.Fatalf("Error loading secrets; %v\n", err)
 	}
+	keys.Store(keyset)
 
 	mysqlConfig := mysql.Config{
-		User:                 os.Getenv("DB_USER"),
-		Passwd:               os.Getenv("DB_PASSWORD"),
-		DBName:               os.Getenv("DB_NAME"),
+		User:                 lib.Secret("DB_USER"),
+		Passwd:               lib.Secret("DB_PASSWORD"),
+		DBName:               lib.Secret("DB_NAME"),
 		ParseTime:            true,
 		AllowNativePasswords: true,
 	}
@@ -52,6 +47,16 @@ func init() {
 		log.Fatalf("Error opening MySQL database connection; %v", err)
 	}
 
+	err = addTokenVersion()
+	if err != nil {
+		log.Fatalf("Error adding token_version to users; %v\n", err)
+	}
+
+	err = expireLegacyPasswords()
+	if err != nil {
+		log.Fatalf("Error clearing unsalted passwords; %v\n", err)
+	}
+
 	// err = migrateDatabase(mysqlDb)
 	// if err != nil {
 	// 	log.Fatalf("Migration failed; %v\n", err)
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Request to move an account to a new email. The change only happens
+// once the OTP sent to the new email comes back
+type EmailChange struct {
+	Id        int       `json:"id"`
+	Email     string    `json:"email"`
+	NewEmail  string    `json:"new_email"`
+	OTP       string    `json:"otp"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Wrong OTPs tried so far
+	Attempts int `json:"attempts"`
+}
+
+func NewEmailChange(email, newEmail string, duration time.Duration) *EmailChange {
+	now := time.Now()
+	return &EmailChange{
+		Email:     email,
+		NewEmail:  newEmail,
+		OTP:       generateOtp(MIN_OTP_LEN),
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+}
+
+type EmailChangeModel struct {
+	db *sql.DB
+}
+
+func NewEmailChangeModel() *EmailChangeModel {
+	return &EmailChangeModel{
+		db: db,
+	}
+}
+
+// Saves change, replacing any earlier request of the same user
+func (m *EmailChangeModel) Insert(change EmailChange) (int64, error) {
+	_, err := m.db.Exec("DELETE FROM email_changes WHERE email = ?", change.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "INSERT INTO email_changes(email, new_email, otp, expires_at) VALUES(?, ?, ?, ?)"
+	result, err := m.db.Exec(
+		query,
+		change.Email,
+		change.NewEmail,
+		change.OTP,
+		change.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Fetches the pending change of the user with email
+func (m *EmailChangeModel) Get(email string) (*EmailChange, error) {
+	query := "SELECT * FROM email_changes WHERE email = ? AND expires_at > ?"
+	row := m.db.QueryRow(query, email, time.Now())
+
+	change := EmailChange{}
+	err := row.Scan(
+		&change.Id,
+		&change.Email,
+		&change.NewEmail,
+		&change.OTP,
+		&change.ExpiresAt,
+		&change.CreatedAt,
+		&change.Attempts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// Counts a wrong OTP against the pending change of the user with email
+// and returns how many have been tried
+func (m *EmailChangeModel) AddAttempt(email string) (int, error) {
+	_, err := m.db.Exec("UPDATE email_changes SET attempts = attempts + 1 WHERE email = ?", email)
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts int
+	err = m.db.QueryRow("SELECT attempts FROM email_changes WHERE email = ?", email).Scan(&attempts)
+	return attempts, err
+}
+
+func (m *EmailChangeModel) Delete(email string) (int64, error) {
+	result, err := m.db.Exec("DELETE FROM email_changes WHERE email = ?", email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Lets whoever holds Token register into OrgName. If DeptName is set
+// the invite is only good for that department
+type InviteToken struct {
+	Id        int       `json:"id"`
+	Token     string    `json:"token"`
+	OrgName   string    `json:"org_name"`
+	DeptName  string    `json:"dept_name"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewInviteToken(orgName, deptName string, duration time.Duration) *InviteToken {
+	token := make([]byte, 24)
+	rand.Read(token)
+	now := time.Now()
+
+	return &InviteToken{
+		Token:     hex.EncodeToString(token),
+		OrgName:   orgName,
+		DeptName:  deptName,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+}
+
+type InviteModel struct {
+	db *sql.DB
+}
+
+func NewInviteModel() *InviteModel {
+	return &InviteModel{
+		db: db,
+	}
+}
+
+func (m *InviteModel) Insert(invite InviteToken) (int64, error) {
+	query := "INSERT INTO org_invites(token, org_name, dept_name, expires_at) VALUES(?, ?, ?, ?)"
+	result, err := m.db.Exec(
+		query,
+		invite.Token,
+		invite.OrgName,
+		invite.DeptName,
+		invite.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Fetches an unexpired invite by its token
+func (m *InviteModel) Get(token string) (*InviteToken, error) {
+	query := "SELECT id, token, org_name, dept_name, expires_at, created_at FROM org_invites WHERE token = ? AND expires_at > ?"
+	row := m.db.QueryRow(query, token, time.Now())
+
+	invite := InviteToken{}
+	err := row.Scan(
+		&invite.Id,
+		&invite.Token,
+		&invite.OrgName,
+		&invite.DeptName,
+		&invite.ExpiresAt,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+var ErrInvalidInvite = errors.New("invalid or expired invite")
+
+// Registers user with the invite token. The invite is used up in the
+// same transaction that inserts the user, so of two registrations with
+// one invite only the first gets in. Fails with ErrInvalidInvite if the
+// invite is used, expired or not good for the user's department
+func (m *InviteModel) Redeem(token string, user User) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"DELETE FROM org_invites WHERE token = ? AND expires_at > ? AND org_name = ? AND (dept_name = '' OR dept_name = ?)",
+		token, time.Now(), user.OrgName, user.DeptName,
+	)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrInvalidInvite
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO users(username, email, password, org_name, dept_name) VALUES(?, ?, ?, ?, ?)",
+		user.Username, user.Email, user.Password, user.OrgName, user.DeptName,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Invites are single use; deleted once someone registers with them
+func (m *InviteModel) Delete(token string) (int64, error) {
+	query := "DELETE FROM org_invites WHERE token = ?"
+	result, err := m.db.Exec(query, token)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
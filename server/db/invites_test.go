@@ -0,0 +1,99 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func testInviteUser(email string) User {
+	return User{
+		Username: "user",
+		Email:    email,
+		Password: PASSWORD_RESET_REQUIRED,
+		OrgName:  "org",
+		DeptName: "dept",
+	}
+}
+
+// Of several registrations racing for one invite only one gets in
+func TestRedeemInviteOnce(t *testing.T) {
+	openTestDB(t)
+	invites := NewInviteModel()
+	invite := NewInviteToken("org", "", time.Hour)
+	_, err := invites.Insert(*invite)
+	if err != nil {
+		t.Fatalf("Error saving invite; %v", err)
+	}
+
+	const racers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, racers)
+	for i := range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- invites.Redeem(invite.Token, testInviteUser(string(rune('a'+i))+"@example.com"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	redeemed := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			redeemed++
+		case ErrInvalidInvite:
+		default:
+			t.Errorf("Redeem failed; %v", err)
+		}
+	}
+	if redeemed != 1 {
+		t.Errorf("%v registrations got in with one invite; want 1", redeemed)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if err != nil || count != 1 {
+		t.Errorf("%v users created, %v; want 1", count, err)
+	}
+}
+
+func TestRedeemInviteRefused(t *testing.T) {
+	openTestDB(t)
+	invites := NewInviteModel()
+
+	expired := NewInviteToken("org", "", -time.Minute)
+	otherDept := NewInviteToken("org", "other", time.Hour)
+	otherOrg := NewInviteToken("other", "", time.Hour)
+	for _, invite := range []*InviteToken{expired, otherDept, otherOrg} {
+		_, err := invites.Insert(*invite)
+		if err != nil {
+			t.Fatalf("Error saving invite; %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", expired.Token},
+		{"other department", otherDept.Token},
+		{"other organization", otherOrg.Token},
+		{"unknown", "unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := invites.Redeem(test.token, testInviteUser("alice@example.com"))
+			if err != ErrInvalidInvite {
+				t.Errorf("Redeem = %v; want ErrInvalidInvite", err)
+			}
+		})
+	}
+
+	exists, err := NewUserModel().Exists("alice@example.com")
+	if err != nil || exists {
+		t.Errorf("User created by a refused invite")
+	}
+}
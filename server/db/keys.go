@@ -0,0 +1,106 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// SECRET_KEY signs tokens. To rotate it move the old key to
+// SECRET_KEY_PREVIOUS (comma separated, newest first), set the new one
+// and send the server SIGHUP; RotateKeys does all of it for the dotenv
+// source. New tokens are signed with the current key while ones signed
+// with a previous key keep working; a previous key can be dropped once
+// its tokens have expired
+type Keyset struct {
+	Current  string
+	Previous []string
+}
+
+// Current key followed by the previous ones
+func (k *Keyset) All() []string {
+	return append([]string{k.Current}, k.Previous...)
+}
+
+var (
+	keys atomic.Pointer[Keyset]
+
+	// Keeps rotations and reloads from interleaving
+	rotateMu sync.Mutex
+)
+
+func loadKeys() (*Keyset, error) {
+	current, err := lib.RequireSecret("SECRET_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	keyset := &Keyset{Current: current}
+	for _, key := range strings.Split(lib.Secret("SECRET_KEY_PREVIOUS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && key != current {
+			keyset.Previous = append(keyset.Previous, key)
+		}
+	}
+	return keyset, nil
+}
+
+// Returns the keys currently in use
+func SecretKeys() *Keyset {
+	return keys.Load()
+}
+
+// Reads SECRET_KEY and SECRET_KEY_PREVIOUS again from the secrets
+// source. The old keyset stays in use if they can't be loaded
+func ReloadKeys() error {
+	rotateMu.Lock()
+	defer rotateMu.Unlock()
+
+	err := lib.ReloadSecrets()
+	if err != nil {
+		return fmt.Errorf("error reloading secrets; %v", err)
+	}
+	keyset, err := loadKeys()
+	if err != nil {
+		return err
+	}
+	keys.Store(keyset)
+	return nil
+}
+
+// Generates a new SECRET_KEY and makes it current, keeping the one it
+// replaces as the newest previous key. The keys are written back to
+// the secrets source so they survive a restart; see lib.SaveSecrets
+func RotateKeys() (*Keyset, error) {
+	rotateMu.Lock()
+	defer rotateMu.Unlock()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	current := hex.EncodeToString(key)
+
+	old := SecretKeys()
+	previous := old.All()
+	err := lib.SaveSecrets(map[string]string{
+		"SECRET_KEY":          current,
+		"SECRET_KEY_PREVIOUS": strings.Join(previous, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyset, err := loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keyset.Current != current {
+		return nil, fmt.Errorf("error rotating SECRET_KEY; the saved key wasn't picked up")
+	}
+	keys.Store(keyset)
+	return keyset, nil
+}
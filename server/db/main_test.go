@@ -0,0 +1,46 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Tests that need a database run against the MySQL database named by
+// the DSN in FUSION_TEST_DSN and skip without one. Every table is
+// dropped and created again, so never point it at real data
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	dsn := os.Getenv("FUSION_TEST_DSN")
+	if dsn == "" {
+		t.Skip("FUSION_TEST_DSN not set")
+	}
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("Error parsing FUSION_TEST_DSN; %v", err)
+	}
+	config.ParseTime = true
+	config.MultiStatements = true
+
+	conn, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		t.Fatalf("Error opening test database; %v", err)
+	}
+	schema, err := sqlDir.ReadFile("sql/mysql_tables.sql")
+	if err != nil {
+		t.Fatalf("Error reading schema; %v", err)
+	}
+	_, err = conn.Exec(string(schema))
+	if err != nil {
+		t.Fatalf("Error creating tables; %v", err)
+	}
+
+	db = conn
+	t.Cleanup(func() {
+		conn.Close()
+		db = nil
+	})
+}
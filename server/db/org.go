@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,6 +16,8 @@ type Organization struct {
 	OrgPassword string `json:"org_password"`
 }
 
+var ErrOrgExists = errors.New("organization already exists")
+
 // Validates user details and creates a new organization.
 // Does password hashing, you can pass in the password as plaintext
 func NewOrganization(
@@ -27,7 +30,7 @@ func NewOrganization(
 	// Check if organization directory already exists
 	_, err := os.Stat(orgDir)
 	if err == nil {
-		return nil, fmt.Errorf("organization already exists")
+		return nil, ErrOrgExists
 	}
 
 	// Create organization directory
@@ -48,14 +51,25 @@ func NewOrganization(
 		}
 	}
 
+	hashed, err := hashPassword(orgPassword)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Organization{
 		Name:        filepath.Base(orgDir),
 		AdminName:   adminName,
 		AdminEmail:  adminEmail,
-		OrgPassword: hashPassword(orgPassword),
+		OrgPassword: hashed,
 	}, nil
 }
 
+// Reports whether password is the organization's password
+func (o *Organization) PasswordMatches(password string) bool {
+	match, _ := CheckPassword(o.OrgPassword, password)
+	return match
+}
+
 type OrganizationModel struct {
 	db *sql.DB
 }
@@ -80,3 +94,40 @@ func (m *OrganizationModel) Insert(o Organization) (int64, error) {
 	}
 	return result.RowsAffected()
 }
+
+// Changes an organization's password. Hashes the password for you;
+// you can pass in the password as plaintext
+func (m *OrganizationModel) ChangePassword(name string, newPassword string) (int64, error) {
+	hashed, err := hashPassword(newPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "UPDATE organizations SET org_password = ? WHERE name = ?"
+	result, err := m.db.Exec(
+		query,
+		hashed,
+		name,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m *OrganizationModel) Get(name string) (*Organization, error) {
+	query := "SELECT name, admin_name, admin_email, org_password FROM organizations WHERE name = ?"
+	row := m.db.QueryRow(query, name)
+
+	var org Organization
+	err := row.Scan(
+		&org.Name,
+		&org.AdminName,
+		&org.AdminEmail,
+		&org.OrgPassword,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
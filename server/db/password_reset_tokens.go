@@ -85,7 +85,7 @@ func (m *PasswordResetModel) Get(email, otp string) (*PasswordResetToken, error)
 }
 
 func (m *PasswordResetModel) Delete(email, otp string) (int64, error) {
-	query := "DELETE password_reset_tokens WHERE email=? AND otp=?"
+	query := "DELETE FROM password_reset_tokens WHERE email=? AND otp=?"
 	result, err := m.db.Exec(
 		query,
 		email,
@@ -96,3 +96,14 @@ func (m *PasswordResetModel) Delete(email, otp string) (int64, error) {
 	}
 	return result.RowsAffected()
 }
+
+// Removes tokens that expired without being used. Returns how many
+// were removed
+func (m *PasswordResetModel) DeleteExpired() (int64, error) {
+	query := "DELETE FROM password_reset_tokens WHERE expires_at <= ?"
+	result, err := m.db.Exec(query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
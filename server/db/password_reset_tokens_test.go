@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// Only tokens past their expiry are purged; live ones still work
+func TestDeleteExpiredResetTokens(t *testing.T) {
+	openTestDB(t)
+	tokens := NewPasswordResetModel()
+
+	expired := NewPasswordResetToken("expired@example.com", -time.Minute)
+	expired.OTP = "111111"
+	live := NewPasswordResetToken("live@example.com", time.Hour)
+	live.OTP = "222222"
+	for _, token := range []*PasswordResetToken{expired, live} {
+		_, err := tokens.Insert(*token)
+		if err != nil {
+			t.Fatalf("Error saving token; %v", err)
+		}
+	}
+
+	removed, err := tokens.DeleteExpired()
+	if err != nil || removed != 1 {
+		t.Fatalf("DeleteExpired = %v, %v; want 1, nil", removed, err)
+	}
+	if _, err := tokens.Get(live.Email, live.OTP); err != nil {
+		t.Errorf("live token gone after DeleteExpired; %v", err)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM password_reset_tokens WHERE email = ?", expired.Email).Scan(&count)
+	if err != nil || count != 0 {
+		t.Errorf("%v expired tokens left, %v; want 0", count, err)
+	}
+
+	removed, err = tokens.DeleteExpired()
+	if err != nil || removed != 0 {
+		t.Errorf("second DeleteExpired = %v, %v; want 0, nil", removed, err)
+	}
+	removed, err = tokens.Delete(live.Email, live.OTP)
+	if err != nil || removed != 1 {
+		t.Errorf("Delete = %v, %v; want 1, nil", removed, err)
+	}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// Lets anyone holding Token download the file at Path, relative to
+// the server's realpath, without logging in. The link stops working
+// once it expires, is revoked or has been used MaxDownloads times
+type ShareLink struct {
+	Id           int       `json:"id"`
+	Token        string    `json:"token"`
+	Path         string    `json:"path"`
+	CreatedBy    string    `json:"created_by"`
+	MaxDownloads int       `json:"max_downloads"` // 0 means unlimited
+	Downloads    int       `json:"downloads"`
+	Revoked      bool      `json:"revoked"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func NewShareLink(path, createdBy string, duration time.Duration, maxDownloads int) *ShareLink {
+	token := make([]byte, 24)
+	rand.Read(token)
+	now := time.Now()
+
+	return &ShareLink{
+		Token:        hex.EncodeToString(token),
+		Path:         path,
+		CreatedBy:    createdBy,
+		MaxDownloads: maxDownloads,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(duration),
+	}
+}
+
+type ShareLinkModel struct {
+	db *sql.DB
+}
+
+func NewShareLinkModel() *ShareLinkModel {
+	return &ShareLinkModel{
+		db: db,
+	}
+}
+
+func (m *ShareLinkModel) Insert(link ShareLink) (int64, error) {
+	query := "INSERT INTO share_links(token, path, created_by, max_downloads, expires_at) VALUES(?, ?, ?, ?, ?)"
+	result, err := m.db.Exec(
+		query,
+		link.Token,
+		link.Path,
+		link.CreatedBy,
+		link.MaxDownloads,
+		link.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Fetches a link that can still be used by its token
+func (m *ShareLinkModel) Get(token string) (*ShareLink, error) {
+	query := "SELECT id, token, path, created_by, max_downloads, downloads, revoked, expires_at, created_at FROM share_links " +
+		"WHERE token = ? AND revoked = FALSE AND expires_at > ? AND (max_downloads = 0 OR downloads < max_downloads)"
+	row := m.db.QueryRow(query, token, time.Now())
+
+	link := ShareLink{}
+	err := row.Scan(
+		&link.Id,
+		&link.Token,
+		&link.Path,
+		&link.CreatedBy,
+		&link.MaxDownloads,
+		&link.Downloads,
+		&link.Revoked,
+		&link.ExpiresAt,
+		&link.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Counts a download against the link. Returns 0 rows affected if the
+// link was used up, revoked or expired in the meantime
+func (m *ShareLinkModel) CountDownload(token string) (int64, error) {
+	query := "UPDATE share_links SET downloads = downloads + 1 " +
+		"WHERE token = ? AND revoked = FALSE AND expires_at > ? AND (max_downloads = 0 OR downloads < max_downloads)"
+	result, err := m.db.Exec(query, token, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Revokes a link. Only the user who created it may revoke it
+func (m *ShareLinkModel) Revoke(token, email string) (int64, error) {
+	query := "UPDATE share_links SET revoked = TRUE WHERE token = ? AND created_by = ?"
+	result, err := m.db.Exec(query, token, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// Saves a new link to the test user's file
+func insertShareLink(t *testing.T, links *ShareLinkModel, duration time.Duration, maxDownloads int) *ShareLink {
+	t.Helper()
+	link := NewShareLink("org/dept/file", "alice@example.com", duration, maxDownloads)
+	_, err := links.Insert(*link)
+	if err != nil {
+		t.Fatalf("Error saving share link; %v", err)
+	}
+	return link
+}
+
+// A link works until it has been used up
+func TestShareLinkValid(t *testing.T) {
+	openTestDB(t)
+	links := NewShareLinkModel()
+	link := insertShareLink(t, links, time.Hour, 2)
+
+	got, err := links.Get(link.Token)
+	if err != nil || got.Path != link.Path || got.CreatedBy != link.CreatedBy {
+		t.Fatalf("Get = %+v, %v; want the link", got, err)
+	}
+	for i := range 2 {
+		if counted, err := links.CountDownload(link.Token); err != nil || counted != 1 {
+			t.Errorf("download %v counted = %v, %v; want 1", i+1, counted, err)
+		}
+	}
+	if counted, _ := links.CountDownload(link.Token); counted != 0 {
+		t.Error("download past max_downloads counted")
+	}
+	if _, err := links.Get(link.Token); err != sql.ErrNoRows {
+		t.Errorf("Get of used up link = %v; want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestShareLinkExpired(t *testing.T) {
+	openTestDB(t)
+	links := NewShareLinkModel()
+	link := insertShareLink(t, links, -time.Minute, 0)
+
+	if _, err := links.Get(link.Token); err != sql.ErrNoRows {
+		t.Errorf("Get of expired link = %v; want %v", err, sql.ErrNoRows)
+	}
+	if counted, _ := links.CountDownload(link.Token); counted != 0 {
+		t.Error("download of expired link counted")
+	}
+}
+
+// Only the link's creator can revoke it, after which it stops working
+func TestShareLinkRevoked(t *testing.T) {
+	openTestDB(t)
+	links := NewShareLinkModel()
+	link := insertShareLink(t, links, time.Hour, 0)
+
+	if revoked, err := links.Revoke(link.Token, "bob@example.com"); err != nil || revoked != 0 {
+		t.Errorf("Revoke by another user = %v, %v; want 0", revoked, err)
+	}
+	if _, err := links.Get(link.Token); err != nil {
+		t.Fatalf("Get after refused revoke = %v", err)
+	}
+	if revoked, err := links.Revoke(link.Token, link.CreatedBy); err != nil || revoked != 1 {
+		t.Errorf("Revoke by creator = %v, %v; want 1", revoked, err)
+	}
+	if _, err := links.Get(link.Token); err != sql.ErrNoRows {
+		t.Errorf("Get of revoked link = %v; want %v", err, sql.ErrNoRows)
+	}
+}
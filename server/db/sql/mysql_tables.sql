@@ -25,6 +25,7 @@ CREATE TABLE IF NOT EXISTS `users` (
   `password` VARCHAR(255) NOT NULL,
   `org_name` VARCHAR(255) NOT NULL,
   `dept_name` VARCHAR(255) NOT NULL,
+  `token_version` INT NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`)
 );
 
@@ -42,3 +43,51 @@ CREATE TABLE IF NOT EXISTS `password_reset_tokens` (
   PRIMARY KEY (`id`)
 );
 
+--
+-- Table structure for table `org_invites`
+--
+DROP TABLE IF EXISTS `org_invites`;
+
+CREATE TABLE IF NOT EXISTS `org_invites` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `token` VARCHAR(255) NOT NULL UNIQUE,
+  `org_name` VARCHAR(255) NOT NULL,
+  `dept_name` VARCHAR(255) NOT NULL DEFAULT '',
+  `expires_at` DATETIME NOT NULL,
+  `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`)
+);
+
+--
+-- Table structure for table `share_links`
+--
+DROP TABLE IF EXISTS `share_links`;
+
+CREATE TABLE IF NOT EXISTS `share_links` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `token` VARCHAR(255) NOT NULL UNIQUE,
+  `path` VARCHAR(4096) NOT NULL,
+  `created_by` VARCHAR(255) NOT NULL,
+  `max_downloads` INT NOT NULL DEFAULT 0,
+  `downloads` INT NOT NULL DEFAULT 0,
+  `revoked` BOOLEAN NOT NULL DEFAULT FALSE,
+  `expires_at` DATETIME NOT NULL,
+  `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`)
+);
+
+--
+-- Table structure for table `email_changes`
+--
+DROP TABLE IF EXISTS `email_changes`;
+
+CREATE TABLE IF NOT EXISTS `email_changes` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `email` VARCHAR(255) NOT NULL UNIQUE,
+  `new_email` VARCHAR(255) NOT NULL,
+  `otp` VARCHAR(255) NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  `attempts` INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`)
+);
@@ -2,9 +2,15 @@ package db
 
 import (
 	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
 
 	"github.com/caleb-mwasikira/fusion/lib"
 )
@@ -16,12 +22,119 @@ type User struct {
 	Password string `json:"password"`
 	OrgName  string `json:"org_name"`
 	DeptName string `json:"dept_name"`
+
+	// Bumped whenever a change to the user makes the tokens issued to
+	// them before out of date
+	TokenVersion int `json:"token_version"`
+}
+
+// Passwords are stored as PBKDF2-SHA256 of the password and a random
+// salt of its own:
+//
+//	pbkdf2-sha256$<iterations>$<salt>$<key>
+//
+// with salt and key base64 encoded. Hashes in any other form predate
+// salting and are cleared by expireLegacyPasswords
+const (
+	PASSWORD_SCHEME     = "pbkdf2-sha256"
+	PASSWORD_ITERATIONS = 600_000
+	PASSWORD_SALT_SIZE  = 16
+	PASSWORD_KEY_SIZE   = 32
+
+	// Stored in place of cleared hashes. Matches no password, so the
+	// user has to reset theirs
+	PASSWORD_RESET_REQUIRED = "!"
+)
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, PASSWORD_SALT_SIZE)
+	rand.Read(salt)
+	return hashPasswordWith(salt, PASSWORD_ITERATIONS, password)
+}
+
+func hashPasswordWith(salt []byte, iterations int, password string) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, PASSWORD_KEY_SIZE)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"%v$%v$%v$%v",
+		PASSWORD_SCHEME,
+		iterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Reports whether hashed is a hash of password and whether it was
+// made with fewer iterations than PASSWORD_ITERATIONS and should be
+// replaced
+func CheckPassword(hashed, password string) (match bool, outdated bool) {
+	fields := strings.Split(hashed, "$")
+	if len(fields) != 4 || fields[0] != PASSWORD_SCHEME {
+		return false, false
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations < 1 {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return false, false
+	}
+
+	computed, err := hashPasswordWith(salt, iterations, password)
+	if err != nil || !hmac.Equal([]byte(computed), []byte(hashed)) {
+		return false, false
+	}
+	return true, iterations < PASSWORD_ITERATIONS
+}
+
+// Clears password hashes of users and organizations stored before
+// passwords were salted. Those held the password itself; users must
+// reset theirs through /auth/forgot-password and organization admins
+// invite users until they set a new organization password
+func expireLegacyPasswords() error {
+	prefix := PASSWORD_SCHEME + "$%"
+
+	result, err := db.Exec(
+		"UPDATE users SET password = ? WHERE password NOT LIKE ? AND password != ?",
+		PASSWORD_RESET_REQUIRED, prefix, PASSWORD_RESET_REQUIRED,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("[WARN] Cleared %v unsalted user passwords; those users must reset their password\n", n)
+	}
+
+	result, err = db.Exec(
+		"UPDATE organizations SET org_password = ? WHERE org_password NOT LIKE ? AND org_password != ?",
+		PASSWORD_RESET_REQUIRED, prefix, PASSWORD_RESET_REQUIRED,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("[WARN] Cleared %v unsalted organization passwords; users can only join those organizations by invite\n", n)
+	}
+	return nil
 }
 
-func hashPassword(password string) string {
-	hash := hmac.New(sha256.New, []byte(SECRET_KEY))
-	digest := hash.Sum([]byte(password))
-	return hex.EncodeToString(digest)
+// Adds the token_version column to users tables created before it
+// existed
+func addTokenVersion() error {
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'token_version')",
+	).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	log.Println("[INFO] Adding token_version to users")
+	_, err = db.Exec("ALTER TABLE users ADD COLUMN token_version INT NOT NULL DEFAULT 0")
+	return err
 }
 
 // Validates user details and creates a new user.
@@ -49,10 +162,15 @@ func NewUser(
 		return nil, err
 	}
 
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
 	return &User{
 		Username: username,
 		Email:    email,
-		Password: hashPassword(password),
+		Password: hashed,
 		OrgName:  orgName,
 		DeptName: deptName,
 	}, nil
@@ -101,6 +219,7 @@ func (m *UserModel) Get(email string) (*User, error) {
 		&user.Password,
 		&user.OrgName,
 		&user.DeptName,
+		&user.TokenVersion,
 	)
 	if err != nil {
 		return nil, err
@@ -121,10 +240,15 @@ func (m *UserModel) Exists(email string) (bool, error) {
 // Changes a user's password. Hashes the password for you; you can pass
 // in the password as plaintext
 func (m *UserModel) ChangePassword(email string, newPassword string) (int64, error) {
+	hashed, err := hashPassword(newPassword)
+	if err != nil {
+		return 0, err
+	}
+
 	query := "UPDATE users SET password = ? WHERE email = ?"
 	result, err := m.db.Exec(
 		query,
-		hashPassword(newPassword),
+		hashed,
 		email,
 	)
 	if err != nil {
@@ -132,3 +256,39 @@ func (m *UserModel) ChangePassword(email string, newPassword string) (int64, err
 	}
 	return result.RowsAffected()
 }
+
+// Reports whether username is taken by anyone else in the department.
+// Personal directories are named after usernames so they have to be
+// unique within one
+func (m *UserModel) UsernameTaken(orgName, deptName, username, exceptEmail string) (bool, error) {
+	var taken bool
+	query := `SELECT EXISTS(SELECT 1 FROM users WHERE org_name = ? AND dept_name = ? AND username = ? AND email != ?)`
+	err := m.db.QueryRow(query, orgName, deptName, username, exceptEmail).Scan(&taken)
+	if err != nil {
+		return false, err
+	}
+	return taken, nil
+}
+
+// Changes the username of the user with email. Tokens issued before
+// carry the old username so their version is bumped
+func (m *UserModel) UpdateUsername(email, username string) (int64, error) {
+	query := "UPDATE users SET username = ?, token_version = token_version + 1 WHERE email = ?"
+	result, err := m.db.Exec(query, username, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Changes the email of the user with email. Only call once newEmail
+// has been confirmed; see EmailChangeModel. Bumps the token version
+// like UpdateUsername
+func (m *UserModel) UpdateEmail(email, newEmail string) (int64, error) {
+	query := "UPDATE users SET email = ?, token_version = token_version + 1 WHERE email = ?"
+	result, err := m.db.Exec(query, newEmail, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashPasswordIsSalted(t *testing.T) {
+	first, err := hashPassword("Secret-password1")
+	if err != nil {
+		t.Fatalf("Error hashing password; %v", err)
+	}
+	second, err := hashPassword("Secret-password1")
+	if err != nil {
+		t.Fatalf("Error hashing password; %v", err)
+	}
+
+	if first == second {
+		t.Error("Same password hashed twice gave the same hash")
+	}
+	if strings.Contains(first, "Secret-password1") {
+		t.Errorf("Hash %q contains the password", first)
+	}
+	if !strings.HasPrefix(first, PASSWORD_SCHEME+"$") {
+		t.Errorf("Hash %q doesn't start with %v", first, PASSWORD_SCHEME)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hashed, err := hashPassword("Secret-password1")
+	if err != nil {
+		t.Fatalf("Error hashing password; %v", err)
+	}
+	weak, err := hashPasswordWith([]byte("0123456789abcdef"), 1000, "Secret-password1")
+	if err != nil {
+		t.Fatalf("Error hashing password; %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		hashed       string
+		password     string
+		wantMatch    bool
+		wantOutdated bool
+	}{
+		{"correct", hashed, "Secret-password1", true, false},
+		{"wrong", hashed, "Secret-password2", false, false},
+		{"empty", hashed, "", false, false},
+		{"fewer iterations", weak, "Secret-password1", true, true},
+		{"reset required", PASSWORD_RESET_REQUIRED, "!", false, false},
+		{"unsalted", "5365637265742d70617373776f726431", "Secret-password1", false, false},
+		{"malformed", PASSWORD_SCHEME + "$x$y$z", "Secret-password1", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, outdated := CheckPassword(test.hashed, test.password)
+			if match != test.wantMatch || outdated != test.wantOutdated {
+				t.Errorf("CheckPassword = %v, %v; want %v, %v", match, outdated, test.wantMatch, test.wantOutdated)
+			}
+		})
+	}
+}
+
+// Usernames are unique within a department only; renaming bumps the
+// token version so tokens carrying the old name are refused
+func TestUpdateUsername(t *testing.T) {
+	openTestDB(t)
+	users := NewUserModel()
+	for _, user := range []User{
+		{Username: "alice", Email: "alice@example.com", OrgName: "org", DeptName: "dept"},
+		{Username: "bob", Email: "bob@example.com", OrgName: "org", DeptName: "dept"},
+		{Username: "carol", Email: "carol@example.com", OrgName: "org", DeptName: "other"},
+	} {
+		user.Password = PASSWORD_RESET_REQUIRED
+		_, err := users.Insert(user)
+		if err != nil {
+			t.Fatalf("Error saving user; %v", err)
+		}
+	}
+
+	tests := []struct {
+		username string
+		want     bool
+	}{
+		{"bob", true},
+		{"alice", false},
+		{"carol", false},
+		{"dave", false},
+	}
+	for _, test := range tests {
+		taken, err := users.UsernameTaken("org", "dept", test.username, "alice@example.com")
+		if err != nil || taken != test.want {
+			t.Errorf("UsernameTaken(%v) = %v, %v; want %v", test.username, taken, err, test.want)
+		}
+	}
+
+	count, err := users.UpdateUsername("alice@example.com", "dave")
+	if err != nil || count != 1 {
+		t.Fatalf("UpdateUsername = %v, %v; want 1, nil", count, err)
+	}
+	user, err := users.Get("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "dave" || user.TokenVersion != 1 {
+		t.Errorf("renamed user = %v at token version %v; want dave at 1", user.Username, user.TokenVersion)
+	}
+}
+
+// Moving to an email another account has fails; moving to a free one
+// leaves nothing under the old email
+func TestUpdateEmail(t *testing.T) {
+	openTestDB(t)
+	users := NewUserModel()
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		_, err := users.Insert(User{Username: "user", Email: email, Password: PASSWORD_RESET_REQUIRED, OrgName: "org", DeptName: "dept"})
+		if err != nil {
+			t.Fatalf("Error saving user; %v", err)
+		}
+	}
+
+	if _, err := users.UpdateEmail("alice@example.com", "bob@example.com"); err == nil {
+		t.Error("UpdateEmail onto another account's email succeeded")
+	}
+	count, err := users.UpdateEmail("alice@example.com", "new@example.com")
+	if err != nil || count != 1 {
+		t.Fatalf("UpdateEmail = %v, %v; want 1, nil", count, err)
+	}
+	if exists, _ := users.Exists("alice@example.com"); exists {
+		t.Error("old email still has an account")
+	}
+	user, err := users.Get("new@example.com")
+	if err != nil || user.TokenVersion != 1 {
+		t.Errorf("moved user = %+v, %v; want it at token version 1", user, err)
+	}
+}
+
+// A new email change replaces the pending one, and wrong OTPs are
+// counted against it
+func TestEmailChangeReplaced(t *testing.T) {
+	openTestDB(t)
+	changes := NewEmailChangeModel()
+	for _, newEmail := range []string{"first@example.com", "second@example.com"} {
+		_, err := changes.Insert(*NewEmailChange("alice@example.com", newEmail, time.Hour))
+		if err != nil {
+			t.Fatalf("Error saving email change; %v", err)
+		}
+	}
+
+	change, err := changes.Get("alice@example.com")
+	if err != nil || change.NewEmail != "second@example.com" {
+		t.Fatalf("pending change = %+v, %v; want the second", change, err)
+	}
+	for want := 1; want <= 2; want++ {
+		if attempts, err := changes.AddAttempt("alice@example.com"); err != nil || attempts != want {
+			t.Errorf("AddAttempt = %v, %v; want %v", attempts, err, want)
+		}
+	}
+
+	_, err = changes.Insert(*NewEmailChange("bob@example.com", "bob@new.example.com", -time.Minute))
+	if err != nil {
+		t.Fatalf("Error saving email change; %v", err)
+	}
+	if _, err := changes.Get("bob@example.com"); err == nil {
+		t.Error("expired email change still pending")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reports whether this process still has path open
+func fileOpen(t *testing.T, path string) bool {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	for _, fd := range fds {
+		target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if target == path {
+			return true
+		}
+	}
+	return false
+}
+
+// A client that stops reading is cut off once it stalls for
+// -download-stall or the download runs past -download-timeout, and
+// the file is closed
+func TestDownloadToStalledClient(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	path := filepath.Join(dir, "stalled")
+	// Far more than flow control lets the server send unread
+	err := os.WriteFile(path, make([]byte, 64*1024*1024), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+
+	tests := []struct {
+		name           string
+		stall, timeout time.Duration
+	}{
+		{"stall", 200 * time.Millisecond, time.Hour},
+		{"timeout", 0, 200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldStall, oldTimeout := downloadStall, downloadTimeout
+			downloadStall, downloadTimeout = test.stall, test.timeout
+			t.Cleanup(func() { downloadStall, downloadTimeout = oldStall, oldTimeout })
+
+			stream, err := client.DownloadFile(ctx, &proto.DownloadRequest{Path: "/stalled"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Recv(); err != nil {
+				t.Fatalf("first chunk = %v", err)
+			}
+			time.Sleep(time.Second)
+
+			for {
+				_, err = stream.Recv()
+				if err != nil {
+					break
+				}
+			}
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Fatalf("stalled download = %v; want DeadlineExceeded", err)
+			}
+			if fileOpen(t, path) {
+				t.Error("file still open after the download was aborted")
+			}
+		})
+	}
+}
+
+// Allocations of a whole 1MB download; see BenchmarkChunkBuffers in
+// lib for the share the chunk buffer had
+func BenchmarkDownloadFile(b *testing.B) {
+	client, ctx := newTestClient(b, FuseServer{path: mountpoint}, testUser)
+	path := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, "bench")
+	err := os.WriteFile(path, make([]byte, 1024*1024), 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.Remove(path) })
+
+	b.ReportAllocs()
+	for b.Loop() {
+		stream, err := client.DownloadFile(ctx, &proto.DownloadRequest{Path: "/bench"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			_, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Sends HTML emails. Which implementation is used is picked by the
+// EMAIL_TRANSPORT env variable; see newEmailSender
+type EmailSender interface {
+	Send(to, subject, html string) error
+}
+
+// Returns the email sender selected by EMAIL_TRANSPORT:
+//
+//	smtp (default), ses, sendmail or log
+func newEmailSender() (EmailSender, error) {
+	_, _, err := lib.LookupSecret("EMAIL_TRANSPORT")
+	if err != nil {
+		return nil, fmt.Errorf("error loading secrets; %v", err)
+	}
+
+	transport := strings.ToLower(strings.TrimSpace(lib.Secret("EMAIL_TRANSPORT")))
+	switch transport {
+	case "", "smtp":
+		return smtpSender{
+			host:     lib.Secret("SMTP_HOST"),
+			port:     lib.Secret("SMTP_PORT"),
+			from:     lib.Secret("SMTP_EMAIL"),
+			password: lib.Secret("SMTP_PASSWORD"), // App Password (not actual Gmail password)
+		}, nil
+
+	case "ses":
+		return sesSender{
+			region:    lib.Secret("AWS_REGION"),
+			accessKey: lib.Secret("AWS_ACCESS_KEY_ID"),
+			secretKey: lib.Secret("AWS_SECRET_ACCESS_KEY"),
+			from:      lib.Secret("SES_FROM_EMAIL"),
+		}, nil
+
+	case "sendmail":
+		path := lib.Secret("SENDMAIL_PATH")
+		if path == "" {
+			path = "/usr/sbin/sendmail"
+		}
+		return sendmailSender{
+			path: path,
+			from: lib.Secret("SENDMAIL_FROM"),
+		}, nil
+
+	case "log":
+		return logSender{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_TRANSPORT %q", transport)
+	}
+}
+
+// Builds the raw message sent by the smtp and sendmail senders
+func mimeMessage(from, to, subject, html string) []byte {
+	return []byte(
+		"From: " + from + "\r\n" +
+			"To: " + to + "\r\n" +
+			"Subject: " + subject + "\r\n" +
+			"MIME-version: 1.0;\r\n" +
+			"Content-Type: text/html; charset=\"UTF-8\";\r\n" +
+			"\r\n" +
+			html,
+	)
+}
+
+type smtpSender struct {
+	host, port     string
+	from, password string
+}
+
+func (s smtpSender) Send(to, subject, html string) error {
+	auth := smtp.PlainAuth("", s.from, s.password, s.host)
+	addr := net.JoinHostPort(s.host, s.port)
+	return smtp.SendMail(addr, auth, s.from, []string{to}, mimeMessage(s.from, to, subject, html))
+}
+
+// Pipes messages into a local sendmail binary
+type sendmailSender struct {
+	path string
+	from string
+}
+
+func (s sendmailSender) Send(to, subject, html string) error {
+	cmd := exec.Command(s.path, "-t", "-i")
+	cmd.Stdin = bytes.NewReader(mimeMessage(s.from, to, subject, html))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sendmail failed; %v: %s", err, output)
+	}
+	return nil
+}
+
+// Only logs emails. Meant for development
+type logSender struct{}
+
+func (logSender) Send(to, subject, html string) error {
+	log.Printf("[EMAIL] To: %v; Subject: %v\n%v\n", to, subject, html)
+	return nil
+}
+
+// Sends emails through the AWS SES v2 API
+type sesSender struct {
+	region               string
+	accessKey, secretKey string
+	from                 string
+}
+
+func (s sesSender) Send(to, subject, html string) error {
+	body, err := json.Marshal(map[string]any{
+		"FromEmailAddress": s.from,
+		"Destination": map[string]any{
+			"ToAddresses": []string{to},
+		},
+		"Content": map[string]any{
+			"Simple": map[string]any{
+				"Subject": map[string]string{"Data": subject, "Charset": "UTF-8"},
+				"Body": map[string]any{
+					"Html": map[string]string{"Data": html, "Charset": "UTF-8"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	host := fmt.Sprintf("email.%v.amazonaws.com", s.region)
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, host, body, time.Now().UTC())
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("SES responded with %v; %s", resp.Status, message)
+	}
+	return nil
+}
+
+// Signs req with AWS signature version 4
+func (s sesSender) sign(req *http.Request, host string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.region + "/ses/aws4_request"
+
+	hash := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.Path,
+		"",
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + host,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		hash(body),
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hash([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := mac([]byte("AWS4"+s.secretKey), date)
+	key = mac(key, s.region)
+	key = mac(key, "ses")
+	key = mac(key, "aws4_request")
+	signature := hex.EncodeToString(mac(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.accessKey, scope, signedHeaders, signature,
+	))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The log sender writes out the whole password reset email, OTP
+// included
+func TestLogSenderPasswordResetEmail(t *testing.T) {
+	t.Setenv("EMAIL_TRANSPORT", "log")
+	sender, err := newEmailSender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sender.(logSender); !ok {
+		t.Fatalf("EMAIL_TRANSPORT=log gave %T", sender)
+	}
+
+	output := bytes.Buffer{}
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	err = sendEmail("bob@example.com", "482913")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := passwordResetEmail("482913")
+	for _, want := range []string{"To: bob@example.com", "Subject: " + subject, ">482913</div>", "10 minutes"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("logged email lacks %q:\n%v", want, output.String())
+		}
+	}
+}
+
+func TestNewEmailSender(t *testing.T) {
+	tests := map[string]string{
+		"":         "main.smtpSender",
+		"SMTP":     "main.smtpSender",
+		"ses":      "main.sesSender",
+		"sendmail": "main.sendmailSender",
+		" log ":    "main.logSender",
+	}
+	for transport, want := range tests {
+		t.Setenv("EMAIL_TRANSPORT", transport)
+		sender, err := newEmailSender()
+		if err != nil {
+			t.Errorf("EMAIL_TRANSPORT=%q failed; %v", transport, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", sender); got != want {
+			t.Errorf("EMAIL_TRANSPORT=%q gave %v; want %v", transport, got, want)
+		}
+	}
+
+	t.Setenv("EMAIL_TRANSPORT", "pigeon")
+	if _, err := newEmailSender(); err == nil {
+		t.Error("unknown EMAIL_TRANSPORT accepted")
+	}
+}
+
+func TestMimeMessage(t *testing.T) {
+	message := string(mimeMessage("from@example.com", "to@example.com", "Subject", "<p>body</p>"))
+	header, body, ok := strings.Cut(message, "\r\n\r\n")
+	if !ok || body != "<p>body</p>" {
+		t.Fatalf("message = %q; want headers then the body", message)
+	}
+	for _, want := range []string{"From: from@example.com", "To: to@example.com", "Subject: Subject", "Content-Type: text/html"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("headers lack %q:\n%v", want, header)
+		}
+	}
+}
@@ -19,16 +19,20 @@ type FileHandle struct {
 	mu   sync.Mutex
 	fd   int
 	path string
+
+	// Gives back the handle reserved with acquireFuseHandle
+	release func()
 }
 
 // NewLoopbackFile creates a FileHandle out of a file descriptor. All
 // operations are implemented. When using the Fd from a *os.File, call
 // syscall.Dup() on the fd, to avoid os.File's finalizer from closing
 // the file descriptor.
-func NewLoopbackFile(fd int, path string) fs.FileHandle {
+func NewLoopbackFile(fd int, path string, release func()) fs.FileHandle {
 	return &FileHandle{
-		fd:   fd,
-		path: path,
+		fd:      fd,
+		path:    path,
+		release: release,
 	}
 }
 
@@ -82,6 +86,9 @@ func (f *FileHandle) Release(ctx context.Context) syscall.Errno {
 	if f.fd != -1 {
 		err := syscall.Close(f.fd)
 		f.fd = -1
+		if f.release != nil {
+			f.release()
+		}
 		return fs.ToErrno(err)
 	}
 	return syscall.EBADF
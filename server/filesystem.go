@@ -8,6 +8,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/events"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -17,9 +18,21 @@ import (
 // Get relative path for both FUSE filesystem and GRPC
 // FUSE filesystem writes to realpath
 // GRPC writes to the mountpoint
+//
+// Returned paths are wire paths; see lib.ToWire. Paths outside both
+// directories, including "", are returned as they are
 func relativePath(path string) string {
-	path = strings.TrimPrefix(path, realpath)
-	path = strings.TrimPrefix(path, mountpoint)
+	for _, root := range []string{realpath, mountpoint} {
+		root = filepath.Clean(root)
+		if path == root {
+			return "/"
+		}
+		// Match whole path components so that eg. /data2 is not
+		// taken to be inside /data
+		if strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return lib.ToWire(path[len(root):])
+		}
+	}
 	return path
 }
 
@@ -62,40 +75,16 @@ func NewFileSystem(realpath string) (fs.InodeEmbedder, error) {
 }
 
 func (n *Node) OnAdd(ctx context.Context) {
-	if !n.IsDir() {
-		return
-	}
-
-	files, err := os.ReadDir(n.path)
-	if err != nil {
-		return
-	}
-
-	for _, file := range files {
-		fullpath := filepath.Join(n.path, file.Name())
-
-		stat := syscall.Stat_t{}
-		err = syscall.Lstat(fullpath, &stat)
-		if err != nil {
-			continue
-		}
-
-		child := n.NewPersistentInode(
-			ctx,
-			&Node{path: fullpath},
-			fs.StableAttr{
-				Ino:  stat.Ino,
-				Mode: stat.Mode,
-			},
-		)
-		n.AddChild(file.Name(), child, false)
-	}
+	lib.LiveInodes.Add(1)
 }
 
+// Disk usage of realpath; see -statfs-ttl
+var localStatfs *lib.StatfsCache
+
 func (n *Node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
 	log.Printf("[FUSE] Statfs %v\n", n.path)
 	stat := syscall.Statfs_t{}
-	err := syscall.Statfs(n.path, &stat)
+	err := localStatfs.Statfs(n.path, &stat)
 	if err != nil {
 		log.Printf("[FUSE] Stafs %v failed; %v\n", n.path, err)
 		return fs.ToErrno(err)
@@ -116,7 +105,7 @@ func (n *Node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs
 	}
 	out.Attr.FromStat(&stat)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: fullpath},
 		fs.StableAttr{
@@ -124,7 +113,6 @@ func (n *Node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
 	return child, fs.OK
 }
 
@@ -134,7 +122,7 @@ func (n *Node) Mkdir(ctx context.Context, name string, _mode uint32, out *fuse.E
 
 	log.Printf("[FUSE] Mkdir; %v\n", relativePath(fullpath))
 
-	err := os.Mkdir(fullpath, mode)
+	err := lib.Mkdir(fullpath, _mode)
 	if err != nil {
 		log.Printf("[FUSE] Mkdir %v failed; %v\n", relativePath(fullpath), err)
 		return nil, fs.ToErrno(err)
@@ -149,7 +137,7 @@ func (n *Node) Mkdir(ctx context.Context, name string, _mode uint32, out *fuse.E
 	}
 	out.Attr.FromStat(&stat)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: fullpath},
 		fs.StableAttr{
@@ -157,7 +145,8 @@ func (n *Node) Mkdir(ctx context.Context, name string, _mode uint32, out *fuse.E
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
+
+	syncParent(fullpath)
 
 	go notifyObservers(
 		events.ADD_FILE, fullpath, "", mode,
@@ -166,6 +155,22 @@ func (n *Node) Mkdir(ctx context.Context, name string, _mode uint32, out *fuse.E
 	return child, fs.OK
 }
 
+// Flushes a directory to disk. A variable so tests can see which
+// directories were flushed
+var fsyncDir = lib.FsyncPath
+
+// Makes the entry for path durable in its parent directory. Costs a
+// disk flush per change so it is only done with -fsync-dirs
+func syncParent(path string) {
+	if !fsyncDirs {
+		return
+	}
+	err := fsyncDir(filepath.Dir(path))
+	if err != nil {
+		log.Printf("[FUSE] Error syncing directory of %v; %v\n", relativePath(path), err)
+	}
+}
+
 func (n *Node) Rmdir(ctx context.Context, name string) syscall.Errno {
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Rmdir %v\n", relativePath(fullpath))
@@ -175,6 +180,7 @@ func (n *Node) Rmdir(ctx context.Context, name string) syscall.Errno {
 		return fs.ToErrno(err)
 	}
 
+	syncParent(fullpath)
 	go n.RmChild(name)
 
 	go notifyObservers(
@@ -191,7 +197,15 @@ func (n *Node) Unlink(ctx context.Context, name string) syscall.Errno {
 	if err != nil {
 		return fs.ToErrno(err)
 	}
-	return fs.ToErrno(err)
+
+	// go-fuse drops the child itself once Unlink succeeds
+	syncParent(fullpath)
+
+	go notifyObservers(
+		events.DELETE_FILE, fullpath, "", 0,
+	)
+
+	return fs.OK
 }
 
 func (n *Node) Rename(ctx context.Context, oldName string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
@@ -220,6 +234,10 @@ func (n *Node) Rename(ctx context.Context, oldName string, newParent fs.InodeEmb
 		log.Printf("[FUSE] Rename %v -> %v failed; %v\n", oldpath, newpath, err)
 		return fs.ToErrno(err)
 	}
+	syncParent(newpath)
+	if n.path != newNode.path {
+		syncParent(oldpath)
+	}
 
 	// Remove old entry from parent
 	oldChild := n.GetChild(oldName)
@@ -232,23 +250,14 @@ func (n *Node) Rename(ctx context.Context, oldName string, newParent fs.InodeEmb
 		}()
 	}
 
-	var stat syscall.Stat_t
-	err = syscall.Lstat(newpath, &stat)
-	if err != nil {
-		log.Printf("[FUSE] Rename %v -> %v failed; %v\n", oldpath, newpath, err)
-		return fs.ToErrno(err)
+	// The bridge moves the existing inode under newParent once we
+	// return; point it at its new location on disk.
+	if oldChild != nil {
+		if node, ok := oldChild.Operations().(*Node); ok {
+			node.path = newpath
+		}
 	}
 
-	child := newNode.NewPersistentInode(
-		ctx,
-		&Node{path: newpath},
-		fs.StableAttr{
-			Ino:  stat.Ino,
-			Mode: stat.Mode,
-		},
-	)
-	newNode.AddChild(newName, child, false)
-
 	// For rename, we send 2 file events; delete oldpath, and create newpath
 	go notifyObservers(
 		events.RENAME_FILE, oldpath, relativePath(newpath), 0,
@@ -261,6 +270,24 @@ func (n *Node) Create(ctx context.Context, name string, flags uint32, mode uint3
 	fullpath := filepath.Join(n.path, name)
 	log.Printf("[FUSE] Create %v\n", relativePath(fullpath))
 
+	// Creating a file needs write and search permission on its parent
+	errno = lib.CheckPermissions(ctx, n.path, lib.W_OK|lib.X_OK)
+	if errno != 0 {
+		log.Printf("[FUSE] Create %v failed; %v\n", relativePath(fullpath), errno)
+		return nil, nil, 0, errno
+	}
+
+	release, errno := acquireFuseHandle(ctx)
+	if errno != 0 {
+		log.Printf("[FUSE] Create %v failed; %v\n", relativePath(fullpath), errno)
+		return nil, nil, 0, errno
+	}
+	defer func() {
+		if errno != 0 {
+			release()
+		}
+	}()
+
 	file, err := os.OpenFile(fullpath, int(flags), 0755)
 	if err != nil {
 		log.Printf("[FUSE] Create %v failed; %v\n", relativePath(fullpath), err)
@@ -275,7 +302,7 @@ func (n *Node) Create(ctx context.Context, name string, flags uint32, mode uint3
 	}
 	out.FromStat(&stat)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: fullpath},
 		fs.StableAttr{
@@ -283,25 +310,26 @@ func (n *Node) Create(ctx context.Context, name string, flags uint32, mode uint3
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
 
 	fd, err := syscall.Dup(int(file.Fd()))
 	if err != nil {
 		return nil, nil, 0, fs.ToErrno(err)
 	}
 
+	syncParent(fullpath)
+
 	go notifyObservers(
 		events.ADD_FILE, fullpath, "", os.FileMode(stat.Mode),
 	)
 
-	return child, NewLoopbackFile(fd, fullpath), 0, fs.OK
+	return child, NewLoopbackFile(fd, fullpath, release), 0, fs.OK
 }
 
 func (n *Node) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	fullpath := filepath.Join(n.path, name)
-	log.Printf("[FUSE] Symlink; %v\n", relativePath(fullpath))
+	log.Printf("[FUSE] Symlink; %v -> %v\n", relativePath(fullpath), target)
 
-	err := syscall.Symlink(target, relativePath(fullpath))
+	err := syscall.Symlink(target, fullpath)
 	if err != nil {
 		log.Printf("[FUSE] Symlink %v failed; %v\n", relativePath(fullpath), err)
 		return nil, fs.ToErrno(err)
@@ -316,7 +344,7 @@ func (n *Node) Symlink(ctx context.Context, target, name string, out *fuse.Entry
 	}
 	out.Attr.FromStat(&stat)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: fullpath},
 		fs.StableAttr{
@@ -324,7 +352,6 @@ func (n *Node) Symlink(ctx context.Context, target, name string, out *fuse.Entry
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
 	return child, fs.OK
 }
 
@@ -353,7 +380,7 @@ func (n *Node) Link(ctx context.Context, target fs.InodeEmbedder, name string, o
 	}
 	out.Attr.FromStat(&stat)
 
-	child := n.NewPersistentInode(
+	child := n.NewInode(
 		ctx,
 		&Node{path: newpath},
 		fs.StableAttr{
@@ -361,59 +388,42 @@ func (n *Node) Link(ctx context.Context, target fs.InodeEmbedder, name string, o
 			Mode: stat.Mode,
 		},
 	)
-	n.AddChild(name, child, false)
 	return child, fs.OK
 }
 
 func (n *Node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
-	fullpath := n.path
-
-	// No idea whats going on here
-	for l := 256; ; l *= 2 {
-		buf := make([]byte, l)
-		size, err := syscall.Readlink(fullpath, buf)
-		if err != nil {
-			return nil, fs.ToErrno(err)
-		}
-
-		if size < len(buf) {
-			return buf[:size], 0
-		}
-	}
+	return lib.Readlink(n.path)
 }
 
 func (n *Node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
 	log.Printf("[FUSE] Open %v\n", n.path)
-	file, err := os.OpenFile(n.path, int(flags), 0755)
-	if err != nil {
-		log.Printf("[FUSE] Open %v failed; %v\n", n.path, err)
-		return nil, 0, fs.ToErrno(err)
+
+	errno := lib.CheckPermissions(ctx, n.path, lib.AccessMask(flags))
+	if errno != 0 {
+		log.Printf("[FUSE] Open %v failed; %v\n", n.path, errno)
+		return nil, 0, errno
 	}
 
-	stat := syscall.Stat_t{}
-	err = syscall.Lstat(n.path, &stat)
+	release, errno := acquireFuseHandle(ctx)
+	if errno != 0 {
+		log.Printf("[FUSE] Open %v failed; %v\n", n.path, errno)
+		return nil, 0, errno
+	}
+
+	file, err := os.OpenFile(n.path, int(flags), 0755)
 	if err != nil {
+		release()
 		log.Printf("[FUSE] Open %v failed; %v\n", n.path, err)
 		return nil, 0, fs.ToErrno(err)
 	}
 
-	name := filepath.Base(n.path)
-	child := n.NewPersistentInode(
-		ctx,
-		&Node{path: n.path},
-		fs.StableAttr{
-			Ino:  stat.Ino,
-			Mode: stat.Mode,
-		},
-	)
-	n.AddChild(name, child, false)
-
 	fd, err := syscall.Dup(int(file.Fd()))
 	if err != nil {
+		release()
 		return nil, 0, fs.ToErrno(err)
 	}
 
-	return NewLoopbackFile(fd, n.path), 0, fs.OK
+	return NewLoopbackFile(fd, n.path, release), 0, fs.OK
 }
 
 func (n *Node) OpendirHandle(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
@@ -430,36 +440,21 @@ func (n *Node) OpendirHandle(ctx context.Context, flags uint32) (fs.FileHandle,
 func (n *Node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	// log.Printf("[FUSE] Readdir %v\n", n.path)
 
-	entries := []fuse.DirEntry{}
-	files, err := os.ReadDir(n.path)
+	entries, err := lib.ReadDir(n.path)
 	if err != nil {
 		return nil, fs.ToErrno(err)
 	}
-	for _, f := range files {
-		info, err := f.Info()
-		if err != nil {
-			continue
-		}
-
-		var mode uint32
-		if info.IsDir() {
-			mode = fuse.S_IFDIR | uint32(info.Mode().Perm())
-		} else {
-			mode = fuse.S_IFREG | uint32(info.Mode().Perm())
-		}
-
-		entries = append(entries, fuse.DirEntry{
-			Name: f.Name(),
-			Mode: mode,
-			Ino:  uint64(info.Sys().(*syscall.Stat_t).Ino),
-		})
-	}
 	return fs.NewListDirStream(entries), fs.OK
 }
 
 func (n *Node) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	// log.Printf("[FUSE] Getattr %v\n", n.path)
 
+	// An open handle sees its own writes and truncates
+	if fga, ok := fh.(fs.FileGetattrer); ok && fga != nil {
+		return fga.Getattr(ctx, out)
+	}
+
 	stat := syscall.Stat_t{}
 	err := syscall.Lstat(n.path, &stat)
 	if err != nil {
@@ -556,5 +551,5 @@ func (n *Node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn
 }
 
 func (n *Node) OnForget() {
-	n.ForgetPersistent()
+	lib.LiveInodes.Add(-1)
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+func TestSetattrFlagsOwner(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	file, _ := setattrFixture(t)
+	fullpath := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, file)
+
+	current, err := lib.GetPathFlags(fullpath)
+	if err != nil {
+		t.Skipf("Filesystem doesn't support file flags; %v", err)
+	}
+
+	// Requests that change nothing pass whoever owns the file
+	_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: file, Flags: &current})
+	if err != nil {
+		t.Errorf("Setattr with unchanged flags failed; %v", err)
+	}
+
+	err = lib.SetOwner(fullpath, testUser.Email)
+	if err != nil {
+		t.Skipf("Filesystem doesn't support xattrs; %v", err)
+	}
+	flags := current | lib.FS_APPEND_FL
+	_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: file, Flags: &flags})
+	if errno := lib.StatusErrno(err); err != nil && errno != syscall.EPERM && errno != syscall.EOPNOTSUPP && errno != syscall.ENOTTY {
+		t.Errorf("Setattr by owner = %v; want success or a refusal from the filesystem", err)
+	}
+	if err == nil {
+		got, err := lib.GetPathFlags(fullpath)
+		if err != nil || got != flags {
+			t.Errorf("flags after Setattr = %#x, %v; want %#x", got, err, flags)
+		}
+	}
+	t.Cleanup(func() {
+		lib.SetPathFlags(fullpath, current)
+		os.Remove(fullpath)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Records the directories flushed while the test runs with
+// -fsync-dirs set to enabled
+func useTestFsyncDirs(t *testing.T, enabled bool) func() []string {
+	var (
+		mu     sync.Mutex
+		synced []string
+	)
+	oldFsync, oldEnabled := fsyncDir, fsyncDirs
+	fsyncDirs = enabled
+	fsyncDir = func(path string) error {
+		mu.Lock()
+		synced = append(synced, path)
+		mu.Unlock()
+		return oldFsync(path)
+	}
+	t.Cleanup(func() { fsyncDir, fsyncDirs = oldFsync, oldEnabled })
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := synced
+		synced = nil
+		return got
+	}
+}
+
+// With -fsync-dirs each namespace change flushes the directories whose
+// entries it changed, and only with it
+func TestFsyncDirsAfterChanges(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "other"), 0755)
+	if err == nil {
+		// Never looked up, so renaming it has no kernel to notify
+		err = os.WriteFile(filepath.Join(dir, "plain"), nil, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := fs.NewNodeFS(&Node{path: dir}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	other := fuse.EntryOut{}
+	if code := raw.Lookup(nil, &header, "other", &other); !code.Ok() {
+		t.Fatalf("Lookup = %v", code)
+	}
+
+	synced := useTestFsyncDirs(t, false)
+	created := fuse.CreateOut{}
+	in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}
+	if code := raw.Create(nil, in, "unsynced", &created); !code.Ok() {
+		t.Fatalf("Create = %v", code)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: created.NodeId}, Fh: created.Fh})
+	if got := synced(); len(got) != 0 {
+		t.Errorf("flushed %q without -fsync-dirs", got)
+	}
+
+	synced = useTestFsyncDirs(t, true)
+	tests := []struct {
+		op   string
+		do   func() fuse.Status
+		want []string
+	}{
+		{"create", func() fuse.Status {
+			code := raw.Create(nil, in, "file", &created)
+			raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: created.NodeId}, Fh: created.Fh})
+			return code
+		}, []string{dir}},
+		{"mkdir", func() fuse.Status {
+			return raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 0755}, "dir", &fuse.EntryOut{})
+		}, []string{dir}},
+		{"rename", func() fuse.Status {
+			return raw.Rename(nil, &fuse.RenameIn{InHeader: header, Newdir: other.NodeId}, "plain", "moved")
+		}, []string{dir, filepath.Join(dir, "other")}},
+		{"unlink", func() fuse.Status {
+			return raw.Unlink(nil, &fuse.InHeader{NodeId: other.NodeId}, "moved")
+		}, []string{filepath.Join(dir, "other")}},
+		{"rmdir", func() fuse.Status {
+			return raw.Rmdir(nil, &header, "dir")
+		}, []string{dir}},
+	}
+	for _, test := range tests {
+		if code := test.do(); !code.Ok() {
+			t.Fatalf("%v = %v", test.op, code)
+		}
+		got := synced()
+		slices.Sort(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%v flushed %q; want %q", test.op, got, test.want)
+		}
+	}
+
+	// Uploads replace files by renaming a temporary file into place
+	err = replaceFile(filepath.Join(dir, "replaced"), []byte("replaced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := synced(); !slices.Equal(got, []string{dir}) {
+		t.Errorf("replaceFile flushed %q; want %q", got, []string{dir})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "replaced")); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,11 +1,12 @@
 module github.com/caleb-mwasikira/fusion/server
 
-go 1.23.6
+go 1.24
 
 require (
 	github.com/caleb-mwasikira/fusion/proto v0.0.0-20250718080408-0e0da6ff7b4a
 	github.com/hanwen/go-fuse/v2 v2.8.0
 	golang.org/x/sys v0.31.0
+	golang.org/x/text v0.23.0
 	google.golang.org/grpc v1.73.0
 	google.golang.org/protobuf v1.36.6
 )
@@ -17,6 +18,5 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
 	github.com/mattn/go-sqlite3 v1.14.30 // indirect
 	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250324211829-b45e905df463 // indirect
 )
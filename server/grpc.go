@@ -2,17 +2,19 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
 	"github.com/caleb-mwasikira/fusion/server/auth"
 	"github.com/caleb-mwasikira/fusion/server/db"
@@ -20,6 +22,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type FuseServer struct {
@@ -39,25 +42,46 @@ func NewFuseServer(ctx context.Context, path string) FuseServer {
 
 var _ = (proto.FuseServer)((*FuseServer)(nil))
 
+// Permission bits clients may set. Leaves out setuid and setgid so no
+// client can make a file that runs with its owner's or group's rights
+const CLIENT_MODE_MASK = 01777
+
+// Gets the user embedded into the context by the auth interceptors
+func currentUser(ctx context.Context) (*db.User, error) {
+	user, ok := ctx.Value(auth.USER_CTX_KEY).(*db.User)
+	if !ok {
+		// Usr is NOT logged in
+		// The system should never reach this state as we are relying on the
+		// auth interceptor to filter unauthenticated gRPC requests
+		return nil, errors.New("user not logged in")
+	}
+	return user, nil
+}
+
 // Gets the logged in user's root directory
 //
 //	returns:
 //		string: path they are allowed access to
 //		error: if access is denied
 func getUsersDir(ctx context.Context) (string, error) {
-	user, ok := ctx.Value(userCtxKey).(*db.User)
-	if !ok {
-		// Usr is NOT logged in
-		// The system should never reach this state as we are relying on the
-		// auth interceptor to filter unauthenticated gRPC requests
-		return "", errors.New("user not logged in")
+	user, err := currentUser(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	fullpath := filepath.Join(mountpoint, user.OrgName, user.DeptName)
+	dir := filepath.Join(user.OrgName, user.DeptName)
+	if requestScope(ctx) == SCOPE_PERSONAL {
+		err = provisionPersonalDir(user)
+		if err != nil {
+			return "", err
+		}
+		dir = personalDir(user)
+	}
+	fullpath := filepath.Join(mountpoint, dir)
 
 	// Check if directory exists
 	stat := syscall.Stat_t{}
-	err := syscall.Stat(fullpath, &stat)
+	err = syscall.Stat(fullpath, &stat)
 	if err != nil {
 		return "", err
 	}
@@ -65,6 +89,59 @@ func getUsersDir(ctx context.Context) (string, error) {
 	return relativePath(fullpath), nil
 }
 
+// Fails with ResourceExhausted once the user's org has used up its
+// inode quota. Otherwise reserves an inode, which callers keep once
+// the file is created and release either way. Nothing is reserved if
+// fullpath already exists, eg. a name merged onto an existing one
+func checkInodeQuota(ctx context.Context, fullpath string) (*inodeReservation, error) {
+	_, err := os.Lstat(fullpath)
+	if err == nil {
+		return nil, nil
+	}
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	if !underInodeQuota(user.OrgName) {
+		return nil, status.Error(codes.ResourceExhausted, "Inode quota exceeded")
+	}
+	return reserveInode(user.OrgName), nil
+}
+
+// Records the logged in user as the owner of path. Ownership lives
+// on the file in realpath since our FUSE nodes do not pass xattrs
+// through
+func setOwner(ctx context.Context, path string) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return
+	}
+
+	err = lib.SetOwner(filepath.Join(realpath, relativePath(path)), user.Email)
+	if err != nil {
+		log.Printf("[GRPC] Error setting owner of %v; %v\n", relativePath(path), err)
+	}
+}
+
+// Tells clients what this server supports so they can avoid calls
+// that would fail on it
+func (s FuseServer) Hello(ctx context.Context, _ *emptypb.Empty) (*proto.ServerInfo, error) {
+	features := lib.FEATURES
+	if versionsEnabled() {
+		features = append(slices.Clone(features), lib.FEATURE_VERSIONS)
+	}
+	info := &proto.ServerInfo{
+		Version:  lib.VERSION,
+		Features: features,
+	}
+	// Hello needs a login like every call outside
+	// auth.nonProtectedMethods, so the caller's organization is known
+	if user, err := currentUser(ctx); err == nil {
+		info.TempPatterns = string(tempPatterns(user.OrgName))
+	}
+	return info, nil
+}
+
 func (s FuseServer) Auth(ctx context.Context, req *proto.AuthRequest) (*proto.AuthResponse, error) {
 	log.Printf("[GRPC] Auth %v\n", req.Email)
 
@@ -73,10 +150,18 @@ func (s FuseServer) Auth(ctx context.Context, req *proto.AuthRequest) (*proto.Au
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	passwordMatch := auth.VerifyPassword(user.Password, req.Password)
+	passwordMatch, outdated := auth.VerifyPassword(user.Password, req.Password)
 	if !passwordMatch {
 		return nil, status.Error(codes.InvalidArgument, "Invalid username or password")
 	}
+	if outdated {
+		go rehashPassword(user.Email, req.Password)
+	}
+
+	err = provisionPersonalDir(user)
+	if err != nil {
+		log.Printf("[GRPC] Error creating personal directory of %v; %v\n", user.Email, err)
+	}
 
 	accessToken, err := auth.GenerateToken(*user)
 	if err != nil {
@@ -87,49 +172,138 @@ func (s FuseServer) Auth(ctx context.Context, req *proto.AuthRequest) (*proto.Au
 	}, nil
 }
 
+func (s FuseServer) GetProfile(ctx context.Context, _ *emptypb.Empty) (*proto.Profile, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, p, err := loadProfile(user)
+	if err != nil {
+		return nil, profileStatusError(err)
+	}
+	return p.proto(), nil
+}
+
+func (s FuseServer) UpdateProfile(ctx context.Context, req *proto.UpdateProfileRequest) (*proto.ProfileResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[GRPC] UpdateProfile %v\n", user.Email)
+
+	current, _, err := loadProfile(user)
+	if err == nil && req.Username != "" {
+		err = changeUsername(current, req.Username)
+	}
+	if err == nil && req.Email != "" {
+		err = requestEmailChange(current, req.Email)
+	}
+	if err != nil {
+		return nil, profileStatusError(err)
+	}
+	return profileReply(current)
+}
+
+func (s FuseServer) ConfirmEmail(ctx context.Context, req *proto.ConfirmEmailRequest) (*proto.ProfileResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[GRPC] ConfirmEmail %v\n", user.Email)
+
+	current, _, err := loadProfile(user)
+	if err == nil {
+		err = confirmEmailChange(current, req.Otp)
+	}
+	if err != nil {
+		return nil, profileStatusError(err)
+	}
+	return profileReply(current)
+}
+
+// Returns the profile of user and a token carrying it
+func profileReply(user *db.User) (*proto.ProfileResponse, error) {
+	_, p, err := loadProfile(user)
+	if err != nil {
+		return nil, profileStatusError(err)
+	}
+	accessToken, err := auth.GenerateToken(*user)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error generating json web token")
+	}
+	return &proto.ProfileResponse{
+		Profile: p.proto(),
+		Token:   accessToken,
+	}, nil
+}
+
 func (s FuseServer) DownloadFile(req *proto.DownloadRequest, stream grpc.ServerStreamingServer[proto.FileChunk]) error {
 	// log.Printf("[GRPC] DownloadFile \"%v\"\n", req.Path)
 
 	ctx := stream.Context()
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return grpcError(err)
+		return lib.StatusError(err)
+	}
+
+	release, err := acquireGrpcHandle(ctx)
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
-	file, err := os.Open(fullpath)
+	file, err := openInDir(ctx, filepath.Join(s.path, usersDir), fullpath, os.O_RDONLY, 0)
 	if err != nil {
-		return grpcError(err)
+		return lib.StatusError(err)
 	}
 	defer file.Close()
 
 	// Hash local file and compare with received hash
-	hash := md5.New()
-	_, err = io.Copy(hash, file)
+	fileHash, err := indexedFileHash(file, fullpath)
 	if err != nil {
-		return grpcError(err)
+		return lib.StatusError(err)
 	}
-	digest := hash.Sum(nil)
-	fileHash := hex.EncodeToString(digest)
 	if fileHash == req.ExpectedHash {
 		// File hashes match; no need to send the file over network
 		return nil
 	}
 
-	// Reset file's read pointer to start of file to prepare for second
-	// read
-	_, err = file.Seek(0, io.SeekStart)
+	info, err := file.Stat()
 	if err != nil {
-		return grpcError(err)
+		return lib.StatusError(err)
 	}
 
-	info, err := file.Stat()
+	// Resume an interrupted download only if the file has not
+	// changed since the client received its first bytes
+	var offset int64 = 0
+	if req.ResumeOffset > 0 && req.ResumeHash == fileHash && req.ResumeOffset < info.Size() {
+		offset = req.ResumeOffset
+	}
+
+	// Reset file's read pointer to prepare for second read
+	_, err = file.Seek(offset, io.SeekStart)
 	if err != nil {
-		return grpcError(err)
+		return lib.StatusError(err)
 	}
 
-	buff := make([]byte, 64*1024) // 64Kb
-	sentBytes := 0
+	// Send serializes the chunk before it returns so the buffer can
+	// be reused right after. Not so when sendChunk gives up on a Send
+	// that is still running
+	buf := lib.GetChunk()
+	reusable := true
+	defer func() {
+		if reusable {
+			lib.PutChunk(buf)
+		}
+	}()
+	buff := *buf
+	sentBytes := int(offset)
+
+	var deadline time.Time
+	if downloadTimeout > 0 {
+		deadline = time.Now().Add(downloadTimeout)
+	}
 
 outer:
 	for {
@@ -139,22 +313,33 @@ outer:
 			break outer
 
 		default:
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				log.Printf("[GRPC] Aborting download of %v; took longer than %v\n", req.Path, downloadTimeout)
+				return status.Errorf(codes.DeadlineExceeded, "download took longer than %v", downloadTimeout)
+			}
+
 			n, err := file.Read(buff)
 			if err != nil {
 				if err == io.EOF {
 					return nil
 				}
-				return grpcError(err)
+				return lib.StatusError(err)
 			}
 
 			chunk := proto.FileChunk{
 				Data:      buff[:n],
 				Offset:    int64(sentBytes),
 				TotalSize: info.Size(),
+				Hash:      fileHash,
+			}
+			err = sendChunk(stream, &chunk, deadline)
+			if status.Code(err) == codes.DeadlineExceeded {
+				reusable = false
+				log.Printf("[GRPC] Aborting download of %v; %v\n", req.Path, err)
+				return err
 			}
-			err = stream.Send(&chunk)
 			if err != nil {
-				return grpcError(err)
+				return lib.StatusError(err)
 			}
 
 			sentBytes += n
@@ -164,20 +349,56 @@ outer:
 	return nil
 }
 
+// Sends chunk unless the client takes longer than -download-stall to
+// receive it or the download runs past deadline. A client that stops
+// reading blocks Send on flow control for good; giving up ends the
+// stream, which unblocks the Send left behind
+func sendChunk(stream grpc.ServerStreamingServer[proto.FileChunk], chunk *proto.FileChunk, deadline time.Time) error {
+	timeout := downloadStall
+	if !deadline.IsZero() && (timeout <= 0 || time.Until(deadline) < timeout) {
+		timeout = max(time.Until(deadline), 0)
+	}
+	if timeout <= 0 && deadline.IsZero() {
+		return stream.Send(chunk)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Send(chunk)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return status.Errorf(codes.DeadlineExceeded, "client stopped receiving for %v", timeout)
+	}
+}
+
 func (s FuseServer) ObserveFileChanges(_ *emptypb.Empty, stream grpc.ServerStreamingServer[proto.FileEvent]) error {
 	ctx := stream.Context()
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return grpcError(err)
+		return lib.StatusError(err)
+	}
+	user, err := currentUser(ctx)
+	if err != nil {
+		return lib.StatusError(err)
 	}
 
 	log.Printf("[GRPC] Client observing MAIN_OBSERVER@%v\n", usersDir)
-	clientChan := make(chan *proto.FileEvent, 10)
 
 	// Add user as an observer
-	mu.Lock()
-	observers[usersDir] = append(observers[usersDir], clientChan)
-	mu.Unlock()
+	obs := newObserver(user, usersDir)
+	err = addObserver(usersDir, obs)
+	if err != nil {
+		log.Printf("[GRPC] Rejected observer@%v; %v\n", usersDir, err)
+		return err
+	}
+	defer removeObserver(usersDir, obs)
 
 	for {
 		select {
@@ -186,31 +407,68 @@ func (s FuseServer) ObserveFileChanges(_ *emptypb.Empty, stream grpc.ServerStrea
 			log.Printf("[GRPC] Client stopped observing MAIN_OBSERVER@%v; %v\n", usersDir, ctx.Err())
 			return nil
 
-		case fileEvent := <-clientChan:
+		case <-obs.done:
+			log.Printf("[GRPC] Closing observer@%v; %v\n", usersDir, obs.closeErr)
+			return obs.closeErr
+
+		case fileEvent := <-obs.events:
+			path, ok := lib.WireRel(usersDir, fileEvent.Path)
+			if !ok || hiddenPath(ctx, user, path) {
+				continue
+			}
+			newPath, _ := lib.WireRel(usersDir, fileEvent.NewPath)
 			log.Printf("[GRPC] Sending file event %s to client\n", fileEvent)
 
 			// Trim usersDir from response; our clients do NOT care
-			// how the directories are structured on the backend
-			trimmedPath := strings.TrimPrefix(fileEvent.Path, usersDir)
-			fileEvent.Path = trimmedPath
-
-			trimmedPath = strings.TrimPrefix(fileEvent.NewPath, usersDir)
-			fileEvent.NewPath = trimmedPath
+			// how the directories are structured on the backend.
+			// The event is shared by all observers so send a copy
+			response := &proto.FileEvent{
+				Event:     fileEvent.Event,
+				Path:      path,
+				NewPath:   newPath,
+				Mode:      fileEvent.Mode,
+				Timestamp: fileEvent.Timestamp,
+			}
 
-			err := stream.Send(fileEvent)
+			err := stream.Send(response)
 			if err != nil {
-				return grpcError(err)
+				return lib.StatusError(err)
 			}
 		}
 	}
 }
 
+func (s FuseServer) GetManifest(req *proto.ManifestRequest, stream grpc.ServerStreamingServer[proto.ManifestEntry]) error {
+	ctx := stream.Context()
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+
+	fullpath := filepath.Join(s.path, usersDir, req.Path)
+	log.Printf("[GRPC] GetManifest \"%v\"\n", relativePath(fullpath))
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	hidden := func(path string) bool {
+		return hiddenPath(ctx, user, path)
+	}
+
+	err = sendManifest(stream, fullpath, lib.ToWire(req.Path), req.Recursive, hidden)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	return nil
+}
+
 // FUSE functions
 
 func (s FuseServer) Attr(ctx context.Context, req *proto.DirEntry) (*proto.FileAttr, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
@@ -219,7 +477,7 @@ func (s FuseServer) Attr(ctx context.Context, req *proto.DirEntry) (*proto.FileA
 	stat := syscall.Stat_t{}
 	err = syscall.Lstat(fullpath, &stat)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	return lib.StatToFileAttr(&stat), nil
 }
@@ -227,39 +485,59 @@ func (s FuseServer) Attr(ctx context.Context, req *proto.DirEntry) (*proto.FileA
 func (s FuseServer) Lookup(ctx context.Context, req *proto.LookupRequest) (*proto.DirEntry, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
 	log.Printf("[GRPC] Lookup \"%v\"\n", relativePath(fullpath))
 
+	// A symlink is reported as itself so the client can make one
+	// like it, rather than as whatever it points to
 	stat := syscall.Stat_t{}
-	err = syscall.Stat(fullpath, &stat)
+	err = syscall.Lstat(fullpath, &stat)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
-	return &proto.DirEntry{
+	attr := lib.StatToFileAttr(&stat)
+	attr.Mime = detectMime(filepath.Join(realpath, usersDir, req.Path), &stat)
+	entry := &proto.DirEntry{
 		Path: req.Path,
-		Attr: lib.StatToFileAttr(&stat),
-	}, nil
+		Attr: attr,
+		Mime: attr.Mime,
+	}
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+		entry.Target, err = os.Readlink(fullpath)
+		if err != nil {
+			return nil, lib.StatusError(err)
+		}
+	}
+	return entry, nil
 }
 
 func (s FuseServer) ReadDirAll(ctx context.Context, req *proto.DirEntry) (*proto.ReadDirAllResponse, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
 	// log.Printf("[GRPC] ReadDirAll \"%v\"\n", relativePath(fullpath))
 
 	files, err := os.ReadDir(fullpath)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
 	}
 
 	entries := []*proto.DirEntry{}
 	for _, file := range files {
-		filePath := filepath.Join(req.Path, file.Name())
+		filePath := path.Join(lib.ToWire(req.Path), file.Name())
+		if hiddenPath(ctx, user, filePath) {
+			continue
+		}
 
 		info, err := file.Info()
 		if err != nil {
@@ -267,11 +545,16 @@ func (s FuseServer) ReadDirAll(ctx context.Context, req *proto.DirEntry) (*proto
 		}
 
 		attr := lib.FileInfoToFileAttr(info)
-		entries = append(entries, &proto.DirEntry{
+		entry := &proto.DirEntry{
 			Ino:  attr.Ino,
 			Path: filePath,
 			Mode: uint32(info.Mode()),
-		})
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok {
+			entry.Mime = detectMime(filepath.Join(realpath, usersDir, lib.FromWire(filePath)), stat)
+		}
+		entries = append(entries, entry)
 	}
 	return &proto.ReadDirAllResponse{
 		Entries: entries,
@@ -281,14 +564,23 @@ func (s FuseServer) ReadDirAll(ctx context.Context, req *proto.DirEntry) (*proto
 func (s FuseServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.DirEntry, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	req.Path, err = resolveName(ctx, usersDir, req.Path)
+	if err != nil {
+		return nil, err
 	}
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
+	quota, err := checkInodeQuota(ctx, fullpath)
+	if err != nil {
+		return nil, err
+	}
+	defer quota.release()
 	log.Printf("[GRPC] Mkdir \"%v\"\n", relativePath(fullpath))
 
-	err = os.Mkdir(fullpath, os.FileMode(req.Mode))
+	err = lib.Mkdir(fullpath, req.Mode)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	// Confirm directory was created
@@ -296,8 +588,10 @@ func (s FuseServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.
 	err = syscall.Lstat(fullpath, &stat)
 	if err != nil {
 		os.Remove(fullpath)
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
+	quota.keep()
+	setOwner(ctx, fullpath)
 
 	return &proto.DirEntry{
 		Path: req.Path,
@@ -308,14 +602,46 @@ func (s FuseServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.
 func (s FuseServer) Rmdir(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
 	log.Printf("[GRPC] Rmdir \"%v\"\n", relativePath(fullpath))
 
-	err = os.Remove(fullpath)
+	info, err := os.Lstat(fullpath)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	if !info.IsDir() {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is not a directory", req.Path)
+	}
+
+	// Fails with ENOTEMPTY unless the directory is empty
+	err = syscall.Rmdir(fullpath)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s FuseServer) Unlink(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	fullpath := filepath.Join(s.path, usersDir, req.Path)
+	log.Printf("[GRPC] Unlink \"%v\"\n", relativePath(fullpath))
+
+	info, err := os.Lstat(fullpath)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	if info.IsDir() {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is a directory", req.Path)
+	}
+
+	err = syscall.Unlink(fullpath)
+	if err != nil {
+		return nil, lib.StatusError(err)
 	}
 	return &emptypb.Empty{}, nil
 }
@@ -323,7 +649,7 @@ func (s FuseServer) Rmdir(ctx context.Context, req *proto.DirEntry) (*emptypb.Em
 func (s FuseServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.FileAttr, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
 	log.Printf("[GRPC] Getattr \"%v\"\n", relativePath(fullpath))
@@ -331,7 +657,105 @@ func (s FuseServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.Fi
 	stat := syscall.Stat_t{}
 	err = syscall.Lstat(fullpath, &stat)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	attr := lib.StatToFileAttr(&stat)
+	attr.Mime = detectMime(filepath.Join(realpath, usersDir, req.Path), &stat)
+	return attr, nil
+}
+
+// Changes the attributes of a file. Works on realpath directly so
+// observers get a single MODIFY event
+// Immutable and append-only files stay that way for everyone sharing
+// the directory, so only the file's owner and the organization's admin
+// may change those flags. Requests that leave them as they are pass
+func checkFlagsAllowed(ctx context.Context, fullpath string, flags uint32) error {
+	current, err := lib.GetPathFlags(fullpath)
+	if err != nil {
+		return err
+	}
+	if (current^flags)&lib.SYNCED_FILE_FLAGS == 0 {
+		return nil
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return err
+	}
+	if lib.GetOwner(fullpath) == user.Email {
+		return nil
+	}
+	org, err := organizations.Get(user.OrgName)
+	if err == nil && org.AdminEmail == user.Email {
+		return nil
+	}
+	return syscall.EPERM
+}
+
+func (s FuseServer) Setattr(ctx context.Context, req *proto.SetattrRequest) (*proto.FileAttr, error) {
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	fullpath := filepath.Join(realpath, usersDir, req.Path)
+	log.Printf("[GRPC] Setattr \"%v\"\n", req.Path)
+
+	// None of the changes follow a symlink; they apply to the link
+	// itself or fail
+	modified := false
+	if req.Size != nil {
+		unlock := lockPath(filepath.Join(usersDir, req.Path))
+		defer unlock()
+		snapshotVersion(filepath.Join(usersDir, req.Path), false)
+		err = lib.Ltruncate(fullpath, int64(*req.Size))
+		if err != nil {
+			return nil, lib.StatusError(err)
+		}
+		modified = true
+	}
+	if req.Mode != nil {
+		err = lib.Lchmod(fullpath, *req.Mode&CLIENT_MODE_MASK)
+		if err != nil {
+			return nil, lib.StatusError(err)
+		}
+		modified = true
+	}
+	if req.ATime != nil || req.MTime != nil {
+		var atime, mtime *time.Time
+		if req.ATime != nil {
+			t := req.ATime.AsTime()
+			atime = &t
+		}
+		if req.MTime != nil {
+			t := req.MTime.AsTime()
+			mtime = &t
+		}
+		err = lib.LsetTimes(fullpath, atime, mtime)
+		if err != nil {
+			return nil, lib.StatusError(err)
+		}
+		modified = true
+	}
+	if req.Flags != nil {
+		err = checkFlagsAllowed(ctx, fullpath, *req.Flags)
+		if err != nil {
+			return nil, lib.StatusError(err)
+		}
+		// Last, since an immutable file refuses every other change
+		err = lib.SetPathFlags(fullpath, *req.Flags)
+		if err != nil {
+			return nil, lib.StatusError(err)
+		}
+		modified = true
+	}
+
+	stat := syscall.Stat_t{}
+	err = syscall.Lstat(fullpath, &stat)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	if modified {
+		go notifyObservers(events.MODIFY_FILE, fullpath, "", os.FileMode(stat.Mode))
 	}
 	return lib.StatToFileAttr(&stat), nil
 }
@@ -339,20 +763,38 @@ func (s FuseServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.Fi
 func (s FuseServer) Create(ctx context.Context, req *proto.CreateRequest) (*proto.CreateResponse, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	req.Path, err = resolveName(ctx, usersDir, req.Path)
+	if err != nil {
+		return nil, err
 	}
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
+	quota, err := checkInodeQuota(ctx, fullpath)
+	if err != nil {
+		return nil, err
+	}
+	defer quota.release()
 	log.Printf("[GRPC] Create \"%v\"\n", relativePath(fullpath))
 
-	file, err := os.OpenFile(fullpath, int(req.Flags), os.FileMode(req.Mode))
+	release, err := acquireGrpcHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	file, err := openInDir(ctx, filepath.Join(s.path, usersDir), fullpath, int(req.Flags), req.Mode&0777)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	defer file.Close()
+	quota.keep()
+	setOwner(ctx, fullpath)
+	rememberCreate(ctx, fullpath)
 
 	info, err := file.Stat()
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	attr := lib.FileInfoToFileAttr(info)
 	return &proto.CreateResponse{
@@ -364,23 +806,46 @@ func (s FuseServer) Create(ctx context.Context, req *proto.CreateRequest) (*prot
 func (s FuseServer) Symlink(ctx context.Context, req *proto.LinkRequest) (*proto.LinkResponse, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	req.NewPath, err = resolveName(ctx, usersDir, req.NewPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// OldPath is the link's target, stored exactly as given
+	err = checkSymlinkTarget(filepath.Join(s.path, usersDir), req.NewPath, req.OldPath)
+	if err != nil {
+		return nil, err
 	}
 
-	oldpath := filepath.Join(s.path, usersDir, req.OldPath)
 	newpath := filepath.Join(s.path, usersDir, req.NewPath)
-	log.Printf("[GRPC] Symlink %v -> %v\n", relativePath(oldpath), relativePath(newpath))
+	log.Printf("[GRPC] Symlink %v -> %v\n", relativePath(newpath), req.OldPath)
 
-	err = syscall.Symlink(oldpath, newpath)
+	err = syscall.Symlink(req.OldPath, newpath)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	// The target may lead back to the link itself, out of the
+	// directory or into another user's personal directory through
+	// other symlinks
+	err = checkSymlinks(filepath.Join(realpath, usersDir), req.NewPath, true, func(path string) bool {
+		return hiddenPath(ctx, user, path)
+	})
 	if err != nil {
-		return nil, grpcError(err)
+		syscall.Unlink(newpath)
+		return nil, lib.StatusError(err)
 	}
 
 	// Stat new path
 	stat := syscall.Stat_t{}
 	err = syscall.Lstat(newpath, &stat)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	return &proto.LinkResponse{
@@ -394,7 +859,11 @@ func (s FuseServer) Symlink(ctx context.Context, req *proto.LinkRequest) (*proto
 func (s FuseServer) Link(ctx context.Context, req *proto.LinkRequest) (*proto.LinkResponse, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	req.NewPath, err = resolveName(ctx, usersDir, req.NewPath)
+	if err != nil {
+		return nil, err
 	}
 
 	oldpath := filepath.Join(s.path, usersDir, req.OldPath)
@@ -403,14 +872,14 @@ func (s FuseServer) Link(ctx context.Context, req *proto.LinkRequest) (*proto.Li
 
 	err = syscall.Link(oldpath, newpath)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	// Stat new path
 	stat := syscall.Stat_t{}
 	err = syscall.Stat(newpath, &stat)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	return &proto.LinkResponse{
@@ -424,15 +893,35 @@ func (s FuseServer) Link(ctx context.Context, req *proto.LinkRequest) (*proto.Li
 func (s FuseServer) ReadAll(ctx context.Context, req *proto.DirEntry) (*proto.ReadAllResponse, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
 	log.Printf("[GRPC] ReadAll %v\n", relativePath(fullpath))
 
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	// Leave some room for the rest of the message. Anything bigger
+	// would fail to send with an error that doesn't say why
+	if info.Size() > int64(maxMsgSize)*1024*1024-1024 {
+		return nil, status.Errorf(
+			codes.ResourceExhausted,
+			"%v is %v bytes, too large for ReadAll; use DownloadFile instead",
+			req.Path, info.Size(),
+		)
+	}
+
+	release, err := acquireGrpcHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	data, err := os.ReadFile(fullpath)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	return &proto.ReadAllResponse{Data: data}, nil
 }
@@ -440,32 +929,272 @@ func (s FuseServer) ReadAll(ctx context.Context, req *proto.DirEntry) (*proto.Re
 func (s FuseServer) Write(ctx context.Context, req *proto.WriteRequest) (*proto.WriteResponse, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 
 	fullpath := filepath.Join(s.path, usersDir, req.Path)
 	log.Printf("[GRPC] Write %v bytes of data to file %v\n", len(req.Data), req.Path)
 
-	file, err := os.OpenFile(fullpath, os.O_WRONLY, 0755)
+	err = checkWrite(req)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := acquireGrpcHandle(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, err
+	}
+	defer release()
+
+	if req.Replace {
+		return s.replace(ctx, usersDir, req)
+	}
+	unlock := lockPath(filepath.Join(usersDir, req.Path))
+	defer unlock()
+	snapshotVersion(filepath.Join(usersDir, req.Path), false)
+	if req.Append {
+		return appendFile(ctx, filepath.Join(s.path, usersDir), fullpath, req.Path, req.Data)
+	}
+
+	file, err := openInDir(ctx, filepath.Join(s.path, usersDir), fullpath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, writeOpenError(ctx, fullpath, req.Path, err)
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
 	n, err := file.WriteAt(req.Data, req.Offset)
 	if err != nil {
-		return nil, grpcError(err)
+		dropTornWrite(file, info.Size(), err)
+		return nil, lib.StatusError(err)
+	}
+	localStatfs.AddUsage(int64(n))
+
+	return &proto.WriteResponse{
+		BytesWritten: uint64(n),
+		Offset:       req.Offset,
+	}, nil
+}
+
+// Rejects writes carrying more than -max-write-size of data or that
+// would grow a file past -max-file-size, eg. with an absurd offset
+// creating a huge sparse file
+func checkWrite(req *proto.WriteRequest) error {
+	if req.Offset < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative write offset %v", req.Offset)
+	}
+	if int64(len(req.Data)) > int64(maxWriteSize)*1024*1024 {
+		return status.Errorf(codes.ResourceExhausted, "write of %v bytes is over the %vMB limit", len(req.Data), maxWriteSize)
+	}
+	limit := maxFileSize * 1024 * 1024 * 1024
+	if maxFileSize > 0 && req.Offset > limit-int64(len(req.Data)) {
+		return status.Errorf(codes.ResourceExhausted, "write would grow file past the %vGB limit", maxFileSize)
+	}
+	return nil
+}
+
+// Writes data to the end of a file. O_APPEND makes the kernel pick
+// the offset so appends from different clients never overwrite each
+// other
+func appendFile(ctx context.Context, dir, fullpath, path string, data []byte) (*proto.WriteResponse, error) {
+	file, err := openInDir(ctx, dir, fullpath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, writeOpenError(ctx, fullpath, path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	n, err := file.Write(data)
+	if err != nil {
+		dropTornWrite(file, info.Size(), err)
+		return nil, lib.StatusError(err)
+	}
+	localStatfs.AddUsage(int64(n))
+
+	// Our file offset now points right after the data we wrote
+	end, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, lib.StatusError(err)
 	}
 
 	return &proto.WriteResponse{
 		BytesWritten: uint64(n),
+		Offset:       end - int64(n),
+	}, nil
+}
+
+// A write that runs out of space part way leaves the file grown by
+// the bytes that fit. Cuts it back to size so that the client, which
+// is told the whole write failed, doesn't disagree with remote about
+// the end of the file
+func dropTornWrite(file *os.File, size int64, err error) {
+	if !errors.Is(err, syscall.ENOSPC) && !errors.Is(err, syscall.EDQUOT) {
+		return
+	}
+	info, statErr := file.Stat()
+	if statErr != nil || info.Size() <= size {
+		return
+	}
+	truncErr := file.Truncate(size)
+	if truncErr != nil {
+		log.Printf("[GRPC] Error dropping torn write to %v; %v\n", file.Name(), truncErr)
+	}
+}
+
+// Swaps in the new contents of a file in one step. Works on realpath
+// directly; the FUSE layer would otherwise broadcast the temp file
+// and the rename as separate events
+func (s FuseServer) replace(ctx context.Context, usersDir string, req *proto.WriteRequest) (*proto.WriteResponse, error) {
+	path, err := resolveName(ctx, usersDir, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	fullpath := filepath.Join(realpath, usersDir, path)
+	unlock := lockPath(filepath.Join(usersDir, path))
+	defer unlock()
+
+	_, err = os.Stat(fullpath)
+	created := os.IsNotExist(err)
+	var quota *inodeReservation
+	if created {
+		quota, err = checkInodeQuota(ctx, fullpath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer quota.release()
+	snapshotVersion(filepath.Join(usersDir, path), false)
+
+	err = replaceFile(fullpath, req.Data)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	quota.keep()
+	localStatfs.AddUsage(int64(len(req.Data)))
+	if created {
+		setOwner(ctx, fullpath)
+	}
+
+	stat := syscall.Stat_t{}
+	err = syscall.Lstat(fullpath, &stat)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+
+	go func() {
+		if created {
+			notifyObservers(events.ADD_FILE, fullpath, "", os.FileMode(stat.Mode))
+		}
+		notifyObservers(events.MODIFY_FILE, fullpath, "", os.FileMode(stat.Mode))
+	}()
+
+	return &proto.WriteResponse{
+		BytesWritten: uint64(len(req.Data)),
+	}, nil
+}
+
+// Creates or replaces a small file, contents and mode, in one call
+// so that clients don't need a Create and Write per tiny file. Works
+// on realpath directly like replace
+func (s FuseServer) PutFile(ctx context.Context, req *proto.PutFileRequest) (*proto.PutFileResponse, error) {
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	log.Printf("[GRPC] PutFile %v bytes to file %v\n", len(req.Data), req.Path)
+
+	if len(req.Data) > lib.MAX_PUT_SIZE {
+		return nil, status.Errorf(codes.InvalidArgument, "put of %v bytes is over the %v byte limit", len(req.Data), lib.MAX_PUT_SIZE)
+	}
+	path, err := resolveName(ctx, usersDir, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := acquireGrpcHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fullpath := filepath.Join(realpath, usersDir, path)
+	unlock := lockPath(filepath.Join(usersDir, path))
+	defer unlock()
+
+	info, err := os.Lstat(fullpath)
+	created := os.IsNotExist(err)
+	var quota *inodeReservation
+	if created {
+		quota, err = checkInodeQuota(ctx, fullpath)
+		if err != nil {
+			return nil, err
+		}
+	} else if err == nil && !info.Mode().IsRegular() {
+		return nil, lib.StatusError(syscall.EISDIR)
+	}
+	defer quota.release()
+	snapshotVersion(filepath.Join(usersDir, path), false)
+
+	err = replaceFile(fullpath, req.Data)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	quota.keep()
+	localStatfs.AddUsage(int64(len(req.Data)))
+	if created {
+		setOwner(ctx, fullpath)
+	}
+	err = lib.Lchmod(fullpath, req.Mode&CLIENT_MODE_MASK)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+
+	file, err := openInDir(ctx, filepath.Join(realpath, usersDir), fullpath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	defer file.Close()
+
+	hash, err := indexedFileHash(file, fullpath)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	stat := syscall.Stat_t{}
+	err = syscall.Fstat(int(file.Fd()), &stat)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+
+	go func() {
+		if created {
+			notifyObservers(events.ADD_FILE, fullpath, "", os.FileMode(stat.Mode))
+		}
+		notifyObservers(events.MODIFY_FILE, fullpath, "", os.FileMode(stat.Mode))
+	}()
+
+	return &proto.PutFileResponse{
+		Attr: lib.StatToFileAttr(&stat),
+		Hash: hash,
 	}, nil
 }
 
 func (s FuseServer) Rename(ctx context.Context, req *proto.RenameRequest) (*emptypb.Empty, error) {
 	usersDir, err := getUsersDir(ctx)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
+	}
+	// Changing only the case of a name collides with itself
+	if !strings.EqualFold(req.OldPath, req.NewPath) {
+		req.NewPath, err = resolveName(ctx, usersDir, req.NewPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	oldpath := filepath.Join(s.path, usersDir, req.OldPath)
@@ -478,45 +1207,135 @@ func (s FuseServer) Rename(ctx context.Context, req *proto.RenameRequest) (*empt
 		err := os.MkdirAll(newParentDir, 0755)
 		if err != nil {
 			log.Printf("[GRPC] Failed to create target directory: %v\n", err)
-			return nil, grpcError(err)
+			return nil, lib.StatusError(err)
 		}
 	}
 
 	err = syscall.Rename(oldpath, newpath)
 	if err != nil {
-		return nil, grpcError(err)
+		return nil, lib.StatusError(err)
 	}
 	return &emptypb.Empty{}, nil
 }
 
-// Parse normal error into GRPC error code
-func grpcError(err error) error {
-	switch {
-	case os.IsNotExist(err):
-		return status.Error(codes.NotFound, err.Error())
+// Copies a file on the server so clients duplicating a file don't
+// have to upload it again. Works on realpath directly so that
+// reflinks are possible
+func (s FuseServer) Copy(ctx context.Context, req *proto.CopyRequest) (*proto.DirEntry, error) {
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	req.DstPath, err = resolveName(ctx, usersDir, req.DstPath)
+	if err != nil {
+		return nil, err
+	}
+	src := filepath.Join(realpath, usersDir, req.SrcPath)
+	dst := filepath.Join(realpath, usersDir, req.DstPath)
+	quota, err := checkInodeQuota(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	defer quota.release()
+	log.Printf("[GRPC] Copy %v -> %v\n", relativePath(src), relativePath(dst))
+
+	err = copyFile(src, dst)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	quota.keep()
+	setOwner(ctx, dst)
 
-	case os.IsPermission(err):
-		return status.Error(codes.PermissionDenied, err.Error())
+	stat := syscall.Stat_t{}
+	err = syscall.Lstat(dst, &stat)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+
+	// The FUSE layer never saw this file being created. Follow up
+	// with MODIFY so other clients fetch its contents
+	go func() {
+		notifyObservers(events.ADD_FILE, dst, "", os.FileMode(stat.Mode))
+		notifyObservers(events.MODIFY_FILE, dst, "", os.FileMode(stat.Mode))
+	}()
+
+	return &proto.DirEntry{
+		Path: req.DstPath,
+		Mode: stat.Mode,
+		Attr: lib.StatToFileAttr(&stat),
+	}, nil
+}
+
+func (s FuseServer) Statfs(ctx context.Context, _ *emptypb.Empty) (*proto.StatfsResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
 
-	case os.IsTimeout(err):
-		return status.Error(codes.DeadlineExceeded, err.Error())
+	files := countInodes(user.OrgName)
+	response := &proto.StatfsResponse{
+		Files: files,
+	}
+	if inodeQuota > 0 {
+		response.Quota = uint64(inodeQuota)
+		if files < response.Quota {
+			response.Ffree = response.Quota - files
+		}
+	}
+	return response, nil
+}
 
-	case os.IsExist(err):
-		return status.Error(codes.AlreadyExists, err.Error())
+// Lists the earlier versions kept of a file, newest first
+func (s FuseServer) ListVersions(ctx context.Context, req *proto.DirEntry) (*proto.VersionList, error) {
+	if !versionsEnabled() {
+		return nil, status.Error(codes.FailedPrecondition, "versioning is disabled on this server")
+	}
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	log.Printf("[GRPC] ListVersions \"%v\"\n", req.Path)
 
-	case err == syscall.EIO:
-		// I/O error, often indicates a physical disk failure
-		return status.Errorf(codes.Internal, "I/O error: %v", err)
+	versions, err := listVersions(filepath.Join(usersDir, req.Path))
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	response := &proto.VersionList{}
+	for _, version := range versions {
+		response.Versions = append(response.Versions, &proto.FileVersion{
+			Id:      version.id,
+			Size:    uint64(version.size),
+			Created: timestamppb.New(version.created),
+		})
+	}
+	return response, nil
+}
 
-	case err == syscall.ENOSPC:
-		// No space left on device
-		return status.Errorf(codes.ResourceExhausted, "no space left on device: %v", err)
+// Puts an earlier version of a file back in place
+func (s FuseServer) RestoreVersion(ctx context.Context, req *proto.RestoreVersionRequest) (*proto.FileAttr, error) {
+	if !versionsEnabled() {
+		return nil, status.Error(codes.FailedPrecondition, "versioning is disabled on this server")
+	}
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return nil, lib.StatusError(err)
+	}
+	log.Printf("[GRPC] RestoreVersion %v of \"%v\"\n", req.Id, req.Path)
 
-	case err == syscall.EINVAL:
-		// Invalid argument to a syscall
-		return status.Errorf(codes.InvalidArgument, "invalid system call argument: %v", err)
+	unlock := lockPath(filepath.Join(usersDir, req.Path))
+	err = restoreVersion(filepath.Join(usersDir, req.Path), req.Id)
+	unlock()
+	if err != nil {
+		if errors.Is(err, errInvalidVersion) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, lib.StatusError(err)
+	}
 
-	default:
-		return status.Error(codes.Internal, err.Error())
+	stat := syscall.Stat_t{}
+	err = syscall.Lstat(filepath.Join(realpath, usersDir, req.Path), &stat)
+	if err != nil {
+		return nil, lib.StatusError(err)
 	}
+	return lib.StatToFileAttr(&stat), nil
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Most files a single client may hold open on the server at once. GRPC
+// clients are told apart by email and hold a descriptor for as long as
+// a request that reads or writes a file runs; local users of the mount
+// are told apart by uid and hold one per open FileHandle. 0 means
+// unlimited
+var maxOpenHandles int
+
+var (
+	// Open handles per client
+	openHandles   = make(map[string]int)
+	openHandlesMu = sync.Mutex{}
+)
+
+var errTooManyHandles = status.Error(codes.ResourceExhausted, "Too many open files")
+
+// Reserves a handle for client. The returned function releases it and
+// must be called exactly once
+func acquireHandle(client string) (func(), error) {
+	openHandlesMu.Lock()
+	defer openHandlesMu.Unlock()
+
+	if maxOpenHandles > 0 && openHandles[client] >= maxOpenHandles {
+		return nil, errTooManyHandles
+	}
+	openHandles[client]++
+
+	released := false
+	return func() {
+		openHandlesMu.Lock()
+		defer openHandlesMu.Unlock()
+
+		if released {
+			return
+		}
+		released = true
+		openHandles[client]--
+		if openHandles[client] <= 0 {
+			delete(openHandles, client)
+		}
+	}, nil
+}
+
+// Reserves a handle for the user making a GRPC request
+func acquireGrpcHandle(ctx context.Context) (func(), error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return acquireHandle(user.Email)
+}
+
+// Reserves a handle for the local process making a FUSE request
+func acquireFuseHandle(ctx context.Context) (func(), syscall.Errno) {
+	client := "uid:0"
+	caller, ok := fuse.FromContext(ctx)
+	if ok {
+		client = fmt.Sprintf("uid:%v", caller.Uid)
+	}
+	release, err := acquireHandle(client)
+	if err != nil {
+		return nil, syscall.EMFILE
+	}
+	return release, 0
+}
+
+// Number of handles client holds open
+func openHandleCount(client string) int {
+	openHandlesMu.Lock()
+	defer openHandlesMu.Unlock()
+	return openHandles[client]
+}
+
+func openHandlesHandler(w http.ResponseWriter, r *http.Request) {
+	openHandlesMu.Lock()
+	total := 0
+	for _, count := range openHandles {
+		total += count
+	}
+	openHandlesMu.Unlock()
+
+	// Per client counts are listed with the sessions of each organization
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"open_handles":     total,
+		"max_open_handles": maxOpenHandles,
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sets -max-open-handles for the rest of the test
+func useMaxOpenHandles(t *testing.T, max int) {
+	old := maxOpenHandles
+	maxOpenHandles = max
+	t.Cleanup(func() { maxOpenHandles = old })
+}
+
+// A local user opening past the cap gets EMFILE while other users
+// still get in, and a released handle makes room again
+func TestFuseHandlesCapped(t *testing.T) {
+	useMaxOpenHandles(t, 3)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "existing"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := fs.NewNodeFS(&Node{path: dir}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	client := "uid:" + strconv.Itoa(os.Getuid())
+
+	created := []fuse.CreateOut{}
+	for i := range maxOpenHandles {
+		out := fuse.CreateOut{}
+		in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}
+		if code := raw.Create(nil, in, "file"+strconv.Itoa(i), &out); !code.Ok() {
+			t.Fatalf("Create %v = %v", i, code)
+		}
+		created = append(created, out)
+	}
+	if got := openHandleCount(client); got != maxOpenHandles {
+		t.Errorf("open handles = %v; want %v", got, maxOpenHandles)
+	}
+
+	in := &fuse.CreateIn{InHeader: header, Flags: uint32(os.O_CREATE | os.O_RDWR), Mode: 0644}
+	if code := raw.Create(nil, in, "one-too-many", &fuse.CreateOut{}); code != fuse.Status(syscall.EMFILE) {
+		t.Errorf("Create past the cap = %v; want EMFILE", code)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "one-too-many")); err == nil {
+		t.Error("file refused past the cap was created")
+	}
+	existing := fuse.EntryOut{}
+	if code := raw.Lookup(nil, &header, "existing", &existing); !code.Ok() {
+		t.Fatalf("Lookup = %v", code)
+	}
+	open := &fuse.OpenIn{InHeader: fuse.InHeader{NodeId: existing.NodeId, Caller: header.Caller}, Flags: uint32(os.O_RDONLY)}
+	if code := raw.Open(nil, open, &fuse.OpenOut{}); code != fuse.Status(syscall.EMFILE) {
+		t.Errorf("Open past the cap = %v; want EMFILE", code)
+	}
+
+	other := *open
+	other.Caller.Uid = uint32(os.Getuid()) + 1
+	opened := fuse.OpenOut{}
+	if code := raw.Open(nil, &other, &opened); !code.Ok() {
+		t.Errorf("Open by another user = %v; want it let through", code)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: existing.NodeId}, Fh: opened.Fh})
+
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: created[0].NodeId}, Fh: created[0].Fh})
+	opened = fuse.OpenOut{}
+	if code := raw.Open(nil, open, &opened); !code.Ok() {
+		t.Errorf("Open after a release = %v; want it let through", code)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: existing.NodeId}, Fh: opened.Fh})
+	for _, out := range created[1:] {
+		raw.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: out.NodeId}, Fh: out.Fh})
+	}
+	if got := openHandleCount(client); got != 0 {
+		t.Errorf("open handles after releasing all = %v; want 0", got)
+	}
+}
+
+// A GRPC client holding its share of handles has further reads and
+// writes refused with ResourceExhausted until one is given back
+func TestGrpcHandlesCapped(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	useMaxOpenHandles(t, 1)
+	path := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, "capped")
+	err := os.WriteFile(path, []byte("capped"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+
+	release, err := acquireHandle(testUser.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Write(ctx, &proto.WriteRequest{Path: "/capped", Data: []byte("more")})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("write past the cap = %v; want ResourceExhausted", err)
+	}
+	_, err = client.ReadAll(ctx, &proto.DirEntry{Path: "/capped"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("read past the cap = %v; want ResourceExhausted", err)
+	}
+
+	release()
+	_, err = client.ReadAll(ctx, &proto.DirEntry{Path: "/capped"})
+	if err != nil {
+		t.Errorf("read after a release = %v; want it let through", err)
+	}
+	if got := openHandleCount(testUser.Email); got != 0 {
+		t.Errorf("open handles after the requests = %v; want 0", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Most files whose hashes are remembered at a time. The index is
+// dropped when it grows past this; it fills up again as files are
+// requested
+const MAX_HASH_INDEX = 100_000
+
+type indexedHash struct {
+	ino     uint64
+	size    int64
+	modTime time.Time
+	ctime   syscall.Timespec
+	hash    string
+}
+
+var (
+	// Hashes of files we have already read, keyed by full path. An
+	// entry is only trusted while the file's inode, size, mtime and
+	// ctime are the ones it was hashed at
+	hashIndex   = make(map[string]indexedHash)
+	hashIndexMu = sync.Mutex{}
+)
+
+// Returns the hash of open file path, only reading the file if it
+// changed since it was last hashed
+func indexedFileHash(file *os.File, path string) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return lib.HashFile(file)
+	}
+
+	hashIndexMu.Lock()
+	entry, found := hashIndex[path]
+	hashIndexMu.Unlock()
+
+	if found && entry.ino == stat.Ino && entry.size == info.Size() &&
+		entry.modTime.Equal(info.ModTime()) && entry.ctime == stat.Ctim {
+		return entry.hash, nil
+	}
+
+	hash, err := lib.HashFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	hashIndexMu.Lock()
+	if len(hashIndex) >= MAX_HASH_INDEX {
+		hashIndex = make(map[string]indexedHash)
+	}
+	hashIndex[path] = indexedHash{
+		ino:     stat.Ino,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		ctime:   stat.Ctim,
+		hash:    hash,
+	}
+	hashIndexMu.Unlock()
+
+	return hash, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Writes a file of size bytes in the test user's department and
+// returns its path and hash
+func hashIndexFixture(t testing.TB, size int) (string, string) {
+	t.Helper()
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "large")
+	err = os.WriteFile(path, bytes.Repeat([]byte("fusion"), size/6), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(path)
+		hashIndexMu.Lock()
+		clear(hashIndex)
+		hashIndexMu.Unlock()
+	})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	hash, err := lib.HashFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, hash
+}
+
+// Asks remote for path as a client that already has it would, and
+// fails unless nothing is sent
+func checkUnchanged(t testing.TB, client proto.FuseClient, ctx context.Context, hash string) {
+	t.Helper()
+	stream, err := client.DownloadFile(ctx, &proto.DownloadRequest{Path: "/large", ExpectedHash: hash})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != io.EOF {
+		t.Fatalf("download of an unchanged file = %v; want nothing sent", err)
+	}
+}
+
+// Opens path afresh, as DownloadFile does, for its indexed hash
+func indexedHashOf(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	hash, err := indexedFileHash(file, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// An unchanged file's hash comes from the index; any change to the
+// file has it hashed again
+func TestHashIndexFollowsChanges(t *testing.T) {
+	path, hash := hashIndexFixture(t, 1024*1024)
+
+	if got := indexedHashOf(t, path); got != hash {
+		t.Fatalf("indexedFileHash = %v; want %v", got, hash)
+	}
+	// Only an answer taken from the index could be this
+	hashIndexMu.Lock()
+	entry := hashIndex[path]
+	entry.hash = "indexed"
+	hashIndex[path] = entry
+	hashIndexMu.Unlock()
+	if got := indexedHashOf(t, path); got != "indexed" {
+		t.Errorf("hash of unchanged file = %v; want the indexed one", got)
+	}
+
+	later := time.Now().Add(time.Hour)
+	err := os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := indexedHashOf(t, path); got != hash {
+		t.Errorf("hash after mtime change = %v; want %v", got, hash)
+	}
+
+	err = os.WriteFile(path, []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := lib.HashFile(bytes.NewReader([]byte("changed")))
+	if got := indexedHashOf(t, path); got != want {
+		t.Errorf("hash after rewrite = %v; want %v", got, want)
+	}
+}
+
+// Repeated checks of an unchanged 32MB file. Indexed, each check costs
+// a stat; rehashed, as before the index, it costs reading the file
+func BenchmarkDownloadCheckUnchanged(b *testing.B) {
+	const SIZE = 32 * 1024 * 1024
+	client, ctx := newTestClient(b, FuseServer{path: mountpoint}, testUser)
+	_, hash := hashIndexFixture(b, SIZE)
+
+	b.Run("indexed", func(b *testing.B) {
+		b.SetBytes(SIZE)
+		for b.Loop() {
+			checkUnchanged(b, client, ctx, hash)
+		}
+	})
+	b.Run("rehashed", func(b *testing.B) {
+		b.SetBytes(SIZE)
+		for b.Loop() {
+			hashIndexMu.Lock()
+			clear(hashIndex)
+			hashIndexMu.Unlock()
+			checkUnchanged(b, client, ctx, hash)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Hello answers logged in clients with the server's version, features
+// and their organization's temp patterns, and refuses anyone else
+func TestHelloNeedsLogin(t *testing.T) {
+	forgetTempRules(t, testUser.OrgName)
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+
+	info, err := client.Hello(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Hello = %v", err)
+	}
+	if info.Version != lib.VERSION {
+		t.Errorf("version = %q; want %q", info.Version, lib.VERSION)
+	}
+	for _, feature := range lib.FEATURES {
+		if !slices.Contains(info.Features, feature) {
+			t.Errorf("features %v lack %v", info.Features, feature)
+		}
+	}
+	if info.TempPatterns == "" {
+		t.Error("Hello sent no temp patterns")
+	}
+
+	_, err = client.Hello(context.Background(), &emptypb.Empty{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Hello without a token = %v; want Unauthenticated", err)
+	}
+}
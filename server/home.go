@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Every user gets a personal directory inside their department at
+// users/<username>. It is created on registration or first use and
+// only its owner can reach it; the rest of the department directory
+// is shared.
+//
+// Clients choose what they are rooted at with the SCOPE_METADATA
+// request metadata; the department directory for SCOPE_SHARED (the
+// default) or their own directory for SCOPE_PERSONAL
+const (
+	USERS_DIR_NAME = "users"
+
+	SCOPE_METADATA = "fusion-scope"
+	SCOPE_SHARED   = "shared"
+	SCOPE_PERSONAL = "personal"
+)
+
+// Path of user's personal directory relative to realpath
+func personalDir(user *db.User) string {
+	return filepath.Join(user.OrgName, user.DeptName, USERS_DIR_NAME, user.Username)
+}
+
+// Creates user's personal directory if it doesn't exist yet
+func provisionPersonalDir(user *db.User) error {
+	dir := filepath.Join(realpath, personalDir(user))
+	if dirExists(dir) {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(dir), 0771)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(dir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	return lib.SetOwner(dir, user.Email)
+}
+
+// Scope the request asked for. Anything but personal is shared
+func requestScope(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(SCOPE_METADATA)
+	if len(values) > 0 && values[0] == SCOPE_PERSONAL {
+		return SCOPE_PERSONAL
+	}
+	return SCOPE_SHARED
+}
+
+// Reports whether path, as seen by the client, is inside another
+// user's personal directory
+func hiddenPath(ctx context.Context, user *db.User, path string) bool {
+	if requestScope(ctx) != SCOPE_SHARED {
+		return false
+	}
+	return inOthersPersonalDir(user, path)
+}
+
+// Reports whether path, relative to user's department directory, is
+// inside another user's personal directory
+func inOthersPersonalDir(user *db.User, path string) bool {
+	parts := strings.Split(strings.Trim(filepath.Clean("/"+path), "/"), "/")
+	return len(parts) >= 2 && parts[0] == USERS_DIR_NAME && parts[1] != user.Username
+}
+
+// Methods that may act on the user's directory itself. The rest need
+// a path inside it so that eg. an Rmdir of "." can't remove it
+var rootPathMethods = map[string]bool{
+	proto.Fuse_Lookup_FullMethodName:          true,
+	proto.Fuse_ReadDirAll_FullMethodName:      true,
+	proto.Fuse_Getattr_FullMethodName:         true,
+	proto.Fuse_GetManifest_FullMethodName:     true,
+	proto.Fuse_DownloadFile_FullMethodName:    true,
+	proto.Fuse_DownloadArchive_FullMethodName: true,
+	proto.Fuse_ListVersions_FullMethodName:    true,
+}
+
+// Methods acting on a symlink itself rather than on what it points
+// to. Their last path component is not followed by checkSymlinks
+var noFollowMethods = map[string]bool{
+	proto.Fuse_Lookup_FullMethodName:  true,
+	proto.Fuse_Getattr_FullMethodName: true,
+	proto.Fuse_Unlink_FullMethodName:  true,
+	proto.Fuse_Rmdir_FullMethodName:   true,
+	proto.Fuse_Rename_FullMethodName:  true,
+	proto.Fuse_Symlink_FullMethodName: true,
+	proto.Fuse_Link_FullMethodName:    true,
+}
+
+// Cleans a wire path sent by a client with lib.CleanWirePath and
+// converts it into a local one. Paths that would leave the user's
+// directory through ".." fail, as do names this server can't store
+func cleanRequestPath(path string) (string, error) {
+	cleaned, ok := lib.CleanWirePath(path)
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "path %q leaves your directory", path)
+	}
+	if !lib.LocalPathValid(cleaned) {
+		return "", status.Errorf(codes.InvalidArgument, "path %q has a name this server can't store", path)
+	}
+	return lib.FromWire(cleaned), nil
+}
+
+// Cleans the paths of a request with cleanRequestPath and rejects
+// those that reach into another user's personal directory, directly
+// or through symlinks, and those whose symlinks loop or lead out of
+// the user's directory. Every string
+// field of the request whose name ends in "path" is checked
+func checkRequestPaths(ctx context.Context, method string, req any) error {
+	msg, ok := req.(protoreflect.ProtoMessage)
+	if !ok {
+		return nil
+	}
+	user, err := currentUser(ctx)
+	if err != nil {
+		// Not an authenticated method
+		return nil
+	}
+
+	message := msg.ProtoReflect()
+	fields := message.Descriptor().Fields()
+	for i := range fields.Len() {
+		field := fields.Get(i)
+		name := string(field.Name())
+		if field.Kind() != protoreflect.StringKind || field.IsList() || !strings.HasSuffix(name, "path") {
+			continue
+		}
+		if method == proto.Fuse_Symlink_FullMethodName && name == "old_path" {
+			// A symlink's target; see checkSymlinkTarget
+			continue
+		}
+
+		path, err := cleanRequestPath(message.Get(field).String())
+		if err != nil {
+			return err
+		}
+		if path == "/" && !rootPathMethods[method] {
+			return status.Errorf(codes.InvalidArgument, "%v needs a path inside your directory", name)
+		}
+		usersDir, err := getUsersDir(ctx)
+		if err != nil {
+			return lib.StatusError(err)
+		}
+		path = mergeNames(user, usersDir, path)
+		if hiddenPath(ctx, user, path) {
+			return status.Errorf(codes.PermissionDenied, "%v is in another user's personal directory", path)
+		}
+		err = checkSymlinks(filepath.Join(realpath, usersDir), path, !noFollowMethods[method], func(path string) bool {
+			return hiddenPath(ctx, user, path)
+		})
+		if err != nil {
+			return lib.StatusError(err)
+		}
+		message.Set(field, protoreflect.ValueOfString(path))
+	}
+	return nil
+}
+
+func PathInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	err := checkRequestPaths(ctx, info.FullMethod, req)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Checks the request of a server streaming method once it arrives
+type pathCheckedStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s pathCheckedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	return checkRequestPaths(s.Context(), s.method, m)
+}
+
+func PathStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return handler(srv, pathCheckedStream{ServerStream: ss, method: info.FullMethod})
+}
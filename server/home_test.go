@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Gives another user of the test user's department a file and plants
+// a symlink to their personal directory. Returns the department
+// directory and the symlink's path in it
+func personalDirFixture(t *testing.T) (dir, link string) {
+	t.Helper()
+
+	dir = filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	bob := filepath.Join(dir, USERS_DIR_NAME, "bob")
+	err := os.MkdirAll(bob, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(bob, "secret"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link = filepath.Base(t.Name())
+	err = os.Symlink(filepath.Join(USERS_DIR_NAME, "bob"), filepath.Join(dir, link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Join(dir, USERS_DIR_NAME))
+		os.Remove(filepath.Join(dir, link))
+	})
+	return dir, link
+}
+
+func TestSymlinkIntoPersonalDirRefused(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	_, link := personalDirFixture(t)
+
+	stream, err := client.DownloadFile(ctx, &proto.DownloadRequest{Path: "/" + link + "/secret"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("download through symlink = %v; want PermissionDenied", err)
+	}
+}
+
+func TestCreateSymlinkIntoPersonalDirRefused(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir, link := personalDirFixture(t)
+
+	targets := []string{
+		"users/bob/secret",
+		"./users/bob",
+		link + "/secret",
+	}
+	for _, target := range targets {
+		name := link + ".new"
+		_, err := client.Symlink(ctx, &proto.LinkRequest{OldPath: target, NewPath: "/" + name})
+		if err == nil {
+			t.Errorf("symlink to %v created", target)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("symlink to %v left behind", target)
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
+// A symlink planted after a request was checked is still caught when
+// the file is opened
+func TestOpenInDirChecksResolvedPath(t *testing.T) {
+	dir, link := personalDirFixture(t)
+	ctx := context.WithValue(context.Background(), auth.USER_CTX_KEY, &testUser)
+
+	_, err := openInDir(ctx, dir, filepath.Join(dir, link, "secret"), os.O_RDONLY, 0)
+	if !errors.Is(err, syscall.EPERM) {
+		t.Errorf("open through symlink = %v; want EPERM", err)
+	}
+
+	// bob reaches his own directory
+	bob := testUser
+	bob.Username = "bob"
+	ctx = context.WithValue(context.Background(), auth.USER_CTX_KEY, &bob)
+	file, err := openInDir(ctx, dir, filepath.Join(dir, link, "secret"), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("open by owner = %v", err)
+	}
+	file.Close()
+}
+
+// A path may not pass through another user's personal directory on
+// its way somewhere else
+func TestCheckSymlinksChecksEveryComponent(t *testing.T) {
+	dir, _ := personalDirFixture(t)
+	err := os.Symlink("users/bob/../..", filepath.Join(dir, "through"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "through")) })
+
+	var visited []string
+	hidden := func(path string) bool {
+		visited = append(visited, path)
+		return inOthersPersonalDir(&testUser, path)
+	}
+	err = checkSymlinks(dir, "/through", true, hidden)
+	if !errors.Is(err, syscall.EPERM) {
+		t.Errorf("checkSymlinks = %v; want EPERM after visiting %v", err, visited)
+	}
+
+	err = checkSymlinks(dir, "/users", true, hidden)
+	if err != nil {
+		t.Errorf("checkSymlinks of users = %v", err)
+	}
+}
+
+// A new user's first personal request creates a directory only they
+// can reach, which other members of the department don't see into
+func TestNewUserGetsPersonalDir(t *testing.T) {
+	carol := testUser
+	carol.Id, carol.Username, carol.Email = 3, "carol", "carol@example.com"
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, carol)
+	dir := filepath.Join(mountpoint, personalDir(&carol))
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("personal directory exists before first use; %v", err)
+	}
+
+	personal := metadata.AppendToOutgoingContext(ctx, SCOPE_METADATA, SCOPE_PERSONAL)
+	_, err := client.Create(personal, &proto.CreateRequest{
+		Path:  "/notes",
+		Flags: syscall.O_CREAT | syscall.O_WRONLY,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Fatalf("Create in personal scope = %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Fatalf("personal directory = %v, %v; want a 0700 directory", info, err)
+	}
+	if owner := lib.GetOwner(dir); owner != "" && owner != carol.Email {
+		t.Errorf("personal directory owned by %q; want %v", owner, carol.Email)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes")); err != nil {
+		t.Errorf("file created in personal scope not in the personal directory; %v", err)
+	}
+	err = provisionPersonalDir(&carol)
+	if err != nil {
+		t.Errorf("provisioning an existing directory = %v", err)
+	}
+
+	other, otherCtx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	_, err = other.Lookup(otherCtx, &proto.LookupRequest{Path: "/" + USERS_DIR_NAME + "/carol/notes"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("another user's Lookup of carol's file = %v; want PermissionDenied", err)
+	}
+	res, err := other.ReadDirAll(otherCtx, &proto.DirEntry{Path: "/" + USERS_DIR_NAME})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range res.Entries {
+		if filepath.Base(entry.Path) == "carol" {
+			t.Error("another user's listing shows carol's personal directory")
+		}
+	}
+}
+
+func TestCleanRequestPath(t *testing.T) {
+	tests := map[string]string{
+		"":              "/",
+		".":             "/",
+		"/":             "/",
+		"//":            "/",
+		"./":            "/",
+		"dir/..":        "/",
+		"file":          "/file",
+		"/dir/file":     "/dir/file",
+		"dir//./file/":  "/dir/file",
+		"/dir/../file":  "/file",
+		"..":            "",
+		"/..":           "",
+		"../other":      "",
+		"dir/../../etc": "",
+	}
+	for path, want := range tests {
+		got, err := cleanRequestPath(path)
+		if want == "" {
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("cleanRequestPath(%q) = %q, %v; want InvalidArgument", path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("cleanRequestPath(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	tests := map[string]string{
+		realpath:                               "/",
+		realpath + "/":                         "/",
+		filepath.Join(realpath, "org", "dept"): "/org/dept",
+		mountpoint:                             "/",
+		realpath + "2/file":                    realpath + "2/file",
+		"":                                     "",
+		"/elsewhere":                           "/elsewhere",
+	}
+	for path, want := range tests {
+		if got := relativePath(path); got != want {
+			t.Errorf("relativePath(%q) = %q; want %q", path, got, want)
+		}
+	}
+}
+
+// The user's directory itself can be listed but not removed, and
+// paths can't climb out of it
+func TestRootPathRequests(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	name := filepath.Base(t.Name())
+	err := os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, name)) })
+
+	for _, path := range []string{"", ".", "/"} {
+		res, err := client.ReadDirAll(ctx, &proto.DirEntry{Path: path})
+		if err != nil {
+			t.Fatalf("ReadDirAll %q = %v", path, err)
+		}
+		found := false
+		for _, entry := range res.Entries {
+			found = found || entry.Path == "/"+name
+		}
+		if !found {
+			t.Errorf("ReadDirAll %q doesn't list the user's directory", path)
+		}
+
+		_, err = client.Rmdir(ctx, &proto.DirEntry{Path: path})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Rmdir %q = %v; want InvalidArgument", path, err)
+		}
+	}
+	for _, path := range []string{"..", "/../" + testUser.DeptName, "../../" + testUser.OrgName} {
+		_, err := client.ReadDirAll(ctx, &proto.DirEntry{Path: path})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ReadDirAll %q = %v; want InvalidArgument", path, err)
+		}
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("user's directory gone; %v", err)
+	}
+}
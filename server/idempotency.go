@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	// How long the result of a keyed request is remembered
+	IDEMPOTENCY_TTL = 10 * time.Minute
+
+	// Most results remembered at once. Keyed requests beyond this
+	// run without deduplication
+	MAX_IDEMPOTENCY_KEYS = 10_000
+)
+
+// Requests that change files and so must not be applied twice
+var idempotentMethods = map[string]bool{
+	proto.Fuse_Mkdir_FullMethodName:   true,
+	proto.Fuse_Rmdir_FullMethodName:   true,
+	proto.Fuse_Unlink_FullMethodName:  true,
+	proto.Fuse_Create_FullMethodName:  true,
+	proto.Fuse_Write_FullMethodName:   true,
+	proto.Fuse_PutFile_FullMethodName: true,
+	proto.Fuse_Rename_FullMethodName:  true,
+	proto.Fuse_Symlink_FullMethodName: true,
+}
+
+type idempotentResult struct {
+	done     chan struct{} // closed once response and err are set
+	response any
+	err      error
+	expires  time.Time
+}
+
+var (
+	idempotentResults   = make(map[string]*idempotentResult)
+	idempotentResultsMu = sync.Mutex{}
+)
+
+// Applies a mutating request carrying an idempotency key only once.
+// Repeats of the request within IDEMPOTENCY_TTL get the result of
+// the first one, including while the first is still running. Only
+// successes and failures that a repeat would run into again are
+// remembered; see transientError. Must run after auth.AuthInterceptor
+func IdempotencyInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if !idempotentMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(lib.IDEMPOTENCY_KEY)
+	if len(keys) == 0 || keys[0] == "" {
+		return handler(ctx, req)
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return handler(ctx, req)
+	}
+
+	// Include the request itself so that a key reused for different
+	// requests (say chunks of one upload) doesn't merge them
+	msg, ok := req.(protobuf.Message)
+	if !ok {
+		return handler(ctx, req)
+	}
+	data, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return handler(ctx, req)
+	}
+	digest := sha256.Sum256(data)
+	cacheKey := user.Email + "|" + info.FullMethod + "|" + keys[0] + "|" + hex.EncodeToString(digest[:])
+
+	result, first := rememberRequest(cacheKey)
+	if result == nil {
+		// Cache full
+		return handler(ctx, req)
+	}
+	if !first {
+		<-result.done
+		return result.response, result.err
+	}
+
+	result.response, result.err = handler(ctx, req)
+	if transientError(ctx, result.err) {
+		// A retry may well succeed; let it run instead of handing
+		// it this failure
+		forgetRequest(cacheKey, result)
+	}
+	close(result.done)
+	return result.response, result.err
+}
+
+// Reports whether err says nothing about the request itself and so
+// must not be replayed to its repeats: the caller gave up, or the
+// server couldn't get to it
+func transientError(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if ctx.Err() != nil {
+		return true
+	}
+	switch status.Code(lib.StatusError(err)) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Unavailable,
+		codes.Aborted, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drops the result slot of key, if it is still result
+func forgetRequest(key string, result *idempotentResult) {
+	idempotentResultsMu.Lock()
+	defer idempotentResultsMu.Unlock()
+
+	if idempotentResults[key] == result {
+		delete(idempotentResults, key)
+	}
+}
+
+// Returns the result slot of key and whether the caller is the first
+// to ask for it and so must fill it in
+func rememberRequest(key string) (*idempotentResult, bool) {
+	idempotentResultsMu.Lock()
+	defer idempotentResultsMu.Unlock()
+
+	now := time.Now()
+	result, ok := idempotentResults[key]
+	if ok && now.Before(result.expires) {
+		return result, false
+	}
+
+	if len(idempotentResults) >= MAX_IDEMPOTENCY_KEYS {
+		for k, r := range idempotentResults {
+			if now.After(r.expires) {
+				delete(idempotentResults, k)
+			}
+		}
+		if len(idempotentResults) >= MAX_IDEMPOTENCY_KEYS {
+			return nil, false
+		}
+	}
+
+	result = &idempotentResult{
+		done:    make(chan struct{}),
+		expires: now.Add(IDEMPOTENCY_TTL),
+	}
+	idempotentResults[key] = result
+	return result, true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Fails Mkdir with errs in turn, then succeeds
+type countingServer struct {
+	proto.UnimplementedFuseServer
+	calls atomic.Int32
+	errs  []error
+}
+
+func (s *countingServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.DirEntry, error) {
+	n := int(s.calls.Add(1))
+	if n <= len(s.errs) {
+		return nil, s.errs[n-1]
+	}
+	return &proto.DirEntry{Path: req.Path}, nil
+}
+
+func keyed(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, lib.IDEMPOTENCY_KEY, key)
+}
+
+func TestIdempotentRequestAppliedOnce(t *testing.T) {
+	srv := &countingServer{}
+	client, ctx := newTestClient(t, srv, testUser)
+	ctx = keyed(ctx, "once")
+
+	for range 3 {
+		_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/once"})
+		if err != nil {
+			t.Fatalf("Mkdir = %v", err)
+		}
+	}
+	if calls := srv.calls.Load(); calls != 1 {
+		t.Errorf("handler ran %v times; want 1", calls)
+	}
+
+	// Another request under the same key is a different request
+	_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/other"})
+	if err != nil {
+		t.Fatalf("Mkdir = %v", err)
+	}
+	if calls := srv.calls.Load(); calls != 2 {
+		t.Errorf("handler ran %v times; want 2", calls)
+	}
+}
+
+func TestIdempotentTransientErrorsNotReplayed(t *testing.T) {
+	transient := []error{
+		status.Error(codes.Unavailable, "unavailable"),
+		status.Error(codes.DeadlineExceeded, "deadline"),
+		status.Error(codes.Canceled, "canceled"),
+		lib.StatusError(syscall.EIO),
+	}
+	for _, first := range transient {
+		t.Run(status.Code(first).String(), func(t *testing.T) {
+			srv := &countingServer{errs: []error{first}}
+			client, ctx := newTestClient(t, srv, testUser)
+			ctx = keyed(ctx, "transient-"+status.Code(first).String())
+
+			_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+			if status.Code(err) != status.Code(first) {
+				t.Fatalf("first Mkdir = %v; want %v", err, first)
+			}
+			_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+			if err != nil {
+				t.Errorf("retried Mkdir = %v; want it to run again and succeed", err)
+			}
+			if calls := srv.calls.Load(); calls != 2 {
+				t.Errorf("handler ran %v times; want 2", calls)
+			}
+		})
+	}
+}
+
+func TestIdempotentDeterministicErrorsReplayed(t *testing.T) {
+	srv := &countingServer{errs: []error{lib.StatusError(syscall.EEXIST)}}
+	client, ctx := newTestClient(t, srv, testUser)
+	ctx = keyed(ctx, "exists")
+
+	for range 2 {
+		_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+		if lib.StatusErrno(err) != syscall.EEXIST {
+			t.Fatalf("Mkdir = %v; want EEXIST", err)
+		}
+	}
+	if calls := srv.calls.Load(); calls != 1 {
+		t.Errorf("handler ran %v times; want 1", calls)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+var (
+	// Ignore files of department and personal directories keyed by
+	// the directory they are in
+	ignoreFiles   = make(map[string]*lib.IgnoreFile)
+	ignoreFilesMu = sync.Mutex{}
+)
+
+// Ignore file at the root of dir, relative to realpath
+func ignoreFileAt(dir string) *lib.IgnoreFile {
+	ignoreFilesMu.Lock()
+	defer ignoreFilesMu.Unlock()
+
+	file, ok := ignoreFiles[dir]
+	if !ok {
+		file = lib.NewIgnoreFile(filepath.Join(realpath, dir, lib.IGNORE_FILE))
+		ignoreFiles[dir] = file
+	}
+	return file
+}
+
+// Reports whether path, relative to realpath, is ignored by the
+// ignore file clients keep at the root of their department or of the
+// personal directory path is in
+func syncIgnored(path string, isDir bool) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 {
+		return false
+	}
+
+	dept := filepath.Join(parts[0], parts[1])
+	if ignoreFileAt(dept).Match(filepath.Join(parts[2:]...), isDir) {
+		return true
+	}
+	if len(parts) >= 5 && parts[2] == USERS_DIR_NAME {
+		personal := filepath.Join(dept, parts[2], parts[3])
+		return ignoreFileAt(personal).Match(filepath.Join(parts[4:]...), isDir)
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Writes rules to the ignore file at the root of dir
+func writeIgnoreFile(t *testing.T, dir, rules string) {
+	t.Helper()
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, lib.IGNORE_FILE)
+	err = os.WriteFile(path, []byte(rules), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+}
+
+// The department's ignore file applies throughout it, and a personal
+// directory's on top of that within it
+func TestSyncIgnored(t *testing.T) {
+	dept := filepath.Join(testUser.OrgName, testUser.DeptName)
+	writeIgnoreFile(t, filepath.Join(realpath, dept), "*.log\n")
+	personal := filepath.Join(dept, USERS_DIR_NAME, "dave")
+	writeIgnoreFile(t, filepath.Join(realpath, personal), "drafts/\n")
+	t.Cleanup(func() { os.RemoveAll(filepath.Join(realpath, dept, USERS_DIR_NAME, "dave")) })
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/" + dept + "/debug.log", false, true},
+		{"/" + dept + "/sub/debug.log", false, true},
+		{"/" + dept + "/notes.txt", false, false},
+		{"/" + dept + "/" + lib.IGNORE_FILE, false, false},
+		{"/" + personal + "/debug.log", false, true},
+		{"/" + personal + "/drafts", true, true},
+		{"/" + personal + "/drafts/a.txt", false, true},
+		{"/" + dept + "/drafts", true, false},
+		{"/" + testUser.OrgName, true, false},
+	}
+	for _, test := range tests {
+		if got := syncIgnored(test.path, test.isDir); got != test.want {
+			t.Errorf("syncIgnored(%v) = %v; want %v", test.path, got, test.want)
+		}
+	}
+}
+
+// Observers hear of changes to synced files but not to ignored ones
+func TestIgnoredChangesNotBroadcast(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	writeIgnoreFile(t, dir, "*.log\n")
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(dir, "debug.log"))
+		os.Remove(filepath.Join(dir, "notes.txt"))
+	})
+
+	observing, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go startMainObserver(observing)
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	t.Cleanup(cancel)
+	stream, err := client.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSessions(t, 1)
+
+	// The ignored file goes first, so its event would come first too
+	size := uint64(1)
+	for _, name := range []string{"debug.log", "notes.txt"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("contents"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: "/" + name, Size: &size})
+		if err != nil {
+			t.Fatalf("Setattr %v = %v", name, err)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "debug.log")); string(got) != "c" {
+		t.Errorf("ignored file = %q on the server; want %q", got, "c")
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("no event for notes.txt; %v", err)
+		}
+		if filepath.Base(event.Path) == "debug.log" {
+			t.Errorf("event %v broadcast for an ignored file", events.EventType(event.Event))
+		}
+		if filepath.Base(event.Path) == "notes.txt" {
+			break
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+)
+
+// Keeps secrets in a temporary ~/.fusion/.env holding the test key
+func useDotenvKeys(t *testing.T) {
+	oldDir := lib.ProjectDir
+	lib.ProjectDir = t.TempDir()
+	t.Setenv(lib.SECRETS_SOURCE_ENV, lib.SECRETS_DOTENV)
+	// Restores the environment loading .env writes to
+	t.Setenv("SECRET_KEY", "test-secret-key")
+	t.Setenv("SECRET_KEY_PREVIOUS", "")
+	err := os.WriteFile(filepath.Join(lib.ProjectDir, ".env"), []byte("SECRET_KEY=test-secret-key\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReloadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		lib.ProjectDir = oldDir
+		os.Setenv(lib.SECRETS_SOURCE_ENV, lib.SECRETS_ENV)
+		os.Setenv("SECRET_KEY", "test-secret-key")
+		os.Unsetenv("SECRET_KEY_PREVIOUS")
+		db.ReloadKeys()
+	})
+}
+
+func rotateKeys(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/keys/rotate", nil)
+	r = r.WithContext(context.WithValue(r.Context(), auth.USER_CTX_KEY, &testUser))
+	w := httptest.NewRecorder()
+	rotateKeysHandler(w, r)
+	return w
+}
+
+// Tokens signed before a rotation stay valid through it, new ones are
+// signed with the new key, and the keys are saved for a restart
+func TestRotateKeys(t *testing.T) {
+	useDotenvKeys(t)
+	before, err := auth.GenerateToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := rotateKeys(t)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate = %v %v; want 200", w.Code, w.Body)
+	}
+	body := map[string]any{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["previous_keys"] != float64(1) {
+		t.Errorf("rotate answered %v; want 1 previous key", w.Body)
+	}
+	keys := db.SecretKeys()
+	if keys.Current == "test-secret-key" || len(keys.Current) != 64 {
+		t.Fatalf("current key after rotation = %q; want a new one", keys.Current)
+	}
+
+	var user db.User
+	if !auth.ValidToken(before, &user) {
+		t.Error("token signed before the rotation didn't validate")
+	}
+	after, err := auth.GenerateToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.ValidToken(after, &user) {
+		t.Error("token signed after the rotation didn't validate")
+	}
+
+	// A second rotation keeps both older keys, newest first
+	first := keys.Current
+	if w := rotateKeys(t); w.Code != http.StatusOK {
+		t.Fatalf("second rotate = %v %v; want 200", w.Code, w.Body)
+	}
+	keys = db.SecretKeys()
+	if len(keys.Previous) != 2 || keys.Previous[0] != first || keys.Previous[1] != "test-secret-key" {
+		t.Errorf("previous keys = %v; want [%v test-secret-key]", keys.Previous, first)
+	}
+	if !auth.ValidToken(before, &user) || !auth.ValidToken(after, &user) {
+		t.Error("tokens from before the second rotation didn't validate")
+	}
+
+	// What a restart would read
+	data, err := os.ReadFile(filepath.Join(lib.ProjectDir, ".env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := "SECRET_KEY=" + keys.Current + "\nSECRET_KEY_PREVIOUS=" + strings.Join(keys.Previous, ",") + "\n"
+	if string(data) != saved {
+		t.Errorf(".env = %q; want %q", data, saved)
+	}
+
+	// Until the old key is dropped
+	err = os.WriteFile(filepath.Join(lib.ProjectDir, ".env"), []byte("SECRET_KEY="+keys.Current+"\nSECRET_KEY_PREVIOUS=\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReloadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if auth.ValidToken(before, &user) {
+		t.Error("token signed with a dropped key validated")
+	}
+}
+
+// Keys from a source the server can't write to are rotated there
+func TestRotateKeysReadOnly(t *testing.T) {
+	w := rotateKeys(t)
+	if w.Code != http.StatusConflict {
+		t.Errorf("rotate with keys from the environment = %v %v; want 409", w.Code, w.Body)
+	}
+	if keys := db.SecretKeys(); keys.Current != "test-secret-key" || len(keys.Previous) != 0 {
+		t.Errorf("keys = %+v after a refused rotation; want them unchanged", keys)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Symlinks are reported as symlinks, with their target, not as what
+// they point to
+func TestLookupSymlink(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	name := filepath.Base(t.Name())
+	err := os.WriteFile(filepath.Join(dir, name), []byte("file"), 0644)
+	if err == nil {
+		err = os.Symlink(name, filepath.Join(dir, name+".link"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(dir, name))
+		os.Remove(filepath.Join(dir, name+".link"))
+	})
+
+	res, err := client.Lookup(ctx, &proto.LookupRequest{Path: "/" + name + ".link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Attr.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+		t.Errorf("mode = %o; want a symlink", res.Attr.Mode)
+	}
+	if res.Target != name {
+		t.Errorf("target = %q; want %q", res.Target, name)
+	}
+
+	res, err = client.Lookup(ctx, &proto.LookupRequest{Path: "/" + name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Attr.Mode&syscall.S_IFMT != syscall.S_IFREG || res.Target != "" {
+		t.Errorf("file looked up as mode %o, target %q", res.Attr.Mode, res.Target)
+	}
+}
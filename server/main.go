@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
@@ -16,27 +17,46 @@ import (
 	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
 	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-type key string
-
 var (
 	debug                bool
 	realpath, mountpoint string
 	grpcAddr             string
 	webAddr              string
+	createMountpoint     bool
+	inodeQuota           int
+	maxRPCs, maxStreams  int
+	orgLimits            string
+	orgNamePolicies      string
+	orgClients           string
+	tlsCert, tlsKey      string
+	maxMsgSize           int
+	maxWriteSize         int
+	maxFileSize          int64
+	downloadStall        time.Duration
+	downloadTimeout      time.Duration
+	tokenCleanup         time.Duration
+	fsyncDirs            bool
+	statfsTTL            time.Duration
+	keepVersions         int
+	versionMaxAge        time.Duration
+	versionsDir          string
+	tempPatternsPath     string
+	tlsConfig            *tls.Config
 
 	SECRET_KEY string
 
 	fuseServer *fuse.Server
 	grpcServer *grpc.Server
-	userCtxKey key = "user"
 )
 
-func init() {
+func parseFlags() {
 	var help bool
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -46,8 +66,35 @@ func init() {
 	flag.BoolVar(&debug, "debug", false, "Display FUSE debug logs to stdout.")
 	flag.StringVar(&realpath, "realpath", "", "Physical directory where files are stored")
 	flag.StringVar(&mountpoint, "mountpoint", filepath.Join(homeDir, "FAT_BOY"), "Virtual directory where files appear")
+	flag.BoolVar(&createMountpoint, "create-mountpoint", true, "Create -mountpoint if it does not exist.")
+	flag.IntVar(&inodeQuota, "inode-quota", 0, "Maximum number of files and directories per organization. 0 disables the quota.")
 	flag.StringVar(&grpcAddr, "grpc-address", "0.0.0.0:1054", "Address to run the GRPC FUSE service on.")
 	flag.StringVar(&webAddr, "web-address", "0.0.0.0:5000", "Address to run the web server")
+	flag.IntVar(&maxRPCs, "max-rpcs-per-user", 64, "Maximum concurrent GRPC requests per user. 0 means unlimited.")
+	flag.IntVar(&maxStreams, "max-streams-per-user", 16, "Maximum concurrent GRPC streams per user. 0 means unlimited.")
+	flag.StringVar(&orgLimits, "org-limits", "", "Per organization limits overriding the per user ones; eg. org1=64:16,org2=8:4")
+	flag.IntVar(&maxOpenHandles, "max-open-handles", 256, "Maximum files a single client may hold open on the server at once. 0 means unlimited.")
+	flag.IntVar(&maxClients, "max-clients", 0, "Maximum clients connected at once per organization. 0 means unlimited.")
+	flag.StringVar(&orgClients, "org-max-clients", "", "Per organization -max-clients; eg. org1=10,org2=5")
+	flag.StringVar(&namePolicy, "names", NAMES_EXACT, "How to treat names differing only in case; one of exact, reject or merge. reject and merge also normalize names to NFC.")
+	flag.StringVar(&orgNamePolicies, "org-names", "", "Per organization -names policy; eg. org1=reject,org2=merge")
+	flag.IntVar(&maxMsgSize, "max-msg-size", 16, "Largest GRPC message in MB the server sends or accepts. Bounds the files ReadAll can return.")
+	flag.IntVar(&maxWriteSize, "max-write-size", 8, "Most data in MB a single Write may carry. Must be below -max-msg-size.")
+	flag.Int64Var(&maxFileSize, "max-file-size", 1024, "Largest file in GB a Write may grow. 0 means unlimited.")
+	flag.DurationVar(&downloadStall, "download-stall", 30*time.Second, "Longest a client may take to receive one chunk of a download before it is aborted. 0 disables.")
+	flag.DurationVar(&downloadTimeout, "download-timeout", time.Hour, "Longest a single download may take. 0 disables.")
+	flag.DurationVar(&statfsTTL, "statfs-ttl", 2*time.Second, "How long disk usage of -realpath is reused between Statfs calls. 0 disables.")
+	flag.BoolVar(&fsyncDirs, "fsync-dirs", false, "Flush the parent directory after every create, mkdir, rename and delete so the change survives a crash. Slows those operations down.")
+	flag.IntVar(&keepVersions, "versions", 0, "Number of earlier versions kept of every file changed through GRPC. 0 disables versioning.")
+	flag.DurationVar(&versionMaxAge, "version-max-age", 0, "Remove versions older than this. 0 keeps them until -versions is exceeded.")
+	flag.StringVar(&versionsDir, "versions-dir", filepath.Join(lib.ProjectDir, "versions"), "Directory where earlier versions of files are kept. Must be outside -realpath.")
+	flag.DurationVar(&tokenCleanup, "token-cleanup-interval", time.Hour, "How often expired password reset tokens are removed from the database. 0 disables.")
+	flag.StringVar(&tempPatternsPath, "temp-patterns", "", "File listing, in .fusionignore syntax, the temp files whose changes aren't broadcast to clients. Read again when it changes. Leave empty for the built-in patterns; organizations can add their own in "+ORG_TEMP_FILE+" at the root of their directory.")
+	flag.StringVar(&exportConfigPath, "export-config", "", "Write every organization and user to this JSON file, - for stdout, and exit.")
+	flag.StringVar(&importConfigPath, "import-config", "", "Restore organizations and users from a JSON file written by -export-config and exit.")
+	flag.StringVar(&importMode, "import-mode", db.IMPORT_SKIP, "What -import-config does with organizations and users that already exist; skip keeps them, overwrite replaces them.")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate for the GRPC server. Replacing the file takes effect on new connections without a restart. Leave empty to serve without TLS.")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key matching -tls-cert.")
 	flag.BoolVar(&help, "help", false, "Display help message.")
 	flag.Parse()
 
@@ -64,17 +111,92 @@ func init() {
 		log.Fatalf("invalid -web-address provided; %v\n", err)
 	}
 
-	err = lib.LoadEnv()
+	tlsConfig, err = serverTLSConfig(tlsCert, tlsKey)
+	if err != nil {
+		log.Fatalf("invalid -tls-cert or -tls-key provided; %v\n", err)
+	}
+
+	if maxMsgSize < 4 {
+		log.Fatalf("invalid -max-msg-size provided; must be at least gRPC's default of 4MB\n")
+	}
+	if maxWriteSize < 1 || maxWriteSize >= maxMsgSize {
+		log.Fatalf("invalid -max-write-size provided; must be at least 1MB and below -max-msg-size\n")
+	}
+	if maxFileSize < 0 {
+		log.Fatalf("invalid -max-file-size provided; must not be negative\n")
+	}
+
+	if importMode != db.IMPORT_SKIP && importMode != db.IMPORT_OVERWRITE {
+		log.Fatalf("invalid -import-mode provided; expected one of %v, %v\n", db.IMPORT_SKIP, db.IMPORT_OVERWRITE)
+	}
+
+	if keepVersions < 0 || versionMaxAge < 0 {
+		log.Fatalf("invalid -versions or -version-max-age provided; must not be negative\n")
+	}
+
+	localStatfs = lib.NewStatfsCache(statfsTTL)
+
+	if tempPatternsPath != "" {
+		if _, err := os.Stat(tempPatternsPath); err != nil {
+			log.Fatalf("invalid -temp-patterns provided; %v\n", err)
+		}
+		tempPatternsFile = lib.NewIgnoreFile(tempPatternsPath)
+	}
+
+	perOrg, err := parseOrgLimits(orgLimits)
 	if err != nil {
-		log.Fatalf("Error loading env variables; %v\n", err)
+		log.Fatalf("invalid -org-limits provided; %v\n", err)
+	}
+	auth.SetLimits(auth.Limits{MaxRPCs: maxRPCs, MaxStreams: maxStreams}, perOrg)
+
+	if maxOpenHandles < 0 {
+		log.Fatalf("invalid -max-open-handles provided; must not be negative\n")
+	}
+
+	if maxClients < 0 {
+		log.Fatalf("invalid -max-clients provided; must not be negative\n")
+	}
+	orgMaxClients, err = parseOrgMaxClients(orgClients)
+	if err != nil {
+		log.Fatalf("invalid -org-max-clients provided; %v\n", err)
+	}
+
+	if !validNamePolicy(namePolicy) {
+		log.Fatalf("invalid -names provided; expected one of %v\n", strings.Join(namePolicyList, ", "))
+	}
+	orgNamePolicy, err = parseOrgNamePolicies(orgNamePolicies)
+	if err != nil {
+		log.Fatalf("invalid -org-names provided; %v\n", err)
 	}
 
 	// Ensure SECRET_KEY is always set
-	SECRET_KEY = os.Getenv("SECRET_KEY")
+	SECRET_KEY, err = lib.RequireSecret("SECRET_KEY")
+	if err != nil {
+		log.Fatalf("Error loading secrets; %v\n", err)
+	}
+}
 
-	if strings.TrimSpace(SECRET_KEY) == "" {
-		log.Fatalln("Missing SECRET_KEY env variable")
+// Parses limits in the format org1=rpcs:streams,org2=rpcs:streams
+func parseOrgLimits(value string) (map[string]auth.Limits, error) {
+	limits := make(map[string]auth.Limits)
+	if strings.TrimSpace(value) == "" {
+		return limits, nil
 	}
+
+	for _, entry := range strings.Split(value, ",") {
+		org, values, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected org=rpcs:streams but got %q", entry)
+		}
+
+		var orgLimit auth.Limits
+		_, err := fmt.Sscanf(values, "%d:%d", &orgLimit.MaxRPCs, &orgLimit.MaxStreams)
+		if err != nil {
+			return nil, fmt.Errorf("expected org=rpcs:streams but got %q", entry)
+		}
+		limits[org] = orgLimit
+	}
+	return limits, nil
 }
 
 func dirExists(path string) bool {
@@ -94,16 +216,16 @@ func mountFileSystem(errorChan chan<- error) {
 	}
 
 	// Ensure mountpoint directory exists
-	if !dirExists(mountpoint) {
-		log.Println("-mountpoint directory does not exist")
-		err := os.Mkdir(mountpoint, 0755)
-		if err != nil {
-			log.Fatalf("Error creating mount directory; %v\n", err)
-		}
+	created, err := lib.PrepareMountpoint(mountpoint, createMountpoint)
+	if err != nil {
+		log.Fatalf("Error preparing mount directory; %v\n", err)
 	}
 
 	fileSystem, err := NewFileSystem(realpath)
 	if err != nil {
+		if created {
+			lib.RemoveMountpoint(mountpoint)
+		}
 		errorChan <- fmt.Errorf("error creating loopback Root directory; %v", err)
 		return
 	}
@@ -121,6 +243,10 @@ func mountFileSystem(errorChan chan<- error) {
 		},
 	)
 	if err != nil {
+		// Don't leave stale directories behind between restarts
+		if created {
+			lib.RemoveMountpoint(mountpoint)
+		}
 		errorChan <- fmt.Errorf("mount fail: %v", err)
 		return
 	}
@@ -131,6 +257,16 @@ func mountFileSystem(errorChan chan<- error) {
 	log.Fatalln("Filesystem unmounted by user")
 }
 
+// Interceptors and limits every GRPC request goes through
+func grpcServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(RequestIdInterceptor, auth.AuthInterceptor, RequestUserInterceptor, MaintenanceInterceptor, PathInterceptor, IdempotencyInterceptor),
+		grpc.ChainStreamInterceptor(RequestIdStreamInterceptor, auth.AuthStreamInterceptor, RequestUserStreamInterceptor, MaintenanceStreamInterceptor, PathStreamInterceptor),
+		grpc.MaxRecvMsgSize(maxMsgSize * 1024 * 1024),
+		grpc.MaxSendMsgSize(maxMsgSize * 1024 * 1024),
+	}
+}
+
 func start_gRPCServer(errorChan chan<- error) {
 	listener, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
@@ -138,10 +274,11 @@ func start_gRPCServer(errorChan chan<- error) {
 		return
 	}
 
-	grpcServer = grpc.NewServer(
-		grpc.UnaryInterceptor(auth.AuthInterceptor),
-		grpc.StreamInterceptor(auth.AuthStreamInterceptor),
-	)
+	opts := grpcServerOptions()
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer = grpc.NewServer(opts...)
 
 	// Create new FuseServer instance
 	ctx, cancel := context.WithCancel(context.Background())
@@ -158,6 +295,15 @@ func start_gRPCServer(errorChan chan<- error) {
 }
 
 func main() {
+	parseFlags()
+	db.Open()
+	openModels()
+	auth.SetUserLookup(users.Get)
+
+	if runConfigCommand() {
+		return
+	}
+
 	fileSystemChan := make(chan error)
 	gRPCChan := make(chan error)
 	webChan := make(chan error)
@@ -165,6 +311,7 @@ func main() {
 	go mountFileSystem(fileSystemChan)
 	go start_gRPCServer(gRPCChan)
 	go startWebServer(webChan)
+	go cleanupResetTokens(tokenCleanup)
 
 	const MAX_FAILS = 3
 	numberFuseFails := 0
@@ -193,6 +340,31 @@ func main() {
 		os.Exit(1)
 	}()
 
+	// SIGHUP picks up a rotated SECRET_KEY; see db.Keyset
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for range hupChan {
+			err := db.ReloadKeys()
+			if err != nil {
+				log.Printf("Error reloading secret keys; %v\n", err)
+				continue
+			}
+			log.Printf("Reloaded secret keys; %v previous keys still accepted\n", len(db.SecretKeys().Previous))
+		}
+	}()
+
+	// SIGUSR1 toggles maintenance; see maintenance.go
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+
+	go func() {
+		for range usr1Chan {
+			toggleMaintenance()
+		}
+	}()
+
 	for {
 		// Restart FUSE filesystem whenever it fails
 		select {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Tests run without a database. Secrets come from the environment and
+// files live under a temporary -mountpoint
+func TestMain(m *testing.M) {
+	os.Setenv(lib.SECRETS_SOURCE_ENV, lib.SECRETS_ENV)
+	os.Setenv("SECRET_KEY", "test-secret-key")
+	err := db.ReloadKeys()
+	if err != nil {
+		log.Fatalf("Error loading test keys; %v\n", err)
+	}
+
+	dir, err := os.MkdirTemp("", "fusion-server-test")
+	if err != nil {
+		log.Fatalf("Error creating test directory; %v\n", err)
+	}
+	mountpoint = dir
+	realpath = dir
+	maxMsgSize = 16
+	maxWriteSize = 8
+	localStatfs = lib.NewStatfsCache(0)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var testUser = db.User{
+	Id:       1,
+	Username: "alice",
+	Email:    "alice@example.com",
+	OrgName:  "org",
+	DeptName: "dept",
+}
+
+// Serves srv over an in-memory connection through the interceptors the
+// server uses and returns a client for it together with a context
+// authenticated as user
+func newTestClient(t testing.TB, srv proto.FuseServer, user db.User) (proto.FuseClient, context.Context) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Join(mountpoint, user.OrgName, user.DeptName), 0755)
+	if err != nil {
+		t.Fatalf("Error creating user's directory; %v", err)
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpcServerOptions()...)
+	proto.RegisterFuseServer(server, srv)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Error connecting to test server; %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("Error generating token; %v", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+	return proto.NewFuseClient(conn), ctx
+}
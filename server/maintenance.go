@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc"
+)
+
+// While in maintenance, eg. during a backup, requests that change
+// anything fail with Unavailable so the files and database hold
+// still. Reads and observer streams carry on. SIGUSR1 toggles it for
+// the whole server; an organization's admin can turn it on for just
+// their organization.
+//
+// Clients treat Unavailable as remote being unreachable and queue
+// their changes until it is over
+var (
+	maintenance atomic.Bool
+
+	orgMaintenance   = make(map[string]bool)
+	orgMaintenanceMu = sync.Mutex{}
+)
+
+// Requests refused during maintenance
+var mutatingMethods = map[string]bool{
+	proto.Fuse_Mkdir_FullMethodName:          true,
+	proto.Fuse_Rmdir_FullMethodName:          true,
+	proto.Fuse_Unlink_FullMethodName:         true,
+	proto.Fuse_Setattr_FullMethodName:        true,
+	proto.Fuse_Create_FullMethodName:         true,
+	proto.Fuse_Symlink_FullMethodName:        true,
+	proto.Fuse_Link_FullMethodName:           true,
+	proto.Fuse_Write_FullMethodName:          true,
+	proto.Fuse_PutFile_FullMethodName:        true,
+	proto.Fuse_UploadFile_FullMethodName:     true,
+	proto.Fuse_Rename_FullMethodName:         true,
+	proto.Fuse_Copy_FullMethodName:           true,
+	proto.Fuse_RestoreVersion_FullMethodName: true,
+	proto.Fuse_UpdateProfile_FullMethodName:  true,
+	proto.Fuse_ConfirmEmail_FullMethodName:   true,
+}
+
+func toggleMaintenance() {
+	enabled := !maintenance.Load()
+	maintenance.Store(enabled)
+	if enabled {
+		log.Println("Entered maintenance; changes are refused until the next SIGUSR1")
+	} else {
+		log.Println("Left maintenance")
+	}
+}
+
+func inMaintenance(orgName string) bool {
+	if maintenance.Load() {
+		return true
+	}
+
+	orgMaintenanceMu.Lock()
+	defer orgMaintenanceMu.Unlock()
+	return orgMaintenance[orgName]
+}
+
+// Refuses mutating requests during maintenance. Must run after
+// auth.AuthInterceptor
+func MaintenanceInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if !mutatingMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	user, err := currentUser(ctx)
+	if err == nil && inMaintenance(user.OrgName) {
+		return nil, lib.MaintenanceError()
+	}
+	return handler(ctx, req)
+}
+
+// Stream counterpart of MaintenanceInterceptor
+func MaintenanceStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if !mutatingMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+
+	user, err := currentUser(ss.Context())
+	if err == nil && inMaintenance(user.OrgName) {
+		return lib.MaintenanceError()
+	}
+	return handler(srv, ss)
+}
+
+type maintenanceRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+func getMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"maintenance": inMaintenance(user.OrgName),
+		"server_wide": maintenance.Load(),
+	})
+}
+
+func setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	var req maintenanceRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "enabled field required")
+		return
+	}
+
+	orgMaintenanceMu.Lock()
+	if req.Enabled {
+		orgMaintenance[user.OrgName] = true
+	} else {
+		delete(orgMaintenance, user.OrgName)
+	}
+	orgMaintenanceMu.Unlock()
+	log.Printf("Maintenance of %v set to %v by %v\n", user.OrgName, req.Enabled, user.Email)
+
+	getMaintenanceHandler(w, r)
+}
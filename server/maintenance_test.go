@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+type okServer struct {
+	proto.UnimplementedFuseServer
+}
+
+func (okServer) Mkdir(ctx context.Context, req *proto.MkdirRequest) (*proto.DirEntry, error) {
+	return &proto.DirEntry{Path: req.Path}, nil
+}
+
+func (okServer) Getattr(ctx context.Context, req *proto.DirEntry) (*proto.FileAttr, error) {
+	return &proto.FileAttr{}, nil
+}
+
+func TestMaintenanceRefusesChanges(t *testing.T) {
+	client, ctx := newTestClient(t, okServer{}, testUser)
+
+	toggleMaintenance()
+	_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+	if !lib.IsMaintenance(err) {
+		t.Errorf("Mkdir during maintenance = %v; want a maintenance error", err)
+	}
+	_, err = client.Getattr(ctx, &proto.DirEntry{Path: "/dir"})
+	if err != nil {
+		t.Errorf("Getattr during maintenance = %v; want it to succeed", err)
+	}
+
+	toggleMaintenance()
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+	if err != nil {
+		t.Errorf("Mkdir after maintenance = %v; want it to succeed", err)
+	}
+}
+
+func TestOrgMaintenance(t *testing.T) {
+	client, ctx := newTestClient(t, okServer{}, testUser)
+	other := testUser
+	other.Email = "bob@example.com"
+	other.OrgName = "other-org"
+	otherClient, otherCtx := newTestClient(t, okServer{}, other)
+
+	orgMaintenanceMu.Lock()
+	orgMaintenance[testUser.OrgName] = true
+	orgMaintenanceMu.Unlock()
+	defer func() {
+		orgMaintenanceMu.Lock()
+		delete(orgMaintenance, testUser.OrgName)
+		orgMaintenanceMu.Unlock()
+	}()
+
+	_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/dir"})
+	if !lib.IsMaintenance(err) {
+		t.Errorf("Mkdir in an organization in maintenance = %v; want a maintenance error", err)
+	}
+	_, err = otherClient.Mkdir(otherCtx, &proto.MkdirRequest{Path: "/dir"})
+	if err != nil {
+		t.Errorf("Mkdir in another organization = %v; want it to succeed", err)
+	}
+}
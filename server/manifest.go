@@ -0,0 +1,215 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type manifestItem struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+	hash    string
+	owner   string
+}
+
+type cachedManifest struct {
+	dir      string
+	modTime  time.Time
+	items    []manifestItem
+	cachedAt time.Time
+}
+
+// Most directory manifests kept at once. The least recently listed
+// ones are dropped first
+const MANIFEST_CACHE_SIZE = 4096
+
+// How long a cached manifest is kept without its directory being
+// listed again
+const MANIFEST_CACHE_TTL = 30 * time.Minute
+
+var (
+	// Manifests of directories we have already listed, keyed by the
+	// directory's full path. Elements of manifestLRU, most recently
+	// listed first, hold the cachedManifest
+	manifestCache   = make(map[string]*list.Element)
+	manifestLRU     = list.New()
+	manifestCacheMu = sync.Mutex{}
+)
+
+// Returns the cached manifest of dir if it has not expired
+func cachedDirManifest(dir string) (cachedManifest, bool) {
+	manifestCacheMu.Lock()
+	defer manifestCacheMu.Unlock()
+
+	elem, ok := manifestCache[dir]
+	if !ok {
+		return cachedManifest{}, false
+	}
+	cached := elem.Value.(cachedManifest)
+	if time.Since(cached.cachedAt) > MANIFEST_CACHE_TTL {
+		manifestLRU.Remove(elem)
+		delete(manifestCache, dir)
+		return cachedManifest{}, false
+	}
+	return cached, true
+}
+
+// Caches the manifest of dir, dropping the least recently listed ones
+// past MANIFEST_CACHE_SIZE
+func cacheDirManifest(cached cachedManifest) {
+	manifestCacheMu.Lock()
+	defer manifestCacheMu.Unlock()
+
+	cached.cachedAt = time.Now()
+	elem, ok := manifestCache[cached.dir]
+	if ok {
+		elem.Value = cached
+		manifestLRU.MoveToFront(elem)
+	} else {
+		manifestCache[cached.dir] = manifestLRU.PushFront(cached)
+	}
+	for manifestLRU.Len() > MANIFEST_CACHE_SIZE {
+		oldest := manifestLRU.Back()
+		manifestLRU.Remove(oldest)
+		delete(manifestCache, oldest.Value.(cachedManifest).dir)
+	}
+}
+
+// Lists the files and directories directly inside dir along with
+// the hashes of the files.
+//
+// The listing is re-used for as long as the directory's mtime stays
+// the same. Writing to a file does not change its parent's mtime so
+// a file is still re-hashed whenever its own size or mtime changes.
+func dirManifest(dir string) ([]manifestItem, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, ok := cachedDirManifest(dir)
+
+	previous := make(map[string]manifestItem)
+	for _, item := range cached.items {
+		previous[item.name] = item
+	}
+
+	var names []string
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		for _, item := range cached.items {
+			names = append(names, item.name)
+		}
+	} else {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+	}
+
+	items := []manifestItem{}
+	for _, name := range names {
+		fileInfo, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		item := manifestItem{
+			name:    name,
+			size:    fileInfo.Size(),
+			modTime: fileInfo.ModTime(),
+			mode:    fileInfo.Mode(),
+			owner:   lib.GetOwner(filepath.Join(realpath, relativePath(filepath.Join(dir, name)))),
+		}
+
+		switch {
+		case fileInfo.IsDir():
+			item.size = 0
+
+		case fileInfo.Mode().IsRegular():
+			old, ok := previous[name]
+			if ok && old.size == item.size && old.modTime.Equal(item.modTime) {
+				item.hash = old.hash
+				break
+			}
+
+			item.hash, err = hashPath(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+
+		default:
+			// Skip symlinks and special files
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	cacheDirManifest(cachedManifest{
+		dir:     dir,
+		modTime: info.ModTime(),
+		items:   items,
+	})
+
+	return items, nil
+}
+
+func hashPath(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return indexedFileHash(file, path)
+}
+
+// Streams the manifest of directory dir to the client.
+// relDir is the wire path of dir as seen by the client
+func sendManifest(stream grpc.ServerStreamingServer[proto.ManifestEntry], dir, relDir string, recursive bool, hidden func(path string) bool) error {
+	items, err := dirManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		entryPath := path.Join(relDir, item.name)
+		if hidden(entryPath) {
+			continue
+		}
+
+		err := stream.Send(&proto.ManifestEntry{
+			Path:  entryPath,
+			Size:  uint64(item.size),
+			MTime: timestamppb.New(item.modTime),
+			Hash:  item.hash,
+			Mode:  uint32(item.mode),
+			Owner: item.owner,
+		})
+		if err != nil {
+			return err
+		}
+
+		if recursive && item.mode.IsDir() {
+			err := sendManifest(stream, filepath.Join(dir, item.name), entryPath, recursive, hidden)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Starts the test with an empty manifest cache
+func useTestManifestCache(t *testing.T) {
+	manifestCacheMu.Lock()
+	oldCache, oldLRU := manifestCache, manifestLRU
+	manifestCache, manifestLRU = make(map[string]*list.Element), list.New()
+	manifestCacheMu.Unlock()
+	t.Cleanup(func() {
+		manifestCacheMu.Lock()
+		manifestCache, manifestLRU = oldCache, oldLRU
+		manifestCacheMu.Unlock()
+	})
+}
+
+func TestManifestCacheEvictsLeastRecent(t *testing.T) {
+	useTestManifestCache(t)
+
+	for i := range MANIFEST_CACHE_SIZE + 10 {
+		cacheDirManifest(cachedManifest{dir: "/dir" + strconv.Itoa(i)})
+		if i == MANIFEST_CACHE_SIZE {
+			// Listed again; stays while the ones before it go
+			cacheDirManifest(cachedManifest{dir: "/dir1"})
+		}
+	}
+	if n := len(manifestCache); n != MANIFEST_CACHE_SIZE {
+		t.Errorf("cache holds %v manifests; want %v", n, MANIFEST_CACHE_SIZE)
+	}
+	if _, ok := cachedDirManifest("/dir0"); ok {
+		t.Error("least recently listed manifest kept")
+	}
+	if _, ok := cachedDirManifest("/dir1"); !ok {
+		t.Error("manifest listed again dropped")
+	}
+	last := "/dir" + strconv.Itoa(MANIFEST_CACHE_SIZE+9)
+	if _, ok := cachedDirManifest(last); !ok {
+		t.Error("most recently listed manifest dropped")
+	}
+}
+
+func TestManifestCacheExpires(t *testing.T) {
+	useTestManifestCache(t)
+
+	cacheDirManifest(cachedManifest{dir: "/dir"})
+	manifestCacheMu.Lock()
+	elem := manifestCache["/dir"]
+	cached := elem.Value.(cachedManifest)
+	cached.cachedAt = time.Now().Add(-MANIFEST_CACHE_TTL - time.Second)
+	elem.Value = cached
+	manifestCacheMu.Unlock()
+
+	if _, ok := cachedDirManifest("/dir"); ok {
+		t.Error("expired manifest returned")
+	}
+	if n := len(manifestCache); n != 0 {
+		t.Errorf("cache holds %v manifests after expiry; want 0", n)
+	}
+}
+
+// Fetches the recursive manifest of path and returns its files' hashes
+// keyed by path
+func manifestHashes(t *testing.T, client proto.FuseClient, ctx context.Context, path string) map[string]string {
+	t.Helper()
+	stream, err := client.GetManifest(ctx, &proto.ManifestRequest{Path: path, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(map[string]string)
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			return hashes
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if os.FileMode(entry.Mode).IsRegular() {
+			hashes[filepath.Clean("/"+entry.Path)] = entry.Hash
+		}
+	}
+}
+
+func md5Hex(data string) string {
+	digest := md5.Sum([]byte(data))
+	return hex.EncodeToString(digest[:])
+}
+
+// The manifest carries each file's actual hash and picks up a change
+// to a file even though its directory is unchanged
+func TestManifestMatchesFileHashes(t *testing.T) {
+	useTestManifestCache(t)
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name)
+	err := os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	files := map[string]string{"a": "first", "sub/b": "second"}
+	for path, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, path), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := manifestHashes(t, client, ctx, "/"+name)
+	for path, contents := range files {
+		full := "/" + name + "/" + path
+		if got[full] != md5Hex(contents) {
+			t.Errorf("hash of %v = %q; want %q", full, got[full], md5Hex(contents))
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "a"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "a"), later, later)
+
+	got = manifestHashes(t, client, ctx, "/"+name)
+	if want := md5Hex("changed"); got["/"+name+"/a"] != want {
+		t.Errorf("hash of changed file = %q; want %q", got["/"+name+"/a"], want)
+	}
+}
+
+// Listings and manifests name files with forward slashes whatever
+// form the requested path took, and a backslash stays part of a name
+func TestWirePathsInListings(t *testing.T) {
+	useTestManifestCache(t)
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name)
+	err := os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	files := map[string]string{`back\slash`: "first", "sub/c": "second"}
+	for path, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, path), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, requested := range []string{"/" + name, name + "//", "/" + name + "/./sub/.."} {
+		res, err := client.ReadDirAll(ctx, &proto.DirEntry{Path: requested})
+		if err != nil {
+			t.Fatalf("ReadDirAll %q failed; %v", requested, err)
+		}
+		listed := make(map[string]bool)
+		for _, entry := range res.Entries {
+			listed[entry.Path] = true
+		}
+		for _, want := range []string{"/" + name + `/back\slash`, "/" + name + "/sub"} {
+			if !listed[want] {
+				t.Errorf("ReadDirAll %q listed %v; want %v among them", requested, listed, want)
+			}
+		}
+
+		got := manifestHashes(t, client, ctx, requested)
+		for path, contents := range files {
+			full := "/" + name + "/" + path
+			if got[full] != md5Hex(contents) {
+				t.Errorf("manifest of %q has %v; want %v with hash %q", requested, got, full, md5Hex(contents))
+			}
+		}
+	}
+}
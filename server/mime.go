@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// Most entries kept by the MIME cache before it is emptied
+const MAX_MIME_CACHE = 10_000
+
+type mimeCacheEntry struct {
+	mTime    syscall.Timespec
+	size     int64
+	mimeType string
+}
+
+var (
+	// MIME types of files keyed by path. An entry is only valid while
+	// the file keeps the mtime and size it was detected at
+	mimeCache   = make(map[string]mimeCacheEntry)
+	mimeCacheMu = sync.Mutex{}
+)
+
+// Returns the MIME type of the file at path, which stat describes.
+// Detection looks at the first 512 bytes of the file and falls back
+// on its extension. Directories and special files have none
+func detectMime(path string, stat *syscall.Stat_t) string {
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		return ""
+	}
+
+	mimeCacheMu.Lock()
+	cached, ok := mimeCache[path]
+	mimeCacheMu.Unlock()
+	if ok && cached.mTime == stat.Mtim && cached.size == stat.Size {
+		return cached.mimeType
+	}
+
+	mimeType := sniffMime(path)
+
+	mimeCacheMu.Lock()
+	if len(mimeCache) >= MAX_MIME_CACHE {
+		clear(mimeCache)
+	}
+	mimeCache[path] = mimeCacheEntry{
+		mTime:    stat.Mtim,
+		size:     stat.Size,
+		mimeType: mimeType,
+	}
+	mimeCacheMu.Unlock()
+
+	return mimeType
+}
+
+func sniffMime(path string) string {
+	// DetectContentType falls back to this when it can't tell
+	const UNKNOWN = "application/octet-stream"
+
+	detected := UNKNOWN
+	file, err := os.Open(path)
+	if err == nil {
+		buf := make([]byte, 512)
+		n, _ := io.ReadFull(file, buf)
+		file.Close()
+		if n > 0 {
+			detected = http.DetectContentType(buf[:n])
+		}
+	}
+
+	// Content sniffing can't tell text formats like JSON or CSV apart
+	// from plain text; the extension knows better
+	if detected == UNKNOWN || detected == "text/plain; charset=utf-8" {
+		byExt := mime.TypeByExtension(filepath.Ext(path))
+		if byExt != "" {
+			return byExt
+		}
+	}
+	return detected
+}
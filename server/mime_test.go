@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+var mimeFiles = map[string]struct {
+	contents string
+	want     string
+}{
+	"image.png": {"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+	"doc.pdf":   {"%PDF-1.4\n%\xe2\xe3\xcf\xd3\n", "application/pdf"},
+	"notes.txt": {"plain text notes\n", "text/plain; charset=utf-8"},
+	"data.json": {`{"key": "value"}`, "application/json"},
+}
+
+// Common types are told apart by content, and text formats by their
+// extension; directories have no type
+func TestMimeTypes(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name)
+	err := os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for file, test := range mimeFiles {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(test.contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := client.ReadDirAll(ctx, &proto.DirEntry{Path: "/" + name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != len(mimeFiles)+1 {
+		t.Fatalf("listed %v entries; want %v", len(res.Entries), len(mimeFiles)+1)
+	}
+	for _, entry := range res.Entries {
+		want := mimeFiles[path.Base(entry.Path)].want
+		if entry.Mime != want {
+			t.Errorf("ReadDirAll %v mime = %q; want %q", entry.Path, entry.Mime, want)
+		}
+	}
+
+	for file, test := range mimeFiles {
+		entry, err := client.Lookup(ctx, &proto.LookupRequest{Path: "/" + name + "/" + file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Mime != test.want || entry.Attr.Mime != test.want {
+			t.Errorf("Lookup %v mime = %q, %q; want %q", file, entry.Mime, entry.Attr.Mime, test.want)
+		}
+		attr, err := client.Getattr(ctx, &proto.DirEntry{Path: "/" + name + "/" + file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attr.Mime != test.want {
+			t.Errorf("Getattr %v mime = %q; want %q", file, attr.Mime, test.want)
+		}
+	}
+}
+
+// A file rewritten with other contents is detected again rather than
+// served from the cache
+func TestMimeCacheFollowsChanges(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	detect := func() string {
+		t.Helper()
+		stat := syscall.Stat_t{}
+		err := syscall.Stat(file, &stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return detectMime(file, &stat)
+	}
+
+	err := os.WriteFile(file, []byte(mimeFiles["image.png"].contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := detect(); got != "image/png" {
+		t.Fatalf("mime = %q; want image/png", got)
+	}
+
+	err = os.WriteFile(file, []byte(strings.Repeat("text", 4)), 0644)
+	if err == nil {
+		later := time.Now().Add(time.Minute)
+		err = os.Chtimes(file, later, later)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := detect(); got != "text/plain; charset=utf-8" {
+		t.Errorf("mime after rewrite = %q; want text/plain", got)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func modeOf(t *testing.T, path string) uint32 {
+	t.Helper()
+	stat := syscall.Stat_t{}
+	err := syscall.Lstat(path, &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat.Mode & 07777
+}
+
+// A shared directory made setgid through the mount or over GRPC keeps
+// the bit on the backing store, whatever the umask
+func TestMkdirKeepsSetgid(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	oldUmask := syscall.Umask(027)
+	t.Cleanup(func() { syscall.Umask(oldUmask) })
+
+	_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/shared", Mode: syscall.S_IFDIR | 02770})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "shared")) })
+	if got := modeOf(t, filepath.Join(dir, "shared")); got != 02770 {
+		t.Errorf("directory made over GRPC has mode %o; want 2770", got)
+	}
+
+	local := t.TempDir()
+	raw := fs.NewNodeFS(&Node{path: local}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	code := raw.Mkdir(nil, &fuse.MkdirIn{InHeader: header, Mode: 03775}, "shared", &fuse.EntryOut{})
+	if !code.Ok() {
+		t.Fatalf("Mkdir = %v", code)
+	}
+	if got := modeOf(t, filepath.Join(local, "shared")); got != 03775 {
+		t.Errorf("directory made through the mount has mode %o; want 3775", got)
+	}
+}
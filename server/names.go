@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// How names that only differ in case are handled. Clients on case
+// insensitive filesystems (macOS, Windows) treat File.txt and
+// file.txt as the same file; letting both exist on remote corrupts
+// their copy
+const (
+	NAMES_EXACT  = "exact"  // names are taken as is
+	NAMES_REJECT = "reject" // refuse names colliding with an existing one
+	NAMES_MERGE  = "merge"  // map colliding names onto the existing one
+)
+
+// Longest path the kernel accepts, terminating NUL included
+const PATH_MAX = 4096
+
+var (
+	// Longest file name the backing filesystem accepts
+	maxNameLen     int
+	maxNameLenOnce sync.Once
+)
+
+// Rejects paths too long for the backing filesystem before anything
+// touches the disk
+func checkNameLength(usersDir, path string) error {
+	maxNameLenOnce.Do(func() {
+		maxNameLen = 255
+		stat := syscall.Statfs_t{}
+		err := syscall.Statfs(realpath, &stat)
+		if err == nil && stat.Namelen > 0 {
+			maxNameLen = int(stat.Namelen)
+		}
+	})
+
+	for _, name := range strings.Split(path, "/") {
+		if len(name) > maxNameLen {
+			return status.Errorf(codes.InvalidArgument, "file name %.32q... is %v bytes long; the limit is %v", name, len(name), maxNameLen)
+		}
+	}
+
+	// Requests reach the disk through both the mount and realpath
+	prefix := max(len(realpath), len(mountpoint))
+	length := prefix + len(filepath.Join("/", usersDir, path))
+	if length >= PATH_MAX {
+		return status.Errorf(codes.InvalidArgument, "path %v is too long; full paths are limited to %v bytes", path, PATH_MAX-1-prefix)
+	}
+	return nil
+}
+
+var (
+	namePolicy     string
+	orgNamePolicy  = make(map[string]string)
+	namePolicyList = []string{NAMES_EXACT, NAMES_REJECT, NAMES_MERGE}
+)
+
+func validNamePolicy(policy string) bool {
+	for _, p := range namePolicyList {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses policies in the format org1=reject,org2=merge
+func parseOrgNamePolicies(value string) (map[string]string, error) {
+	policies := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return policies, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		org, policy, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || !validNamePolicy(policy) {
+			return nil, fmt.Errorf("expected org=%v but got %q", strings.Join(namePolicyList, "|"), entry)
+		}
+		policies[org] = policy
+	}
+	return policies, nil
+}
+
+func namePolicyFor(orgName string) string {
+	policy, ok := orgNamePolicy[orgName]
+	if ok {
+		return policy
+	}
+	return namePolicy
+}
+
+// Returns the name path should be created under. Unless the org takes
+// names as is, path is normalized to NFC and checked against the
+// entries of its directory that only differ in case
+func resolveName(ctx context.Context, usersDir, path string) (string, error) {
+	err := checkNameLength(usersDir, path)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := currentUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	policy := namePolicyFor(user.OrgName)
+	if policy == NAMES_EXACT {
+		return path, nil
+	}
+
+	path = norm.NFC.String(path)
+	dir, name := filepath.Split(path)
+
+	entries, err := os.ReadDir(filepath.Join(realpath, usersDir, dir))
+	if err != nil {
+		// Parent is missing; whatever creates path reports that
+		return path, nil
+	}
+
+	for _, entry := range entries {
+		existing := entry.Name()
+		if existing == name || !strings.EqualFold(norm.NFC.String(existing), name) {
+			continue
+		}
+
+		if policy == NAMES_MERGE {
+			return filepath.Join(dir, existing), nil
+		}
+		return "", status.Errorf(codes.AlreadyExists, "%v differs only in case from existing %v", name, existing)
+	}
+	return path, nil
+}
+
+// Under NAMES_MERGE, maps every name in path onto the entry of its
+// directory that only differs from it in case, so requests after a
+// merged create reach the file it was merged onto. Names without such
+// an entry are left as they are. Other policies take path as is
+func mergeNames(user *db.User, usersDir, path string) string {
+	if namePolicyFor(user.OrgName) != NAMES_MERGE {
+		return path
+	}
+
+	root := filepath.Join(realpath, usersDir)
+	merged := "/"
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		merged = filepath.Join(merged, mergedName(filepath.Join(root, merged), name))
+	}
+	return merged
+}
+
+// Returns the entry of dir that name is merged onto; name itself if
+// it exists or nothing only differs from it in case
+func mergedName(dir, name string) string {
+	_, err := os.Lstat(filepath.Join(dir, name))
+	if err == nil {
+		return name
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return name
+	}
+	normalized := norm.NFC.String(name)
+	for _, entry := range entries {
+		if strings.EqualFold(norm.NFC.String(entry.Name()), normalized) {
+			return entry.Name()
+		}
+	}
+	return name
+}
+
+// Symlinks keep the exact target they were created with. Remote
+// reaches files through the kernel though, which follows them, so a
+// target leading out of the user's directory would hand out the
+// server's own files. Those are refused; the link stays on the client.
+//
+// Leading ".." are resolved against the real parent directory of the
+// link. Any later ".." could follow another symlink first so they are
+// refused outright. Targets leaving the directory fail with EPERM
+func checkSymlinkTarget(root, linkPath, target string) error {
+	if target == "" || filepath.IsAbs(target) {
+		return status.Errorf(codes.InvalidArgument, "symlink target %q must be a relative path", target)
+	}
+
+	ups := 0
+	named := false
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if named {
+				return status.Errorf(codes.InvalidArgument, "symlink target %q may only use .. at its start", target)
+			}
+			ups++
+		default:
+			named = true
+		}
+	}
+	if ups == 0 {
+		return nil
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(filepath.Join(root, linkPath)))
+	if err != nil {
+		return lib.StatusError(err)
+	}
+	rel, err := filepath.Rel(realRoot, parent)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return lib.StatusError(&os.PathError{Op: "symlink", Path: linkPath, Err: syscall.EPERM})
+	}
+
+	depth := 0
+	if rel != "." {
+		depth = strings.Count(rel, "/") + 1
+	}
+	if ups > depth {
+		return lib.StatusError(&os.PathError{Op: "symlink", Path: target, Err: syscall.EPERM})
+	}
+	return nil
+}
+
+// Most symlinks followed resolving one path, same as Linux
+const MAX_SYMLINK_HOPS = 40
+
+// Follows the symlinks along path, relative to root, the way the
+// kernel would, without letting it. Fails with ELOOP after
+// MAX_SYMLINK_HOPS symlinks and with EPERM once a symlink leads out of
+// root, eg. one planted before checkSymlinkTarget existed or reached
+// through another symlink. A symlink in the last component is only
+// followed with followLast. From the first component that doesn't
+// exist on the rest of path is taken as is.
+//
+// Every directory passed through on the way, and where path ends up,
+// is also checked with hidden, given its path relative to root, so
+// that path can't pass through another user's personal directory
+// either. Failing that is EPERM too. The walk only tells where path
+// leads now; opening it with openInDir keeps that from changing
+// before the file is reached
+func checkSymlinks(root, path string, followLast bool, hidden func(path string) bool) error {
+	parts := strings.Split(path, "/")
+	resolved := []string{}
+	hops := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return &os.PathError{Op: "resolve", Path: path, Err: syscall.EPERM}
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		if len(parts) == 0 && !followLast {
+			resolved = append(resolved, part)
+			break
+		}
+
+		current := filepath.Join(root, filepath.Join(resolved...), part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			resolved = append(resolved, part)
+			resolved = append(resolved, parts...)
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			if hidden("/" + filepath.Join(resolved...)) {
+				return &os.PathError{Op: "resolve", Path: path, Err: syscall.EPERM}
+			}
+			continue
+		}
+
+		hops++
+		if hops > MAX_SYMLINK_HOPS {
+			return &os.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(current)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(target) {
+			rel, err := filepath.Rel(root, target)
+			if err != nil || !filepath.IsLocal(rel) {
+				return &os.PathError{Op: "resolve", Path: path, Err: syscall.EPERM}
+			}
+			resolved = resolved[:0]
+			target = rel
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	if hidden("/" + filepath.Join(resolved...)) {
+		return &os.PathError{Op: "resolve", Path: path, Err: syscall.EPERM}
+	}
+	return nil
+}
+
+// Opens fullpath, inside the user's directory dir, like os.OpenFile.
+// checkSymlinks looked at the request's paths before it ran; here the
+// kernel keeps the open itself inside dir, so a symlink swapped in
+// since, or created by another client in between, can't lead out.
+// Leaving dir fails with EPERM as in checkSymlinks, as does a file
+// that turns out to be in another user's personal directory
+func openInDir(ctx context.Context, dir, fullpath string, flags int, mode uint32) (*os.File, error) {
+	rel, err := filepath.Rel(dir, fullpath)
+	if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+		return nil, &os.PathError{Op: "open", Path: fullpath, Err: syscall.EPERM}
+	}
+	file, err := lib.OpenBeneath(dir, rel, flags, mode)
+	if errors.Is(err, syscall.EXDEV) {
+		return nil, &os.PathError{Op: "open", Path: fullpath, Err: syscall.EPERM}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hidden, err := resolvesHidden(ctx, dir, file)
+	if err != nil || hidden {
+		file.Close()
+		return nil, &os.PathError{Op: "open", Path: fullpath, Err: syscall.EPERM}
+	}
+	return file, nil
+}
+
+// Reports whether file, opened inside dir, is in another user's
+// personal directory, going by where the kernel resolved it to
+func resolvesHidden(ctx context.Context, dir string, file *os.File) (bool, error) {
+	user, err := currentUser(ctx)
+	if err != nil || requestScope(ctx) != SCOPE_SHARED {
+		return false, nil
+	}
+	rel, err := resolvedPath(dir, file)
+	if err != nil {
+		return false, err
+	}
+	return inOthersPersonalDir(user, rel), nil
+}
+
+// Returns the path the kernel resolved file, opened inside dir, to
+// relative to dir
+func resolvedPath(dir string, file *os.File) (string, error) {
+	resolved, err := lib.FilePath(file)
+	if err != nil {
+		return "", err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(realDir, resolved)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Puts the test user's org under NAMES_MERGE and gives it Docs/Report.txt
+func mergeFixture(t *testing.T) string {
+	t.Helper()
+	return namesFixture(t, NAMES_MERGE)
+}
+
+// Puts the test user's org under policy and gives it Docs/Report.txt
+func namesFixture(t *testing.T, policy string) string {
+	t.Helper()
+	old, had := orgNamePolicy[testUser.OrgName]
+	orgNamePolicy[testUser.OrgName] = policy
+	t.Cleanup(func() {
+		if had {
+			orgNamePolicy[testUser.OrgName] = old
+		} else {
+			delete(orgNamePolicy, testUser.OrgName)
+		}
+	})
+
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, "Docs")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	err = os.WriteFile(filepath.Join(dir, "Report.txt"), []byte("report"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMergeNames(t *testing.T) {
+	mergeFixture(t)
+	usersDir := filepath.Join(testUser.OrgName, testUser.DeptName)
+
+	tests := map[string]string{
+		"/docs/report.TXT": "/Docs/Report.txt",
+		"/DOCS/new.txt":    "/Docs/new.txt",
+		"/Docs/Report.txt": "/Docs/Report.txt",
+		"/other/report":    "/other/report",
+	}
+	for path, want := range tests {
+		if got := mergeNames(&testUser, usersDir, path); got != want {
+			t.Errorf("mergeNames(%q) = %q; want %q", path, got, want)
+		}
+	}
+}
+
+// Requests other than creates reach the file a name was merged onto,
+// and a create merged onto an existing file doesn't count against the
+// inode quota
+func TestMergedNamesResolveEverywhere(t *testing.T) {
+	dir := mergeFixture(t)
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+
+	_, err := client.Getattr(ctx, &proto.DirEntry{Path: "/docs/REPORT.txt"})
+	if err != nil {
+		t.Errorf("Getattr of a merged name = %v", err)
+	}
+
+	old := inodeQuota
+	inodeQuota = int(countInodes(testUser.OrgName))
+	t.Cleanup(func() { inodeQuota = old })
+	_, err = client.Create(ctx, &proto.CreateRequest{
+		Path:  "/docs/report.TXT",
+		Flags: syscall.O_WRONLY | syscall.O_CREAT,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Errorf("Create merged onto an existing file = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Docs has %v entries; want Report.txt only", len(entries))
+	}
+}
+
+// Creating a name that only differs in case from an existing one, and
+// one spelled with a combining accent, under each policy
+func TestNamePolicies(t *testing.T) {
+	tests := []struct {
+		policy  string
+		code    codes.Code
+		entries []string
+	}{
+		{NAMES_EXACT, codes.OK, []string{"Cafe\u0301", "Report.txt", "report.TXT"}},
+		{NAMES_REJECT, codes.AlreadyExists, []string{"Café", "Report.txt"}},
+		{NAMES_MERGE, codes.OK, []string{"Café", "Report.txt"}},
+	}
+	for _, test := range tests {
+		dir := namesFixture(t, test.policy)
+		client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+
+		_, err := client.Create(ctx, &proto.CreateRequest{
+			Path:  "/Docs/report.TXT",
+			Flags: syscall.O_WRONLY | syscall.O_CREAT,
+			Mode:  0644,
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("%v: create of a case-colliding name = %v; want %v", test.policy, err, test.code)
+		}
+		_, err = client.Create(ctx, &proto.CreateRequest{
+			Path:  "/Docs/Cafe\u0301",
+			Flags: syscall.O_WRONLY | syscall.O_CREAT,
+			Mode:  0644,
+		})
+		if err != nil {
+			t.Errorf("%v: create of an unnormalized name = %v", test.policy, err)
+		}
+
+		entries, _ := os.ReadDir(dir)
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if !slices.Equal(names, test.entries) {
+			t.Errorf("%v: Docs holds %q; want %q", test.policy, names, test.entries)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// Names and paths longer than the backing filesystem takes are refused
+// as invalid before anything reaches the disk
+func TestOverlongNamesRefused(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	err := os.WriteFile(filepath.Join(dir, "existing"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "existing")) })
+
+	longName := "/" + strings.Repeat("n", 256)
+	longPath := strings.Repeat("/"+strings.Repeat("p", 200), PATH_MAX/201+1)
+	for _, path := range []string{longName, longPath} {
+		calls := map[string]func() error{
+			"Create": func() error {
+				_, err := client.Create(ctx, &proto.CreateRequest{Path: path, Flags: uint32(os.O_CREATE | os.O_WRONLY), Mode: 0644})
+				return err
+			},
+			"Mkdir": func() error {
+				_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: path, Mode: 0755})
+				return err
+			},
+			"Rename": func() error {
+				_, err := client.Rename(ctx, &proto.RenameRequest{OldPath: "/existing", NewPath: path})
+				return err
+			},
+			"Link": func() error {
+				_, err := client.Link(ctx, &proto.LinkRequest{OldPath: "/existing", NewPath: path})
+				return err
+			},
+			"Symlink": func() error {
+				_, err := client.Symlink(ctx, &proto.LinkRequest{OldPath: "existing", NewPath: path})
+				return err
+			},
+		}
+		for name, call := range calls {
+			err := call()
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("%v of %v byte path = %v; want InvalidArgument", name, len(path), err)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "nnn") || strings.HasPrefix(entry.Name(), "ppp") {
+			t.Errorf("refused name %.16v... reached the disk", entry.Name())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "existing")); err != nil {
+		t.Errorf("renamed away by a refused Rename; %v", err)
+	}
+
+	// The longest name allowed still works
+	name := "/" + strings.Repeat("n", 255)
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: name, Mode: 0755})
+	if err != nil {
+		t.Errorf("Mkdir of 255 byte name = %v", err)
+	}
+	os.Remove(filepath.Join(dir, name))
+}
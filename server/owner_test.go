@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Whoever creates a file through the server is recorded as its owner
+// and every other client learns it from the manifest
+func TestManifestCarriesCreator(t *testing.T) {
+	useTestManifestCache(t)
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	t.Cleanup(func() { os.RemoveAll(filepath.Join(dir, name)) })
+
+	_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/" + name, Mode: 0755})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.GetOwner(filepath.Join(dir, name)) == "" {
+		t.Skip("no user extended attributes in ", dir)
+	}
+
+	stream, err := client.GetManifest(ctx, &proto.ManifestRequest{Path: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			t.Fatalf("%v missing from manifest", name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(entry.Path) != name {
+			continue
+		}
+		if entry.Owner != testUser.Email {
+			t.Errorf("owner = %q; want %q", entry.Owner, testUser.Email)
+		}
+		return
+	}
+}
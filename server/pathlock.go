@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Writes, truncates, replaces and restores of the same file are
+// applied one at a time, in the order the server gets to them. A
+// truncate never lands halfway through a Write and a Write arriving
+// after a truncate sees the truncated file. Different files don't
+// wait on each other
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// A lock per path, made when first locked and dropped once nobody
+// holds or waits on it
+type pathLocker struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+var (
+	pathLocks = pathLocker{locks: make(map[string]*pathLock)}
+
+	// Guards taking, pruning and restoring the versions of each file
+	versionLocks = pathLocker{locks: make(map[string]*pathLock)}
+)
+
+// Locks path, relative to realpath, and returns the function that
+// unlocks it
+func lockPath(path string) func() {
+	return pathLocks.lock(path)
+}
+
+// Locks the versions of path, relative to realpath, and returns the
+// function that unlocks them
+func lockVersions(path string) func() {
+	return versionLocks.lock(path)
+}
+
+func (l *pathLocker) lock(path string) func() {
+	path = filepath.Clean("/" + path)
+
+	l.mu.Lock()
+	lock, ok := l.locks[path]
+	if !ok {
+		lock = &pathLock{}
+		l.locks[path] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, path)
+		}
+	}
+}
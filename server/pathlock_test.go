@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// Truncates racing whole-file writes leave either an empty file or one
+// write's data in full, never a write cut short by a truncate
+func TestConcurrentTruncatesAndWrites(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	path := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, "racing")
+	err := os.WriteFile(path, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+
+	const size = 1024 * 1024
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte('a' + i)}, size)
+			_, err := client.Write(ctx, &proto.WriteRequest{Path: "/racing", Data: data})
+			if err != nil {
+				t.Errorf("write %v = %v; want nil", i, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var zero uint64
+			_, err := client.Setattr(ctx, &proto.SetattrRequest{Path: "/racing", Size: &zero})
+			if err != nil {
+				t.Errorf("truncate %v = %v; want nil", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case len(got) == 0:
+	case len(got) != size:
+		t.Errorf("file size = %v; want 0 or %v", len(got), size)
+	case bytes.Count(got, got[:1]) != size:
+		t.Errorf("file holds %v bytes of %q; want all %v", bytes.Count(got, got[:1]), got[0], size)
+	}
+
+	pathLocks.mu.Lock()
+	defer pathLocks.mu.Unlock()
+	if len(pathLocks.locks) != 0 {
+		t.Errorf("path locks held after the requests = %v; want none", len(pathLocks.locks))
+	}
+}
+
+// Locking one path blocks others on it but not on a different path
+func TestLockPathPerPath(t *testing.T) {
+	unlock := lockPath("/a")
+
+	done := make(chan struct{})
+	go func() {
+		lockPath("b")()
+		close(done)
+	}()
+	<-done
+
+	locked := make(chan struct{})
+	go func() {
+		lockPath("/a/../a")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("second lock of /a taken while the first is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Users can rename themselves and move their account to a new email.
+// Usernames name personal directories so they are unique within a
+// department. A new email only replaces the old one once the OTP sent
+// to it comes back through ConfirmEmail.
+//
+// Tokens carry the user as they were when issued, so every change
+// hands out a new token and bumps the user's token version, which
+// refuses the older ones
+
+// How long the OTP sent to a new email stays valid
+const EMAIL_CHANGE_TTL = 15 * time.Minute
+
+// Wrong OTPs a pending email change survives. The change is dropped
+// after that and has to be requested again
+const MAX_EMAIL_CHANGE_ATTEMPTS = 5
+
+var emailChanges *db.EmailChangeModel
+
+var (
+	errInvalidProfile = errors.New("invalid profile")
+	errUsernameTaken  = errors.New("username is taken by another member of your department")
+	errEmailTaken     = errors.New("email is already in use")
+	errInvalidOtp     = errors.New("invalid or expired OTP")
+)
+
+type profile struct {
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	OrgName      string `json:"org_name"`
+	DeptName     string `json:"dept_name"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+func (p profile) proto() *proto.Profile {
+	return &proto.Profile{
+		Username:     p.Username,
+		Email:        p.Email,
+		OrgName:      p.OrgName,
+		DeptName:     p.DeptName,
+		PendingEmail: p.PendingEmail,
+	}
+}
+
+// Returns user as currently stored. The user in a token may be out of
+// date
+func loadProfile(user *db.User) (*db.User, profile, error) {
+	current, err := users.Get(user.Email)
+	if err != nil {
+		return nil, profile{}, err
+	}
+
+	p := profile{
+		Username: current.Username,
+		Email:    current.Email,
+		OrgName:  current.OrgName,
+		DeptName: current.DeptName,
+	}
+	change, err := emailChanges.Get(current.Email)
+	if err == nil {
+		p.PendingEmail = change.NewEmail
+	}
+	return current, p, nil
+}
+
+// Renames user, moving their personal directory along
+func changeUsername(user *db.User, username string) error {
+	username = strings.TrimSpace(username)
+	if username == user.Username {
+		return nil
+	}
+	if err := lib.ValidateName("username", username); err != nil {
+		return fmt.Errorf("%w; %v", errInvalidProfile, err)
+	}
+	if err := lib.ValidatePathComponent("username", username); err != nil {
+		return fmt.Errorf("%w; %v", errInvalidProfile, err)
+	}
+
+	taken, err := users.UsernameTaken(user.OrgName, user.DeptName, username, user.Email)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errUsernameTaken
+	}
+
+	renamed := *user
+	renamed.Username = username
+	oldDir := filepath.Join(realpath, personalDir(user))
+	newDir := filepath.Join(realpath, personalDir(&renamed))
+	moved := false
+	if dirExists(oldDir) {
+		if _, err := os.Lstat(newDir); err == nil {
+			// Left behind by someone who had the name before
+			return errUsernameTaken
+		}
+		err = os.Rename(oldDir, newDir)
+		if err != nil {
+			return err
+		}
+		moved = true
+	}
+
+	_, err = users.UpdateUsername(user.Email, username)
+	if err != nil {
+		if moved {
+			os.Rename(newDir, oldDir)
+		}
+		return err
+	}
+	auth.ForgetTokenVersion(user.Email)
+	user.Username = username
+	user.TokenVersion++
+	return nil
+}
+
+// Sends an OTP to newEmail. The account moves over once it is
+// confirmed with confirmEmailChange
+func requestEmailChange(user *db.User, newEmail string) error {
+	newEmail = strings.TrimSpace(newEmail)
+	if newEmail == user.Email {
+		return nil
+	}
+	if err := lib.ValidateEmail(newEmail); err != nil {
+		return fmt.Errorf("%w; %v", errInvalidProfile, err)
+	}
+
+	taken, err := users.Exists(newEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errEmailTaken
+	}
+
+	change := db.NewEmailChange(user.Email, newEmail, EMAIL_CHANGE_TTL)
+	_, err = emailChanges.Insert(*change)
+	if err != nil {
+		return err
+	}
+
+	go func(email, otp string) {
+		sender, err := newEmailSender()
+		if err == nil {
+			subject, html := emailChangeEmail(otp)
+			err = sender.Send(email, subject, html)
+		}
+		if err != nil {
+			log.Printf("Error sending email; %v\n", err)
+		}
+	}(newEmail, change.OTP)
+	return nil
+}
+
+// Moves user to the email their pending change is for if otp matches
+func confirmEmailChange(user *db.User, otp string) error {
+	change, err := emailChanges.Get(user.Email)
+	if err == sql.ErrNoRows {
+		return errInvalidOtp
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(change.OTP), []byte(otp)) != 1 {
+		attempts, err := emailChanges.AddAttempt(user.Email)
+		if err == nil && attempts >= MAX_EMAIL_CHANGE_ATTEMPTS {
+			_, err = emailChanges.Delete(user.Email)
+		}
+		if err != nil {
+			log.Printf("Error counting OTP attempt of %v; %v\n", user.Email, err)
+		}
+		return errInvalidOtp
+	}
+
+	// Someone may have registered with it in the meantime
+	taken, err := users.Exists(change.NewEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errEmailTaken
+	}
+
+	count, err := users.UpdateEmail(user.Email, change.NewEmail)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errInvalidOtp
+	}
+	go emailChanges.Delete(user.Email)
+	auth.ForgetTokenVersion(user.Email)
+
+	// Files record their owner by email
+	go reassignOwner(filepath.Join(realpath, user.OrgName), user.Email, change.NewEmail)
+
+	user.Email = change.NewEmail
+	user.TokenVersion++
+	return nil
+}
+
+// Moves the files of dir owned by oldEmail over to newEmail
+func reassignOwner(dir, oldEmail, newEmail string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if lib.GetOwner(path) != oldEmail {
+			return nil
+		}
+		err = lib.SetOwner(path, newEmail)
+		if err != nil {
+			log.Printf("Error changing owner of %v; %v\n", relativePath(path), err)
+		}
+		return nil
+	})
+}
+
+func emailChangeEmail(otp string) (string, string) {
+	html := "<html>" +
+		"<body style='font-family: Arial, sans-serif;'>" +
+		"<h2>Confirm Your New Email</h2>" +
+		"<p>Hello, there</p>" +
+		"<p>We received a request to move your File Manager account to this email address. Use the following One-Time Password (OTP) to confirm:</p>" +
+		"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>" + otp + "</div>" +
+		"<p>This code will expire in <b>15 minutes</b>.</p>" +
+		"<p>If you didn't request this change, you can safely ignore this email.</p>" +
+		"<br>" +
+		"<p>Best regards,<br>File Manager</p>" +
+		"</body>" +
+		"</html>"
+
+	return "Confirm your new email", html
+}
+
+// Maps the errors of the functions above onto gRPC status codes
+func profileStatusError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidProfile):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errUsernameTaken), errors.Is(err, errEmailTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, errInvalidOtp):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case err == sql.ErrNoRows:
+		return status.Error(codes.NotFound, "user account not found")
+	default:
+		log.Printf("[GRPC] Error updating profile; %v\n", err)
+		return status.Error(codes.Internal, "error updating profile")
+	}
+}
+
+// Same as profileStatusError for the web server
+func profileErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidProfile):
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	case errors.Is(err, errUsernameTaken), errors.Is(err, errEmailTaken):
+		errorResponse(w, http.StatusConflict, ERR_CONFLICT, err.Error())
+	case errors.Is(err, errInvalidOtp):
+		errorResponse(w, http.StatusNotFound, ERR_INVALID_OTP, err.Error())
+	case err == sql.ErrNoRows:
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "user account not found")
+	default:
+		log.Printf("Error updating profile; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error updating profile")
+	}
+}
+
+func getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	_, p, err := loadProfile(user)
+	if err != nil {
+		profileErrorResponse(w, err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, p)
+}
+
+type updateProfileRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	var req updateProfileRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || (strings.TrimSpace(req.Username) == "" && strings.TrimSpace(req.Email) == "") {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "username or email field required")
+		return
+	}
+
+	current, _, err := loadProfile(user)
+	if err == nil && req.Username != "" {
+		err = changeUsername(current, req.Username)
+	}
+	if err == nil && req.Email != "" {
+		err = requestEmailChange(current, req.Email)
+	}
+	if err != nil {
+		profileErrorResponse(w, err)
+		return
+	}
+	profileResponse(w, current)
+}
+
+type confirmEmailRequest struct {
+	OTP string `json:"otp"`
+}
+
+func confirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	var req confirmEmailRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || strings.TrimSpace(req.OTP) == "" {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "otp field required")
+		return
+	}
+
+	current, _, err := loadProfile(user)
+	if err == nil {
+		err = confirmEmailChange(current, req.OTP)
+	}
+	if err != nil {
+		profileErrorResponse(w, err)
+		return
+	}
+	profileResponse(w, current)
+}
+
+// Responds with the profile of user and a token carrying it
+func profileResponse(w http.ResponseWriter, user *db.User) {
+	_, p, err := loadProfile(user)
+	if err != nil {
+		profileErrorResponse(w, err)
+		return
+	}
+	accessToken, err := auth.GenerateToken(*user)
+	if err != nil {
+		log.Printf("Error generating JWT; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error updating profile")
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"profile":      p,
+		"access_token": accessToken,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Bad usernames and emails are refused before the database is asked;
+// keeping the current ones changes nothing
+func TestProfileChangesValidated(t *testing.T) {
+	user := testUser
+	for _, username := range []string{"a", "..", "bob/../alice", "bad\tname", " alice "} {
+		err := changeUsername(&user, username)
+		if username == " alice " {
+			if err != nil {
+				t.Errorf("changeUsername to the current name = %v; want nil", err)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidProfile) {
+			t.Errorf("changeUsername(%q) = %v; want %v", username, err, errInvalidProfile)
+		}
+	}
+	if user.Username != testUser.Username {
+		t.Errorf("username = %v after refused changes; want %v", user.Username, testUser.Username)
+	}
+
+	for _, email := range []string{"", "alice", "alice@example"} {
+		err := requestEmailChange(&user, email)
+		if !errors.Is(err, errInvalidProfile) {
+			t.Errorf("requestEmailChange(%q) = %v; want %v", email, err, errInvalidProfile)
+		}
+	}
+	if err := requestEmailChange(&user, user.Email); err != nil {
+		t.Errorf("requestEmailChange to the current email = %v; want nil", err)
+	}
+}
+
+// A taken username or email is a conflict on both servers
+func TestProfileErrorCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+		web  int
+	}{
+		{errInvalidProfile, codes.InvalidArgument, http.StatusBadRequest},
+		{errUsernameTaken, codes.AlreadyExists, http.StatusConflict},
+		{errEmailTaken, codes.AlreadyExists, http.StatusConflict},
+		{errInvalidOtp, codes.InvalidArgument, http.StatusNotFound},
+		{sql.ErrNoRows, codes.NotFound, http.StatusNotFound},
+		{errors.New("database gone"), codes.Internal, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if code := status.Code(profileStatusError(test.err)); code != test.code {
+			t.Errorf("GRPC code for %v = %v; want %v", test.err, code, test.code)
+		}
+		w := httptest.NewRecorder()
+		profileErrorResponse(w, test.err)
+		if w.Code != test.web {
+			t.Errorf("web status for %v = %v; want %v", test.err, w.Code, test.web)
+		}
+	}
+}
+
+// PUT /me needs something to change
+func TestUpdateProfileHandlerNeedsFields(t *testing.T) {
+	for _, body := range []string{"", "{}", `{"username": " ", "email": ""}`} {
+		r := httptest.NewRequest("PUT", "/me", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), auth.USER_CTX_KEY, &testUser))
+		w := httptest.NewRecorder()
+		updateProfileHandler(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PUT /me with %q = %v; want %v", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
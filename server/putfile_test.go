@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+func TestPutFileMasksMode(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := "/" + t.Name()
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name))
+	})
+
+	_, err := client.PutFile(ctx, &proto.PutFileRequest{Path: name, Data: []byte("contents"), Mode: 06755})
+	if err != nil {
+		t.Fatalf("PutFile failed; %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name))
+	if err != nil {
+		t.Fatalf("Error reading file; %v", err)
+	}
+	if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 || info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v; want -rwxr-xr-x", info.Mode())
+	}
+}
+
+func BenchmarkPutFile(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10, lib.MAX_PUT_SIZE} {
+		b.Run(fmt.Sprintf("%vKiB", size>>10), func(b *testing.B) {
+			client, ctx := newTestClient(b, FuseServer{path: mountpoint}, testUser)
+			data := bytes.Repeat([]byte("x"), size)
+			name := fmt.Sprintf("/bench-%v", size)
+			b.Cleanup(func() {
+				os.Remove(filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name))
+			})
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := client.PutFile(ctx, &proto.PutFileRequest{Path: name, Data: data, Mode: 0644})
+				if err != nil {
+					b.Fatalf("PutFile failed; %v", err)
+				}
+			}
+		})
+	}
+}
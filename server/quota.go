@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long a counted number of inodes is trusted before the org
+// directory is walked again
+const INODE_COUNT_TTL = 10 * time.Second
+
+type inodeCount struct {
+	count     uint64
+	countedAt time.Time
+}
+
+var (
+	// Number of files and directories in each org, keyed by org name
+	inodeCounts   = make(map[string]inodeCount)
+	inodeCountsMu = sync.Mutex{}
+)
+
+// Returns the number of files and directories in org orgName
+func countInodes(orgName string) uint64 {
+	inodeCountsMu.Lock()
+	cached, ok := inodeCounts[orgName]
+	inodeCountsMu.Unlock()
+
+	if ok && time.Since(cached.countedAt) < INODE_COUNT_TTL {
+		return cached.count
+	}
+
+	var count uint64
+	orgDir := filepath.Join(realpath, orgName)
+	filepath.WalkDir(orgDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != orgDir {
+			count++
+		}
+		return nil
+	})
+
+	inodeCountsMu.Lock()
+	inodeCounts[orgName] = inodeCount{count: count, countedAt: time.Now()}
+	inodeCountsMu.Unlock()
+
+	return count
+}
+
+// Bumps the cached inode count of orgName
+func addInode(orgName string) {
+	inodeCountsMu.Lock()
+	defer inodeCountsMu.Unlock()
+
+	cached, ok := inodeCounts[orgName]
+	if ok {
+		cached.count++
+		inodeCounts[orgName] = cached
+	}
+}
+
+// An inode counted against an org's quota before the file or directory
+// it stands for exists; see checkInodeQuota
+type inodeReservation struct {
+	orgName string
+	at      time.Time
+	kept    bool
+}
+
+// Bumps the cached inode count of orgName for a file about to be
+// created, so back to back creates can't overshoot the quota
+func reserveInode(orgName string) *inodeReservation {
+	addInode(orgName)
+	return &inodeReservation{orgName: orgName, at: time.Now()}
+}
+
+// Marks the reserved inode as created. Safe on a nil reservation
+func (r *inodeReservation) keep() {
+	if r != nil {
+		r.kept = true
+	}
+}
+
+// Gives the inode back unless it was kept. Counts taken since the
+// reservation never saw it and are left alone. Safe on a nil
+// reservation
+func (r *inodeReservation) release() {
+	if r == nil || r.kept {
+		return
+	}
+
+	inodeCountsMu.Lock()
+	defer inodeCountsMu.Unlock()
+
+	cached, ok := inodeCounts[r.orgName]
+	if ok && cached.count > 0 && cached.countedAt.Before(r.at) {
+		cached.count--
+		inodeCounts[r.orgName] = cached
+	}
+}
+
+// Reports whether org orgName may create another file or directory
+func underInodeQuota(orgName string) bool {
+	if inodeQuota <= 0 {
+		return true
+	}
+	return countInodes(orgName) < uint64(inodeQuota)
+}
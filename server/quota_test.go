@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Returns the cached inode count of the test user's org
+func cachedInodes(t *testing.T) uint64 {
+	t.Helper()
+	inodeCountsMu.Lock()
+	defer inodeCountsMu.Unlock()
+	return inodeCounts[testUser.OrgName].count
+}
+
+// Creates that fail give back the inode they reserved
+func TestFailedCreateReleasesInode(t *testing.T) {
+	old := inodeQuota
+	inodeQuota = 1 << 20
+	t.Cleanup(func() { inodeQuota = old })
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+
+	countInodes(testUser.OrgName)
+	before := cachedInodes(t)
+
+	_, err := client.Mkdir(ctx, &proto.MkdirRequest{Path: "/missing/dir", Mode: 0755})
+	if err == nil {
+		t.Fatal("Mkdir under a missing directory succeeded")
+	}
+	if got := cachedInodes(t); got != before {
+		t.Errorf("inode count %v after failed Mkdir; want %v", got, before)
+	}
+
+	name := filepath.Base(t.Name())
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/" + name, Mode: 0755})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name))
+	})
+	if got := cachedInodes(t); got != before+1 {
+		t.Errorf("inode count %v after Mkdir; want %v", got, before+1)
+	}
+}
+
+// Statfs counts the org's files and directories against the quota,
+// and creates past it are refused
+func TestInodeQuota(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	inodeCountsMu.Lock()
+	delete(inodeCounts, testUser.OrgName)
+	inodeCountsMu.Unlock()
+	used := countInodes(testUser.OrgName)
+	old := inodeQuota
+	inodeQuota = int(used) + 1
+	t.Cleanup(func() { inodeQuota = old })
+
+	stat, err := client.Statfs(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Files != used || stat.Ffree != 1 || stat.Quota != used+1 {
+		t.Errorf("Statfs = %v files, %v free of %v; want %v, 1 of %v", stat.Files, stat.Ffree, stat.Quota, used, used+1)
+	}
+
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(dir, name))
+		os.Remove(filepath.Join(dir, name+".over"))
+	})
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/" + name, Mode: 0755})
+	if err != nil {
+		t.Fatalf("Mkdir within quota = %v", err)
+	}
+	_, err = client.Mkdir(ctx, &proto.MkdirRequest{Path: "/" + name + ".over", Mode: 0755})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Mkdir past quota = %v; want ResourceExhausted", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, name+".over")); err == nil {
+		t.Error("directory past quota created")
+	}
+
+	stat, err = client.Statfs(ctx, &emptypb.Empty{})
+	if err == nil && (stat.Files != used+1 || stat.Ffree != 0) {
+		t.Errorf("Statfs after Mkdir = %v files, %v free; want %v, 0", stat.Files, stat.Ffree, used+1)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Files past gRPC's 4MB default still come back whole from ReadAll,
+// and those too large for a message are refused with a reason
+func TestReadAllLargeFiles(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	limit := grpc.MaxCallRecvMsgSize(maxMsgSize * 1024 * 1024)
+
+	data := bytes.Repeat([]byte("0123456789"), 1024*1024)
+	err := os.WriteFile(filepath.Join(dir, "large"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "large")) })
+
+	res, err := client.ReadAll(ctx, &proto.DirEntry{Path: "/large"}, limit)
+	if err != nil {
+		t.Fatalf("ReadAll of %v bytes = %v", len(data), err)
+	}
+	if !bytes.Equal(res.Data, data) {
+		t.Errorf("ReadAll = %v bytes; want the %v written", len(res.Data), len(data))
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "huge"), make([]byte, maxMsgSize*1024*1024), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "huge")) })
+
+	_, err = client.ReadAll(ctx, &proto.DirEntry{Path: "/huge"}, limit)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("ReadAll past the message limit = %v; want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Replaces the contents of path with data. The data is written to a
+// temp file in the same directory which is then renamed over path, so
+// readers see either the old or the new contents and a crash part way
+// through leaves the original untouched
+func replaceFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	owner := ""
+	info, err := os.Stat(path)
+	if err == nil {
+		mode = info.Mode().Perm()
+		owner = lib.GetOwner(path)
+	}
+
+	// Leading dot keeps observers from hearing about the temp file
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if owner != "" {
+		err = lib.SetOwner(tmp.Name(), owner)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	syncParent(path)
+	return nil
+}
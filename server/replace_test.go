@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// A replacement that dies part way through writing leaves the original
+// as it was and no temp file behind. The write is cut short by a file
+// size limit, which fails it with EFBIG once SIGXFSZ is ignored
+func TestReplaceFileFailureKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	err := os.WriteFile(path, []byte("original"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limit syscall.Rlimit
+	err = syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit)
+	if err != nil {
+		t.Skip(err)
+	}
+	signal.Ignore(syscall.SIGXFSZ)
+	err = syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: 64 * 1024, Max: limit.Max})
+	if err != nil {
+		t.Skip(err)
+	}
+	err = replaceFile(path, bytes.Repeat([]byte("x"), 1024*1024))
+	syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)
+	signal.Reset(syscall.SIGXFSZ)
+	if err == nil {
+		t.Fatal("replacement larger than the file size limit succeeded")
+	}
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "original" {
+		t.Errorf("file = %q after failed replacement; want %q", got, "original")
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != "file" {
+			t.Errorf("left behind %v", entry.Name())
+		}
+	}
+}
+
+// Readers see either the old or the new contents, never a mix
+func TestReplaceFileNotTorn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	versions := []string{strings.Repeat("a", 256*1024), strings.Repeat("b", 256*1024)}
+	err := os.WriteFile(path, []byte(versions[0]), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Errorf("read during replacement = %v", err)
+				return
+			}
+			if string(got) != versions[0] && string(got) != versions[1] {
+				t.Errorf("read %v bytes of torn contents", len(got))
+				return
+			}
+		}
+	}()
+
+	for i := range 50 {
+		err := replaceFile(path, []byte(versions[(i+1)%2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Every GRPC and web request gets an id, the client's if it sent one.
+// It is logged with the request and sent back in the response headers,
+// and in the trailers of failed GRPC calls, so users can quote it in
+// bug reports. Error statuses are passed on untouched; clients read
+// their details, eg. the errno StatusError attaches
+
+type requestIdKey struct{}
+
+// What is logged of a request. The user is only known once the auth
+// interceptor has run, after the request id is taken
+type requestInfo struct {
+	id   string
+	user string
+}
+
+// Id of the request ctx belongs to
+func requestId(ctx context.Context) string {
+	info, ok := ctx.Value(requestIdKey{}).(*requestInfo)
+	if !ok {
+		return ""
+	}
+	return info.id
+}
+
+// Notes the user who made the request ctx belongs to for its log line
+func recordRequestUser(ctx context.Context) {
+	info, ok := ctx.Value(requestIdKey{}).(*requestInfo)
+	if !ok {
+		return
+	}
+	if user, err := currentUser(ctx); err == nil {
+		info.user = user.Email
+	}
+}
+
+func incomingRequestId(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(lib.REQUEST_ID_KEY)
+	if len(ids) > 0 && validRequestId(ids[0]) {
+		return ids[0]
+	}
+	return lib.NewRequestId()
+}
+
+// Ids end up in logs; keep them short and printable
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func logRequest(ctx context.Context, method string, start time.Time, err error) {
+	who := "-"
+	if info, ok := ctx.Value(requestIdKey{}).(*requestInfo); ok && info.user != "" {
+		who = info.user
+	}
+	log.Printf(
+		"[GRPC] request %v %v by %v; %v in %v\n",
+		requestId(ctx), method, who, status.Code(err), time.Since(start),
+	)
+}
+
+// Runs first so that requests failing authentication are logged too
+func RequestIdInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	id := incomingRequestId(ctx)
+	ctx = context.WithValue(ctx, requestIdKey{}, &requestInfo{id: id})
+	grpc.SetHeader(ctx, metadata.Pairs(lib.REQUEST_ID_KEY, id))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if err != nil {
+		grpc.SetTrailer(ctx, metadata.Pairs(lib.REQUEST_ID_KEY, id))
+	}
+	logRequest(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// Runs after authentication to note who the request is from
+func RequestUserInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	recordRequestUser(ctx)
+	return handler(ctx, req)
+}
+
+func RequestUserStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	recordRequestUser(ss.Context())
+	return handler(srv, ss)
+}
+
+type requestIdStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s requestIdStream) Context() context.Context {
+	return s.ctx
+}
+
+func RequestIdStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	id := incomingRequestId(ss.Context())
+	ctx := context.WithValue(ss.Context(), requestIdKey{}, &requestInfo{id: id})
+	ss.SetHeader(metadata.Pairs(lib.REQUEST_ID_KEY, id))
+
+	start := time.Now()
+	err := handler(srv, requestIdStream{ServerStream: ss, ctx: ctx})
+	if err != nil {
+		ss.SetTrailer(metadata.Pairs(lib.REQUEST_ID_KEY, id))
+	}
+	logRequest(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// Same for the web server. The id is stored where chi's Logger looks
+// for it so it shows up in the access log
+func requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(lib.REQUEST_ID_HEADER)
+		if !validRequestId(id) {
+			id = lib.NewRequestId()
+		}
+		w.Header().Set(lib.REQUEST_ID_HEADER, id)
+
+		ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+		ctx = context.WithValue(ctx, requestIdKey{}, &requestInfo{id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
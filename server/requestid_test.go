@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type failingServer struct {
+	proto.UnimplementedFuseServer
+	err error
+}
+
+func (s failingServer) Rmdir(ctx context.Context, req *proto.DirEntry) (*emptypb.Empty, error) {
+	return nil, s.err
+}
+
+func TestRequestIdInTrailer(t *testing.T) {
+	want := lib.StatusError(syscall.ENOTEMPTY)
+	client, ctx := newTestClient(t, failingServer{err: want}, testUser)
+	ctx = metadata.AppendToOutgoingContext(ctx, lib.REQUEST_ID_KEY, "test-request")
+
+	var trailer metadata.MD
+	_, err := client.Rmdir(ctx, &proto.DirEntry{Path: "/dir"}, grpc.Trailer(&trailer))
+	if err == nil {
+		t.Fatal("Rmdir succeeded; expected an error")
+	}
+
+	ids := trailer.Get(lib.REQUEST_ID_KEY)
+	if len(ids) != 1 || ids[0] != "test-request" {
+		t.Errorf("trailer request id = %v; want test-request", ids)
+	}
+
+	got := status.Convert(err)
+	if got.Code() != status.Code(want) || got.Message() != status.Convert(want).Message() {
+		t.Errorf("status = %v %q; want %v %q", got.Code(), got.Message(), status.Code(want), status.Convert(want).Message())
+	}
+	if errno := lib.StatusErrno(err); errno != syscall.ENOTEMPTY {
+		t.Errorf("StatusErrno = %v; want ENOTEMPTY", errno)
+	}
+}
+
+// Errnos reach the client however many share a gRPC code
+func TestErrnoThroughInterceptors(t *testing.T) {
+	errnos := []syscall.Errno{
+		syscall.ENOENT, syscall.EEXIST, syscall.ENOTEMPTY, syscall.ENOTDIR,
+		syscall.EISDIR, syscall.ELOOP, syscall.EROFS, syscall.EXDEV,
+		syscall.ENOSPC, syscall.EDQUOT, syscall.ENAMETOOLONG, syscall.EACCES,
+	}
+	for _, errno := range errnos {
+		t.Run(errno.Error(), func(t *testing.T) {
+			client, ctx := newTestClient(t, failingServer{err: lib.StatusError(errno)}, testUser)
+			_, err := client.Rmdir(ctx, &proto.DirEntry{Path: "/dir"})
+			if got := lib.StatusErrno(err); got != errno {
+				t.Errorf("StatusErrno = %v; want %v", got, errno)
+			}
+		})
+	}
+}
+
+// The id a client sends is the one logged with the request and sent
+// back in the headers; an id unfit for logs is replaced
+func TestRequestIdLogged(t *testing.T) {
+	client, ctx := newTestClient(t, failingServer{err: lib.StatusError(syscall.ENOTEMPTY)}, testUser)
+	output := bytes.Buffer{}
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, sent := range []string{"client-request", strings.Repeat("x", 65)} {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(ctx, lib.REQUEST_ID_KEY, sent)
+		client.Rmdir(ctx, &proto.DirEntry{Path: "/dir"}, grpc.Header(&header))
+
+		ids := header.Get(lib.REQUEST_ID_KEY)
+		if len(ids) != 1 || !validRequestId(ids[0]) {
+			t.Fatalf("header request id = %q; want one valid id", ids)
+		}
+		if validRequestId(sent) != (ids[0] == sent) {
+			t.Errorf("sent %q; got back %q", sent, ids[0])
+		}
+		want := "request " + ids[0] + " " + proto.Fuse_Rmdir_FullMethodName + " by " + testUser.Email
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("log %q lacks %q", output.String(), want)
+		}
+	}
+}
+
+// Web responses carry the request id in a header, and error bodies in
+// request_id as well
+func TestRequestIdInWebResponses(t *testing.T) {
+	handler := requestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestId(r.Context()) != w.Header().Get(lib.REQUEST_ID_HEADER) {
+			t.Error("request id in context differs from the response header")
+		}
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "not found")
+	}))
+
+	for _, sent := range []string{"web-request", ""} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if sent != "" {
+			r.Header.Set(lib.REQUEST_ID_HEADER, sent)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		id := w.Header().Get(lib.REQUEST_ID_HEADER)
+		if sent != "" && id != sent || !validRequestId(id) {
+			t.Errorf("sent %q; response header has %q", sent, id)
+		}
+		body := errorBody{}
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil || body.RequestId != id {
+			t.Errorf("error body request_id = %q, %v; want %q", body.RequestId, err, id)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rmdir only removes empty directories and Unlink only what isn't one
+func TestRmdir(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := filepath.Base(t.Name())
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, name)
+	for _, sub := range []string{"empty", "full"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, file := range []string{"file", "full/file"} {
+		err := os.WriteFile(filepath.Join(dir, file), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := client.Rmdir(ctx, &proto.DirEntry{Path: "/" + name + "/empty"})
+	if err != nil {
+		t.Errorf("Rmdir of empty directory = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "empty")); !os.IsNotExist(err) {
+		t.Errorf("empty directory left behind; %v", err)
+	}
+
+	_, err = client.Rmdir(ctx, &proto.DirEntry{Path: "/" + name + "/full"})
+	if errno := lib.StatusErrno(err); errno != syscall.ENOTEMPTY {
+		t.Errorf("Rmdir of non-empty directory = %v; want ENOTEMPTY", err)
+	}
+	_, err = client.Rmdir(ctx, &proto.DirEntry{Path: "/" + name + "/file"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Rmdir of file = %v; want FailedPrecondition", err)
+	}
+	_, err = client.Unlink(ctx, &proto.DirEntry{Path: "/" + name + "/full"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Unlink of directory = %v; want FailedPrecondition", err)
+	}
+	for _, path := range []string{"full/file", "file"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%v removed by a refused call; %v", path, err)
+		}
+	}
+
+	_, err = client.Unlink(ctx, &proto.DirEntry{Path: "/" + name + "/file"})
+	if err != nil {
+		t.Errorf("Unlink of file = %v", err)
+	}
+}
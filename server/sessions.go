@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A client observing file events, as shown to admins
+type session struct {
+	Id       uint64    `json:"id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	OrgName  string    `json:"org_name"`
+	DeptName string    `json:"dept_name"`
+	Path     string    `json:"path"`
+	Since    time.Time `json:"since"`
+	Dropped  uint64    `json:"dropped_events"`
+
+	// Files the user holds open through GRPC
+	OpenHandles int `json:"open_handles"`
+}
+
+// Most clients an organization may have connected at once, counted
+// by their ObserveFileChanges streams. 0 means unlimited
+var (
+	maxClients    int
+	orgMaxClients = make(map[string]int)
+)
+
+// Parses per organization client limits in the format org1=10,org2=5
+func parseOrgMaxClients(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	if strings.TrimSpace(value) == "" {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		org, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		n, err := strconv.Atoi(limit)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("expected org=clients but got %q", entry)
+		}
+		limits[org] = n
+	}
+	return limits, nil
+}
+
+func maxClientsFor(orgName string) int {
+	limit, ok := orgMaxClients[orgName]
+	if ok {
+		return limit
+	}
+	return maxClients
+}
+
+// Number of clients of organization orgName connected. Called with mu
+// held
+func countClients(orgName string) int {
+	count := 0
+	for _, _observers := range observers {
+		for _, obs := range _observers {
+			if obs.user.OrgName == orgName {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Lists active observer sessions belonging to organization orgName
+func listSessions(orgName string) []session {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	sessions := []session{}
+	for path, _observers := range observers {
+		for _, obs := range _observers {
+			if obs.user.OrgName != orgName {
+				continue
+			}
+			sessions = append(sessions, session{
+				Id:       obs.id,
+				Username: obs.user.Username,
+				Email:    obs.user.Email,
+				OrgName:  obs.user.OrgName,
+				DeptName: obs.user.DeptName,
+				Path:     path,
+				Since:    obs.since,
+				Dropped:  obs.dropped.Load(),
+
+				OpenHandles: openHandleCount(obs.user.Email),
+			})
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Id < sessions[j].Id
+	})
+	return sessions
+}
+
+// Closes the observer streams of organization orgName matching either
+// id or email. Returns the number of sessions terminated
+func terminateSessions(orgName string, id uint64, email string) int {
+	matches := []*observer{}
+
+	mu.RLock()
+	for _, _observers := range observers {
+		for _, obs := range _observers {
+			if obs.user.OrgName != orgName {
+				continue
+			}
+			if (id != 0 && obs.id == id) || (email != "" && obs.user.Email == email) {
+				matches = append(matches, obs)
+			}
+		}
+	}
+	mu.RUnlock()
+
+	for _, obs := range matches {
+		removeObserver(obs.path, obs)
+		obs.close(status.Error(codes.Aborted, "Session terminated by admin"))
+	}
+	return len(matches)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Calls an admin session route as the test user and decodes its reply
+func sessionsRequest(t *testing.T, handler http.HandlerFunc, method, body string) (int, map[string]any) {
+	t.Helper()
+	r := httptest.NewRequest(method, "/sessions", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), auth.USER_CTX_KEY, &testUser))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	reply := make(map[string]any)
+	err := json.Unmarshal(w.Body.Bytes(), &reply)
+	if err != nil {
+		t.Fatalf("%v /sessions replied %q; %v", method, w.Body, err)
+	}
+	return w.Code, reply
+}
+
+// Waits for the number of sessions listed to reach want
+func waitForSessions(t *testing.T, want int) []any {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, reply := sessionsRequest(t, listSessionsHandler, "GET", "")
+		sessions, _ := reply["sessions"].([]any)
+		if len(sessions) == want {
+			return sessions
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v sessions listed; want %v", len(sessions), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTerminateSession(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	stream, err := client.ObserveFileChanges(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := waitForSessions(t, 1)
+	listed, _ := sessions[0].(map[string]any)
+	if listed["email"] != testUser.Email {
+		t.Errorf("session of %v listed; want %v", listed["email"], testUser.Email)
+	}
+
+	code, _ := sessionsRequest(t, terminateSessionHandler, "POST", `{"email": "nobody@example.com"}`)
+	if code != http.StatusNotFound {
+		t.Errorf("terminating unknown user = %v; want %v", code, http.StatusNotFound)
+	}
+	code, reply := sessionsRequest(t, terminateSessionHandler, "POST", `{"email": "`+testUser.Email+`"}`)
+	if code != http.StatusOK || reply["terminated"] != 1.0 {
+		t.Errorf("terminate = %v %v; want 1 session terminated", code, reply)
+	}
+
+	_, err = stream.Recv()
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("stream after terminate = %v; want Aborted", err)
+	}
+	waitForSessions(t, 0)
+}
+
+// Clients connected of organization orgName
+func connectedClients(orgName string) int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return countClients(orgName)
+}
+
+// Past its limit an organization's next client is turned away; other
+// organizations are not, and a client leaving frees its slot
+func TestMaxClientsPerOrg(t *testing.T) {
+	oldLimits := orgMaxClients
+	orgMaxClients = map[string]int{testUser.OrgName: 2}
+	t.Cleanup(func() { orgMaxClients = oldLimits })
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+
+	cancels := []context.CancelFunc{}
+	for range 2 {
+		streamCtx, cancel := context.WithCancel(ctx)
+		t.Cleanup(cancel)
+		cancels = append(cancels, cancel)
+		_, err := client.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForSessions(t, 2)
+	_, reply := sessionsRequest(t, listSessionsHandler, "GET", "")
+	if reply["clients"] != 2.0 || reply["max_clients"] != 2.0 {
+		t.Errorf("sessions reply %v clients of %v; want 2 of 2", reply["clients"], reply["max_clients"])
+	}
+
+	stream, err := client.ObserveFileChanges(ctx, &emptypb.Empty{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("client past the limit = %v; want ResourceExhausted", err)
+	}
+
+	other := testUser
+	other.Id, other.Email, other.OrgName = 4, "dan@other.example.com", "other"
+	otherClient, otherCtx := newTestClient(t, FuseServer{path: mountpoint}, other)
+	t.Cleanup(func() { os.RemoveAll(filepath.Join(mountpoint, other.OrgName)) })
+	streamCtx, cancel := context.WithCancel(otherCtx)
+	t.Cleanup(cancel)
+	_, err = otherClient.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for connectedClients(other.OrgName) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("another organization's client not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	cancels[0]()
+	waitForSessions(t, 1)
+	streamCtx, cancel = context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	_, err = client.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSessions(t, 2)
+}
+
+func TestParseOrgMaxClients(t *testing.T) {
+	limits, err := parseOrgMaxClients(" org1=10, org2=0 ")
+	if err != nil || limits["org1"] != 10 || limits["org2"] != 0 || len(limits) != 2 {
+		t.Errorf("parseOrgMaxClients = %v, %v; want org1=10 and org2=0", limits, err)
+	}
+	for _, value := range []string{"org1", "org1=x", "org1=-1"} {
+		if _, err := parseOrgMaxClients(value); err == nil {
+			t.Errorf("parseOrgMaxClients(%q) accepted", value)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Creates file and a symlink to it in the test user's directory
+func setattrFixture(t *testing.T) (file, link string) {
+	t.Helper()
+
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatalf("Error creating user's directory; %v", err)
+	}
+	name := filepath.Base(t.Name())
+	file = filepath.Join(dir, name)
+	err = os.WriteFile(file, []byte("contents"), 0644)
+	if err != nil {
+		t.Fatalf("Error creating file; %v", err)
+	}
+	link = file + ".link"
+	err = os.Symlink(file, link)
+	if err != nil {
+		t.Fatalf("Error creating symlink; %v", err)
+	}
+	t.Cleanup(func() {
+		os.Remove(file)
+		os.Remove(link)
+	})
+	return "/" + name, "/" + name + ".link"
+}
+
+func TestSetattrMasksMode(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	file, _ := setattrFixture(t)
+
+	mode := uint32(06755)
+	attr, err := client.Setattr(ctx, &proto.SetattrRequest{Path: file, Mode: &mode})
+	if err != nil {
+		t.Fatalf("Setattr failed; %v", err)
+	}
+	if got := attr.Mode & 07777; got != 0755 {
+		t.Errorf("mode = %o; want 755", got)
+	}
+}
+
+// Changes to a symlink never reach its target
+func TestSetattrDoesNotFollowSymlinks(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	_, link := setattrFixture(t)
+	target := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, filepath.Base(t.Name()))
+
+	before, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("Error reading target; %v", err)
+	}
+
+	mode := uint32(0777)
+	_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: link, Mode: &mode})
+	if errno := lib.StatusErrno(err); errno != syscall.EOPNOTSUPP {
+		t.Errorf("chmod of symlink = %v; want EOPNOTSUPP", err)
+	}
+
+	size := uint64(0)
+	_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: link, Size: &size})
+	if errno := lib.StatusErrno(err); errno != syscall.ELOOP {
+		t.Errorf("truncate of symlink = %v; want ELOOP", err)
+	}
+
+	mtime := timestamppb.New(time.Unix(1000, 0))
+	_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: link, MTime: mtime})
+	if err != nil {
+		t.Errorf("utimes of symlink failed; %v", err)
+	}
+
+	after, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("Error reading target; %v", err)
+	}
+	if after.Mode() != before.Mode() || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("target changed from %v %v %v to %v %v %v",
+			before.Mode(), before.Size(), before.ModTime(), after.Mode(), after.Size(), after.ModTime())
+	}
+}
+
+// A truncate sent from the client changes the size and tells
+// observers the file was modified
+func TestSetattrTruncates(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	file, _ := setattrFixture(t)
+	observing, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go startMainObserver(observing)
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	t.Cleanup(cancel)
+	stream, err := client.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSessions(t, 1)
+
+	size := uint64(3)
+	attr, err := client.Setattr(ctx, &proto.SetattrRequest{Path: file, Size: &size})
+	if err != nil {
+		t.Fatalf("Setattr failed; %v", err)
+	}
+	if attr.Size != size {
+		t.Errorf("size = %v; want %v", attr.Size, size)
+	}
+	got, _ := os.ReadFile(filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, file))
+	if string(got) != "con" {
+		t.Errorf("contents = %q; want %q", got, "con")
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("no MODIFY_FILE event for %v; %v", file, err)
+		}
+		if event.Event == uint32(events.MODIFY_FILE) && event.Path == file {
+			break
+		}
+	}
+}
+
+// Each field applies alone and together with the others, leaving the
+// fields not sent as they were
+func TestSetattrFields(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	file, _ := setattrFixture(t)
+	fullpath := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, file)
+
+	type attrs struct {
+		mode         os.FileMode
+		size         int64
+		atime, mtime time.Time
+	}
+	current := func() attrs {
+		st := syscall.Stat_t{}
+		err := syscall.Stat(fullpath, &st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attrs{
+			mode:  os.FileMode(st.Mode & 0777),
+			size:  st.Size,
+			atime: time.Unix(st.Atim.Unix()),
+			mtime: time.Unix(st.Mtim.Unix()),
+		}
+	}
+
+	mode, size := uint32(0600), uint64(3)
+	atime, mtime := time.Unix(1000, 0), time.Unix(2000, 0)
+	tests := []struct {
+		name string
+		req  *proto.SetattrRequest
+	}{
+		{"mode", &proto.SetattrRequest{Mode: &mode}},
+		{"size", &proto.SetattrRequest{Size: &size}},
+		{"atime", &proto.SetattrRequest{ATime: timestamppb.New(atime)}},
+		{"mtime", &proto.SetattrRequest{MTime: timestamppb.New(mtime)}},
+		{"atime mtime", &proto.SetattrRequest{ATime: timestamppb.New(atime), MTime: timestamppb.New(mtime)}},
+		{"mode size", &proto.SetattrRequest{Mode: &mode, Size: &size}},
+		{"everything", &proto.SetattrRequest{Mode: &mode, Size: &size, ATime: timestamppb.New(atime), MTime: timestamppb.New(mtime)}},
+	}
+	for _, test := range tests {
+		err := os.WriteFile(fullpath, []byte("contents"), 0644)
+		if err == nil {
+			err = os.Chmod(fullpath, 0644)
+		}
+		if err == nil {
+			err = os.Chtimes(fullpath, time.Unix(5000, 0), time.Unix(5000, 0))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := current()
+		if test.req.Mode != nil {
+			want.mode = os.FileMode(mode)
+		}
+		if test.req.Size != nil {
+			want.size = int64(size)
+			// Truncating is a modification
+			want.mtime = time.Time{}
+		}
+		if test.req.ATime != nil {
+			want.atime = atime
+		}
+		if test.req.MTime != nil {
+			want.mtime = mtime
+		}
+
+		test.req.Path = file
+		_, err = client.Setattr(ctx, test.req)
+		if err != nil {
+			t.Fatalf("Setattr of %v = %v", test.name, err)
+		}
+		got := current()
+		if want.mtime.IsZero() {
+			want.mtime = got.mtime
+		}
+		if got != want {
+			t.Errorf("after Setattr of %v = %+v; want %+v", test.name, got, want)
+		}
+	}
+}
+
+// A truncate through an open handle shows in the stat that follows,
+// which answers from the handle rather than the path
+func TestGetattrThroughHandle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	err := os.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := fs.NewNodeFS(&Node{path: dir}, &fs.Options{})
+	header := fuse.InHeader{
+		NodeId: fuse.FUSE_ROOT_ID,
+		Caller: fuse.Caller{Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}},
+	}
+	entry := fuse.EntryOut{}
+	if code := raw.Lookup(nil, &header, "file", &entry); !code.Ok() {
+		t.Fatalf("Lookup = %v", code)
+	}
+	header.NodeId = entry.NodeId
+	opened := fuse.OpenOut{}
+	if code := raw.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_RDWR)}, &opened); !code.Ok() {
+		t.Fatalf("Open = %v", code)
+	}
+	size := func() uint64 {
+		out := fuse.AttrOut{}
+		code := raw.GetAttr(nil, &fuse.GetAttrIn{InHeader: header}, &out)
+		if !code.Ok() {
+			t.Fatalf("GetAttr = %v", code)
+		}
+		return out.Size
+	}
+
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+		InHeader: header,
+		Valid:    fuse.FATTR_SIZE | fuse.FATTR_FH,
+		Fh:       opened.Fh,
+		Size:     1,
+	}}
+	if code := raw.SetAttr(nil, in, &fuse.AttrOut{}); !code.Ok() {
+		t.Fatalf("SetAttr = %v", code)
+	}
+	if got := size(); got != 1 {
+		t.Errorf("size after truncate = %v; want 1", got)
+	}
+
+	err = os.WriteFile(path+".new", []byte("replaced"), 0644)
+	if err == nil {
+		err = os.Rename(path+".new", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := size(); got != 1 {
+		t.Errorf("size with the handle open = %v; want the handle's 1", got)
+	}
+	raw.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: opened.Fh})
+	if got := size(); got != 8 {
+		t.Errorf("size after release = %v; want the path's 8", got)
+	}
+}
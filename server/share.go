@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/sys/unix"
+)
+
+// How long share links last unless their creator asks otherwise
+const DEFAULT_SHARE_DURATION = 24 * time.Hour
+
+// Longest a share link may last
+const MAX_SHARE_DURATION = 30 * 24 * time.Hour
+
+var shareLinks *db.ShareLinkModel
+
+type createShareLinkRequest struct {
+	// File to share relative to the user's department directory
+	Path           string `json:"path"`
+	ExpiresInHours int    `json:"expires_in_hours"`
+	MaxDownloads   int    `json:"max_downloads"`
+}
+
+// Resolves path, relative to user's department directory, to a path
+// relative to realpath. Fails for paths the user cannot reach
+func userPath(user *db.User, path string) (string, bool) {
+	relPath := strings.TrimLeft(path, "/")
+	if relPath == "" || !filepath.IsLocal(relPath) || inOthersPersonalDir(user, relPath) {
+		return "", false
+	}
+	return filepath.Join(user.OrgName, user.DeptName, relPath), true
+}
+
+// Opens path, relative to realpath, inside user's department
+// directory and returns the regular file it resolves to, relative to
+// realpath
+func resolveSharedFile(ctx context.Context, user *db.User, path string) (string, error) {
+	dir := filepath.Join(realpath, user.OrgName, user.DeptName)
+	file, err := openInDir(ctx, dir, filepath.Join(realpath, path), unix.O_PATH, 0)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", syscall.EISDIR
+	}
+	return resolvedPath(realpath, file)
+}
+
+func createShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	var req createShareLinkRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || strings.TrimSpace(req.Path) == "" {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "path field required")
+		return
+	}
+	if req.ExpiresInHours < 0 || req.MaxDownloads < 0 {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "expires_in_hours and max_downloads must not be negative")
+		return
+	}
+
+	// Users can only share files they can reach themselves
+	path, ok := userPath(user, req.Path)
+	if !ok {
+		errorResponse(w, http.StatusForbidden, ERR_FORBIDDEN, "path is outside your directory")
+		return
+	}
+
+	// Links store the path the file resolved to; symlinks along the
+	// way are checked once here and never followed again
+	path, err = resolveSharedFile(r.Context(), user, path)
+	if err != nil {
+		errMessage := fmt.Sprintf("File '%v' NOT found", req.Path)
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, errMessage)
+		return
+	}
+
+	duration := DEFAULT_SHARE_DURATION
+	if req.ExpiresInHours > 0 {
+		duration = min(time.Duration(req.ExpiresInHours)*time.Hour, MAX_SHARE_DURATION)
+	}
+
+	link := db.NewShareLink(path, user.Email, duration, req.MaxDownloads)
+	_, err = shareLinks.Insert(*link)
+	if err != nil {
+		log.Printf("Error creating share link; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating share link")
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, map[string]any{
+		"token":         link.Token,
+		"url":           "/share/" + link.Token,
+		"path":          req.Path,
+		"max_downloads": link.MaxDownloads,
+		"expires_at":    link.ExpiresAt,
+	})
+}
+
+func revokeShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	revoked, err := shareLinks.Revoke(chi.URLParam(r, "token"), user.Email)
+	if err != nil {
+		log.Printf("Error revoking share link; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error revoking share link")
+		return
+	}
+	if revoked == 0 {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "no matching share link found")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "share link revoked"})
+}
+
+// Serves the file behind a share link to anyone holding its token
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	link, err := shareLinks.Get(token)
+	if err == sql.ErrNoRows {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "share link is invalid, expired or revoked")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching share link; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error fetching shared file")
+		return
+	}
+
+	// Don't follow a symlink swapped in after the link was created,
+	// in the file's name or any directory above it
+	file, err := lib.OpenBeneathNoSymlinks(realpath, link.Path, os.O_RDONLY, 0)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "shared file no longer exists")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "shared file no longer exists")
+		return
+	}
+
+	// Resuming a download or seeking through a video takes many range
+	// requests; only those sending the first byte count
+	if startsDownload(r, info.Size(), info.ModTime()) {
+		counted, err := shareLinks.CountDownload(token)
+		if err != nil {
+			log.Printf("Error counting share link download; %v\n", err)
+			errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error fetching shared file")
+			return
+		}
+		if counted == 0 {
+			// Another download used up the link since we fetched it
+			errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "share link is invalid, expired or revoked")
+			return
+		}
+	}
+
+	name := filepath.Base(link.Path)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// Reports whether http.ServeContent answers r with the first byte of
+// a file of size bytes modified at modtime: r has no Range header, one
+// ServeContent ignores and sends the whole file for, or one with a
+// range covering byte 0 however it is written. A header we can't make
+// sense of counts too
+func startsDownload(r *http.Request, size int64, modtime time.Time) bool {
+	header := r.Header.Get("Range")
+	if header == "" || !ifRangeMatches(r, modtime) {
+		return true
+	}
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return true
+	}
+
+	first := false
+	var total int64
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(spec, "-")
+		if !ok {
+			return true
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+
+		var start, length int64
+		if from == "" {
+			// The last n bytes
+			n, err := strconv.ParseInt(to, 10, 64)
+			if err != nil || n < 0 {
+				return true
+			}
+			n = min(n, size)
+			start, length = size-n, n
+		} else {
+			var err error
+			start, err = strconv.ParseInt(from, 10, 64)
+			if err != nil || start < 0 {
+				return true
+			}
+			if start >= size {
+				// Past the end; not sent
+				continue
+			}
+			end := size - 1
+			if to != "" {
+				end, err = strconv.ParseInt(to, 10, 64)
+				if err != nil || end < start {
+					return true
+				}
+				end = min(end, size-1)
+			}
+			length = end - start + 1
+		}
+		if start == 0 {
+			first = true
+		}
+		total += length
+	}
+
+	// Ranges adding up to more than the file get it sent whole
+	return first || total > size
+}
+
+// Reports whether ServeContent honors r's Range header given its
+// If-Range. Share downloads send no ETag, so only the file's
+// modification time can match
+func ifRangeMatches(r *http.Request, modtime time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return true
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Unix() == modtime.Unix()
+}
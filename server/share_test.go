@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/server/auth"
+)
+
+// Checks startsDownload against what http.ServeContent actually sends
+// for each Range header
+func TestStartsDownload(t *testing.T) {
+	content := "!........."
+	modtime := time.Unix(1_000_000, 0)
+	tests := []struct {
+		ranges, ifRange string
+		want            bool
+	}{
+		{"", "", true},
+		{"bytes=0-", "", true},
+		{"bytes=0-99", "", true},
+		{"bytes=0-0,5-6", "", true},
+		{"bytes=5-", "", false},
+		{"bytes=5-6,0-1", "", true},
+		{"bytes=20-", "", false},
+
+		// Suffix ranges cover the start once they're as long as the file
+		{"bytes=-5", "", false},
+		{"bytes=-10", "", true},
+		{"bytes=-500", "", true},
+
+		// Zero-padded starts are still 0
+		{"bytes=00-", "", true},
+		{"bytes= 000-4", "", true},
+
+		// Ranges adding up to more than the file are ignored
+		{"bytes=1-,1-", "", true},
+
+		// So is one whose If-Range doesn't match
+		{"bytes=5-", modtime.UTC().Format(http.TimeFormat), false},
+		{"bytes=5-", modtime.Add(time.Hour).UTC().Format(http.TimeFormat), true},
+		{"bytes=5-", `"etag"`, true},
+
+		// ServeContent refuses these; counting them is harmless
+		{"bytes=5-1", "", true},
+		{"lines=0-", "", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/share/token", nil)
+		if test.ranges != "" {
+			r.Header.Set("Range", test.ranges)
+		}
+		if test.ifRange != "" {
+			r.Header.Set("If-Range", test.ifRange)
+		}
+		got := startsDownload(r, int64(len(content)), modtime)
+		if got != test.want {
+			t.Errorf("startsDownload(%q, If-Range %q) = %v; want %v", test.ranges, test.ifRange, got, test.want)
+		}
+
+		w := httptest.NewRecorder()
+		http.ServeContent(w, r, "file", modtime, strings.NewReader(content))
+		if sent := strings.Contains(w.Body.String(), "!"); sent && !got {
+			t.Errorf("ServeContent sent the first byte for %q, If-Range %q, uncounted", test.ranges, test.ifRange)
+		}
+	}
+}
+
+func TestResolveSharedFile(t *testing.T) {
+	dir, link := personalDirFixture(t)
+	ctx := context.WithValue(context.Background(), auth.USER_CTX_KEY, &testUser)
+	deptDir := filepath.Join(testUser.OrgName, testUser.DeptName)
+
+	name := filepath.Base(t.Name()) + ".txt"
+	err := os.WriteFile(filepath.Join(dir, name), []byte("shared"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(name, filepath.Join(dir, name+".link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(dir, name))
+		os.Remove(filepath.Join(dir, name+".link"))
+	})
+
+	// Links store the file a symlink resolved to
+	got, err := resolveSharedFile(ctx, &testUser, filepath.Join(deptDir, name+".link"))
+	if err != nil {
+		t.Fatalf("resolving symlink in own directory = %v", err)
+	}
+	if want := filepath.Join(deptDir, name); got != want {
+		t.Errorf("resolved to %v; want %v", got, want)
+	}
+
+	_, err = resolveSharedFile(ctx, &testUser, filepath.Join(deptDir, link, "secret"))
+	if err == nil {
+		t.Error("shared a file in another user's personal directory through a symlink")
+	}
+	_, err = resolveSharedFile(ctx, &testUser, deptDir)
+	if err == nil {
+		t.Error("shared a directory")
+	}
+}
+
+// Links to files outside the creator's directory are refused before
+// any link is made
+func TestCreateShareLinkOutsideDirRefused(t *testing.T) {
+	_, link := personalDirFixture(t)
+	oldLinks := shareLinks
+	shareLinks = nil // reaching it would panic
+	t.Cleanup(func() { shareLinks = oldLinks })
+
+	other := filepath.Join(mountpoint, testUser.OrgName, "other")
+	err := os.MkdirAll(other, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(other, "file"), []byte("other"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(other) })
+
+	paths := []string{
+		"../other/file",
+		"/users/bob/secret",
+		link + "/secret",
+		"/missing",
+	}
+	for _, path := range paths {
+		body := strings.NewReader(`{"path": "` + path + `"}`)
+		r := httptest.NewRequest("POST", "/share", body)
+		r = r.WithContext(context.WithValue(r.Context(), auth.USER_CTX_KEY, &testUser))
+		w := httptest.NewRecorder()
+		createShareLinkHandler(w, r)
+		if w.Code != http.StatusForbidden && w.Code != http.StatusNotFound {
+			t.Errorf("share link to %v = %v; want it refused", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Symlinks are stored with the exact target the client sent and read
+// back unchanged. Absolute targets are refused rather than rewritten
+func TestSymlinkTargetsKeptVerbatim(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	err := os.MkdirAll(filepath.Join(dir, "links"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(filepath.Join(dir, "links")) })
+
+	targets := map[string]string{
+		"/links/relative": "file",
+		"/links/up":       "../links/./file",
+	}
+	for path, target := range targets {
+		_, err := client.Symlink(ctx, &proto.LinkRequest{OldPath: target, NewPath: path})
+		if err != nil {
+			t.Fatalf("Symlink %v -> %q = %v", path, target, err)
+		}
+		got, err := os.Readlink(filepath.Join(dir, path))
+		if err != nil || got != target {
+			t.Errorf("stored %v = %q, %v; want %q", path, got, err, target)
+		}
+		link := &Node{path: filepath.Join(dir, path)}
+		data, errno := link.Readlink(ctx)
+		if errno != 0 || string(data) != target {
+			t.Errorf("Readlink %v = %q, %v; want %q", path, data, errno, target)
+		}
+	}
+
+	_, err = client.Symlink(ctx, &proto.LinkRequest{OldPath: "/etc/hostname", NewPath: "/links/absolute"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Symlink to an absolute target = %v; want InvalidArgument", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "links", "absolute")); err == nil {
+		t.Error("refused symlink left behind")
+	}
+
+	// Made on the server's own mount, any target is stored as given
+	raw := fs.NewNodeFS(&Node{path: filepath.Join(dir, "links")}, &fs.Options{})
+	for name, target := range map[string]string{"local-relative": "../file", "local-absolute": "/etc/hostname"} {
+		out := fuse.EntryOut{}
+		if code := raw.Symlink(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, target, name, &out); !code.Ok() {
+			t.Fatalf("FUSE Symlink %v = %v", name, code)
+		}
+		got, code := raw.Readlink(nil, &fuse.InHeader{NodeId: out.NodeId})
+		if !code.Ok() || string(got) != target {
+			t.Errorf("FUSE Readlink %v = %q, %v; want %q", name, got, code, target)
+		}
+	}
+}
+
+// A symlink leading back to itself, directly or through another, is
+// refused when made and fails with ELOOP when followed. One leading out
+// of the user's directory is refused both ways with EPERM
+func TestSymlinkLoopsAndEscapesRefused(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	err := os.MkdirAll(filepath.Join(dir, "loops"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(filepath.Join(dir, "loops")) })
+
+	refused := []struct {
+		path, target string
+		want         codes.Code
+	}{
+		{"/loops/self", "self", codes.FailedPrecondition},
+		{"/loops/out", "../../../outside", codes.PermissionDenied},
+	}
+	for _, test := range refused {
+		_, err := client.Symlink(ctx, &proto.LinkRequest{OldPath: test.target, NewPath: test.path})
+		if status.Code(err) != test.want {
+			t.Errorf("Symlink %v -> %v = %v; want %v", test.path, test.target, err, test.want)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, test.path)); err == nil {
+			t.Errorf("refused symlink %v left behind", test.path)
+		}
+	}
+
+	// Planted behind the server's back
+	planted := map[string]string{
+		"ping":   "pong",
+		"pong":   "ping",
+		"escape": filepath.Dir(mountpoint),
+	}
+	for name, target := range planted {
+		err := os.Symlink(target, filepath.Join(dir, "loops", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	followed := []struct {
+		path string
+		want codes.Code
+	}{
+		{"/loops/ping", codes.FailedPrecondition},
+		{"/loops/ping/file", codes.FailedPrecondition},
+		{"/loops/escape", codes.PermissionDenied},
+	}
+	for _, test := range followed {
+		stream, err := client.DownloadFile(ctx, &proto.DownloadRequest{Path: test.path})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != test.want {
+			t.Errorf("download of %v = %v; want %v", test.path, err, test.want)
+		}
+	}
+
+	// The links themselves can still be looked at and removed
+	_, err = client.Lookup(ctx, &proto.LookupRequest{Path: "/loops/ping"})
+	if err != nil {
+		t.Errorf("Lookup of a looping symlink = %v; want it found", err)
+	}
+	_, err = client.Unlink(ctx, &proto.DirEntry{Path: "/loops/ping"})
+	if err != nil {
+		t.Errorf("Unlink of a looping symlink = %v", err)
+	}
+}
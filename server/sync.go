@@ -4,37 +4,129 @@ import (
 	"context"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/caleb-mwasikira/fusion/lib"
 	"github.com/caleb-mwasikira/fusion/lib/events"
 	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"github.com/caleb-mwasikira/fusion/server/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Consecutive events an observer may miss before we give up on it
+const MAX_DROPPED_EVENTS = 100
+
+// A client listening for file events
+type observer struct {
+	id    uint64
+	user  *db.User
+	path  string
+	since time.Time
+
+	events chan *proto.FileEvent
+
+	// Closed when the server disconnects the observer. closeErr is
+	// returned to the client
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	// Events dropped in a row; only touched by MAIN_OBSERVER
+	drops int
+
+	// Events dropped in total
+	dropped atomic.Uint64
+}
+
+func newObserver(user *db.User, path string) *observer {
+	return &observer{
+		id:     nextObserverId.Add(1),
+		user:   user,
+		path:   path,
+		since:  time.Now(),
+		events: make(chan *proto.FileEvent, 10),
+		done:   make(chan struct{}),
+	}
+}
+
+// Ends the observer's stream with err
+func (obs *observer) close(err error) {
+	obs.closeOnce.Do(func() {
+		obs.closeErr = err
+		close(obs.done)
+	})
+}
+
 var (
 	// List of clients listening for changes on a directory
-	observers = make(map[string][]chan *proto.FileEvent)
+	observers = make(map[string][]*observer)
 	broadcast = make(chan *proto.FileEvent, 100)
 	mu        = sync.RWMutex{}
+
+	// Total number of events dropped because an observer was
+	// not keeping up
+	droppedEvents atomic.Uint64
+
+	nextObserverId atomic.Uint64
 )
 
+// Registers obs unless its organization already has as many clients
+// connected as -max-clients allows
+func addObserver(path string, obs *observer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	limit := maxClientsFor(obs.user.OrgName)
+	if limit > 0 && countClients(obs.user.OrgName) >= limit {
+		return status.Errorf(
+			codes.ResourceExhausted,
+			"organization %v already has the maximum of %v connected clients",
+			obs.user.OrgName, limit,
+		)
+	}
+	observers[path] = append(observers[path], obs)
+	return nil
+}
+
+func removeObserver(path string, obs *observer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := observers[path]
+	for i, o := range list {
+		if o == obs {
+			observers[path] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(observers[path]) == 0 {
+		delete(observers, path)
+	}
+}
+
 // Get all observers for provided path.
 // Path doesn't have to be an exact match;
 //
 //	eg. An observer could be listening for changes on the path
 //	/home/Documents but a file in /home/Documents/folder changes.
 //	That observer should be notified of these changes.
-func getObservers(path string) []chan *proto.FileEvent {
+func getObservers(path string) map[*observer]string {
 	mu.RLock()
 	defer mu.RUnlock()
 
-	clients := []chan *proto.FileEvent{}
+	clients := map[*observer]string{}
 	for observedPath, _observers := range observers {
-		if strings.Contains(path, observedPath) {
-			clients = append(clients, _observers...)
+		// Match whole path components so that an observer of
+		// users/bob isn't sent events from users/bobby
+		if path == observedPath || strings.HasPrefix(path, observedPath+"/") {
+			for _, obs := range _observers {
+				clients[obs] = observedPath
+			}
 		}
 	}
 	return clients
@@ -47,7 +139,15 @@ func startMainObserver(ctx context.Context) {
 	log.Println("[SYNC] Launching MAIN_OBSERVER goroutine")
 
 	for {
-		fileEvent := <-broadcast
+		var fileEvent *proto.FileEvent
+
+		select {
+		case <-ctx.Done():
+			log.Printf("[SYNC] Exiting MAIN_OBSERVER goroutine; %v\n", ctx.Err())
+			return
+
+		case fileEvent = <-broadcast:
+		}
 
 		log.Printf("[SYNC] MAIN_OBSERVER received file event %v\n", fileEvent)
 
@@ -57,21 +157,45 @@ func startMainObserver(ctx context.Context) {
 			continue
 		}
 
-		for _, client := range clients {
-			select {
-			case <-ctx.Done():
-				log.Printf("[SYNC] Exiting MAIN_OBSERVER goroutine; %v\n", ctx.Err())
-				return
-
-			default:
-				go func(fileEvent *proto.FileEvent) {
-					client <- fileEvent
-				}(fileEvent)
-			}
+		for client, observedPath := range clients {
+			deliver(client, observedPath, fileEvent)
 		}
 	}
 }
 
+// Hands a file event to an observer without blocking. If the
+// observer's buffer is full its oldest event is dropped to make room.
+// Observers that keep dropping events are disconnected
+func deliver(obs *observer, observedPath string, fileEvent *proto.FileEvent) {
+	select {
+	case obs.events <- fileEvent:
+		obs.drops = 0
+		return
+	default:
+	}
+
+	// Buffer full; drop the oldest event
+	select {
+	case <-obs.events:
+	default:
+	}
+	select {
+	case obs.events <- fileEvent:
+	default:
+	}
+
+	obs.drops++
+	obs.dropped.Add(1)
+	total := droppedEvents.Add(1)
+	log.Printf("[SYNC] Observer@%v too slow; dropped event (%v dropped in total)\n", observedPath, total)
+
+	if obs.drops >= MAX_DROPPED_EVENTS {
+		log.Printf("[SYNC] Disconnecting slow observer@%v\n", observedPath)
+		removeObserver(observedPath, obs)
+		obs.close(status.Error(codes.ResourceExhausted, "Observer too slow; too many file events dropped"))
+	}
+}
+
 // Sends a message on the broadcast channel to notify observers
 // of a file change
 // Should be called as a goroutine
@@ -79,13 +203,23 @@ func notifyObservers(event events.EventType, path string, newpath string, mode o
 	path = relativePath(path)
 	newpath = relativePath(newpath)
 
-	// We are not going to send notifications for created temporary files
-	isTempFile := func(filename string) bool {
-		return strings.HasPrefix(filename, ".")
+	// We are not going to send notifications for temp files. A rename
+	// between a temp file and a real one is what observers would have
+	// seen without the temp file; eg. the atomic save of an editor
+	// writing to a temp file then renaming it over the original
+	tempPath := isTempFile(path, mode.IsDir())
+	tempNewPath := isTempFile(newpath, mode.IsDir())
+	switch {
+	case event == events.RENAME_FILE && tempPath && newpath != "" && !tempNewPath:
+		event, path, newpath = events.MODIFY_FILE, newpath, ""
+	case event == events.RENAME_FILE && !tempPath && tempNewPath:
+		event, newpath = events.DELETE_FILE, ""
+	case tempPath || tempNewPath:
+		log.Printf("[SYNC] Not sending notifications for actions on temp files; %v or %v\n", path, newpath)
+		return
 	}
 
-	if isTempFile(filepath.Base(path)) || isTempFile(filepath.Base(newpath)) {
-		log.Printf("[SYNC] Not sending notifications for actions on temp files; %v or %v\n", path, newpath)
+	if syncIgnored(path, mode.IsDir()) && (newpath == "" || syncIgnored(newpath, mode.IsDir())) {
 		return
 	}
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+)
+
+// An observer that never reads holds at most its buffer of events,
+// costs no goroutine per event and is disconnected in the end
+func TestSlowObserverDropped(t *testing.T) {
+	user := testUser
+	path := "/" + t.Name()
+	obs := newObserver(&user, path)
+	err := addObserver(path, obs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { removeObserver(path, obs) })
+
+	goroutines := runtime.NumGoroutine()
+	for i := range cap(obs.events) + MAX_DROPPED_EVENTS - 1 {
+		deliver(obs, path, &proto.FileEvent{Path: path + "/" + strconv.Itoa(i)})
+	}
+	if n := len(obs.events); n > cap(obs.events) {
+		t.Errorf("%v events buffered; want at most %v", n, cap(obs.events))
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%v goroutines after delivering; started with %v", n, goroutines)
+	}
+	select {
+	case <-obs.done:
+		t.Fatal("observer disconnected before MAX_DROPPED_EVENTS")
+	default:
+	}
+
+	// Newest events are kept
+	last := &proto.FileEvent{Path: path + "/last"}
+	deliver(obs, path, last)
+	select {
+	case <-obs.done:
+	default:
+		t.Fatal("observer still connected after MAX_DROPPED_EVENTS drops")
+	}
+	if got := obs.dropped.Load(); got != MAX_DROPPED_EVENTS {
+		t.Errorf("dropped = %v; want %v", got, MAX_DROPPED_EVENTS)
+	}
+	if _, ok := getObservers(path)[obs]; ok {
+		t.Error("disconnected observer still registered")
+	}
+	var newest *proto.FileEvent
+	for len(obs.events) > 0 {
+		newest = <-obs.events
+	}
+	if newest != last {
+		t.Errorf("newest buffered event = %v; want %v", newest, last)
+	}
+}
+
+// A reader keeping up resets the count of drops in a row
+func TestObserverCatchesUp(t *testing.T) {
+	user := testUser
+	path := "/" + t.Name()
+	obs := newObserver(&user, path)
+
+	for range 3 {
+		for range cap(obs.events) + MAX_DROPPED_EVENTS - 1 {
+			deliver(obs, path, &proto.FileEvent{Path: path})
+		}
+		for len(obs.events) > 0 {
+			<-obs.events
+		}
+		deliver(obs, path, &proto.FileEvent{Path: path})
+		<-obs.events
+	}
+	select {
+	case <-obs.done:
+		t.Error("observer that caught up was disconnected")
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+)
+
+// Changes to temp files aren't broadcast to observers. Which files are
+// temp files is set for the whole deployment by the file given with
+// -temp-patterns, lib.DEFAULT_TEMP_PATTERNS without one, and can be
+// extended per organization by ORG_TEMP_FILE at the root of its
+// directory. Both use the syntax of lib.IGNORE_FILE; organization rules
+// come last so they can re-include what the deployment excludes. Both
+// files are read again when they change.
+//
+// Patterns are matched against paths relative to the department
+// directory. Clients get them in Hello
+const ORG_TEMP_FILE = ".fusiontemp"
+
+type tempRules struct {
+	data  []byte
+	rules *lib.Ignore
+}
+
+var (
+	// Set from -temp-patterns
+	tempPatternsFile *lib.IgnoreFile
+
+	// Parsed rules of each organization keyed by its name
+	orgTempRules   = make(map[string]*tempRules)
+	orgTempFiles   = make(map[string]*lib.IgnoreFile)
+	orgTempRulesMu = sync.Mutex{}
+)
+
+// Temp patterns of org as one file
+func tempPatterns(org string) []byte {
+	deployment := []byte(lib.DEFAULT_TEMP_PATTERNS)
+	if tempPatternsFile != nil {
+		deployment = tempPatternsFile.Data()
+	}
+
+	orgTempRulesMu.Lock()
+	file, ok := orgTempFiles[org]
+	if !ok {
+		file = lib.NewIgnoreFile(filepath.Join(realpath, org, ORG_TEMP_FILE))
+		orgTempFiles[org] = file
+	}
+	orgTempRulesMu.Unlock()
+
+	return bytes.Join([][]byte{deployment, file.Data()}, []byte("\n"))
+}
+
+// Rules parsed from tempPatterns(org). Parsed again only when either
+// file changed
+func tempRulesOf(org string) *lib.Ignore {
+	data := tempPatterns(org)
+
+	orgTempRulesMu.Lock()
+	defer orgTempRulesMu.Unlock()
+
+	cached, ok := orgTempRules[org]
+	if ok && bytes.Equal(cached.data, data) {
+		return cached.rules
+	}
+	cached = &tempRules{data: data, rules: lib.ParseIgnore(data)}
+	orgTempRules[org] = cached
+	return cached.rules
+}
+
+// Reports whether path, relative to realpath, is a temp file. The
+// ignore file is a dotfile but is synced like any other
+func isTempFile(path string, isDir bool) bool {
+	if path == "" || filepath.Base(path) == lib.IGNORE_FILE {
+		return false
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 {
+		return false
+	}
+	return tempRulesOf(parts[0]).Match(filepath.Join(parts[2:]...), isDir)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Drops the cached temp patterns of org before and after the test
+func forgetTempRules(t *testing.T, org string) {
+	forget := func() {
+		orgTempRulesMu.Lock()
+		delete(orgTempRules, org)
+		delete(orgTempFiles, org)
+		orgTempRulesMu.Unlock()
+	}
+	forget()
+	t.Cleanup(forget)
+}
+
+// Writes data to path with a modification time that differs from the
+// last write's, so the change is seen even within the same tick
+func writeRules(t *testing.T, path, data string, age time.Duration) {
+	t.Helper()
+	err := os.WriteFile(path, []byte(data), 0644)
+	if err == nil {
+		modTime := time.Now().Add(-age)
+		err = os.Chtimes(path, modTime, modTime)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The built-in patterns cover editor and office lock files. A
+// -temp-patterns file replaces them, the organization's file adds to
+// it, and both are read again once they change
+func TestTempFilePatterns(t *testing.T) {
+	org := testUser.OrgName
+	forgetTempRules(t, org)
+	err := os.MkdirAll(filepath.Join(realpath, org), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dept := "/" + filepath.Join(org, testUser.DeptName)
+	check := func(when string, want map[string]bool) {
+		t.Helper()
+		for name, temp := range want {
+			if got := isTempFile(dept+"/"+name, false); got != temp {
+				t.Errorf("%v: isTempFile(%v) = %v; want %v", when, name, got, temp)
+			}
+		}
+	}
+
+	check("built-in patterns", map[string]bool{
+		"~$report.docx":          true,
+		".~lock.report.odt#":     true,
+		"drawing.dwl":            true,
+		"sub/.report.docx.swp":   true,
+		"report.docx":            false,
+		"drawing.dwg":            false,
+		lib.IGNORE_FILE:          false,
+		"sub/" + lib.IGNORE_FILE: false,
+	})
+
+	deployment := filepath.Join(t.TempDir(), "temp-patterns")
+	writeRules(t, deployment, "*.bak\n", time.Hour)
+	oldFile := tempPatternsFile
+	tempPatternsFile = lib.NewIgnoreFile(deployment)
+	t.Cleanup(func() { tempPatternsFile = oldFile })
+	orgFile := filepath.Join(realpath, org, ORG_TEMP_FILE)
+	writeRules(t, orgFile, "*.cache\n!keep.bak\n", time.Hour)
+	t.Cleanup(func() { os.Remove(orgFile) })
+	check("configured patterns", map[string]bool{
+		"report.bak":    true,
+		"build.cache":   true,
+		"keep.bak":      false,
+		"~$report.docx": false,
+	})
+
+	writeRules(t, deployment, "~$*\n", 0)
+	os.Remove(orgFile)
+	check("changed patterns", map[string]bool{
+		"~$report.docx": true,
+		"report.bak":    false,
+		"build.cache":   false,
+	})
+}
+
+// Observers hear of changes to real files but not to temp files
+func TestTempFileChangesNotBroadcast(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	forgetTempRules(t, testUser.OrgName)
+	dir := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName)
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(dir, "~$report.docx"))
+		os.Remove(filepath.Join(dir, "report.docx"))
+	})
+
+	observing, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go startMainObserver(observing)
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	t.Cleanup(cancel)
+	stream, err := client.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSessions(t, 1)
+
+	// The temp file goes first, so its event would come first too
+	size := uint64(1)
+	for _, name := range []string{"~$report.docx", "report.docx"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("contents"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Setattr(ctx, &proto.SetattrRequest{Path: "/" + name, Size: &size})
+		if err != nil {
+			t.Fatalf("Setattr %v = %v", name, err)
+		}
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("no event for report.docx; %v", err)
+		}
+		if filepath.Base(event.Path) == "~$report.docx" {
+			t.Errorf("event %v broadcast for a temp file", events.EventType(event.Event))
+		}
+		if filepath.Base(event.Path) == "report.docx" {
+			break
+		}
+	}
+}
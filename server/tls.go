@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Serves the certificate in certFile/keyFile, picking up a renewed
+// pair (eg. by certbot) on the next handshake without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	_, err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Latest modification time of the cert and key files
+func (r *certReloader) lastModified() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	modTime := certInfo.ModTime()
+	if keyInfo.ModTime().After(modTime) {
+		modTime = keyInfo.ModTime()
+	}
+	return modTime, nil
+}
+
+// Loads the cert/key pair again if either file changed since the
+// last load. Returns whether a new certificate was loaded
+func (r *certReloader) reload() (bool, error) {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		// Not tried again, nor logged on every handshake, until
+		// either file changes again
+		r.modTime = modTime
+		return false, fmt.Errorf("error loading certificate %v; %v", r.certFile, err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return true, nil
+}
+
+// Used as tls.Config.GetCertificate. Checks the files on every
+// handshake; a stat is cheap next to the handshake itself
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloaded, err := r.reload()
+	if err != nil {
+		// Halfway through a renewal the key may not match the
+		// cert yet. Keep serving the old pair until it does
+		log.Printf("[GRPC] Error reloading TLS certificate; %v\n", err)
+	} else if reloaded {
+		log.Printf("[GRPC] Loaded new TLS certificate %v\n", r.certFile)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// Returns the TLS config for the gRPC server or nil if TLS is off
+func serverTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
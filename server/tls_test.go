@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a new self-signed pair to certFile and keyFile, both modified
+// at mtime
+func writeCert(t *testing.T, certFile, keyFile string, mtime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err == nil {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err == nil {
+		err = os.Chtimes(certFile, mtime, mtime)
+	}
+	if err == nil {
+		err = os.Chtimes(keyFile, mtime, mtime)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A pair that fails to load keeps the old certificate and isn't tried
+// again until the files change
+func TestCertReloaderFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, start)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := r.cert
+
+	// Halfway through a renewal
+	err = os.WriteFile(keyFile, []byte("not a key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, start.Add(time.Minute), start.Add(time.Minute))
+	if _, err := r.reload(); err == nil {
+		t.Fatal("reload of a broken key succeeded")
+	}
+	if reloaded, err := r.reload(); reloaded || err != nil {
+		t.Errorf("reload of unchanged files = %v, %v; want false, nil", reloaded, err)
+	}
+	if cert, _ := r.GetCertificate(nil); cert != old {
+		t.Error("serving a different certificate after a failed reload")
+	}
+
+	writeCert(t, certFile, keyFile, start.Add(2*time.Minute))
+	if reloaded, err := r.reload(); !reloaded || err != nil {
+		t.Errorf("reload of renewed pair = %v, %v; want true, nil", reloaded, err)
+	}
+}
+
+// Handshakes with the server listening on addr and returns the
+// certificate it presented
+func presentedCert(t *testing.T, addr string) []byte {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("handshake failed; %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Raw
+}
+
+// Once the cert files are swapped new handshakes get the new
+// certificate, without restarting the listener
+func TestCertSwapUsedByNewHandshakes(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, start)
+
+	config, err := serverTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+
+	before := presentedCert(t, listener.Addr().String())
+	if again := presentedCert(t, listener.Addr().String()); !bytes.Equal(again, before) {
+		t.Fatal("certificate changed without the files changing")
+	}
+
+	writeCert(t, certFile, keyFile, start.Add(time.Minute))
+	if after := presentedCert(t, listener.Addr().String()); bytes.Equal(after, before) {
+		t.Error("new handshake got the old certificate after the swap")
+	}
+}
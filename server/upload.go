@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Applies a stream of writes to one file. The file is opened once for
+// the whole stream rather than once per Write request, and chunks are
+// applied in the order they were sent, so large sequential writes
+// neither crawl nor get reordered. The file must already exist
+func (s FuseServer) UploadFile(stream grpc.ClientStreamingServer[proto.UploadChunk, proto.UploadResponse]) error {
+	ctx := stream.Context()
+	usersDir, err := getUsersDir(ctx)
+	if err != nil {
+		return lib.StatusError(err)
+	}
+
+	chunk, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&proto.UploadResponse{})
+	}
+	if err != nil {
+		return err
+	}
+	path := chunk.Path
+	fullpath := filepath.Join(s.path, usersDir, path)
+	log.Printf("[GRPC] UploadFile %v\n", path)
+
+	release, err := acquireGrpcHandle(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	appending := chunk.Append
+	flags := os.O_WRONLY
+	if appending {
+		flags |= os.O_APPEND
+	}
+	lockKey := filepath.Join(usersDir, path)
+	unlock := lockPath(lockKey)
+	snapshotVersion(lockKey, false)
+	file, err := openInDir(ctx, filepath.Join(s.path, usersDir), fullpath, flags, 0)
+	unlock()
+	if err != nil {
+		return writeOpenError(ctx, fullpath, path, err)
+	}
+	defer file.Close()
+
+	written := uint64(0)
+	for {
+		if chunk.Path != path {
+			return status.Errorf(codes.InvalidArgument, "upload of %v carries a chunk for %v", path, chunk.Path)
+		}
+		err = checkWrite(&proto.WriteRequest{Offset: chunk.Offset, Data: chunk.Data})
+		if err != nil {
+			return err
+		}
+
+		n, err := writeChunk(file, lockKey, appending, chunk)
+		if err != nil {
+			return lib.StatusError(err)
+		}
+		localStatfs.AddUsage(int64(n))
+		written += uint64(n)
+
+		chunk, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return stream.SendAndClose(&proto.UploadResponse{BytesWritten: written})
+}
+
+// Writes chunk to file at its offset, or at the end of the file for
+// appending streams. Holds the lock of path, relative to the server's
+// root, like a Write request would
+func writeChunk(file *os.File, lockKey string, appending bool, chunk *proto.UploadChunk) (int, error) {
+	unlock := lockPath(lockKey)
+	defer unlock()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	if appending {
+		n, err = file.Write(chunk.Data)
+	} else {
+		n, err = file.WriteAt(chunk.Data, chunk.Offset)
+	}
+	if err != nil {
+		dropTornWrite(file, info.Size(), err)
+	}
+	return n, err
+}
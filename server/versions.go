@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+)
+
+// With -versions set, the contents of a file are copied into
+// -versions-dir before a Write, replace or truncate changes it. Each
+// file's versions live in a directory named after the hash of its
+// path and are named after when they were taken. Versions don't
+// follow renames
+
+// Writes this soon after the last version was taken belong to the
+// same change, eg. the chunks of one upload, and don't take another
+const VERSION_COALESCE = time.Minute
+
+var errInvalidVersion = errors.New("invalid version id")
+
+type fileVersion struct {
+	id      string
+	size    int64
+	created time.Time
+}
+
+func versionsEnabled() bool {
+	return keepVersions > 0
+}
+
+// Directory holding the versions of path, relative to realpath
+func versionDir(path string) string {
+	digest := md5.Sum([]byte(filepath.Clean("/" + path)))
+	return filepath.Join(versionsDir, hex.EncodeToString(digest[:]))
+}
+
+// Returns the versions of path, newest first
+func listVersions(path string) ([]fileVersion, error) {
+	entries, err := os.ReadDir(versionDir(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []fileVersion{}
+	for _, entry := range entries {
+		nanos, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, fileVersion{
+			id:      entry.Name(),
+			size:    info.Size(),
+			created: time.Unix(0, nanos),
+		})
+	}
+	slices.SortFunc(versions, func(a, b fileVersion) int {
+		return b.created.Compare(a.created)
+	})
+	return versions, nil
+}
+
+// Copies the current contents of path, relative to realpath, into its
+// versions. Unless force is set nothing is taken if the last version
+// is less than VERSION_COALESCE old
+func snapshotVersion(path string, force bool) {
+	if !versionsEnabled() {
+		return
+	}
+	unlock := lockVersions(path)
+	defer unlock()
+
+	fullpath := filepath.Join(realpath, path)
+	info, err := os.Lstat(fullpath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		// Nothing worth keeping yet
+		return
+	}
+
+	versions, err := listVersions(path)
+	if err != nil {
+		log.Printf("[GRPC] Error listing versions of %v; %v\n", path, err)
+		return
+	}
+	if !force && len(versions) > 0 && time.Since(versions[0].created) < VERSION_COALESCE {
+		return
+	}
+
+	dir := versionDir(path)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Printf("[GRPC] Error creating versions directory; %v\n", err)
+		return
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 10)
+	err = copyFile(fullpath, filepath.Join(dir, id))
+	if err != nil {
+		log.Printf("[GRPC] Error saving version of %v; %v\n", path, err)
+		os.Remove(filepath.Join(dir, id))
+		return
+	}
+
+	pruneVersions(path)
+}
+
+// Removes versions of path past -versions or older than
+// -version-max-age. Called with the versions of path locked
+func pruneVersions(path string) {
+	versions, err := listVersions(path)
+	if err != nil {
+		return
+	}
+	for i, version := range versions {
+		expired := versionMaxAge > 0 && time.Since(version.created) > versionMaxAge
+		if i < keepVersions && !expired {
+			continue
+		}
+		err = os.Remove(filepath.Join(versionDir(path), version.id))
+		if err != nil {
+			log.Printf("[GRPC] Error removing old version of %v; %v\n", path, err)
+		}
+	}
+}
+
+// Puts version id of path, relative to realpath, back in place. The
+// contents it replaces are kept as a version of their own so the
+// restore can be undone. Observers are sent a MODIFY event
+func restoreVersion(path, id string) error {
+	_, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return errInvalidVersion
+	}
+	source := filepath.Join(versionDir(path), id)
+	_, err = os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	// Same as replaceFile; readers see either the old or the
+	// restored contents. The version is copied out first as keeping
+	// the current contents may prune it
+	fullpath := filepath.Join(realpath, path)
+	tmp, err := os.CreateTemp(filepath.Dir(fullpath), "."+filepath.Base(fullpath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	unlock := lockVersions(path)
+	err = copyFile(source, tmp.Name())
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	snapshotVersion(path, true)
+
+	unlock = lockVersions(path)
+	defer unlock()
+
+	mode := os.FileMode(0644)
+	owner := ""
+	info, err := os.Stat(fullpath)
+	if err == nil {
+		mode = info.Mode().Perm()
+		owner = lib.GetOwner(fullpath)
+	}
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		err = lib.SetOwner(tmp.Name(), owner)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(tmp.Name(), fullpath)
+	if err != nil {
+		return err
+	}
+	syncParent(fullpath)
+
+	info, err = os.Lstat(fullpath)
+	if err == nil {
+		go notifyObservers(events.MODIFY_FILE, fullpath, "", info.Mode())
+	}
+	return nil
+}
+
+func listVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+	if !versionsEnabled() {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "versioning is disabled on this server")
+		return
+	}
+
+	path, ok := userPath(user, r.URL.Query().Get("path"))
+	if !ok {
+		errorResponse(w, http.StatusForbidden, ERR_FORBIDDEN, "path is outside your directory")
+		return
+	}
+
+	versions, err := listVersions(path)
+	if err != nil {
+		log.Printf("Error listing versions; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error listing versions")
+		return
+	}
+	response := []map[string]any{}
+	for _, version := range versions {
+		response = append(response, map[string]any{
+			"id":         version.id,
+			"size":       version.size,
+			"created_at": version.created,
+		})
+	}
+	jsonResponse(w, http.StatusOK, response)
+}
+
+type restoreVersionRequest struct {
+	// File relative to the user's department directory
+	Path string `json:"path"`
+	Id   string `json:"id"`
+}
+
+func restoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+	if !versionsEnabled() {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "versioning is disabled on this server")
+		return
+	}
+
+	var req restoreVersionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Id == "" {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "path and id fields required")
+		return
+	}
+	path, ok := userPath(user, req.Path)
+	if !ok {
+		errorResponse(w, http.StatusForbidden, ERR_FORBIDDEN, "path is outside your directory")
+		return
+	}
+
+	unlock := lockPath(path)
+	err = restoreVersion(path, req.Id)
+	unlock()
+	switch {
+	case errors.Is(err, errInvalidVersion):
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+		return
+	case os.IsNotExist(err):
+		errMessage := fmt.Sprintf("Version '%v' of '%v' NOT found", req.Id, req.Path)
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, errMessage)
+		return
+	case err != nil:
+		log.Printf("Error restoring version; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error restoring version")
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "version restored"})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/fusion/lib/events"
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Keeps up to n versions in a temporary -versions-dir
+func useTestVersions(t *testing.T, n int) {
+	oldKeep, oldDir := keepVersions, versionsDir
+	keepVersions, versionsDir = n, t.TempDir()
+	t.Cleanup(func() { keepVersions, versionsDir = oldKeep, oldDir })
+}
+
+// Taking a version of one file doesn't wait on another file's versions
+func TestSnapshotVersionLocksPerPath(t *testing.T) {
+	useTestVersions(t, 2)
+	name := filepath.Base(t.Name())
+	err := os.WriteFile(filepath.Join(realpath, name), []byte("contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(realpath, name)) })
+
+	unlock := lockVersions("/other")
+	defer unlock()
+
+	done := make(chan struct{})
+	go func() {
+		snapshotVersion(name, true)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("snapshotVersion waited on the versions of another file")
+	}
+
+	versions, err := listVersions(name)
+	if err != nil || len(versions) != 1 {
+		t.Errorf("versions = %v, %v; want one", versions, err)
+	}
+}
+
+// Moves the versions of path back in time as if they were taken before
+// the last VERSION_COALESCE
+func ageVersions(t *testing.T, path string) {
+	t.Helper()
+	versions, err := listVersions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Oldest first so a renamed version never lands on another
+	for i := len(versions) - 1; i >= 0; i-- {
+		id := versions[i].id
+		older := strconv.FormatInt(versions[i].created.Add(-2*VERSION_COALESCE).UnixNano(), 10)
+		err := os.Rename(filepath.Join(versionDir(path), id), filepath.Join(versionDir(path), older))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Each write that changes the file keeps what it had before, up to
+// -versions of them, and restoring one puts it back and tells
+// observers
+func TestVersionsListedAndRestored(t *testing.T) {
+	useTestVersions(t, 3)
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	name := "/" + filepath.Base(t.Name())
+	path := filepath.Join(testUser.OrgName, testUser.DeptName, name)
+	err := os.WriteFile(filepath.Join(realpath, path), []byte("v1"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(realpath, path)) })
+
+	write := func(contents string) {
+		t.Helper()
+		_, err := client.Write(ctx, &proto.WriteRequest{Path: name, Data: []byte(contents)})
+		if err != nil {
+			t.Fatalf("Write %v = %v", contents, err)
+		}
+	}
+	write("v2")
+	write("v2") // the same change; no new version
+	for _, contents := range []string{"v3", "v4", "v5"} {
+		ageVersions(t, path)
+		write(contents)
+	}
+
+	list, err := client.ListVersions(ctx, &proto.DirEntry{Path: name})
+	if err != nil {
+		t.Fatalf("ListVersions = %v", err)
+	}
+	got := []string{}
+	for _, version := range list.Versions {
+		data, _ := os.ReadFile(filepath.Join(versionDir(path), version.Id))
+		got = append(got, string(data))
+	}
+	if want := []string{"v4", "v3", "v2"}; !slices.Equal(got, want) {
+		t.Fatalf("versions = %q; want %q, newest first", got, want)
+	}
+
+	observing, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go startMainObserver(observing)
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	t.Cleanup(cancel)
+	stream, err := client.ObserveFileChanges(streamCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSessions(t, 1)
+
+	oldest := list.Versions[len(list.Versions)-1]
+	attr, err := client.RestoreVersion(ctx, &proto.RestoreVersionRequest{Path: name, Id: oldest.Id})
+	if err != nil {
+		t.Fatalf("RestoreVersion = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(realpath, path)); string(data) != "v2" || attr.Size != 2 {
+		t.Errorf("file = %q, size %v after restore; want %q", data, attr.Size, "v2")
+	}
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("no MODIFY_FILE event for the restore; %v", err)
+		}
+		if event.Event == uint32(events.MODIFY_FILE) && event.Path == name {
+			break
+		}
+	}
+
+	// What the restore replaced is a version of its own
+	list, err = client.ListVersions(ctx, &proto.DirEntry{Path: name})
+	if err != nil || len(list.Versions) == 0 {
+		t.Fatalf("ListVersions = %v, %v", list, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(versionDir(path), list.Versions[0].Id)); string(data) != "v5" {
+		t.Errorf("newest version = %q after restore; want %q", data, "v5")
+	}
+
+	_, err = client.RestoreVersion(ctx, &proto.RestoreVersionRequest{Path: name, Id: "../../etc/passwd"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RestoreVersion of a bad id = %v; want InvalidArgument", err)
+	}
+}
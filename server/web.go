@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
-	"net/smtp"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,23 +21,69 @@ import (
 )
 
 var (
-	users               *db.UserModel          = db.NewUserModel()
-	passwordResetTokens *db.PasswordResetModel = db.NewPasswordResetModel()
-	organizations       *db.OrganizationModel  = db.NewOrganizationModel()
+	users               *db.UserModel
+	passwordResetTokens *db.PasswordResetModel
+	organizations       *db.OrganizationModel
+	invites             *db.InviteModel
 )
 
+// Models hold the connection db.Open makes, so they are created after it
+func openModels() {
+	users = db.NewUserModel()
+	passwordResetTokens = db.NewPasswordResetModel()
+	organizations = db.NewOrganizationModel()
+	invites = db.NewInviteModel()
+	emailChanges = db.NewEmailChangeModel()
+	shareLinks = db.NewShareLinkModel()
+}
+
 func jsonResponse(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
+// Machine readable error codes sent to clients
+const (
+	ERR_INVALID_REQUEST     = "invalid_request"
+	ERR_INVALID_CREDENTIALS = "invalid_credentials"
+	ERR_INVALID_OTP         = "invalid_otp"
+	ERR_INVALID_INVITE      = "invalid_invite"
+	ERR_UNAUTHORIZED        = "unauthorized"
+	ERR_FORBIDDEN           = "forbidden"
+	ERR_NOT_FOUND           = "not_found"
+	ERR_CONFLICT            = "conflict"
+	ERR_INTERNAL            = "internal_error"
+)
+
+// Body of every error response. message is meant for people and
+// never carries internal error text; that is logged instead
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Id of the failed request; see requestIdMiddleware
+	RequestId string `json:"request_id,omitempty"`
+}
+
+func errorResponse(w http.ResponseWriter, status int, code string, message string) {
+	jsonResponse(w, status, errorBody{
+		Code:      code,
+		Message:   message,
+		RequestId: w.Header().Get(lib.REQUEST_ID_HEADER),
+	})
+}
+
 type registerRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	OrgName  string `json:"org_name"`
 	DeptName string `json:"dept_name"`
+
+	// Proof the user may join the organization; one is required
+	InviteToken string `json:"invite_token"`
+	OrgPassword string `json:"org_password"`
 }
 
 func (req registerRequest) Validate() error {
@@ -53,9 +99,18 @@ func (req registerRequest) Validate() error {
 	if err := lib.ValidateName("orgName", req.OrgName); err != nil {
 		return err
 	}
+	if err := lib.ValidatePathComponent("orgName", req.OrgName); err != nil {
+		return err
+	}
 	if err := lib.ValidateName("deptName", req.DeptName); err != nil {
 		return err
 	}
+	if err := lib.ValidatePathComponent("deptName", req.DeptName); err != nil {
+		return err
+	}
+	if strings.TrimSpace(req.InviteToken) == "" && req.OrgPassword == "" {
+		return fmt.Errorf("invite_token or org_password required to join an organization")
+	}
 	return nil
 }
 
@@ -64,19 +119,19 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&req)
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "username, email, password, org_name, dept_name fields required"})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "username, email, password, org_name, dept_name fields required")
 		return
 	}
 
 	err = req.Validate()
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
 	ok, _ := users.Exists(req.Email)
 	if ok {
-		jsonResponse(w, http.StatusConflict, map[string]string{"message": "user account already exists"})
+		errorResponse(w, http.StatusConflict, ERR_CONFLICT, "user account already exists")
 		return
 	}
 
@@ -84,10 +139,41 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	baseDir := filepath.Join(realpath, req.OrgName, req.DeptName)
 	if !dirExists(baseDir) {
 		errMessage := fmt.Sprintf("Organization '%v' with department '%v' NOT found", req.OrgName, req.DeptName)
-		jsonResponse(w, http.StatusNotFound, map[string]string{"message": errMessage})
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, errMessage)
 		return
 	}
 
+	// Existing directories alone don't let anyone in; the user must
+	// hold an invite or know the org password
+	org, err := organizations.Get(req.OrgName)
+	if err == sql.ErrNoRows {
+		errMessage := fmt.Sprintf("Organization '%v' NOT found", req.OrgName)
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, errMessage)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching organization; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating user account")
+		return
+	}
+
+	// Invites are checked when they are redeemed below
+	if req.InviteToken == "" {
+		match, outdated := db.CheckPassword(org.OrgPassword, req.OrgPassword)
+		if !match {
+			errorResponse(w, http.StatusForbidden, ERR_INVALID_CREDENTIALS, "invalid org_password")
+			return
+		}
+		if outdated {
+			go func() {
+				_, err := organizations.ChangePassword(org.Name, req.OrgPassword)
+				if err != nil {
+					log.Printf("Error re-hashing organization password; %v\n", err)
+				}
+			}()
+		}
+	}
+
 	user, err := db.NewUser(
 		req.Username,
 		req.Email,
@@ -96,17 +182,30 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		req.DeptName,
 	)
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
-	_, err = users.Insert(*user)
+	if req.InviteToken != "" {
+		err = invites.Redeem(req.InviteToken, *user)
+	} else {
+		_, err = users.Insert(*user)
+	}
+	if err == db.ErrInvalidInvite {
+		errorResponse(w, http.StatusForbidden, ERR_INVALID_INVITE, "invalid or expired invite_token")
+		return
+	}
 	if err != nil {
 		log.Printf("Error creating user account; %v\n", err)
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": "error creating user account"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating user account")
 		return
 	}
 
+	err = provisionPersonalDir(user)
+	if err != nil {
+		log.Printf("Error creating personal directory of %v; %v\n", user.Email, err)
+	}
+
 	jsonResponse(w, http.StatusCreated, map[string]string{"message": "user registered"})
 }
 
@@ -119,38 +218,82 @@ func (req loginRequest) Validate() error {
 	return lib.ValidateEmail(req.Email)
 }
 
+// Replaces a password hash made with a rotated out SECRET_KEY with
+// one made with the current key
+func rehashPassword(email, password string) {
+	_, err := users.ChangePassword(email, password)
+	if err != nil {
+		log.Printf("Error re-hashing password; %v\n", err)
+	}
+}
+
+// Makes a freshly generated SECRET_KEY current. Tokens signed with the
+// key it replaces keep working; see db.Keyset
+func rotateKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	keyset, err := db.RotateKeys()
+	if errors.Is(err, lib.ErrSecretsReadOnly) {
+		errorResponse(w, http.StatusConflict, ERR_CONFLICT, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating secret key; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error rotating secret key")
+		return
+	}
+	log.Printf("Secret key rotated by %v; %v previous keys still accepted\n", user.Email, len(keyset.Previous))
+
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"message":       "secret key rotated",
+		"previous_keys": len(keyset.Previous),
+	})
+}
+
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var req loginRequest
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&req)
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "username, password fields required"})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "username, password fields required")
 		return
 	}
 
 	err = req.Validate()
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
 	user, err := users.Get(req.Email)
+	if err == sql.ErrNoRows {
+		errorResponse(w, http.StatusUnauthorized, ERR_INVALID_CREDENTIALS, "invalid username or password")
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching user account; %v\n", err)
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "invalid username or password"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error logging in user")
 		return
 	}
 
-	passwordMatch := auth.VerifyPassword(user.Password, req.Password)
+	passwordMatch, outdated := auth.VerifyPassword(user.Password, req.Password)
 	if !passwordMatch {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "invalid username or password"})
+		errorResponse(w, http.StatusUnauthorized, ERR_INVALID_CREDENTIALS, "invalid username or password")
 		return
 	}
+	if outdated {
+		go rehashPassword(user.Email, req.Password)
+	}
+
+	err = provisionPersonalDir(user)
+	if err != nil {
+		log.Printf("Error creating personal directory of %v; %v\n", user.Email, err)
+	}
 
 	accessToken, err := auth.GenerateToken(*user)
 	if err != nil {
 		log.Printf("Error generating JWT; %v\n", err)
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": "error logging in user"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error logging in user")
 		return
 	}
 
@@ -168,8 +311,18 @@ type createOrgRequest struct {
 }
 
 func (req createOrgRequest) Validate() error {
-	// No need to validate department name
-	return lib.ValidateName("orgName", req.OrgName)
+	if err := lib.ValidateName("orgName", req.OrgName); err != nil {
+		return err
+	}
+	if err := lib.ValidatePathComponent("orgName", req.OrgName); err != nil {
+		return err
+	}
+
+	// Department is optional but still ends up on disk
+	if req.DeptName != "" {
+		return lib.ValidatePathComponent("deptName", req.DeptName)
+	}
+	return nil
 }
 
 func createOrgHandler(w http.ResponseWriter, r *http.Request) {
@@ -178,7 +331,7 @@ func createOrgHandler(w http.ResponseWriter, r *http.Request) {
 	user, ok := userObj.(*db.User)
 	if !ok {
 		log.Println("Error extracting user object from context")
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": "error fetching current logged in user"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error fetching current logged in user")
 		return
 	}
 
@@ -186,13 +339,13 @@ func createOrgHandler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&req)
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "org_name and dept_name fields required"})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "org_name and dept_name fields required")
 		return
 	}
 
 	err = req.Validate()
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "org_name field required"})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
@@ -205,8 +358,13 @@ func createOrgHandler(w http.ResponseWriter, r *http.Request) {
 		user.Email,
 		req.OrgPassword,
 	)
+	if err == db.ErrOrgExists {
+		errorResponse(w, http.StatusConflict, ERR_CONFLICT, "organization already exists")
+		return
+	}
 	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		log.Printf("Error creating organization directory; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating organization")
 		return
 	}
 
@@ -216,52 +374,40 @@ func createOrgHandler(w http.ResponseWriter, r *http.Request) {
 		// Rollback directory creation
 		os.RemoveAll(orgDir)
 
-		log.Printf("error creating organization; %v\n", err)
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": "error creating organization"})
+		log.Printf("Error creating organization; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating organization")
 		return
 	}
 
 	jsonResponse(w, http.StatusOK, map[string]string{"message": "organization and department directory created successfully"})
 }
 
+// Emails a password reset OTP to the user
 func sendEmail(email, otp string) error {
-	err := lib.LoadEnv()
+	sender, err := newEmailSender()
 	if err != nil {
-		return fmt.Errorf("error loading env file; %v", err)
-	}
-
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	from := os.Getenv("SMTP_EMAIL")
-	password := os.Getenv("SMTP_PASSWORD") // App Password (not actual Gmail password)
-	to := []string{email}
-
-	message := []byte(
-		"Subject: Reset your password\r\n" +
-			"MIME-version: 1.0;\r\n" +
-			"Content-Type: text/html; charset=\"UTF-8\";\r\n" +
-			"\r\n" +
-			"<html>" +
-			"<body style='font-family: Arial, sans-serif;'>" +
-			"<h2>Password Reset Request</h2>" +
-			"<p>Hello, there</p>" +
-			"<p>We received a request to reset your password on your File Manager account. Use the following One-Time Password (OTP) to continue:</p>" +
-			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>" + otp + "</div>" +
-			"<p>This code will expire in <b>10 minutes</b>.</p>" +
-			"<p>If you didn't request a password reset, you can safely ignore this email.</p>" +
-			"<br>" +
-			"<p>Best regards,<br>File Manager</p>" +
-			"</body>" +
-			"</html>",
-	)
-
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-	addr := net.JoinHostPort(smtpHost, smtpPort)
-	err = smtp.SendMail(addr, auth, from, to, message)
-	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	return nil
+
+	subject, html := passwordResetEmail(otp)
+	return sender.Send(email, subject, html)
+}
+
+func passwordResetEmail(otp string) (string, string) {
+	html := "<html>" +
+		"<body style='font-family: Arial, sans-serif;'>" +
+		"<h2>Password Reset Request</h2>" +
+		"<p>Hello, there</p>" +
+		"<p>We received a request to reset your password on your File Manager account. Use the following One-Time Password (OTP) to continue:</p>" +
+		"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>" + otp + "</div>" +
+		"<p>This code will expire in <b>10 minutes</b>.</p>" +
+		"<p>If you didn't request a password reset, you can safely ignore this email.</p>" +
+		"<br>" +
+		"<p>Best regards,<br>File Manager</p>" +
+		"</body>" +
+		"</html>"
+
+	return "Reset your password", html
 }
 
 type forgotPasswordRequest struct {
@@ -280,14 +426,14 @@ func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&req)
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "email field required"})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "email field required")
 		return
 	}
 
 	// validate email
 	err = req.Validate()
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
@@ -306,7 +452,7 @@ func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	_, err = passwordResetTokens.Insert(*token)
 	if err != nil {
 		log.Printf("Error saving password_reset_token; %v\n", err)
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": "error creating password reset token"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating password reset token")
 		return
 	}
 
@@ -342,34 +488,43 @@ func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&req)
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "email, otp and new_password fields required"})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "email, otp and new_password fields required")
 		return
 	}
 
 	err = req.Validate()
 	if err != nil {
-		jsonResponse(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
 		return
 	}
 
 	token, err := passwordResetTokens.Get(req.Email, req.OTP)
+	if err == sql.ErrNoRows {
+		errorResponse(w, http.StatusNotFound, ERR_INVALID_OTP, "invalid or expired OTP")
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching password_reset_token; %v\n", err)
-		jsonResponse(w, http.StatusNotFound, map[string]string{"message": "invalid or expired OTP"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error resetting password")
 		return
 	}
 
 	// verify database otp matches one passed in by user
 	if token.OTP != req.OTP {
-		jsonResponse(w, http.StatusNotFound, map[string]string{"message": "invalid or expired OTP"})
+		errorResponse(w, http.StatusNotFound, ERR_INVALID_OTP, "invalid or expired OTP")
 		return
 	}
 
 	// change users password
-	_, err = users.ChangePassword(req.Email, req.NewPassword)
+	count, err := users.ChangePassword(req.Email, req.NewPassword)
 	if err != nil {
 		log.Printf("Error changing user password; %v\n", err)
-		jsonResponse(w, http.StatusInternalServerError, map[string]string{"message": "invalid or expired OTP"})
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error resetting password")
+		return
+	}
+	if count == 0 {
+		// Account was deleted after the OTP was issued
+		errorResponse(w, http.StatusNotFound, ERR_INVALID_OTP, "invalid or expired OTP")
 		return
 	}
 
@@ -379,19 +534,161 @@ func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, http.StatusOK, map[string]string{"message": "Password reset successful"})
 }
 
+// Periodically purges password reset tokens that expired unused.
+// Tokens are only deleted on a successful reset otherwise
+func cleanupResetTokens(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := passwordResetTokens.DeleteExpired()
+		if err != nil {
+			log.Printf("Error removing expired password reset tokens; %v\n", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Removed %v expired password reset tokens\n", removed)
+		}
+	}
+}
+
+// Only lets through the admin of the logged in user's organization.
+// Must run after requireAuthMiddleware
+func requireAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+		if !ok {
+			errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error fetching current logged in user")
+			return
+		}
+
+		org, err := organizations.Get(user.OrgName)
+		if err != nil || org.AdminEmail != user.Email {
+			errorResponse(w, http.StatusForbidden, ERR_FORBIDDEN, "access to this route requires organization admin")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// How long invites last unless the admin asks otherwise
+const DEFAULT_INVITE_DURATION = 72 * time.Hour
+
+type createInviteRequest struct {
+	DeptName       string `json:"dept_name"`
+	ExpiresInHours int    `json:"expires_in_hours"`
+}
+
+func createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	var req createInviteRequest
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "invalid request body")
+			return
+		}
+	}
+
+	if req.DeptName != "" {
+		err := lib.ValidatePathComponent("deptName", req.DeptName)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+		if !dirExists(filepath.Join(realpath, user.OrgName, req.DeptName)) {
+			errMessage := fmt.Sprintf("Department '%v' NOT found", req.DeptName)
+			errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, errMessage)
+			return
+		}
+	}
+
+	duration := DEFAULT_INVITE_DURATION
+	if req.ExpiresInHours > 0 {
+		duration = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	invite := db.NewInviteToken(user.OrgName, req.DeptName, duration)
+	_, err := invites.Insert(*invite)
+	if err != nil {
+		log.Printf("Error creating invite; %v\n", err)
+		errorResponse(w, http.StatusInternalServerError, ERR_INTERNAL, "error creating invite")
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, map[string]any{
+		"invite_token": invite.Token,
+		"org_name":     invite.OrgName,
+		"dept_name":    invite.DeptName,
+		"expires_at":   invite.ExpiresAt,
+	})
+}
+
+// Reports how many inodes the server's FUSE mount is holding on to
+func liveInodesHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"live_inodes": lib.LiveInodes.Load(),
+	})
+}
+
+func listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	sessions := listSessions(user.OrgName)
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"sessions":    sessions,
+		"clients":     len(sessions),
+		"max_clients": maxClientsFor(user.OrgName),
+	})
+}
+
+type terminateSessionRequest struct {
+	Id    uint64 `json:"id"`
+	Email string `json:"email"`
+}
+
+func terminateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(auth.USER_CTX_KEY).(*db.User)
+
+	var req terminateSessionRequest
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&req)
+	if err != nil || (req.Id == 0 && strings.TrimSpace(req.Email) == "") {
+		errorResponse(w, http.StatusBadRequest, ERR_INVALID_REQUEST, "id or email field required")
+		return
+	}
+
+	count := terminateSessions(user.OrgName, req.Id, req.Email)
+	if count == 0 {
+		errorResponse(w, http.StatusNotFound, ERR_NOT_FOUND, "no matching session found")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"message":    "sessions terminated",
+		"terminated": count,
+	})
+}
+
 func requireAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		fields := strings.Split(authHeader, " ")
 		if len(fields) != 2 {
-			jsonResponse(w, http.StatusBadRequest, map[string]string{"message": "invalid Authorization header format"})
+			errorResponse(w, http.StatusUnauthorized, ERR_UNAUTHORIZED, "invalid Authorization header format")
 			return
 		}
 
 		token := fields[1]
 		var user db.User
-		if !auth.ValidToken(token, &user) {
-			jsonResponse(w, http.StatusUnauthorized, map[string]string{"message": "access to this route requried user login."})
+		if !auth.ValidUserToken(token, &user) {
+			errorResponse(w, http.StatusUnauthorized, ERR_UNAUTHORIZED, "access to this route requires user login")
 			return
 		}
 
@@ -407,17 +704,47 @@ func requireAuthMiddleware(next http.Handler) http.Handler {
 func startWebServer(doneChan chan<- error) {
 	r := chi.NewRouter()
 
+	r.Use(requestIdMiddleware)
 	r.Use(middleware.Logger)
 	r.Post("/auth/register", registerHandler)
 	r.Post("/auth/login", loginHandler)
 	r.Post("/auth/forgot-password", forgotPasswordHandler)
 	r.Post("/auth/reset-password", resetPasswordHandler)
+	r.Get("/share/{token}", shareHandler)
 
 	r.Group(func(r chi.Router) {
 		r.Use(requireAuthMiddleware)
 
 		// Anyone can create an organization so long as they are logged in
 		r.Get("/create-organization", createOrgHandler)
+
+		r.Post("/share-links", createShareLinkHandler)
+		r.Delete("/share-links/{token}", revokeShareLinkHandler)
+
+		r.Get("/archive/*", archiveHandler)
+
+		r.Get("/versions", listVersionsHandler)
+		r.Post("/versions/restore", restoreVersionHandler)
+
+		r.Get("/me", getProfileHandler)
+		r.Put("/me", updateProfileHandler)
+		r.Post("/me/confirm-email", confirmEmailHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(requireAuthMiddleware)
+		r.Use(requireAdminMiddleware)
+
+		r.Get("/sessions", listSessionsHandler)
+		r.Post("/sessions/terminate", terminateSessionHandler)
+		r.Post("/invites", createInviteHandler)
+		r.Get("/debug/inodes", liveInodesHandler)
+		r.Get("/debug/handles", openHandlesHandler)
+		r.Get("/config/export", exportConfigHandler)
+		r.Post("/config/import", importConfigHandler)
+		r.Get("/maintenance", getMaintenanceHandler)
+		r.Post("/maintenance", setMaintenanceHandler)
+		r.Post("/keys/rotate", rotateKeysHandler)
 	})
 
 	address := "0.0.0.0:5000"
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib"
+	"github.com/caleb-mwasikira/fusion/server/auth"
+	"github.com/caleb-mwasikira/fusion/server/db"
+)
+
+// Org and department names end up on disk and may not escape the
+// directory they are joined to
+func TestOrgRequestsRejectUnsafeNames(t *testing.T) {
+	register := registerRequest{
+		Username:    "alice",
+		Email:       "alice@example.com",
+		Password:    "password123",
+		OrgName:     "acme",
+		DeptName:    "sales",
+		OrgPassword: "secret",
+	}
+	if err := register.Validate(); err != nil {
+		t.Fatalf("valid registration refused; %v", err)
+	}
+	create := createOrgRequest{OrgName: "acme", DeptName: "sales"}
+	if err := create.Validate(); err != nil {
+		t.Fatalf("valid organization refused; %v", err)
+	}
+
+	for _, name := range []string{"../../etc", "acme/../..", "acme\x00", "....", " acme"} {
+		req := register
+		req.OrgName = name
+		if req.Validate() == nil {
+			t.Errorf("registration with org %q accepted", name)
+		}
+		req = register
+		req.DeptName = name
+		if req.Validate() == nil {
+			t.Errorf("registration with department %q accepted", name)
+		}
+		if (createOrgRequest{OrgName: name}).Validate() == nil {
+			t.Errorf("organization %q accepted", name)
+		}
+		if (createOrgRequest{OrgName: "acme", DeptName: name}).Validate() == nil {
+			t.Errorf("department %q accepted", name)
+		}
+	}
+}
+
+// Every handler answers a bad request with the status that fits and
+// an errorBody, never with internal error text
+func TestWebErrors(t *testing.T) {
+	passthrough := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, map[string]string{"message": "ok"})
+	})
+	tests := []struct {
+		name    string
+		handler http.Handler
+		user    *db.User
+		auth    string
+		body    string
+		status  int
+		code    string
+	}{
+		{"register malformed", http.HandlerFunc(registerHandler), nil, "", "{", http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"register bad email", http.HandlerFunc(registerHandler), nil, "", `{"username": "bob", "email": "bob", "password": "password123", "org_name": "org", "dept_name": "dept", "org_password": "secret"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"register without invite", http.HandlerFunc(registerHandler), nil, "", `{"username": "bob", "email": "bob@example.com", "password": "password123", "org_name": "org", "dept_name": "dept"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"login malformed", http.HandlerFunc(loginHandler), nil, "", "{", http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"login bad email", http.HandlerFunc(loginHandler), nil, "", `{"email": "bob"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"create org without user", http.HandlerFunc(createOrgHandler), nil, "", `{}`, http.StatusInternalServerError, ERR_INTERNAL},
+		{"create org malformed", http.HandlerFunc(createOrgHandler), &testUser, "", "{", http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"create org unsafe name", http.HandlerFunc(createOrgHandler), &testUser, "", `{"org_name": "../org", "dept_name": "dept"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"forgot password malformed", http.HandlerFunc(forgotPasswordHandler), nil, "", "{", http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"forgot password bad email", http.HandlerFunc(forgotPasswordHandler), nil, "", `{"email": "bob"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"reset password malformed", http.HandlerFunc(resetPasswordHandler), nil, "", "{", http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"reset password weak password", http.HandlerFunc(resetPasswordHandler), nil, "", `{"email": "bob@example.com", "otp": "123456", "new_password": "short"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"invite malformed", http.HandlerFunc(createInviteHandler), &testUser, "", "{", http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"invite unsafe department", http.HandlerFunc(createInviteHandler), &testUser, "", `{"dept_name": "../dept"}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"invite missing department", http.HandlerFunc(createInviteHandler), &testUser, "", `{"dept_name": "missing"}`, http.StatusNotFound, ERR_NOT_FOUND},
+		{"terminate without session", http.HandlerFunc(terminateSessionHandler), &testUser, "", `{}`, http.StatusBadRequest, ERR_INVALID_REQUEST},
+		{"terminate unknown session", http.HandlerFunc(terminateSessionHandler), &testUser, "", `{"email": "nobody@example.com"}`, http.StatusNotFound, ERR_NOT_FOUND},
+		{"no authorization", requireAuthMiddleware(passthrough), nil, "", "", http.StatusUnauthorized, ERR_UNAUTHORIZED},
+		{"bad token", requireAuthMiddleware(passthrough), nil, "Bearer junk", "", http.StatusUnauthorized, ERR_UNAUTHORIZED},
+		{"admin without user", requireAdminMiddleware(passthrough), nil, "", "", http.StatusInternalServerError, ERR_INTERNAL},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+		if test.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), auth.USER_CTX_KEY, test.user))
+		}
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		requestIdMiddleware(test.handler).ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%v: status = %v; want %v", test.name, w.Code, test.status)
+		}
+		body := map[string]string{}
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil {
+			t.Errorf("%v: body %q is not an errorBody; %v", test.name, w.Body, err)
+			continue
+		}
+		keys := []string{}
+		for key := range body {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, []string{"code", "message", "request_id"}) {
+			t.Errorf("%v: body has fields %v; want code, message and request_id", test.name, keys)
+		}
+		if body["code"] != test.code {
+			t.Errorf("%v: code = %q; want %q", test.name, body["code"], test.code)
+		}
+		if body["request_id"] != w.Header().Get(lib.REQUEST_ID_HEADER) {
+			t.Errorf("%v: request_id = %q; want the response's %q", test.name, body["request_id"], w.Header().Get(lib.REQUEST_ID_HEADER))
+		}
+		if strings.Contains(body["message"], "invalid character") || strings.Contains(body["message"], "EOF") {
+			t.Errorf("%v: message %q carries the decoder's error", test.name, body["message"])
+		}
+	}
+}
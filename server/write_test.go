@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/caleb-mwasikira/fusion/lib/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Writes with a negative offset, more data than -max-write-size or an
+// offset past -max-file-size are refused before the file is touched
+func TestWriteLimits(t *testing.T) {
+	client, ctx := newTestClient(t, FuseServer{path: mountpoint}, testUser)
+	path := filepath.Join(mountpoint, testUser.OrgName, testUser.DeptName, "limited")
+	err := os.WriteFile(path, []byte("limited"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+	oldMax := maxFileSize
+	maxFileSize = 1
+	t.Cleanup(func() { maxFileSize = oldMax })
+
+	tests := []struct {
+		name   string
+		offset int64
+		size   int
+		want   codes.Code
+	}{
+		{"negative offset", -1, 1, codes.InvalidArgument},
+		{"oversized payload", 0, maxWriteSize*1024*1024 + 1, codes.ResourceExhausted},
+		{"past the file size limit", 1024 * 1024 * 1024, 1, codes.ResourceExhausted},
+		{"ending past the file size limit", 1024*1024*1024 - 1, 2, codes.ResourceExhausted},
+		{"largest payload", 0, maxWriteSize * 1024 * 1024, codes.OK},
+		{"up to the file size limit", 1024*1024*1024 - 1, 1, codes.OK},
+	}
+	for _, test := range tests {
+		_, err := client.Write(ctx, &proto.WriteRequest{Path: "/limited", Offset: test.offset, Data: make([]byte, test.size)})
+		if status.Code(err) != test.want {
+			t.Errorf("write with %v = %v; want %v", test.name, err, test.want)
+		}
+		if test.want == codes.OK {
+			continue
+		}
+		if got, _ := os.ReadFile(path); string(got) != "limited" {
+			t.Errorf("file after refused write with %v = %v bytes; want it untouched", test.name, len(got))
+		}
+	}
+}
+
+// A write cut short by a full disk is cut back off the file; other
+// failed writes leave it alone
+func TestDropTornWrite(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int64
+	}{
+		{&os.PathError{Op: "write", Path: "torn", Err: syscall.ENOSPC}, 4},
+		{&os.PathError{Op: "write", Path: "torn", Err: syscall.EDQUOT}, 4},
+		{&os.PathError{Op: "write", Path: "torn", Err: syscall.EIO}, 10},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "torn")
+		file, err := os.Create(path)
+		if err == nil {
+			_, err = file.WriteString("0123456789")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropTornWrite(file, 4, test.err)
+		file.Close()
+		if info, _ := os.Stat(path); info.Size() != test.want {
+			t.Errorf("size after a write failing with %v = %v; want %v", test.err, info.Size(), test.want)
+		}
+	}
+}